@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// generatorConfig shapes the synthetic series
+type generatorConfig struct {
+	// Metrics is the number of metric families
+	Metrics int
+	// Series is the number of series of each family
+	Series int
+	// Churn is the fraction of the series of each family replaced by new
+	// ones every interval
+	Churn float64
+	// Histograms is the fraction of families that are histograms
+	Histograms float64
+	// Buckets is the number of buckets of each histogram, besides +Inf
+	Buckets int
+}
+
+// family is a metric family and the state of its series
+type family struct {
+	name      string
+	histogram bool
+	// ids identifies the series of each slot; churn gives a slot a new id
+	ids    []int
+	counts []float64
+	sums   []float64
+}
+
+// generator produces the samples of every series once per interval
+type generator struct {
+	cfg      generatorConfig
+	families []*family
+	bounds   []string
+	nextID   int
+	// churned is the slot the next churn starts at
+	churned int
+	rng     *rand.Rand
+}
+
+// newGenerator creates the series of every family
+func newGenerator(cfg generatorConfig, seed int64) *generator {
+	g := &generator{cfg: cfg, rng: rand.New(rand.NewSource(seed))}
+
+	histograms := int(float64(cfg.Metrics)*cfg.Histograms + 0.5)
+	for i := 0; i < cfg.Metrics; i++ {
+		f := &family{
+			histogram: i < histograms,
+			ids:       make([]int, cfg.Series),
+			counts:    make([]float64, cfg.Series),
+			sums:      make([]float64, cfg.Series),
+		}
+		if f.histogram {
+			f.name = fmt.Sprintf("loadgen_histogram_%04d_seconds", i)
+		} else {
+			f.name = fmt.Sprintf("loadgen_counter_%04d_total", i)
+		}
+		for slot := range f.ids {
+			f.ids[slot] = g.nextID
+			g.nextID++
+		}
+		g.families = append(g.families, f)
+	}
+
+	// Exponential bucket bounds starting at 5ms
+	bound := 0.005
+	for i := 0; i < cfg.Buckets; i++ {
+		g.bounds = append(g.bounds, strconv.FormatFloat(bound, 'g', -1, 64))
+		bound *= 2
+	}
+	g.bounds = append(g.bounds, "+Inf")
+	return g
+}
+
+// seriesPerInterval is the number of samples generated every interval
+func (g *generator) seriesPerInterval() int {
+	total := 0
+	for _, f := range g.families {
+		if f.histogram {
+			// The buckets, including +Inf, plus _sum and _count
+			total += len(f.ids) * (len(g.bounds) + 2)
+		} else {
+			total += len(f.ids)
+		}
+	}
+	return total
+}
+
+// churn replaces a fraction of the series of each family with new ones,
+// which start from zero like a restarted target
+func (g *generator) churn() {
+	if g.cfg.Series == 0 {
+		return
+	}
+	replaced := int(float64(g.cfg.Series)*g.cfg.Churn + 0.5)
+	for i := 0; i < replaced; i++ {
+		slot := (g.churned + i) % g.cfg.Series
+		for _, f := range g.families {
+			f.ids[slot] = g.nextID
+			f.counts[slot], f.sums[slot] = 0, 0
+			g.nextID++
+		}
+	}
+	g.churned = (g.churned + replaced) % g.cfg.Series
+}
+
+// next advances every series by one interval and returns their samples at
+// the timestamp, in batches of at most size series
+func (g *generator) next(timestamp int64, size int) [][]prompb.TimeSeries {
+	var batches [][]prompb.TimeSeries
+	batch := make([]prompb.TimeSeries, 0, size)
+	add := func(value float64, labels ...prompb.Label) {
+		batch = append(batch, prompb.TimeSeries{
+			Labels:  labels,
+			Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+		})
+		if len(batch) == size {
+			batches = append(batches, batch)
+			batch = make([]prompb.TimeSeries, 0, size)
+		}
+	}
+
+	for _, f := range g.families {
+		for slot, id := range f.ids {
+			observed := float64(g.rng.Intn(100) + 1)
+			f.counts[slot] += observed
+			instance := prompb.Label{Name: "instance", Value: "loadgen-" + strconv.Itoa(id%100)}
+			series := prompb.Label{Name: "series", Value: strconv.Itoa(id)}
+
+			if !f.histogram {
+				add(f.counts[slot], prompb.Label{Name: "__name__", Value: f.name}, instance, series)
+				continue
+			}
+
+			f.sums[slot] += observed * g.rng.Float64()
+			for i, le := range g.bounds {
+				// Cumulative counts rising towards +Inf
+				count := f.counts[slot] * float64(i+1) / float64(len(g.bounds))
+				add(count, prompb.Label{Name: "__name__", Value: f.name + "_bucket"}, instance, prompb.Label{Name: "le", Value: le}, series)
+			}
+			add(f.sums[slot], prompb.Label{Name: "__name__", Value: f.name + "_sum"}, instance, series)
+			add(f.counts[slot], prompb.Label{Name: "__name__", Value: f.name + "_count"}, instance, series)
+		}
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
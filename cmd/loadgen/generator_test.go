@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func labelValue(ts prompb.TimeSeries, name string) string {
+	for _, label := range ts.Labels {
+		if label.Name == name {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+func flatten(batches [][]prompb.TimeSeries) []prompb.TimeSeries {
+	var all []prompb.TimeSeries
+	for _, batch := range batches {
+		all = append(all, batch...)
+	}
+	return all
+}
+
+func TestGenerator_SeriesAndBatches(t *testing.T) {
+	gen := newGenerator(generatorConfig{Metrics: 10, Series: 5, Histograms: 0.2, Buckets: 3}, 1)
+
+	// 8 counters with 5 series, and 2 histograms with 4 buckets, _sum and _count
+	want := 8*5 + 2*5*(4+2)
+	if got := gen.seriesPerInterval(); got != want {
+		t.Fatalf("seriesPerInterval() = %d, want %d", got, want)
+	}
+
+	batches := gen.next(1000, 7)
+	all := flatten(batches)
+	if len(all) != want {
+		t.Fatalf("Generated %d series, want %d", len(all), want)
+	}
+	for i, batch := range batches {
+		if len(batch) > 7 || (i < len(batches)-1 && len(batch) != 7) {
+			t.Errorf("Batch %d has %d series, want batches of 7", i, len(batch))
+		}
+	}
+	for _, ts := range all {
+		if len(ts.Samples) != 1 || ts.Samples[0].Timestamp != 1000 {
+			t.Fatalf("Unexpected samples %v", ts.Samples)
+		}
+	}
+}
+
+func TestGenerator_CountersIncrease(t *testing.T) {
+	gen := newGenerator(generatorConfig{Metrics: 1, Series: 3, Histograms: 1, Buckets: 2}, 1)
+
+	values := func(batches [][]prompb.TimeSeries) map[string]float64 {
+		result := make(map[string]float64)
+		for _, ts := range flatten(batches) {
+			key := labelValue(ts, "__name__") + labelValue(ts, "series") + labelValue(ts, "le")
+			result[key] = ts.Samples[0].Value
+		}
+		return result
+	}
+	first, second := values(gen.next(1000, 100)), values(gen.next(2000, 100))
+
+	for key, value := range second {
+		if value <= first[key] {
+			t.Errorf("%s did not increase: %v then %v", key, first[key], value)
+		}
+	}
+	for _, series := range []string{"0", "1", "2"} {
+		inf := second["loadgen_histogram_0000_seconds_bucket"+series+"+Inf"]
+		if count := second["loadgen_histogram_0000_seconds_count"+series]; inf != count {
+			t.Errorf("Series %s: +Inf bucket %v != count %v", series, inf, count)
+		}
+	}
+}
+
+func TestGenerator_Churn(t *testing.T) {
+	gen := newGenerator(generatorConfig{Metrics: 2, Series: 10, Churn: 0.3}, 1)
+
+	ids := func() map[string]bool {
+		result := make(map[string]bool)
+		for _, ts := range flatten(gen.next(1000, 100)) {
+			result[labelValue(ts, "__name__")+"/"+labelValue(ts, "series")] = true
+		}
+		return result
+	}
+	before := ids()
+	gen.churn()
+	after := ids()
+
+	if len(after) != 20 {
+		t.Fatalf("Got %d series after churn, want 20", len(after))
+	}
+	replaced := 0
+	for id := range after {
+		if !before[id] {
+			replaced++
+		}
+	}
+	// 3 of the 10 series of each of the 2 families
+	if replaced != 6 {
+		t.Errorf("Churn replaced %d series, want 6", replaced)
+	}
+}
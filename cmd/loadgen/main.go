@@ -0,0 +1,125 @@
+// Command loadgen sends synthetic remote write traffic to an Adaptive Metrics
+// instance and reports the throughput it achieved and how many samples were
+// dropped, as a reproducible benchmark for capacity planning.
+//
+//	go run ./cmd/loadgen -target http://localhost:8080/api/v1/write -metrics 100 -series 1000 -duration 5m
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var (
+		target      = flag.String("target", "http://localhost:8080/api/v1/write", "Remote write endpoint of the instance under test")
+		metricsURL  = flag.String("metrics-url", "", "Prometheus metrics endpoint of the instance, for server-side drops (default: /metrics on the target host; \"-\" to disable)")
+		tenant      = flag.String("tenant", "", "Tenant to send the traffic as")
+		tenantHdr   = flag.String("tenant-header", "X-Scope-OrgID", "Header identifying the tenant")
+		metrics     = flag.Int("metrics", 100, "Number of metric families")
+		series      = flag.Int("series", 100, "Number of series of each family")
+		churn       = flag.Float64("churn", 0, "Fraction of the series of each family replaced by new ones every interval")
+		histograms  = flag.Float64("histograms", 0.1, "Fraction of families that are histograms")
+		buckets     = flag.Int("buckets", 10, "Number of buckets of each histogram, besides +Inf")
+		interval    = flag.Duration("interval", 15*time.Second, "Interval between two samples of a series, like a scrape interval")
+		duration    = flag.Duration("duration", time.Minute, "How long to send traffic")
+		batchSize   = flag.Int("batch-size", 2000, "Maximum series per remote write request")
+		concurrency = flag.Int("concurrency", 4, "Number of concurrent remote write requests")
+		timeout     = flag.Duration("timeout", 10*time.Second, "Timeout of each remote write request")
+		seed        = flag.Int64("seed", 1, "Seed of the generated values, for reproducible runs")
+	)
+	flag.Parse()
+
+	if *metrics <= 0 || *series <= 0 || *batchSize <= 0 || *concurrency <= 0 || *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "metrics, series, batch-size, concurrency and interval must be positive")
+		os.Exit(2)
+	}
+	if *churn < 0 || *churn > 1 || *histograms < 0 || *histograms > 1 {
+		fmt.Fprintln(os.Stderr, "churn and histograms must be between 0 and 1")
+		os.Exit(2)
+	}
+
+	scrapeURL := *metricsURL
+	if scrapeURL == "" {
+		u, err := url.Parse(*target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid target: %v\n", err)
+			os.Exit(2)
+		}
+		u.Path, u.RawQuery = "/metrics", ""
+		scrapeURL = u.String()
+	} else if scrapeURL == "-" {
+		scrapeURL = ""
+	}
+
+	gen := newGenerator(generatorConfig{
+		Metrics:    *metrics,
+		Series:     *series,
+		Churn:      *churn,
+		Histograms: *histograms,
+		Buckets:    *buckets,
+	}, *seed)
+	sender := newSender(*target, *tenantHdr, *tenant, *timeout, *concurrency)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	ctx, cancelRun := context.WithTimeout(ctx, *duration)
+	defer cancelRun()
+
+	fmt.Printf("Sending %d series every %s to %s for %s\n", gen.seriesPerInterval(), *interval, *target, *duration)
+
+	before, err := scrapeDrops(scrapeURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Server-side drops not reported: %v\n", err)
+		scrapeURL = ""
+	}
+
+	stats := run(ctx, gen, sender, *interval, *batchSize)
+
+	var serverDrops map[string]float64
+	if scrapeURL != "" {
+		after, err := scrapeDrops(scrapeURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Server-side drops not reported: %v\n", err)
+		} else {
+			serverDrops = after.since(before)
+		}
+	}
+
+	stats.print(os.Stdout, serverDrops)
+}
+
+// run sends the samples of every series once per interval until the context
+// ends. Intervals taking longer than the interval start the next one right
+// away and are counted as late.
+func run(ctx context.Context, gen *generator, sender *sender, interval time.Duration, batchSize int) *stats {
+	stats := newStats()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		if !first {
+			gen.churn()
+		}
+		start := time.Now()
+		batches := gen.next(start.UnixMilli(), batchSize)
+		sender.send(ctx, batches, stats)
+		stats.intervals++
+		if time.Since(start) > interval {
+			stats.late++
+		}
+
+		select {
+		case <-ctx.Done():
+			stats.finish()
+			return stats
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// samplesWrittenHeader reports how many samples of a request the receiver
+// accepted
+const samplesWrittenHeader = "X-Prometheus-Remote-Write-Samples-Written"
+
+// sender sends remote write requests with bounded concurrency
+type sender struct {
+	target       string
+	tenantHeader string
+	tenant       string
+	client       *http.Client
+	concurrency  int
+}
+
+func newSender(target, tenantHeader, tenant string, timeout time.Duration, concurrency int) *sender {
+	return &sender{
+		target:       target,
+		tenantHeader: tenantHeader,
+		tenant:       tenant,
+		client:       &http.Client{Timeout: timeout},
+		concurrency:  concurrency,
+	}
+}
+
+// send sends the batches of an interval and waits for all of them
+func (s *sender) send(ctx context.Context, batches [][]prompb.TimeSeries, stats *stats) {
+	work := make(chan []prompb.TimeSeries)
+	var wg sync.WaitGroup
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range work {
+				stats.record(s.write(ctx, batch))
+			}
+		}()
+	}
+
+feed:
+	for _, batch := range batches {
+		select {
+		case work <- batch:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+}
+
+// result is the outcome of a remote write request
+type result struct {
+	samples int
+	// written is the number of samples the receiver accepted
+	written int
+	status  int
+	latency time.Duration
+	err     error
+	// canceled is set for requests cut short by the end of the run
+	canceled bool
+}
+
+// write sends one remote write request
+func (s *sender) write(ctx context.Context, batch []prompb.TimeSeries) result {
+	res := result{samples: len(batch)}
+	data, err := (&prompb.WriteRequest{Timeseries: batch}).Marshal()
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.target, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		res.err = err
+		return res
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if s.tenant != "" {
+		req.Header.Set(s.tenantHeader, s.tenant)
+	}
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	res.latency = time.Since(start)
+	if err != nil {
+		// Requests cut short by the end of the run are not failures
+		res.canceled = ctx.Err() != nil
+		res.err = err
+		return res
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	res.status = resp.StatusCode
+	if resp.StatusCode/100 != 2 {
+		res.err = fmt.Errorf("status %d", resp.StatusCode)
+		return res
+	}
+	res.written = res.samples
+	if header := resp.Header.Get(samplesWrittenHeader); header != "" {
+		if written, err := strconv.Atoi(header); err == nil {
+			res.written = written
+		}
+	}
+	return res
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSender_Stats(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Scope-OrgID") != "team-a" {
+			t.Errorf("Missing tenant header")
+		}
+		// Reject every third request and accept half of the others
+		if requests.Add(1)%3 == 0 {
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set(samplesWrittenHeader, "5")
+	}))
+	defer server.Close()
+
+	gen := newGenerator(generatorConfig{Metrics: 6, Series: 10}, 1)
+	sender := newSender(server.URL, "X-Scope-OrgID", "team-a", time.Second, 2)
+	stats := newStats()
+	sender.send(context.Background(), gen.next(1000, 10), stats)
+	stats.finish()
+
+	if stats.requests != 6 || stats.samples != 60 {
+		t.Fatalf("Got %d requests with %d samples, want 6 with 60", stats.requests, stats.samples)
+	}
+	if stats.rejected != 2 || stats.failed != 2 {
+		t.Errorf("Got %d rejected and %d failed requests, want 2 and 2", stats.rejected, stats.failed)
+	}
+	if stats.written != 20 {
+		t.Errorf("Got %d written samples, want 20", stats.written)
+	}
+
+	var report strings.Builder
+	stats.print(&report, map[string]float64{"queue_full": 4})
+	for _, want := range []string{"Client drop rate:  66.67%", "Server drop rate:  20.00%", "queue_full:"} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("Report does not contain %q:\n%s", want, report.String())
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// stats accumulates the outcome of a run
+type stats struct {
+	mu        sync.Mutex
+	start     time.Time
+	elapsed   time.Duration
+	intervals int
+	// late counts intervals whose requests took longer than the interval
+	late int
+
+	requests int
+	failed   int
+	// rejected counts requests answered with 429
+	rejected int
+	samples  int
+	written  int
+	latency  []time.Duration
+	errors   map[string]int
+}
+
+func newStats() *stats {
+	return &stats{start: time.Now(), errors: make(map[string]int)}
+}
+
+// record adds the outcome of a request
+func (s *stats) record(res result) {
+	if res.canceled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.samples += res.samples
+	s.written += res.written
+	if res.latency > 0 {
+		s.latency = append(s.latency, res.latency)
+	}
+	if res.err == nil {
+		return
+	}
+	s.failed++
+	if res.status == http.StatusTooManyRequests {
+		s.rejected++
+	}
+	s.errors[res.err.Error()]++
+}
+
+// finish stops the clock of the run
+func (s *stats) finish() {
+	s.elapsed = time.Since(s.start)
+}
+
+// percentile returns the latency below which a fraction of requests completed
+func (s *stats) percentile(q float64) time.Duration {
+	if len(s.latency) == 0 {
+		return 0
+	}
+	return s.latency[int(q*float64(len(s.latency)-1))]
+}
+
+// print writes the report of the run. serverDrops holds the samples the
+// server discarded during the run by reason, if known.
+func (s *stats) print(w io.Writer, serverDrops map[string]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.Slice(s.latency, func(i, j int) bool { return s.latency[i] < s.latency[j] })
+
+	seconds := s.elapsed.Seconds()
+	fmt.Fprintf(w, "\nDuration:          %s (%d intervals, %d late)\n", s.elapsed.Round(time.Millisecond), s.intervals, s.late)
+	fmt.Fprintf(w, "Requests:          %d (%d failed, %d rejected with 429)\n", s.requests, s.failed, s.rejected)
+	fmt.Fprintf(w, "Samples sent:      %d (%.0f/s)\n", s.samples, float64(s.samples)/seconds)
+	fmt.Fprintf(w, "Samples accepted:  %d (%.0f/s)\n", s.written, float64(s.written)/seconds)
+	fmt.Fprintf(w, "Client drop rate:  %.2f%%\n", ratio(s.samples-s.written, s.samples))
+	fmt.Fprintf(w, "Request latency:   p50 %s, p90 %s, p99 %s, max %s\n",
+		s.percentile(0.5).Round(time.Microsecond), s.percentile(0.9).Round(time.Microsecond),
+		s.percentile(0.99).Round(time.Microsecond), s.percentile(1).Round(time.Microsecond))
+
+	if serverDrops != nil {
+		total := 0.0
+		reasons := make([]string, 0, len(serverDrops))
+		for reason, dropped := range serverDrops {
+			total += dropped
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		fmt.Fprintf(w, "Server drop rate:  %.2f%% of accepted samples\n", ratio(int(total), s.written))
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "  %-16s %.0f\n", reason+":", serverDrops[reason])
+		}
+	}
+
+	if len(s.errors) > 0 {
+		fmt.Fprintln(w, "Errors:")
+		for err, count := range s.errors {
+			fmt.Fprintf(w, "  %dx %s\n", count, err)
+		}
+	}
+}
+
+// ratio returns part as a percentage of total
+func ratio(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+// discardedSamplesMetric counts the samples the server accepted but dropped
+const discardedSamplesMetric = "adaptive_metrics_discarded_samples_total"
+
+// drops holds the samples discarded by the server by reason
+type drops map[string]float64
+
+// scrapeDrops reads the discarded samples counter of the server
+func scrapeDrops(metricsURL string) (drops, error) {
+	if metricsURL == "" {
+		return nil, nil
+	}
+	resp, err := http.Get(metricsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d", metricsURL, resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", metricsURL, err)
+	}
+
+	result := make(drops)
+	family, exists := families[discardedSamplesMetric]
+	if !exists {
+		return result, nil
+	}
+	for _, metric := range family.GetMetric() {
+		reason := "unknown"
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "reason" {
+				reason = label.GetValue()
+			}
+		}
+		result[reason] += metric.GetCounter().GetValue()
+	}
+	return result, nil
+}
+
+// since returns the samples discarded since an earlier scrape
+func (d drops) since(before drops) map[string]float64 {
+	delta := make(map[string]float64)
+	for reason, dropped := range d {
+		if diff := dropped - before[reason]; diff > 0 {
+			delta[reason] = diff
+		}
+	}
+	return delta
+}
@@ -0,0 +1,149 @@
+// Command rulelint checks aggregation rule files against best practices
+// without a running instance, for example in CI before rules are deployed.
+// It exits with status 1 when any rule has warnings.
+//
+//	go run ./cmd/rulelint -scrape-interval 30s rules/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+func main() {
+	var (
+		configPath     = flag.String("config", "", "Directory of the config.yaml whose lint settings are used")
+		highLabels     = flag.String("high-cardinality-labels", "", "Comma-separated labels rules should not segment by (default: pod, instance, id and similar labels)")
+		outputSuffixes = flag.String("output-suffixes", "", "Comma-separated accepted endings of output metric names besides the aggregation type")
+		scrapeInterval = flag.Duration("scrape-interval", 0, "Scrape interval intervals must be a multiple of; 0 skips the check")
+		deadMansSwitch = flag.Bool("dead-mans-switch", true, "Whether the dead man's switch is enabled where the rules run")
+		disable        = flag.String("disable", "", "Comma-separated checks not to run: "+strings.Join(rules.LintChecks, ", "))
+	)
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: rulelint [flags] <rule file or directory>...")
+		os.Exit(2)
+	}
+
+	opts := rules.LintOptions{DeadMansSwitch: *deadMansSwitch}
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+			os.Exit(2)
+		}
+		opts = rules.LintOptionsFromConfig(cfg, nil)
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "dead-mans-switch" {
+				opts.DeadMansSwitch = *deadMansSwitch
+			}
+		})
+	}
+	if *highLabels != "" {
+		opts.HighCardinalityLabels = splitList(*highLabels)
+	}
+	if *outputSuffixes != "" {
+		opts.OutputSuffixes = splitList(*outputSuffixes)
+	}
+	if *scrapeInterval > 0 {
+		interval := *scrapeInterval
+		opts.ScrapeInterval = func(string) time.Duration { return interval }
+	}
+	if *disable != "" {
+		opts.Disabled = splitList(*disable)
+	}
+	if err := rules.ValidateLintChecks(opts.Disabled); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	files, err := ruleFiles(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	warnings := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		decoded, err := rules.DecodeRules(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			os.Exit(2)
+		}
+		for _, rule := range decoded {
+			if rule == nil {
+				continue
+			}
+			for _, warning := range rules.Lint(rule, opts) {
+				fmt.Printf("%s: %s: %s: %s\n", file, ruleLabel(rule), warning.Check, warning.Message)
+				warnings++
+			}
+		}
+	}
+
+	if warnings > 0 {
+		fmt.Fprintf(os.Stderr, "%d warnings in %d files\n", warnings, len(files))
+		os.Exit(1)
+	}
+}
+
+// ruleFiles expands directories among paths into the YAML and JSON files
+// they hold, like the rules directory of an instance
+func ruleFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			switch filepath.Ext(entry.Name()) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// ruleLabel names a rule in the output
+func ruleLabel(rule *models.Rule) string {
+	if rule.ID == "" {
+		return fmt.Sprintf("%q", rule.Name)
+	}
+	return fmt.Sprintf("%s (%s)", rule.ID, rule.Name)
+}
+
+// splitList splits a comma-separated flag value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,65 @@
+package aggregator
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// Positions of the output sample within its aggregation interval
+const (
+	OutputTimestampEnd      = "end"
+	OutputTimestampStart    = "start"
+	OutputTimestampMidpoint = "midpoint"
+)
+
+// validOutputTimestamp reports an error for an unknown output timestamp position
+func validOutputTimestamp(mode string) error {
+	switch mode {
+	case "", OutputTimestampEnd, OutputTimestampStart, OutputTimestampMidpoint:
+		return nil
+	default:
+		return fmt.Errorf("invalid output timestamp %q: must be end, start or midpoint", mode)
+	}
+}
+
+// intervalStart returns the start of the interval holding t. Intervals are
+// aligned to the Unix epoch, so every instance and every rule with the same
+// interval agree on the boundaries.
+func intervalStart(t time.Time, interval time.Duration) time.Time {
+	ms := interval.Milliseconds()
+	if ms <= 0 {
+		return t
+	}
+	unix := t.UnixMilli()
+	offset := unix % ms
+	if offset < 0 {
+		offset += ms
+	}
+	return time.UnixMilli(unix - offset)
+}
+
+// outputTimestamp returns the timestamp of the output sample of an interval
+func outputTimestamp(mode string, start, end time.Time) time.Time {
+	switch mode {
+	case OutputTimestampStart:
+		return start
+	case OutputTimestampMidpoint:
+		return start.Add(end.Sub(start) / 2)
+	default:
+		return end
+	}
+}
+
+// flushJitter returns the extra delay before the buckets of a rule are
+// flushed. It is derived from the rule ID, so each rule keeps flushing at the
+// same offset every interval while the flushes of many rules with the same
+// interval are spread over the jitter window.
+func flushJitter(ruleID string, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(ruleID))
+	return time.Duration(h.Sum64() % uint64(jitter))
+}
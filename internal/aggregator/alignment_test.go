@@ -0,0 +1,113 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestIntervalStart(t *testing.T) {
+	tests := []struct {
+		at       time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{time.Unix(125, 0), time.Minute, time.Unix(120, 0)},
+		{time.Unix(120, 0), time.Minute, time.Unix(120, 0)},
+		// Intervals not dividing a day are aligned to the Unix epoch too
+		{time.Unix(20, 500), 7 * time.Second, time.Unix(14, 0)},
+		{time.Unix(-3, 0), 7 * time.Second, time.Unix(-7, 0)},
+	}
+	for _, tt := range tests {
+		if got := intervalStart(tt.at, tt.interval); !got.Equal(tt.want) {
+			t.Errorf("intervalStart(%v, %v) = %v, want %v", tt.at.Unix(), tt.interval, got.Unix(), tt.want.Unix())
+		}
+	}
+}
+
+func TestOutputTimestamp(t *testing.T) {
+	start, end := time.Unix(60, 0), time.Unix(120, 0)
+	tests := map[string]time.Time{
+		"":                      end,
+		OutputTimestampEnd:      end,
+		OutputTimestampStart:    start,
+		OutputTimestampMidpoint: time.Unix(90, 0),
+	}
+	for mode, want := range tests {
+		if got := outputTimestamp(mode, start, end); !got.Equal(want) {
+			t.Errorf("outputTimestamp(%q) = %v, want %v", mode, got.Unix(), want.Unix())
+		}
+	}
+	if err := validOutputTimestamp("middle"); err == nil {
+		t.Error("Expected an error for an unknown output timestamp")
+	}
+}
+
+func TestFlushJitter(t *testing.T) {
+	if got := flushJitter("rule", 0); got != 0 {
+		t.Errorf("flushJitter() without jitter = %v, want 0", got)
+	}
+
+	window := 10 * time.Second
+	offsets := make(map[time.Duration]bool)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		jitter := flushJitter(id, window)
+		if jitter < 0 || jitter >= window {
+			t.Errorf("flushJitter(%q) = %v, outside of the window", id, jitter)
+		}
+		if again := flushJitter(id, window); again != jitter {
+			t.Errorf("flushJitter(%q) changed from %v to %v", id, jitter, again)
+		}
+		offsets[jitter] = true
+	}
+	if len(offsets) < 2 {
+		t.Errorf("Flushes of different rules were not spread: %v", offsets)
+	}
+}
+
+func TestProcessor_OutputTimestampAndJitter(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Aggregator.OutputTimestamp = OutputTimestampMidpoint
+	cfg.Aggregator.FlushJitterMs = 60000
+	p := &Processor{
+		cfg:        cfg,
+		buckets:    make(map[string]*aggregationBucket),
+		inputCh:    make(chan *models.MetricSample, 1),
+		stopCh:     make(chan struct{}),
+		counters:   newCounterTracker(counterLimits{}),
+		watch:      newOutputWatch(0),
+		timestamps: cfg.Aggregator.OutputTimestamp,
+		jitter:     time.Minute,
+	}
+	output, _ := p.Subscribe("test", SubscribeAll, 10)
+
+	rule := &models.Rule{
+		ID:          "rule",
+		Matcher:     models.MetricMatcher{MetricNames: []string{"requests"}},
+		Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 10},
+		Output:      models.OutputConfig{MetricName: "requests_aggregated"},
+	}
+	start := time.Now().Add(-time.Minute).Truncate(10 * time.Second)
+	flushAt := start.Add(10*time.Second + flushJitter(rule.ID, p.jitter))
+	p.buckets["rule"] = &aggregationBucket{
+		rule:      rule,
+		metrics:   map[string][]*models.MetricSample{"_all_": {{Name: "requests", Value: 1}}},
+		startTime: start,
+		endTime:   start.Add(10 * time.Second),
+		flushAt:   flushAt,
+	}
+
+	// The bucket is only flushed once its jitter has passed
+	p.flushBuckets(false)
+	if flushed, want := len(p.buckets) == 0, !time.Now().Before(flushAt); flushed != want {
+		t.Fatalf("Bucket flushed = %v, want %v", flushed, want)
+	}
+	p.flushBuckets(true)
+
+	metric := <-output
+	if want := start.Add(5 * time.Second); !metric.Timestamp.Equal(want) || !metric.SampleTime().Equal(want) {
+		t.Errorf("Output timestamp = %v, want the midpoint %v", metric.Timestamp, want)
+	}
+}
@@ -0,0 +1,147 @@
+package aggregator
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// BucketInfo describes an open aggregation bucket
+type BucketInfo struct {
+	RuleID     string    `json:"rule_id"`
+	RuleName   string    `json:"rule_name"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	FlushAt    time.Time `json:"flush_at"`
+	AgeSeconds float64   `json:"age_seconds"` // Time since the start of the interval
+	Segments   int       `json:"segments"`
+	Samples    int       `json:"samples"`
+	Counter    bool      `json:"counter"` // Sums counter increases
+}
+
+// SegmentInfo describes the samples of one segment of an open bucket
+type SegmentInfo struct {
+	Labels  map[string]string `json:"labels"` // Values of the grouping labels
+	Samples int               `json:"samples"`
+	Sum     float64           `json:"sum"`
+	Min     float64           `json:"min"`
+	Max     float64           `json:"max"`
+	Oldest  time.Time         `json:"oldest"` // Timestamp of the oldest sample
+	Newest  time.Time         `json:"newest"` // Timestamp of the newest sample
+}
+
+// BucketDetails describes an open bucket and its largest segments
+type BucketDetails struct {
+	Bucket   BucketInfo    `json:"bucket"`
+	Segments []SegmentInfo `json:"segments"`
+	// Truncated is set when segments beyond the limit were left out
+	Truncated bool `json:"truncated"`
+}
+
+// Buckets returns the open aggregation buckets, ordered by rule and interval
+func (p *Processor) Buckets() []BucketInfo {
+	now := time.Now()
+	p.bucketMu.RLock()
+	buckets := make([]BucketInfo, 0, len(p.buckets))
+	for _, bucket := range p.buckets {
+		buckets = append(buckets, bucket.info(now))
+	}
+	p.bucketMu.RUnlock()
+
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].RuleID != buckets[j].RuleID {
+			return buckets[i].RuleID < buckets[j].RuleID
+		}
+		return buckets[i].StartTime.Before(buckets[j].StartTime)
+	})
+	return buckets
+}
+
+// RuleBuckets returns the open buckets of a rule with up to limit segments
+// each, the ones holding the most samples first. A limit of 0 returns all
+// segments.
+func (p *Processor) RuleBuckets(ruleID string, limit int) []BucketDetails {
+	now := time.Now()
+	p.bucketMu.RLock()
+	var details []BucketDetails
+	for _, bucket := range p.buckets {
+		if bucket.rule.ID == ruleID {
+			details = append(details, bucket.details(now, limit))
+		}
+	}
+	p.bucketMu.RUnlock()
+
+	sort.Slice(details, func(i, j int) bool {
+		return details[i].Bucket.StartTime.Before(details[j].Bucket.StartTime)
+	})
+	return details
+}
+
+// info describes the bucket; callers hold the bucket lock
+func (b *aggregationBucket) info(now time.Time) BucketInfo {
+	info := BucketInfo{
+		RuleID:     b.rule.ID,
+		RuleName:   b.rule.Name,
+		StartTime:  b.startTime,
+		EndTime:    b.endTime,
+		FlushAt:    b.flushAt,
+		AgeSeconds: now.Sub(b.startTime).Seconds(),
+		Segments:   len(b.metrics),
+		Counter:    b.counter,
+	}
+	for _, samples := range b.metrics {
+		info.Samples += len(samples)
+	}
+	return info
+}
+
+// details describes the bucket and its segments; callers hold the bucket lock
+func (b *aggregationBucket) details(now time.Time, limit int) BucketDetails {
+	details := BucketDetails{
+		Bucket:   b.info(now),
+		Segments: make([]SegmentInfo, 0, len(b.metrics)),
+	}
+	grouping := b.rule.GroupingLabels()
+	for _, samples := range b.metrics {
+		details.Segments = append(details.Segments, segmentInfo(samples, grouping))
+	}
+
+	sort.Slice(details.Segments, func(i, j int) bool {
+		return details.Segments[i].Samples > details.Segments[j].Samples
+	})
+	if limit > 0 && len(details.Segments) > limit {
+		details.Segments = details.Segments[:limit]
+		details.Truncated = true
+	}
+	return details
+}
+
+// segmentInfo summarizes the samples of a segment
+func segmentInfo(samples []*models.MetricSample, grouping []string) SegmentInfo {
+	info := SegmentInfo{
+		Labels:  make(map[string]string, len(grouping)),
+		Samples: len(samples),
+	}
+	if len(samples) == 0 {
+		return info
+	}
+	for _, label := range grouping {
+		info.Labels[label] = samples[0].Labels[label]
+	}
+	info.Min, info.Max = samples[0].Value, samples[0].Value
+	info.Oldest, info.Newest = samples[0].Timestamp, samples[0].Timestamp
+	for _, sample := range samples {
+		info.Sum += sample.Value
+		info.Min = math.Min(info.Min, sample.Value)
+		info.Max = math.Max(info.Max, sample.Value)
+		if sample.Timestamp.Before(info.Oldest) {
+			info.Oldest = sample.Timestamp
+		}
+		if sample.Timestamp.After(info.Newest) {
+			info.Newest = sample.Timestamp
+		}
+	}
+	return info
+}
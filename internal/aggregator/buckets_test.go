@@ -0,0 +1,64 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestProcessor_Buckets(t *testing.T) {
+	now := time.Now()
+	rule := &models.Rule{
+		ID:          "requests",
+		Name:        "Requests by service",
+		Aggregation: models.AggregationConfig{Type: "sum", Segmentation: []string{"service"}},
+	}
+	sample := func(service string, value float64, age time.Duration) *models.MetricSample {
+		return &models.MetricSample{Value: value, Timestamp: now.Add(-age), Labels: map[string]string{"service": service, "pod": "a"}}
+	}
+
+	p := &Processor{buckets: map[string]*aggregationBucket{
+		"requests-60-1": {
+			rule:      rule,
+			startTime: now.Add(-30 * time.Second),
+			endTime:   now.Add(30 * time.Second),
+			metrics: map[string][]*models.MetricSample{
+				"[service=api]": {sample("api", 1, 20*time.Second), sample("api", 4, 5*time.Second), sample("api", 2, 10*time.Second)},
+				"[service=web]": {sample("web", 3, time.Second)},
+			},
+		},
+		"other-60-1": {
+			rule:      &models.Rule{ID: "other"},
+			startTime: now,
+			metrics:   map[string][]*models.MetricSample{"_all_": {sample("", 1, 0)}},
+		},
+	}}
+
+	buckets := p.Buckets()
+	if len(buckets) != 2 || buckets[0].RuleID != "other" || buckets[1].RuleID != "requests" {
+		t.Fatalf("Buckets() = %+v, want other and requests", buckets)
+	}
+	if b := buckets[1]; b.Segments != 2 || b.Samples != 4 || b.AgeSeconds < 30 {
+		t.Errorf("Buckets()[1] = %+v, want 2 segments, 4 samples and an age of at least 30s", b)
+	}
+
+	details := p.RuleBuckets("requests", 1)
+	if len(details) != 1 || !details[0].Truncated || len(details[0].Segments) != 1 {
+		t.Fatalf("RuleBuckets() = %+v, want one bucket with one of two segments", details)
+	}
+	segment := details[0].Segments[0]
+	if segment.Labels["service"] != "api" || segment.Samples != 3 || segment.Sum != 7 || segment.Min != 1 || segment.Max != 4 {
+		t.Errorf("segment = %+v, want api with 3 samples, sum 7, min 1 and max 4", segment)
+	}
+	if !segment.Oldest.Equal(now.Add(-20*time.Second)) || !segment.Newest.Equal(now.Add(-5*time.Second)) {
+		t.Errorf("segment spans %v to %v, want the oldest and newest samples", segment.Oldest, segment.Newest)
+	}
+	if _, exists := segment.Labels["pod"]; exists {
+		t.Error("segment labels should only hold grouping labels")
+	}
+
+	if details := p.RuleBuckets("missing", 0); len(details) != 0 {
+		t.Errorf("RuleBuckets() of a rule without buckets = %+v, want none", details)
+	}
+}
@@ -0,0 +1,148 @@
+package aggregator
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// Flushed intervals kept per rule, and example series kept per side of each
+const (
+	comparisonIntervals = 10
+	comparisonExamples  = 5
+)
+
+// SeriesExample is a series read or written by a rule
+type SeriesExample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+}
+
+// IntervalComparison compares the input a rule matched in one flushed
+// interval with the output it emitted for it
+type IntervalComparison struct {
+	StartTime      time.Time       `json:"start_time"`
+	EndTime        time.Time       `json:"end_time"`
+	FlushedAt      time.Time       `json:"flushed_at"`
+	InputSamples   int             `json:"input_samples"`
+	InputSeries    int             `json:"input_series"`
+	OutputSamples  int             `json:"output_samples"`
+	OutputSeries   int             `json:"output_series"`
+	InputExamples  []SeriesExample `json:"input_examples,omitempty"`
+	OutputExamples []SeriesExample `json:"output_examples,omitempty"`
+}
+
+// comparisonHistory keeps the comparisons of the last flushed intervals of
+// each rule
+type comparisonHistory struct {
+	mu    sync.Mutex
+	rules map[string][]IntervalComparison // Oldest first
+}
+
+func newComparisonHistory() *comparisonHistory {
+	return &comparisonHistory{rules: make(map[string][]IntervalComparison)}
+}
+
+// record compares the samples of a flushed bucket with its outputs. A nil
+// history records nothing.
+func (h *comparisonHistory) record(bucket *aggregationBucket, outputs []*models.AggregatedMetric, now time.Time) {
+	if h == nil {
+		return
+	}
+
+	comparison := IntervalComparison{
+		StartTime:     bucket.startTime,
+		EndTime:       bucket.endTime,
+		FlushedAt:     now,
+		OutputSamples: len(outputs),
+	}
+
+	series := make(map[uint64]bool)
+	for _, samples := range bucket.metrics {
+		comparison.InputSamples += len(samples)
+		for _, sample := range samples {
+			key := seriesHash(sample.Name, sample.Labels)
+			if series[key] {
+				continue
+			}
+			series[key] = true
+			if len(comparison.InputExamples) < comparisonExamples {
+				comparison.InputExamples = append(comparison.InputExamples, SeriesExample{Name: sample.Name, Labels: sample.Labels})
+			}
+		}
+	}
+	comparison.InputSeries = len(series)
+
+	series = make(map[uint64]bool)
+	for _, output := range outputs {
+		key := seriesHash(output.Name, output.Labels)
+		if series[key] {
+			continue
+		}
+		series[key] = true
+		if len(comparison.OutputExamples) < comparisonExamples {
+			comparison.OutputExamples = append(comparison.OutputExamples, SeriesExample{Name: output.Name, Labels: output.Labels})
+		}
+	}
+	comparison.OutputSeries = len(series)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	intervals := append(h.rules[bucket.rule.ID], comparison)
+	if len(intervals) > comparisonIntervals {
+		intervals = intervals[len(intervals)-comparisonIntervals:]
+	}
+	h.rules[bucket.rule.ID] = intervals
+}
+
+// forget drops the comparisons of rules not in keep
+func (h *comparisonHistory) forget(keep map[string]bool) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ruleID := range h.rules {
+		if !keep[ruleID] {
+			delete(h.rules, ruleID)
+		}
+	}
+}
+
+// seriesHash identifies a series by its name and labels
+func seriesHash(name string, labels map[string]string) uint64 {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	for _, key := range keys {
+		hash.Write([]byte{0xff})
+		hash.Write([]byte(key))
+		hash.Write([]byte{0xfe})
+		hash.Write([]byte(labels[key]))
+	}
+	return hash.Sum64()
+}
+
+// RuleComparison returns the comparisons of the last flushed intervals of a
+// rule, oldest first
+func (p *Processor) RuleComparison(ruleID string) []IntervalComparison {
+	if p.comparisons == nil {
+		return nil
+	}
+
+	p.comparisons.mu.Lock()
+	defer p.comparisons.mu.Unlock()
+	intervals := p.comparisons.rules[ruleID]
+	result := make([]IntervalComparison, len(intervals))
+	copy(result, intervals)
+	return result
+}
@@ -0,0 +1,59 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestProcessor_RuleComparison(t *testing.T) {
+	now := time.Now()
+	rule := &models.Rule{
+		ID:          "requests",
+		Aggregation: models.AggregationConfig{Type: "sum", Segmentation: []string{"service"}},
+		Output:      models.OutputConfig{MetricName: "requests:sum"},
+	}
+	sample := func(service, pod string) *models.MetricSample {
+		return &models.MetricSample{Name: "requests_total", Value: 1, Timestamp: now, Labels: map[string]string{"service": service, "pod": pod}}
+	}
+
+	p := &Processor{
+		counters:    newCounterTracker(counterLimits{}),
+		comparisons: newComparisonHistory(),
+	}
+	for i := 0; i < comparisonIntervals+2; i++ {
+		p.buckets = map[string]*aggregationBucket{
+			"requests": {
+				rule:      rule,
+				startTime: now.Add(time.Duration(i) * time.Minute),
+				endTime:   now.Add(time.Duration(i+1) * time.Minute),
+				metrics: map[string][]*models.MetricSample{
+					"[service=api]": {sample("api", "api-1"), sample("api", "api-2"), sample("api", "api-1")},
+					"[service=web]": {sample("web", "web-1")},
+				},
+			},
+		}
+		p.flushBuckets(true)
+	}
+
+	intervals := p.RuleComparison("requests")
+	if len(intervals) != comparisonIntervals {
+		t.Fatalf("RuleComparison() returned %d intervals, want the last %d", len(intervals), comparisonIntervals)
+	}
+	if !intervals[0].StartTime.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("oldest interval starts at %v, want the oldest ones dropped", intervals[0].StartTime)
+	}
+	last := intervals[len(intervals)-1]
+	if last.InputSamples != 4 || last.InputSeries != 3 || last.OutputSamples != 2 || last.OutputSeries != 2 {
+		t.Errorf("interval = %+v, want 4 samples of 3 series in and 2 series out", last)
+	}
+	if len(last.InputExamples) != 3 || len(last.OutputExamples) != 2 || last.OutputExamples[0].Name != "requests:sum" {
+		t.Errorf("examples = %+v and %+v, want every series of each side", last.InputExamples, last.OutputExamples)
+	}
+
+	p.comparisons.forget(map[string]bool{"other": true})
+	if intervals := p.RuleComparison("requests"); len(intervals) != 0 {
+		t.Errorf("RuleComparison() after the rule was deleted = %d intervals, want none", len(intervals))
+	}
+}
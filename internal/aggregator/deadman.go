@@ -0,0 +1,101 @@
+package aggregator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// ruleActivity is when a rule last received matching input and produced output
+type ruleActivity struct {
+	interval   time.Duration
+	lastInput  time.Time
+	lastOutput time.Time // Time of the first input until the rule produces output
+	stalled    bool      // Set once the stall is reported, until output resumes
+}
+
+// outputWatch is a dead man's switch for rules: it finds rules whose matching
+// input keeps arriving while they produce no output, which would silently
+// lose data if their original metrics are dropped
+type outputWatch struct {
+	mu        sync.Mutex
+	intervals int // Missed aggregation intervals before a rule is stalled
+	rules     map[string]*ruleActivity
+}
+
+// newOutputWatch creates a watch that reports rules after the given number
+// of intervals without output. It returns nil, which watches nothing, if
+// intervals is not positive.
+func newOutputWatch(intervals int) *outputWatch {
+	if intervals <= 0 {
+		return nil
+	}
+	return &outputWatch{
+		intervals: intervals,
+		rules:     make(map[string]*ruleActivity),
+	}
+}
+
+// input records that a sample matched the rule
+func (w *outputWatch) input(rule *models.Rule, now time.Time) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	activity, exists := w.rules[rule.ID]
+	if !exists {
+		activity = &ruleActivity{lastOutput: now}
+		w.rules[rule.ID] = activity
+	}
+	activity.interval = time.Duration(rule.Aggregation.IntervalSeconds) * time.Second
+	activity.lastInput = now
+}
+
+// output records that the rule produced an aggregated series
+func (w *outputWatch) output(ruleID string, now time.Time) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if activity, exists := w.rules[ruleID]; exists {
+		activity.lastOutput = now
+		activity.stalled = false
+	}
+}
+
+// stalledRules returns the IDs of rules that still receive input but produced no
+// output for the configured number of intervals, on top of the aggregation
+// delay. Each stall is reported once, until the rule produces output again.
+func (w *outputWatch) stalledRules(now time.Time, delay time.Duration) []string {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var stalled []string
+	for id, activity := range w.rules {
+		// Forget rules that stopped receiving input, e.g. deleted ones
+		if now.Sub(activity.lastInput) > counterStateTTL {
+			delete(w.rules, id)
+			continue
+		}
+
+		window := time.Duration(w.intervals) * activity.interval
+		if activity.stalled || now.Sub(activity.lastInput) > window || now.Sub(activity.lastOutput) <= window+delay {
+			continue
+		}
+		activity.stalled = true
+		stalled = append(stalled, id)
+	}
+
+	return stalled
+}
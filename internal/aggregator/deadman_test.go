@@ -0,0 +1,53 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestOutputWatch_StalledRules(t *testing.T) {
+	watch := newOutputWatch(3)
+	rule := &models.Rule{ID: "rule", Aggregation: models.AggregationConfig{IntervalSeconds: 60}}
+	delay := 30 * time.Second
+	start := time.Now()
+
+	// Input keeps arriving and output is produced every interval
+	for i := 0; i < 5; i++ {
+		now := start.Add(time.Duration(i) * time.Minute)
+		watch.input(rule, now)
+		watch.output(rule.ID, now)
+		if stalled := watch.stalledRules(now, delay); len(stalled) != 0 {
+			t.Fatalf("stalledRules() = %v while output is produced", stalled)
+		}
+	}
+
+	// Output stops while input keeps arriving
+	last := start.Add(4 * time.Minute)
+	for i := 5; i <= 7; i++ {
+		now := start.Add(time.Duration(i) * time.Minute)
+		watch.input(rule, now)
+		if stalled := watch.stalledRules(now, delay); len(stalled) != 0 {
+			t.Fatalf("stalledRules() = %v after %v without output", stalled, now.Sub(last))
+		}
+	}
+	now := last.Add(3*time.Minute + delay + time.Second)
+	if stalled := watch.stalledRules(now, delay); len(stalled) != 1 || stalled[0] != rule.ID {
+		t.Fatalf("stalledRules() = %v, want [rule]", stalled)
+	}
+	if stalled := watch.stalledRules(now.Add(time.Second), delay); len(stalled) != 0 {
+		t.Errorf("stalledRules() = %v, want the stall reported once", stalled)
+	}
+
+	// A rule without input is not stalled
+	if stalled := watch.stalledRules(now.Add(time.Hour), delay); len(stalled) != 0 {
+		t.Errorf("stalledRules() = %v for a rule without input", stalled)
+	}
+
+	disabled := newOutputWatch(0)
+	disabled.input(rule, now)
+	if stalled := disabled.stalledRules(now.Add(time.Hour), delay); stalled != nil {
+		t.Errorf("disabled watch reported %v", stalled)
+	}
+}
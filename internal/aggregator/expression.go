@@ -0,0 +1,260 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+)
+
+// expressionTimeout bounds the evaluation of one rule expression
+const expressionTimeout = 10 * time.Second
+
+// expressionEngine is the PromQL engine evaluating promql rules
+var expressionEngine = promql.NewEngine(promql.EngineOpts{
+	MaxSamples:               50000000,
+	Timeout:                  expressionTimeout,
+	NoStepSubqueryIntervalFn: func(int64) int64 { return time.Minute.Milliseconds() },
+	EnableAtModifier:         true,
+	EnableNegativeOffset:     true,
+})
+
+// evaluateExpression evaluates the expression of a promql rule over the
+// samples of a bucket. The expression is evaluated at the newest sample, and
+// instant selectors see every sample of the bucket.
+func evaluateExpression(bucket *aggregationBucket) ([]*models.AggregatedMetric, error) {
+	store := newBucketStorage(bucket)
+	if len(store.series) == 0 {
+		return nil, nil
+	}
+
+	opts := promql.NewPrometheusQueryOpts(false, time.Duration(store.maxt-store.mint+1)*time.Millisecond)
+	evalTime := time.UnixMilli(store.maxt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), expressionTimeout)
+	defer cancel()
+
+	query, err := expressionEngine.NewInstantQuery(ctx, store, opts, bucket.rule.Aggregation.Expression, evalTime)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+
+	result := query.Exec(ctx)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+
+	count := 0
+	for _, samples := range bucket.metrics {
+		count += len(samples)
+	}
+	newMetric := func(value float64, lset labels.Labels) *models.AggregatedMetric {
+		metricLabels := make(map[string]string, lset.Len())
+		lset.Range(func(l labels.Label) {
+			if l.Name != labels.MetricName {
+				metricLabels[l.Name] = l.Value
+			}
+		})
+		for k, v := range bucket.rule.Output.AdditionalLabels {
+			metricLabels[k] = v
+		}
+		return &models.AggregatedMetric{
+			Name:       bucket.rule.Output.MetricName,
+			Value:      value,
+			StartTime:  bucket.startTime,
+			EndTime:    bucket.endTime,
+			Labels:     metricLabels,
+			SourceRule: bucket.rule.ID,
+			Count:      count,
+		}
+	}
+
+	switch value := result.Value.(type) {
+	case promql.Vector:
+		metrics := make([]*models.AggregatedMetric, 0, len(value))
+		for _, sample := range value {
+			if sample.H != nil {
+				continue // Native histograms are not supported
+			}
+			metrics = append(metrics, newMetric(sample.F, sample.Metric))
+		}
+		return metrics, nil
+	case promql.Scalar:
+		return []*models.AggregatedMetric{newMetric(value.V, labels.EmptyLabels())}, nil
+	default:
+		return nil, fmt.Errorf("unsupported expression result type %s", result.Value.Type())
+	}
+}
+
+// bucketStorage is a read-only PromQL storage over the samples of a bucket
+type bucketStorage struct {
+	series     []storage.Series
+	mint, maxt int64
+}
+
+// newBucketStorage indexes the samples of a bucket by series. Samples
+// without a timestamp are placed at the end of the bucket.
+func newBucketStorage(bucket *aggregationBucket) *bucketStorage {
+	bySeries := make(map[string]labels.Labels)
+	samples := make(map[string][]chunks.Sample)
+	store := &bucketStorage{}
+	first := true
+
+	for _, segment := range bucket.metrics {
+		for _, sample := range segment {
+			lset := sampleLabels(sample)
+			key := lset.String()
+			bySeries[key] = lset
+
+			ts := sample.Timestamp.UnixMilli()
+			if sample.Timestamp.IsZero() {
+				ts = bucket.endTime.UnixMilli() - 1
+			}
+			if first {
+				store.mint, store.maxt = ts, ts
+				first = false
+			}
+			store.mint = min(store.mint, ts)
+			store.maxt = max(store.maxt, ts)
+			samples[key] = append(samples[key], floatSample{t: ts, f: sample.Value})
+		}
+	}
+
+	for key, lset := range bySeries {
+		store.series = append(store.series, storage.NewListSeries(lset, sortedSamples(samples[key])))
+	}
+	sort.Slice(store.series, func(i, j int) bool {
+		return labels.Compare(store.series[i].Labels(), store.series[j].Labels()) < 0
+	})
+
+	return store
+}
+
+// sampleLabels returns the labels of a sample including its metric name
+func sampleLabels(sample *models.MetricSample) labels.Labels {
+	builder := labels.NewScratchBuilder(len(sample.Labels) + 1)
+	builder.Add(labels.MetricName, sample.Name)
+	for name, value := range sample.Labels {
+		builder.Add(name, value)
+	}
+	builder.Sort()
+	return builder.Labels()
+}
+
+// sortedSamples orders samples by time, keeping the last one received for
+// each timestamp
+func sortedSamples(samples []chunks.Sample) []chunks.Sample {
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].T() < samples[j].T() })
+
+	result := samples[:0]
+	for _, sample := range samples {
+		if n := len(result); n > 0 && result[n-1].T() == sample.T() {
+			result[n-1] = sample
+			continue
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+// Querier implements storage.Queryable
+func (s *bucketStorage) Querier(mint, maxt int64) (storage.Querier, error) {
+	return s, nil
+}
+
+// Select returns the series matching all matchers, sorted by labels
+func (s *bucketStorage) Select(_ context.Context, _ bool, _ *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	var selected []storage.Series
+	for _, series := range s.series {
+		if matchesAll(series.Labels(), matchers) {
+			selected = append(selected, series)
+		}
+	}
+	return &seriesSet{series: selected, idx: -1}
+}
+
+// LabelValues returns the sorted values of a label on series matching all matchers
+func (s *bucketStorage) LabelValues(_ context.Context, name string, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	seen := make(map[string]bool)
+	var values []string
+	for _, series := range s.series {
+		if value := series.Labels().Get(name); value != "" && !seen[value] && matchesAll(series.Labels(), matchers) {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values, nil, nil
+}
+
+// LabelNames returns the sorted label names of series matching all matchers
+func (s *bucketStorage) LabelNames(_ context.Context, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, series := range s.series {
+		if !matchesAll(series.Labels(), matchers) {
+			continue
+		}
+		series.Labels().Range(func(l labels.Label) {
+			if !seen[l.Name] {
+				seen[l.Name] = true
+				names = append(names, l.Name)
+			}
+		})
+	}
+	sort.Strings(names)
+	return names, nil, nil
+}
+
+// Close implements storage.Querier
+func (s *bucketStorage) Close() error {
+	return nil
+}
+
+// matchesAll reports whether a label set matches all matchers
+func matchesAll(lset labels.Labels, matchers []*labels.Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(lset.Get(matcher.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// seriesSet iterates over a slice of series
+type seriesSet struct {
+	series []storage.Series
+	idx    int
+}
+
+func (s *seriesSet) Next() bool {
+	s.idx++
+	return s.idx < len(s.series)
+}
+
+func (s *seriesSet) At() storage.Series                { return s.series[s.idx] }
+func (s *seriesSet) Err() error                        { return nil }
+func (s *seriesSet) Warnings() annotations.Annotations { return nil }
+
+// floatSample is a float sample of a bucket series
+type floatSample struct {
+	t int64
+	f float64
+}
+
+func (s floatSample) T() int64                      { return s.t }
+func (s floatSample) F() float64                    { return s.f }
+func (s floatSample) H() *histogram.Histogram       { return nil }
+func (s floatSample) FH() *histogram.FloatHistogram { return nil }
+func (s floatSample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
+func (s floatSample) Copy() chunks.Sample           { return s }
@@ -0,0 +1,84 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	end := time.Now().Truncate(time.Minute)
+	at := end.Add(-30 * time.Second)
+	sample := func(name, service, pod string, value float64) *models.MetricSample {
+		return &models.MetricSample{
+			Name:      name,
+			Value:     value,
+			Timestamp: at,
+			Labels:    map[string]string{"service": service, "pod": pod},
+		}
+	}
+
+	bucket := &aggregationBucket{
+		rule: &models.Rule{
+			ID: "error-ratio",
+			Aggregation: models.AggregationConfig{
+				Type:       models.AggregationPromQL,
+				Expression: `sum by (service) (http_errors_total) / sum by (service) (http_requests_total)`,
+			},
+			Output: models.OutputConfig{
+				MetricName:       "service:http_error_ratio",
+				AdditionalLabels: map[string]string{"source": "adaptive"},
+			},
+		},
+		metrics: map[string][]*models.MetricSample{
+			"_all_": {
+				sample("http_requests_total", "checkout", "a", 80),
+				sample("http_requests_total", "checkout", "b", 20),
+				sample("http_errors_total", "checkout", "a", 5),
+				sample("http_errors_total", "checkout", "b", 5),
+				sample("http_requests_total", "search", "a", 50),
+				sample("http_errors_total", "search", "a", 1),
+			},
+		},
+		startTime: end.Add(-time.Minute),
+		endTime:   end,
+	}
+
+	outputs, err := evaluateExpression(bucket)
+	if err != nil {
+		t.Fatalf("evaluateExpression() error = %v", err)
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("evaluateExpression() returned %d series, want 2", len(outputs))
+	}
+
+	want := map[string]float64{"checkout": 0.1, "search": 0.02}
+	for _, output := range outputs {
+		service := output.Labels["service"]
+		if output.Name != "service:http_error_ratio" || output.Labels["source"] != "adaptive" || len(output.Labels) != 2 {
+			t.Errorf("output = %+v, want the rule's metric name with service and source labels", output)
+		}
+		if !almostEqual(output.Value, want[service]) {
+			t.Errorf("ratio of %s = %v, want %v", service, output.Value, want[service])
+		}
+	}
+
+	// Later samples of a series replace earlier ones for instant selectors
+	bucket.metrics["_all_"] = append(bucket.metrics["_all_"], &models.MetricSample{
+		Name:      "http_requests_total",
+		Value:     100,
+		Timestamp: at.Add(10 * time.Second),
+		Labels:    map[string]string{"service": "search", "pod": "a"},
+	})
+	bucket.rule.Aggregation.Expression = `sum(http_requests_total{service="search"})`
+	outputs, err = evaluateExpression(bucket)
+	if err != nil || len(outputs) != 1 || outputs[0].Value != 100 {
+		t.Errorf("evaluateExpression() = %v, %v, want the latest sample", outputs, err)
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	diff := a - b
+	return diff < 1e-9 && diff > -1e-9
+}
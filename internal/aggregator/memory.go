@@ -0,0 +1,72 @@
+package aggregator
+
+import (
+	"unsafe"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// Approximate sizes used by the memory estimates
+const (
+	pointerBytes   = 8
+	mapEntryBytes  = 16 // Bucket overhead of a map entry beyond its key and value
+	stringHdrBytes = 16
+)
+
+// MemoryUsage is the estimated memory held by the processor, in bytes. Label
+// sets shared by the samples of a series are counted once per segment, and
+// not at all for queued samples.
+type MemoryUsage struct {
+	Buckets          int64 `json:"buckets"`
+	InputQueue       int64 `json:"input_queue"`
+	RemoteWriteQueue int64 `json:"remote_write_queue"`
+	Counters         int64 `json:"counters"`
+}
+
+// Total returns the sum of the estimates
+func (m MemoryUsage) Total() int64 {
+	return m.Buckets + m.InputQueue + m.RemoteWriteQueue + m.Counters
+}
+
+var (
+	sampleBytes     = int64(unsafe.Sizeof(models.MetricSample{})) + pointerBytes
+	aggregatedBytes = int64(unsafe.Sizeof(models.AggregatedMetric{})) + pointerBytes
+	counterBytes    = int64(unsafe.Sizeof(counterState{})) + pointerBytes + mapEntryBytes
+)
+
+// MemoryUsage estimates the memory held by the open buckets, the queues and
+// the counter state of the processor
+func (p *Processor) MemoryUsage() MemoryUsage {
+	usage := MemoryUsage{InputQueue: int64(len(p.inputCh)) * sampleBytes}
+	if p.remoteWriter != nil {
+		usage.RemoteWriteQueue = int64(p.remoteWriter.QueueLength()) * aggregatedBytes
+	}
+
+	inputs, outputs := p.counters.size()
+	// Input series are keyed by hash, output series by rule and segment keys
+	// assumed to be 64 bytes long
+	usage.Counters = int64(inputs)*(counterBytes+8) + int64(outputs)*(counterBytes+stringHdrBytes+64)
+
+	p.bucketMu.RLock()
+	defer p.bucketMu.RUnlock()
+	for key, bucket := range p.buckets {
+		usage.Buckets += int64(unsafe.Sizeof(aggregationBucket{})) + int64(len(key)) + stringHdrBytes + mapEntryBytes
+		for segment, samples := range bucket.metrics {
+			usage.Buckets += int64(len(segment)) + stringHdrBytes + mapEntryBytes
+			usage.Buckets += int64(cap(samples)) * sampleBytes
+			if len(samples) > 0 {
+				usage.Buckets += labelsBytes(samples[0].Labels)
+			}
+		}
+	}
+	return usage
+}
+
+// labelsBytes estimates the memory of a label set
+func labelsBytes(labels map[string]string) int64 {
+	size := int64(48) // Map header
+	for name, value := range labels {
+		size += int64(len(name)+len(value)) + 2*stringHdrBytes + mapEntryBytes
+	}
+	return size
+}
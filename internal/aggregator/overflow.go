@@ -0,0 +1,112 @@
+package aggregator
+
+import (
+	"errors"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// Overflow policies applied when the input queue is full
+const (
+	// OverflowDrop drops the incoming sample
+	OverflowDrop = "drop"
+	// OverflowDropOldest evicts the oldest queued sample to make room
+	OverflowDropOldest = "drop_oldest"
+	// OverflowBlock waits up to the configured timeout for room in the queue
+	OverflowBlock = "block"
+	// OverflowReject drops the incoming sample and reports ErrOverloaded so the
+	// sender can retry later
+	OverflowReject = "reject"
+)
+
+// Reasons recorded in the discarded samples metric
+const (
+	DropReasonQueueFull        = "queue_full"
+	DropReasonQueueTimeout     = "queue_timeout"
+	DropReasonEvicted          = "evicted"
+	DropReasonRejected         = "rejected"
+	DropReasonForwardQueueFull = "forward_queue_full"
+)
+
+// ErrOverloaded is returned by ProcessMetric when a sample could not be queued
+// and the sender should back off
+var ErrOverloaded = errors.New("processor is overloaded")
+
+// enqueue submits a sample to the input queue according to the overflow policy
+func (p *Processor) enqueue(sample *models.MetricSample) error {
+	sample.EnqueuedAt = time.Now()
+	select {
+	case p.inputCh <- sample:
+		return nil
+	default:
+	}
+	metrics.RecordQueueFull(metrics.QueueInput)
+
+	switch p.cfg.Aggregator.OverflowPolicy {
+	case OverflowDropOldest:
+		// Evict queued samples until there is room; workers may drain the
+		// queue concurrently, so both operations are non-blocking
+		for {
+			select {
+			case p.inputCh <- sample:
+				return nil
+			default:
+			}
+			select {
+			case evicted := <-p.inputCh:
+				p.recordDrop(evicted, DropReasonEvicted)
+			default:
+			}
+		}
+
+	case OverflowBlock:
+		timer := time.NewTimer(time.Duration(p.cfg.Aggregator.OverflowTimeoutMs) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case p.inputCh <- sample:
+			return nil
+		case <-timer.C:
+			p.recordDrop(sample, DropReasonQueueTimeout)
+			return ErrOverloaded
+		case <-p.stopCh:
+			p.recordDrop(sample, DropReasonQueueTimeout)
+			return ErrOverloaded
+		}
+
+	case OverflowReject:
+		p.recordDrop(sample, DropReasonRejected)
+		return ErrOverloaded
+
+	default:
+		p.recordDrop(sample, DropReasonQueueFull)
+		return nil
+	}
+}
+
+// Admit returns ErrOverloaded when the overflow policy rejects samples and
+// the input queue has no room for n more, so that a request can be refused
+// before any of its samples are queued. Requests larger than the queue are
+// admitted when it is empty.
+func (p *Processor) Admit(n int) error {
+	if p.cfg.Aggregator.OverflowPolicy != OverflowReject {
+		return nil
+	}
+	if cap(p.inputCh)-len(p.inputCh) < min(n, cap(p.inputCh)) {
+		metrics.RecordQueueFull(metrics.QueueInput)
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// recordDrop counts a discarded sample
+func (p *Processor) recordDrop(sample *models.MetricSample, reason string) {
+	metrics.RecordDiscardedSample(sample.Name, reason)
+	metrics.RecordDroppedSamples(metrics.StageIngest, reason, 1)
+	droppedInputLog.Log(logger.Fields{
+		"metric_name": sample.Name,
+		"reason":      reason,
+	})
+}
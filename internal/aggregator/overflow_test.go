@@ -0,0 +1,69 @@
+package aggregator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestProcessor(policy string) *Processor {
+	cfg := &config.Config{}
+	cfg.Aggregator.OverflowPolicy = policy
+	cfg.Aggregator.OverflowTimeoutMs = 10
+
+	return &Processor{
+		cfg:     cfg,
+		inputCh: make(chan *models.MetricSample, 1),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+func TestProcessor_Enqueue(t *testing.T) {
+	first := &models.MetricSample{Name: "first"}
+	second := &models.MetricSample{Name: "second"}
+
+	tests := []struct {
+		policy  string
+		wantErr bool
+		queued  string
+		reason  string
+	}{
+		{policy: OverflowDrop, wantErr: false, queued: "first", reason: DropReasonQueueFull},
+		{policy: OverflowDropOldest, wantErr: false, queued: "second", reason: DropReasonEvicted},
+		{policy: OverflowBlock, wantErr: true, queued: "first", reason: DropReasonQueueTimeout},
+		{policy: OverflowReject, wantErr: true, queued: "first", reason: DropReasonRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			p := newTestProcessor(tt.policy)
+			if err := p.Admit(5); err != nil {
+				t.Errorf("Admit() of a request larger than the empty queue error = %v", err)
+			}
+			if err := p.enqueue(first); err != nil {
+				t.Fatalf("enqueue() into empty queue error = %v", err)
+			}
+			dropped := metrics.DroppedSamplesCounter.WithLabelValues(metrics.StageIngest, tt.reason)
+			before := testutil.ToFloat64(dropped)
+
+			err := p.enqueue(second)
+			if errors.Is(err, ErrOverloaded) != tt.wantErr {
+				t.Errorf("enqueue() into full queue error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// Only rejecting policies refuse requests up front
+			if err := p.Admit(1); errors.Is(err, ErrOverloaded) != (tt.policy == OverflowReject) {
+				t.Errorf("Admit() into full queue error = %v", err)
+			}
+			if queued := <-p.inputCh; queued.Name != tt.queued {
+				t.Errorf("queued sample = %s, want %s", queued.Name, tt.queued)
+			}
+			if got := testutil.ToFloat64(dropped) - before; got != 1 {
+				t.Errorf("dropped samples counted = %v, want 1", got)
+			}
+		})
+	}
+}
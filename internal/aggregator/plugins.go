@@ -0,0 +1,166 @@
+package aggregator
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+	"github.com/marcotuna/adaptive-metrics/pkg/transform"
+)
+
+// pluginPanicLog is sampled, since a faulty plugin may panic on every sample
+var pluginPanicLog = logger.NewSampler(logger.Error, "Transform plugin panicked")
+
+// sampleTransform is the sample transform of a plugin
+type sampleTransform struct {
+	path string
+	fn   func(*transform.Sample) bool
+}
+
+// outputTransform is the output transform of a plugin
+type outputTransform struct {
+	path string
+	fn   func(*transform.Output) bool
+}
+
+// transformPlugins are the transform functions of the loaded transform
+// plugins, in the order of their configuration
+type transformPlugins struct {
+	samples []sampleTransform
+	outputs []outputTransform
+}
+
+// loadTransformPlugins opens the configured transform plugins and initializes
+// them. It returns nil when none is configured.
+func loadTransformPlugins(cfgs []config.TransformPluginConfig) (*transformPlugins, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+
+	plugins := &transformPlugins{}
+	for _, cfg := range cfgs {
+		p, err := plugin.Open(cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open transform plugin %s: %w", cfg.Path, err)
+		}
+
+		if symbol, err := p.Lookup(transform.InitSymbol); err == nil {
+			init, ok := symbol.(func(map[string]string) error)
+			if !ok {
+				return nil, fmt.Errorf("transform plugin %s: %s has type %T, want func(map[string]string) error", cfg.Path, transform.InitSymbol, symbol)
+			}
+			if err := init(cfg.Params); err != nil {
+				return nil, fmt.Errorf("failed to initialize transform plugin %s: %w", cfg.Path, err)
+			}
+		}
+
+		found := false
+		if symbol, err := p.Lookup(transform.SampleSymbol); err == nil {
+			fn, ok := symbol.(func(*transform.Sample) bool)
+			if !ok {
+				return nil, fmt.Errorf("transform plugin %s: %s has type %T, want func(*transform.Sample) bool", cfg.Path, transform.SampleSymbol, symbol)
+			}
+			plugins.samples = append(plugins.samples, sampleTransform{path: cfg.Path, fn: fn})
+			found = true
+		}
+		if symbol, err := p.Lookup(transform.OutputSymbol); err == nil {
+			fn, ok := symbol.(func(*transform.Output) bool)
+			if !ok {
+				return nil, fmt.Errorf("transform plugin %s: %s has type %T, want func(*transform.Output) bool", cfg.Path, transform.OutputSymbol, symbol)
+			}
+			plugins.outputs = append(plugins.outputs, outputTransform{path: cfg.Path, fn: fn})
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("transform plugin %s exports neither %s nor %s", cfg.Path, transform.SampleSymbol, transform.OutputSymbol)
+		}
+
+		logger.LogInfoWithFields("Loaded transform plugin", logger.Fields{
+			"path": cfg.Path,
+		})
+	}
+
+	return plugins, nil
+}
+
+// sample applies the sample transforms to a copy of a sample. It returns
+// false if a transform dropped the sample or panicked.
+func (t *transformPlugins) sample(sample *models.MetricSample) (*models.MetricSample, bool) {
+	if t == nil || len(t.samples) == 0 {
+		return sample, true
+	}
+
+	labels := make(map[string]string, len(sample.Labels))
+	for k, v := range sample.Labels {
+		labels[k] = v
+	}
+	s := &transform.Sample{Name: sample.Name, Labels: labels, Value: sample.Value, Timestamp: sample.Timestamp}
+	for _, fn := range t.samples {
+		if !fn.call(s) {
+			metrics.RecordDroppedSamples(metrics.StageIngest, "transform_plugin", 1)
+			return nil, false
+		}
+	}
+
+	transformed := *sample
+	transformed.Name = s.Name
+	transformed.Labels = s.Labels
+	transformed.Value = s.Value
+	transformed.Timestamp = s.Timestamp
+	return &transformed, true
+}
+
+// output applies the output transforms to an aggregated series in place. It
+// returns false if a transform dropped the series or panicked.
+func (t *transformPlugins) output(metric *models.AggregatedMetric) bool {
+	if t == nil || len(t.outputs) == 0 {
+		return true
+	}
+
+	o := &transform.Output{
+		Name:      metric.Name,
+		Labels:    metric.Labels,
+		Value:     metric.Value,
+		Timestamp: metric.Timestamp,
+		Rule:      metric.SourceRule,
+	}
+	for _, fn := range t.outputs {
+		if !fn.call(o) {
+			metrics.RecordDroppedSamples(metrics.StageAggregation, "transform_plugin", 1)
+			return false
+		}
+	}
+
+	metric.Name = o.Name
+	metric.Labels = o.Labels
+	metric.Value = o.Value
+	metric.Timestamp = o.Timestamp
+	return true
+}
+
+// call applies the transform, treating a panic as a drop
+func (t sampleTransform) call(sample *transform.Sample) (keep bool) {
+	defer recoverTransform(t.path, &keep)
+	return t.fn(sample)
+}
+
+// call applies the transform, treating a panic as a drop
+func (t outputTransform) call(output *transform.Output) (keep bool) {
+	defer recoverTransform(t.path, &keep)
+	return t.fn(output)
+}
+
+// recoverTransform recovers from the panic of a transform plugin, which
+// drops what it was given
+func recoverTransform(path string, keep *bool) {
+	if r := recover(); r != nil {
+		pluginPanicLog.Log(logger.Fields{
+			"path":  path,
+			"panic": fmt.Sprint(r),
+		})
+		*keep = false
+	}
+}
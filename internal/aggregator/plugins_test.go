@@ -0,0 +1,96 @@
+package aggregator
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/transform"
+)
+
+func TestTransformPlugins_Sample(t *testing.T) {
+	plugins := &transformPlugins{samples: []sampleTransform{
+		{path: "enrich.so", fn: func(s *transform.Sample) bool {
+			s.Labels["team"] = "payments"
+			s.Name = "enriched_" + s.Name
+			return true
+		}},
+		{path: "filter.so", fn: func(s *transform.Sample) bool {
+			return s.Labels["env"] != "dev"
+		}},
+	}}
+
+	sample := &models.MetricSample{Name: "http_requests_total", Labels: map[string]string{"env": "prod"}, Value: 1}
+	transformed, keep := plugins.sample(sample)
+	if !keep {
+		t.Fatal("sample dropped")
+	}
+	if transformed.Name != "enriched_http_requests_total" || transformed.Labels["team"] != "payments" || transformed.Value != 1 {
+		t.Errorf("transformed sample = %+v", transformed)
+	}
+	// The ingested sample is left as is
+	if sample.Name != "http_requests_total" || len(sample.Labels) != 1 {
+		t.Errorf("original sample changed: %+v", sample)
+	}
+
+	if _, keep := plugins.sample(&models.MetricSample{Name: "x", Labels: map[string]string{"env": "dev"}}); keep {
+		t.Error("sample filtered by a plugin kept")
+	}
+
+	var none *transformPlugins
+	if transformed, keep := none.sample(sample); !keep || transformed != sample {
+		t.Error("sample changed without plugins")
+	}
+}
+
+func TestTransformPlugins_Output(t *testing.T) {
+	plugins := &transformPlugins{outputs: []outputTransform{
+		{path: "scale.so", fn: func(o *transform.Output) bool {
+			if o.Rule != "rule-1" {
+				return false
+			}
+			o.Value *= 1000
+			o.Labels["unit"] = "ms"
+			return true
+		}},
+	}}
+
+	metric := &models.AggregatedMetric{Name: "latency:avg", Labels: map[string]string{}, Value: 1.5, SourceRule: "rule-1"}
+	if !plugins.output(metric) {
+		t.Fatal("series dropped")
+	}
+	if metric.Value != 1500 || metric.Labels["unit"] != "ms" {
+		t.Errorf("transformed series = %+v", metric)
+	}
+
+	if plugins.output(&models.AggregatedMetric{Name: "other", Labels: map[string]string{}, SourceRule: "rule-2"}) {
+		t.Error("series filtered by a plugin kept")
+	}
+}
+
+func TestTransformPlugins_Panic(t *testing.T) {
+	plugins := &transformPlugins{
+		samples: []sampleTransform{{path: "faulty.so", fn: func(s *transform.Sample) bool {
+			panic("nil map")
+		}}},
+		outputs: []outputTransform{{path: "faulty.so", fn: func(o *transform.Output) bool {
+			panic("nil map")
+		}}},
+	}
+
+	if _, keep := plugins.sample(&models.MetricSample{Name: "x", Labels: map[string]string{}}); keep {
+		t.Error("sample kept after a panic")
+	}
+	if plugins.output(&models.AggregatedMetric{Name: "x", Labels: map[string]string{}}) {
+		t.Error("series kept after a panic")
+	}
+}
+
+func TestLoadTransformPlugins_Missing(t *testing.T) {
+	if plugins, err := loadTransformPlugins(nil); err != nil || plugins != nil {
+		t.Errorf("loadTransformPlugins(nil) = %v, %v", plugins, err)
+	}
+	if _, err := loadTransformPlugins([]config.TransformPluginConfig{{Path: t.TempDir() + "/missing.so"}}); err == nil {
+		t.Error("missing plugin loaded")
+	}
+}
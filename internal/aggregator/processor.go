@@ -1,15 +1,23 @@
 package aggregator
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/marcotuna/adaptive-metrics/internal/config"
 	"github.com/marcotuna/adaptive-metrics/internal/models"
 	"github.com/marcotuna/adaptive-metrics/internal/rules"
+	"github.com/marcotuna/adaptive-metrics/internal/sharding"
+	"github.com/marcotuna/adaptive-metrics/internal/tracing"
 	"github.com/marcotuna/adaptive-metrics/internal/types"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
 	"github.com/marcotuna/adaptive-metrics/pkg/remote"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MetricTracker defines the interface that aggregator requires from API handlers
@@ -24,13 +32,32 @@ type Processor struct {
 	buckets      map[string]*aggregationBucket
 	bucketMu     sync.RWMutex
 	inputCh      chan *models.MetricSample
-	outputCh     chan *models.AggregatedMetric
 	workerWg     sync.WaitGroup
 	stopCh       chan struct{}
-	apiHandler   MetricTracker  // Interface used for usage tracking
-	remoteWriter *remote.Client // Remote write client
+	flusherDone  chan struct{}      // Closed when the aggregator goroutine exits
+	apiHandler   MetricTracker      // Interface used for usage tracking
+	remoteWriter *remote.Client     // Remote write client
+	cluster      *sharding.Cluster  // Owners of the output series when sharding is enabled
+	counters     *counterTracker    // Counter state for temporality conversion
+	watch        *outputWatch       // Dead man's switch for rules that stop producing output
+	subscribers  subscriptions      // Consumers of the aggregated series
+	external     map[string]string  // Labels added to every aggregated series
+	running      atomic.Bool        // Set between Start and Stop
+	pool         *workerPool        // Sizes the workers processing the input queue
+	scalerDone   chan struct{}      // Closed when the worker scaler exits
+	timestamps   string             // Position of output samples within their interval
+	jitter       time.Duration      // Window over which the flushes of rules are spread
+	comparisons  *comparisonHistory // Input and output of the last flushed intervals of each rule
+	plugins      *transformPlugins  // Transform plugins applied to samples and aggregated series
 }
 
+// Sampled logs for drops on the hot path
+var (
+	droppedInputLog    = logger.NewSampler(logger.Warn, "Dropped metrics")
+	relabelErrorLog    = logger.NewSampler(logger.Error, "Failed to relabel aggregated metric")
+	expressionErrorLog = logger.NewSampler(logger.Error, "Failed to evaluate rule expression")
+)
+
 // Ensure Processor implements the MetricProcessor interface
 var _ types.MetricProcessor = (*Processor)(nil)
 
@@ -40,28 +67,73 @@ type aggregationBucket struct {
 	metrics   map[string][]*models.MetricSample // key is the segmentation key
 	startTime time.Time
 	endTime   time.Time
+	// spanContexts are the traced processing spans that inserted samples,
+	// linked from the flush span
+	spanContexts []trace.SpanContext
+	// counter is set when the bucket sums counter increases, which are
+	// emitted as cumulative totals
+	counter bool
+	// flushAt is when the bucket is flushed: its end, plus the aggregation
+	// delay and the flush jitter of its rule
+	flushAt time.Time
 }
 
 // NewProcessor creates a new metrics aggregation processor
 func NewProcessor(cfg *config.Config, ruleEngine *rules.Engine, apiHandler MetricTracker) (*Processor, error) {
 	processor := &Processor{
-		cfg:        cfg,
-		ruleEngine: ruleEngine,
-		buckets:    make(map[string]*aggregationBucket),
-		inputCh:    make(chan *models.MetricSample, cfg.Aggregator.BatchSize),
-		outputCh:   make(chan *models.AggregatedMetric, cfg.Aggregator.BatchSize),
-		stopCh:     make(chan struct{}),
-		apiHandler: apiHandler,
+		cfg:         cfg,
+		ruleEngine:  ruleEngine,
+		buckets:     make(map[string]*aggregationBucket),
+		inputCh:     make(chan *models.MetricSample, cfg.Aggregator.BatchSize),
+		stopCh:      make(chan struct{}),
+		flusherDone: make(chan struct{}),
+		apiHandler:  apiHandler,
+		counters: newCounterTracker(counterLimits{
+			ttl:       time.Duration(cfg.Aggregator.CounterStateTTLSeconds) * time.Second,
+			maxSeries: cfg.Aggregator.CounterStateMaxSeries,
+			eviction:  cfg.Aggregator.CounterStateEviction,
+		}),
+		watch:       newOutputWatch(cfg.Aggregator.DeadMansSwitchIntervals),
+		external:    cfg.ExternalLabels,
+		pool:        newWorkerPool(cfg.Aggregator),
+		timestamps:  cfg.Aggregator.OutputTimestamp,
+		jitter:      time.Duration(cfg.Aggregator.FlushJitterMs) * time.Millisecond,
+		comparisons: newComparisonHistory(),
+	}
+	if err := validOutputTimestamp(processor.timestamps); err != nil {
+		return nil, err
 	}
+	plugins, err := loadTransformPlugins(cfg.Aggregator.TransformPlugins)
+	if err != nil {
+		return nil, err
+	}
+	processor.plugins = plugins
 
 	// Initialize remote write client if enabled
 	if cfg.RemoteWrite.Enabled && len(cfg.RemoteWrite.Endpoints) > 0 {
 		var err error
 		processor.remoteWriter, err = remote.NewClient(&cfg.RemoteWrite)
 		if err != nil {
-			fmt.Printf("Warning: Failed to initialize remote write client: %v\n", err)
 			// Continue without remote write
+			logger.LogWarnWithFields("Failed to initialize remote write client", logger.Fields{
+				"endpoints": cfg.RemoteWrite.Endpoints,
+				"error":     err.Error(),
+			})
+		}
+	}
+
+	// Join the other instances if sharding is enabled
+	if cfg.Sharding.Enabled {
+		// The elected replica of an HA pair is tracked by each instance, so
+		// instances receiving different replicas would both accept them
+		if cfg.Ingest.HATracker.Enabled {
+			return nil, fmt.Errorf("ingest.ha_tracker cannot be enabled with sharding, since replicas are elected by each instance")
 		}
+		cluster, err := sharding.NewCluster(cfg.Sharding, processor.ProcessMetric)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize sharding: %w", err)
+		}
+		processor.cluster = cluster
 	}
 
 	return processor, nil
@@ -75,39 +147,73 @@ func (p *Processor) Start() {
 	}
 
 	// Start worker goroutines
-	for i := 0; i < p.cfg.Aggregator.WorkerCount; i++ {
-		p.workerWg.Add(1)
-		go p.worker()
-	}
+	p.startWorkers()
 	// Start aggregator goroutine
 	go p.aggregator()
+	// Receive samples from peers
+	if p.cluster != nil {
+		p.cluster.Start()
+	}
+	p.running.Store(true)
 }
 
-// Stop stops the aggregation processor
+// Stop stops the aggregation processor. Queued samples are processed and
+// all open buckets flushed before the remote write client sends what is left
+// in its queue, so nothing accepted before Stop is lost.
 func (p *Processor) Stop() {
+	started := p.running.Swap(false)
+	// Samples forwarded by peers are queued before the workers drain the queue
+	if p.cluster != nil {
+		p.cluster.StopReceiving()
+	}
 	close(p.stopCh)
+	// The scaler must not start workers once they are being waited for
+	if p.scalerDone != nil {
+		<-p.scalerDone
+	}
 	p.workerWg.Wait()
+	if started {
+		<-p.flusherDone
+	}
+
+	// Flush buckets whose interval has not ended yet
+	if flushed := p.flushBuckets(true); flushed > 0 {
+		logger.LogInfoWithFields("Flushed open aggregation buckets", logger.Fields{
+			"buckets": flushed,
+		})
+	}
+
+	// Flush samples pending for peers
+	if p.cluster != nil {
+		p.cluster.Stop()
+	}
 
 	// Stop the remote write client if configured
 	if p.remoteWriter != nil {
 		p.remoteWriter.Stop()
 	}
+
+	p.subscribers.close()
 }
 
-// ProcessMetric submits a metric for processing
-func (p *Processor) ProcessMetric(sample *models.MetricSample) {
-	// Track the metric's usage before processing
-	if p.apiHandler != nil {
+// Running reports whether the processor was started and not stopped
+func (p *Processor) Running() bool {
+	return p.running.Load()
+}
+
+// ProcessMetric submits a metric for processing. When the input queue is full
+// the configured overflow policy applies; it returns ErrOverloaded if the
+// sender should back off.
+func (p *Processor) ProcessMetric(sample *models.MetricSample) error {
+	metrics.RecordMetricReceived(sample)
+
+	// Track the metric's usage before processing; forwarded samples were
+	// already tracked by the instance that received them
+	if p.apiHandler != nil && !sample.Forwarded {
 		p.apiHandler.TrackMetric(sample.Name, sample.Labels, sample.Value)
 	}
 
-	select {
-	case p.inputCh <- sample:
-		// Metric submitted successfully
-	default:
-		// Channel is full, log and drop
-		fmt.Printf("Warning: Input channel full, dropping metric: %s\n", sample.Name)
-	}
+	return p.enqueue(sample)
 }
 
 // RegisterRecommendationRule registers a rule as coming from a recommendation with the remote write client
@@ -117,9 +223,20 @@ func (p *Processor) RegisterRecommendationRule(ruleID string) {
 	}
 }
 
-// GetOutputChannel returns the channel for aggregated metrics
-func (p *Processor) GetOutputChannel() <-chan *models.AggregatedMetric {
-	return p.outputCh
+// UnregisterRecommendationRule reverts RegisterRecommendationRule
+func (p *Processor) UnregisterRecommendationRule(ruleID string) {
+	if p.remoteWriter != nil {
+		p.remoteWriter.UnregisterRecommendationRule(ruleID)
+	}
+}
+
+// RemoteWriteStatus returns the health of the remote write endpoints, and
+// false if remote write is not enabled
+func (p *Processor) RemoteWriteStatus() ([]remote.EndpointStatus, bool) {
+	if p.remoteWriter == nil {
+		return nil, false
+	}
+	return p.remoteWriter.Status(), true
 }
 
 // worker processes incoming metrics
@@ -128,48 +245,178 @@ func (p *Processor) worker() {
 	for {
 		select {
 		case <-p.stopCh:
+			p.drainInput()
+			return
+		case <-p.pool.shrink:
 			return
 		case sample := <-p.inputCh:
-			p.processSample(sample)
+			p.runSample(sample)
 		}
 	}
 }
 
-// processSample processes a single metric sample
+// drainInput processes the samples left in the input queue
+func (p *Processor) drainInput() {
+	for {
+		select {
+		case sample := <-p.inputCh:
+			p.runSample(sample)
+		default:
+			return
+		}
+	}
+}
+
+// processSample processes a single metric sample. When sharding is enabled,
+// it is forwarded to the owners of the output series it is aggregated into
+// by other instances.
 func (p *Processor) processSample(sample *models.MetricSample) {
-	// Find matching rules
-	matchingRules := p.ruleEngine.FindMatchingRules(sample)
-	for _, rule := range matchingRules {
-		// Create bucket key from rule ID and interval
-		bucketKey := fmt.Sprintf("%s-%d", rule.ID, rule.Aggregation.IntervalSeconds)
-		// Get current interval
-		intervalSeconds := rule.Aggregation.IntervalSeconds
-		interval := time.Duration(intervalSeconds) * time.Second
+	// Forwarded samples went through the plugins of the instance that
+	// received them
+	if !sample.Forwarded {
+		var keep bool
+		if sample, keep = p.plugins.sample(sample); !keep {
+			return
+		}
+	}
+
+	// Only samples from traced requests are traced
+	traced := sample.SpanContext.IsSampled()
+	var ctx context.Context
+	var span, matchSpan trace.Span
+	if traced {
+		ctx = trace.ContextWithSpanContext(context.Background(), sample.SpanContext)
+		ctx, span = tracing.Tracer().Start(ctx, "aggregator.process_sample",
+			trace.WithAttributes(attribute.String("metric.name", sample.Name)))
+		defer span.End()
+		_, matchSpan = tracing.Tracer().Start(ctx, "rules.match")
+	}
+
+	// Find matching rules, which the sending peer did for forwarded samples
+	var matchingRules []*models.Rule
+	if sample.Forwarded {
+		matchingRules = p.ruleEngine.ForwardedRules(sample)
+	} else {
+		matchStart := time.Now()
+		matchingRules = p.ruleEngine.FindMatchingRules(sample)
+		metrics.RecordRuleMatching(time.Since(matchStart), len(matchingRules) > 0)
+	}
+	if traced {
+		matchSpan.SetAttributes(attribute.Int("rules.matched", len(matchingRules)))
+		matchSpan.End()
+	}
+
+	// The transforms of each rule run once, before the sample is forwarded
+	// to the owners of the series it is aggregated into
+	inputs := p.ruleInputs(sample, matchingRules)
+	if p.cluster != nil && !sample.Forwarded {
+		inputs = p.forwardRules(sample, inputs)
+	}
+
+	// Counters are summed by their increases, computed once per sample
+	var counterSample *models.MetricSample
+	if sample.Temporality != "" {
+		for _, ri := range inputs {
+			if ri.rule.Aggregation.Type == "sum" {
+				increase := *sample
+				increase.Value = p.counters.increase(sample)
+				counterSample = &increase
+				break
+			}
+		}
+	}
+
+	for _, ri := range inputs {
+		rule := ri.rule
+		var insertSpan trace.Span
+		if traced {
+			_, insertSpan = tracing.Tracer().Start(ctx, "aggregator.bucket_insert",
+				trace.WithAttributes(attribute.String("rule.id", rule.ID)))
+		}
 
 		// Calculate bucket boundaries
+		interval := time.Duration(rule.Aggregation.IntervalSeconds) * time.Second
 		now := time.Now()
-		bucketStart := now.Truncate(interval)
+		bucketStart := intervalStart(now, interval)
 		bucketEnd := bucketStart.Add(interval)
+		// Buckets are keyed by their interval, so that a bucket waiting for
+		// the aggregation delay is not replaced by the next one
+		bucketKey := fmt.Sprintf("%s-%d-%d", rule.ID, rule.Aggregation.IntervalSeconds, bucketStart.UnixMilli())
+
+		// Sum rules of counters aggregate the increases
+		input, counter := ri.input, false
+		if counterSample != nil && rule.Aggregation.Type == "sum" {
+			increase := *input
+			increase.Value = counterSample.Value
+			input, counter = &increase, true
+		}
+
 		// Add to appropriate bucket
 		p.bucketMu.Lock()
 		bucket, exists := p.buckets[bucketKey]
-		if !exists || bucket.endTime.Before(now) {
-			// Create new bucket if it doesn't exist or the existing one is expired
+		if !exists {
 			bucket = &aggregationBucket{
 				rule:      rule,
 				metrics:   make(map[string][]*models.MetricSample),
 				startTime: bucketStart,
 				endTime:   bucketEnd,
+				flushAt:   bucketEnd.Add(p.flushDelay() + flushJitter(rule.ID, p.jitter)),
 			}
 			p.buckets[bucketKey] = bucket
 		}
+		p.watch.input(rule, now)
 		// Generate segmentation key from sample labels
-		segmentKey := p.generateSegmentKey(sample, rule.Aggregation.Segmentation)
+		segmentKey := p.generateSegmentKey(input, rule.GroupingLabels())
 		// Add the sample to the bucket
-		bucket.metrics[segmentKey] = append(bucket.metrics[segmentKey], sample)
+		bucket.metrics[segmentKey] = append(bucket.metrics[segmentKey], input)
+		if counter {
+			bucket.counter = true
+		}
+		if traced && len(bucket.spanContexts) < tracing.MaxLinks {
+			bucket.spanContexts = append(bucket.spanContexts, span.SpanContext())
+		}
 
 		p.bucketMu.Unlock()
+
+		if traced {
+			insertSpan.End()
+		}
+	}
+}
+
+// ruleSample is a rule matching a sample, with the sample as the rule
+// aggregates it
+type ruleSample struct {
+	rule  *models.Rule
+	input *models.MetricSample
+}
+
+// ruleInputs returns the samples the matching rules aggregate, without the
+// rules whose WASM transform drops the sample
+func (p *Processor) ruleInputs(sample *models.MetricSample, matchingRules []*models.Rule) []ruleSample {
+	inputs := make([]ruleSample, 0, len(matchingRules))
+	for _, rule := range matchingRules {
+		if input := p.ruleInput(rule, sample); input != nil {
+			inputs = append(inputs, ruleSample{rule: rule, input: input})
+		}
 	}
+	return inputs
+}
+
+// ruleInput returns a sample as aggregated by a rule, or nil if its WASM
+// transform drops it
+func (p *Processor) ruleInput(rule *models.Rule, input *models.MetricSample) *models.MetricSample {
+	// Samples of family sources are grouped with the labels of the family
+	input = rule.Matcher.ApplyFamily(input)
+	if rule.WasmTransform != "" {
+		transform, _ := p.ruleEngine.GetWasmTransform(rule.WasmTransform)
+		if input = applyWasmTransform(rule, transform, input); input == nil {
+			return nil
+		}
+	}
+	// Personal data in label values is hashed or truncated before it is
+	// grouped by or written
+	return transformLabels(input, rule.LabelTransforms)
 }
 
 // generateSegmentKey creates a key for segmenting metrics during aggregation
@@ -190,6 +437,7 @@ func (p *Processor) generateSegmentKey(sample *models.MetricSample, segmentBy []
 
 // aggregator periodically aggregates metrics in buckets
 func (p *Processor) aggregator() {
+	defer close(p.flusherDone)
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 	for {
@@ -198,72 +446,290 @@ func (p *Processor) aggregator() {
 			return
 		case <-ticker.C:
 			p.aggregateBuckets()
+			p.counters.evictStale()
+			p.expireRules()
+			p.checkOutputs()
+			p.updateGauges()
+		}
+	}
+}
+
+// updateGauges refreshes the metrics of the input queue, open buckets and
+// active rules, and drops the interval comparisons of deleted rules
+func (p *Processor) updateGauges() {
+	metrics.UpdateQueue(metrics.QueueInput, len(p.inputCh), cap(p.inputCh))
+
+	p.bucketMu.RLock()
+	metrics.UpdateAggregationBucketsCount(len(p.buckets))
+	p.bucketMu.RUnlock()
+
+	rules, err := p.ruleEngine.GetRules()
+	if err != nil {
+		return
+	}
+	now, active := time.Now(), 0
+	known := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		known[rule.ID] = true
+		if rule.Enabled && !rule.Expired(now) {
+			active++
+		}
+	}
+	metrics.UpdateActiveRulesCount(active)
+	p.comparisons.forget(known)
+}
+
+// expireRules disables temporary rules whose expiry time has passed
+func (p *Processor) expireRules() {
+	expired, err := p.ruleEngine.ExpireRules(time.Now())
+	for _, rule := range expired {
+		metrics.RecordRuleExpired()
+		logger.LogInfoWithFields("Temporary rule expired and was disabled", logger.Fields{
+			"rule_id":    rule.ID,
+			"rule_name":  rule.Name,
+			"expires_at": rule.ExpiresAt.Format(time.RFC3339),
+		})
+	}
+	if err != nil {
+		logger.LogErrorWithFields("Failed to persist expired rules", logger.Fields{
+			"error": err.Error(),
+		})
+	}
+}
+
+// checkOutputs suspends dropping the original metrics of rules that receive
+// input but stopped producing output, so their data is not silently lost
+func (p *Processor) checkOutputs() {
+	now := time.Now()
+	// Output of a rule may be held back by up to the delay and the jitter
+	delay := p.flushDelay() + p.jitter
+
+	for _, id := range p.watch.stalledRules(now, delay) {
+		metrics.RecordRuleOutputStalled(id)
+		logger.LogWarnWithFields("Rule receives input but stopped producing output", logger.Fields{
+			"rule_id":   id,
+			"intervals": p.cfg.Aggregator.DeadMansSwitchIntervals,
+		})
+
+		reason := fmt.Sprintf("no output for %d aggregation intervals while input was arriving", p.cfg.Aggregator.DeadMansSwitchIntervals)
+		rule, err := p.ruleEngine.SuspendDrops(id, reason, now)
+		if err != nil {
+			logger.LogErrorWithFields("Failed to persist suspended rule", logger.Fields{
+				"rule_id": id,
+				"error":   err.Error(),
+			})
+		}
+		if rule != nil {
+			logger.LogErrorWithFields("Suspended dropping the original metrics of a rule without output", logger.Fields{
+				"rule_id":   rule.ID,
+				"rule_name": rule.Name,
+				"reason":    reason,
+			})
 		}
 	}
 }
 
+// flushDelay is how long buckets are kept open after their interval ends,
+// for late samples
+func (p *Processor) flushDelay() time.Duration {
+	return time.Duration(p.cfg.Aggregator.AggregationDelayMs) * time.Millisecond
+}
+
 // aggregateBuckets aggregates metrics in completed buckets
 func (p *Processor) aggregateBuckets() {
+	p.flushBuckets(false)
+}
+
+// flushBuckets aggregates and removes the buckets whose interval and delay
+// have passed, or all buckets if force is set, and returns how many it flushed
+func (p *Processor) flushBuckets(force bool) int {
+	done := metrics.TrackDuration("flush")
 	now := time.Now()
 
-	// Calculate the delay for aggregation
-	delayDuration := time.Duration(p.cfg.Aggregator.AggregationDelayMs) * time.Millisecond
+	// Take the buckets that are ready out of the map, so that samples keep
+	// being added to the others while these are evaluated and written
+	var ready []*aggregationBucket
 	p.bucketMu.Lock()
-	defer p.bucketMu.Unlock()
-	// Check for buckets that are ready for aggregation
 	for key, bucket := range p.buckets {
-		// Skip if not yet expired or not past the delay
-		if now.Before(bucket.endTime.Add(delayDuration)) {
+		// Skip if not yet past the delay and jitter
+		if !force && now.Before(bucket.flushAt) {
 			continue
 		}
-		// Process each segment in the bucket
-		for segmentKey, samples := range bucket.metrics {
-			if len(samples) == 0 {
-				continue
-			}
-			// Aggregate the samples
-			aggValue := p.aggregateSamples(samples, bucket.rule.Aggregation.Type)
+		ready = append(ready, bucket)
+		delete(p.buckets, key)
+	}
+	p.bucketMu.Unlock()
+
+	for _, bucket := range ready {
+		// Trace flushes of buckets holding traced samples as part of the first
+		// sample's trace, linked to the processing spans of all of them
+		var flushSpan trace.Span
+		var flushSpanContext trace.SpanContext
+		if len(bucket.spanContexts) > 0 {
+			ctx := trace.ContextWithSpanContext(context.Background(), bucket.spanContexts[0])
+			_, flushSpan = tracing.Tracer().Start(ctx, "aggregator.flush",
+				trace.WithLinks(tracing.Links(bucket.spanContexts[1:])...),
+				trace.WithAttributes(
+					attribute.String("rule.id", bucket.rule.ID),
+					attribute.Int("segments", len(bucket.metrics)),
+				))
+			flushSpanContext = flushSpan.SpanContext()
+		}
 
-			// Create labels map from segmentation key
-			labels := p.parseSegmentKey(segmentKey)
+		outputs := p.bucketOutputs(bucket)
+		p.comparisons.record(bucket, outputs, now)
 
-			// Add any additional labels from the rule
-			for k, v := range bucket.rule.Output.AdditionalLabels {
-				labels[k] = v
-			}
-			// Create aggregated metric
-			aggMetric := &models.AggregatedMetric{
-				Name:       bucket.rule.Output.MetricName,
-				Value:      aggValue,
-				StartTime:  bucket.startTime,
-				EndTime:    bucket.endTime,
-				Labels:     labels,
-				SourceRule: bucket.rule.ID,
-				Count:      len(samples),
-			}
+		shadowSeries, shadowSamples := 0, 0
+		for _, aggMetric := range outputs {
+			aggMetric.SpanContext = flushSpanContext
+			p.watch.output(bucket.rule.ID, now)
 
 			// Also track the aggregated metric for usage patterns
 			if p.apiHandler != nil {
 				p.apiHandler.TrackMetric(aggMetric.Name, aggMetric.Labels, aggMetric.Value)
 			}
 
+			// Shadow rules are only measured, never written downstream
+			if bucket.rule.Shadow {
+				shadowSeries++
+				shadowSamples += aggMetric.Count
+				continue
+			}
+
+			metrics.RecordMetricAggregated(aggMetric)
+
 			// Send to remote write if enabled
 			if p.remoteWriter != nil {
 				p.remoteWriter.Write(aggMetric)
 			}
 
-			// Send to output channel
-			select {
-			case p.outputCh <- aggMetric:
-				// Sent successfully
-			default:
-				// Channel full, log and drop
-				fmt.Printf("Warning: Output channel full, dropping aggregated metric: %s\n", aggMetric.Name)
+			// Fan out to subscribers
+			p.subscribers.publish(aggMetric)
+		}
+		if bucket.rule.Shadow {
+			metrics.RecordShadowAggregation(bucket.rule.ID, shadowSeries, shadowSamples)
+		}
+		if flushSpan != nil {
+			flushSpan.End()
+		}
+	}
+	if len(ready) > 0 {
+		done()
+	}
+	return len(ready)
+}
+
+// bucketOutputs computes the aggregated series of a bucket, shaped by the
+// rule's output relabeling
+func (p *Processor) bucketOutputs(bucket *aggregationBucket) []*models.AggregatedMetric {
+	var outputs []*models.AggregatedMetric
+	if bucket.rule.Aggregation.Type == models.AggregationPromQL {
+		var err error
+		outputs, err = evaluateExpression(bucket)
+		if err != nil {
+			expressionErrorLog.Log(logger.Fields{
+				"rule_id": bucket.rule.ID,
+				"error":   err.Error(),
+			})
+		}
+	} else {
+		outputs = p.aggregateSegments(bucket)
+	}
+
+	kept := outputs[:0]
+	for _, aggMetric := range outputs {
+		// Counters are emitted as cumulative totals, which gauge semantics do not describe
+		if semantics := bucket.rule.Output.GaugeSemantics; semantics != "" && !bucket.counter && aggMetric.Name == bucket.rule.Output.MetricName {
+			aggMetric.Semantics = semantics
+			aggMetric.Labels[models.GaugeSemanticsLabel] = models.GaugeSemanticsFunction(semantics)
+		}
+		keep, err := relabel(aggMetric, bucket.rule.Output.Relabeling)
+		if err != nil {
+			relabelErrorLog.Log(logger.Fields{
+				"rule_id": bucket.rule.ID,
+				"error":   err.Error(),
+			})
+			metrics.RecordDroppedSamples(metrics.StageAggregation, "relabel_error", 1)
+			continue
+		}
+		if keep {
+			aggMetric.Timestamp = outputTimestamp(p.timestamps, bucket.startTime, bucket.endTime)
+			aggMetric.Target = bucket.rule.Output.RemoteWriteTarget
+			addExternalLabels(aggMetric, p.external)
+			if !p.plugins.output(aggMetric) {
+				continue
 			}
+			kept = append(kept, aggMetric)
+		}
+	}
+	return kept
+}
+
+// addExternalLabels adds the global external labels to an aggregated series.
+// As in Prometheus, labels the series already has take precedence.
+func addExternalLabels(metric *models.AggregatedMetric, external map[string]string) {
+	for name, value := range external {
+		if _, exists := metric.Labels[name]; exists {
+			continue
+		}
+		if metric.Labels == nil {
+			metric.Labels = make(map[string]string, len(external))
+		}
+		metric.Labels[name] = value
+	}
+}
+
+// aggregateSegments aggregates the samples of each segment of a bucket into
+// one series
+func (p *Processor) aggregateSegments(bucket *aggregationBucket) []*models.AggregatedMetric {
+	outputs := make([]*models.AggregatedMetric, 0, len(bucket.metrics))
+	for segmentKey, samples := range bucket.metrics {
+		if len(samples) == 0 {
+			continue
+		}
+		// Aggregate the samples
+		aggValue := p.aggregateSamples(samples, bucket.rule.Aggregation.Type)
+		if bucket.counter {
+			// Emit counters as cumulative totals for Prometheus
+			aggValue = p.counters.accumulate(bucket.rule.ID+"/"+segmentKey, aggValue)
+		}
+
+		// Only the grouping labels survive onto the aggregated series
+		labels := segmentLabels(samples[0], bucket.rule.GroupingLabels())
+
+		// Add any additional labels from the rule
+		for k, v := range bucket.rule.Output.AdditionalLabels {
+			labels[k] = v
+		}
+		outputs = append(outputs, &models.AggregatedMetric{
+			Name:       bucket.rule.Output.MetricName,
+			Value:      aggValue,
+			StartTime:  bucket.startTime,
+			EndTime:    bucket.endTime,
+			Labels:     labels,
+			SourceRule: bucket.rule.ID,
+			Count:      len(samples),
+		})
+
+		// Statistics are computed from the same samples. Counter buckets hold
+		// increases, which only the sum of the rule accumulates.
+		for _, stat := range bucket.rule.Output.Statistics {
+			statLabels := make(map[string]string, len(labels))
+			for k, v := range labels {
+				statLabels[k] = v
+			}
+			outputs = append(outputs, &models.AggregatedMetric{
+				Name:       bucket.rule.StatisticMetricName(stat),
+				Value:      p.aggregateSamples(samples, stat.Type),
+				StartTime:  bucket.startTime,
+				EndTime:    bucket.endTime,
+				Labels:     statLabels,
+				SourceRule: bucket.rule.ID,
+				Count:      len(samples),
+			})
 		}
-		// Remove the processed bucket
-		delete(p.buckets, key)
 	}
+	return outputs
 }
 
 // aggregateSamples aggregates metric samples based on the specified type
@@ -302,6 +768,24 @@ func (p *Processor) aggregateSamples(samples []*models.MetricSample, aggType str
 		return max
 	case "count":
 		return float64(len(samples))
+	case "last":
+		// The most recent sample by timestamp; the latest to arrive on ties
+		last := samples[0]
+		for _, sample := range samples[1:] {
+			if !sample.Timestamp.Before(last.Timestamp) {
+				last = sample
+			}
+		}
+		return last.Value
+	case "first":
+		// The oldest sample by timestamp; the earliest to arrive on ties
+		first := samples[0]
+		for _, sample := range samples[1:] {
+			if sample.Timestamp.Before(first.Timestamp) {
+				first = sample
+			}
+		}
+		return first.Value
 	default:
 		// Default to sum if unrecognized
 		var sum float64
@@ -312,16 +796,14 @@ func (p *Processor) aggregateSamples(samples []*models.MetricSample, aggType str
 	}
 }
 
-// parseSegmentKey parses a segment key back into a labels map
-func (p *Processor) parseSegmentKey(segmentKey string) map[string]string {
-	if segmentKey == "_all_" {
-		return make(map[string]string)
+// segmentLabels returns the grouping labels of a segment from one of its samples.
+// All samples of a segment share these values; missing labels are omitted.
+func segmentLabels(sample *models.MetricSample, groupBy []string) map[string]string {
+	labels := make(map[string]string, len(groupBy))
+	for _, label := range groupBy {
+		if value := sample.Labels[label]; value != "" {
+			labels[label] = value
+		}
 	}
-	// Placeholder implementation - in practice would need to parse the segment key format
-	// This is simplified for demonstration
-	labels := make(map[string]string)
-	// Parse segment key format "key1=value1,key2=value2"
-	// Actual implementation would depend on the format of segmentKey
-
 	return labels
 }
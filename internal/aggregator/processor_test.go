@@ -0,0 +1,268 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/transform"
+)
+
+func TestSegmentLabels_KeepLabels(t *testing.T) {
+	rule := &models.Rule{
+		Aggregation: models.AggregationConfig{Segmentation: []string{"service"}},
+		Output:      models.OutputConfig{KeepLabels: []string{"service", "region"}},
+	}
+	sample := &models.MetricSample{
+		Name:   "http_requests_total",
+		Labels: map[string]string{"service": "checkout", "region": "eu", "pod": "checkout-1"},
+	}
+
+	labels := segmentLabels(sample, rule.GroupingLabels())
+	if len(labels) != 2 || labels["service"] != "checkout" || labels["region"] != "eu" {
+		t.Errorf("segmentLabels() = %v, want service and region only", labels)
+	}
+
+	// Without keep labels, segmentation labels are kept
+	rule.Output.KeepLabels = nil
+	labels = segmentLabels(sample, rule.GroupingLabels())
+	if len(labels) != 1 || labels["service"] != "checkout" {
+		t.Errorf("segmentLabels() = %v, want service only", labels)
+	}
+}
+
+type recordingTracker struct {
+	tracked []string
+}
+
+func (r *recordingTracker) TrackMetric(name string, labels map[string]string, value float64) {
+	r.tracked = append(r.tracked, name)
+}
+
+func TestProcessor_AggregateBuckets_Shadow(t *testing.T) {
+	tracker := &recordingTracker{}
+	p := &Processor{
+		cfg:        &config.Config{},
+		buckets:    make(map[string]*aggregationBucket),
+		apiHandler: tracker,
+	}
+	output, unsubscribe := p.Subscribe("test", SubscribeAll, 10)
+	defer unsubscribe()
+
+	end := time.Now().Add(-time.Minute)
+	for _, shadow := range []bool{true, false} {
+		rule := &models.Rule{
+			ID:          "rule",
+			Shadow:      shadow,
+			Aggregation: models.AggregationConfig{Type: "sum"},
+			Output:      models.OutputConfig{MetricName: "requests_aggregated"},
+		}
+		p.buckets["rule"] = &aggregationBucket{
+			rule: rule,
+			metrics: map[string][]*models.MetricSample{
+				"": {{Name: "requests", Value: 1}, {Name: "requests", Value: 2}},
+			},
+			startTime: end.Add(-time.Minute),
+			endTime:   end,
+		}
+		p.aggregateBuckets()
+	}
+
+	// Both rules are measured, but only the regular rule is written
+	if len(tracker.tracked) != 2 {
+		t.Errorf("tracked %d aggregated metrics, want 2", len(tracker.tracked))
+	}
+	if len(output) != 1 {
+		t.Fatalf("subscription holds %d metrics, want 1", len(output))
+	}
+	if metric := <-output; metric.Value != 3 {
+		t.Errorf("aggregated value = %v, want 3", metric.Value)
+	}
+}
+
+func TestProcessor_Stop_FlushesOpenBuckets(t *testing.T) {
+	p := &Processor{
+		cfg:         &config.Config{},
+		buckets:     make(map[string]*aggregationBucket),
+		inputCh:     make(chan *models.MetricSample, 1),
+		stopCh:      make(chan struct{}),
+		flusherDone: make(chan struct{}),
+	}
+	output, _ := p.Subscribe("test", SubscribeAll, 10)
+
+	// A bucket whose interval has not ended yet
+	p.buckets["rule"] = &aggregationBucket{
+		rule: &models.Rule{
+			ID:          "rule",
+			Aggregation: models.AggregationConfig{Type: "sum"},
+			Output:      models.OutputConfig{MetricName: "requests_aggregated"},
+		},
+		metrics: map[string][]*models.MetricSample{
+			"": {{Name: "requests", Value: 1}, {Name: "requests", Value: 2}},
+		},
+		startTime: time.Now(),
+		endTime:   time.Now().Add(time.Minute),
+	}
+	p.Stop()
+
+	if len(p.buckets) != 0 {
+		t.Errorf("%d buckets left after Stop, want 0", len(p.buckets))
+	}
+	if metric, ok := <-output; !ok || metric.Value != 3 {
+		t.Errorf("flushed metric = %+v, want value 3", metric)
+	}
+}
+
+func TestProcessor_FlushBuckets_ReleasesBuckets(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	p := &Processor{
+		cfg:     &config.Config{},
+		buckets: make(map[string]*aggregationBucket),
+		plugins: &transformPlugins{outputs: []outputTransform{{path: "slow.so", fn: func(*transform.Output) bool {
+			close(entered)
+			<-release
+			return true
+		}}}},
+	}
+	p.buckets["rule"] = &aggregationBucket{
+		rule: &models.Rule{
+			ID:          "rule",
+			Aggregation: models.AggregationConfig{Type: "sum"},
+			Output:      models.OutputConfig{MetricName: "requests_aggregated"},
+		},
+		metrics:   map[string][]*models.MetricSample{"": {{Name: "requests", Value: 1}}},
+		startTime: time.Now().Add(-2 * time.Minute),
+		endTime:   time.Now().Add(-time.Minute),
+	}
+
+	flushed := make(chan int)
+	go func() { flushed <- p.flushBuckets(false) }()
+	<-entered
+
+	// Samples are added to buckets while flushed buckets are written
+	locked := make(chan struct{})
+	go func() {
+		p.bucketMu.Lock()
+		p.bucketMu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("buckets are locked while flushed buckets are written")
+	}
+	close(release)
+	if n := <-flushed; n != 1 {
+		t.Errorf("flushBuckets() = %d, want 1", n)
+	}
+}
+
+func TestProcessor_BucketOutputs_ExternalLabels(t *testing.T) {
+	p := &Processor{external: map[string]string{"cluster": "prod", "region": "eu"}}
+	bucket := &aggregationBucket{
+		rule: &models.Rule{
+			ID:          "rule",
+			Aggregation: models.AggregationConfig{Type: "sum", Segmentation: []string{"service"}},
+			Output: models.OutputConfig{
+				MetricName:       "requests_aggregated",
+				AdditionalLabels: map[string]string{"region": "us"},
+			},
+		},
+		metrics: map[string][]*models.MetricSample{
+			"api": {{Name: "requests", Value: 1, Labels: map[string]string{"service": "api"}}},
+		},
+	}
+
+	outputs := p.bucketOutputs(bucket)
+	if len(outputs) != 1 {
+		t.Fatalf("bucketOutputs() returned %d series, want 1", len(outputs))
+	}
+	want := map[string]string{"service": "api", "cluster": "prod", "region": "us"}
+	for name, value := range want {
+		if got := outputs[0].Labels[name]; got != value {
+			t.Errorf("label %s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestProcessor_BucketOutputs_GaugeSemantics(t *testing.T) {
+	p := &Processor{counters: newCounterTracker(counterLimits{})}
+	bucket := &aggregationBucket{
+		rule: &models.Rule{
+			ID:          "rule",
+			Aggregation: models.AggregationConfig{Type: "max", Segmentation: []string{"service"}},
+			Output:      models.OutputConfig{MetricName: "memory_bytes:max", GaugeSemantics: models.GaugeSemanticsMax},
+		},
+		metrics: map[string][]*models.MetricSample{
+			"api": {{Name: "memory_bytes", Value: 1, Labels: map[string]string{"service": "api"}}},
+		},
+	}
+
+	outputs := p.bucketOutputs(bucket)
+	if len(outputs) != 1 || outputs[0].Semantics != models.GaugeSemanticsMax || outputs[0].Labels[models.GaugeSemanticsLabel] != "max_over_time" {
+		t.Fatalf("bucketOutputs() = %+v, want one series with max semantics", outputs)
+	}
+
+	// Counters are not gauges
+	bucket.counter = true
+	if outputs := p.bucketOutputs(bucket); outputs[0].Semantics != "" {
+		t.Errorf("counter semantics = %q, want none", outputs[0].Semantics)
+	}
+}
+
+func TestProcessor_AggregateSamples_LastFirst(t *testing.T) {
+	p := &Processor{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Samples arrive out of timestamp order
+	samples := []*models.MetricSample{
+		{Value: 2, Timestamp: start.Add(20 * time.Second)},
+		{Value: 3, Timestamp: start.Add(40 * time.Second)},
+		{Value: 1, Timestamp: start},
+		{Value: 4, Timestamp: start.Add(30 * time.Second)},
+	}
+
+	if got := p.aggregateSamples(samples, "last"); got != 3 {
+		t.Errorf("last = %v, want 3", got)
+	}
+	if got := p.aggregateSamples(samples, "first"); got != 1 {
+		t.Errorf("first = %v, want 1", got)
+	}
+}
+
+func TestProcessor_BucketOutputs_Statistics(t *testing.T) {
+	p := &Processor{}
+	bucket := &aggregationBucket{
+		rule: &models.Rule{
+			ID:          "rule",
+			Aggregation: models.AggregationConfig{Type: "sum", Segmentation: []string{"service"}},
+			Output: models.OutputConfig{
+				MetricName: "request_bytes",
+				Statistics: []models.OutputStatistic{{Type: "count"}, {Type: "max", MetricName: "request_bytes:max"}},
+			},
+		},
+		metrics: map[string][]*models.MetricSample{
+			"api": {
+				{Name: "request_bytes", Value: 1, Labels: map[string]string{"service": "api"}},
+				{Name: "request_bytes", Value: 5, Labels: map[string]string{"service": "api"}},
+			},
+		},
+	}
+
+	got := make(map[string]float64)
+	for _, output := range p.bucketOutputs(bucket) {
+		if output.Labels["service"] != "api" {
+			t.Errorf("%s labels = %v, want the segment labels", output.Name, output.Labels)
+		}
+		got[output.Name] = output.Value
+	}
+	want := map[string]float64{"request_bytes": 6, "request_bytes_count": 2, "request_bytes:max": 5}
+	if len(got) != len(want) {
+		t.Fatalf("bucketOutputs() = %v, want %v", got, want)
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %v, want %v", name, got[name], value)
+		}
+	}
+}
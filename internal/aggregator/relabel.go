@@ -0,0 +1,142 @@
+package aggregator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// relabelRegexCache holds compiled relabeling regexes by expression
+var relabelRegexCache sync.Map
+
+// relabelRegex returns the anchored, compiled regex of a relabeling configuration
+func relabelRegex(cfg *models.RelabelConfig) (*regexp.Regexp, error) {
+	expr := cfg.Regex
+	if expr == "" {
+		expr = models.DefaultRelabelRegex
+	}
+	if re, ok := relabelRegexCache.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, err
+	}
+	relabelRegexCache.Store(expr, re)
+
+	return re, nil
+}
+
+// relabel applies the output relabeling of a rule to an aggregated metric.
+// The metric name is exposed as the __name__ label. It returns false if the
+// metric was dropped.
+func relabel(metric *models.AggregatedMetric, configs []models.RelabelConfig) (bool, error) {
+	if len(configs) == 0 {
+		return true, nil
+	}
+
+	labels := make(map[string]string, len(metric.Labels)+1)
+	for k, v := range metric.Labels {
+		labels[k] = v
+	}
+	labels[models.MetricNameLabel] = metric.Name
+
+	for i := range configs {
+		keep, err := relabelStep(labels, &configs[i])
+		if err != nil {
+			return false, fmt.Errorf("relabeling %d: %w", i, err)
+		}
+		if !keep {
+			return false, nil
+		}
+	}
+
+	metric.Name = labels[models.MetricNameLabel]
+	delete(labels, models.MetricNameLabel)
+	metric.Labels = labels
+
+	return metric.Name != "", nil
+}
+
+// relabelStep applies one relabeling configuration to labels in place
+func relabelStep(labels map[string]string, cfg *models.RelabelConfig) (bool, error) {
+	re, err := relabelRegex(cfg)
+	if err != nil {
+		return false, err
+	}
+
+	separator := cfg.Separator
+	if separator == "" {
+		separator = models.DefaultRelabelSeparator
+	}
+	replacement := cfg.Replacement
+	if replacement == "" {
+		replacement = models.DefaultRelabelReplacement
+	}
+
+	values := make([]string, len(cfg.SourceLabels))
+	for i, name := range cfg.SourceLabels {
+		values[i] = labels[name]
+	}
+	value := strings.Join(values, separator)
+
+	switch cfg.ActionOrDefault() {
+	case models.RelabelKeep:
+		return re.MatchString(value), nil
+	case models.RelabelDrop:
+		return !re.MatchString(value), nil
+	case models.RelabelReplace:
+		match := re.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true, nil
+		}
+		target := string(re.ExpandString(nil, cfg.TargetLabel, value, match))
+		result := string(re.ExpandString(nil, replacement, value, match))
+		if result == "" {
+			delete(labels, target)
+		} else {
+			labels[target] = result
+		}
+	case models.RelabelHashMod:
+		labels[cfg.TargetLabel] = fmt.Sprintf("%d", xxhash.Sum64String(value)%cfg.Modulus)
+	case models.RelabelHash:
+		if re.MatchString(value) {
+			labels[cfg.TargetLabel] = fmt.Sprintf("%016x", xxhash.Sum64String(value))[:8]
+		}
+	case models.RelabelLowercase:
+		labels[cfg.TargetLabel] = strings.ToLower(value)
+	case models.RelabelUppercase:
+		labels[cfg.TargetLabel] = strings.ToUpper(value)
+	case models.RelabelLabelMap:
+		mapped := make(map[string]string)
+		for name, v := range labels {
+			if match := re.FindStringSubmatchIndex(name); match != nil {
+				mapped[string(re.ExpandString(nil, replacement, name, match))] = v
+			}
+		}
+		for name, v := range mapped {
+			labels[name] = v
+		}
+	case models.RelabelLabelDrop:
+		for name := range labels {
+			if name != models.MetricNameLabel && re.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case models.RelabelLabelKeep:
+		for name := range labels {
+			if name != models.MetricNameLabel && !re.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	default:
+		return false, fmt.Errorf("invalid relabel action: %s", cfg.Action)
+	}
+
+	return true, nil
+}
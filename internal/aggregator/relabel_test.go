@@ -0,0 +1,101 @@
+package aggregator
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestRelabel(t *testing.T) {
+	newMetric := func() *models.AggregatedMetric {
+		return &models.AggregatedMetric{
+			Name:   "http_requests:sum",
+			Labels: map[string]string{"svc": "checkout", "pod": "checkout-7d9f", "tmp_id": "1"},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		configs    []models.RelabelConfig
+		wantKeep   bool
+		wantName   string
+		wantLabels map[string]string
+	}{
+		{
+			name: "rename and drop labels",
+			configs: []models.RelabelConfig{
+				{SourceLabels: []string{"svc"}, TargetLabel: "service"},
+				{Regex: "svc|tmp_.*", Action: models.RelabelLabelDrop},
+			},
+			wantKeep:   true,
+			wantName:   "http_requests:sum",
+			wantLabels: map[string]string{"service": "checkout", "pod": "checkout-7d9f"},
+		},
+		{
+			name: "static replacement and metric rename",
+			configs: []models.RelabelConfig{
+				{TargetLabel: "env", Replacement: "prod"},
+				{SourceLabels: []string{"__name__"}, Regex: "(.*):sum", TargetLabel: "__name__", Replacement: "${1}_total"},
+				{Regex: "env|svc", Action: models.RelabelLabelKeep},
+			},
+			wantKeep:   true,
+			wantName:   "http_requests_total",
+			wantLabels: map[string]string{"env": "prod", "svc": "checkout"},
+		},
+		{
+			name: "hash high-cardinality value",
+			configs: []models.RelabelConfig{
+				{SourceLabels: []string{"pod"}, TargetLabel: "pod", Action: models.RelabelHashMod, Modulus: 1},
+			},
+			wantKeep:   true,
+			wantName:   "http_requests:sum",
+			wantLabels: map[string]string{"svc": "checkout", "pod": "0", "tmp_id": "1"},
+		},
+		{
+			name: "drop series",
+			configs: []models.RelabelConfig{
+				{SourceLabels: []string{"svc"}, Regex: "check.*", Action: models.RelabelDrop},
+			},
+			wantKeep: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric := newMetric()
+			keep, err := relabel(metric, tt.configs)
+			if err != nil {
+				t.Fatalf("relabel() error = %v", err)
+			}
+			if keep != tt.wantKeep {
+				t.Fatalf("relabel() keep = %v, want %v", keep, tt.wantKeep)
+			}
+			if !keep {
+				return
+			}
+			if metric.Name != tt.wantName {
+				t.Errorf("name = %q, want %q", metric.Name, tt.wantName)
+			}
+			if len(metric.Labels) != len(tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", metric.Labels, tt.wantLabels)
+			}
+			for k, v := range tt.wantLabels {
+				if metric.Labels[k] != v {
+					t.Errorf("labels[%s] = %q, want %q", k, metric.Labels[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestRelabel_Hash(t *testing.T) {
+	metric := &models.AggregatedMetric{Name: "m", Labels: map[string]string{"user": "alice"}}
+	configs := []models.RelabelConfig{{SourceLabels: []string{"user"}, TargetLabel: "user", Action: models.RelabelHash}}
+
+	if _, err := relabel(metric, configs); err != nil {
+		t.Fatalf("relabel() error = %v", err)
+	}
+	if got := metric.Labels["user"]; len(got) != 8 || got == "alice" {
+		t.Errorf("hashed user = %q, want an 8 character hash", got)
+	}
+}
@@ -0,0 +1,97 @@
+package aggregator
+
+import (
+	"sort"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+// Replayer aggregates historical samples of a rule into the series the
+// processor would have produced for them. Samples are bucketed by their
+// timestamp instead of their arrival time, and the samples of each series
+// must be added in time order.
+type Replayer struct {
+	rule     *models.Rule
+	interval time.Duration
+	buckets  map[int64]*aggregationBucket // Keyed by bucket start in milliseconds
+	// p holds the counter state of the replay, separate from live processing
+	p *Processor
+	// wasm is the WASM transform of the rule, if any
+	wasm *rules.WasmTransform
+}
+
+// NewReplayer creates a replayer for a rule, adding the external labels to
+// its series and placing their samples within the interval like live
+// processing
+func NewReplayer(rule *models.Rule, externalLabels map[string]string, outputTimestamp string) *Replayer {
+	return &Replayer{
+		rule:     rule,
+		interval: time.Duration(rule.Aggregation.IntervalSeconds) * time.Second,
+		buckets:  make(map[int64]*aggregationBucket),
+		p: &Processor{
+			counters:   newCounterTracker(counterLimits{}),
+			external:   externalLabels,
+			timestamps: outputTimestamp,
+		},
+	}
+}
+
+// SetWasmTransform sets the WASM transform the rule applies to its samples
+func (r *Replayer) SetWasmTransform(transform *rules.WasmTransform) {
+	r.wasm = transform
+}
+
+// Add adds a sample to the bucket of its timestamp
+func (r *Replayer) Add(sample *models.MetricSample) {
+	start := intervalStart(sample.Timestamp, r.interval)
+	bucket, exists := r.buckets[start.UnixMilli()]
+	if !exists {
+		bucket = &aggregationBucket{
+			rule:      r.rule,
+			metrics:   make(map[string][]*models.MetricSample),
+			startTime: start,
+			endTime:   start.Add(r.interval),
+		}
+		r.buckets[start.UnixMilli()] = bucket
+	}
+
+	// Counters are summed by their increases, as in live processing
+	if sample.Temporality != "" && r.rule.Aggregation.Type == "sum" {
+		increase := *sample
+		increase.Value = r.p.counters.increase(sample)
+		sample = &increase
+		bucket.counter = true
+	}
+
+	sample = r.rule.Matcher.ApplyFamily(sample)
+	if r.rule.WasmTransform != "" {
+		if sample = applyWasmTransform(r.rule, r.wasm, sample); sample == nil {
+			return
+		}
+	}
+	sample = transformLabels(sample, r.rule.LabelTransforms)
+
+	segmentKey := r.p.generateSegmentKey(sample, r.rule.GroupingLabels())
+	bucket.metrics[segmentKey] = append(bucket.metrics[segmentKey], sample)
+}
+
+// Flush aggregates the buckets that end at or before until, oldest first,
+// and returns their series
+func (r *Replayer) Flush(until time.Time) []*models.AggregatedMetric {
+	var starts []int64
+	for start, bucket := range r.buckets {
+		if !bucket.endTime.After(until) {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var outputs []*models.AggregatedMetric
+	for _, start := range starts {
+		outputs = append(outputs, r.p.bucketOutputs(r.buckets[start])...)
+		delete(r.buckets, start)
+	}
+	return outputs
+}
@@ -0,0 +1,79 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestReplayer_BucketsBySampleTime(t *testing.T) {
+	rule := &models.Rule{
+		ID:          "rule",
+		Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 60, Segmentation: []string{"service"}},
+		Output:      models.OutputConfig{MetricName: "requests:sum"},
+	}
+	replayer := NewReplayer(rule, nil, "")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A cumulative counter of two pods, scraped every 30s for two minutes
+	for i, values := range [][2]float64{{10, 100}, {20, 110}, {30, 120}, {5, 130}} {
+		ts := start.Add(time.Duration(i) * 30 * time.Second)
+		for pod, value := range values {
+			replayer.Add(&models.MetricSample{
+				Name:        "requests_total",
+				Value:       value,
+				Timestamp:   ts,
+				Labels:      map[string]string{"service": "api", "pod": string(rune('a' + pod))},
+				Temporality: models.TemporalityCumulative,
+			})
+		}
+	}
+
+	if outputs := replayer.Flush(start.Add(59 * time.Second)); len(outputs) != 0 {
+		t.Fatalf("Flush() before the first bucket ends returned %d series", len(outputs))
+	}
+
+	outputs := replayer.Flush(start.Add(2 * time.Minute))
+	if len(outputs) != 2 {
+		t.Fatalf("Flush() returned %d series, want one per bucket", len(outputs))
+	}
+	// First bucket: baselines, then +10 per pod; second bucket: +10 and a
+	// reset counted as 5 for pod a, +20 for pod b; outputs are cumulative
+	if outputs[0].Value != 20 || outputs[1].Value != 55 {
+		t.Errorf("values = %v, %v, want 20, 55", outputs[0].Value, outputs[1].Value)
+	}
+	if !outputs[0].EndTime.Equal(start.Add(time.Minute)) || outputs[0].Labels["service"] != "api" {
+		t.Errorf("first series = %+v, want the first minute of service api", outputs[0])
+	}
+	if outputs := replayer.Flush(start.Add(time.Hour)); len(outputs) != 0 {
+		t.Errorf("flushed buckets were returned again")
+	}
+}
+
+func TestReplayer_MergesMetricFamily(t *testing.T) {
+	rule := &models.Rule{
+		ID: "rule",
+		Matcher: models.MetricMatcher{
+			MetricNames: []string{"http_requests"},
+			Family: []models.FamilySource{
+				{MetricName: "legacy_requests", RenameLabels: map[string]string{"svc": "service"}, Labels: map[string]string{"source": "legacy"}},
+			},
+		},
+		Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 60, Segmentation: []string{"service"}},
+		Output:      models.OutputConfig{MetricName: "requests:sum"},
+	}
+	replayer := NewReplayer(rule, nil, "")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	replayer.Add(&models.MetricSample{Name: "http_requests", Value: 3, Timestamp: start, Labels: map[string]string{"service": "api"}})
+	replayer.Add(&models.MetricSample{Name: "legacy_requests", Value: 4, Timestamp: start, Labels: map[string]string{"svc": "api"}})
+
+	outputs := replayer.Flush(start.Add(time.Minute))
+	if len(outputs) != 1 {
+		t.Fatalf("Flush() returned %d series, want both names merged into one", len(outputs))
+	}
+	if outputs[0].Value != 7 || outputs[0].Labels["service"] != "api" {
+		t.Errorf("series = %+v, want 7 for service api", outputs[0])
+	}
+}
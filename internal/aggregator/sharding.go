@@ -0,0 +1,37 @@
+package aggregator
+
+import (
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/sharding"
+)
+
+// forwardRules forwards a sample to the instances owning the output series
+// it is aggregated into by its matching rules, given with the sample as each
+// of them aggregates it, and returns those whose output series the local
+// instance owns. A sample is sent as ingested, once to each owner, with the
+// IDs of the rules it aggregates it by.
+func (p *Processor) forwardRules(sample *models.MetricSample, inputs []ruleSample) []ruleSample {
+	var local []ruleSample
+	var remote map[string][]string
+	for _, ri := range inputs {
+		groupKey := p.generateSegmentKey(ri.input, ri.rule.GroupingLabels())
+		owner := p.cluster.Owner(sharding.GroupHash(ri.rule.ID, groupKey))
+		if owner == p.cluster.Self() {
+			local = append(local, ri)
+			continue
+		}
+		if remote == nil {
+			remote = make(map[string][]string)
+		}
+		remote[owner] = append(remote[owner], ri.rule.ID)
+	}
+
+	for owner, ruleIDs := range remote {
+		forwarded := *sample
+		forwarded.Rules = ruleIDs
+		if !p.cluster.Forward(owner, &forwarded) {
+			p.recordDrop(sample, DropReasonForwardQueueFull)
+		}
+	}
+	return local
+}
@@ -0,0 +1,78 @@
+package aggregator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/sharding"
+)
+
+func TestProcessor_ForwardRules(t *testing.T) {
+	cluster, err := sharding.NewCluster(config.ShardingConfig{
+		InstanceAddress:       "self:9095",
+		ListenAddress:         "127.0.0.1:0",
+		Membership:            sharding.MembershipStatic,
+		Peers:                 []string{"self:9095", "127.0.0.1:1"},
+		Secret:                "secret",
+		VirtualNodes:          64,
+		ForwardTimeoutSeconds: 1,
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewCluster() error = %v", err)
+	}
+	cluster.Start()
+	defer cluster.Stop()
+	p := &Processor{cfg: &config.Config{}, cluster: cluster}
+
+	byService := &models.Rule{ID: "by-service", Aggregation: models.AggregationConfig{Type: "sum", Segmentation: []string{"service"}}}
+	total := &models.Rule{ID: "total", Aggregation: models.AggregationConfig{Type: "sum"}}
+
+	// All samples of an output series are aggregated by the same instance,
+	// whatever their other labels
+	local := make(map[string]map[string]bool)
+	for i := 0; i < 200; i++ {
+		sample := &models.MetricSample{
+			Name:   "http_requests_total",
+			Labels: map[string]string{"service": fmt.Sprintf("service-%d", i%20), "pod": fmt.Sprintf("pod-%d", i)},
+		}
+		owned := map[string]bool{}
+		inputs := []ruleSample{{rule: byService, input: sample}, {rule: total, input: sample}}
+		for _, ri := range p.forwardRules(sample, inputs) {
+			owned[ri.rule.ID] = true
+		}
+		groups := map[string]string{"by-service": "by-service/" + sample.Labels["service"], "total": "total"}
+		for ruleID, group := range groups {
+			if local[group] == nil {
+				local[group] = map[string]bool{}
+			}
+			local[group][fmt.Sprint(owned[ruleID])] = true
+		}
+	}
+
+	owners := map[string]bool{}
+	for group, aggregated := range local {
+		if len(aggregated) != 1 {
+			t.Errorf("output series %s is aggregated both locally and by the peer", group)
+		}
+		for owner := range aggregated {
+			owners[owner] = true
+		}
+	}
+	// Output series are spread over both instances
+	if len(owners) != 2 {
+		t.Error("all output series are owned by the same instance")
+	}
+}
+
+func TestNewProcessor_ShardingWithHATracker(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Sharding = config.ShardingConfig{Enabled: true, InstanceAddress: "self:9095", ListenAddress: "127.0.0.1:0", Membership: sharding.MembershipStatic, Secret: "secret"}
+	cfg.Ingest.HATracker.Enabled = true
+
+	if _, err := NewProcessor(cfg, nil, nil); err == nil || !strings.Contains(err.Error(), "ha_tracker") {
+		t.Errorf("NewProcessor() with the HA tracker and sharding error = %v, want it rejected", err)
+	}
+}
@@ -0,0 +1,35 @@
+package aggregator
+
+// ProcessorStats is a snapshot of the internal state of the processor
+type ProcessorStats struct {
+	QueueLength         int `json:"queue_length"` // Samples waiting in the input queue
+	QueueCapacity       int `json:"queue_capacity"`
+	Workers             int `json:"workers"`
+	Buckets             int `json:"buckets"`          // Open aggregation buckets
+	BufferedSamples     int `json:"buffered_samples"` // Samples held by open buckets
+	CounterInputSeries  int `json:"counter_input_series"`
+	CounterOutputSeries int `json:"counter_output_series"`
+	Subscribers         int `json:"subscribers"`
+}
+
+// Stats returns a snapshot of the internal state of the processor
+func (p *Processor) Stats() ProcessorStats {
+	stats := ProcessorStats{
+		QueueLength:   len(p.inputCh),
+		QueueCapacity: cap(p.inputCh),
+		Workers:       int(p.pool.workers.Load()),
+		Subscribers:   p.subscribers.count(),
+	}
+	stats.CounterInputSeries, stats.CounterOutputSeries = p.counters.size()
+
+	p.bucketMu.RLock()
+	defer p.bucketMu.RUnlock()
+	stats.Buckets = len(p.buckets)
+	for _, bucket := range p.buckets {
+		for _, samples := range bucket.metrics {
+			stats.BufferedSamples += len(samples)
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,116 @@
+package aggregator
+
+import (
+	"sync"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// SubscribeAll subscribes to the aggregated series of every rule
+const SubscribeAll = ""
+
+// droppedSubscriptionLog is the sampled log of series dropped for slow subscribers
+var droppedSubscriptionLog = logger.NewSampler(logger.Warn, "Subscription channel full, dropped aggregated metrics")
+
+// subscription is a consumer of aggregated series
+type subscription struct {
+	name   string
+	ruleID string // SubscribeAll for every rule
+	ch     chan *models.AggregatedMetric
+}
+
+// subscriptions fans aggregated series out to independent consumers, so
+// exporters never take series from each other
+type subscriptions struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[int]*subscription
+	closed bool
+}
+
+// Subscribe returns a channel receiving the aggregated series of a rule, or
+// of every rule for SubscribeAll, and a function ending the subscription. The
+// name identifies the consumer in metrics and logs. Series are dropped when
+// the channel buffer is full, so a slow consumer does not hold back the
+// others. The channel is closed when the subscription ends or the processor
+// stops.
+func (p *Processor) Subscribe(name, ruleID string, buffer int) (<-chan *models.AggregatedMetric, func()) {
+	return p.subscribers.subscribe(name, ruleID, buffer)
+}
+
+func (s *subscriptions) subscribe(name, ruleID string, buffer int) (<-chan *models.AggregatedMetric, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan *models.AggregatedMetric, buffer)
+	if s.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if s.subs == nil {
+		s.subs = make(map[int]*subscription)
+	}
+
+	id := s.nextID
+	s.nextID++
+	s.subs[id] = &subscription{name: name, ruleID: ruleID, ch: ch}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() { s.unsubscribe(id) })
+	}
+}
+
+// unsubscribe ends a subscription and closes its channel
+func (s *subscriptions) unsubscribe(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sub, exists := s.subs[id]; exists {
+		delete(s.subs, id)
+		close(sub.ch)
+	}
+}
+
+// publish sends an aggregated series to the subscribers of its rule
+func (s *subscriptions) publish(metric *models.AggregatedMetric) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, sub := range s.subs {
+		if sub.ruleID != SubscribeAll && sub.ruleID != metric.SourceRule {
+			continue
+		}
+		select {
+		case sub.ch <- metric:
+		default:
+			metrics.RecordSubscriptionDrop(sub.name)
+			droppedSubscriptionLog.Log(logger.Fields{
+				"subscriber":  sub.name,
+				"metric_name": metric.Name,
+				"rule_id":     metric.SourceRule,
+			})
+		}
+	}
+}
+
+// close ends all subscriptions; later subscriptions are closed immediately
+func (s *subscriptions) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, sub := range s.subs {
+		delete(s.subs, id)
+		close(sub.ch)
+	}
+	s.closed = true
+}
+
+// count returns the number of active subscriptions
+func (s *subscriptions) count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.subs)
+}
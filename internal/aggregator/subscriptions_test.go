@@ -0,0 +1,44 @@
+package aggregator
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestSubscriptions_FanOut(t *testing.T) {
+	var subs subscriptions
+	all, unsubscribeAll := subs.subscribe("all", SubscribeAll, 10)
+	other, _ := subs.subscribe("other", SubscribeAll, 10)
+	ruleA, unsubscribeA := subs.subscribe("rule-a", "a", 10)
+	slow, _ := subs.subscribe("slow", SubscribeAll, 1)
+
+	subs.publish(&models.AggregatedMetric{Name: "a_total", SourceRule: "a"})
+	subs.publish(&models.AggregatedMetric{Name: "b_total", SourceRule: "b"})
+
+	// Every subscriber receives its series independently of the others
+	if len(all) != 2 || len(other) != 2 {
+		t.Errorf("subscribers to all rules received %d and %d series, want 2", len(all), len(other))
+	}
+	if len(ruleA) != 1 || (<-ruleA).SourceRule != "a" {
+		t.Error("rule subscriber did not receive only the series of its rule")
+	}
+	if len(slow) != 1 {
+		t.Errorf("slow subscriber holds %d series, want 1 with the rest dropped", len(slow))
+	}
+
+	unsubscribeA()
+	unsubscribeA()
+	if _, open := <-ruleA; open {
+		t.Error("channel not closed on unsubscribe")
+	}
+
+	subs.close()
+	unsubscribeAll()
+	for range all {
+	}
+	late, _ := subs.subscribe("late", SubscribeAll, 1)
+	if _, open := <-late; open {
+		t.Error("subscription after close is open")
+	}
+}
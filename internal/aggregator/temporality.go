@@ -0,0 +1,176 @@
+package aggregator
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/sharding"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// counterStateTTL is the default of how long counter state is kept for series
+// and output series that stopped reporting
+const counterStateTTL = time.Hour
+
+// Policies applied when the counter state of input series is full
+const (
+	// CounterEvictOldest evicts the series seen least recently to make room
+	CounterEvictOldest = "oldest"
+	// CounterEvictNew does not track new series; their increases count as 0
+	CounterEvictNew = "new"
+)
+
+// evictFraction is the share of tracked input series evicted at once when the
+// counter state is full, so eviction is not repeated for every new series
+const evictFraction = 0.1
+
+// counterLimits bounds the memory of counter state. The zero value keeps
+// state for counterStateTTL without a series limit.
+type counterLimits struct {
+	ttl       time.Duration
+	maxSeries int    // Maximum tracked input series; 0 is unlimited
+	eviction  string // CounterEvictOldest or CounterEvictNew
+}
+
+// counterState is the last observed value of a counter series
+type counterState struct {
+	value     float64
+	timestamp time.Time
+	lastSeen  time.Time
+}
+
+// counterTracker converts counter samples of any temporality to increases and
+// keeps the running totals of aggregated counters, so that sums are correct
+// regardless of input temporality and outputs are cumulative
+type counterTracker struct {
+	mu     sync.Mutex
+	limits counterLimits
+	inputs map[uint64]*counterState // Last value of cumulative input series by series hash
+	totals map[string]*counterState // Running total of output series by rule and segment
+}
+
+// newCounterTracker creates an empty counter tracker
+func newCounterTracker(limits counterLimits) *counterTracker {
+	if limits.ttl <= 0 {
+		limits.ttl = counterStateTTL
+	}
+	return &counterTracker{
+		limits: limits,
+		inputs: make(map[uint64]*counterState),
+		totals: make(map[string]*counterState),
+	}
+}
+
+// increase returns the increase a counter sample represents. Delta samples
+// are increases already. Cumulative samples are differenced against the
+// previous sample of their series: a decrease means the counter was reset,
+// so the whole new value is the increase. The first sample of a series only
+// establishes the baseline, and out-of-order samples are ignored.
+func (ct *counterTracker) increase(sample *models.MetricSample) float64 {
+	if sample.Temporality == models.TemporalityDelta {
+		return sample.Value
+	}
+
+	key := sharding.SeriesHash(sample.Name, sample.Labels)
+	now := time.Now()
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	state, exists := ct.inputs[key]
+	if !exists {
+		if ct.limits.maxSeries > 0 && len(ct.inputs) >= ct.limits.maxSeries {
+			if ct.limits.eviction == CounterEvictNew {
+				return 0
+			}
+			ct.evictOldest(max(1, int(float64(ct.limits.maxSeries)*evictFraction)))
+		}
+		ct.inputs[key] = &counterState{value: sample.Value, timestamp: sample.Timestamp, lastSeen: now}
+		return 0
+	}
+	if sample.Timestamp.Before(state.timestamp) {
+		return 0
+	}
+
+	// A decrease means the counter was reset, e.g. by a restart of the
+	// process exposing it, and counted up from zero since
+	increase := sample.Value - state.value
+	if increase < 0 {
+		increase = sample.Value
+		metrics.RecordCounterReset(sample.Name)
+	}
+	state.value = sample.Value
+	state.timestamp = sample.Timestamp
+	state.lastSeen = now
+
+	return increase
+}
+
+// accumulate adds the increase of a flushed segment to the running total of
+// its output series and returns the new total
+func (ct *counterTracker) accumulate(key string, increase float64) float64 {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	state, exists := ct.totals[key]
+	if !exists {
+		state = &counterState{}
+		ct.totals[key] = state
+	}
+	state.value += increase
+	state.lastSeen = time.Now()
+
+	return state.value
+}
+
+// evictOldest drops the state of the n input series seen least recently.
+// The caller must hold the lock.
+func (ct *counterTracker) evictOldest(n int) {
+	keys := make([]uint64, 0, len(ct.inputs))
+	for key := range ct.inputs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return ct.inputs[keys[i]].lastSeen.Before(ct.inputs[keys[j]].lastSeen)
+	})
+
+	n = min(n, len(keys))
+	for _, key := range keys[:n] {
+		delete(ct.inputs, key)
+	}
+	metrics.RecordCounterStateEvictions(metrics.EvictionReasonLimit, n)
+}
+
+// evictStale drops the state of series not seen within the state TTL
+func (ct *counterTracker) evictStale() {
+	cutoff := time.Now().Add(-ct.limits.ttl)
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	evicted := 0
+	for key, state := range ct.inputs {
+		if state.lastSeen.Before(cutoff) {
+			delete(ct.inputs, key)
+			evicted++
+		}
+	}
+	if evicted > 0 {
+		metrics.RecordCounterStateEvictions(metrics.EvictionReasonStale, evicted)
+	}
+	metrics.UpdateCounterSeriesCount(len(ct.inputs))
+	for key, state := range ct.totals {
+		if state.lastSeen.Before(cutoff) {
+			delete(ct.totals, key)
+		}
+	}
+}
+
+// size returns the number of input series and output series with state
+func (ct *counterTracker) size() (inputs, outputs int) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return len(ct.inputs), len(ct.totals)
+}
@@ -0,0 +1,106 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestCounterTracker_Increase(t *testing.T) {
+	ct := newCounterTracker(counterLimits{})
+	start := time.Now()
+	labels := map[string]string{"job": "api"}
+
+	cumulative := func(value float64, offset time.Duration) *models.MetricSample {
+		return &models.MetricSample{
+			Name:        "requests_total",
+			Labels:      labels,
+			Value:       value,
+			Timestamp:   start.Add(offset),
+			Temporality: models.TemporalityCumulative,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		sample *models.MetricSample
+		want   float64
+	}{
+		{name: "first sample is the baseline", sample: cumulative(100, 0), want: 0},
+		{name: "increase", sample: cumulative(130, time.Second), want: 30},
+		{name: "out of order", sample: cumulative(120, 0), want: 0},
+		{name: "reset", sample: cumulative(5, 2*time.Second), want: 5},
+		{name: "after reset", sample: cumulative(8, 3*time.Second), want: 3},
+		{name: "delta", sample: &models.MetricSample{Name: "requests_total", Value: 7, Temporality: models.TemporalityDelta}, want: 7},
+	}
+
+	for _, tt := range tests {
+		if got := ct.increase(tt.sample); got != tt.want {
+			t.Errorf("%s: increase() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCounterTracker_Accumulate(t *testing.T) {
+	ct := newCounterTracker(counterLimits{})
+
+	if got := ct.accumulate("rule/a", 10); got != 10 {
+		t.Errorf("accumulate() = %v, want 10", got)
+	}
+	if got := ct.accumulate("rule/a", 5); got != 15 {
+		t.Errorf("accumulate() = %v, want 15", got)
+	}
+	if got := ct.accumulate("rule/b", 1); got != 1 {
+		t.Errorf("accumulate() for another series = %v, want 1", got)
+	}
+
+	ct.totals["rule/a"].lastSeen = time.Now().Add(-2 * counterStateTTL)
+	ct.evictStale()
+	if _, exists := ct.totals["rule/a"]; exists {
+		t.Error("evictStale() kept a stale total")
+	}
+	if _, exists := ct.totals["rule/b"]; !exists {
+		t.Error("evictStale() dropped a live total")
+	}
+}
+
+func TestCounterTracker_MaxSeries(t *testing.T) {
+	start := time.Now()
+	sample := func(series string, value float64, offset time.Duration) *models.MetricSample {
+		return &models.MetricSample{
+			Name:        "requests_total",
+			Labels:      map[string]string{"pod": series},
+			Value:       value,
+			Timestamp:   start.Add(offset),
+			Temporality: models.TemporalityCumulative,
+		}
+	}
+
+	// The series seen least recently makes room for a new one
+	ct := newCounterTracker(counterLimits{maxSeries: 2, eviction: CounterEvictOldest})
+	ct.increase(sample("a", 10, 0))
+	ct.increase(sample("b", 10, 0))
+	ct.increase(sample("a", 15, time.Second))
+	ct.increase(sample("c", 10, time.Second))
+	if len(ct.inputs) != 2 {
+		t.Fatalf("tracking %d series, want 2", len(ct.inputs))
+	}
+	if got := ct.increase(sample("a", 20, 2*time.Second)); got != 5 {
+		t.Errorf("increase() of a kept series = %v, want 5", got)
+	}
+	if got := ct.increase(sample("b", 30, 2*time.Second)); got != 0 {
+		t.Errorf("increase() of an evicted series = %v, want 0 as it is a new baseline", got)
+	}
+
+	// New series are not tracked once the limit is reached
+	ct = newCounterTracker(counterLimits{maxSeries: 1, eviction: CounterEvictNew})
+	ct.increase(sample("a", 10, 0))
+	ct.increase(sample("b", 10, 0))
+	if got := ct.increase(sample("b", 20, time.Second)); got != 0 || len(ct.inputs) != 1 {
+		t.Errorf("increase() of an untracked series = %v with %d series, want 0 with 1", got, len(ct.inputs))
+	}
+	if got := ct.increase(sample("a", 12, time.Second)); got != 2 {
+		t.Errorf("increase() of a tracked series = %v, want 2", got)
+	}
+}
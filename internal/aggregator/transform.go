@@ -0,0 +1,73 @@
+package aggregator
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// transformLabels returns the sample with the label transforms of a rule
+// applied to a copy of its labels, or the sample itself if no transformed
+// label is set
+func transformLabels(sample *models.MetricSample, transforms []models.LabelTransform) *models.MetricSample {
+	var labels map[string]string
+	for i := range transforms {
+		transform := &transforms[i]
+		for _, label := range transform.Labels {
+			value, exists := sample.Labels[label]
+			if !exists || value == "" {
+				continue
+			}
+			if labels == nil {
+				labels = make(map[string]string, len(sample.Labels))
+				for k, v := range sample.Labels {
+					labels[k] = v
+				}
+			}
+			labels[label] = transformValue(labels[label], transform)
+		}
+	}
+	if labels == nil {
+		return sample
+	}
+
+	transformed := *sample
+	transformed.Labels = labels
+	return &transformed
+}
+
+// transformValue hashes or truncates a label value
+func transformValue(value string, transform *models.LabelTransform) string {
+	if transform.ActionOrDefault() == models.LabelTransformTruncate {
+		runes := []rune(value)
+		if len(runes) <= transform.Length {
+			return value
+		}
+		return string(runes[:transform.Length])
+	}
+
+	var digest string
+	if transform.Algorithm == models.HashXXHash {
+		digest = fmt.Sprintf("%016x", xxhash.Sum64String(transform.Salt+value))
+	} else if transform.Salt != "" {
+		mac := hmac.New(sha256.New, []byte(transform.Salt))
+		mac.Write([]byte(value))
+		digest = hex.EncodeToString(mac.Sum(nil))
+	} else {
+		sum := sha256.Sum256([]byte(value))
+		digest = hex.EncodeToString(sum[:])
+	}
+
+	length := transform.Length
+	if length == 0 {
+		length = models.DefaultHashLength
+	}
+	if length < len(digest) {
+		return digest[:length]
+	}
+	return digest
+}
@@ -0,0 +1,51 @@
+package aggregator
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestTransformLabels(t *testing.T) {
+	sample := &models.MetricSample{
+		Name:   "logins_total",
+		Labels: map[string]string{"user_id": "42", "email": "jane@example.com", "service": "auth"},
+	}
+	transforms := []models.LabelTransform{
+		{Labels: []string{"user_id"}, Salt: "pepper", Length: 12},
+		{Labels: []string{"email"}, Action: models.LabelTransformTruncate, Length: 4},
+	}
+
+	transformed := transformLabels(sample, transforms)
+	if got := transformed.Labels["user_id"]; len(got) != 12 || got == "42" {
+		t.Errorf("hashed user_id = %q, want a 12 character digest", got)
+	}
+	if got := transformed.Labels["email"]; got != "jane" {
+		t.Errorf("truncated email = %q, want jane", got)
+	}
+	if got := transformed.Labels["service"]; got != "auth" {
+		t.Errorf("service = %q, want it unchanged", got)
+	}
+	if sample.Labels["user_id"] != "42" {
+		t.Error("expected the original sample to be left unchanged")
+	}
+
+	// Equal values keep correlating, and the salt changes the digest
+	if again := transformLabels(sample, transforms); again.Labels["user_id"] != transformed.Labels["user_id"] {
+		t.Error("expected hashing to be deterministic")
+	}
+	unsalted := transformLabels(sample, []models.LabelTransform{{Labels: []string{"user_id"}, Length: 12}})
+	if unsalted.Labels["user_id"] == transformed.Labels["user_id"] {
+		t.Error("expected the salt to change the digest")
+	}
+	xx := transformLabels(sample, []models.LabelTransform{{Labels: []string{"user_id"}, Algorithm: models.HashXXHash}})
+	if got := xx.Labels["user_id"]; len(got) != 16 {
+		t.Errorf("xxhash digest = %q, want 16 hex characters", got)
+	}
+
+	// Samples without the labels are not copied
+	other := &models.MetricSample{Name: "logins_total", Labels: map[string]string{"service": "auth"}}
+	if transformLabels(other, transforms) != other {
+		t.Error("expected a sample without transformed labels to be returned as is")
+	}
+}
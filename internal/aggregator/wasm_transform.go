@@ -0,0 +1,37 @@
+package aggregator
+
+import (
+	"fmt"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// wasmTransformErrorLog is sampled, since a faulty module may fail on every sample
+var wasmTransformErrorLog = logger.NewSampler(logger.Error, "WASM transform failed")
+
+// applyWasmTransform runs the WASM transform of a rule on a sample matched by
+// the rule. It returns nil when the transform dropped the sample or failed,
+// including when it no longer exists.
+func applyWasmTransform(rule *models.Rule, transform *rules.WasmTransform, sample *models.MetricSample) *models.MetricSample {
+	var transformed *models.MetricSample
+	err := fmt.Errorf("wasm transform %s does not exist", rule.WasmTransform)
+	if transform != nil {
+		transformed, err = transform.Apply(sample)
+	}
+	if err != nil {
+		wasmTransformErrorLog.Log(logger.Fields{
+			"rule_id":   rule.ID,
+			"transform": rule.WasmTransform,
+			"error":     err.Error(),
+		})
+		metrics.RecordDroppedSamples(metrics.StageAggregation, "wasm_transform_error", 1)
+		return nil
+	}
+	if transformed == nil {
+		metrics.RecordDroppedSamples(metrics.StageAggregation, "wasm_transform", 1)
+	}
+	return transformed
+}
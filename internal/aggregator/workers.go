@@ -0,0 +1,183 @@
+package aggregator
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// Utilization thresholds of the workers for adaptive sizing
+const (
+	scaleUpUtilization   = 0.8
+	scaleDownUtilization = 0.3
+	// scaleDownIntervals is how many consecutive idle intervals remove a
+	// worker, so that short lulls in bursty load do not shrink the pool
+	scaleDownIntervals = 3
+)
+
+// workerPool sizes the workers of the processor. Without scaling it keeps a
+// fixed number of workers; with scaling it adds workers while the input
+// queue fills up, samples wait too long or workers are busy most of the
+// time, and removes them while they are mostly idle.
+type workerPool struct {
+	scaling    bool
+	min, max   int
+	interval   time.Duration
+	targetWait time.Duration
+
+	workers atomic.Int32
+	// shrink tells a worker to exit
+	shrink chan struct{}
+	// Totals since the last resize
+	waitNanos atomic.Int64
+	busyNanos atomic.Int64
+	processed atomic.Int64
+	// idle counts consecutive intervals in which workers were mostly idle
+	idle int
+}
+
+// newWorkerPool creates a pool from the aggregator configuration
+func newWorkerPool(cfg config.AggregatorConfig) *workerPool {
+	scaling := cfg.WorkerScaling
+	if !scaling.Enabled {
+		count := max(cfg.WorkerCount, 1)
+		return &workerPool{min: count, max: count}
+	}
+
+	pool := &workerPool{
+		scaling:    true,
+		min:        max(scaling.MinWorkers, 1),
+		max:        scaling.MaxWorkers,
+		interval:   time.Duration(scaling.IntervalMs) * time.Millisecond,
+		targetWait: time.Duration(scaling.TargetQueueWaitMs) * time.Millisecond,
+	}
+	if pool.max <= 0 {
+		pool.max = 4 * runtime.GOMAXPROCS(0)
+	}
+	pool.max = max(pool.max, pool.min)
+	if pool.interval <= 0 {
+		pool.interval = time.Second
+	}
+	pool.shrink = make(chan struct{}, pool.max)
+	return pool
+}
+
+// record adds the queue wait and processing time of a sample
+func (w *workerPool) record(wait, busy time.Duration) {
+	w.waitNanos.Add(int64(wait))
+	w.busyNanos.Add(int64(busy))
+	w.processed.Add(1)
+}
+
+// resize returns the number of workers the pool should have, given the
+// current workers, the input queue and the time elapsed since the last resize
+func (w *workerPool) resize(current, depth, capacity int, elapsed time.Duration) int {
+	processed := w.processed.Swap(0)
+	wait := time.Duration(w.waitNanos.Swap(0))
+	busy := time.Duration(w.busyNanos.Swap(0))
+
+	var avgWait time.Duration
+	if processed > 0 {
+		avgWait = wait / time.Duration(processed)
+	}
+	utilization := 0.0
+	if current > 0 && elapsed > 0 {
+		utilization = float64(busy) / (float64(current) * float64(elapsed))
+	}
+
+	if depth*2 >= capacity || avgWait > w.targetWait || utilization > scaleUpUtilization {
+		w.idle = 0
+		// Grow by half at once, so that bursts are absorbed in a few intervals
+		return min(current+max(current/2, 1), w.max)
+	}
+	if depth*10 < capacity && avgWait < w.targetWait/2 && utilization < scaleDownUtilization {
+		w.idle++
+		if w.idle >= scaleDownIntervals {
+			w.idle = 0
+			return max(current-1, w.min)
+		}
+		return current
+	}
+	w.idle = 0
+	return current
+}
+
+// startWorkers starts the initial workers, and the scaler when the pool is
+// sized adaptively
+func (p *Processor) startWorkers() {
+	p.addWorkers(p.pool.min)
+	if p.pool.scaling {
+		p.scalerDone = make(chan struct{})
+		go p.scaleWorkers()
+	}
+}
+
+// addWorkers starts workers
+func (p *Processor) addWorkers(count int) {
+	for i := 0; i < count; i++ {
+		p.workerWg.Add(1)
+		go p.worker()
+	}
+	metrics.UpdateProcessorWorkers(int(p.pool.workers.Add(int32(count))))
+}
+
+// removeWorkers tells workers to exit once they finish their current sample
+func (p *Processor) removeWorkers(count int) {
+	for i := 0; i < count; i++ {
+		p.pool.shrink <- struct{}{}
+	}
+	metrics.UpdateProcessorWorkers(int(p.pool.workers.Add(-int32(count))))
+}
+
+// scaleWorkers resizes the pool every interval until the processor stops
+func (p *Processor) scaleWorkers() {
+	defer close(p.scalerDone)
+	ticker := time.NewTicker(p.pool.interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case now := <-ticker.C:
+			current := int(p.pool.workers.Load())
+			target := p.pool.resize(current, len(p.inputCh), cap(p.inputCh), now.Sub(last))
+			last = now
+			if target == current {
+				continue
+			}
+
+			if target > current {
+				p.addWorkers(target - current)
+			} else {
+				p.removeWorkers(current - target)
+			}
+			logger.LogDebugWithFields("Resized worker pool", logger.Fields{
+				"workers":     target,
+				"previous":    current,
+				"queue_depth": len(p.inputCh),
+			})
+		}
+	}
+}
+
+// runSample processes a sample from the input queue, recording how long it
+// waited and how long it took
+func (p *Processor) runSample(sample *models.MetricSample) {
+	start := time.Now()
+	var wait time.Duration
+	if !sample.EnqueuedAt.IsZero() {
+		wait = start.Sub(sample.EnqueuedAt)
+		metrics.RecordQueueWait(wait)
+	}
+	p.processSample(sample)
+	elapsed := time.Since(start)
+	metrics.RecordProcessingDuration("process_sample", elapsed)
+	p.pool.record(wait, elapsed)
+}
@@ -0,0 +1,122 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+func scalingConfig() config.AggregatorConfig {
+	return config.AggregatorConfig{
+		WorkerCount: 5,
+		WorkerScaling: config.WorkerScalingConfig{
+			Enabled:           true,
+			MinWorkers:        2,
+			MaxWorkers:        8,
+			IntervalMs:        10,
+			TargetQueueWaitMs: 50,
+		},
+	}
+}
+
+func TestNewWorkerPool(t *testing.T) {
+	static := newWorkerPool(config.AggregatorConfig{WorkerCount: 5})
+	if static.scaling || static.min != 5 || static.max != 5 || static.shrink != nil {
+		t.Errorf("Static pool = %+v, want 5 fixed workers", static)
+	}
+
+	cfg := scalingConfig()
+	cfg.WorkerScaling.MaxWorkers = 0
+	pool := newWorkerPool(cfg)
+	if !pool.scaling || pool.min != 2 || pool.max < 4 {
+		t.Errorf("Scaling pool = %+v, want min 2 and max 4 per CPU", pool)
+	}
+}
+
+func TestWorkerPool_Resize(t *testing.T) {
+	pool := newWorkerPool(scalingConfig())
+	second := time.Second
+
+	// A half full queue grows the pool by half
+	if got := pool.resize(4, 50, 100, second); got != 6 {
+		t.Errorf("resize() with a half full queue = %d, want 6", got)
+	}
+	// Growth is bounded
+	if got := pool.resize(6, 100, 100, second); got != 8 {
+		t.Errorf("resize() at the maximum = %d, want 8", got)
+	}
+
+	// Samples waiting longer than the target
+	pool.record(100*time.Millisecond, time.Millisecond)
+	if got := pool.resize(2, 0, 100, second); got != 3 {
+		t.Errorf("resize() with long queue waits = %d, want 3", got)
+	}
+
+	// Busy workers
+	pool.record(0, 1800*time.Millisecond)
+	if got := pool.resize(2, 0, 100, second); got != 3 {
+		t.Errorf("resize() with busy workers = %d, want 3", got)
+	}
+
+	// Idle workers are removed one at a time after a few intervals
+	for i := 1; i < scaleDownIntervals; i++ {
+		if got := pool.resize(4, 0, 100, second); got != 4 {
+			t.Fatalf("resize() after %d idle intervals = %d, want 4", i, got)
+		}
+	}
+	if got := pool.resize(4, 0, 100, second); got != 3 {
+		t.Errorf("resize() after %d idle intervals = %d, want 3", scaleDownIntervals, got)
+	}
+	// Never below the minimum
+	for i := 0; i < scaleDownIntervals; i++ {
+		if got := pool.resize(2, 0, 100, second); got != 2 {
+			t.Errorf("resize() at the minimum = %d, want 2", got)
+		}
+	}
+}
+
+func TestProcessor_WorkerScaling(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Aggregator = scalingConfig()
+	cfg.Aggregator.BatchSize = 100
+	cfg.Aggregator.RulesPath = t.TempDir()
+	engine, err := rules.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	p, err := NewProcessor(cfg, engine, nil)
+	if err != nil {
+		t.Fatalf("NewProcessor() error = %v", err)
+	}
+	p.Start()
+
+	if workers := p.Stats().Workers; workers != 2 {
+		t.Fatalf("Started with %d workers, want 2", workers)
+	}
+	// Samples waiting in the queue for long grow the pool
+	for i := 0; i < 10; i++ {
+		p.ProcessMetric(&models.MetricSample{Name: "requests", Labels: map[string]string{}})
+	}
+	p.pool.record(time.Second, 0)
+	deadline := time.Now().Add(time.Second)
+	for p.Stats().Workers <= 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if workers := p.Stats().Workers; workers <= 2 {
+		t.Errorf("Pool did not grow: %d workers", workers)
+	}
+
+	// Idle workers shrink back to the minimum
+	deadline = time.Now().Add(2 * time.Second)
+	for p.Stats().Workers > 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if workers := p.Stats().Workers; workers != 2 {
+		t.Errorf("Pool did not shrink to the minimum: %d workers", workers)
+	}
+
+	p.Stop()
+}
@@ -0,0 +1,441 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/marcotuna/adaptive-metrics/internal/tracing"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Formats accepted by the agent ingestion endpoint
+const (
+	agentFormatRemoteWrite = "remote_write"
+	agentFormatFluentBit   = "fluent_bit"
+)
+
+// maxAgentRequestBytes bounds the body of an agent ingestion or Firehose
+// request, and maxAgentDecodedBytes the body it decompresses to
+const (
+	maxAgentRequestBytes = 64 << 20
+	maxAgentDecodedBytes = 256 << 20
+)
+
+// errDecodedBodyTooLarge is returned for a request body decompressing to more
+// than maxAgentDecodedBytes
+var errDecodedBodyTooLarge = fmt.Errorf("decompressed body is larger than %d bytes", maxAgentDecodedBytes)
+
+// Metric types of Fluent Bit metrics, as encoded by its cmetrics library
+const (
+	fluentBitCounter = iota
+	fluentBitGauge
+	fluentBitHistogram
+	fluentBitSummary
+	fluentBitUntyped
+)
+
+// fluentBitChunk is a chunk of Fluent Bit metrics encoded as JSON, as sent by
+// its http output with format json
+type fluentBitChunk struct {
+	Meta struct {
+		Processing struct {
+			// StaticLabels are added to every series of the chunk
+			StaticLabels [][2]string `json:"static_labels"`
+		} `json:"processing"`
+	} `json:"meta"`
+	Metrics []fluentBitMetric `json:"metrics"`
+}
+
+// fluentBitMetric is a metric family of a Fluent Bit chunk
+type fluentBitMetric struct {
+	Meta struct {
+		Type int `json:"type"`
+		Opts struct {
+			Namespace   string `json:"ns"`
+			Subsystem   string `json:"ss"`
+			Name        string `json:"name"`
+			Description string `json:"desc"`
+		} `json:"opts"`
+		// Labels are the label names; each value lists the label values in
+		// the same order
+		Labels []string `json:"labels"`
+		// Buckets are the upper bounds of histogram buckets
+		Buckets []float64 `json:"buckets"`
+		// Quantiles are the quantiles of summaries
+		Quantiles []float64 `json:"quantiles"`
+	} `json:"meta"`
+	Values []struct {
+		// Timestamp in nanoseconds
+		Timestamp int64    `json:"ts"`
+		Value     float64  `json:"value"`
+		Labels    []string `json:"labels"`
+		Histogram *struct {
+			// Buckets are the cumulative counts of the buckets
+			Buckets []float64 `json:"buckets"`
+			Sum     float64   `json:"sum"`
+			Count   float64   `json:"count"`
+		} `json:"histogram"`
+		Summary *struct {
+			Quantiles []float64 `json:"quantiles"`
+			Sum       float64   `json:"sum"`
+			Count     float64   `json:"count"`
+		} `json:"summary"`
+	} `json:"values"`
+}
+
+// AgentIngest receives metrics of agents that do not speak Prometheus remote
+// write exactly: remote write as sent by Vector, which may compress it with
+// gzip or zstd, leave timestamps unset and send labels with empty values, and
+// the JSON metrics of Fluent Bit. They are converted to a write request and
+// ingested like remote write.
+func (h *Handler) AgentIngest(w http.ResponseWriter, r *http.Request) {
+	if !h.ingest.enter() {
+		h.shuttingDown(w)
+		return
+	}
+	defer h.ingest.leave()
+
+	requestID := generateRequestID()
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	_, span := tracing.Tracer().Start(ctx, "agent.receive",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("request.id", requestID)))
+	defer span.End()
+
+	startTime := time.Now()
+	r.Body = http.MaxBytesReader(w, r.Body, maxAgentRequestBytes)
+	req, format, err := decodeAgentRequest(r, startTime)
+	if err != nil {
+		status := http.StatusBadRequest
+		var unsupported *unsupportedAgentRequestError
+		if errors.As(err, &unsupported) {
+			status = http.StatusUnsupportedMediaType
+		} else if requestTooLarge(err) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		logger.LogWarnWithFields("Rejecting agent ingestion request", logger.Fields{
+			"request_id": requestID,
+			"error":      err.Error(),
+		})
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	span.SetAttributes(attribute.String("agent.format", format))
+	logger.LogDebugWithFields("Decoded agent ingestion request", logger.Fields{
+		"request_id":       requestID,
+		"format":           format,
+		"timeseries_count": len(req.Timeseries),
+	})
+
+	h.ingestWriteRequest(w, r, req, span, requestID, startTime)
+}
+
+// unsupportedAgentRequestError is a request of a content type or encoding the
+// agent ingestion endpoint does not accept
+type unsupportedAgentRequestError struct {
+	message string
+}
+
+func (e *unsupportedAgentRequestError) Error() string {
+	return e.message
+}
+
+// requestTooLarge reports whether a request was rejected for the size of its
+// body, as sent or decompressed
+func requestTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge) || errors.Is(err, errDecodedBodyTooLarge)
+}
+
+// decodeAgentRequest decodes the body of an agent ingestion request into a
+// write request with the format it was sent in. Samples without a timestamp
+// are placed at now.
+func decodeAgentRequest(r *http.Request, now time.Time) (*prompb.WriteRequest, string, error) {
+	format := agentFormatRemoteWrite
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, "", &unsupportedAgentRequestError{fmt.Sprintf("invalid content type %q", contentType)}
+		}
+		switch mediaType {
+		case "application/x-protobuf":
+		case "application/json", "application/x-ndjson":
+			format = agentFormatFluentBit
+		default:
+			return nil, "", &unsupportedAgentRequestError{fmt.Sprintf("unsupported content type %q", mediaType)}
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	var req *prompb.WriteRequest
+	if format == agentFormatFluentBit {
+		body, err = decompressAgentBody(r.Header.Get("Content-Encoding"), "", body)
+		if err != nil {
+			return nil, "", err
+		}
+		req, err = decodeFluentBitMetrics(body)
+	} else {
+		// Remote write bodies without an encoding are snappy compressed
+		body, err = decompressAgentBody(r.Header.Get("Content-Encoding"), "snappy", body)
+		if err != nil {
+			return nil, "", err
+		}
+		req = &prompb.WriteRequest{}
+		if err = proto.Unmarshal(body, req); err != nil {
+			err = fmt.Errorf("invalid remote write request: %w", err)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	normalizeAgentSeries(req, now)
+	return req, format, nil
+}
+
+// decompressAgentBody decodes a request body with its content encoding, or
+// with fallback when the request does not state one. Bodies decompressing to
+// more than maxAgentDecodedBytes are rejected.
+func decompressAgentBody(encoding, fallback string, body []byte) ([]byte, error) {
+	if encoding == "" {
+		encoding = fallback
+	}
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "snappy":
+		if size, err := snappy.DecodedLen(body); err == nil && size > maxAgentDecodedBytes {
+			return nil, errDecodedBodyTooLarge
+		}
+		decoded, err := snappy.Decode(nil, body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snappy body: %w", err)
+		}
+		return decoded, nil
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer gz.Close()
+		return readDecoded(gz)
+	case "zstd":
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxAgentDecodedBytes), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		decoded, err := decoder.DecodeAll(body, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, errDecodedBodyTooLarge
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd body: %w", err)
+		}
+		return decoded, nil
+	default:
+		return nil, &unsupportedAgentRequestError{fmt.Sprintf("unsupported content encoding %q", encoding)}
+	}
+}
+
+// readDecoded reads a gzip body, up to maxAgentDecodedBytes
+func readDecoded(gz *gzip.Reader) ([]byte, error) {
+	decoded, err := io.ReadAll(io.LimitReader(gz, maxAgentDecodedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip body: %w", err)
+	}
+	if len(decoded) > maxAgentDecodedBytes {
+		return nil, errDecodedBodyTooLarge
+	}
+	return decoded, nil
+}
+
+// normalizeAgentSeries drops labels with empty values, which Prometheus
+// treats as absent, and places samples without a timestamp at now
+func normalizeAgentSeries(req *prompb.WriteRequest, now time.Time) {
+	nowMs := now.UnixMilli()
+	for i := range req.Timeseries {
+		ts := &req.Timeseries[i]
+		labels := ts.Labels[:0]
+		for _, label := range ts.Labels {
+			if label.Value != "" {
+				labels = append(labels, label)
+			}
+		}
+		ts.Labels = labels
+		for j := range ts.Samples {
+			if ts.Samples[j].Timestamp == 0 {
+				ts.Samples[j].Timestamp = nowMs
+			}
+		}
+	}
+}
+
+// decodeFluentBitMetrics converts Fluent Bit JSON metrics, a chunk, an array
+// of chunks or newline-delimited chunks, into a write request. Histograms and
+// summaries become their _bucket, _sum and _count or quantile series.
+func decodeFluentBitMetrics(body []byte) (*prompb.WriteRequest, error) {
+	var chunks []fluentBitChunk
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &chunks); err != nil {
+			return nil, fmt.Errorf("invalid Fluent Bit metrics: %w", err)
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		for {
+			var chunk fluentBitChunk
+			if err := decoder.Decode(&chunk); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("invalid Fluent Bit metrics: %w", err)
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	req := &prompb.WriteRequest{}
+	metadata := make(map[string]prompb.MetricMetadata)
+	for _, chunk := range chunks {
+		for _, metric := range chunk.Metrics {
+			name := fluentBitMetricName(metric)
+			if name == "" {
+				return nil, fmt.Errorf("invalid Fluent Bit metrics: metric without a name")
+			}
+			metadata[name] = prompb.MetricMetadata{
+				Type:             fluentBitMetadataType(metric.Meta.Type),
+				MetricFamilyName: name,
+				Help:             metric.Meta.Opts.Description,
+			}
+
+			for _, value := range metric.Values {
+				if len(value.Labels) > len(metric.Meta.Labels) {
+					return nil, fmt.Errorf("invalid Fluent Bit metrics: %s has %d label values for %d labels", name, len(value.Labels), len(metric.Meta.Labels))
+				}
+				labels := make(map[string]string, len(value.Labels)+len(chunk.Meta.Processing.StaticLabels))
+				for _, static := range chunk.Meta.Processing.StaticLabels {
+					labels[static[0]] = static[1]
+				}
+				for i, labelValue := range value.Labels {
+					labels[metric.Meta.Labels[i]] = labelValue
+				}
+				timestamp := value.Timestamp / int64(time.Millisecond)
+				series := func(name string, sampleValue float64, extra ...string) {
+					req.Timeseries = append(req.Timeseries, fluentBitSeries(name, labels, extra, sampleValue, timestamp))
+				}
+
+				switch {
+				case metric.Meta.Type == fluentBitHistogram && value.Histogram != nil:
+					for i, bound := range metric.Meta.Buckets {
+						if i < len(value.Histogram.Buckets) && !math.IsInf(bound, 1) {
+							series(name+"_bucket", value.Histogram.Buckets[i], "le", formatBound(bound))
+						}
+					}
+					series(name+"_bucket", value.Histogram.Count, "le", "+Inf")
+					series(name+"_sum", value.Histogram.Sum)
+					series(name+"_count", value.Histogram.Count)
+				case metric.Meta.Type == fluentBitSummary && value.Summary != nil:
+					for i, quantile := range metric.Meta.Quantiles {
+						if i < len(value.Summary.Quantiles) {
+							series(name, value.Summary.Quantiles[i], "quantile", formatBound(quantile))
+						}
+					}
+					series(name+"_sum", value.Summary.Sum)
+					series(name+"_count", value.Summary.Count)
+				default:
+					series(name, value.Value)
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		req.Metadata = append(req.Metadata, metadata[name])
+	}
+	return req, nil
+}
+
+// fluentBitMetricName returns the full name of a Fluent Bit metric: its
+// namespace, subsystem and name joined by underscores
+func fluentBitMetricName(metric fluentBitMetric) string {
+	var parts []string
+	for _, part := range []string{metric.Meta.Opts.Namespace, metric.Meta.Opts.Subsystem, metric.Meta.Opts.Name} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// fluentBitMetadataType maps a Fluent Bit metric type to a metadata type
+func fluentBitMetadataType(metricType int) prompb.MetricMetadata_MetricType {
+	switch metricType {
+	case fluentBitCounter:
+		return prompb.MetricMetadata_COUNTER
+	case fluentBitGauge:
+		return prompb.MetricMetadata_GAUGE
+	case fluentBitHistogram:
+		return prompb.MetricMetadata_HISTOGRAM
+	case fluentBitSummary:
+		return prompb.MetricMetadata_SUMMARY
+	default:
+		return prompb.MetricMetadata_UNKNOWN
+	}
+}
+
+// fluentBitSeries builds a series of a single sample; extra holds pairs of
+// label names and values added to labels
+func fluentBitSeries(name string, labels map[string]string, extra []string, value float64, timestamp int64) prompb.TimeSeries {
+	series := prompb.TimeSeries{
+		Labels:  make([]prompb.Label, 0, len(labels)+len(extra)/2+1),
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+	}
+	series.Labels = append(series.Labels, prompb.Label{Name: "__name__", Value: name})
+	for labelName, labelValue := range labels {
+		series.Labels = append(series.Labels, prompb.Label{Name: labelName, Value: labelValue})
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		series.Labels = append(series.Labels, prompb.Label{Name: extra[i], Value: extra[i+1]})
+	}
+	sort.Slice(series.Labels, func(i, j int) bool { return series.Labels[i].Name < series.Labels[j].Name })
+	return series
+}
+
+// formatBound formats a bucket bound or quantile like Prometheus does
+func formatBound(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
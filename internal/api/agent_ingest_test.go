@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// seriesString renders a series as name{labels} value@timestamp for comparisons
+func seriesString(ts prompb.TimeSeries) string {
+	var b strings.Builder
+	for i, label := range ts.Labels {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(label.Name + "=" + label.Value)
+	}
+	for _, sample := range ts.Samples {
+		b.WriteString(" " + formatBound(sample.Value) + "@" + formatBound(float64(sample.Timestamp)))
+	}
+	return b.String()
+}
+
+func TestDecodeAgentRequest_Vector(t *testing.T) {
+	write := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "host", Value: "edge-1"}, {Name: "__name__", Value: "http_requests_total"}, {Name: "region", Value: ""}},
+		Samples: []prompb.Sample{{Value: 3}},
+	}}}
+	data, err := proto.Marshal(write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write(data)
+	gz.Close()
+
+	r := httptest.NewRequest("POST", "/api/v1/ingest", &body)
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	r.Header.Set("Content-Encoding", "gzip")
+	now := time.UnixMilli(1700000000000)
+	req, format, err := decodeAgentRequest(r, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != agentFormatRemoteWrite || len(req.Timeseries) != 1 {
+		t.Fatalf("format = %s, series = %d", format, len(req.Timeseries))
+	}
+	if got, want := seriesString(req.Timeseries[0]), "host=edge-1,__name__=http_requests_total 3@1700000000000"; got != want {
+		t.Errorf("series = %q, want %q", got, want)
+	}
+
+	r = httptest.NewRequest("POST", "/api/v1/ingest", strings.NewReader("x"))
+	r.Header.Set("Content-Encoding", "br")
+	if _, _, err := decodeAgentRequest(r, now); err == nil {
+		t.Error("expected error for unsupported encoding")
+	}
+}
+
+func TestDecodeAgentRequest_DecompressionLimit(t *testing.T) {
+	zeros := make([]byte, maxAgentDecodedBytes+1)
+
+	var gzipBody bytes.Buffer
+	gz := gzip.NewWriter(&gzipBody)
+	gz.Write(zeros)
+	gz.Close()
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstdBody := encoder.EncodeAll(zeros, nil)
+	encoder.Close()
+
+	for encoding, body := range map[string][]byte{
+		"gzip":   gzipBody.Bytes(),
+		"zstd":   zstdBody,
+		"snappy": snappy.Encode(nil, zeros),
+	} {
+		r := httptest.NewRequest("POST", "/api/v1/ingest", bytes.NewReader(body))
+		r.Header.Set("Content-Encoding", encoding)
+		if _, _, err := decodeAgentRequest(r, time.Now()); !errors.Is(err, errDecodedBodyTooLarge) {
+			t.Errorf("%s: error = %v, want %v", encoding, err, errDecodedBodyTooLarge)
+		}
+	}
+
+	// Bodies larger than the request limit are cut off
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/v1/ingest", bytes.NewReader(zeros))
+	r.Body = http.MaxBytesReader(w, r.Body, maxAgentRequestBytes)
+	r.Header.Set("Content-Encoding", "identity")
+	if _, _, err := decodeAgentRequest(r, time.Now()); !requestTooLarge(err) {
+		t.Errorf("error = %v for a body over the request limit", err)
+	}
+}
+
+func TestDecodeAgentRequest_FluentBit(t *testing.T) {
+	body := `{"meta":{"processing":{"static_labels":[["cluster","edge"]]}},"metrics":[
+  {"meta":{"type":0,"opts":{"ns":"fluentbit","ss":"input","name":"records_total","desc":"Records"},"labels":["name"]},
+   "values":[{"ts":1700000000000000000,"value":42,"labels":["tail.0"]}]},
+  {"meta":{"type":2,"opts":{"ns":"","ss":"","name":"latency_seconds","desc":""},"labels":[],"buckets":[0.1,1]},
+   "values":[{"ts":1700000000000000000,"histogram":{"buckets":[2,5],"sum":3.5,"count":6}}]}
+]}`
+	r := httptest.NewRequest("POST", "/api/v1/ingest", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	req, format, err := decodeAgentRequest(r, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != agentFormatFluentBit {
+		t.Errorf("format = %s", format)
+	}
+
+	want := []string{
+		"__name__=fluentbit_input_records_total,cluster=edge,name=tail.0 42@1700000000000",
+		"__name__=latency_seconds_bucket,cluster=edge,le=0.1 2@1700000000000",
+		"__name__=latency_seconds_bucket,cluster=edge,le=1 5@1700000000000",
+		"__name__=latency_seconds_bucket,cluster=edge,le=+Inf 6@1700000000000",
+		"__name__=latency_seconds_sum,cluster=edge 3.5@1700000000000",
+		"__name__=latency_seconds_count,cluster=edge 6@1700000000000",
+	}
+	if len(req.Timeseries) != len(want) {
+		t.Fatalf("got %d series, want %d", len(req.Timeseries), len(want))
+	}
+	for i, ts := range req.Timeseries {
+		if got := seriesString(ts); got != want[i] {
+			t.Errorf("series %d = %q, want %q", i, got, want[i])
+		}
+	}
+
+	if len(req.Metadata) != 2 || req.Metadata[0].MetricFamilyName != "fluentbit_input_records_total" || req.Metadata[0].Type != prompb.MetricMetadata_COUNTER {
+		t.Errorf("metadata = %+v", req.Metadata)
+	}
+}
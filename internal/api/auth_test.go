@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := AuthMiddleware(config.ListenerAuthConfig{
+		BearerToken: "secret-token",
+		Username:    "admin",
+		Password:    "hunter2",
+	})(ok)
+
+	tests := []struct {
+		name      string
+		path      string
+		authorize func(r *http.Request)
+		want      int
+	}{
+		{"no credentials", "/api/v1/rules", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"bearer token", "/api/v1/rules", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret-token") }, http.StatusOK},
+		{"wrong bearer token", "/api/v1/rules", func(r *http.Request) { r.Header.Set("Authorization", "Bearer other") }, http.StatusUnauthorized},
+		{"basic auth", "/api/v1/rules", func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") }, http.StatusOK},
+		{"wrong password", "/api/v1/rules", func(r *http.Request) { r.SetBasicAuth("admin", "guess") }, http.StatusUnauthorized},
+		{"health check", "/health", func(r *http.Request) {}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			tt.authorize(r)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	// Without credentials configured, requests are not authenticated
+	w := httptest.NewRecorder()
+	AuthMiddleware(config.ListenerAuthConfig{})(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/rules", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status without auth = %d, want %d", w.Code, http.StatusOK)
+	}
+}
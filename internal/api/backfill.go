@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/backfill"
+)
+
+// SetupBackfillRoutes sets up the routes for the backfill API
+func (h *Handler) SetupBackfillRoutes(router *mux.Router) {
+	router.HandleFunc("/backfill", h.ListBackfillJobs).Methods("GET", "OPTIONS")
+	router.HandleFunc("/backfill", h.StartBackfillJob).Methods("POST", "OPTIONS")
+	router.HandleFunc("/backfill/{id}", h.GetBackfillJob).Methods("GET", "OPTIONS")
+	router.HandleFunc("/backfill/{id}", h.CancelBackfillJob).Methods("DELETE", "OPTIONS")
+}
+
+// ListBackfillJobs returns all backfill jobs
+func (h *Handler) ListBackfillJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := h.backfill.List()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobs":  jobs,
+		"total": len(jobs),
+	})
+}
+
+// StartBackfillJob starts replaying historical data through rules
+func (h *Handler) StartBackfillJob(w http.ResponseWriter, r *http.Request) {
+	var req backfill.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.backfill.Start(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetBackfillJob returns the status of a backfill job
+func (h *Handler) GetBackfillJob(w http.ResponseWriter, r *http.Request) {
+	job, exists := h.backfill.Get(mux.Vars(r)["id"])
+	if !exists {
+		http.Error(w, "Backfill job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// CancelBackfillJob cancels a running backfill job
+func (h *Handler) CancelBackfillJob(w http.ResponseWriter, r *http.Request) {
+	if err := h.backfill.Cancel(mux.Vars(r)["id"]); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
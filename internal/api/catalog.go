@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+// SetupCatalogRoutes sets up the routes of the built-in catalog of ready-made rules
+func (h *Handler) SetupCatalogRoutes(router *mux.Router) {
+	router.HandleFunc("/catalog", h.ListCatalog).Methods("GET", "OPTIONS")
+	router.HandleFunc("/catalog/{id}", h.GetCatalogEntry).Methods("GET", "OPTIONS")
+	router.HandleFunc("/catalog/{id}/install", h.InstallCatalogEntry).Methods("POST", "OPTIONS")
+}
+
+// ListCatalog returns the catalog entries, optionally of a single exporter
+func (h *Handler) ListCatalog(w http.ResponseWriter, r *http.Request) {
+	entries, err := rules.Catalog()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items := []*models.CatalogEntry{}
+	exporter := r.URL.Query().Get("exporter")
+	for _, entry := range entries {
+		if exporter == "" || entry.Exporter == exporter {
+			items = append(items, entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": items,
+		"total": len(items),
+	})
+}
+
+// GetCatalogEntry returns a catalog entry by ID
+func (h *Handler) GetCatalogEntry(w http.ResponseWriter, r *http.Request) {
+	entry, err := rules.CatalogEntry(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// InstallCatalogEntry creates a rule from a catalog entry, customized by the
+// namespace, labels and variable values of the body. The body may be empty to
+// install the rule as it is.
+func (h *Handler) InstallCatalogEntry(w http.ResponseWriter, r *http.Request) {
+	entry, err := rules.CatalogEntry(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var install models.CatalogInstall
+	if err := json.NewDecoder(r.Body).Decode(&install); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := entry.Install(install, h.cfg.Ownership.NamespaceLabel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rule.ID != "" {
+		if _, err := h.ruleEngine.GetRule(rule.ID); err == nil {
+			http.Error(w, "rule "+rule.ID+" already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	// Infer the team from the namespace when not set
+	h.ownership.assign(rule)
+
+	// Reject rules that would aggregate protected metrics
+	if err := h.ruleEngine.CheckProtection(rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+	rule.UpdatedBy = h.author(r)
+
+	if err := h.ruleEngine.SaveRule(rule); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
@@ -0,0 +1,610 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/marcotuna/adaptive-metrics/internal/tracing"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Output formats of CloudWatch Metric Streams
+const (
+	cloudWatchFormatJSON   = "json"
+	cloudWatchFormatOTel07 = "opentelemetry0.7"
+)
+
+// Firehose HTTP endpoint delivery headers
+const (
+	firehoseRequestIDHeader = "X-Amz-Firehose-Request-Id"
+	firehoseAccessKeyHeader = "X-Amz-Firehose-Access-Key"
+)
+
+// firehoseRequest is the body of a Firehose HTTP endpoint delivery
+type firehoseRequest struct {
+	RequestID string `json:"requestId"`
+	Timestamp int64  `json:"timestamp"`
+	Records   []struct {
+		// Data is a base64 encoded record
+		Data []byte `json:"data"`
+	} `json:"records"`
+}
+
+// cloudWatchDatapoint is the statistic set of a CloudWatch metric over one
+// period, as streamed in either format
+type cloudWatchDatapoint struct {
+	Namespace  string
+	MetricName string
+	Dimensions map[string]string
+	AccountID  string
+	Region     string
+	// Timestamp in milliseconds
+	Timestamp   int64
+	Min         float64
+	Max         float64
+	Sum         float64
+	Count       float64
+	Percentiles map[float64]float64
+}
+
+// cloudWatchJSONRecord is a line of a record of the JSON output format
+type cloudWatchJSONRecord struct {
+	AccountID  string             `json:"account_id"`
+	Region     string             `json:"region"`
+	Namespace  string             `json:"namespace"`
+	MetricName string             `json:"metric_name"`
+	Dimensions map[string]string  `json:"dimensions"`
+	Timestamp  int64              `json:"timestamp"`
+	Value      map[string]float64 `json:"value"`
+}
+
+// CloudWatchIngest receives AWS CloudWatch Metric Streams delivered by
+// Firehose to an HTTP endpoint, in the JSON or OpenTelemetry 0.7 output
+// format. Every statistic of a datapoint becomes a series, with the
+// dimensions as labels, and is ingested like remote write. Responses follow
+// the Firehose format so failed deliveries are retried.
+func (h *Handler) CloudWatchIngest(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Header.Get(firehoseRequestIDHeader)
+	respond := func(status int, message string) {
+		response := map[string]interface{}{
+			"requestId": requestID,
+			"timestamp": time.Now().UnixMilli(),
+		}
+		if message != "" {
+			response["errorMessage"] = message
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+
+	accessKey := h.cfg.Ingest.CloudWatch.AccessKey
+	if accessKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(firehoseAccessKeyHeader)), []byte(accessKey)) != 1 {
+		respond(http.StatusUnauthorized, "invalid access key")
+		return
+	}
+
+	if !h.ingest.enter() {
+		respond(http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+	defer h.ingest.leave()
+
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	_, span := tracing.Tracer().Start(ctx, "cloudwatch.receive",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("request.id", requestID)))
+	defer span.End()
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != cloudWatchFormatJSON && format != cloudWatchFormatOTel07 {
+		respond(http.StatusBadRequest, fmt.Sprintf("invalid format %q: must be %s or %s", format, cloudWatchFormatJSON, cloudWatchFormatOTel07))
+		return
+	}
+
+	startTime := time.Now()
+	r.Body = http.MaxBytesReader(w, r.Body, maxAgentRequestBytes)
+	datapoints, err := decodeFirehoseRequest(r, format)
+	if err != nil {
+		logger.LogWarnWithFields("Rejecting CloudWatch metric stream delivery", logger.Fields{
+			"request_id": requestID,
+			"error":      err.Error(),
+		})
+		span.SetStatus(codes.Error, err.Error())
+		status := http.StatusBadRequest
+		if requestTooLarge(err) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		respond(status, err.Error())
+		return
+	}
+	req := cloudWatchWriteRequest(datapoints, h.cfg.Ingest.CloudWatch.MetricPrefix)
+
+	// The pipeline answers like remote write; its outcome is translated to a
+	// Firehose response
+	captured := &capturedResponse{header: make(http.Header)}
+	h.ingestWriteRequest(captured, r, req, span, requestID, startTime)
+	if retryAfter := captured.header.Get("Retry-After"); retryAfter != "" {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+	if captured.status >= http.StatusBadRequest {
+		respond(captured.status, strings.TrimSpace(captured.body.String()))
+		return
+	}
+	respond(http.StatusOK, "")
+}
+
+// capturedResponse records a response instead of sending it
+type capturedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *capturedResponse) Header() http.Header {
+	return c.header
+}
+
+func (c *capturedResponse) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *capturedResponse) Write(data []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+	return c.body.Write(data)
+}
+
+// decodeFirehoseRequest decodes the datapoints of the records of a Firehose
+// delivery. Without a format, each record is decoded as JSON if it looks
+// like JSON, and as OpenTelemetry 0.7 otherwise. Gzip bodies decompressing
+// to more than maxAgentDecodedBytes are rejected.
+func decodeFirehoseRequest(r *http.Request, format string) ([]cloudWatchDatapoint, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			if requestTooLarge(err) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer gz.Close()
+		decoded, err := readDecoded(gz)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(decoded)
+	}
+
+	var delivery firehoseRequest
+	if err := json.NewDecoder(body).Decode(&delivery); err != nil {
+		return nil, fmt.Errorf("invalid Firehose request: %w", err)
+	}
+
+	var datapoints []cloudWatchDatapoint
+	for i, record := range delivery.Records {
+		var decoded []cloudWatchDatapoint
+		var err error
+		switch {
+		case format == cloudWatchFormatJSON:
+			decoded, err = decodeCloudWatchJSON(record.Data)
+		case format == cloudWatchFormatOTel07:
+			decoded, err = decodeCloudWatchOTel07(record.Data)
+		case bytes.HasPrefix(bytes.TrimSpace(record.Data), []byte("{")):
+			decoded, err = decodeCloudWatchJSON(record.Data)
+			// A length-delimited message of 123 bytes starts with { too
+			if err != nil {
+				if otelDecoded, otelErr := decodeCloudWatchOTel07(record.Data); otelErr == nil {
+					decoded, err = otelDecoded, nil
+				}
+			}
+		default:
+			decoded, err = decodeCloudWatchOTel07(record.Data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		datapoints = append(datapoints, decoded...)
+	}
+	return datapoints, nil
+}
+
+// decodeCloudWatchJSON decodes a record of the JSON output format, which
+// holds one datapoint per line
+func decodeCloudWatchJSON(data []byte) ([]cloudWatchDatapoint, error) {
+	var datapoints []cloudWatchDatapoint
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record cloudWatchJSONRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("invalid JSON datapoint: %w", err)
+		}
+		if record.Namespace == "" || record.MetricName == "" {
+			return nil, fmt.Errorf("invalid JSON datapoint: missing namespace or metric name")
+		}
+
+		datapoint := cloudWatchDatapoint{
+			Namespace:   record.Namespace,
+			MetricName:  record.MetricName,
+			Dimensions:  record.Dimensions,
+			AccountID:   record.AccountID,
+			Region:      record.Region,
+			Timestamp:   record.Timestamp,
+			Min:         record.Value["min"],
+			Max:         record.Value["max"],
+			Sum:         record.Value["sum"],
+			Count:       record.Value["count"],
+			Percentiles: make(map[float64]float64),
+		}
+		// Additional statistics are percentiles such as p99 or p99.9
+		for statistic, value := range record.Value {
+			if !strings.HasPrefix(statistic, "p") {
+				continue
+			}
+			if percentile, err := strconv.ParseFloat(statistic[1:], 64); err == nil {
+				datapoint.Percentiles[percentile/100] = value
+			}
+		}
+		datapoints = append(datapoints, datapoint)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid JSON datapoints: %w", err)
+	}
+	return datapoints, nil
+}
+
+// decodeCloudWatchOTel07 decodes a record of the OpenTelemetry 0.7 output
+// format: length-delimited ExportMetricsServiceRequest messages whose metrics
+// are summaries. Quantile 0 is the minimum and quantile 1 the maximum.
+func decodeCloudWatchOTel07(data []byte) ([]cloudWatchDatapoint, error) {
+	var datapoints []cloudWatchDatapoint
+	for len(data) > 0 {
+		size, n := protowire.ConsumeVarint(data)
+		if n < 0 || uint64(len(data)-n) < size {
+			return nil, fmt.Errorf("invalid OpenTelemetry record: truncated message")
+		}
+		message := data[n : n+int(size)]
+		data = data[n+int(size):]
+
+		// ExportMetricsServiceRequest: resource_metrics = 1
+		err := forEachField(message, func(num protowire.Number, value []byte, _ uint64) error {
+			if num != 1 {
+				return nil
+			}
+			decoded, err := decodeOTel07ResourceMetrics(value)
+			datapoints = append(datapoints, decoded...)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenTelemetry record: %w", err)
+		}
+	}
+	return datapoints, nil
+}
+
+// decodeOTel07ResourceMetrics decodes a ResourceMetrics message: resource = 1
+// with the account and region attributes, instrumentation_library_metrics = 2
+// holding metrics = 2
+func decodeOTel07ResourceMetrics(data []byte) ([]cloudWatchDatapoint, error) {
+	attributes := make(map[string]string)
+	var metrics [][]byte
+	err := forEachField(data, func(num protowire.Number, value []byte, _ uint64) error {
+		switch num {
+		case 1:
+			// Resource: attributes = 1
+			return forEachField(value, func(num protowire.Number, value []byte, _ uint64) error {
+				if num == 1 {
+					return decodeOTel07KeyValue(value, attributes)
+				}
+				return nil
+			})
+		case 2:
+			return forEachField(value, func(num protowire.Number, value []byte, _ uint64) error {
+				if num == 2 {
+					metrics = append(metrics, value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var datapoints []cloudWatchDatapoint
+	for _, metric := range metrics {
+		decoded, err := decodeOTel07Metric(metric, attributes["cloud.account.id"], attributes["cloud.region"])
+		if err != nil {
+			return nil, err
+		}
+		datapoints = append(datapoints, decoded...)
+	}
+	return datapoints, nil
+}
+
+// decodeOTel07Metric decodes a Metric message: name = 1 and double_summary =
+// 11 holding data_points = 1. Other metric types are not streamed by
+// CloudWatch and are skipped.
+func decodeOTel07Metric(data []byte, accountID, region string) ([]cloudWatchDatapoint, error) {
+	var name string
+	var points [][]byte
+	err := forEachField(data, func(num protowire.Number, value []byte, _ uint64) error {
+		switch num {
+		case 1:
+			name = string(value)
+		case 11:
+			return forEachField(value, func(num protowire.Number, value []byte, _ uint64) error {
+				if num == 1 {
+					points = append(points, value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var datapoints []cloudWatchDatapoint
+	for _, point := range points {
+		datapoint := cloudWatchDatapoint{
+			AccountID:   accountID,
+			Region:      region,
+			Dimensions:  make(map[string]string),
+			Percentiles: make(map[float64]float64),
+		}
+		// DoubleSummaryDataPoint: labels = 1, time_unix_nano = 3, count = 4,
+		// sum = 5, quantile_values = 6
+		err := forEachField(point, func(num protowire.Number, value []byte, scalar uint64) error {
+			switch num {
+			case 1:
+				labels := make(map[string]string)
+				if err := decodeOTel07KeyValue(value, labels); err != nil {
+					return err
+				}
+				for key, labelValue := range labels {
+					switch key {
+					case "Namespace":
+						datapoint.Namespace = labelValue
+					case "MetricName":
+						datapoint.MetricName = labelValue
+					default:
+						datapoint.Dimensions[key] = labelValue
+					}
+				}
+			case 3:
+				datapoint.Timestamp = int64(scalar / uint64(time.Millisecond))
+			case 4:
+				datapoint.Count = float64(scalar)
+			case 5:
+				datapoint.Sum = math.Float64frombits(scalar)
+			case 6:
+				var quantile, quantileValue float64
+				err := forEachField(value, func(num protowire.Number, _ []byte, scalar uint64) error {
+					switch num {
+					case 1:
+						quantile = math.Float64frombits(scalar)
+					case 2:
+						quantileValue = math.Float64frombits(scalar)
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				switch quantile {
+				case 0:
+					datapoint.Min = quantileValue
+				case 1:
+					datapoint.Max = quantileValue
+				default:
+					datapoint.Percentiles[quantile] = quantileValue
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The metric name is amazonaws.com/<namespace>/<metric name>
+		if datapoint.Namespace == "" || datapoint.MetricName == "" {
+			trimmed := strings.TrimPrefix(name, "amazonaws.com/")
+			if i := strings.LastIndex(trimmed, "/"); i > 0 {
+				datapoint.Namespace, datapoint.MetricName = trimmed[:i], trimmed[i+1:]
+			}
+		}
+		if datapoint.Namespace == "" || datapoint.MetricName == "" {
+			return nil, fmt.Errorf("metric %q has no namespace or metric name", name)
+		}
+		datapoints = append(datapoints, datapoint)
+	}
+	return datapoints, nil
+}
+
+// decodeOTel07KeyValue decodes a StringKeyValue or a KeyValue with a string
+// value into values: key = 1, value = 2 either as a string or an AnyValue
+// with string_value = 1
+func decodeOTel07KeyValue(data []byte, values map[string]string) error {
+	var key, value string
+	err := forEachField(data, func(num protowire.Number, field []byte, _ uint64) error {
+		switch num {
+		case 1:
+			key = string(field)
+		case 2:
+			value = string(field)
+			// An AnyValue holding a string starts with the tag of string_value
+			if len(field) > 0 && field[0] == byte(protowire.EncodeTag(1, protowire.BytesType)) {
+				if str, n := protowire.ConsumeBytes(field[1:]); n == len(field)-1 {
+					value = string(str)
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil && key != "" {
+		values[key] = value
+	}
+	return err
+}
+
+// forEachField calls fn with each field of a protobuf message: the contents
+// of length-delimited fields, or the value of scalar ones
+func forEachField(data []byte, fn func(num protowire.Number, value []byte, scalar uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		var scalar uint64
+		switch typ {
+		case protowire.VarintType:
+			scalar, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			scalar, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var fixed uint32
+			fixed, n = protowire.ConsumeFixed32(data)
+			scalar = uint64(fixed)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, value, scalar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloudWatchWriteRequest converts datapoints into a write request with a
+// gauge series per statistic: prefix_namespace_metric_statistic with the
+// minimum, maximum, sum, sample count and percentiles. Namespaces drop the
+// AWS/ prefix, and names and dimensions are converted to snake case.
+func cloudWatchWriteRequest(datapoints []cloudWatchDatapoint, prefix string) *prompb.WriteRequest {
+	req := &prompb.WriteRequest{}
+	families := make(map[string]bool)
+	for _, datapoint := range datapoints {
+		parts := []string{snakeCase(strings.TrimPrefix(datapoint.Namespace, "AWS/")), snakeCase(datapoint.MetricName)}
+		if prefix != "" {
+			parts = append([]string{prefix}, parts...)
+		}
+		base := strings.Join(parts, "_")
+
+		labels := []prompb.Label{}
+		if datapoint.AccountID != "" {
+			labels = append(labels, prompb.Label{Name: "account_id", Value: datapoint.AccountID})
+		}
+		if datapoint.Region != "" {
+			labels = append(labels, prompb.Label{Name: "region", Value: datapoint.Region})
+		}
+		for dimension, value := range datapoint.Dimensions {
+			if value != "" {
+				labels = append(labels, prompb.Label{Name: snakeCase(dimension), Value: value})
+			}
+		}
+
+		statistics := map[string]float64{
+			"minimum":      datapoint.Min,
+			"maximum":      datapoint.Max,
+			"sum":          datapoint.Sum,
+			"sample_count": datapoint.Count,
+		}
+		for percentile, value := range datapoint.Percentiles {
+			statistic := "p" + strings.ReplaceAll(strconv.FormatFloat(percentile*100, 'f', -1, 64), ".", "_")
+			statistics[statistic] = value
+		}
+
+		names := make([]string, 0, len(statistics))
+		for statistic := range statistics {
+			names = append(names, statistic)
+		}
+		sort.Strings(names)
+		for _, statistic := range names {
+			name := base + "_" + statistic
+			families[name] = true
+			series := prompb.TimeSeries{
+				Labels:  append([]prompb.Label{{Name: "__name__", Value: name}}, labels...),
+				Samples: []prompb.Sample{{Value: statistics[statistic], Timestamp: datapoint.Timestamp}},
+			}
+			sort.Slice(series.Labels, func(i, j int) bool { return series.Labels[i].Name < series.Labels[j].Name })
+			req.Timeseries = append(req.Timeseries, series)
+		}
+	}
+
+	// Statistics of a period are gauges, even the sums and sample counts
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		req.Metadata = append(req.Metadata, prompb.MetricMetadata{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: name})
+	}
+	return req
+}
+
+// snakeCase converts a CloudWatch name such as DiskWriteOps or
+// ApplicationELB to a Prometheus name such as disk_write_ops or
+// application_elb. Characters not allowed in names become underscores.
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteByte('_')
+		}
+	}
+	snake := b.String()
+	for strings.Contains(snake, "__") {
+		snake = strings.ReplaceAll(snake, "__", "_")
+	}
+	return strings.Trim(snake, "_")
+}
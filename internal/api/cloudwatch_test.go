@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// firehoseBody returns a Firehose delivery of records
+func firehoseBody(records ...[]byte) string {
+	var encoded []string
+	for _, record := range records {
+		encoded = append(encoded, `{"data":"`+base64.StdEncoding.EncodeToString(record)+`"}`)
+	}
+	return `{"requestId":"req-1","timestamp":1700000000000,"records":[` + strings.Join(encoded, ",") + `]}`
+}
+
+// otel07Record encodes a length-delimited OpenTelemetry 0.7 request with a
+// single CPUUtilization summary data point
+func otel07Record() []byte {
+	message := func(fields ...func([]byte) []byte) []byte {
+		var b []byte
+		for _, field := range fields {
+			b = field(b)
+		}
+		return b
+	}
+	bytesField := func(num protowire.Number, value []byte) func([]byte) []byte {
+		return func(b []byte) []byte {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			return protowire.AppendBytes(b, value)
+		}
+	}
+	fixed64Field := func(num protowire.Number, value uint64) func([]byte) []byte {
+		return func(b []byte) []byte {
+			b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+			return protowire.AppendFixed64(b, value)
+		}
+	}
+	stringKeyValue := func(key, value string) []byte {
+		return message(bytesField(1, []byte(key)), bytesField(2, []byte(value)))
+	}
+	attribute := func(key, value string) []byte {
+		return message(bytesField(1, []byte(key)), bytesField(2, message(bytesField(1, []byte(value)))))
+	}
+	quantile := func(q, v float64) []byte {
+		return message(fixed64Field(1, math.Float64bits(q)), fixed64Field(2, math.Float64bits(v)))
+	}
+
+	point := message(
+		bytesField(1, stringKeyValue("Namespace", "AWS/EC2")),
+		bytesField(1, stringKeyValue("MetricName", "CPUUtilization")),
+		bytesField(1, stringKeyValue("InstanceId", "i-123")),
+		fixed64Field(3, 1700000000000000000),
+		fixed64Field(4, 5),
+		fixed64Field(5, math.Float64bits(20)),
+		bytesField(6, quantile(0, 1)),
+		bytesField(6, quantile(1, 9)),
+		bytesField(6, quantile(0.99, 8)),
+	)
+	metric := message(
+		bytesField(1, []byte("amazonaws.com/AWS/EC2/CPUUtilization")),
+		bytesField(11, message(bytesField(1, point))),
+	)
+	resource := message(bytesField(1, attribute("cloud.account.id", "123456789012")), bytesField(1, attribute("cloud.region", "eu-west-1")))
+	request := message(bytesField(1, message(bytesField(1, resource), bytesField(2, message(bytesField(2, metric))))))
+
+	return protowire.AppendBytes(nil, request)
+}
+
+func TestDecodeFirehoseRequest(t *testing.T) {
+	jsonRecord := []byte(`{"metric_stream_name":"s","account_id":"123456789012","region":"eu-west-1","namespace":"AWS/EC2","metric_name":"CPUUtilization","dimensions":{"InstanceId":"i-123"},"timestamp":1700000000000,"value":{"max":9,"min":1,"sum":20,"count":5,"p99":8},"unit":"Percent"}` + "\n")
+
+	for _, tt := range []struct {
+		name   string
+		record []byte
+		format string
+	}{
+		{"json", jsonRecord, ""},
+		{"json explicit", jsonRecord, cloudWatchFormatJSON},
+		{"opentelemetry 0.7", otel07Record(), ""},
+		{"opentelemetry 0.7 explicit", otel07Record(), cloudWatchFormatOTel07},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/ingest/cloudwatch", strings.NewReader(firehoseBody(tt.record)))
+			datapoints, err := decodeFirehoseRequest(r, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := cloudWatchWriteRequest(datapoints, "aws")
+
+			want := []string{
+				"__name__=aws_ec2_cpu_utilization_maximum,account_id=123456789012,instance_id=i-123,region=eu-west-1 9@1700000000000",
+				"__name__=aws_ec2_cpu_utilization_minimum,account_id=123456789012,instance_id=i-123,region=eu-west-1 1@1700000000000",
+				"__name__=aws_ec2_cpu_utilization_p99,account_id=123456789012,instance_id=i-123,region=eu-west-1 8@1700000000000",
+				"__name__=aws_ec2_cpu_utilization_sample_count,account_id=123456789012,instance_id=i-123,region=eu-west-1 5@1700000000000",
+				"__name__=aws_ec2_cpu_utilization_sum,account_id=123456789012,instance_id=i-123,region=eu-west-1 20@1700000000000",
+			}
+			if len(req.Timeseries) != len(want) {
+				t.Fatalf("got %d series, want %d", len(req.Timeseries), len(want))
+			}
+			for i, ts := range req.Timeseries {
+				if got := seriesString(ts); got != want[i] {
+					t.Errorf("series %d = %q, want %q", i, got, want[i])
+				}
+			}
+			if len(req.Metadata) != len(want) {
+				t.Errorf("metadata = %+v", req.Metadata)
+			}
+		})
+	}
+}
+
+func TestDecodeFirehoseRequest_DecompressionLimit(t *testing.T) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte(`{"records":[{"data":"`))
+	gz.Write(bytes.Repeat([]byte("A"), maxAgentDecodedBytes))
+	gz.Write([]byte(`"}]}`))
+	gz.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/ingest/cloudwatch", &body)
+	r.Header.Set("Content-Encoding", "gzip")
+	if _, err := decodeFirehoseRequest(r, ""); !errors.Is(err, errDecodedBodyTooLarge) {
+		t.Errorf("error = %v, want %v", err, errDecodedBodyTooLarge)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"DiskWriteOps":   "disk_write_ops",
+		"CPUUtilization": "cpu_utilization",
+		"ApplicationELB": "application_elb",
+		"EC2":            "ec2",
+		"Custom/My App":  "custom_my_app",
+	} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCloudWatchIngest_AccessKey(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Ingest.CloudWatch.AccessKey = "secret"
+	h := &Handler{cfg: cfg}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/ingest/cloudwatch", strings.NewReader(firehoseBody()))
+	r.Header.Set(firehoseRequestIDHeader, "req-1")
+	r.Header.Set(firehoseAccessKeyHeader, "wrong")
+	w := httptest.NewRecorder()
+	h.CloudWatchIngest(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var response map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response["requestId"] != "req-1" || response["errorMessage"] == nil {
+		t.Errorf("response = %v", response)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/controlplane"
+)
+
+// ControlPlaneHandler serves the bundle of a central instance and the state
+// of the polling of an edge instance. Either side may be nil when disabled.
+type ControlPlaneHandler struct {
+	publisher *controlplane.Publisher
+	poller    *controlplane.Poller
+}
+
+// NewControlPlaneHandler creates a handler for a publisher and a poller
+func NewControlPlaneHandler(publisher *controlplane.Publisher, poller *controlplane.Poller) *ControlPlaneHandler {
+	return &ControlPlaneHandler{publisher: publisher, poller: poller}
+}
+
+// SetupRoutes sets up the routes for the control plane API
+func (h *ControlPlaneHandler) SetupRoutes(router *mux.Router) {
+	if h.publisher != nil {
+		router.HandleFunc("/control-plane/bundle", h.GetBundle).Methods("GET", "OPTIONS")
+		router.HandleFunc("/control-plane/instances", h.GetInstances).Methods("GET", "OPTIONS")
+	}
+	if h.poller != nil {
+		router.HandleFunc("/control-plane/status", h.GetStatus).Methods("GET", "OPTIONS")
+		router.HandleFunc("/control-plane/poll", h.Poll).Methods("POST", "OPTIONS")
+	}
+}
+
+// GetBundle returns the signed bundle of the rules and config fragments, or
+// 304 when the client has the current one
+func (h *ControlPlaneHandler) GetBundle(w http.ResponseWriter, r *http.Request) {
+	bundle, etag, err := h.publisher.Bundle()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.publisher.RecordPoll(r, etag)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bundle)
+}
+
+// etagMatches reports whether an If-None-Match header lists an ETag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// GetInstances returns the edge instances that polled the bundle
+func (h *ControlPlaneHandler) GetInstances(w http.ResponseWriter, r *http.Request) {
+	_, etag, err := h.publisher.Bundle()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	instances := h.publisher.Instances(etag)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"etag":      etag,
+		"instances": instances,
+		"total":     len(instances),
+	})
+}
+
+// GetStatus returns the state of the polling of the bundle
+func (h *ControlPlaneHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.poller.Status())
+}
+
+// Poll polls the bundle right away
+func (h *ControlPlaneHandler) Poll(w http.ResponseWriter, r *http.Request) {
+	if err := h.poller.Poll(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.poller.Status())
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// SetupDebugRoutes sets up the routes for inspecting the open aggregation
+// buckets and what rules did in their last intervals, and for debug dumps
+func (h *Handler) SetupDebugRoutes(router *mux.Router) {
+	router.HandleFunc("/debug/buckets", h.ListBuckets).Methods("GET", "OPTIONS")
+	router.HandleFunc("/debug/buckets/{ruleID}", h.GetRuleBuckets).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rules/{id}/comparison", h.GetRuleComparison).Methods("GET", "OPTIONS")
+	router.HandleFunc("/debug/dump", h.DebugDump).Methods("POST", "OPTIONS")
+}
+
+// ListBuckets returns the open aggregation buckets of all rules
+func (h *Handler) ListBuckets(w http.ResponseWriter, r *http.Request) {
+	if h.processor == nil {
+		http.Error(w, "Processor is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	buckets := h.processor.Buckets()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"buckets": buckets,
+		"total":   len(buckets),
+	})
+}
+
+// GetRuleBuckets returns the open buckets of a rule with their segments, the
+// ones holding the most samples first. The limit parameter caps the segments
+// of each bucket (default 100, 0 for all).
+func (h *Handler) GetRuleBuckets(w http.ResponseWriter, r *http.Request) {
+	if h.processor == nil {
+		http.Error(w, "Processor is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	ruleID := mux.Vars(r)["ruleID"]
+	if _, err := h.ruleEngine.GetRule(ruleID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid 'limit' parameter", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	buckets := h.processor.RuleBuckets(ruleID, limit)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rule_id": ruleID,
+		"buckets": buckets,
+		"total":   len(buckets),
+	})
+}
+
+// GetRuleComparison returns, for the last flushed intervals of a rule, the
+// samples and series it matched next to those it emitted, oldest first. The
+// examples parameter adds a few series from each side.
+func (h *Handler) GetRuleComparison(w http.ResponseWriter, r *http.Request) {
+	if h.processor == nil {
+		http.Error(w, "Processor is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	ruleID := mux.Vars(r)["id"]
+	if _, err := h.ruleEngine.GetRule(ruleID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	examples := false
+	if v := r.URL.Query().Get("examples"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid 'examples' parameter", http.StatusBadRequest)
+			return
+		}
+		examples = parsed
+	}
+
+	intervals := h.processor.RuleComparison(ruleID)
+	if !examples {
+		for i := range intervals {
+			intervals[i].InputExamples = nil
+			intervals[i].OutputExamples = nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rule_id":   ruleID,
+		"intervals": intervals,
+		"total":     len(intervals),
+	})
+}
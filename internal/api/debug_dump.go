@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dumpStateTimeout bounds the collection of each part of the state in a
+// debug dump, which may wait on locks held by a wedged goroutine
+const dumpStateTimeout = 5 * time.Second
+
+// Debug dumps are named dumpFilePrefix, their UTC time then dumpFileSuffix,
+// so their names sort by time
+const (
+	dumpFilePrefix     = "adaptive-metrics-dump-"
+	dumpFileSuffix     = ".txt"
+	dumpFileTimeFormat = "20060102T150405.000000000Z"
+)
+
+// WriteDebugDump writes a debug bundle of the running process to a file in
+// server.debug_dump_dir and returns its path: the goroutine stacks, the
+// build and uptime, queue depths, open buckets, a checksum of the rules and
+// the dropped sample counters. The stacks are written first, as the other
+// parts may not be collectable when the process is wedged.
+func (h *Handler) WriteDebugDump() (string, error) {
+	dir := h.cfg.Server.DebugDumpDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create debug dump directory: %w", err)
+	}
+
+	now := time.Now()
+	file, err := createDumpFile(dir, now)
+	if err != nil {
+		return "", fmt.Errorf("failed to create debug dump: %w", err)
+	}
+	defer file.Close()
+	path := file.Name()
+
+	fmt.Fprintf(file, "# Adaptive Metrics debug dump at %s\n\n## Goroutines\n\n", now.Format(time.RFC3339))
+	if err := pprof.Lookup("goroutine").WriteTo(file, 2); err != nil {
+		return "", fmt.Errorf("failed to write goroutine stacks: %w", err)
+	}
+	file.Sync()
+
+	state := map[string]interface{}{
+		"build":          version.Get(),
+		"uptime_seconds": int64(now.Sub(startedAt).Seconds()),
+		"dropped":        droppedCounters(),
+		"rules":          collectDumpState(h.dumpRules),
+	}
+	if h.processor != nil {
+		state["processor"] = collectDumpState(func() interface{} { return h.processor.Stats() })
+		state["buckets"] = collectDumpState(func() interface{} { return h.processor.Buckets() })
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode state: %w", err)
+	}
+	fmt.Fprintf(file, "\n## State\n\n%s\n", data)
+
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write debug dump: %w", err)
+	}
+	logger.LogInfoWithFields("Wrote debug dump", logger.Fields{
+		"path": path,
+	})
+	pruneDebugDumps(dir, h.cfg.Server.DebugDumpKeep)
+	return path, nil
+}
+
+// createDumpFile creates the file of a dump taken at a time. A dump never
+// replaces another: when a file of the same time exists, the time in the
+// name is moved forward, so that names keep sorting by time.
+func createDumpFile(dir string, at time.Time) (*os.File, error) {
+	for {
+		path := filepath.Join(dir, dumpFilePrefix+at.UTC().Format(dumpFileTimeFormat)+dumpFileSuffix)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return file, err
+		}
+		at = at.Add(time.Nanosecond)
+	}
+}
+
+// pruneDebugDumps deletes the oldest debug dumps of a directory beyond keep
+func pruneDebugDumps(dir string, keep int) {
+	if keep <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var dumps []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), dumpFilePrefix) && strings.HasSuffix(entry.Name(), dumpFileSuffix) {
+			dumps = append(dumps, entry.Name())
+		}
+	}
+	sort.Strings(dumps)
+	for _, name := range dumps[:max(len(dumps)-keep, 0)] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			logger.LogWarnWithFields("Failed to delete old debug dump", logger.Fields{
+				"path":  filepath.Join(dir, name),
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+// collectDumpState returns the result of collect, or an error message when
+// it does not return in time
+func collectDumpState(collect func() interface{}) interface{} {
+	done := make(chan interface{}, 1)
+	go func() {
+		done <- collect()
+	}()
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(dumpStateTimeout):
+		return map[string]string{"error": "timed out, see the goroutine stacks"}
+	}
+}
+
+// dumpRules returns the number of rules and their checksum
+func (h *Handler) dumpRules() interface{} {
+	rules, err := h.ruleEngine.GetRules()
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	checksum, err := models.HashRules(rules)
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	return map[string]interface{}{
+		"count":    len(rules),
+		"checksum": checksum,
+	}
+}
+
+// droppedCounters returns the values of the counters of dropped samples and
+// series, keyed by metric name and labels
+func droppedCounters() map[string]float64 {
+	counters := make(map[string]float64)
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return counters
+	}
+	for _, family := range families {
+		if !strings.Contains(family.GetName(), "dropped") {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+			}
+			sort.Strings(labels)
+			counters[family.GetName()+"{"+strings.Join(labels, ",")+"}"] = metric.GetCounter().GetValue()
+		}
+	}
+	return counters
+}
+
+// DebugDump writes a debug dump and returns its path. Dumps are rejected with
+// 429 until server.debug_dump_interval_seconds passed since the last one.
+func (h *Handler) DebugDump(w http.ResponseWriter, r *http.Request) {
+	h.dumpMu.Lock()
+	defer h.dumpMu.Unlock()
+	interval := time.Duration(h.cfg.Server.DebugDumpIntervalSeconds) * time.Second
+	if wait := time.Until(h.lastDump.Add(interval)); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many debug dumps", http.StatusTooManyRequests)
+		return
+	}
+	h.lastDump = time.Now()
+
+	path, err := h.WriteDebugDump()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"path":   path,
+	})
+}
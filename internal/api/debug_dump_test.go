@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+	pkgmetrics "github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+func TestHandler_WriteDebugDump(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Aggregator.RulesPath = t.TempDir()
+	cfg.Server.DebugDumpDir = filepath.Join(t.TempDir(), "dumps")
+	engine, err := rules.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	pkgmetrics.RecordDroppedSamples(pkgmetrics.StageAggregation, "relabel_error", 3)
+
+	h := &Handler{cfg: cfg, ruleEngine: engine}
+	path, err := h.WriteDebugDump()
+	if err != nil {
+		t.Fatalf("WriteDebugDump() error = %v", err)
+	}
+	if filepath.Dir(path) != cfg.Server.DebugDumpDir {
+		t.Errorf("dump written to %s, want it in %s", path, cfg.Server.DebugDumpDir)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := string(data)
+	for _, want := range []string{
+		"## Goroutines",
+		"TestHandler_WriteDebugDump",
+		`"checksum"`,
+		`adaptive_metrics_dropped_total{reason=\"relabel_error\",stage=\"aggregation\"}`,
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump does not contain %s", want)
+		}
+	}
+}
+
+func TestCreateDumpFile_Unique(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Now()
+
+	// Dumps of the same time get distinct names, in the order they were taken
+	var names []string
+	for i := 0; i < 3; i++ {
+		file, err := createDumpFile(dir, at)
+		if err != nil {
+			t.Fatalf("createDumpFile() error = %v", err)
+		}
+		file.Close()
+		names = append(names, filepath.Base(file.Name()))
+	}
+	if names[0] == names[1] || names[1] == names[2] || !sort.StringsAreSorted(names) {
+		t.Errorf("dump names = %v, want distinct names sorted by time", names)
+	}
+}
+
+func TestHandler_DebugDumpLimits(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Aggregator.RulesPath = t.TempDir()
+	cfg.Server.DebugDumpDir = t.TempDir()
+	cfg.Server.DebugDumpKeep = 2
+	cfg.Server.DebugDumpIntervalSeconds = 60
+	engine, err := rules.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	h := &Handler{cfg: cfg, ruleEngine: engine}
+
+	// Dumps requested through the API are rate limited
+	w := httptest.NewRecorder()
+	h.DebugDump(w, httptest.NewRequest(http.MethodPost, "/api/v1/debug/dump", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("first dump status = %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.DebugDump(w, httptest.NewRequest(http.MethodPost, "/api/v1/debug/dump", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("second dump status = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Only the latest dumps are kept, and other files are left alone
+	other := filepath.Join(cfg.Server.DebugDumpDir, "notes.txt")
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := h.WriteDebugDump()
+		if err != nil {
+			t.Fatalf("WriteDebugDump() error = %v", err)
+		}
+		paths = append(paths, path)
+	}
+	entries, err := os.ReadDir(cfg.Server.DebugDumpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{filepath.Base(paths[1]), filepath.Base(paths[2]), "notes.txt"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// SetupDiscoveryRoutes sets up the Prometheus-compatible label and series
+// discovery routes, so Grafana can browse the series known to the usage
+// tracker before and after aggregation
+func (h *Handler) SetupDiscoveryRoutes(router *mux.Router) {
+	router.HandleFunc("/labels", h.DiscoveryLabels).Methods("GET", "POST", "OPTIONS")
+	router.HandleFunc("/label/{name}/values", h.DiscoveryLabelValues).Methods("GET", "OPTIONS")
+	router.HandleFunc("/series", h.DiscoverySeries).Methods("GET", "POST", "OPTIONS")
+}
+
+// discoveredSeries is a series known to the discovery endpoints, with when it
+// was first and last seen
+type discoveredSeries struct {
+	labels    map[string]string // Including the metric name
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// discoveryCatalog maps the metrics known to the discovery endpoints to
+// their series, by the canonical form of their labels
+type discoveryCatalog map[string]map[string]*discoveredSeries
+
+// add records a series, merging the times it was seen with those of the same
+// series added before
+func (c discoveryCatalog) add(name string, seriesLabels map[string]string, firstSeen, lastSeen time.Time) {
+	series, exists := c[name]
+	if !exists {
+		series = make(map[string]*discoveredSeries)
+		c[name] = series
+	}
+	key := labels.FromMap(seriesLabels).String()
+	if known, exists := series[key]; exists {
+		if firstSeen.Before(known.firstSeen) {
+			known.firstSeen = firstSeen
+		}
+		if lastSeen.After(known.lastSeen) {
+			known.lastSeen = lastSeen
+		}
+		return
+	}
+	series[key] = &discoveredSeries{labels: seriesLabels, firstSeen: firstSeen, lastSeen: lastSeen}
+}
+
+// discoveryCatalog returns the series known to the discovery endpoints: the
+// series of each metric sampled by the usage tracker, and the output series
+// of enabled rules they are aggregated into. The usage tracker keeps a
+// bounded sample of the series of each metric, so only label combinations
+// that actually occurred are listed, but not all of them.
+func (h *Handler) discoveryCatalog() discoveryCatalog {
+	catalog := make(discoveryCatalog)
+	inputs := make(map[string][]metrics.SampledSeries)
+	for _, name := range h.usageTracker.MetricNames() {
+		sampled := h.usageTracker.SampledSeries(name)
+		inputs[name] = sampled
+		for _, series := range sampled {
+			seriesLabels := make(map[string]string, len(series.Labels)+1)
+			for k, v := range series.Labels {
+				seriesLabels[k] = v
+			}
+			seriesLabels[labels.MetricName] = name
+			catalog.add(name, seriesLabels, series.FirstSeen, series.LastSeen)
+		}
+	}
+
+	rules, err := h.ruleEngine.GetRules()
+	if err != nil {
+		return catalog
+	}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		outputLabels, known := rule.OutputLabelNames()
+		if !known {
+			continue
+		}
+
+		for _, input := range rule.Matcher.Names() {
+			for _, series := range inputs[input] {
+				for _, name := range rule.OutputMetricNames() {
+					catalog.add(name, outputSeries(rule, name, outputLabels, series.Labels), series.FirstSeen, series.LastSeen)
+				}
+			}
+		}
+	}
+	return catalog
+}
+
+// outputSeries returns the labels of the output series of a rule an input
+// series is aggregated into
+func outputSeries(rule *models.Rule, name string, outputLabels []string, input map[string]string) map[string]string {
+	series := map[string]string{labels.MetricName: name}
+	for _, label := range outputLabels {
+		if value, exists := rule.Output.AdditionalLabels[label]; exists {
+			series[label] = value
+		} else if value, exists := input[label]; exists {
+			series[label] = value
+		}
+	}
+	if rule.Output.GaugeSemantics != "" && name == rule.Output.MetricName {
+		series[models.GaugeSemanticsLabel] = models.GaugeSemanticsFunction(rule.Output.GaugeSemantics)
+	}
+	return series
+}
+
+// discoveryRange returns the time range of the start and end parameters of a
+// request; a missing bound leaves the range open on that side
+func discoveryRange(r *http.Request) (start, end time.Time, err error) {
+	if value := r.Form.Get("start"); value != "" {
+		if start, err = parseDiscoveryTime(value); err != nil {
+			return start, end, err
+		}
+	}
+	if value := r.Form.Get("end"); value != "" {
+		if end, err = parseDiscoveryTime(value); err != nil {
+			return start, end, err
+		}
+		if !start.IsZero() && end.Before(start) {
+			return start, end, errors.New("end timestamp must not be before start time")
+		}
+	}
+	return start, end, nil
+}
+
+// parseDiscoveryTime parses a timestamp given in Unix seconds or RFC 3339, as
+// Prometheus accepts them
+func parseDiscoveryTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*float64(time.Second))), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", value)
+}
+
+// matchingSeries returns the series of the catalog seen within the start and
+// end of a request, and selected by any of its match[] selectors, or all of
+// them when there are none
+func (h *Handler) matchingSeries(r *http.Request) ([]map[string]string, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	start, end, err := discoveryRange(r)
+	if err != nil {
+		return nil, err
+	}
+	var selectors [][]*labels.Matcher
+	for _, selector := range r.Form["match[]"] {
+		matchers, err := parser.ParseMetricSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, matchers)
+	}
+
+	catalog := h.discoveryCatalog()
+	names := make([]string, 0, len(catalog))
+	for name := range catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []map[string]string
+	for _, name := range names {
+		keys := make([]string, 0, len(catalog[name]))
+		for key := range catalog[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := catalog[name][key]
+			if (!start.IsZero() && series.lastSeen.Before(start)) || (!end.IsZero() && series.firstSeen.After(end)) {
+				continue
+			}
+			if len(selectors) == 0 || seriesMatches(series.labels, selectors) {
+				result = append(result, series.labels)
+			}
+		}
+	}
+	return result, nil
+}
+
+// seriesMatches reports whether a series is selected by any of the selectors
+func seriesMatches(series map[string]string, selectors [][]*labels.Matcher) bool {
+	for _, matchers := range selectors {
+		matched := true
+		for _, matcher := range matchers {
+			if !matcher.Matches(series[matcher.Name]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscoveryLabels returns the label names of the series matching the
+// match[] selectors, in the format of the Prometheus /api/v1/labels endpoint
+func (h *Handler) DiscoveryLabels(w http.ResponseWriter, r *http.Request) {
+	series, err := h.matchingSeries(r)
+	if err != nil {
+		writeDiscoveryError(w, err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, s := range series {
+		for name := range s {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	writeDiscoveryData(w, names)
+}
+
+// DiscoveryLabelValues returns the values of a label in the series matching
+// the match[] selectors, in the format of the Prometheus
+// /api/v1/label/{name}/values endpoint
+func (h *Handler) DiscoveryLabelValues(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	series, err := h.matchingSeries(r)
+	if err != nil {
+		writeDiscoveryError(w, err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	values := []string{}
+	for _, s := range series {
+		if value, exists := s[name]; exists && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	writeDiscoveryData(w, values)
+}
+
+// DiscoverySeries returns the sampled series matching the match[] selectors,
+// in the format of the Prometheus /api/v1/series endpoint. At least one
+// selector is required, as in Prometheus.
+func (h *Handler) DiscoverySeries(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeDiscoveryError(w, err)
+		return
+	}
+	if len(r.Form["match[]"]) == 0 {
+		writeDiscoveryError(w, errNoMatchSelector)
+		return
+	}
+
+	series, err := h.matchingSeries(r)
+	if err != nil {
+		writeDiscoveryError(w, err)
+		return
+	}
+	if series == nil {
+		series = []map[string]string{}
+	}
+	writeDiscoveryData(w, series)
+}
+
+// errNoMatchSelector is returned for series requests without a selector
+var errNoMatchSelector = errors.New("no match[] parameter provided")
+
+// writeDiscoveryData responds with data in the Prometheus API envelope
+func writeDiscoveryData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+// writeDiscoveryError responds with an invalid request in the Prometheus API
+// envelope
+func writeDiscoveryError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "error",
+		"errorType": "bad_data",
+		"error":     err.Error(),
+	})
+}
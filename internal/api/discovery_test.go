@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+func newDiscoveryTestRouter(t *testing.T) *mux.Router {
+	cfg := &config.Config{}
+	cfg.Aggregator.RulesPath = t.TempDir()
+	engine, err := rules.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	err = engine.AddRule(models.Rule{
+		ID:          "requests-by-service",
+		Name:        "requests by service",
+		Enabled:     true,
+		Matcher:     models.MetricMatcher{MetricNames: []string{"http_requests_total"}},
+		Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 60, Segmentation: []string{"service"}},
+		Output:      models.OutputConfig{MetricName: "http_requests:sum"},
+	})
+	if err != nil {
+		t.Fatalf("AddRule() error = %v", err)
+	}
+
+	tracker := metrics.NewUsageTracker(time.Hour)
+	tracker.TrackMetric("http_requests_total", map[string]string{"service": "api", "pod": "api-1"}, 1)
+	tracker.TrackMetric("http_requests_total", map[string]string{"service": "web", "pod": "web-1"}, 1)
+	tracker.TrackMetric("up", map[string]string{"job": "node"}, 1)
+
+	h := &Handler{cfg: cfg, ruleEngine: engine, usageTracker: tracker}
+	router := mux.NewRouter()
+	h.SetupDiscoveryRoutes(router)
+	return router
+}
+
+func discoveryRequest(t *testing.T, router *mux.Router, target string, data interface{}) int {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	body := struct {
+		Status string      `json:"status"`
+		Data   interface{} `json:"data"`
+	}{Data: data}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("%s: %v", target, err)
+	}
+	return w.Code
+}
+
+func TestDiscovery_Labels(t *testing.T) {
+	router := newDiscoveryTestRouter(t)
+
+	var names []string
+	discoveryRequest(t, router, "/labels", &names)
+	if want := []string{"__name__", "job", "pod", "service"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("labels = %v, want %v", names, want)
+	}
+
+	// The output of a rule only keeps its grouping labels
+	discoveryRequest(t, router, "/labels?match[]=http_requests:sum", &names)
+	if want := []string{"__name__", "service"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("labels of the output = %v, want %v", names, want)
+	}
+}
+
+func TestDiscovery_LabelValues(t *testing.T) {
+	router := newDiscoveryTestRouter(t)
+
+	var values []string
+	discoveryRequest(t, router, "/label/__name__/values", &values)
+	if want := []string{"http_requests:sum", "http_requests_total", "up"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("metric names = %v, want %v", values, want)
+	}
+
+	discoveryRequest(t, router, `/label/service/values?match[]={__name__="http_requests:sum"}`, &values)
+	if want := []string{"api", "web"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("service values of the output = %v, want %v", values, want)
+	}
+}
+
+func TestDiscovery_Series(t *testing.T) {
+	router := newDiscoveryTestRouter(t)
+
+	var series []map[string]string
+	discoveryRequest(t, router, `/series?match[]=http_requests_total{service="web"}`, &series)
+	if len(series) != 1 || series[0]["service"] != "web" || series[0]["__name__"] != "http_requests_total" {
+		t.Errorf("series = %v, want one http_requests_total series of the web service", series)
+	}
+
+	if code := discoveryRequest(t, router, "/series", nil); code != http.StatusBadRequest {
+		t.Errorf("series without a selector = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := discoveryRequest(t, router, "/series?match[]={", nil); code != http.StatusBadRequest {
+		t.Errorf("series with an invalid selector = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestDiscovery_SeriesCombinations(t *testing.T) {
+	router := newDiscoveryTestRouter(t)
+
+	// Only label combinations that occurred are listed
+	var series []map[string]string
+	discoveryRequest(t, router, `/series?match[]=http_requests_total{service="web",pod="api-1"}`, &series)
+	if len(series) != 0 {
+		t.Errorf("series = %v, want no series combining labels of different series", series)
+	}
+	discoveryRequest(t, router, `/series?match[]=http_requests_total`, &series)
+	want := []map[string]string{
+		{"__name__": "http_requests_total", "service": "api", "pod": "api-1"},
+		{"__name__": "http_requests_total", "service": "web", "pod": "web-1"},
+	}
+	if !reflect.DeepEqual(series, want) {
+		t.Errorf("series = %v, want %v", series, want)
+	}
+}
+
+func TestDiscovery_TimeRange(t *testing.T) {
+	router := newDiscoveryTestRouter(t)
+	now := time.Now()
+	unix := func(t time.Time) string { return fmt.Sprintf("%d", t.Unix()) }
+
+	var values []string
+	discoveryRequest(t, router, "/label/__name__/values?start="+unix(now.Add(-time.Hour))+"&end="+unix(now.Add(time.Hour)), &values)
+	if len(values) != 3 {
+		t.Errorf("metric names seen within the range = %v, want 3", values)
+	}
+	discoveryRequest(t, router, "/label/__name__/values?end="+unix(now.Add(-time.Hour)), &values)
+	if len(values) != 0 {
+		t.Errorf("metric names seen before the series = %v, want none", values)
+	}
+	discoveryRequest(t, router, "/label/__name__/values?start="+now.Add(time.Hour).Format(time.RFC3339), &values)
+	if len(values) != 0 {
+		t.Errorf("metric names seen after the series = %v, want none", values)
+	}
+
+	if code := discoveryRequest(t, router, "/labels?start=yesterday", nil); code != http.StatusBadRequest {
+		t.Errorf("labels with an invalid start = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := discoveryRequest(t, router, "/labels?start=200&end=100", nil); code != http.StatusBadRequest {
+		t.Errorf("labels with end before start = %d, want %d", code, http.StatusBadRequest)
+	}
+}
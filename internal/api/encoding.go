@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/munnerz/goautoneg"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Content types of API responses
+const (
+	contentTypeJSON = "application/json"
+	// contentTypeProtobuf responses hold the JSON document encoded as a
+	// google.protobuf.Value, so they need no schema beyond the well-known types
+	contentTypeProtobuf = "application/x-protobuf"
+	protobufMessageType = "google.protobuf.Value"
+)
+
+// gzipWriters reuses gzip writers across responses
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// CompressionMiddleware gzips responses for clients accepting it. Responses
+// the handler already encoded, such as compressed metric scrapes, are left as
+// they are.
+func CompressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: w}
+		defer writer.close()
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// acceptsGzip reports whether the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response body once the handler decides
+// on the headers
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+// decide starts compressing unless the response is already encoded or has no body
+func (g *gzipResponseWriter) decide(code int) {
+	if g.decided {
+		return
+	}
+	g.decided = true
+
+	header := g.Header()
+	if header.Get("Content-Encoding") != "" || code == http.StatusNoContent || code == http.StatusNotModified {
+		return
+	}
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	g.gz = gzipWriters.Get().(*gzip.Writer)
+	g.gz.Reset(g.ResponseWriter)
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	g.decide(code)
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.decided {
+		if g.Header().Get("Content-Type") == "" {
+			// Detect the type of the uncompressed body, as the server would
+			g.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		g.decide(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(p)
+	}
+	return g.gz.Write(p)
+}
+
+// Flush supports streaming responses through the compressor
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// close finishes the compressed body
+func (g *gzipResponseWriter) close() {
+	if g.gz == nil {
+		return
+	}
+	g.gz.Close()
+	g.gz.Reset(nil)
+	gzipWriters.Put(g.gz)
+	g.gz = nil
+}
+
+// NegotiationMiddleware serves the JSON responses of GET API requests as
+// protobuf when the client prefers application/x-protobuf in its Accept
+// header. Such responses carry the same document as a google.protobuf.Value.
+func NegotiationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, "/api/") ||
+			goautoneg.Negotiate(r.Header.Get("Accept"), []string{contentTypeJSON, contentTypeProtobuf}) != contentTypeProtobuf {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buffer := &bufferedResponseWriter{header: make(http.Header), code: http.StatusOK}
+		next.ServeHTTP(buffer, r)
+
+		body := buffer.body.Bytes()
+		mediaType, _, _ := strings.Cut(buffer.header.Get("Content-Type"), ";")
+		if buffer.code == http.StatusOK && strings.TrimSpace(mediaType) == contentTypeJSON {
+			if encoded, err := jsonToProtobuf(body); err == nil {
+				buffer.header.Set("Content-Type", contentTypeProtobuf+"; proto="+protobufMessageType)
+				buffer.header.Del("Content-Length")
+				body = encoded
+			}
+		}
+
+		for key, values := range buffer.header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(buffer.code)
+		w.Write(body)
+	})
+}
+
+// jsonToProtobuf encodes a JSON document as a google.protobuf.Value
+func jsonToProtobuf(data []byte) ([]byte, error) {
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	value, err := structpb.NewValue(document)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(value)
+}
+
+// bufferedResponseWriter holds a response so it can be re-encoded
+type bufferedResponseWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) WriteHeader(code int) {
+	if !b.wrote {
+		b.code = code
+		b.wrote = true
+	}
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	b.wrote = true
+	return b.body.Write(p)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func jsonHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"rules":[{"id":"a","enabled":true}],"total":1}`))
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	handler := CompressionMiddleware(http.HandlerFunc(jsonHandler))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/rules", nil)
+	req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(reader)
+	if string(body) != `{"rules":[{"id":"a","enabled":true}],"total":1}` {
+		t.Errorf("decompressed body = %s", body)
+	}
+
+	// Clients not accepting gzip get the plain body
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/rules", nil))
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() == 0 {
+		t.Errorf("expected an uncompressed response, got %q", rec.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestNegotiationMiddleware(t *testing.T) {
+	handler := NegotiationMiddleware(http.HandlerFunc(jsonHandler))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/rules", nil)
+	req.Header.Set("Accept", "application/x-protobuf, application/json;q=0.5")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Type"); got != "application/x-protobuf; proto=google.protobuf.Value" {
+		t.Fatalf("Content-Type = %q, want protobuf", got)
+	}
+	var value structpb.Value
+	if err := proto.Unmarshal(rec.Body.Bytes(), &value); err != nil {
+		t.Fatal(err)
+	}
+	if total := value.GetStructValue().Fields["total"].GetNumberValue(); total != 1 {
+		t.Errorf("decoded total = %v, want 1", total)
+	}
+
+	// JSON stays the default
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/rules", nil)
+	req.Header.Set("Accept", "*/*")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/gitops"
+)
+
+// GitOpsHandler serves the status of the rule sync with a Git repository
+type GitOpsHandler struct {
+	syncer *gitops.Syncer
+}
+
+// NewGitOpsHandler creates a handler for a Git sync
+func NewGitOpsHandler(syncer *gitops.Syncer) *GitOpsHandler {
+	return &GitOpsHandler{syncer: syncer}
+}
+
+// SetupRoutes sets up the routes for the GitOps API
+func (h *GitOpsHandler) SetupRoutes(router *mux.Router) {
+	router.HandleFunc("/gitops/status", h.GetStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/gitops/sync", h.Sync).Methods("POST", "OPTIONS")
+}
+
+// GetStatus returns the state of the Git sync
+func (h *GitOpsHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.syncer.Status())
+}
+
+// Sync syncs the rules with the repository right away
+func (h *GitOpsHandler) Sync(w http.ResponseWriter, r *http.Request) {
+	if err := h.syncer.Sync(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.syncer.Status())
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// SetupRuleGroupRoutes sets up the routes for the rule group API
+func (h *Handler) SetupRuleGroupRoutes(router *mux.Router) {
+	router.HandleFunc("/rule-groups", h.ListRuleGroups).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rule-groups", h.CreateRuleGroup).Methods("POST", "OPTIONS")
+	router.HandleFunc("/rule-groups/{name}", h.GetRuleGroup).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rule-groups/{name}", h.UpdateRuleGroup).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/rule-groups/{name}", h.DeleteRuleGroup).Methods("DELETE", "OPTIONS")
+}
+
+// ListRuleGroups returns all rule groups ordered by priority
+func (h *Handler) ListRuleGroups(w http.ResponseWriter, r *http.Request) {
+	groups := h.ruleEngine.GetGroups()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"groups": groups,
+		"total":  len(groups),
+	})
+}
+
+// GetRuleGroup returns a specific rule group by name
+func (h *Handler) GetRuleGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	group, err := h.ruleEngine.GetGroup(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// CreateRuleGroup creates a new rule group
+func (h *Handler) CreateRuleGroup(w http.ResponseWriter, r *http.Request) {
+	var group models.RuleGroup
+
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := group.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.ruleEngine.GetGroup(group.Name); err == nil {
+		http.Error(w, "Rule group already exists", http.StatusConflict)
+		return
+	}
+
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = time.Now()
+
+	if err := h.ruleEngine.SaveGroup(&group); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+// UpdateRuleGroup updates an existing rule group
+func (h *Handler) UpdateRuleGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	existing, err := h.ruleEngine.GetGroup(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var group models.RuleGroup
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Ensure name matches and keep the creation time
+	group.Name = name
+	group.CreatedAt = existing.CreatedAt
+	group.UpdatedAt = time.Now()
+
+	if err := h.ruleEngine.SaveGroup(&group); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// DeleteRuleGroup deletes a rule group that no longer has any rules
+func (h *Handler) DeleteRuleGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if _, err := h.ruleEngine.GetGroup(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := h.ruleEngine.DeleteGroup(name); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
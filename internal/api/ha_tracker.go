@@ -0,0 +1,101 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// electedReplica is the replica whose samples are accepted for a cluster
+type electedReplica struct {
+	replica  string
+	lastSeen time.Time
+}
+
+// HATracker deduplicates the samples of HA Prometheus pairs. Each cluster
+// elects the first replica it hears from and only accepts its samples. When
+// the elected replica sends nothing for the failover timeout, the next
+// replica to send is elected instead.
+type HATracker struct {
+	cfg     config.HATrackerConfig
+	elected map[string]*electedReplica // Keyed by tenant and cluster
+	mu      sync.Mutex
+}
+
+// NewHATracker creates a new HA tracker. It returns nil, which accepts
+// every request, if the tracker is disabled.
+func NewHATracker(cfg config.HATrackerConfig) *HATracker {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &HATracker{
+		cfg:     cfg,
+		elected: make(map[string]*electedReplica),
+	}
+}
+
+// replicaLabels returns the cluster and replica of a remote write request,
+// taken from its first series; Prometheus adds the same external labels to
+// every series it sends. Both are empty if the request has no replica label.
+func (t *HATracker) replicaLabels(timeseries []prompb.TimeSeries) (cluster, replica string) {
+	if t == nil || len(timeseries) == 0 {
+		return "", ""
+	}
+	for _, l := range timeseries[0].Labels {
+		switch l.Name {
+		case t.cfg.ClusterLabel:
+			cluster = l.Value
+		case t.cfg.ReplicaLabel:
+			replica = l.Value
+		}
+	}
+	if cluster == "" || replica == "" {
+		return "", ""
+	}
+	return cluster, replica
+}
+
+// Accept reports whether the samples of a replica should be accepted, electing
+// it if its cluster has no elected replica or the elected one timed out.
+// changed is set when the replica was newly elected over another one.
+func (t *HATracker) Accept(tenant, cluster, replica string, now time.Time) (accepted, changed bool) {
+	if t == nil {
+		return true, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := tenant + "/" + cluster
+	elected, exists := t.elected[key]
+	switch {
+	case !exists:
+		t.elected[key] = &electedReplica{replica: replica, lastSeen: now}
+		return true, false
+	case elected.replica == replica:
+		elected.lastSeen = now
+		return true, false
+	case now.Sub(elected.lastSeen) > time.Duration(t.cfg.FailoverTimeoutSeconds)*time.Second:
+		elected.replica = replica
+		elected.lastSeen = now
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// stripReplicaLabel removes the replica label from all series, so the series
+// of the elected replicas of a cluster are the same across failovers
+func (t *HATracker) stripReplicaLabel(timeseries []prompb.TimeSeries) {
+	for i := range timeseries {
+		labels := timeseries[i].Labels[:0]
+		for _, l := range timeseries[i].Labels {
+			if l.Name != t.cfg.ReplicaLabel {
+				labels = append(labels, l)
+			}
+		}
+		timeseries[i].Labels = labels
+	}
+}
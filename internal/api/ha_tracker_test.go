@@ -0,0 +1,70 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestHATracker_Accept(t *testing.T) {
+	tracker := NewHATracker(config.HATrackerConfig{
+		Enabled:                true,
+		ClusterLabel:           "cluster",
+		ReplicaLabel:           "__replica__",
+		FailoverTimeoutSeconds: 30,
+	})
+	now := time.Now()
+
+	if accepted, _ := tracker.Accept("t1", "prod", "a", now); !accepted {
+		t.Fatal("first replica of a cluster was not elected")
+	}
+	if accepted, _ := tracker.Accept("t1", "prod", "b", now.Add(10*time.Second)); accepted {
+		t.Error("samples of the non-elected replica were accepted")
+	}
+	if accepted, _ := tracker.Accept("t2", "prod", "b", now); !accepted {
+		t.Error("clusters of different tenants share an elected replica")
+	}
+	if accepted, _ := tracker.Accept("t1", "prod", "a", now.Add(20*time.Second)); !accepted {
+		t.Error("samples of the elected replica were rejected")
+	}
+
+	// The elected replica stops sending
+	accepted, changed := tracker.Accept("t1", "prod", "b", now.Add(51*time.Second))
+	if !accepted || !changed {
+		t.Errorf("Accept() after the failover timeout = %v, %v, want the replica elected", accepted, changed)
+	}
+	if accepted, _ := tracker.Accept("t1", "prod", "a", now.Add(52*time.Second)); accepted {
+		t.Error("samples of the replaced replica were accepted")
+	}
+}
+
+func TestHATracker_ReplicaLabels(t *testing.T) {
+	tracker := NewHATracker(config.HATrackerConfig{Enabled: true, ClusterLabel: "cluster", ReplicaLabel: "__replica__"})
+	timeseries := []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "__replica__", Value: "a"}, {Name: "cluster", Value: "prod"}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "__replica__", Value: "a"}}},
+	}
+
+	cluster, replica := tracker.replicaLabels(timeseries)
+	if cluster != "prod" || replica != "a" {
+		t.Errorf("replicaLabels() = %q, %q, want prod, a", cluster, replica)
+	}
+
+	tracker.stripReplicaLabel(timeseries)
+	for _, ts := range timeseries {
+		for _, l := range ts.Labels {
+			if l.Name == "__replica__" {
+				t.Errorf("replica label left on %v", ts.Labels)
+			}
+		}
+	}
+
+	if _, replica := tracker.replicaLabels(timeseries[1:]); replica != "" {
+		t.Error("series without a cluster label were assigned a replica")
+	}
+	if _, replica := (*HATracker)(nil).replicaLabels(timeseries); replica != "" {
+		t.Error("disabled tracker returned a replica")
+	}
+}
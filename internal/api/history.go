@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// SetupRuleHistoryRoutes sets up the routes for the rule history API
+func (h *Handler) SetupRuleHistoryRoutes(router *mux.Router) {
+	router.HandleFunc("/rules/{id}/history", h.GetRuleHistory).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rules/{id}/history/{rev}", h.GetRuleRevision).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rules/{id}/history/{rev}/diff", h.DiffRuleRevision).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rules/{id}/history/{rev}/restore", h.RestoreRuleRevision).Methods("POST", "OPTIONS")
+}
+
+// author returns the user making a request, as set by Grafana or a proxy
+func (h *Handler) author(r *http.Request) string {
+	if h.cfg.Server.UserHeader == "" {
+		return ""
+	}
+	return r.Header.Get(h.cfg.Server.UserHeader)
+}
+
+// GetRuleHistory returns all revisions of a rule, newest first
+func (h *Handler) GetRuleHistory(w http.ResponseWriter, r *http.Request) {
+	revisions, err := h.ruleEngine.RuleHistory(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revisions": revisions,
+		"total":     len(revisions),
+	})
+}
+
+// GetRuleRevision returns one revision of a rule
+func (h *Handler) GetRuleRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	rev, err := strconv.Atoi(vars["rev"])
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := h.ruleEngine.RuleRevision(vars["id"], rev)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revision)
+}
+
+// DiffRuleRevision returns the fields a revision changed compared to the
+// previous revision, or to the revision given by the against parameter
+func (h *Handler) DiffRuleRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	rev, err := strconv.Atoi(vars["rev"])
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+	against := rev - 1
+	if value := r.URL.Query().Get("against"); value != "" {
+		if against, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid against revision", http.StatusBadRequest)
+			return
+		}
+	}
+
+	revision, err := h.ruleEngine.RuleRevision(id, rev)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// Revision 0 is the rule before it was created
+	var base *models.RuleRevision
+	if against > 0 {
+		if base, err = h.ruleEngine.RuleRevision(id, against); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rule_id":  id,
+		"revision": rev,
+		"against":  against,
+		"changes":  models.DiffRules(revisionRule(base), revisionRule(revision)),
+	})
+}
+
+// revisionRule returns the rule as it existed after a revision, which is no
+// rule for a deletion
+func revisionRule(revision *models.RuleRevision) *models.Rule {
+	if revision == nil || revision.Action == models.RevisionDeleted {
+		return nil
+	}
+	return &revision.Rule
+}
+
+// RestoreRuleRevision makes a revision the current version of a rule
+func (h *Handler) RestoreRuleRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	rev, err := strconv.Atoi(vars["rev"])
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := h.ruleEngine.RuleRevision(vars["id"], rev)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if revision.Action == models.RevisionDeleted {
+		http.Error(w, "cannot restore a deletion: restore the revision before it", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.ruleEngine.RestoreRevision(vars["id"], rev, h.author(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.LogInfoWithFields("Rule revision restored", logger.Fields{
+		"rule_id":  rule.ID,
+		"revision": rev,
+		"author":   rule.UpdatedBy,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// SetupIngestFilterRoutes sets up the routes for the ingest filters API
+func (h *Handler) SetupIngestFilterRoutes(router *mux.Router) {
+	router.HandleFunc("/ingest/filters", h.GetIngestFilters).Methods("GET", "OPTIONS")
+	router.HandleFunc("/ingest/filters", h.UpdateIngestFilters).Methods("PUT", "OPTIONS")
+}
+
+// GetIngestFilters returns the series dropped or passed through on ingest
+func (h *Handler) GetIngestFilters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.ruleEngine.IngestFilters())
+}
+
+// UpdateIngestFilters replaces the ingest filters. They apply to samples
+// received after the update.
+func (h *Handler) UpdateIngestFilters(w http.ResponseWriter, r *http.Request) {
+	var filters models.IngestFilters
+	if err := json.NewDecoder(r.Body).Decode(&filters); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.ruleEngine.SetIngestFilters(filters); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.LogInfoWithFields("Updated ingest filters", logger.Fields{
+		"drop": filters.Drop,
+		"pass": filters.Pass,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.ruleEngine.IngestFilters())
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+)
+
+// tokenBucket is a token bucket refilled at rate tokens per second up to burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// allow takes n tokens from the bucket if available
+func (b *tokenBucket) allow(n int, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if float64(n) > b.tokens {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// IngestLimiter enforces per-tenant sample rate limits on ingestion
+type IngestLimiter struct {
+	cfg       config.IngestConfig
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewIngestLimiter creates a new ingestion limiter
+func NewIngestLimiter(cfg config.IngestConfig) *IngestLimiter {
+	return &IngestLimiter{
+		cfg:       cfg,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow reports whether the tenant may ingest n more samples now. Requests
+// larger than the burst are accepted only when the bucket is full, so a
+// single large batch is never rejected forever.
+func (l *IngestLimiter) Allow(tenant string, n int) bool {
+	return l.allow(tenant, n, time.Now())
+}
+
+// allow is Allow at a given time
+func (l *IngestLimiter) allow(tenant string, n int, now time.Time) bool {
+	rate, burst := l.cfg.RateLimit, l.cfg.Burst
+	// Configuration keys are case-insensitive
+	if limits, exists := l.cfg.TenantLimits[strings.ToLower(tenant)]; exists {
+		rate, burst = limits.RateLimit, limits.Burst
+	}
+	if rate <= 0 {
+		return true
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, exists := l.buckets[tenant]
+	if !exists {
+		bucket = &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+		l.buckets[tenant] = bucket
+	}
+
+	if n > burst {
+		n = burst
+	}
+	return bucket.allow(n, now)
+}
+
+// sweep removes full buckets, whose tenants have not sent for long enough
+// that recreating the bucket makes no difference
+func (l *IngestLimiter) sweep(now time.Time) {
+	for tenant, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate >= bucket.burst {
+			delete(l.buckets, tenant)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/pkg/kubernetes"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// SetupKubernetesRoutes sets up the routes for reconciling generated
+// Kubernetes monitors with the rules
+func (h *Handler) SetupKubernetesRoutes(router *mux.Router) {
+	router.HandleFunc("/kubernetes/reconcile", h.GetKubernetesReconcile).Methods("GET", "OPTIONS")
+	router.HandleFunc("/kubernetes/reconcile", h.FixKubernetesReconcile).Methods("POST", "OPTIONS")
+}
+
+// GetKubernetesReconcile reports rules whose monitors are missing and monitor
+// files whose rules no longer exist
+func (h *Handler) GetKubernetesReconcile(w http.ResponseWriter, r *http.Request) {
+	h.writeKubernetesReconcile(w, false)
+}
+
+// FixKubernetesReconcile generates missing monitor files, deletes orphaned
+// ones and reports what was changed
+func (h *Handler) FixKubernetesReconcile(w http.ResponseWriter, r *http.Request) {
+	h.writeKubernetesReconcile(w, true)
+}
+
+func (h *Handler) writeKubernetesReconcile(w http.ResponseWriter, fix bool) {
+	report, err := h.reconcileKubernetes(fix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// reconcileKubernetes compares the rules with their monitors
+func (h *Handler) reconcileKubernetes(fix bool) (*kubernetes.ReconcileReport, error) {
+	rules, err := h.ruleEngine.GetRules()
+	if err != nil {
+		return nil, err
+	}
+
+	var reader kubernetes.MonitorReader
+	if h.cfg.Kubernetes.CheckCluster {
+		client, err := kubernetes.NewInClusterClient()
+		if err != nil {
+			return nil, err
+		}
+		reader = client
+	}
+
+	return kubernetes.Reconcile(rules, h.cfg.Kubernetes.MonitorsDir, h.cfg.Kubernetes.ExistingMonitorsDir, reader, fix)
+}
+
+// reconcileKubernetesOnStartup logs differences between the rules and their
+// monitors, which drift when rules change while monitors are applied by hand
+func (h *Handler) reconcileKubernetesOnStartup() {
+	report, err := h.reconcileKubernetes(h.cfg.Kubernetes.AutoFix)
+	if err != nil {
+		logger.LogWarnWithFields("Failed to reconcile Kubernetes monitors", logger.Fields{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	for _, missing := range report.Missing {
+		logger.LogWarnWithFields("Rule has no Kubernetes monitor file", logger.Fields{
+			"rule_id": missing.RuleID,
+			"file":    missing.File,
+		})
+	}
+	for _, file := range report.Orphaned {
+		logger.LogWarnWithFields("Kubernetes monitor file has no rule", logger.Fields{
+			"file": file,
+		})
+	}
+	for _, missing := range report.MissingInCluster {
+		logger.LogWarnWithFields("Kubernetes monitor of rule does not exist in the cluster", logger.Fields{
+			"rule_id":   missing.RuleID,
+			"kind":      missing.Kind,
+			"namespace": missing.Namespace,
+			"name":      missing.Name,
+		})
+	}
+	if len(report.Fixed) > 0 {
+		logger.LogInfoWithFields("Fixed Kubernetes monitor files", logger.Fields{
+			"files": strings.Join(report.Fixed, ", "),
+		})
+	}
+	if len(report.Errors) > 0 {
+		logger.LogWarnWithFields("Errors while reconciling Kubernetes monitors", logger.Fields{
+			"errors": strings.Join(report.Errors, "; "),
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+// SetupLintRoutes sets up the routes for checking rules against best
+// practices. They must be registered before the routes of single rules.
+func (h *Handler) SetupLintRoutes(router *mux.Router) {
+	router.HandleFunc("/rules/lint", h.LintRules).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rules/lint", h.LintSubmittedRules).Methods("POST", "OPTIONS")
+}
+
+// lintOptions returns the lint options of the configuration, with the scrape
+// intervals inferred by the usage tracker
+func (h *Handler) lintOptions() rules.LintOptions {
+	return rules.LintOptionsFromConfig(h.cfg, func(metric string) time.Duration {
+		if h.usageTracker == nil {
+			return 0
+		}
+		if info := h.usageTracker.GetMetricInfo(metric); info != nil {
+			return info.ScrapeInterval
+		}
+		return 0
+	})
+}
+
+// LintRules returns the best-practice warnings of all rules, or with rule_id
+// of a single rule
+func (h *Handler) LintRules(w http.ResponseWriter, r *http.Request) {
+	opts := h.lintOptions()
+
+	var warnings []rules.LintWarning
+	if id := r.URL.Query().Get("rule_id"); id != "" {
+		rule, err := h.ruleEngine.GetRule(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		warnings = append([]rules.LintWarning{}, rules.Lint(rule, opts)...)
+	} else {
+		var err error
+		if warnings, err = h.ruleEngine.LintRules(opts); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": warnings,
+		"total": len(warnings),
+	})
+}
+
+// LintSubmittedRules returns the best-practice warnings of the rules in the
+// body, in any format accepted by the rule import, without saving them
+func (h *Handler) LintSubmittedRules(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	submitted, err := rules.DecodeRules(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := h.lintOptions()
+	warnings := []rules.LintWarning{}
+	for _, rule := range submitted {
+		if rule != nil {
+			warnings = append(warnings, rules.Lint(rule, opts)...)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": warnings,
+		"total": len(warnings),
+	})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+func TestLintSubmittedRules(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Aggregator.DeadMansSwitchIntervals = 3
+	h := &Handler{cfg: cfg}
+
+	body := `
+id: by-pod
+name: Requests by pod
+matcher:
+  metric_names: [http_requests_total]
+aggregation:
+  type: sum
+  interval_seconds: 60
+  segmentation: [pod]
+output:
+  metric_name: http_requests:sum
+`
+	w := httptest.NewRecorder()
+	h.LintSubmittedRules(w, httptest.NewRequest(http.MethodPost, "/rules/lint", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Items []rules.LintWarning `json:"items"`
+		Total int                 `json:"total"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 1 || response.Items[0].Check != rules.LintHighCardinalitySegmentation || response.Items[0].RuleID != "by-pod" {
+		t.Errorf("warnings = %+v, want high cardinality segmentation of by-pod", response.Items)
+	}
+}
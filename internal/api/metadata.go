@@ -0,0 +1,63 @@
+package api
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// MetadataStore keeps the metric family metadata sent with remote write requests
+type MetadataStore struct {
+	mu       sync.RWMutex
+	families map[string]prompb.MetricMetadata
+}
+
+// NewMetadataStore creates an empty metadata store
+func NewMetadataStore() *MetadataStore {
+	return &MetadataStore{
+		families: make(map[string]prompb.MetricMetadata),
+	}
+}
+
+// Update records the metadata of a remote write request
+func (s *MetadataStore) Update(metadata []prompb.MetricMetadata) {
+	if len(metadata) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range metadata {
+		s.families[m.MetricFamilyName] = m
+	}
+}
+
+// IsCounter reports whether a series with the given metric name is a
+// monotonic counter: a counter family, or the _count, _sum and _bucket
+// series of a histogram or summary. Without metadata, names ending in
+// _total are treated as counters, following the OpenMetrics convention.
+func (s *MetadataStore) IsCounter(metricName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if m, exists := s.families[metricName]; exists {
+		return m.Type == prompb.MetricMetadata_COUNTER
+	}
+
+	if family, exists := s.families[strings.TrimSuffix(metricName, "_total")]; exists {
+		return family.Type == prompb.MetricMetadata_COUNTER
+	}
+
+	for _, suffix := range []string{"_count", "_sum", "_bucket"} {
+		if !strings.HasSuffix(metricName, suffix) {
+			continue
+		}
+		if family, exists := s.families[strings.TrimSuffix(metricName, suffix)]; exists {
+			return family.Type == prompb.MetricMetadata_HISTOGRAM || family.Type == prompb.MetricMetadata_SUMMARY
+		}
+	}
+
+	return strings.HasSuffix(metricName, "_total")
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// quietRoutes are polled by probes and scrapers, so their access logs are
+// only written at debug level
+var quietRoutes = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/metrics": true,
+}
+
+// RequestMiddleware records the latency, status code and request size of
+// every request per route, and writes an access log if accessLog is set
+func RequestMiddleware(accessLog bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = body
+			}
+			recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+
+			next.ServeHTTP(recorder, r)
+
+			duration := time.Since(start)
+			route := routeTemplate(r)
+			metrics.RecordHTTPRequest(route, r.Method, recorder.code, duration, body.n)
+			if !accessLog {
+				return
+			}
+
+			fields := logger.Fields{
+				"method":         r.Method,
+				"route":          route,
+				"path":           r.URL.Path,
+				"status":         recorder.code,
+				"duration_ms":    float64(duration.Microseconds()) / 1000,
+				"request_bytes":  body.n,
+				"response_bytes": recorder.written,
+				"remote_addr":    r.RemoteAddr,
+				"user_agent":     r.UserAgent(),
+			}
+			if quietRoutes[route] {
+				logger.LogDebugWithFields("HTTP request", fields)
+				return
+			}
+			logger.LogInfoWithFields("HTTP request", fields)
+		})
+	}
+}
+
+// routeTemplate returns the path template of the matched route, which unlike
+// the path does not grow the cardinality of the metrics with every rule ID
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "unmatched"
+	}
+	if template, err := route.GetPathTemplate(); err == nil {
+		return template
+	}
+	return "unmatched"
+}
+
+// statusRecorder captures the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	written     int64
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.code = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+// Flush supports streaming responses through the recorder
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
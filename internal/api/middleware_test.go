@@ -0,0 +1,35 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequestMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(RequestMiddleware(false))
+	router.HandleFunc("/api/v1/rules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "not found", http.StatusNotFound)
+	}).Methods(http.MethodPut)
+
+	for _, id := range []string{"a", "b"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/rules/"+id, strings.NewReader("{}")))
+	}
+
+	// Requests are counted per route template, not per path
+	if got := testutil.ToFloat64(metrics.HTTPRequestsCounter.WithLabelValues("/api/v1/rules/{id}", http.MethodPut, "404")); got != 2 {
+		t.Errorf("requests counted = %v, want 2", got)
+	}
+	if got := testutil.CollectAndCount(metrics.HTTPRequestSizeHistogram); got != 1 {
+		t.Errorf("request size series = %d, want 1", got)
+	}
+}
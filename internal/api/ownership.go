@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// Groupings supported by the savings report
+const (
+	SavingsByTeam      = "team"
+	SavingsByNamespace = "namespace"
+	SavingsByOwner     = "owner"
+)
+
+// unassigned is the savings report key of rules without an owner, team or namespace
+const unassigned = "unassigned"
+
+// ownershipAssigner infers the team and namespace of rules from the series they match
+type ownershipAssigner struct {
+	cfg          config.OwnershipConfig
+	usageTracker *metrics.UsageTracker
+}
+
+// OwnershipSavings is the series reduction of the rules of one team, namespace or owner
+type OwnershipSavings struct {
+	Key          string `json:"key"`
+	Rules        int    `json:"rules"`
+	InputSeries  int    `json:"input_series"`  // Series of the metrics matched by the rules
+	OutputSeries int    `json:"output_series"` // Series of the aggregated metrics
+	// SeriesSaved only counts rules that drop their original metrics, in
+	// proportion to their rollout percentage
+	SeriesSaved       int     `json:"series_saved"`
+	SavingsPercentage float64 `json:"savings_percentage"`
+}
+
+// assign fills in the team and namespace of a rule that leaves them empty.
+// A value is only inferred when all series the rule matches agree on it.
+func (a *ownershipAssigner) assign(rule *models.Rule) {
+	if a == nil {
+		return
+	}
+
+	if rule.Namespace == "" {
+		rule.Namespace = a.inferLabel(rule, a.cfg.NamespaceLabel)
+	}
+	if rule.Team == "" {
+		rule.Team = a.inferLabel(rule, a.cfg.TeamLabel)
+		if rule.Team == "" && rule.Namespace != "" {
+			rule.Team = a.cfg.NamespaceTeams[rule.Namespace]
+		}
+	}
+}
+
+// inferLabel returns the single value a label has on the series a rule matches,
+// taken from the rule's exact label matcher or else from observed usage
+func (a *ownershipAssigner) inferLabel(rule *models.Rule, label string) string {
+	if label == "" {
+		return ""
+	}
+	return ruleLabelValue(a.usageTracker, rule, label)
+}
+
+// ruleLabelValue returns the single value a label has on the series a rule
+// matches, or an empty string when they disagree or lack the label
+func ruleLabelValue(tracker *metrics.UsageTracker, rule *models.Rule, label string) string {
+	if value := rule.Matcher.Labels[label]; value != "" {
+		return value
+	}
+
+	value := ""
+	for _, name := range matchedMetrics(tracker, rule) {
+		observed, ok := singleLabelValue(tracker, name, label)
+		if !ok || (value != "" && observed != value) {
+			return ""
+		}
+		value = observed
+	}
+
+	return value
+}
+
+// singleLabelValue returns the value of a label if all series of a metric share it
+func singleLabelValue(tracker *metrics.UsageTracker, name, label string) (string, bool) {
+	for _, distribution := range tracker.GetLabelDistribution(name) {
+		if distribution.Label == label && distribution.Cardinality == 1 && len(distribution.TopValues) > 0 {
+			return distribution.TopValues[0].Value, true
+		}
+	}
+	return "", false
+}
+
+// matchedMetrics returns the tracked metrics whose name a rule matches
+func matchedMetrics(tracker *metrics.UsageTracker, rule *models.Rule) []string {
+	var names []string
+	var all map[string]*metrics.MetricUsageInfo
+	seen := make(map[string]bool)
+
+	for _, pattern := range rule.Matcher.Names() {
+		if !strings.Contains(pattern, "*") {
+			if !seen[pattern] && tracker.GetMetricInfo(pattern) != nil {
+				seen[pattern] = true
+				names = append(names, pattern)
+			}
+			continue
+		}
+
+		if all == nil {
+			all = tracker.GetAllMetricsInfo()
+		}
+		for name := range all {
+			if matched, _ := path.Match(pattern, name); matched && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// SetupOwnershipRoutes sets up the routes for the ownership API
+func (h *Handler) SetupOwnershipRoutes(router *mux.Router) {
+	router.HandleFunc("/ownership/savings", h.OwnershipSavings).Methods("GET", "OPTIONS")
+}
+
+// OwnershipSavings reports the series reduction of rules grouped by team,
+// namespace or owner. Query parameters: by (team, namespace, owner; default team).
+func (h *Handler) OwnershipSavings(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = SavingsByTeam
+	}
+	if by != SavingsByTeam && by != SavingsByNamespace && by != SavingsByOwner {
+		http.Error(w, "Invalid 'by' parameter: must be one of team, namespace, owner", http.StatusBadRequest)
+		return
+	}
+
+	rules, err := h.ruleEngine.GetRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	groups := make(map[string]*OwnershipSavings)
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		key := ownershipKey(rule, by)
+		group, exists := groups[key]
+		if !exists {
+			group = &OwnershipSavings{Key: key}
+			groups[key] = group
+		}
+
+		input := 0
+		for _, name := range matchedMetrics(h.usageTracker, rule) {
+			if info := h.usageTracker.GetMetricInfo(name); info != nil {
+				input += info.Cardinality
+			}
+		}
+		output := 0
+		if info := h.usageTracker.GetMetricInfo(rule.Output.MetricName); info != nil {
+			output = info.Cardinality
+		}
+
+		group.Rules++
+		group.InputSeries += input
+		group.OutputSeries += output
+		if input > output {
+			group.SeriesSaved += (input - output) * rule.DropPercentage() / 100
+		}
+	}
+
+	result := make([]OwnershipSavings, 0, len(groups))
+	for _, group := range groups {
+		if group.InputSeries > 0 {
+			group.SavingsPercentage = float64(group.SeriesSaved) / float64(group.InputSeries) * 100.0
+		}
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].SeriesSaved != result[j].SeriesSaved {
+			return result[i].SeriesSaved > result[j].SeriesSaved
+		}
+		return result[i].Key < result[j].Key
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"by":      by,
+		"savings": result,
+		"total":   len(result),
+	})
+}
+
+// ownershipKey returns the team, namespace or owner of a rule
+func ownershipKey(rule *models.Rule, by string) string {
+	key := rule.Team
+	switch by {
+	case SavingsByNamespace:
+		key = rule.Namespace
+	case SavingsByOwner:
+		key = rule.Owner
+	}
+
+	if key == "" {
+		return unassigned
+	}
+	return key
+}
+
+// matchesOwnership reports whether a rule matches the owner, team and namespace
+// filters of a request; empty filters match every rule
+func matchesOwnership(rule *models.Rule, owner, team, namespace string) bool {
+	return (owner == "" || rule.Owner == owner) &&
+		(team == "" || rule.Team == team) &&
+		(namespace == "" || rule.Namespace == namespace)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestOwnershipAssigner_Assign(t *testing.T) {
+	tracker := metrics.NewUsageTracker(time.Hour)
+	for i := 0; i < 10; i++ {
+		tracker.TrackMetric("payments_requests_total", map[string]string{"namespace": "payments", "id": fmt.Sprintf("%d", i)}, 1)
+		tracker.TrackMetric("payments_errors_total", map[string]string{"namespace": "payments", "id": fmt.Sprintf("%d", i)}, 1)
+		tracker.TrackMetric("shared_requests_total", map[string]string{"namespace": fmt.Sprintf("ns%d", i%2)}, 1)
+	}
+
+	assigner := &ownershipAssigner{
+		cfg: config.OwnershipConfig{
+			NamespaceLabel: "namespace",
+			TeamLabel:      "team",
+			NamespaceTeams: map[string]string{"payments": "payments-team", "search": "search-team"},
+		},
+		usageTracker: tracker,
+	}
+
+	tests := []struct {
+		name          string
+		rule          models.Rule
+		wantNamespace string
+		wantTeam      string
+	}{
+		{
+			name:          "inferred from observed series",
+			rule:          models.Rule{Matcher: models.MetricMatcher{MetricNames: []string{"payments_*"}}},
+			wantNamespace: "payments",
+			wantTeam:      "payments-team",
+		},
+		{
+			name:          "taken from the label matcher",
+			rule:          models.Rule{Matcher: models.MetricMatcher{MetricNames: []string{"shared_requests_total"}, Labels: map[string]string{"namespace": "search"}}},
+			wantNamespace: "search",
+			wantTeam:      "search-team",
+		},
+		{
+			name: "series disagree",
+			rule: models.Rule{Matcher: models.MetricMatcher{MetricNames: []string{"shared_requests_total"}}},
+		},
+		{
+			name:          "explicit values are kept",
+			rule:          models.Rule{Team: "platform", Namespace: "infra", Matcher: models.MetricMatcher{MetricNames: []string{"payments_requests_total"}}},
+			wantNamespace: "infra",
+			wantTeam:      "platform",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			assigner.assign(&rule)
+			if rule.Namespace != tt.wantNamespace || rule.Team != tt.wantTeam {
+				t.Errorf("assign() = namespace %q, team %q, want %q, %q", rule.Namespace, rule.Team, tt.wantNamespace, tt.wantTeam)
+			}
+		})
+	}
+}
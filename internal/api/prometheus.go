@@ -2,16 +2,28 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
+	"github.com/marcotuna/adaptive-metrics/internal/aggregator"
 	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/tracing"
 	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	pkgmetrics "github.com/marcotuna/adaptive-metrics/pkg/metrics"
 	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // generateRequestID creates a unique identifier for tracking requests in logs
@@ -19,16 +31,96 @@ func generateRequestID() string {
 	return time.Now().Format("20060102-150405") + "-" + fmt.Sprintf("%06d", time.Now().Nanosecond()/1000)
 }
 
+// sourceTemporality returns the counter temporality of a source
+func (h *Handler) sourceTemporality(source string) string {
+	if temporality, exists := h.cfg.Temporality.Sources[strings.ToLower(source)]; exists {
+		return temporality
+	}
+	if h.cfg.Temporality.Default != "" {
+		return h.cfg.Temporality.Default
+	}
+	return models.TemporalityCumulative
+}
+
+// Remote write protocol headers
+const (
+	remoteWriteVersionHeader        = "X-Prometheus-Remote-Write-Version"
+	remoteWriteSamplesWrittenHeader = "X-Prometheus-Remote-Write-Samples-Written"
+)
+
+// remoteWriteV1Proto is the protobuf message of remote write 1.0 requests
+const remoteWriteV1Proto = "prometheus.WriteRequest"
+
+// checkRemoteWriteProtocol returns an error if the request uses a remote write
+// protocol version, message or encoding this receiver does not support.
+// Senders negotiating remote write 2.0 fall back to 1.0 when rejected with
+// 415 Unsupported Media Type.
+func checkRemoteWriteProtocol(r *http.Request) error {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return fmt.Errorf("invalid content type %q", contentType)
+		}
+		if mediaType != "application/x-protobuf" {
+			return fmt.Errorf("unsupported content type %q", mediaType)
+		}
+		if proto, exists := params["proto"]; exists && proto != remoteWriteV1Proto {
+			return fmt.Errorf("unsupported protobuf message %q, only %s is supported", proto, remoteWriteV1Proto)
+		}
+	}
+
+	if encoding := r.Header.Get("Content-Encoding"); encoding != "" && encoding != "snappy" {
+		return fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	if version := r.Header.Get(remoteWriteVersionHeader); version != "" && !strings.HasPrefix(version, "0.") && !strings.HasPrefix(version, "1.") {
+		return fmt.Errorf("unsupported remote write version %q", version)
+	}
+
+	return nil
+}
+
+// tooManyRequests rejects a request that senders should retry later
+func (h *Handler) tooManyRequests(w http.ResponseWriter, message string) {
+	if h.cfg.Ingest.RetryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(h.cfg.Ingest.RetryAfterSeconds))
+	}
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
 // PrometheusRemoteWrite handles incoming remote write requests from Prometheus
 func (h *Handler) PrometheusRemoteWrite(w http.ResponseWriter, r *http.Request) {
+	if !h.ingest.enter() {
+		h.shuttingDown(w)
+		return
+	}
+	defer h.ingest.leave()
+
 	requestID := generateRequestID()
 	remoteAddr := r.RemoteAddr
+
+	// Continue the sender's trace if it propagated one
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	_, span := tracing.Tracer().Start(ctx, "remote_write.receive",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("request.id", requestID)))
+	defer span.End()
 	logger.LogDebugWithFields("Received remote write request", logger.Fields{
 		"request_id":     requestID,
 		"remote_addr":    remoteAddr,
 		"content_length": r.ContentLength,
 	})
 
+	if err := checkRemoteWriteProtocol(r); err != nil {
+		logger.LogWarnWithFields("Rejecting remote write request with unsupported protocol", logger.Fields{
+			"request_id": requestID,
+			"error":      err.Error(),
+		})
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
 	startTime := time.Now()
 	compressed, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -36,6 +128,7 @@ func (h *Handler) PrometheusRemoteWrite(w http.ResponseWriter, r *http.Request)
 			"request_id": requestID,
 			"error":      err.Error(),
 		})
+		span.SetStatus(codes.Error, err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -51,6 +144,7 @@ func (h *Handler) PrometheusRemoteWrite(w http.ResponseWriter, r *http.Request)
 			"request_id": requestID,
 			"error":      err.Error(),
 		})
+		span.SetStatus(codes.Error, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -66,22 +160,111 @@ func (h *Handler) PrometheusRemoteWrite(w http.ResponseWriter, r *http.Request)
 			"request_id": requestID,
 			"error":      err.Error(),
 		})
+		span.SetStatus(codes.Error, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	timeseriesCount := len(req.Timeseries)
 	logger.LogDebugWithFields("Unmarshalled Prometheus write request", logger.Fields{
 		"request_id":       requestID,
-		"timeseries_count": timeseriesCount,
+		"timeseries_count": len(req.Timeseries),
 	})
 
+	h.ingestWriteRequest(w, r, &req, span, requestID, startTime)
+}
+
+// ingestWriteRequest tracks and aggregates the series of a decoded write
+// request, after HA deduplication, rate limiting, ingest filters and sample
+// age checks, and writes the response
+func (h *Handler) ingestWriteRequest(w http.ResponseWriter, r *http.Request, req *prompb.WriteRequest, span trace.Span, requestID string, startTime time.Time) {
+	timeseriesCount := len(req.Timeseries)
+
+	// Counter temporality depends on the source
+	h.metadata.Update(req.Metadata)
+	for _, m := range req.Metadata {
+		h.usageTracker.SetMetricMetadata(m.MetricFamilyName, strings.ToLower(m.Type.String()), m.Unit)
+	}
+	temporality := h.sourceTemporality(r.Header.Get(h.cfg.Temporality.SourceHeader))
+
+	requestSamples := 0
+	for _, ts := range req.Timeseries {
+		requestSamples += len(ts.Samples)
+	}
+
+	tenant := r.Header.Get(h.cfg.Ingest.TenantHeader)
+	if tenant == "" {
+		tenant = h.cfg.Ingest.DefaultTenant
+	}
+
+	// Only the elected replica of an HA pair is accepted; the other one is
+	// acknowledged so it does not retry
+	if cluster, replica := h.haTracker.replicaLabels(req.Timeseries); replica != "" {
+		accepted, changed := h.haTracker.Accept(tenant, cluster, replica, time.Now())
+		if changed {
+			pkgmetrics.RecordHAReplicaElected(cluster)
+			logger.LogInfoWithFields("Elected new HA replica after failover", logger.Fields{
+				"tenant":  tenant,
+				"cluster": cluster,
+				"replica": replica,
+			})
+		}
+		if !accepted {
+			deduplicated := 0
+			for _, ts := range req.Timeseries {
+				deduplicated += len(ts.Samples)
+			}
+			pkgmetrics.RecordHADeduplicatedSamples(cluster, deduplicated)
+			span.SetAttributes(attribute.String("ha.replica", replica), attribute.Bool("ha.deduplicated", true))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  "success",
+				"message": "Samples of a non-elected HA replica were deduplicated",
+			})
+			return
+		}
+		h.haTracker.stripReplicaLabel(req.Timeseries)
+	}
+
+	if !h.ingestLimiter.Allow(tenant, requestSamples) {
+		pkgmetrics.RecordRateLimitedSamples(tenant, requestSamples)
+		logger.LogWarnWithFields("Rejecting remote write request over the tenant rate limit", logger.Fields{
+			"request_id":    requestID,
+			"tenant":        tenant,
+			"samples_count": requestSamples,
+		})
+		span.SetStatus(codes.Error, "ingestion rate limit exceeded")
+		h.tooManyRequests(w, "ingestion rate limit exceeded")
+		return
+	}
+
+	// Requests are refused as a whole while the processor is overloaded, since
+	// a sender retries all samples of a request answered with 429
+	if h.processor != nil {
+		if err := h.processor.Admit(requestSamples); err != nil {
+			logger.LogWarnWithFields("Rejecting remote write request, processor is overloaded", logger.Fields{
+				"request_id":    requestID,
+				"samples_count": requestSamples,
+			})
+			span.SetStatus(codes.Error, err.Error())
+			h.tooManyRequests(w, err.Error())
+			return
+		}
+	}
+
 	// Process the timeseries data
 	processedCount := 0
+	overloaded := false
 	sampleCount := 0
+	filteredCount := 0
+	rejectedCount := 0
+	outOfBounds := make(map[string]int) // Samples with timestamps out of bounds by reason
 	metricNamesMap := make(map[string]bool)
 
 	for _, ts := range req.Timeseries {
+		if overloaded {
+			break
+		}
 		metricName := ""
 		labels := make(map[string]string)
 
@@ -103,16 +286,41 @@ func (h *Handler) PrometheusRemoteWrite(w http.ResponseWriter, r *http.Request)
 		}
 
 		metricNamesMap[metricName] = true
+
+		// Filtered series never reach the usage tracker or the rules
+		if action := h.ruleEngine.IngestAction(metricName, labels); action != models.IngestActionProcess {
+			pkgmetrics.RecordIngestFilteredSamples(action, len(ts.Samples))
+			filteredCount += len(ts.Samples)
+			continue
+		}
+
+		seriesTemporality := ""
+		if h.metadata.IsCounter(metricName) {
+			seriesTemporality = temporality
+		}
 		sampleCount += len(ts.Samples)
 
 		// Process each sample
 		for _, s := range ts.Samples {
+			// Samples from clients with a wrong clock are rejected or clamped
+			timestamp, reason, accepted := checkSampleAge(h.cfg.Ingest.SampleAge, time.Unix(0, s.Timestamp*int64(time.Millisecond)), startTime)
+			if reason != "" {
+				outOfBounds[reason]++
+			}
+			if !accepted {
+				rejectedCount++
+				continue
+			}
+
 			// Convert to our internal metric sample format
 			sample := &models.MetricSample{
-				Name:      metricName,
-				Value:     s.Value,
-				Timestamp: time.Unix(0, s.Timestamp*int64(time.Millisecond)),
-				Labels:    labels,
+				Name:        metricName,
+				Value:       s.Value,
+				Timestamp:   timestamp,
+				Labels:      labels,
+				Temporality: seriesTemporality,
+				Tenant:      tenant,
+				SpanContext: span.SpanContext(),
 			}
 
 			// Track metric usage for recommendation engine
@@ -121,13 +329,51 @@ func (h *Handler) PrometheusRemoteWrite(w http.ResponseWriter, r *http.Request)
 			// Process the metric through the aggregation engine
 			// This assumes we have a reference to the processor
 			if h.processor != nil {
-				h.processor.ProcessMetric(sample)
+				if err := h.processor.ProcessMetric(sample); errors.Is(err, aggregator.ErrOverloaded) {
+					// Nothing was queued yet, so the sender can safely retry
+					if processedCount == 0 {
+						logger.LogWarnWithFields("Rejecting remote write request, processor is overloaded", logger.Fields{
+							"request_id": requestID,
+						})
+						span.SetStatus(codes.Error, err.Error())
+						h.tooManyRequests(w, err.Error())
+						return
+					}
+					// Retrying would count the queued samples twice, so the
+					// write is acknowledged as partial and the rest dropped
+					logger.LogWarnWithFields("Dropping the rest of a remote write request, processor is overloaded", logger.Fields{
+						"request_id":      requestID,
+						"processed_count": processedCount,
+						"samples_count":   requestSamples,
+					})
+					overloaded = true
+					break
+				}
 			}
 
 			processedCount++
 		}
 	}
 
+	if len(outOfBounds) > 0 {
+		recordOutOfBounds(h.cfg.Ingest.SampleAge, tenant, outOfBounds)
+		logger.LogWarnWithFields("Remote write request has samples with timestamps out of bounds", logger.Fields{
+			"request_id":     requestID,
+			"tenant":         tenant,
+			"too_old":        outOfBounds[sampleTooOld],
+			"too_new":        outOfBounds[sampleTooNew],
+			"rejected_count": rejectedCount,
+		})
+	}
+
+	span.SetAttributes(
+		attribute.Int("timeseries.count", timeseriesCount),
+		attribute.Int("samples.count", sampleCount),
+		attribute.Int("samples.processed", processedCount),
+		attribute.Int("samples.filtered", filteredCount),
+		attribute.Int("samples.rejected", rejectedCount),
+	)
+
 	processingDuration := time.Since(startTime)
 	uniqueMetricsCount := len(metricNamesMap)
 
@@ -137,16 +383,23 @@ func (h *Handler) PrometheusRemoteWrite(w http.ResponseWriter, r *http.Request)
 		"unique_metrics":      uniqueMetricsCount,
 		"samples_count":       sampleCount,
 		"processed_count":     processedCount,
+		"filtered_count":      filteredCount,
+		"rejected_count":      rejectedCount,
 		"processing_duration": processingDuration.String(),
 		"processing_ms":       processingDuration.Milliseconds(),
 	})
 
 	// Return success response
+	message := "Remote write processed successfully"
+	if overloaded {
+		message = "Remote write partially processed, processor is overloaded"
+	}
+	w.Header().Set(remoteWriteSamplesWrittenHeader, strconv.Itoa(processedCount))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":            "success",
-		"message":           "Remote write processed successfully",
+		"message":           message,
 		"metrics_processed": processedCount,
 	})
 }
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckRemoteWriteProtocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		wantFail bool
+	}{
+		{name: "no headers", headers: nil},
+		{name: "remote write 1.0", headers: map[string]string{
+			"Content-Type":           "application/x-protobuf",
+			"Content-Encoding":       "snappy",
+			remoteWriteVersionHeader: "0.1.0",
+		}},
+		{name: "explicit 1.0 message", headers: map[string]string{
+			"Content-Type": "application/x-protobuf;proto=prometheus.WriteRequest",
+		}},
+		{name: "remote write 2.0 message", headers: map[string]string{
+			"Content-Type":           "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+			remoteWriteVersionHeader: "2.0.0",
+		}, wantFail: true},
+		{name: "remote write 2.0 version", headers: map[string]string{
+			remoteWriteVersionHeader: "2.0.0",
+		}, wantFail: true},
+		{name: "json body", headers: map[string]string{
+			"Content-Type": "application/json",
+		}, wantFail: true},
+		{name: "zstd encoding", headers: map[string]string{
+			"Content-Encoding": "zstd",
+		}, wantFail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/write", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if err := checkRemoteWriteProtocol(req); (err != nil) != tt.wantFail {
+				t.Errorf("checkRemoteWriteProtocol() error = %v, wantFail %v", err, tt.wantFail)
+			}
+		})
+	}
+}
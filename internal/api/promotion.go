@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// PromotionResult is how a promoted rule was rewritten for this environment,
+// and where it does not fit the usage observed here
+type PromotionResult struct {
+	Index    int                      `json:"index"`
+	ID       string                   `json:"id"`
+	Changes  []models.PromotionChange `json:"changes,omitempty"`
+	Warnings []string                 `json:"warnings,omitempty"`
+}
+
+// promotionMapping loads the promotion mapping file of the given name
+func (h *Handler) promotionMapping(name string) (*models.PromotionMapping, error) {
+	if err := models.ValidateID(name); err != nil {
+		return nil, fmt.Errorf("invalid promotion %q", name)
+	}
+	mapping, err := models.LoadPromotionMapping(filepath.Join(h.cfg.Promotion.MappingsPath, name+".yaml"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("unknown promotion %q: no mapping file in %s", name, h.cfg.Promotion.MappingsPath)
+	}
+	return mapping, err
+}
+
+// promoteRule rewrites a rule with a promotion mapping
+func (h *Handler) promoteRule(mapping *models.PromotionMapping, index int, rule *models.Rule) (*models.Rule, PromotionResult, error) {
+	promoted, changes, err := mapping.Promote(rule, h.cfg.Ownership.NamespaceLabel)
+	if err != nil {
+		return nil, PromotionResult{}, fmt.Errorf("rule %d (%s): %w", index, rule.ID, err)
+	}
+	return promoted, PromotionResult{Index: index, ID: promoted.ID, Changes: changes}, nil
+}
+
+// promotionWarnings checks a promoted rule against the usage observed in
+// this environment: its metrics should have been seen here, with the label
+// values it matches
+func (h *Handler) promotionWarnings(rule *models.Rule) []string {
+	if h.usageTracker == nil {
+		return nil
+	}
+
+	labels := make([]string, 0, len(rule.Matcher.Labels))
+	for label := range rule.Matcher.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var warnings []string
+	for _, name := range rule.Matcher.Names() {
+		if strings.Contains(name, "*") {
+			continue
+		}
+		if h.usageTracker.GetMetricInfo(name) == nil {
+			warnings = append(warnings, fmt.Sprintf("metric %s has not been seen in this environment", name))
+			continue
+		}
+		// Family sources may rename the labels the matcher refers to
+		if rule.Matcher.FamilySource(name) != nil {
+			continue
+		}
+		distributions := h.usageTracker.GetLabelDistribution(name)
+		for _, label := range labels {
+			if warning := labelValueWarning(distributions, name, label, rule.Matcher.Labels[label]); warning != "" {
+				warnings = append(warnings, warning)
+			}
+		}
+	}
+	return warnings
+}
+
+// labelValueWarning reports a label value a metric has not been seen with.
+// Values beyond the most frequent ones tracked are assumed to be seen.
+func labelValueWarning(distributions []metrics.LabelDistribution, name, label, value string) string {
+	for _, distribution := range distributions {
+		if distribution.Label != label {
+			continue
+		}
+		for _, top := range distribution.TopValues {
+			if top.Value == value {
+				return ""
+			}
+		}
+		if len(distribution.TopValues) >= distribution.Cardinality {
+			return fmt.Sprintf("metric %s has not been seen with %s=%q", name, label, value)
+		}
+		return ""
+	}
+	return fmt.Sprintf("metric %s has not been seen with label %s", name, label)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+func TestImportRules_Promotion(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Aggregator.RulesPath = filepath.Join(dir, "rules")
+	cfg.Promotion.MappingsPath = dir
+	cfg.Ownership.NamespaceLabel = "namespace"
+	mapping := "namespaces:\n  payments-staging: payments\nlabels:\n  cluster:\n    staging: prod\n"
+	if err := os.WriteFile(filepath.Join(dir, "prod.yaml"), []byte(mapping), 0644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := rules.NewEngine(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := metrics.NewUsageTracker(time.Hour)
+	tracker.TrackMetric("http_requests_total", map[string]string{"namespace": "payments", "cluster": "eu"}, 1)
+	h := &Handler{cfg: cfg, ruleEngine: engine, usageTracker: tracker}
+
+	body := `
+id: payments-requests
+name: Payments requests
+namespace: payments-staging
+matcher:
+  metric_names: [http_requests_total, http_errors_total]
+  labels:
+    namespace: payments-staging
+    cluster: staging
+aggregation:
+  type: sum
+  interval_seconds: 60
+  segmentation: [status]
+output:
+  metric_name: http_requests:sum
+`
+	w := httptest.NewRecorder()
+	h.ImportRules(w, httptest.NewRequest(http.MethodPost, "/rules/import?promotion=prod", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Created   int               `json:"created"`
+		Promotion []PromotionResult `json:"promotion"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Created != 1 || len(response.Promotion) != 1 {
+		t.Fatalf("response = %+v", response)
+	}
+	if len(response.Promotion[0].Changes) != 3 {
+		t.Errorf("changes = %+v, want namespace and two label matchers", response.Promotion[0].Changes)
+	}
+	want := []string{
+		"metric http_requests_total has not been seen with cluster=\"prod\"",
+		"metric http_errors_total has not been seen in this environment",
+	}
+	if got := response.Promotion[0].Warnings; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+
+	rule, err := engine.GetRule("payments-requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Namespace != "payments" || rule.Matcher.Labels["cluster"] != "prod" {
+		t.Errorf("saved rule = %+v", rule)
+	}
+
+	w = httptest.NewRecorder()
+	h.ImportRules(w, httptest.NewRequest(http.MethodPost, "/rules/import?promotion=../prod", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid promotion status = %d", w.Code)
+	}
+}
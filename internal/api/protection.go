@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// SetupProtectionRoutes sets up the routes for the protection list API
+func (h *Handler) SetupProtectionRoutes(router *mux.Router) {
+	router.HandleFunc("/protection", h.GetProtection).Methods("GET", "OPTIONS")
+	router.HandleFunc("/protection", h.UpdateProtection).Methods("PUT", "OPTIONS")
+}
+
+// GetProtection returns the protection list and the rules that violate it
+func (h *Handler) GetProtection(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.protectionResponse())
+}
+
+// UpdateProtection replaces the protection list. Existing rules that violate
+// the new list are reported but not changed.
+func (h *Handler) UpdateProtection(w http.ResponseWriter, r *http.Request) {
+	var list models.ProtectionList
+	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.ruleEngine.SetProtection(list); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := h.protectionResponse()
+	logViolations(response["violating_rules"].([]rules.ProtectionViolation))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// protectionResponse returns the protection list with the rules violating it
+func (h *Handler) protectionResponse() map[string]interface{} {
+	violations := h.ruleEngine.ProtectionViolations()
+	if violations == nil {
+		violations = []rules.ProtectionViolation{}
+	}
+
+	return map[string]interface{}{
+		"protection":      h.ruleEngine.Protection(),
+		"violating_rules": violations,
+	}
+}
+
+// logViolations warns about rules that violate the protection list
+func logViolations(violations []rules.ProtectionViolation) {
+	for _, violation := range violations {
+		logger.LogWarnWithFields("Rule violates the protection list", logger.Fields{
+			"rule_id": violation.RuleID,
+			"error":   violation.Error,
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/tracing"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// pushgatewaySource is the temporality source of pushed metrics, used when
+// the request does not name one
+const pushgatewaySource = "pushgateway"
+
+// PushgatewayPush receives metrics pushed by batch jobs like a Prometheus
+// Pushgateway does, at /metrics/job/<job>{/<label>/<value>}. The grouping key
+// of the path is added to every pushed series, which are ingested like remote
+// write. PUT and POST are handled alike since pushed groups are not kept.
+func (h *Handler) PushgatewayPush(w http.ResponseWriter, r *http.Request) {
+	if !h.ingest.enter() {
+		h.shuttingDown(w)
+		return
+	}
+	defer h.ingest.leave()
+
+	requestID := generateRequestID()
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	_, span := tracing.Tracer().Start(ctx, "pushgateway.receive",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("request.id", requestID)))
+	defer span.End()
+
+	startTime := time.Now()
+	reject := func(err error) {
+		logger.LogWarnWithFields("Rejecting pushed metrics", logger.Fields{
+			"request_id": requestID,
+			"error":      err.Error(),
+		})
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+
+	grouping, err := parseGroupingKey(r.URL.EscapedPath())
+	if err != nil {
+		reject(err)
+		return
+	}
+	families, err := decodePushedFamilies(r)
+	if err != nil {
+		reject(err)
+		return
+	}
+	req, err := pushedWriteRequest(families, grouping, startTime)
+	if err != nil {
+		reject(err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("pushgateway.job", grouping["job"]))
+	logger.LogDebugWithFields("Decoded pushed metrics", logger.Fields{
+		"request_id":       requestID,
+		"job":              grouping["job"],
+		"timeseries_count": len(req.Timeseries),
+	})
+
+	// Batch jobs often push totals of a single run, which are configured as
+	// delta through the pushgateway source
+	if header := h.cfg.Temporality.SourceHeader; header != "" && r.Header.Get(header) == "" {
+		r.Header.Set(header, pushgatewaySource)
+	}
+
+	h.ingestWriteRequest(w, r, req, span, requestID, startTime)
+}
+
+// PushgatewayDelete acknowledges the deletion of a pushed group. Pushed
+// metrics are aggregated as they arrive and not kept per group, so there is
+// nothing to delete; jobs that clean up after themselves keep working.
+func (h *Handler) PushgatewayDelete(w http.ResponseWriter, r *http.Request) {
+	if _, err := parseGroupingKey(r.URL.EscapedPath()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// parseGroupingKey parses the grouping key of a Pushgateway path,
+// /metrics/job/<job>{/<label>/<value>}. Label names ending in @base64 have
+// values encoded in URL-safe base64, which allows slashes and empty values.
+func parseGroupingKey(escapedPath string) (map[string]string, error) {
+	rest := strings.TrimPrefix(escapedPath, "/metrics/")
+	segments := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+	if len(segments)%2 != 0 {
+		return nil, fmt.Errorf("grouping key %q has a label without a value", rest)
+	}
+
+	grouping := make(map[string]string, len(segments)/2)
+	for i := 0; i < len(segments); i += 2 {
+		name, err := url.PathUnescape(segments[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid label name %q: %w", segments[i], err)
+		}
+		value, err := url.PathUnescape(segments[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of label %s: %w", name, err)
+		}
+		if encoded, ok := strings.CutSuffix(name, "@base64"); ok {
+			name = encoded
+			decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value of label %s: %w", name, err)
+			}
+			value = string(decoded)
+		}
+
+		if i == 0 && name != "job" {
+			return nil, fmt.Errorf("grouping key must start with job, got %q", name)
+		}
+		if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q in grouping key", name)
+		}
+		if _, exists := grouping[name]; exists {
+			return nil, fmt.Errorf("label %s appears twice in grouping key", name)
+		}
+		grouping[name] = value
+	}
+
+	if grouping["job"] == "" {
+		return nil, errors.New("job name is required")
+	}
+	return grouping, nil
+}
+
+// decodePushedFamilies decodes metric families pushed in the text exposition
+// format or as length-delimited protobuf, optionally gzip compressed
+func decodePushedFamilies(r *http.Request) ([]*dto.MetricFamily, error) {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress request body: %w", err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	decoder := expfmt.NewDecoder(body, expfmt.ResponseFormat(r.Header))
+	var families []*dto.MetricFamily
+	for {
+		family := &dto.MetricFamily{}
+		if err := decoder.Decode(family); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse pushed metrics: %w", err)
+		}
+		families = append(families, family)
+	}
+	return families, nil
+}
+
+// pushedWriteRequest converts pushed metric families to a write request, with
+// the grouping key as labels of every series. Metrics without a timestamp are
+// stamped with now. A pushed label that contradicts the grouping key is an
+// error, as it is for the Pushgateway.
+func pushedWriteRequest(families []*dto.MetricFamily, grouping map[string]string, now time.Time) (*prompb.WriteRequest, error) {
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+
+	req := &prompb.WriteRequest{}
+	for _, family := range families {
+		name := family.GetName()
+		req.Metadata = append(req.Metadata, prompb.MetricMetadata{
+			Type:             pushedMetadataType(family.GetType()),
+			MetricFamilyName: name,
+			Help:             family.GetHelp(),
+			Unit:             family.GetUnit(),
+		})
+
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string, len(metric.GetLabel())+len(grouping))
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			for label, value := range grouping {
+				if pushed, exists := labels[label]; exists && pushed != value {
+					return nil, fmt.Errorf("metric %s has label %s=%q, which contradicts the grouping key value %q", name, label, pushed, value)
+				}
+				labels[label] = value
+			}
+
+			timestamp := now.UnixMilli()
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+			add := func(name string, extra *prompb.Label, value float64) {
+				req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
+					Labels:  pushedLabels(name, labels, extra),
+					Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+				})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, nil, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, nil, metric.GetGauge().GetValue())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				infinite := false
+				for _, bucket := range histogram.GetBucket() {
+					infinite = infinite || math.IsInf(bucket.GetUpperBound(), 1)
+					add(name+"_bucket", &prompb.Label{Name: "le", Value: formatBound(bucket.GetUpperBound())}, float64(bucket.GetCumulativeCount()))
+				}
+				if !infinite {
+					add(name+"_bucket", &prompb.Label{Name: "le", Value: "+Inf"}, float64(histogram.GetSampleCount()))
+				}
+				add(name+"_sum", nil, histogram.GetSampleSum())
+				add(name+"_count", nil, float64(histogram.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add(name, &prompb.Label{Name: "quantile", Value: formatBound(quantile.GetQuantile())}, quantile.GetValue())
+				}
+				add(name+"_sum", nil, summary.GetSampleSum())
+				add(name+"_count", nil, float64(summary.GetSampleCount()))
+			default:
+				add(name, nil, metric.GetUntyped().GetValue())
+			}
+		}
+	}
+	return req, nil
+}
+
+// pushedLabels returns the sorted labels of a pushed series
+func pushedLabels(name string, labels map[string]string, extra *prompb.Label) []prompb.Label {
+	result := make([]prompb.Label, 0, len(labels)+2)
+	result = append(result, prompb.Label{Name: "__name__", Value: name})
+	for label, value := range labels {
+		result = append(result, prompb.Label{Name: label, Value: value})
+	}
+	if extra != nil {
+		result = append(result, *extra)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// pushedMetadataType maps the type of a pushed metric family to remote write
+// metadata
+func pushedMetadataType(metricType dto.MetricType) prompb.MetricMetadata_MetricType {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return prompb.MetricMetadata_COUNTER
+	case dto.MetricType_GAUGE:
+		return prompb.MetricMetadata_GAUGE
+	case dto.MetricType_HISTOGRAM:
+		return prompb.MetricMetadata_HISTOGRAM
+	case dto.MetricType_GAUGE_HISTOGRAM:
+		return prompb.MetricMetadata_GAUGEHISTOGRAM
+	case dto.MetricType_SUMMARY:
+		return prompb.MetricMetadata_SUMMARY
+	default:
+		return prompb.MetricMetadata_UNKNOWN
+	}
+}
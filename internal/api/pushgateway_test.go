@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestParseGroupingKey(t *testing.T) {
+	grouping, err := parseGroupingKey("/metrics/job/backup/instance@base64/ZGIvMQ/shard/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grouping["job"] != "backup" || grouping["instance"] != "db/1" || grouping["shard"] != "2" {
+		t.Errorf("grouping = %v", grouping)
+	}
+
+	grouping, err = parseGroupingKey("/metrics/job/backup/instance@base64/=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, exists := grouping["instance"]; !exists || value != "" {
+		t.Errorf("grouping = %v, want empty instance", grouping)
+	}
+
+	for _, path := range []string{
+		"/metrics/job",
+		"/metrics/job/",
+		"/metrics/instance/db/job/backup",
+		"/metrics/job/backup/instance",
+		"/metrics/job/backup/__name__/x",
+		"/metrics/job/backup/shard/1/shard/2",
+	} {
+		if _, err := parseGroupingKey(path); err == nil {
+			t.Errorf("parseGroupingKey(%q) succeeded", path)
+		}
+	}
+}
+
+func TestPushedWriteRequest(t *testing.T) {
+	body := `# TYPE backup_duration_seconds histogram
+backup_duration_seconds_bucket{le="1"} 0
+backup_duration_seconds_bucket{le="10"} 1
+backup_duration_seconds_bucket{le="+Inf"} 1
+backup_duration_seconds_sum 4.5
+backup_duration_seconds_count 1
+# TYPE backup_files_total counter
+backup_files_total{volume="data"} 120
+`
+	r := httptest.NewRequest(http.MethodPut, "/metrics/job/backup", strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	families, err := decodePushedFamilies(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := pushedWriteRequest(families, map[string]string{"job": "backup"}, time.UnixMilli(1700000000000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"__name__=backup_duration_seconds_bucket,job=backup,le=1 0@1700000000000",
+		"__name__=backup_duration_seconds_bucket,job=backup,le=10 1@1700000000000",
+		"__name__=backup_duration_seconds_bucket,job=backup,le=+Inf 1@1700000000000",
+		"__name__=backup_duration_seconds_sum,job=backup 4.5@1700000000000",
+		"__name__=backup_duration_seconds_count,job=backup 1@1700000000000",
+		"__name__=backup_files_total,job=backup,volume=data 120@1700000000000",
+	}
+	if len(req.Timeseries) != len(want) {
+		t.Fatalf("got %d series, want %d", len(req.Timeseries), len(want))
+	}
+	for i, ts := range req.Timeseries {
+		if got := seriesString(ts); got != want[i] {
+			t.Errorf("series %d = %q, want %q", i, got, want[i])
+		}
+	}
+	if len(req.Metadata) != 2 || req.Metadata[1].Type != prompb.MetricMetadata_COUNTER {
+		t.Errorf("metadata = %+v", req.Metadata)
+	}
+
+	if _, err := pushedWriteRequest(families, map[string]string{"job": "backup", "volume": "logs"}, time.Now()); err == nil {
+		t.Error("expected error for a label contradicting the grouping key")
+	}
+}
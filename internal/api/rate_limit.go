@@ -0,0 +1,206 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// How the request limiter tells clients apart
+const (
+	RateLimitByIP     = "ip"
+	RateLimitByTenant = "tenant"
+	RateLimitByAPIKey = "api_key"
+)
+
+// Request classes with separate limits
+const (
+	rateLimitIngest = "ingest"
+	rateLimitAPI    = "api"
+)
+
+// ingestPaths are the ingestion endpoints, limited as ingest
+var ingestPaths = map[string]bool{
+	"/api/v1/write":             true,
+	"/api/v1/ingest":            true,
+	"/api/v1/ingest/cloudwatch": true,
+}
+
+// pushgatewayPath prefixes the Pushgateway endpoints, limited as ingest
+const pushgatewayPath = "/metrics/job"
+
+// rateLimitSweepInterval is how often buckets of clients that stopped sending
+// are removed
+const rateLimitSweepInterval = time.Minute
+
+// Sampled logs for rejected requests
+var rateLimitedLog = logger.NewSampler(logger.Warn, "Rejected requests over the request rate limit")
+
+// RequestLimiter limits the request rate of each client to the API and to
+// remote write ingestion with token buckets. Unlike the ingest limiter, which
+// limits samples per tenant, it limits requests, so that a misconfigured
+// sender retrying in a tight loop cannot starve the aggregator.
+type RequestLimiter struct {
+	cfg          config.RateLimitConfig
+	tenantHeader string
+	buckets      map[string]*tokenBucket
+	lastSweep    time.Time
+	mu           sync.Mutex
+}
+
+// NewRequestLimiter creates a new request limiter. Tenants are identified by
+// the tenant header of the ingest config.
+func NewRequestLimiter(cfg config.RateLimitConfig, ingest config.IngestConfig) *RequestLimiter {
+	return &RequestLimiter{
+		cfg:          cfg,
+		tenantHeader: ingest.TenantHeader,
+		buckets:      make(map[string]*tokenBucket),
+		lastSweep:    time.Now(),
+	}
+}
+
+// Middleware rejects requests over the limit of their client with 429 and a
+// Retry-After header. Only the API and ingestion routes are limited.
+func (l *RequestLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class, limited := requestClass(r.URL.Path)
+		if !limited || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, retryAfter := l.Allow(class, l.clientKey(r), time.Now())
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metrics.RecordRateLimitedRequest(class)
+		rateLimitedLog.Log(logger.Fields{
+			"class": class,
+			"by":    l.cfg.By,
+			"path":  r.URL.Path,
+		})
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "request rate limit exceeded", http.StatusTooManyRequests)
+	})
+}
+
+// Allow takes a token from the bucket of a client for a request class, and
+// otherwise returns how long until the next request would be allowed
+func (l *RequestLimiter) Allow(class, client string, now time.Time) (bool, time.Duration) {
+	limit := l.limit(class, client)
+	if limit.RequestsPerSecond <= 0 {
+		return true, 0
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(limit.RequestsPerSecond))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	key := class + "/" + client
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{rate: limit.RequestsPerSecond, burst: float64(burst), tokens: float64(burst), last: now}
+		l.buckets[key] = bucket
+	}
+	if bucket.allow(1, now) {
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+	return false, max(wait, time.Second)
+}
+
+// requestClass returns the class of the requests to a path, and false for
+// paths that are not limited
+func requestClass(path string) (string, bool) {
+	switch {
+	case ingestPaths[path], path == pushgatewayPath, strings.HasPrefix(path, pushgatewayPath+"/"):
+		return rateLimitIngest, true
+	case strings.HasPrefix(path, "/api/"):
+		return rateLimitAPI, true
+	}
+	return "", false
+}
+
+// limit returns the limit of a client for a request class
+func (l *RequestLimiter) limit(class, client string) config.RequestLimitConfig {
+	// Configuration keys are case-insensitive
+	if overrides, exists := l.cfg.ClientLimits[strings.ToLower(client)]; exists {
+		if class == rateLimitIngest {
+			return overrides.Ingest
+		}
+		return overrides.API
+	}
+	if class == rateLimitIngest {
+		return l.cfg.Ingest
+	}
+	return l.cfg.API
+}
+
+// sweep removes full buckets, whose clients have not sent for long enough
+// that recreating the bucket makes no difference
+func (l *RequestLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate >= bucket.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// clientKey identifies the client of a request. Tenants and API keys are only
+// trusted from authenticated requests, otherwise a client could evade its
+// limit by changing them on every request.
+func (l *RequestLimiter) clientKey(r *http.Request) string {
+	if !requestAuthenticated(r) {
+		return clientIP(r, l.cfg.TrustForwardedFor)
+	}
+	switch l.cfg.By {
+	case RateLimitByTenant:
+		if tenant := r.Header.Get(l.tenantHeader); tenant != "" {
+			return tenant
+		}
+	case RateLimitByAPIKey:
+		if key := r.Header.Get(l.cfg.APIKeyHeader); key != "" {
+			// Keys are only kept hashed
+			sum := sha256.Sum256([]byte(key))
+			return "key:" + hex.EncodeToString(sum[:8])
+		}
+	}
+	// Requests without a tenant or API key are limited by address
+	return clientIP(r, l.cfg.TrustForwardedFor)
+}
+
+// clientIP returns the address of the client of a request, taken from the
+// X-Forwarded-For header if it is set by a trusted proxy
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			first, _, _ := strings.Cut(forwardedFor, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
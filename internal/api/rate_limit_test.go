@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+)
+
+func TestRequestLimiter_Allow(t *testing.T) {
+	limiter := NewRequestLimiter(config.RateLimitConfig{
+		Ingest: config.RequestLimitConfig{RequestsPerSecond: 1, Burst: 2},
+		ClientLimits: map[string]config.ClientLimitConfig{
+			"team-a": {Ingest: config.RequestLimitConfig{RequestsPerSecond: 100}},
+		},
+	}, config.IngestConfig{})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.Allow(rateLimitIngest, "10.0.0.1", now); !allowed {
+			t.Fatalf("request %d within the burst was rejected", i)
+		}
+	}
+	allowed, retryAfter := limiter.Allow(rateLimitIngest, "10.0.0.1", now)
+	if allowed || retryAfter != time.Second {
+		t.Errorf("Allow() over the burst = %v, %v, want rejected for 1s", allowed, retryAfter)
+	}
+
+	// Clients and request classes have their own buckets
+	if allowed, _ := limiter.Allow(rateLimitIngest, "10.0.0.2", now); !allowed {
+		t.Error("expected another client to be allowed")
+	}
+	if allowed, _ := limiter.Allow(rateLimitAPI, "10.0.0.1", now); !allowed {
+		t.Error("expected the unlimited API class to be allowed")
+	}
+	for i := 0; i < 50; i++ {
+		if allowed, _ := limiter.Allow(rateLimitIngest, "Team-A", now); !allowed {
+			t.Fatalf("request %d of a client with a higher limit was rejected", i)
+		}
+	}
+
+	// Tokens are refilled at the rate
+	if allowed, _ := limiter.Allow(rateLimitIngest, "10.0.0.1", now.Add(time.Second)); !allowed {
+		t.Error("expected a request to be allowed after a second")
+	}
+
+	// Buckets of clients that stopped sending are removed
+	limiter.Allow(rateLimitIngest, "10.0.0.3", now.Add(time.Hour))
+	if len(limiter.buckets) != 1 {
+		t.Errorf("%d buckets after sweeping, want 1", len(limiter.buckets))
+	}
+}
+
+func TestRequestLimiter_Middleware(t *testing.T) {
+	limiter := NewRequestLimiter(config.RateLimitConfig{
+		By:     RateLimitByTenant,
+		API:    config.RequestLimitConfig{RequestsPerSecond: 1, Burst: 1},
+		Ingest: config.RequestLimitConfig{RequestsPerSecond: 1, Burst: 1},
+	}, config.IngestConfig{TenantHeader: "X-Scope-OrgID"})
+	handler := AuthMiddleware(config.ListenerAuthConfig{BearerToken: "secret"})(
+		limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	send := func(req *http.Request, tenant string) *httptest.ResponseRecorder {
+		req.Header.Set("X-Scope-OrgID", tenant)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	request := func(path, tenant string) *httptest.ResponseRecorder {
+		return send(httptest.NewRequest(http.MethodGet, path, nil), tenant)
+	}
+
+	request("/api/v1/rules", "a")
+	rec := request("/api/v1/rules", "a")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("second request = %d with Retry-After %q, want 429 with 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := request("/api/v1/rules", "b"); rec.Code != http.StatusOK {
+		t.Errorf("request of another tenant = %d, want 200", rec.Code)
+	}
+	if rec := request("/health", "a"); rec.Code != http.StatusOK {
+		t.Errorf("health check = %d, want it not to be limited", rec.Code)
+	}
+
+	// Every ingestion route is limited as ingest
+	for _, path := range []string{"/api/v1/write", "/api/v1/ingest", "/api/v1/ingest/cloudwatch", "/metrics/job/backup"} {
+		if class, limited := requestClass(path); class != rateLimitIngest || !limited {
+			t.Errorf("requestClass(%s) = %s, %v, want ingest", path, class, limited)
+		}
+	}
+	request("/metrics/job/backup", "c")
+	if rec := request("/api/v1/ingest", "c"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("agent ingestion over the ingest limit = %d, want 429", rec.Code)
+	}
+}
+
+func TestRequestLimiter_UnauthenticatedClients(t *testing.T) {
+	limiter := NewRequestLimiter(config.RateLimitConfig{
+		By:  RateLimitByTenant,
+		API: config.RequestLimitConfig{RequestsPerSecond: 1, Burst: 1},
+	}, config.IngestConfig{TenantHeader: "X-Scope-OrgID"})
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// Without authentication, changing the tenant or claiming to be a
+	// sharding peer does not evade the limit of the address
+	codes := make([]int, 0, 3)
+	for _, tenant := range []string{"a", "b", "c"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/rules", nil)
+		req.Header.Set("X-Scope-OrgID", tenant)
+		if tenant == "c" {
+			req.Header.Set("X-Adaptive-Metrics-Forwarded", "true")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("status codes = %v, want the second and third requests limited", codes)
+	}
+}
+
+func TestIngestLimiter_Sweep(t *testing.T) {
+	limiter := NewIngestLimiter(config.IngestConfig{RateLimit: 10, Burst: 10})
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		limiter.allow(fmt.Sprintf("tenant-%d", i), 5, now)
+	}
+	if limiter.allow("tenant-0", 10, now) {
+		t.Error("expected a tenant over its burst to be limited")
+	}
+
+	// Buckets of tenants that stopped sending are removed
+	limiter.allow("tenant-100", 5, now.Add(time.Hour))
+	if len(limiter.buckets) != 1 {
+		t.Errorf("%d buckets after sweeping, want 1", len(limiter.buckets))
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// readinessCheck is the result of one dependency probe
+type readinessCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessCheck handles readiness requests. Unlike the health check, which
+// only reports that the process is up, it fails with 503 until the rules
+// directory is readable, the storage backend reachable and the processor
+// running, so that no traffic is routed to a half-started instance.
+func (h *Handler) ReadinessCheck(w http.ResponseWriter, r *http.Request) {
+	timeout := time.Duration(h.cfg.Server.Readiness.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	probes := map[string]func(context.Context) error{
+		"rules":     h.checkRules,
+		"storage":   h.checkStorage,
+		"processor": h.checkProcessor,
+		"ingest":    h.checkIngest,
+	}
+	if h.cfg.RemoteWrite.Enabled && h.cfg.Server.Readiness.ResolveRemoteWrite {
+		probes["remote_write"] = h.checkRemoteWrite
+	}
+
+	ready := true
+	checks := make(map[string]readinessCheck, len(probes))
+	for name, probe := range probes {
+		if err := probe(ctx); err != nil {
+			ready = false
+			checks[name] = readinessCheck{Status: "failed", Error: err.Error()}
+			continue
+		}
+		checks[name] = readinessCheck{Status: "ok"}
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"time":   time.Now().Format(time.RFC3339),
+		"checks": checks,
+	})
+}
+
+// checkRules checks that the rules directory can be listed
+func (h *Handler) checkRules(ctx context.Context) error {
+	_, err := os.ReadDir(h.cfg.Aggregator.RulesPath)
+	return err
+}
+
+// checkStorage checks that a storage backend other than memory accepts connections
+func (h *Handler) checkStorage(ctx context.Context) error {
+	if h.cfg.Storage.Type == "" || h.cfg.Storage.Type == "memory" {
+		return nil
+	}
+	if h.cfg.Storage.Connection == "" {
+		return fmt.Errorf("no connection configured for %s storage", h.cfg.Storage.Type)
+	}
+
+	address := h.cfg.Storage.Connection
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		address = u.Host
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkProcessor checks that the metric processor was started
+func (h *Handler) checkProcessor(ctx context.Context) error {
+	if h.processor == nil || !h.processor.Running() {
+		return fmt.Errorf("processor is not running")
+	}
+	return nil
+}
+
+// checkIngest checks that remote write requests are still accepted
+func (h *Handler) checkIngest(ctx context.Context) error {
+	if h.ingest.isClosed() {
+		return fmt.Errorf("shutting down")
+	}
+	return nil
+}
+
+// checkRemoteWrite checks that the hosts of the remote write endpoints resolve
+func (h *Handler) checkRemoteWrite(ctx context.Context) error {
+	for _, endpoint := range h.cfg.RemoteWrite.Endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint %s: %w", endpoint, err)
+		}
+		host := u.Hostname()
+		if net.ParseIP(host) != nil || strings.EqualFold(host, "localhost") {
+			continue
+		}
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", host, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/aggregator"
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+)
+
+func TestHandler_ReadinessCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	cfg := &config.Config{}
+	cfg.Aggregator.RulesPath = t.TempDir()
+	cfg.Aggregator.BatchSize = 10
+	cfg.Storage = config.StorageConfig{Type: "redis", Connection: "redis://" + listener.Addr().String()}
+	processor, err := aggregator.NewProcessor(cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{cfg: cfg, processor: processor}
+
+	ready := func() (int, map[string]readinessCheck) {
+		rec := httptest.NewRecorder()
+		h.ReadinessCheck(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var body struct {
+			Checks map[string]readinessCheck `json:"checks"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, body.Checks
+	}
+
+	// Not ready before the processor starts
+	if code, checks := ready(); code != http.StatusServiceUnavailable || checks["processor"].Status != "failed" {
+		t.Fatalf("ReadinessCheck() = %d %+v, want the processor check to fail", code, checks)
+	}
+
+	processor.Start()
+	defer processor.Stop()
+	if code, checks := ready(); code != http.StatusOK {
+		t.Fatalf("ReadinessCheck() = %d %+v, want ready", code, checks)
+	}
+
+	// Not ready while storage is unreachable
+	listener.Close()
+	if code, checks := ready(); code != http.StatusServiceUnavailable || checks["storage"].Status != "failed" {
+		t.Errorf("ReadinessCheck() = %d %+v, want the storage check to fail", code, checks)
+	}
+}
+
+func TestHandler_StopIngest(t *testing.T) {
+	h := &Handler{cfg: &config.Config{}}
+
+	// An in-flight request holds up the drain until it finishes
+	if !h.ingest.enter() {
+		t.Fatal("expected the gate to admit requests")
+	}
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- h.StopIngest(context.Background())
+	}()
+
+	rec := httptest.NewRecorder()
+	for !h.ingest.isClosed() {
+		time.Sleep(time.Millisecond)
+	}
+	h.PrometheusRemoteWrite(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("remote write during shutdown = %d, want 503", rec.Code)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("StopIngest() returned before the in-flight request finished")
+	default:
+	}
+
+	h.ingest.leave()
+	if err := <-stopped; err != nil {
+		t.Errorf("StopIngest() error = %v", err)
+	}
+}
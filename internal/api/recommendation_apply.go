@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// Steps of applying a recommendation that can fail
+const (
+	applyStepCreateRule    = "create_rule"
+	applyStepPersistStatus = "persist_status"
+)
+
+// ApplyError reports the step at which applying a recommendation failed and
+// whether the steps completed before it were reverted
+type ApplyError struct {
+	RecommendationID string `json:"recommendation_id"`
+	RuleID           string `json:"rule_id"`
+	Step             string `json:"step"`
+	Cause            string `json:"error"`
+	// RolledBack is false when reverting a completed step failed too, leaving
+	// the rule in place
+	RolledBack    bool   `json:"rolled_back"`
+	RollbackError string `json:"rollback_error,omitempty"`
+
+	err error
+}
+
+// Error implements error
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Step, e.err)
+}
+
+// Unwrap returns the error of the failed step
+func (e *ApplyError) Unwrap() error {
+	return e.err
+}
+
+// apply creates the rule of a recommendation and marks it applied. The rule
+// is created and registered first and the status persisted last, so a
+// recommendation is only ever applied with its rule in place; when a step
+// fails, the completed ones are reverted and an *ApplyError is returned.
+func (h *RecommendationHandler) apply(recommendation *models.Recommendation) (models.Rule, error) {
+	status := recommendation.Status
+
+	// Create rule from recommendation
+	rule := recommendation.Rule
+	if rule.ID == "" {
+		rule.ID = "autogen-" + strings.TrimPrefix(recommendation.ID, "rec-")
+	}
+	rule.RecommendationID = recommendation.ID
+	rule.Enabled = true // Enable the rule when applying a recommendation
+	rule.Output.MetricName = models.CurrentOutputNamePolicy().Apply(rule.Output.MetricName)
+	h.ownership.assign(&rule)
+
+	fail := func(step string, err error) *ApplyError {
+		return &ApplyError{RecommendationID: recommendation.ID, RuleID: rule.ID, Step: step, Cause: err.Error(), RolledBack: true, err: err}
+	}
+
+	// A rule with the same ID is replaced, and restored on rollback
+	previous, err := h.ruleStore.GetRule(rule.ID)
+	existed := err == nil
+
+	if err := h.ruleStore.AddRule(rule); err != nil {
+		return rule, fail(applyStepCreateRule, err)
+	}
+
+	// Register rule as coming from a recommendation for remote write filtering
+	if h.processor != nil {
+		h.processor.RegisterRecommendationRule(rule.ID)
+	}
+
+	// Update recommendation status and start measuring its actual impact
+	applied := *recommendation
+	applied.Status = "applied"
+	h.recommendationEngine.BeginVerification(&applied, time.Now())
+	if err := h.store.UpdateRecommendationIf(applied, status); err != nil {
+		applyErr := fail(applyStepPersistStatus, err)
+		h.rollbackRule(applyErr, previous, existed)
+		return rule, applyErr
+	}
+
+	*recommendation = applied
+	return rule, nil
+}
+
+// rollbackRule reverts the creation and registration of the rule of a failed
+// apply, restoring the rule it replaced if any
+func (h *RecommendationHandler) rollbackRule(applyErr *ApplyError, previous models.Rule, existed bool) {
+	// A replaced rule from a recommendation stays registered
+	if h.processor != nil && !(existed && previous.RecommendationID != "") {
+		h.processor.UnregisterRecommendationRule(applyErr.RuleID)
+	}
+
+	var err error
+	if existed {
+		err = h.ruleStore.UpdateRule(previous)
+	} else {
+		err = h.ruleStore.DeleteRule(applyErr.RuleID)
+	}
+	if err != nil {
+		applyErr.RolledBack = false
+		applyErr.RollbackError = err.Error()
+		logger.LogErrorWithFields("Failed to roll back rule of recommendation", logger.Fields{
+			"recommendation_id": applyErr.RecommendationID,
+			"rule_id":           applyErr.RuleID,
+			"step":              applyErr.Step,
+			"error":             err.Error(),
+		})
+	}
+}
+
+// writeApplyError responds with the failure of an apply. Conflicting status
+// changes are reported as conflicts; failures to create the rule as errors.
+func writeApplyError(w http.ResponseWriter, err error) {
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		http.Error(w, "Failed to apply recommendation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	code := http.StatusInternalServerError
+	if applyErr.Step == applyStepPersistStatus {
+		code = http.StatusConflict
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "error",
+		"message": "Failed to apply recommendation",
+		"failure": applyErr,
+	})
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// failingRuleStore is an in-memory rule store whose operations can be made to fail
+type failingRuleStore struct {
+	rules                map[string]models.Rule
+	failAdd, failRestore bool
+}
+
+func (s *failingRuleStore) AddRule(rule models.Rule) error {
+	if s.failAdd {
+		return errors.New("disk full")
+	}
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+func (s *failingRuleStore) GetRule(id string) (models.Rule, error) {
+	rule, exists := s.rules[id]
+	if !exists {
+		return models.Rule{}, fmt.Errorf("rule %s not found", id)
+	}
+	return rule, nil
+}
+
+func (s *failingRuleStore) GetRules() ([]models.Rule, error) {
+	var rules []models.Rule
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (s *failingRuleStore) UpdateRule(rule models.Rule) error {
+	if s.failRestore {
+		return errors.New("disk full")
+	}
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+func (s *failingRuleStore) DeleteRule(id string) error {
+	if s.failRestore {
+		return errors.New("disk full")
+	}
+	delete(s.rules, id)
+	return nil
+}
+
+// recordingProcessor records the rules registered as coming from recommendations
+type recordingProcessor map[string]bool
+
+func (p recordingProcessor) RegisterRecommendationRule(ruleID string)   { p[ruleID] = true }
+func (p recordingProcessor) UnregisterRecommendationRule(ruleID string) { delete(p, ruleID) }
+
+func newApplyTestHandler() (*RecommendationHandler, *failingRuleStore, recordingProcessor) {
+	store := NewRecommendationStore()
+	store.AddRecommendation(models.Recommendation{
+		ID:     "rec-requests",
+		Status: "pending",
+		Rule: models.Rule{
+			ID:          "autogen-requests",
+			Name:        "requests",
+			Matcher:     models.MetricMatcher{MetricNames: []string{"requests_total"}},
+			Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 60},
+			Output:      models.OutputConfig{MetricName: "requests_total:sum"},
+		},
+	})
+	tracker := metrics.NewUsageTracker(time.Hour)
+	rules := &failingRuleStore{rules: make(map[string]models.Rule)}
+	h := NewRecommendationHandler(store, tracker, metrics.NewRecommendationEngine(tracker, 1000, 100, 0.5), rules)
+	processor := recordingProcessor{}
+	h.SetProcessor(processor)
+	return h, rules, processor
+}
+
+func TestRecommendationHandler_Apply(t *testing.T) {
+	h, rules, processor := newApplyTestHandler()
+	rec, _ := h.store.GetRecommendation("rec-requests")
+
+	rule, err := h.apply(&rec)
+	if err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if _, exists := rules.rules[rule.ID]; !exists || !processor[rule.ID] {
+		t.Errorf("rule %s created %v, registered %v, want both", rule.ID, exists, processor[rule.ID])
+	}
+	if stored, _ := h.store.GetRecommendation("rec-requests"); stored.Status != "applied" || stored.AppliedAt == nil {
+		t.Errorf("stored recommendation = %+v, want applied", stored)
+	}
+}
+
+func TestRecommendationHandler_Apply_CreateRuleFails(t *testing.T) {
+	h, rules, processor := newApplyTestHandler()
+	rules.failAdd = true
+
+	router := mux.NewRouter()
+	router.HandleFunc("/recommendations/{id}/apply", h.ApplyRecommendation)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/recommendations/rec-requests/apply", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if stored, _ := h.store.GetRecommendation("rec-requests"); stored.Status != "pending" {
+		t.Errorf("stored status = %s, want pending", stored.Status)
+	}
+	if len(processor) != 0 {
+		t.Errorf("registered rules = %v, want none", processor)
+	}
+}
+
+func TestRecommendationHandler_Apply_PersistStatusFails(t *testing.T) {
+	h, rules, processor := newApplyTestHandler()
+	rules.rules["autogen-requests"] = models.Rule{ID: "autogen-requests", Name: "hand-written"}
+
+	// The recommendation is rejected while it is being applied
+	rec, _ := h.store.GetRecommendation("rec-requests")
+	rejected := rec
+	rejected.Status = "rejected"
+	h.store.UpdateRecommendation(rejected)
+
+	_, err := h.apply(&rec)
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) || applyErr.Step != applyStepPersistStatus || !applyErr.RolledBack {
+		t.Fatalf("apply() error = %v, want a rolled back persist_status failure", err)
+	}
+	if rules.rules["autogen-requests"].Name != "hand-written" {
+		t.Errorf("rule = %+v, want the replaced rule restored", rules.rules["autogen-requests"])
+	}
+	if len(processor) != 0 {
+		t.Errorf("registered rules = %v, want none", processor)
+	}
+	if stored, _ := h.store.GetRecommendation("rec-requests"); stored.Status != "rejected" {
+		t.Errorf("stored status = %s, want rejected", stored.Status)
+	}
+}
+
+func TestRecommendationHandler_Apply_RollbackFails(t *testing.T) {
+	h, rules, _ := newApplyTestHandler()
+	rules.failRestore = true
+
+	rec, _ := h.store.GetRecommendation("rec-requests")
+	h.store.DeleteRecommendation("rec-requests")
+
+	_, err := h.apply(&rec)
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) || applyErr.RolledBack || applyErr.RollbackError == "" {
+		t.Fatalf("apply() error = %+v, want a failure that was not rolled back", applyErr)
+	}
+	if _, exists := rules.rules["autogen-requests"]; !exists {
+		t.Error("rule should be left in place when the rollback fails")
+	}
+}
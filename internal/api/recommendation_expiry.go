@@ -0,0 +1,98 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// expiryCheckInterval is how often pending recommendations are checked for expiry
+const expiryCheckInterval = 10 * time.Minute
+
+// recommendationExpiry expires pending recommendations in the background
+type recommendationExpiry struct {
+	maxAge time.Duration
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// expireStaleRecommendations marks pending recommendations the engine has not
+// suggested again within the expiry period as expired, and returns how many
+func (h *RecommendationHandler) expireStaleRecommendations(now time.Time) int {
+	expired := 0
+	for _, rec := range h.store.GetAllRecommendations() {
+		if !rec.Stale(now, h.expiry.maxAge) {
+			continue
+		}
+		rec.Status = "expired"
+		rec.ExpiredAt = &now
+		// A recommendation applied or regenerated meanwhile is left alone
+		if err := h.store.UpdateRecommendationIf(rec, "pending"); err != nil {
+			continue
+		}
+		expired++
+	}
+
+	if expired > 0 {
+		logger.LogInfoWithFields("Expired stale recommendations", logger.Fields{
+			"expired": expired,
+			"max_age": h.expiry.maxAge.String(),
+		})
+	}
+	return expired
+}
+
+// startExpiry periodically expires stale recommendations until stopExpiry
+func (h *RecommendationHandler) startExpiry() {
+	if h.expiry.maxAge <= 0 {
+		return
+	}
+	h.expiry.stopCh = make(chan struct{})
+	h.expiry.wg.Add(1)
+	go func() {
+		defer h.expiry.wg.Done()
+
+		ticker := time.NewTicker(expiryCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			h.expireStaleRecommendations(time.Now())
+
+			select {
+			case <-h.expiry.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopExpiry stops expiring recommendations and waits for a running check
+func (h *RecommendationHandler) stopExpiry() {
+	if h.expiry.stopCh == nil {
+		return
+	}
+	close(h.expiry.stopCh)
+	h.expiry.wg.Wait()
+	h.expiry.stopCh = nil
+}
+
+// refreshOnAccess recomputes the estimated impact of pending recommendations
+// about to be returned, when regenerate_on_access is enabled
+func (h *RecommendationHandler) refreshOnAccess(recs []models.Recommendation) {
+	if !h.regenerateOnAccess {
+		return
+	}
+	now := time.Now()
+	for i := range recs {
+		rec := recs[i]
+		if !h.recommendationEngine.RefreshImpact(&rec, now) {
+			continue
+		}
+		if err := h.store.UpdateRecommendationIf(rec, "pending"); err == nil {
+			recs[i] = rec
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestRecommendationHandler_ExpireStaleRecommendations(t *testing.T) {
+	now := time.Now()
+	store := NewRecommendationStore()
+	store.AddRecommendation(models.Recommendation{ID: "old", Status: "pending", CreatedAt: now.Add(-15 * 24 * time.Hour)})
+	store.AddRecommendation(models.Recommendation{ID: "recent", Status: "pending", CreatedAt: now.Add(-time.Hour)})
+	store.AddRecommendation(models.Recommendation{ID: "applied", Status: "applied", CreatedAt: now.Add(-15 * 24 * time.Hour)})
+
+	tracker := metrics.NewUsageTracker(time.Hour)
+	h := NewRecommendationHandler(store, tracker, metrics.NewRecommendationEngine(tracker, 1000, 100, 0.5), nil)
+	h.expiry.maxAge = 14 * 24 * time.Hour
+
+	if got := h.expireStaleRecommendations(now); got != 1 {
+		t.Errorf("expireStaleRecommendations() = %d, want 1", got)
+	}
+	want := map[string]string{"old": "expired", "recent": "pending", "applied": "applied"}
+	for id, status := range want {
+		if rec, _ := store.GetRecommendation(id); rec.Status != status {
+			t.Errorf("%s status = %s, want %s", id, rec.Status, status)
+		}
+	}
+	if rec, _ := store.GetRecommendation("old"); rec.ExpiredAt == nil {
+		t.Error("expired recommendation should record when it expired")
+	}
+}
+
+func TestRecommendationHandler_RefreshOnAccess(t *testing.T) {
+	tracker := metrics.NewUsageTracker(time.Hour)
+	for _, pod := range []string{"a", "b", "c", "d"} {
+		tracker.TrackMetric("requests_total", map[string]string{"pod": pod, "service": "api"}, 1)
+	}
+	store := NewRecommendationStore()
+	stale := &models.EstimatedImpact{AffectedSeries: 1000}
+	store.AddRecommendation(models.Recommendation{
+		ID:              "rec",
+		Status:          "pending",
+		EstimatedImpact: stale,
+		Rule: models.Rule{
+			Matcher:     models.MetricMatcher{MetricNames: []string{"requests_total"}},
+			Aggregation: models.AggregationConfig{Type: "sum", Segmentation: []string{"service"}},
+		},
+	})
+	h := NewRecommendationHandler(store, tracker, metrics.NewRecommendationEngine(tracker, 1000, 100, 0.5), nil)
+
+	recs := store.GetAllRecommendations()
+	h.refreshOnAccess(recs)
+	if recs[0].EstimatedImpact != stale {
+		t.Error("impact should not be refreshed unless regenerate_on_access is enabled")
+	}
+
+	h.regenerateOnAccess = true
+	h.refreshOnAccess(recs)
+	if recs[0].EstimatedImpact.AffectedSeries != 4 || recs[0].ImpactRefreshedAt == nil {
+		t.Errorf("refreshed impact = %+v, want the 4 current series", recs[0].EstimatedImpact)
+	}
+	if stored, _ := store.GetRecommendation("rec"); stored.EstimatedImpact.AffectedSeries != 4 {
+		t.Error("refreshed impact should be stored")
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// Labels recommendations can be grouped by
+const (
+	GroupByService   = "service"
+	GroupByNamespace = "namespace"
+	GroupByJob       = "job"
+)
+
+// RecommendationGroup is the combined impact of the recommendations whose
+// metrics share a service, namespace or job
+type RecommendationGroup struct {
+	Label           string   `json:"label"`
+	Value           string   `json:"value"`
+	Recommendations []string `json:"recommendations"` // IDs, highest savings first
+	AffectedSeries  int      `json:"affected_series"`
+	// SeriesSaved is the estimated series reduction of applying all of them
+	SeriesSaved       int     `json:"series_saved"`
+	SavingsPercentage float64 `json:"savings_percentage"`
+}
+
+// groupRecommendations groups recommendations by the value a label has on the
+// series of their metric. Recommendations whose series disagree on the value,
+// or lack the label, are grouped as unassigned.
+func groupRecommendations(recommendations []models.Recommendation, tracker *metrics.UsageTracker, label string) []RecommendationGroup {
+	sort.Slice(recommendations, func(i, j int) bool {
+		return seriesSaved(recommendations[i]) > seriesSaved(recommendations[j])
+	})
+
+	groups := make(map[string]*RecommendationGroup)
+	for i := range recommendations {
+		rec := &recommendations[i]
+		value := recommendationGroup(rec, tracker, label)
+		group, exists := groups[value]
+		if !exists {
+			group = &RecommendationGroup{Label: label, Value: value}
+			groups[value] = group
+		}
+
+		group.Recommendations = append(group.Recommendations, rec.ID)
+		group.SeriesSaved += seriesSaved(*rec)
+		if rec.EstimatedImpact != nil {
+			group.AffectedSeries += rec.EstimatedImpact.AffectedSeries
+		}
+	}
+
+	result := make([]RecommendationGroup, 0, len(groups))
+	for _, group := range groups {
+		if group.AffectedSeries > 0 {
+			group.SavingsPercentage = float64(group.SeriesSaved) / float64(group.AffectedSeries) * 100.0
+		}
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].SeriesSaved != result[j].SeriesSaved {
+			return result[i].SeriesSaved > result[j].SeriesSaved
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+// recommendationGroup returns the group of a recommendation for a label
+func recommendationGroup(rec *models.Recommendation, tracker *metrics.UsageTracker, label string) string {
+	if value := ruleLabelValue(tracker, &rec.Rule, label); value != "" {
+		return value
+	}
+	return unassigned
+}
+
+// seriesSaved returns the estimated series reduction of a recommendation
+func seriesSaved(rec models.Recommendation) int {
+	if rec.EstimatedImpact == nil {
+		return 0
+	}
+	impact := rec.EstimatedImpact
+	return int(math.Round(float64(impact.AffectedSeries) * impact.SavingsPercentage / 100.0))
+}
+
+// groupByParameter returns the label of the 'by' query parameter
+func groupByParameter(r *http.Request) (string, bool) {
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "":
+		return GroupByJob, true
+	case GroupByService, GroupByNamespace, GroupByJob:
+		return by, true
+	}
+	return "", false
+}
+
+// ListRecommendationGroups returns the recommendations grouped by service,
+// namespace or job with their combined savings. Query parameters: by
+// (service, namespace, job; default job) and status (default pending, "all"
+// for every status).
+func (h *RecommendationHandler) ListRecommendationGroups(w http.ResponseWriter, r *http.Request) {
+	by, ok := groupByParameter(r)
+	if !ok {
+		http.Error(w, "Invalid 'by' parameter: must be one of service, namespace, job", http.StatusBadRequest)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+
+	var recommendations []models.Recommendation
+	for _, rec := range h.store.GetAllRecommendations() {
+		if status == "all" || rec.Status == status {
+			recommendations = append(recommendations, rec)
+		}
+	}
+
+	groups := groupRecommendations(recommendations, h.usageTracker, by)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"by":     by,
+		"groups": groups,
+		"total":  len(groups),
+	})
+}
+
+// ApplyRecommendationGroup applies every pending recommendation of a group.
+// The by query parameter selects the grouping label as when listing groups.
+func (h *RecommendationHandler) ApplyRecommendationGroup(w http.ResponseWriter, r *http.Request) {
+	by, ok := groupByParameter(r)
+	if !ok {
+		http.Error(w, "Invalid 'by' parameter: must be one of service, namespace, job", http.StatusBadRequest)
+		return
+	}
+	value := mux.Vars(r)["value"]
+	mode, err := h.monitorMode(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var pending []models.Recommendation
+	for _, rec := range h.store.GetAllRecommendations() {
+		if rec.Status == "pending" && recommendationGroup(&rec, h.usageTracker, by) == value {
+			pending = append(pending, rec)
+		}
+	}
+	if len(pending) == 0 {
+		http.Error(w, "No pending recommendations in group", http.StatusNotFound)
+		return
+	}
+
+	applied := make([]models.Recommendation, 0, len(pending))
+	failed := make(map[string]string)
+	monitors := make(map[string]*appliedMonitor)
+	for _, rec := range pending {
+		rule, err := h.apply(&rec)
+		if err != nil {
+			failed[rec.ID] = err.Error()
+			continue
+		}
+		applied = append(applied, rec)
+		if monitor := h.generateMonitor(&rule, mode); monitor != nil {
+			monitors[rec.ID] = monitor
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"by":       by,
+		"value":    value,
+		"applied":  applied,
+		"failed":   failed,
+		"monitors": monitors,
+		"total":    len(applied),
+	})
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestGroupRecommendations(t *testing.T) {
+	tracker := metrics.NewUsageTracker(time.Hour)
+	for i := 0; i < 10; i++ {
+		tracker.TrackMetric("api_requests_total", map[string]string{"job": "api", "id": fmt.Sprintf("%d", i)}, 1)
+		tracker.TrackMetric("api_errors_total", map[string]string{"job": "api", "id": fmt.Sprintf("%d", i)}, 1)
+		tracker.TrackMetric("mixed_requests_total", map[string]string{"job": fmt.Sprintf("job%d", i%2)}, 1)
+	}
+
+	recommendation := func(id, metric string, series int, savings float64) models.Recommendation {
+		return models.Recommendation{
+			ID:              id,
+			Rule:            models.Rule{Matcher: models.MetricMatcher{MetricNames: []string{metric}}},
+			EstimatedImpact: &models.EstimatedImpact{AffectedSeries: series, SavingsPercentage: savings},
+		}
+	}
+	recommendations := []models.Recommendation{
+		recommendation("requests", "api_requests_total", 100, 50),
+		recommendation("errors", "api_errors_total", 100, 90),
+		recommendation("mixed", "mixed_requests_total", 1000, 10),
+	}
+
+	groups := groupRecommendations(recommendations, tracker, GroupByJob)
+	if len(groups) != 2 {
+		t.Fatalf("groupRecommendations() returned %d groups, want 2: %+v", len(groups), groups)
+	}
+
+	api := groups[0]
+	if api.Value != "api" || api.SeriesSaved != 140 || api.AffectedSeries != 200 || api.SavingsPercentage != 70 {
+		t.Errorf("api group = %+v, want 140 of 200 series saved", api)
+	}
+	if len(api.Recommendations) != 2 || api.Recommendations[0] != "errors" {
+		t.Errorf("api group recommendations = %v, want errors first", api.Recommendations)
+	}
+	if groups[1].Value != unassigned || groups[1].SeriesSaved != 100 {
+		t.Errorf("second group = %+v, want the unassigned mixed recommendation", groups[1])
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/kubernetes"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// Ways the monitors of applied recommendations are generated
+const (
+	monitorOnApplyNone    = "none"
+	monitorOnApplyFile    = "file"
+	monitorOnApplyCluster = "cluster"
+)
+
+// validMonitorOnApply reports whether a monitor generation mode is known
+func validMonitorOnApply(mode string) bool {
+	switch mode {
+	case "", monitorOnApplyNone, monitorOnApplyFile, monitorOnApplyCluster:
+		return true
+	}
+	return false
+}
+
+// appliedMonitor reports the monitors generated for an applied recommendation
+type appliedMonitor struct {
+	Mode      string                      `json:"mode"`
+	FilePath  string                      `json:"file_path,omitempty"`
+	Resources []kubernetes.AppliedMonitor `json:"resources,omitempty"`
+	Error     string                      `json:"error,omitempty"`
+}
+
+// monitorMode returns the monitor generation mode of an apply request: the
+// monitor parameter, or kubernetes.monitor_on_apply
+func (h *RecommendationHandler) monitorMode(r *http.Request) (string, error) {
+	mode := r.URL.Query().Get("monitor")
+	if mode == "" {
+		mode = h.kubernetes.MonitorOnApply
+	}
+	if !validMonitorOnApply(mode) {
+		return "", fmt.Errorf("invalid 'monitor' parameter: must be one of none, file, cluster")
+	}
+	return mode, nil
+}
+
+// generateMonitor generates the monitors of the rule of an applied
+// recommendation. It returns nil when there is nothing to generate. A failure
+// is reported without undoing the apply, as the monitors can be generated
+// again from the rule.
+func (h *RecommendationHandler) generateMonitor(rule *models.Rule, mode string) *appliedMonitor {
+	if mode == "" || mode == monitorOnApplyNone || rule.OutputKubernetes == nil || !rule.OutputKubernetes.Enabled {
+		return nil
+	}
+
+	result := &appliedMonitor{Mode: mode}
+	var err error
+	switch mode {
+	case monitorOnApplyFile:
+		result.FilePath, err = kubernetes.WriteMonitorFile(rule, h.kubernetes.MonitorsDir, h.kubernetes.ExistingMonitorsDir)
+	case monitorOnApplyCluster:
+		applier := h.monitorApplier
+		if applier == nil {
+			applier, err = kubernetes.NewInClusterClient()
+		}
+		if err == nil {
+			result.Resources, err = kubernetes.ApplyMonitors(rule, applier)
+		}
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		logger.LogWarnWithFields("Failed to generate Kubernetes monitor of applied recommendation", logger.Fields{
+			"rule_id": rule.ID,
+			"mode":    mode,
+			"error":   err.Error(),
+		})
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestRecommendationHandler_GenerateMonitor(t *testing.T) {
+	h := &RecommendationHandler{kubernetes: config.KubernetesConfig{MonitorsDir: t.TempDir(), MonitorOnApply: monitorOnApplyFile}}
+	rule := &models.Rule{
+		ID:     "rule",
+		Output: models.OutputConfig{MetricName: "http_requests_aggregated"},
+		OutputKubernetes: &models.KubernetesOutputConfig{
+			Enabled:      true,
+			ResourceType: "ServiceMonitor",
+			Namespace:    "monitoring",
+		},
+	}
+
+	mode, err := h.monitorMode(httptest.NewRequest("POST", "/recommendations/rec/apply", nil))
+	if err != nil || mode != monitorOnApplyFile {
+		t.Fatalf("monitorMode() = %q, %v, want the configured mode", mode, err)
+	}
+	monitor := h.generateMonitor(rule, mode)
+	if monitor == nil || monitor.Error != "" || monitor.FilePath == "" {
+		t.Fatalf("generateMonitor() = %+v, want a file", monitor)
+	}
+	if _, err := os.Stat(monitor.FilePath); err != nil {
+		t.Errorf("monitor file: %v", err)
+	}
+
+	if _, err := h.monitorMode(httptest.NewRequest("POST", "/recommendations/rec/apply?monitor=helm", nil)); err == nil {
+		t.Error("monitorMode() with an unknown mode should fail")
+	}
+	if monitor := h.generateMonitor(&models.Rule{ID: "plain"}, mode); monitor != nil {
+		t.Errorf("generateMonitor() of a rule without Kubernetes output = %+v, want nil", monitor)
+	}
+}
@@ -2,13 +2,16 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/config"
 	"github.com/marcotuna/adaptive-metrics/internal/metrics"
 	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/kubernetes"
 	"github.com/marcotuna/adaptive-metrics/pkg/logger"
 )
 
@@ -32,6 +35,23 @@ func (rs *RecommendationStore) AddRecommendation(rec models.Recommendation) {
 	rs.recommendations[rec.ID] = rec
 }
 
+// MergeRecommendation adds a generated recommendation, or merges it into the
+// stored recommendation with the same ID. It returns the stored result and
+// whether it was added.
+func (rs *RecommendationStore) MergeRecommendation(rec models.Recommendation, now time.Time) (models.Recommendation, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	existing, exists := rs.recommendations[rec.ID]
+	if !exists {
+		rs.recommendations[rec.ID] = rec
+		return rec, true
+	}
+	existing.Merge(rec, now)
+	rs.recommendations[rec.ID] = existing
+	return existing, false
+}
+
 // GetRecommendation retrieves a recommendation by ID
 func (rs *RecommendationStore) GetRecommendation(id string) (models.Recommendation, bool) {
 	rs.mu.RLock()
@@ -52,6 +72,24 @@ func (rs *RecommendationStore) GetAllRecommendations() []models.Recommendation {
 	return recs
 }
 
+// UpdateRecommendationIf updates a recommendation only if its stored status
+// is still the given one, so that concurrent changes are not overwritten
+func (rs *RecommendationStore) UpdateRecommendationIf(rec models.Recommendation, status string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	existing, exists := rs.recommendations[rec.ID]
+	if !exists {
+		return fmt.Errorf("recommendation %s no longer exists", rec.ID)
+	}
+	if existing.Status != status {
+		return fmt.Errorf("recommendation %s changed to %s meanwhile", rec.ID, existing.Status)
+	}
+
+	rs.recommendations[rec.ID] = rec
+	return nil
+}
+
 // UpdateRecommendation updates an existing recommendation
 func (rs *RecommendationStore) UpdateRecommendation(rec models.Recommendation) bool {
 	rs.mu.Lock()
@@ -85,11 +123,17 @@ type RecommendationHandler struct {
 	recommendationEngine *metrics.RecommendationEngine
 	ruleStore            RuleStore
 	processor            ProcessorInterface // For registering recommendation rules
+	ownership            *ownershipAssigner // Infers the team and namespace of applied rules
+	kubernetes           config.KubernetesConfig
+	monitorApplier       kubernetes.MonitorApplier // Applies monitors; the in-cluster client when nil
+	expiry               recommendationExpiry
+	regenerateOnAccess   bool
 }
 
 // ProcessorInterface defines the interface required for the processor
 type ProcessorInterface interface {
 	RegisterRecommendationRule(ruleID string)
+	UnregisterRecommendationRule(ruleID string)
 }
 
 // NewRecommendationHandler creates a new recommendation handler
@@ -108,9 +152,32 @@ func (h *RecommendationHandler) SetProcessor(processor ProcessorInterface) {
 	h.processor = processor
 }
 
+// verifyAppliedRecommendations refreshes the measured impact of applied
+// recommendations whose verification window is still open
+func (h *RecommendationHandler) verifyAppliedRecommendations() {
+	now := time.Now()
+	for _, rec := range h.store.GetAllRecommendations() {
+		if rec.Status != "applied" || !h.recommendationEngine.MeasureImpact(&rec, now) {
+			continue
+		}
+		h.store.UpdateRecommendation(rec)
+
+		if rec.MeasuredImpact.Complete && rec.MeasuredImpact.Diverged {
+			logger.LogWarnWithFields("Applied recommendation diverged from its estimated impact", logger.Fields{
+				"recommendation_id":  rec.ID,
+				"rule_id":            rec.Rule.ID,
+				"measured_reduction": rec.MeasuredImpact.CardinalityReduction,
+				"accuracy":           rec.MeasuredImpact.Accuracy,
+			})
+		}
+	}
+}
+
 // ListRecommendations returns all metric aggregation recommendations
 func (h *RecommendationHandler) ListRecommendations(w http.ResponseWriter, r *http.Request) {
+	h.verifyAppliedRecommendations()
 	recommendations := h.store.GetAllRecommendations()
+	h.refreshOnAccess(recommendations)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -124,11 +191,15 @@ func (h *RecommendationHandler) GetRecommendation(w http.ResponseWriter, r *http
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	h.verifyAppliedRecommendations()
 	recommendation, exists := h.store.GetRecommendation(id)
 	if !exists {
 		http.Error(w, "Recommendation not found", http.StatusNotFound)
 		return
 	}
+	recs := []models.Recommendation{recommendation}
+	h.refreshOnAccess(recs)
+	recommendation = recs[0]
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(recommendation)
@@ -144,35 +215,30 @@ func (h *RecommendationHandler) ApplyRecommendation(w http.ResponseWriter, r *ht
 		http.Error(w, "Recommendation not found", http.StatusNotFound)
 		return
 	}
-
-	// Update recommendation status
-	recommendation.Status = "applied"
-	h.store.UpdateRecommendation(recommendation)
-
-	// Create rule from recommendation
-	rule := recommendation.Rule
-	rule.RecommendationID = recommendation.ID
-	rule.Enabled = true // Enable the rule when applying a recommendation
-
-	// Add the rule to the rule store
-	err := h.ruleStore.AddRule(rule)
+	mode, err := h.monitorMode(r)
 	if err != nil {
-		http.Error(w, "Failed to create rule: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Register rule as coming from a recommendation for remote write filtering
-	if h.processor != nil {
-		h.processor.RegisterRecommendationRule(rule.ID)
+	rule, err := h.apply(&recommendation)
+	if err != nil {
+		writeApplyError(w, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"status":         "success",
 		"message":        "Recommendation applied successfully",
 		"recommendation": recommendation,
 		"rule":           rule,
-	})
+	}
+	if monitor := h.generateMonitor(&rule, mode); monitor != nil {
+		response["kubernetes_monitor"] = monitor
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 // RejectRecommendation marks a recommendation as rejected
@@ -200,12 +266,22 @@ func (h *RecommendationHandler) RejectRecommendation(w http.ResponseWriter, r *h
 
 // GenerateRecommendations triggers the recommendation engine to generate new recommendations
 func (h *RecommendationHandler) GenerateRecommendations(w http.ResponseWriter, r *http.Request) {
+	// Feed completed verifications into confidence calibration first
+	h.verifyAppliedRecommendations()
+
 	// Generate recommendations using the engine
 	recommendations := h.recommendationEngine.GenerateRecommendations()
 
-	// Store the generated recommendations
-	for _, rec := range recommendations {
-		h.store.AddRecommendation(rec)
+	// Store the generated recommendations; suggestions repeated from earlier
+	// runs are merged into their existing record
+	now := time.Now()
+	created := 0
+	for i, rec := range recommendations {
+		var added bool
+		recommendations[i], added = h.store.MergeRecommendation(rec, now)
+		if added {
+			created++
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -214,35 +290,73 @@ func (h *RecommendationHandler) GenerateRecommendations(w http.ResponseWriter, r
 		"message":         "Recommendation generation completed",
 		"recommendations": recommendations,
 		"total":           len(recommendations),
+		"created":         created,
+		"merged":          len(recommendations) - created,
 	})
 }
 
-// ListMetricsUsage returns usage information for all tracked metrics
-func (h *RecommendationHandler) ListMetricsUsage(w http.ResponseWriter, r *http.Request) {
-	// Get metrics usage information from the tracker
-	metricsInfo := h.usageTracker.GetAllMetricsInfo()
+// ListRecommendationStrategies returns the enabled recommendation strategies
+// and the ones compiled into the binary
+func (h *RecommendationHandler) ListRecommendationStrategies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":    h.recommendationEngine.Strategies(),
+		"registered": metrics.RegisteredStrategies(),
+	})
+}
 
-	// Log the metrics info count for debugging
-	infoCount := len(metricsInfo)
+// GetRecommendationSettings returns the current recommendation engine thresholds
+func (h *RecommendationHandler) GetRecommendationSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.recommendationEngine.Settings())
+}
 
-	// Convert to a slice for better JSON serialization
-	metricsInfoSlice := make([]MetricUsageInfoResponse, 0, infoCount)
-	for _, info := range metricsInfo {
-		metricsInfoSlice = append(metricsInfoSlice, convertToMetricUsageInfoResponse(info))
+// UpdateRecommendationSettings changes the recommendation engine thresholds at
+// runtime. Fields missing from the request keep their current value.
+func (h *RecommendationHandler) UpdateRecommendationSettings(w http.ResponseWriter, r *http.Request) {
+	settings := h.recommendationEngine.Settings()
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	// Include a debug message in the response when empty
-	response := map[string]interface{}{
-		"metrics": metricsInfoSlice,
-		"total":   infoCount,
+	if err := h.recommendationEngine.UpdateSettings(settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Add debug information if no metrics are found
-	if infoCount == 0 {
-		response["debug_info"] = map[string]interface{}{
-			"tracker_initialized": h.usageTracker != nil,
-			"timestamp":           time.Now(),
-			"message":             "No metrics found in usage tracker. This could indicate that metrics are not being properly tracked or that the tracker instance is not shared correctly.",
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// ListMetricsUsage returns usage information for all tracked metrics, streamed
+// as JSON or NDJSON. Query parameters: format (json, ndjson), prefix,
+// min_cardinality and fields (comma separated).
+func (h *RecommendationHandler) ListMetricsUsage(w http.ResponseWriter, r *http.Request) {
+	listing, err := parseUsageListing(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if listing.ndjson {
+		count := h.writeMetricsUsageNDJSON(w, listing)
+		logger.LogDebugWithFields("Metrics usage data retrieved", logger.Fields{
+			"count":    count,
+			"endpoint": "ListMetricsUsage",
+		})
+		return
+	}
+
+	// Include a debug message in the response when the tracker is empty
+	var extra map[string]interface{}
+	if len(h.usageTracker.MetricNames()) == 0 {
+		extra = map[string]interface{}{
+			"debug_info": map[string]interface{}{
+				"tracker_initialized": h.usageTracker != nil,
+				"timestamp":           time.Now(),
+				"message":             "No metrics found in usage tracker. This could indicate that metrics are not being properly tracked or that the tracker instance is not shared correctly.",
+			},
 		}
 
 		// Log this situation with proper structured logging
@@ -252,16 +366,16 @@ func (h *RecommendationHandler) ListMetricsUsage(w http.ResponseWriter, r *http.
 			"method":              r.Method,
 			"path":                r.URL.Path,
 		})
-	} else {
+	}
+
+	count := h.writeMetricsUsageJSON(w, listing, extra)
+	if extra == nil {
 		// Log successful metrics retrieval with count
 		logger.LogDebugWithFields("Metrics usage data retrieved", logger.Fields{
-			"count":    infoCount,
+			"count":    count,
 			"endpoint": "ListMetricsUsage",
 		})
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
 }
 
 // GetMetricUsage returns usage information for a specific metric
@@ -291,6 +405,11 @@ type MetricUsageInfoResponse struct {
 	MaxValue         float64        `json:"max_value"`
 	SumValue         float64        `json:"sum_value"`
 	AvgValue         float64        `json:"avg_value"`
+	// Type is counter, gauge, histogram, summary or unknown; TypeSource is
+	// metadata, name or values
+	Type       string `json:"type"`
+	TypeSource string `json:"type_source,omitempty"`
+	Unit       string `json:"unit,omitempty"`
 }
 
 // Convert internal MetricUsageInfo to response format
@@ -311,5 +430,8 @@ func convertToMetricUsageInfoResponse(info *metrics.MetricUsageInfo) MetricUsage
 		MaxValue:         info.MaxValue,
 		SumValue:         info.SumValue,
 		AvgValue:         avgValue,
+		Type:             info.Type,
+		TypeSource:       info.TypeSource,
+		Unit:             info.Unit,
 	}
 }
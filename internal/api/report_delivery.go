@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// savingsReportHTML renders a savings report as an HTML page, for email
+var savingsReportHTML = template.Must(template.New("savings").Funcs(template.FuncMap{
+	"series": func(v float64) string { return strconv.FormatFloat(v, 'f', 0, 64) },
+	"money":  func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) },
+	"pct":    func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) + "%" },
+	"date":   func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Metrics savings report</title></head>
+<body style="font-family: sans-serif">
+<h1>Metrics savings report{{if .Schedule}}: {{.Schedule}}{{end}}</h1>
+<p>{{date .From}} to {{date .To}}</p>
+<p><strong>{{series .Total.SeriesSaved}}</strong> of {{series .Total.InputSeries}} series saved ({{pct .Total.SavingsPercentage}}) by {{.Total.Rules}} rules,
+{{.Total.DroppedSamples}} samples dropped{{if .Total.EstimatedSavings}}, an estimated {{money .Total.EstimatedSavings}} {{.Currency}}{{end}}.</p>
+{{range .Sections}}
+<h2>By {{.Title}}</h2>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>{{.Title}}</th><th>Rules</th><th>Input series</th><th>Output series</th><th>Series saved</th><th>Saved</th><th>Dropped samples</th><th>Estimated savings</th></tr>
+{{range .Lines}}<tr><td>{{.Key}}{{if .Name}} ({{.Name}}){{end}}</td><td>{{.Rules}}</td><td>{{series .InputSeries}}</td><td>{{series .OutputSeries}}</td><td>{{series .SeriesSaved}}</td><td>{{pct .SavingsPercentage}}</td><td>{{.DroppedSamples}}</td><td>{{money .EstimatedSavings}} {{$.Currency}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// reportSection is a breakdown of a report rendered as a table
+type reportSection struct {
+	Title string
+	Lines []SavingsLine
+}
+
+// renderReportHTML renders a report as an HTML page
+func renderReportHTML(w io.Writer, report *ScheduledReport) error {
+	return savingsReportHTML.Execute(w, struct {
+		*ScheduledReport
+		Sections []reportSection
+	}{
+		ScheduledReport: report,
+		Sections: []reportSection{
+			{Title: "tenant", Lines: report.ByTenant},
+			{Title: "job", Lines: report.ByJob},
+			{Title: "rule", Lines: report.ByRule},
+		},
+	})
+}
+
+// postReport sends a report as JSON to a webhook
+func (s *reportScheduler) postReport(url string, report *ScheduledReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// mailReport emails a report as HTML, with the JSON attached
+func (s *reportScheduler) mailReport(to []string, report *ScheduledReport) error {
+	msg, err := reportMessage(s.smtp.From, to, report)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.smtp.Username != "" {
+		auth = smtp.PlainAuth("", s.smtp.Username, s.smtp.Password, s.smtp.Host)
+	}
+	addr := net.JoinHostPort(s.smtp.Host, strconv.Itoa(s.smtp.Port))
+	if err := s.sendMail(addr, auth, s.smtp.From, to, msg); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	return nil
+}
+
+// reportMessage builds the MIME message of an emailed report
+func reportMessage(from string, to []string, report *ScheduledReport) ([]byte, error) {
+	var html bytes.Buffer
+	if err := renderReportHTML(&html, report); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	part, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64(part, html.Bytes())
+
+	part, err = parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"application/json"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf(`attachment; filename="savings-report-%s.json"`, report.To.UTC().Format("2006-01-02"))},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64(part, data)
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: Metrics savings report %s (%s to %s)\r\n", report.Schedule,
+		report.From.UTC().Format("2006-01-02"), report.To.UTC().Format("2006-01-02"))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", parts.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
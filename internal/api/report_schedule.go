@@ -0,0 +1,235 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// Periods of scheduled reports
+const (
+	ReportPeriodWeekly  = "weekly"
+	ReportPeriodMonthly = "monthly"
+)
+
+// reportCheckInterval is how often the scheduler checks whether a period ended
+const reportCheckInterval = time.Minute
+
+// reportDeliveryTimeout bounds the delivery of a report to a webhook
+const reportDeliveryTimeout = 30 * time.Second
+
+// ScheduledReport is a savings report delivered for a schedule
+type ScheduledReport struct {
+	Schedule string `json:"schedule"`
+	Period   string `json:"period"`
+	SavingsReport
+}
+
+// reportScheduler renders the savings reports of schedules at the start of
+// every period and delivers them
+type reportScheduler struct {
+	schedules []config.ReportScheduleConfig
+	smtp      config.SMTPConfig
+	client    *http.Client
+	// sendMail is smtp.SendMail, replaced in tests
+	sendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+
+	mu     sync.Mutex
+	sent   map[string]time.Time // Start of the period each schedule was last sent at
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newReportScheduler validates the schedules of the reports config and
+// creates their scheduler
+func newReportScheduler(cfg config.ReportsConfig) (*reportScheduler, error) {
+	names := make(map[string]bool)
+	for _, schedule := range cfg.Schedules {
+		switch {
+		case schedule.Name == "":
+			return nil, fmt.Errorf("report schedule name is required")
+		case names[schedule.Name]:
+			return nil, fmt.Errorf("duplicate report schedule %s", schedule.Name)
+		case schedule.Period != ReportPeriodWeekly && schedule.Period != ReportPeriodMonthly:
+			return nil, fmt.Errorf("report schedule %s: invalid period %q: must be %s or %s", schedule.Name, schedule.Period, ReportPeriodWeekly, ReportPeriodMonthly)
+		case schedule.WebhookURL == "" && len(schedule.Emails) == 0:
+			return nil, fmt.Errorf("report schedule %s: a webhook_url or emails are required", schedule.Name)
+		case len(schedule.Emails) > 0 && (cfg.SMTP.Host == "" || cfg.SMTP.From == ""):
+			return nil, fmt.Errorf("report schedule %s: emails require reports.smtp.host and reports.smtp.from", schedule.Name)
+		}
+		names[schedule.Name] = true
+	}
+
+	return &reportScheduler{
+		schedules: cfg.Schedules,
+		smtp:      cfg.SMTP,
+		client:    &http.Client{Timeout: reportDeliveryTimeout},
+		sendMail:  smtp.SendMail,
+		sent:      make(map[string]time.Time),
+	}, nil
+}
+
+// schedule returns the schedule with the given name
+func (s *reportScheduler) schedule(name string) (config.ReportScheduleConfig, bool) {
+	for _, schedule := range s.schedules {
+		if schedule.Name == name {
+			return schedule, true
+		}
+	}
+	return config.ReportScheduleConfig{}, false
+}
+
+// periodStart returns the start of the period containing t: Monday or the
+// 1st of the month, at midnight UTC
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	if period == ReportPeriodMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// previousPeriodStart returns the start of the period before the one starting at start
+func previousPeriodStart(period string, start time.Time) time.Time {
+	if period == ReportPeriodMonthly {
+		return start.AddDate(0, -1, 0)
+	}
+	return start.AddDate(0, 0, -7)
+}
+
+// startReports delivers the reports of schedules at the start of every
+// period until stopReports. Periods that start while the server is down are
+// not delivered.
+func (h *Handler) startReports() {
+	s := h.reports
+	if s == nil || len(s.schedules) == 0 {
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for _, schedule := range s.schedules {
+		s.sent[schedule.Name] = periodStart(schedule.Period, now)
+	}
+	s.mu.Unlock()
+
+	s.stopCh = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(reportCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopCh:
+				return
+			case now := <-ticker.C:
+				h.sendDueReports(now)
+			}
+		}
+	}()
+}
+
+// stopReports stops delivering reports and waits for a running delivery
+func (h *Handler) stopReports() {
+	s := h.reports
+	if s == nil || s.stopCh == nil {
+		return
+	}
+	close(s.stopCh)
+	s.wg.Wait()
+	s.stopCh = nil
+}
+
+// sendDueReports delivers the reports of the schedules whose period ended
+// since they were last sent. A failed delivery is logged and not retried.
+func (h *Handler) sendDueReports(now time.Time) {
+	s := h.reports
+	for _, schedule := range s.schedules {
+		start := periodStart(schedule.Period, now)
+		s.mu.Lock()
+		due := s.sent[schedule.Name].Before(start)
+		if due {
+			s.sent[schedule.Name] = start
+		}
+		s.mu.Unlock()
+		if !due {
+			continue
+		}
+
+		if _, err := h.sendReport(schedule, previousPeriodStart(schedule.Period, start), start); err != nil {
+			logger.LogErrorWithFields("Failed to deliver scheduled report", logger.Fields{
+				"schedule": schedule.Name,
+				"error":    err.Error(),
+			})
+			continue
+		}
+		logger.LogInfoWithFields("Delivered scheduled report", logger.Fields{
+			"schedule": schedule.Name,
+			"period":   schedule.Period,
+		})
+	}
+}
+
+// sendReport renders the savings report of a schedule for the period from
+// start to end, which ends now, and delivers it to the webhook and emails of
+// the schedule
+func (h *Handler) sendReport(schedule config.ReportScheduleConfig, start, end time.Time) (*ScheduledReport, error) {
+	rules, err := h.ruleEngine.GetRules()
+	if err != nil {
+		return nil, err
+	}
+
+	hours := int(end.Sub(start).Hours())
+	report := &ScheduledReport{
+		Schedule:      schedule.Name,
+		Period:        schedule.Period,
+		SavingsReport: h.savingsReport(rules, hours, end),
+	}
+
+	if schedule.WebhookURL != "" {
+		if err := h.reports.postReport(schedule.WebhookURL, report); err != nil {
+			return report, err
+		}
+	}
+	if len(schedule.Emails) > 0 {
+		if err := h.reports.mailReport(schedule.Emails, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// SendScheduledReport delivers the report of a schedule now, to check its
+// delivery. The report covers as long a period as the last complete one,
+// ending now.
+func (h *Handler) SendScheduledReport(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	schedule, exists := h.reports.schedule(name)
+	if !exists {
+		http.Error(w, "Report schedule not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	last := periodStart(schedule.Period, now)
+	length := last.Sub(previousPeriodStart(schedule.Period, last))
+	report, err := h.sendReport(schedule, now.Add(-length), now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to deliver report: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
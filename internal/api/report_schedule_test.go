@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// A Friday
+	now := time.Date(2026, 10, 16, 15, 30, 0, 0, time.UTC)
+
+	weekly := periodStart(ReportPeriodWeekly, now)
+	if want := time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC); !weekly.Equal(want) {
+		t.Errorf("weekly start = %s, want %s", weekly, want)
+	}
+	if previous := previousPeriodStart(ReportPeriodWeekly, weekly); !previous.Equal(time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("previous weekly start = %s", previous)
+	}
+	// A Monday starts its own week
+	if start := periodStart(ReportPeriodWeekly, weekly.Add(time.Minute)); !start.Equal(weekly) {
+		t.Errorf("weekly start on Monday = %s, want %s", start, weekly)
+	}
+
+	monthly := periodStart(ReportPeriodMonthly, now)
+	if want := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC); !monthly.Equal(want) {
+		t.Errorf("monthly start = %s, want %s", monthly, want)
+	}
+	if previous := previousPeriodStart(ReportPeriodMonthly, monthly); !previous.Equal(time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("previous monthly start = %s", previous)
+	}
+}
+
+func TestNewReportScheduler_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  config.ReportsConfig
+	}{
+		{"missing name", config.ReportsConfig{Schedules: []config.ReportScheduleConfig{{Period: "weekly", WebhookURL: "http://hook"}}}},
+		{"invalid period", config.ReportsConfig{Schedules: []config.ReportScheduleConfig{{Name: "a", Period: "daily", WebhookURL: "http://hook"}}}},
+		{"no destination", config.ReportsConfig{Schedules: []config.ReportScheduleConfig{{Name: "a", Period: "weekly"}}}},
+		{"emails without smtp", config.ReportsConfig{Schedules: []config.ReportScheduleConfig{{Name: "a", Period: "weekly", Emails: []string{"a@example.com"}}}}},
+		{"duplicate", config.ReportsConfig{Schedules: []config.ReportScheduleConfig{
+			{Name: "a", Period: "weekly", WebhookURL: "http://hook"},
+			{Name: "a", Period: "monthly", WebhookURL: "http://hook"},
+		}}},
+	} {
+		if _, err := newReportScheduler(tt.cfg); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestHandler_SendDueReports(t *testing.T) {
+	var received []ScheduledReport
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report ScheduledReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("decode webhook report: %v", err)
+		}
+		received = append(received, report)
+	}))
+	defer hook.Close()
+
+	cfg := &config.Config{Reports: config.ReportsConfig{
+		JobLabel: "job",
+		Currency: "USD",
+		Schedules: []config.ReportScheduleConfig{{
+			Name:       "weekly-chargeback",
+			Period:     ReportPeriodWeekly,
+			WebhookURL: hook.URL,
+			Emails:     []string{"finops@example.com"},
+		}},
+		SMTP: config.SMTPConfig{Host: "mail.example.com", Port: 587, From: "reports@example.com"},
+	}}
+	cfg.Aggregator.RulesPath = t.TempDir()
+	engine, err := rules.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	scheduler, err := newReportScheduler(cfg.Reports)
+	if err != nil {
+		t.Fatalf("newReportScheduler() error = %v", err)
+	}
+	var mails []string
+	scheduler.sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		if addr != "mail.example.com:587" || from != "reports@example.com" {
+			t.Errorf("mail sent through %s from %s", addr, from)
+		}
+		mails = append(mails, string(msg))
+		return nil
+	}
+	h := &Handler{cfg: cfg, ruleEngine: engine, usageTracker: metrics.NewUsageTracker(time.Hour), reports: scheduler}
+
+	// Sent once per period, for the week that just ended
+	monday := time.Date(2026, 10, 12, 0, 0, 30, 0, time.UTC)
+	scheduler.sent["weekly-chargeback"] = monday.AddDate(0, 0, -7)
+	h.sendDueReports(monday)
+	h.sendDueReports(monday.Add(time.Minute))
+
+	if len(received) != 1 || len(mails) != 1 {
+		t.Fatalf("delivered %d webhooks and %d emails, want 1 each", len(received), len(mails))
+	}
+	report := received[0]
+	if report.Schedule != "weekly-chargeback" || report.Hours != 7*24 || !report.To.Equal(time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("report = %s for %d hours to %s", report.Schedule, report.Hours, report.To)
+	}
+	mail := mails[0]
+	for _, want := range []string{"To: finops@example.com", "Subject: Metrics savings report weekly-chargeback (2026-10-05 to 2026-10-12)", "text/html", `filename="savings-report-2026-10-12.json"`} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("email lacks %q", want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/version"
+	"gopkg.in/yaml.v3"
+)
+
+// Modes of a rule import
+const (
+	importModeAtomic     = "atomic"
+	importModeBestEffort = "best_effort"
+)
+
+// maxImportBytes bounds the size of a rule import request
+const maxImportBytes = 10 << 20
+
+// SetupRuleBundleRoutes sets up the routes for exporting and importing rules
+// in bulk. They must be registered before the routes of single rules.
+func (h *Handler) SetupRuleBundleRoutes(router *mux.Router) {
+	router.HandleFunc("/rules/export", h.ExportRules).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rules/import", h.ImportRules).Methods("POST", "OPTIONS")
+}
+
+// ExportRules returns all rules as a single bundle with their hash, as JSON
+// or, with format=yaml, as YAML. With promotion, the rules are rewritten by
+// the mapping of that name for the destination environment.
+func (h *Handler) ExportRules(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "yaml" {
+		http.Error(w, fmt.Sprintf("invalid format %q: must be json or yaml", format), http.StatusBadRequest)
+		return
+	}
+
+	all, err := h.ruleEngine.GetRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if promotion := r.URL.Query().Get("promotion"); promotion != "" {
+		mapping, err := h.promotionMapping(promotion)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i, rule := range all {
+			if all[i], _, err = h.promoteRule(mapping, i, rule); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	now := time.Now().UTC()
+	bundle, err := models.NewRuleBundle(all, version.Get().Version, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("rules-%s.%s", now.Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "yaml" {
+		data, err := yaml.Marshal(bundle)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bundle)
+}
+
+// ImportRules creates or replaces the rules of a multi-document YAML, JSON
+// array or rule bundle body. The mode query parameter is atomic (the default), where no rule
+// is applied if any fails, or best_effort; dry_run=true only reports what
+// would happen. With promotion, the rules are rewritten by the mapping of
+// that name and checked against the usage observed here.
+func (h *Handler) ImportRules(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = importModeAtomic
+	}
+	if mode != importModeAtomic && mode != importModeBestEffort {
+		http.Error(w, fmt.Sprintf("invalid mode %q: must be %s or %s", mode, importModeAtomic, importModeBestEffort), http.StatusBadRequest)
+		return
+	}
+	dryRun := false
+	if value := query.Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "invalid dry_run: must be true or false", http.StatusBadRequest)
+			return
+		}
+		dryRun = parsed
+	}
+	var mapping *models.PromotionMapping
+	if promotion := query.Get("promotion"); promotion != "" {
+		var err error
+		if mapping, err = h.promotionMapping(promotion); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	imported, err := rules.DecodeRules(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(imported) == 0 {
+		http.Error(w, "no rules to import", http.StatusBadRequest)
+		return
+	}
+
+	author := h.author(r)
+	var promotions []PromotionResult
+	for i, rule := range imported {
+		if rule == nil {
+			http.Error(w, fmt.Sprintf("rule %d is empty", i), http.StatusBadRequest)
+			return
+		}
+		if mapping != nil {
+			promoted, result, err := h.promoteRule(mapping, i, rule)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result.Warnings = h.promotionWarnings(promoted)
+			promotions = append(promotions, result)
+			imported[i], rule = promoted, promoted
+		}
+		// Infer the team and namespace from the matched series when not set
+		h.ownership.assign(rule)
+		rule.UpdatedBy = author
+	}
+
+	results, applied := h.ruleEngine.ImportRules(imported, mode == importModeAtomic, dryRun)
+
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	if applied {
+		logger.LogInfoWithFields("Imported rules", logger.Fields{
+			"mode":    mode,
+			"created": counts[rules.ImportCreated],
+			"updated": counts[rules.ImportUpdated],
+			"failed":  counts[rules.ImportFailed],
+			"author":  author,
+		})
+	}
+
+	status := http.StatusOK
+	if mode == importModeAtomic && !dryRun && !applied {
+		status = http.StatusBadRequest
+	}
+	response := map[string]interface{}{
+		"mode":    mode,
+		"dry_run": dryRun,
+		"applied": applied,
+		"created": counts[rules.ImportCreated],
+		"updated": counts[rules.ImportUpdated],
+		"failed":  counts[rules.ImportFailed],
+		"skipped": counts[rules.ImportSkipped],
+		"results": results,
+	}
+	if mapping != nil {
+		response["promotion"] = promotions
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
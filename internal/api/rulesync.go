@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/plugin"
+)
+
+// RuleSyncHandler serves the status and conflicts of the rule sync with the
+// Grafana plugin
+type RuleSyncHandler struct {
+	sync *plugin.RuleSync
+}
+
+// NewRuleSyncHandler creates a handler for a rule sync
+func NewRuleSyncHandler(sync *plugin.RuleSync) *RuleSyncHandler {
+	return &RuleSyncHandler{sync: sync}
+}
+
+// SetupRoutes sets up the routes for the rule sync API
+func (h *RuleSyncHandler) SetupRoutes(router *mux.Router) {
+	router.HandleFunc("/plugin/rules/sync", h.GetStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/plugin/rules/sync", h.Sync).Methods("POST", "OPTIONS")
+	router.HandleFunc("/plugin/rules/sync/conflicts/{id}/resolve", h.ResolveConflict).Methods("POST", "OPTIONS")
+}
+
+// GetStatus returns the state of the rule sync and the pending conflicts
+func (h *RuleSyncHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.sync.Status())
+}
+
+// Sync runs a rule sync right away
+func (h *RuleSyncHandler) Sync(w http.ResponseWriter, r *http.Request) {
+	if err := h.sync.Sync(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.sync.Status())
+}
+
+// ResolveConflict resolves a conflict to the local or remote side of a rule
+func (h *RuleSyncHandler) ResolveConflict(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Use string `json:"use"` // local or remote
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Use != plugin.ResolveLocal && req.Use != plugin.ResolveRemote {
+		http.Error(w, `use must be "local" or "remote"`, http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if !h.hasConflict(id) {
+		http.Error(w, "Conflict not found", http.StatusNotFound)
+		return
+	}
+	if err := h.sync.Resolve(id, req.Use); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.sync.Status())
+}
+
+// hasConflict reports whether a rule has a pending conflict
+func (h *RuleSyncHandler) hasConflict(id string) bool {
+	for _, conflict := range h.sync.Status().Conflicts {
+		if conflict.RuleID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	pkgmetrics "github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// Reasons a sample timestamp is out of bounds
+const (
+	sampleTooOld = "too_old"
+	sampleTooNew = "too_new"
+)
+
+// checkSampleAge checks a sample timestamp against the configured bounds. It
+// returns the timestamp to ingest the sample with, the reason when the
+// timestamp is out of bounds, and false if the sample must be rejected.
+func checkSampleAge(cfg config.SampleAgeConfig, ts, now time.Time) (time.Time, string, bool) {
+	if cfg.MaxAgeSeconds > 0 {
+		if oldest := now.Add(-time.Duration(cfg.MaxAgeSeconds) * time.Second); ts.Before(oldest) {
+			return oldest, sampleTooOld, cfg.Clamp
+		}
+	}
+	if cfg.MaxFutureSeconds > 0 {
+		if newest := now.Add(time.Duration(cfg.MaxFutureSeconds) * time.Second); ts.After(newest) {
+			return newest, sampleTooNew, cfg.Clamp
+		}
+	}
+	return ts, "", true
+}
+
+// recordOutOfBounds records the samples of a request that were out of bounds,
+// by reason
+func recordOutOfBounds(cfg config.SampleAgeConfig, tenant string, counts map[string]int) {
+	action := "rejected"
+	if cfg.Clamp {
+		action = "clamped"
+	}
+	for reason, count := range counts {
+		pkgmetrics.RecordOutOfBoundsSamples(tenant, reason, action, count)
+		if !cfg.Clamp {
+			pkgmetrics.RecordDroppedSamples(pkgmetrics.StageIngest, reason, count)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+)
+
+func TestCheckSampleAge(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	bounds := config.SampleAgeConfig{MaxAgeSeconds: 3600, MaxFutureSeconds: 600}
+	clamped := bounds
+	clamped.Clamp = true
+
+	tests := []struct {
+		name         string
+		cfg          config.SampleAgeConfig
+		ts           time.Time
+		wantTS       time.Time
+		wantReason   string
+		wantAccepted bool
+	}{
+		{"within bounds", bounds, now.Add(-time.Minute), now.Add(-time.Minute), "", true},
+		{"unbounded", config.SampleAgeConfig{}, now.Add(-24 * time.Hour), now.Add(-24 * time.Hour), "", true},
+		{"too old", bounds, now.Add(-2 * time.Hour), now.Add(-time.Hour), sampleTooOld, false},
+		{"too new", bounds, now.Add(time.Hour), now.Add(10 * time.Minute), sampleTooNew, false},
+		{"too old clamped", clamped, now.Add(-2 * time.Hour), now.Add(-time.Hour), sampleTooOld, true},
+		{"too new clamped", clamped, now.Add(time.Hour), now.Add(10 * time.Minute), sampleTooNew, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, reason, accepted := checkSampleAge(tt.cfg, tt.ts, now)
+			if !ts.Equal(tt.wantTS) || reason != tt.wantReason || accepted != tt.wantAccepted {
+				t.Errorf("checkSampleAge() = %v, %q, %v, want %v, %q, %v", ts, reason, accepted, tt.wantTS, tt.wantReason, tt.wantAccepted)
+			}
+		})
+	}
+}
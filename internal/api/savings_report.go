@@ -0,0 +1,242 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// defaultReportHours is the window of reports when none is given: 30 days
+const defaultReportHours = 30 * 24
+
+// SavingsLine is what the rules of one tenant, job or rule saved over the
+// window of a savings report. Series are averaged over the window.
+type SavingsLine struct {
+	Key          string  `json:"key"`
+	Name         string  `json:"name,omitempty"` // Name of the rule, in the breakdown by rule
+	Rules        int     `json:"rules"`
+	InputSeries  float64 `json:"input_series"`  // Series of the metrics matched by the rules
+	OutputSeries float64 `json:"output_series"` // Series of the aggregated metrics
+	// SeriesSaved and DroppedSamples only count rules that drop their
+	// original metrics, in proportion to their rollout percentage
+	SeriesSaved       float64 `json:"series_saved"`
+	InputSamples      int64   `json:"input_samples"`
+	DroppedSamples    int64   `json:"dropped_samples"`
+	SavingsPercentage float64 `json:"savings_percentage"`
+	EstimatedSavings  float64 `json:"estimated_savings"`
+}
+
+// SavingsReport is the savings of rules over a window, in total and broken
+// down by tenant, job and rule
+type SavingsReport struct {
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	Hours    int           `json:"hours"`
+	Currency string        `json:"currency,omitempty"`
+	Total    SavingsLine   `json:"total"`
+	ByTenant []SavingsLine `json:"by_tenant"`
+	ByJob    []SavingsLine `json:"by_job"`
+	ByRule   []SavingsLine `json:"by_rule"`
+}
+
+// SetupReportRoutes sets up the routes for the reports API
+func (h *Handler) SetupReportRoutes(router *mux.Router) {
+	router.HandleFunc("/reports/savings", h.SavingsReport).Methods("GET", "OPTIONS")
+	router.HandleFunc("/reports/schedules/{name}/send", h.SendScheduledReport).Methods("POST", "OPTIONS")
+}
+
+// SavingsReport reports the input and output series, dropped samples and
+// estimated cost savings of the enabled rules over a window, by tenant, job
+// and rule. Query parameters: hours (default 720, 30 days) and format (json
+// or html).
+func (h *Handler) SavingsReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "html" {
+		http.Error(w, "Invalid 'format' parameter: must be json or html", http.StatusBadRequest)
+		return
+	}
+
+	hours := defaultReportHours
+	if v := query.Get("hours"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid 'hours' parameter", http.StatusBadRequest)
+			return
+		}
+		hours = parsed
+	}
+
+	rules, err := h.ruleEngine.GetRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := h.savingsReport(rules, hours, time.Now())
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		renderReportHTML(w, &ScheduledReport{SavingsReport: report})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// savingsReport computes the savings report of rules over the hours before now
+func (h *Handler) savingsReport(rules []*models.Rule, hours int, now time.Time) SavingsReport {
+	window := time.Duration(hours) * time.Hour
+	report := SavingsReport{
+		From:     now.Add(-window),
+		To:       now,
+		Hours:    hours,
+		Currency: h.cfg.Reports.Currency,
+		Total:    SavingsLine{Key: "total"},
+	}
+
+	tenants := make(map[string]*SavingsLine)
+	jobs := make(map[string]*SavingsLine)
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		line := h.ruleSavings(rule, window)
+		report.ByRule = append(report.ByRule, line)
+		report.Total.add(line, 1)
+		savingsGroup(tenants, ruleTenant(rule)).add(line, 1)
+		for job, share := range h.jobShares(rule) {
+			savingsGroup(jobs, job).add(line, share)
+		}
+	}
+
+	// Monthly costs are prorated to the window
+	months := float64(hours) / defaultReportHours
+	finish := func(lines []SavingsLine) []SavingsLine {
+		for i := range lines {
+			lines[i].finish(h.cfg.Reports.CostPerSeries * months)
+		}
+		sort.Slice(lines, func(i, j int) bool {
+			if lines[i].SeriesSaved != lines[j].SeriesSaved {
+				return lines[i].SeriesSaved > lines[j].SeriesSaved
+			}
+			return lines[i].Key < lines[j].Key
+		})
+		return lines
+	}
+	report.Total.finish(h.cfg.Reports.CostPerSeries * months)
+	report.ByTenant = finish(savingsLines(tenants))
+	report.ByJob = finish(savingsLines(jobs))
+	report.ByRule = finish(report.ByRule)
+	if report.ByRule == nil {
+		report.ByRule = []SavingsLine{}
+	}
+
+	return report
+}
+
+// ruleSavings returns what a rule saved over the window ending now
+func (h *Handler) ruleSavings(rule *models.Rule, window time.Duration) SavingsLine {
+	line := SavingsLine{Key: rule.ID, Name: rule.Name, Rules: 1}
+	for _, name := range matchedMetrics(h.usageTracker, rule) {
+		if usage, ok := h.usageTracker.UsageOverWindow(name, window); ok {
+			line.InputSeries += usage.Cardinality
+			line.InputSamples += usage.Samples
+		}
+	}
+	if usage, ok := h.usageTracker.UsageOverWindow(rule.Output.MetricName, window); ok {
+		line.OutputSeries = usage.Cardinality
+	}
+
+	drop := float64(rule.DropPercentage()) / 100
+	if line.InputSeries > line.OutputSeries {
+		line.SeriesSaved = (line.InputSeries - line.OutputSeries) * drop
+	}
+	line.DroppedSamples = int64(float64(line.InputSamples) * drop)
+	return line
+}
+
+// jobShares returns the jobs of the series a rule matches, with the share of
+// the samples of each: the job of the rule's label matcher, or else the
+// observed values of the job label
+func (h *Handler) jobShares(rule *models.Rule) map[string]float64 {
+	label := h.cfg.Reports.JobLabel
+	if job := rule.Matcher.Labels[label]; job != "" {
+		return map[string]float64{job: 1}
+	}
+
+	counts := make(map[string]int64)
+	var total int64
+	for _, name := range matchedMetrics(h.usageTracker, rule) {
+		for _, distribution := range h.usageTracker.GetLabelDistribution(name) {
+			if distribution.Label != label {
+				continue
+			}
+			for _, value := range distribution.TopValues {
+				counts[value.Value] += value.Count
+				total += value.Count
+			}
+		}
+	}
+	if total == 0 {
+		return map[string]float64{unassigned: 1}
+	}
+
+	shares := make(map[string]float64, len(counts))
+	for job, count := range counts {
+		shares[job] = float64(count) / float64(total)
+	}
+	return shares
+}
+
+// ruleTenant returns the tenant the aggregated series of a rule are written for
+func ruleTenant(rule *models.Rule) string {
+	if target := rule.Output.RemoteWriteTarget; target != nil && target.Tenant != "" {
+		return target.Tenant
+	}
+	return unassigned
+}
+
+// add adds a share of the savings of a rule to the line
+func (l *SavingsLine) add(rule SavingsLine, share float64) {
+	l.Rules++
+	l.InputSeries += rule.InputSeries * share
+	l.OutputSeries += rule.OutputSeries * share
+	l.SeriesSaved += rule.SeriesSaved * share
+	l.InputSamples += int64(float64(rule.InputSamples) * share)
+	l.DroppedSamples += int64(float64(rule.DroppedSamples) * share)
+}
+
+// finish computes the savings percentage and the estimated savings of the
+// line at the given cost per series
+func (l *SavingsLine) finish(costPerSeries float64) {
+	if l.InputSeries > 0 {
+		l.SavingsPercentage = l.SeriesSaved / l.InputSeries * 100.0
+	}
+	l.EstimatedSavings = l.SeriesSaved * costPerSeries
+}
+
+// savingsGroup returns the line of a group, creating it if needed
+func savingsGroup(groups map[string]*SavingsLine, key string) *SavingsLine {
+	line, exists := groups[key]
+	if !exists {
+		line = &SavingsLine{Key: key}
+		groups[key] = line
+	}
+	return line
+}
+
+// savingsLines returns the lines of groups
+func savingsLines(groups map[string]*SavingsLine) []SavingsLine {
+	lines := make([]SavingsLine, 0, len(groups))
+	for _, line := range groups {
+		lines = append(lines, *line)
+	}
+	return lines
+}
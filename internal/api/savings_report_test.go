@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestHandler_SavingsReport(t *testing.T) {
+	tracker := metrics.NewUsageTracker(time.Hour)
+	for i := 0; i < 100; i++ {
+		job := "api"
+		if i%4 == 0 {
+			job = "worker"
+		}
+		tracker.TrackMetric("http_requests_total", map[string]string{"job": job, "pod": fmt.Sprintf("pod-%d", i)}, 1)
+	}
+	for i := 0; i < 10; i++ {
+		tracker.TrackMetric("http_requests_total:aggregated", map[string]string{"route": fmt.Sprintf("/%d", i)}, 1)
+	}
+
+	cfg := &config.Config{Reports: config.ReportsConfig{JobLabel: "job", CostPerSeries: 0.01, Currency: "USD"}}
+	h := &Handler{cfg: cfg, usageTracker: tracker}
+
+	rules := []*models.Rule{
+		{
+			ID:      "requests",
+			Name:    "Requests by route",
+			Enabled: true,
+			Matcher: models.MetricMatcher{MetricNames: []string{"http_requests_total"}},
+			Output: models.OutputConfig{
+				MetricName:        "http_requests_total:aggregated",
+				DropOriginal:      true,
+				RemoteWriteTarget: &models.RemoteWriteTarget{Tenant: "team-a"},
+			},
+		},
+		{
+			ID:      "disabled",
+			Matcher: models.MetricMatcher{MetricNames: []string{"http_requests_total"}},
+		},
+	}
+
+	report := h.savingsReport(rules, 24, time.Now())
+	if len(report.ByRule) != 1 || report.ByRule[0].Key != "requests" {
+		t.Fatalf("by rule = %+v, want the enabled rule only", report.ByRule)
+	}
+
+	line := report.ByRule[0]
+	if line.InputSeries < 95 || line.InputSeries > 105 || line.OutputSeries < 9 || line.OutputSeries > 11 {
+		t.Errorf("series = %.0f in, %.0f out; want about 100 and 10", line.InputSeries, line.OutputSeries)
+	}
+	if line.InputSamples != 100 || line.DroppedSamples != 100 {
+		t.Errorf("samples = %d in, %d dropped; want 100 and 100", line.InputSamples, line.DroppedSamples)
+	}
+	// A day is 1/30 of the monthly cost
+	if want := line.SeriesSaved * 0.01 / 30; math.Abs(line.EstimatedSavings-want) > 1e-9 {
+		t.Errorf("estimated savings = %f, want %f", line.EstimatedSavings, want)
+	}
+
+	if len(report.ByTenant) != 1 || report.ByTenant[0].Key != "team-a" {
+		t.Errorf("by tenant = %+v", report.ByTenant)
+	}
+	if len(report.ByJob) != 2 || report.ByJob[0].Key != "api" || report.ByJob[1].Key != "worker" {
+		t.Fatalf("by job = %+v, want api then worker", report.ByJob)
+	}
+	if api := report.ByJob[0]; math.Abs(api.SeriesSaved-line.SeriesSaved*0.75) > 1e-6 {
+		t.Errorf("api saved %.1f series, want 3/4 of %.1f", api.SeriesSaved, line.SeriesSaved)
+	}
+	if report.Total.SeriesSaved != line.SeriesSaved || report.Total.Rules != 1 {
+		t.Errorf("total = %+v", report.Total)
+	}
+}
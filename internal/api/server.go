@@ -4,16 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/marcotuna/adaptive-metrics/internal/aggregator"
+	"github.com/marcotuna/adaptive-metrics/internal/backfill"
 	"github.com/marcotuna/adaptive-metrics/internal/config"
 	"github.com/marcotuna/adaptive-metrics/internal/metrics"
 	"github.com/marcotuna/adaptive-metrics/internal/models"
 	"github.com/marcotuna/adaptive-metrics/internal/rules"
 	"github.com/marcotuna/adaptive-metrics/internal/types"
 	"github.com/marcotuna/adaptive-metrics/pkg/kubernetes"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -54,6 +58,17 @@ type Handler struct {
 	recommendationStore   *RecommendationStore
 	recommendationHandler *RecommendationHandler
 	processor             *aggregator.Processor
+	ingestLimiter         *IngestLimiter
+	haTracker             *HATracker
+	metadata              *MetadataStore
+	ownership             *ownershipAssigner
+	backfill              *backfill.Manager
+	reports               *reportScheduler
+	ingest                ingestGate
+
+	// Time of the last debug dump requested through the API
+	dumpMu   sync.Mutex
+	lastDump time.Time
 }
 
 // Ensure Handler implements the MetricTracker interface
@@ -61,21 +76,72 @@ var _ types.MetricTracker = (*Handler)(nil)
 
 // NewHandler creates a new API handler
 func NewHandler(cfg *config.Config) (*Handler, error) {
+	if !validMonitorOnApply(cfg.Kubernetes.MonitorOnApply) {
+		return nil, fmt.Errorf("invalid kubernetes.monitor_on_apply %q: must be one of none, file, cluster", cfg.Kubernetes.MonitorOnApply)
+	}
+
 	ruleEngine, err := rules.NewEngine(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create usage tracker (90 days retention)
-	usageTracker := metrics.NewUsageTracker(90 * 24 * time.Hour)
+	// Create usage tracker (defaults to 90 days retention)
+	retention := time.Duration(cfg.Usage.RetentionHours) * time.Hour
+	if retention <= 0 {
+		retention = 90 * 24 * time.Hour
+	}
+	usageTracker := metrics.NewUsageTrackerWithOptions(retention, metrics.UsageTrackerOptions{
+		SeriesPrecision:          uint8(cfg.Usage.SeriesSketchPrecision),
+		LabelPrecision:           uint8(cfg.Usage.LabelSketchPrecision),
+		MaxLabelsPerMetric:       cfg.Usage.MaxLabelsPerMetric,
+		TopValuesPerLabel:        cfg.Usage.TopValuesPerLabel,
+		HistoryPoints:            cfg.Usage.HistoryHours,
+		HistoryFile:              cfg.Usage.HistoryFile,
+		SampleRate:               cfg.Usage.SampleRate,
+		MaxSamplesPerSecond:      cfg.Usage.MaxSamplesPerSecond,
+		CooccurrenceSampleSize:   cfg.Usage.CooccurrenceSampleSize,
+		DiscoverySeriesPerMetric: cfg.Usage.DiscoverySeriesPerMetric,
+	})
+	if err := usageTracker.LoadHistory(); err != nil {
+		logger.LogWarnWithFields("Failed to load usage history", logger.Fields{
+			"file":  cfg.Usage.HistoryFile,
+			"error": err.Error(),
+		})
+	}
+
+	// Metrics no rule matches are caught by their cardinality
+	ruleEngine.SetCardinalitySource(usageTracker)
 
 	// Create recommendation engine
 	recommendationEngine := metrics.NewRecommendationEngine(
 		usageTracker,
-		1000, // Minimum sample threshold
-		100,  // Minimum cardinality threshold
-		0.5,  // Minimum confidence
+		cfg.Recommendations.MinSamples,
+		cfg.Recommendations.MinCardinality,
+		cfg.Recommendations.MinConfidence,
 	)
+	settings := recommendationEngine.Settings()
+	settings.VerificationHours = cfg.Recommendations.VerificationHours
+	settings.DivergenceThreshold = cfg.Recommendations.DivergenceThreshold
+	settings.MaxIntervalSeconds = cfg.Recommendations.MaxIntervalSeconds
+	if err := recommendationEngine.UpdateSettings(settings); err != nil {
+		return nil, fmt.Errorf("invalid recommendations config: %w", err)
+	}
+	recommendationEngine.SetProtectionChecker(ruleEngine)
+	strategies := make([]metrics.StrategyConfig, 0, len(cfg.Recommendations.Strategies))
+	for _, strategy := range cfg.Recommendations.Strategies {
+		strategies = append(strategies, metrics.StrategyConfig{Name: strategy.Name, Params: strategy.Params})
+	}
+	if err := recommendationEngine.SetStrategies(strategies); err != nil {
+		return nil, fmt.Errorf("invalid recommendations config: %w", err)
+	}
+
+	reports, err := newReportScheduler(cfg.Reports)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reports config: %w", err)
+	}
+
+	// Rules loaded from disk are not checked against the protection list
+	logViolations(ruleEngine.ProtectionViolations())
 
 	// Create recommendation store
 	recommendationStore := NewRecommendationStore()
@@ -87,6 +153,12 @@ func NewHandler(cfg *config.Config) (*Handler, error) {
 		usageTracker:         usageTracker,
 		recommendationEngine: recommendationEngine,
 		recommendationStore:  recommendationStore,
+		ingestLimiter:        NewIngestLimiter(cfg.Ingest),
+		haTracker:            NewHATracker(cfg.Ingest.HATracker),
+		metadata:             NewMetadataStore(),
+		ownership:            &ownershipAssigner{cfg: cfg.Ownership, usageTracker: usageTracker},
+		backfill:             backfill.NewManager(cfg, ruleEngine),
+		reports:              reports,
 	}
 
 	// Create rule engine adapter
@@ -99,6 +171,15 @@ func NewHandler(cfg *config.Config) (*Handler, error) {
 		recommendationEngine,
 		ruleEngineAdapter,
 	)
+	h.recommendationHandler.ownership = h.ownership
+	h.recommendationHandler.kubernetes = cfg.Kubernetes
+	h.recommendationHandler.expiry.maxAge = time.Duration(cfg.Recommendations.ExpiryHours) * time.Hour
+	h.recommendationHandler.regenerateOnAccess = cfg.Recommendations.RegenerateOnAccess
+
+	// Monitors applied by hand drift from rules changed while they were not
+	if cfg.Kubernetes.ReconcileOnStartup {
+		h.reconcileKubernetesOnStartup()
+	}
 
 	return h, nil
 }
@@ -137,6 +218,16 @@ func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
 	promhttp.Handler().ServeHTTP(w, r)
 }
 
+// BuildInfo returns build information in the format of the Prometheus
+// /api/v1/status/buildinfo endpoint
+func (h *Handler) BuildInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   version.Get(),
+	})
+}
+
 // ListRules returns all aggregation rules
 func (h *Handler) ListRules(w http.ResponseWriter, r *http.Request) {
 	rules, err := h.ruleEngine.GetRules()
@@ -145,6 +236,45 @@ func (h *Handler) ListRules(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Filter by ownership
+	query := r.URL.Query()
+	owner, team, namespace := query.Get("owner"), query.Get("team"), query.Get("namespace")
+	if owner != "" || team != "" || namespace != "" {
+		filtered := rules[:0]
+		for _, rule := range rules {
+			if matchesOwnership(rule, owner, team, namespace) {
+				filtered = append(filtered, rule)
+			}
+		}
+		rules = filtered
+	}
+
+	// Disabled rules show what they would have matched
+	dryStatistics := h.ruleEngine.DryStatistics()
+	listed := make([]listedRule, 0, len(rules))
+	for _, rule := range rules {
+		listed = append(listed, listedRule{Rule: rule, DryStatistics: dryStatistics[rule.ID]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listed)
+}
+
+// listedRule is a rule of the rules list, with the dry statistics of
+// disabled rules when they are enabled
+type listedRule struct {
+	*models.Rule
+	DryStatistics *rules.DryStatistics `json:"dry_statistics,omitempty"`
+}
+
+// ListCatchAllRules returns the rules the catch-all rule generated for the
+// metrics it currently aggregates
+func (h *Handler) ListCatchAllRules(w http.ResponseWriter, r *http.Request) {
+	rules := h.ruleEngine.CatchAllRules()
+	if rules == nil {
+		rules = []*models.Rule{}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(rules)
 }
@@ -164,12 +294,47 @@ func (h *Handler) GetRule(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(rule)
 }
 
+// ruleRequest is the body of rule create and update requests. TTL is a
+// duration such as "2h" after which the rule expires, as an alternative to
+// setting expires_at.
+type ruleRequest struct {
+	models.Rule
+	TTL string `json:"ttl,omitempty"`
+}
+
+// decodeRuleRequest decodes a rule from a request body, resolving its TTL
+func decodeRuleRequest(r *http.Request, now time.Time) (models.Rule, error) {
+	var req ruleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return models.Rule{}, fmt.Errorf("Invalid request body")
+	}
+
+	rule := req.Rule
+	if req.TTL != "" {
+		if rule.ExpiresAt != nil {
+			return rule, fmt.Errorf("only one of ttl and expires_at may be set")
+		}
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return rule, fmt.Errorf("invalid ttl %q: must be a positive duration such as 2h", req.TTL)
+		}
+		expiresAt := now.Add(ttl)
+		rule.ExpiresAt = &expiresAt
+	}
+
+	// An expired rule can only be enabled with a new expiry time
+	if rule.Enabled && rule.Expired(now) {
+		return rule, fmt.Errorf("rule has expired: set a later expires_at or a ttl to enable it")
+	}
+
+	return rule, nil
+}
+
 // CreateRule creates a new aggregation rule
 func (h *Handler) CreateRule(w http.ResponseWriter, r *http.Request) {
-	var rule models.Rule
-
-	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	rule, err := decodeRuleRequest(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -179,9 +344,19 @@ func (h *Handler) CreateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Infer the team and namespace from the matched series when not set
+	h.ownership.assign(&rule)
+
+	// Reject rules that would aggregate protected metrics
+	if err := h.ruleEngine.CheckProtection(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Set creation time
 	rule.CreatedAt = time.Now()
 	rule.UpdatedAt = time.Now()
+	rule.UpdatedBy = h.author(r)
 
 	// Save the rule
 	if err := h.ruleEngine.SaveRule(&rule); err != nil {
@@ -199,9 +374,9 @@ func (h *Handler) UpdateRule(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var rule models.Rule
-	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	rule, err := decodeRuleRequest(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -214,8 +389,18 @@ func (h *Handler) UpdateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Infer the team and namespace from the matched series when not set
+	h.ownership.assign(&rule)
+
+	// Reject rules that would aggregate protected metrics
+	if err := h.ruleEngine.CheckProtection(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Update timestamp
 	rule.UpdatedAt = time.Now()
+	rule.UpdatedBy = h.author(r)
 
 	// Update the rule
 	if err := h.ruleEngine.UpdateRule(&rule); err != nil {
@@ -227,12 +412,62 @@ func (h *Handler) UpdateRule(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(rule)
 }
 
+// rolloutRequest is the body of rule rollout requests
+type rolloutRequest struct {
+	Percentage int `json:"percentage"`
+}
+
+// UpdateRuleRollout sets the percentage of original series a rule drops
+func (h *Handler) UpdateRuleRollout(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req rolloutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := h.ruleEngine.GetRule(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !existing.DropsOriginals() {
+		http.Error(w, "rule does not drop its original metrics", http.StatusBadRequest)
+		return
+	}
+
+	rule := *existing
+	rule.Output.RolloutPercentage = &req.Percentage
+	rule.DropsSuspended = nil
+	if err := rule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.UpdatedAt = time.Now()
+	rule.UpdatedBy = h.author(r)
+
+	if err := h.ruleEngine.UpdateRule(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.LogInfoWithFields("Rule rollout updated", logger.Fields{
+		"rule_id":    rule.ID,
+		"percentage": req.Percentage,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
 // DeleteRule deletes a rule
 func (h *Handler) DeleteRule(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.ruleEngine.DeleteRule(id); err != nil {
+	if err := h.ruleEngine.DeleteRuleBy(id, h.author(r)); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -245,6 +480,24 @@ func (h *Handler) TrackMetric(name string, labels map[string]string, value float
 	h.usageTracker.TrackMetric(name, labels, value)
 }
 
+// GetRecommendationStore returns the store of recommendations
+func (h *Handler) GetRecommendationStore() types.RecommendationStore {
+	return h.recommendationStore
+}
+
+// StartBackgroundJobs starts the periodic jobs of the handler, such as
+// expiring stale recommendations and delivering scheduled reports
+func (h *Handler) StartBackgroundJobs() {
+	h.recommendationHandler.startExpiry()
+	h.startReports()
+}
+
+// StopBackgroundJobs stops the jobs started by StartBackgroundJobs
+func (h *Handler) StopBackgroundJobs() {
+	h.recommendationHandler.stopExpiry()
+	h.stopReports()
+}
+
 // GetRuleEngine returns the rule engine instance
 func (h *Handler) GetRuleEngine() interface{} {
 	return h.ruleEngine
@@ -253,6 +506,11 @@ func (h *Handler) GetRuleEngine() interface{} {
 // SetupRecommendationRoutes sets up the routes for the recommendation API
 func (h *Handler) SetupRecommendationRoutes(router *mux.Router) {
 	router.HandleFunc("/recommendations", h.recommendationHandler.ListRecommendations).Methods("GET", "OPTIONS")
+	router.HandleFunc("/recommendations/settings", h.recommendationHandler.GetRecommendationSettings).Methods("GET", "OPTIONS")
+	router.HandleFunc("/recommendations/settings", h.recommendationHandler.UpdateRecommendationSettings).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/recommendations/strategies", h.recommendationHandler.ListRecommendationStrategies).Methods("GET", "OPTIONS")
+	router.HandleFunc("/recommendations/groups", h.recommendationHandler.ListRecommendationGroups).Methods("GET", "OPTIONS")
+	router.HandleFunc("/recommendations/groups/{value}/apply", h.recommendationHandler.ApplyRecommendationGroup).Methods("POST", "OPTIONS")
 	router.HandleFunc("/recommendations/{id}", h.recommendationHandler.GetRecommendation).Methods("GET", "OPTIONS")
 	router.HandleFunc("/recommendations/{id}/apply", h.recommendationHandler.ApplyRecommendation).Methods("POST", "OPTIONS")
 	router.HandleFunc("/recommendations/{id}/reject", h.recommendationHandler.RejectRecommendation).Methods("POST", "OPTIONS")
@@ -261,6 +519,13 @@ func (h *Handler) SetupRecommendationRoutes(router *mux.Router) {
 	// Add new endpoints for metrics usage data
 	router.HandleFunc("/metrics-usage", h.recommendationHandler.ListMetricsUsage).Methods("GET", "OPTIONS")
 	router.HandleFunc("/metrics-usage/{name}", h.recommendationHandler.GetMetricUsage).Methods("GET", "OPTIONS")
+
+	// Cardinality explorer endpoints
+	router.HandleFunc("/metrics/usage/top", h.recommendationHandler.TopMetricsUsage).Methods("GET", "OPTIONS")
+	router.HandleFunc("/metrics/usage/export", h.recommendationHandler.ExportMetricsUsage).Methods("GET", "OPTIONS")
+	router.HandleFunc("/metrics/usage/import", h.recommendationHandler.ImportMetricsUsage).Methods("POST", "OPTIONS")
+	router.HandleFunc("/metrics/{name}/labels", h.recommendationHandler.GetMetricLabels).Methods("GET", "OPTIONS")
+	router.HandleFunc("/metrics/{name}/history", h.recommendationHandler.GetMetricHistory).Methods("GET", "OPTIONS")
 }
 
 // KubernetesMonitor generates Kubernetes monitoring resources
@@ -280,8 +545,21 @@ func (h *Handler) KubernetesMonitor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// In patch mode, the JSON patches can be requested instead of the merged monitors
+	if rule.OutputKubernetes.Mode == "patch" && r.URL.Query().Get("format") == "json-patch" {
+		patches, err := kubernetes.PatchMonitors(rule, h.cfg.Kubernetes.ExistingMonitorsDir)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to patch Kubernetes monitor: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(patches)
+		return
+	}
+
 	// Generate the monitor resource
-	monitorYAML, err := kubernetes.RenderMonitor(rule)
+	monitorYAML, err := kubernetes.RenderMonitor(rule, h.cfg.Kubernetes.ExistingMonitorsDir)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to generate Kubernetes monitor: %v", err), http.StatusInternalServerError)
 		return
@@ -292,6 +570,33 @@ func (h *Handler) KubernetesMonitor(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(monitorYAML))
 }
 
+// KubernetesAlerts generates the PrometheusRule alerting on the health of a rule's aggregation
+func (h *Handler) KubernetesAlerts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	rule, err := h.ruleEngine.GetRule(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Check if the rule has Kubernetes output configured
+	if rule.OutputKubernetes == nil || !rule.OutputKubernetes.Enabled {
+		http.Error(w, "Rule does not have Kubernetes output configured", http.StatusBadRequest)
+		return
+	}
+
+	alertsYAML, err := kubernetes.RenderAlerts(rule)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate Kubernetes alerts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write([]byte(alertsYAML))
+}
+
 // SaveKubernetesMonitor generates and saves a Kubernetes monitoring resource to disk
 func (h *Handler) SaveKubernetesMonitor(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -322,11 +627,11 @@ func (h *Handler) SaveKubernetesMonitor(w http.ResponseWriter, r *http.Request)
 	// Use default directory if not specified
 	outputDir := requestData.OutputDir
 	if outputDir == "" {
-		outputDir = "kubernetes/monitors"
+		outputDir = h.cfg.Kubernetes.MonitorsDir
 	}
 
 	// Generate and save the monitor file
-	filePath, err := kubernetes.WriteMonitorFile(rule, outputDir)
+	filePath, err := kubernetes.WriteMonitorFile(rule, outputDir, h.cfg.Kubernetes.ExistingMonitorsDir)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to save Kubernetes monitor: %v", err), http.StatusInternalServerError)
 		return
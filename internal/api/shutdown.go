@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// ingestGate tracks in-flight remote write requests, so that ingestion can be
+// stopped and drained before the processor shuts down
+type ingestGate struct {
+	mu       sync.Mutex
+	closed   bool
+	inflight int
+	drained  chan struct{}
+}
+
+// enter admits a request, or returns false once the gate is closed
+func (g *ingestGate) enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.inflight++
+	return true
+}
+
+// leave marks an admitted request as finished
+func (g *ingestGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inflight--
+	if g.closed && g.inflight == 0 {
+		close(g.drained)
+	}
+}
+
+// isClosed reports whether the gate no longer admits requests
+func (g *ingestGate) isClosed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closed
+}
+
+// close stops admitting requests and waits for the admitted ones to finish
+func (g *ingestGate) close(ctx context.Context) error {
+	g.mu.Lock()
+	if !g.closed {
+		g.closed = true
+		g.drained = make(chan struct{})
+		if g.inflight == 0 {
+			close(g.drained)
+		}
+	}
+	drained := g.drained
+	g.mu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StopIngest rejects new remote write requests with 503, so senders retry
+// against another instance, and waits until the requests being handled have
+// queued their samples or the context is done
+func (h *Handler) StopIngest(ctx context.Context) error {
+	return h.ingest.close(ctx)
+}
+
+// shuttingDown rejects a remote write request received after StopIngest
+func (h *Handler) shuttingDown(w http.ResponseWriter) {
+	if h.cfg.Ingest.RetryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(h.cfg.Ingest.RetryAfterSeconds))
+	}
+	http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+}
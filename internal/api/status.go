@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/memory"
+	"github.com/marcotuna/adaptive-metrics/pkg/remote"
+	"github.com/marcotuna/adaptive-metrics/pkg/version"
+)
+
+// startedAt is when the process started, for the reported uptime
+var startedAt = time.Now()
+
+// SetupStatusRoutes sets up the routes for inspecting the running service
+func (h *Handler) SetupStatusRoutes(router *mux.Router) {
+	router.HandleFunc("/status/config", h.StatusConfig).Methods("GET", "OPTIONS")
+	router.HandleFunc("/status/runtime", h.StatusRuntime).Methods("GET", "OPTIONS")
+	router.HandleFunc("/status/remote-write", h.StatusRemoteWrite).Methods("GET", "OPTIONS")
+	router.HandleFunc("/status/memory", h.StatusMemory).Methods("GET", "OPTIONS")
+}
+
+// StatusConfig returns the effective configuration, after defaults, the
+// config file, environment variables and secret references were applied,
+// with secrets redacted
+func (h *Handler) StatusConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   h.cfg.Redacted().Settings(),
+	})
+}
+
+// StatusRuntime returns the state of the process and the processor
+func (h *Handler) StatusRuntime(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	data := map[string]interface{}{
+		"build":          version.Get(),
+		"started_at":     startedAt.Format(time.RFC3339),
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+		"goroutines":     runtime.NumGoroutine(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"memory": map[string]interface{}{
+			"heap_alloc_bytes":  mem.HeapAlloc,
+			"heap_inuse_bytes":  mem.HeapInuse,
+			"heap_objects":      mem.HeapObjects,
+			"sys_bytes":         mem.Sys,
+			"gc_cycles":         mem.NumGC,
+			"gc_pause_total_ns": mem.PauseTotalNs,
+		},
+	}
+	if rules, err := h.ruleEngine.GetRules(); err == nil {
+		data["rules"] = len(rules)
+	}
+	if h.processor != nil {
+		data["processor"] = h.processor.Stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+// StatusRemoteWrite returns the health and circuit breaker state of each
+// remote write endpoint
+func (h *Handler) StatusRemoteWrite(w http.ResponseWriter, r *http.Request) {
+	endpoints := []remote.EndpointStatus{}
+	enabled := false
+	if h.processor != nil {
+		if status, ok := h.processor.RemoteWriteStatus(); ok {
+			endpoints, enabled = status, true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"enabled":   enabled,
+			"endpoints": endpoints,
+		},
+	})
+}
+
+// StatusMemory returns the heap of the process, the garbage collector
+// settings and the estimated memory held by each subsystem
+func (h *Handler) StatusMemory(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	usage := h.usageTracker.MemoryUsage()
+	subsystems := map[string]interface{}{
+		"usage_tracker": usage,
+	}
+	estimated := usage.Total
+	if h.processor != nil {
+		processor := h.processor.MemoryUsage()
+		subsystems["buckets"] = processor.Buckets
+		subsystems["input_queue"] = processor.InputQueue
+		subsystems["remote_write_queue"] = processor.RemoteWriteQueue
+		subsystems["counters"] = processor.Counters
+		estimated += processor.Total()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"heap": map[string]interface{}{
+				"heap_alloc_bytes": mem.HeapAlloc,
+				"heap_inuse_bytes": mem.HeapInuse,
+				"heap_objects":     mem.HeapObjects,
+				"sys_bytes":        mem.Sys,
+				"next_gc_bytes":    mem.NextGC,
+				"gc_cycles":        mem.NumGC,
+			},
+			"gc":                    memory.CurrentSettings(),
+			"subsystems":            subsystems,
+			"estimated_total_bytes": estimated,
+		},
+	})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// SetupTemplateRoutes sets up the routes for the rule template API
+func (h *Handler) SetupTemplateRoutes(router *mux.Router) {
+	router.HandleFunc("/templates", h.ListTemplates).Methods("GET", "OPTIONS")
+	router.HandleFunc("/templates", h.CreateTemplate).Methods("POST", "OPTIONS")
+	router.HandleFunc("/templates/{id}", h.GetTemplate).Methods("GET", "OPTIONS")
+	router.HandleFunc("/templates/{id}", h.DeleteTemplate).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/templates/{id}/instantiate", h.InstantiateTemplate).Methods("POST", "OPTIONS")
+}
+
+// ListTemplates returns all rule templates
+func (h *Handler) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	templates := h.ruleEngine.GetTemplates()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"templates": templates,
+		"total":     len(templates),
+	})
+}
+
+// GetTemplate returns a specific rule template by ID
+func (h *Handler) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	tmpl, err := h.ruleEngine.GetTemplate(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tmpl)
+}
+
+// CreateTemplate creates a new rule template
+func (h *Handler) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var tmpl models.RuleTemplate
+
+	if err := json.NewDecoder(r.Body).Decode(&tmpl); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate the template
+	if err := tmpl.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl.CreatedAt = time.Now()
+	tmpl.UpdatedAt = time.Now()
+
+	if err := h.ruleEngine.SaveTemplate(&tmpl); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tmpl)
+}
+
+// DeleteTemplate deletes a rule template
+func (h *Handler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := h.ruleEngine.DeleteTemplate(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InstantiateTemplate generates concrete rules from a template, one per set of values
+func (h *Handler) InstantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if _, err := h.ruleEngine.GetTemplate(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var requestData struct {
+		Values []map[string]string `json:"values"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rules, err := h.ruleEngine.InstantiateTemplate(id, requestData.Values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"rules":  rules,
+		"total":  len(rules),
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+)
+
+// TopMetricsUsage returns the top metrics by cardinality, sample rate or cardinality growth.
+// Query parameters: by (cardinality, sample_rate, growth), limit (default 10) and
+// hours (growth window, default 24).
+func (h *RecommendationHandler) TopMetricsUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	by := query.Get("by")
+	if by == "" {
+		by = metrics.TopByCardinality
+	}
+	if by != metrics.TopByCardinality && by != metrics.TopBySampleRate && by != metrics.TopByGrowth {
+		http.Error(w, "Invalid 'by' parameter: must be one of cardinality, sample_rate, growth", http.StatusBadRequest)
+		return
+	}
+
+	limit := 10
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid 'limit' parameter", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	hours := 24
+	if v := query.Get("hours"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid 'hours' parameter", http.StatusBadRequest)
+			return
+		}
+		hours = parsed
+	}
+
+	top := h.usageTracker.TopMetrics(by, limit, time.Duration(hours)*time.Hour)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"by":      by,
+		"hours":   hours,
+		"metrics": top,
+		"total":   len(top),
+	})
+}
+
+// GetMetricLabels returns per-label cardinality and value distributions for a metric
+func (h *RecommendationHandler) GetMetricLabels(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	labels := h.usageTracker.GetLabelDistribution(name)
+	if labels == nil {
+		http.Error(w, "Metric not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"metric_name": name,
+		"labels":      labels,
+		"total":       len(labels),
+	})
+}
+
+// GetMetricHistory returns the hourly cardinality and sample rate roll-ups of a metric.
+// Query parameters: hours (default 24).
+func (h *RecommendationHandler) GetMetricHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	hours := 24
+	if v := r.URL.Query().Get("hours"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid 'hours' parameter", http.StatusBadRequest)
+			return
+		}
+		hours = parsed
+	}
+
+	if h.usageTracker.GetMetricInfo(name) == nil {
+		http.Error(w, "Metric not found", http.StatusNotFound)
+		return
+	}
+
+	window := time.Duration(hours) * time.Hour
+	response := map[string]interface{}{
+		"metric_name": name,
+		"hours":       hours,
+		"points":      h.usageTracker.GetHistory(name, window),
+	}
+	if trend, ok := h.usageTracker.CardinalityTrend(name, window); ok {
+		response["cardinality_trend"] = trend
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
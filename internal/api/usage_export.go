@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/parquet"
+)
+
+const (
+	// defaultUsageExportLimit and maxUsageExportLimit bound the metrics in a
+	// page of a usage export
+	defaultUsageExportLimit = 1000
+	maxUsageExportLimit     = 10000
+
+	// maxUsageImportBytes bounds a usage import request, and
+	// maxUsageImportDecompressedBytes the document it decompresses to
+	maxUsageImportBytes             = 64 << 20
+	maxUsageImportDecompressedBytes = 512 << 20
+
+	// headerNextPageToken carries the token of the next page of an export,
+	// which Parquet pages have no room for in the body
+	headerNextPageToken = "X-Next-Page-Token"
+)
+
+// usageExport is a page of a usage export
+type usageExport struct {
+	Version       int                   `json:"version"`
+	ExportedAt    time.Time             `json:"exported_at"`
+	Items         []metrics.UsageRecord `json:"items"`
+	NextPageToken string                `json:"next_page_token,omitempty"`
+}
+
+// ExportMetricsUsage returns a page of the usage of all metrics as a gzip
+// compressed JSON document, which can be imported into another instance, or
+// with format=parquet as a Parquet file for offline analysis. When more
+// metrics follow, the page carries a token for the next one; requesting a
+// page again with the same token returns the same metrics, so an interrupted
+// export can be retried and resumed.
+func (h *RecommendationHandler) ExportMetricsUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "parquet" {
+		http.Error(w, "Invalid 'format' parameter: must be one of json, parquet", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultUsageExportLimit
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > maxUsageExportLimit {
+			http.Error(w, fmt.Sprintf("Invalid 'limit' parameter: must be between 1 and %d", maxUsageExportLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	after, err := base64.RawURLEncoding.DecodeString(query.Get("page_token"))
+	if err != nil {
+		http.Error(w, "Invalid 'page_token' parameter", http.StatusBadRequest)
+		return
+	}
+
+	records, more := h.usageTracker.ExportUsage(string(after), limit)
+	now := time.Now().UTC()
+	page := usageExport{Version: metrics.UsageExportVersion, ExportedAt: now, Items: records}
+	if more && len(records) > 0 {
+		page.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(records[len(records)-1].MetricName))
+		w.Header().Set(headerNextPageToken, page.NextPageToken)
+	}
+
+	filename := "metrics-usage-" + now.Format("20060102T150405Z")
+	if format == "parquet" {
+		var buf bytes.Buffer
+		if err := writeUsageParquet(&buf, records); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".parquet"))
+		w.Write(buf.Bytes())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json.gz"))
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(page); err != nil {
+		logger.LogErrorWithFields("Failed to write usage export", logger.Fields{
+			"error": err.Error(),
+		})
+	}
+	gz.Close()
+}
+
+// writeUsageParquet writes one row per metric with the usage estimates; the
+// sketches are left out, so Parquet exports cannot be imported
+func writeUsageParquet(w io.Writer, records []metrics.UsageRecord) error {
+	n := len(records)
+	names, labelCardinality := make([]string, n), make([]string, n)
+	sampleCounts, cardinalities := make([]int64, n), make([]int64, n)
+	firstSeen, lastSeen := make([]time.Time, n), make([]time.Time, n)
+	minValues, maxValues, sumValues, scrapeIntervals := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	for i, record := range records {
+		encoded, err := json.Marshal(record.LabelCardinality)
+		if err != nil {
+			return err
+		}
+		names[i], labelCardinality[i] = record.MetricName, string(encoded)
+		sampleCounts[i], cardinalities[i] = record.SampleCount, int64(record.Cardinality)
+		firstSeen[i], lastSeen[i] = record.FirstSeen, record.LastSeen
+		minValues[i], maxValues[i], sumValues[i] = record.MinValue, record.MaxValue, record.SumValue
+		scrapeIntervals[i] = record.ScrapeIntervalSeconds
+	}
+
+	return parquet.Write(w,
+		parquet.StringColumn("metric_name", names),
+		parquet.Int64Column("sample_count", sampleCounts),
+		parquet.TimestampColumn("first_seen", firstSeen),
+		parquet.TimestampColumn("last_seen", lastSeen),
+		parquet.Int64Column("cardinality", cardinalities),
+		parquet.StringColumn("label_cardinality", labelCardinality),
+		parquet.DoubleColumn("min_value", minValues),
+		parquet.DoubleColumn("max_value", maxValues),
+		parquet.DoubleColumn("sum_value", sumValues),
+		parquet.DoubleColumn("scrape_interval_seconds", scrapeIntervals),
+	)
+}
+
+// ImportMetricsUsage merges a page of a JSON usage export, gzip compressed
+// or not, into the tracked usage. Importing a page twice has no further
+// effect, so failed imports can be retried.
+func (h *RecommendationHandler) ImportMetricsUsage(w http.ResponseWriter, r *http.Request) {
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, maxUsageImportBytes))
+	var reader io.Reader = body
+	if magic, _ := body.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	var page usageExport
+	if err := json.NewDecoder(io.LimitReader(reader, maxUsageImportDecompressedBytes)).Decode(&page); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid usage export: %v", err), http.StatusBadRequest)
+		return
+	}
+	if page.Version < 1 || page.Version > metrics.UsageExportVersion {
+		http.Error(w, fmt.Sprintf("Unsupported usage export version %d", page.Version), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.usageTracker.ImportUsage(page.Items)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.LogInfoWithFields("Imported metrics usage", logger.Fields{
+		"imported":         result.Imported,
+		"sketches_skipped": result.SketchesSkipped,
+		"exported_at":      page.ExportedAt,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   result,
+	})
+}
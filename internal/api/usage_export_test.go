@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+)
+
+func TestExportImportMetricsUsage(t *testing.T) {
+	source := newUsageListingTestHandler()
+	target := NewRecommendationHandler(NewRecommendationStore(), metrics.NewUsageTracker(time.Hour), nil, nil)
+
+	// Copy the usage page by page, as a migration would
+	token, pages := "", 0
+	for {
+		w := httptest.NewRecorder()
+		source.ExportMetricsUsage(w, httptest.NewRequest(http.MethodGet, "/metrics/usage/export?limit=2&page_token="+url.QueryEscape(token), nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/gzip" {
+			t.Fatalf("export = %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+		pages++
+
+		imported := httptest.NewRecorder()
+		target.ImportMetricsUsage(imported, httptest.NewRequest(http.MethodPost, "/metrics/usage/import", bytes.NewReader(w.Body.Bytes())))
+		if imported.Code != http.StatusOK {
+			t.Fatalf("import = %d %s", imported.Code, imported.Body.String())
+		}
+
+		token = w.Header().Get(headerNextPageToken)
+		if token == "" {
+			break
+		}
+	}
+	if pages != 2 {
+		t.Errorf("exported %d pages, want 2", pages)
+	}
+	if got := target.usageTracker.GetMetricInfo("http_requests_total"); got == nil || got.Cardinality != 3 {
+		t.Errorf("imported http_requests_total = %+v, want cardinality 3", got)
+	}
+	if got := len(target.usageTracker.MetricNames()); got != 3 {
+		t.Errorf("imported %d metrics, want 3", got)
+	}
+
+	// Parquet exports are for offline analysis
+	w := httptest.NewRecorder()
+	source.ExportMetricsUsage(w, httptest.NewRequest(http.MethodGet, "/metrics/usage/export?format=parquet", nil))
+	if body := w.Body.Bytes(); w.Code != http.StatusOK || !bytes.HasPrefix(body, []byte("PAR1")) || !bytes.HasSuffix(body, []byte("PAR1")) {
+		t.Errorf("parquet export = %d, not a Parquet file", w.Code)
+	}
+}
+
+func TestImportMetricsUsage_UnsupportedVersion(t *testing.T) {
+	h := newUsageListingTestHandler()
+	body, _ := json.Marshal(map[string]interface{}{"version": 99, "items": []interface{}{}})
+	w := httptest.NewRecorder()
+	h.ImportMetricsUsage(w, httptest.NewRequest(http.MethodPost, "/metrics/usage/import", bytes.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
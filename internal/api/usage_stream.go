@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/munnerz/goautoneg"
+)
+
+// contentTypeNDJSON is the content type of newline delimited JSON responses
+const contentTypeNDJSON = "application/x-ndjson"
+
+// usageFlushEvery is how many metrics are written between flushes of a
+// streamed usage listing
+const usageFlushEvery = 500
+
+// usageFields are the fields of a metric usage listing that can be selected
+var usageFields = map[string]func(*MetricUsageInfoResponse) interface{}{
+	"metric_name":       func(m *MetricUsageInfoResponse) interface{} { return m.MetricName },
+	"sample_count":      func(m *MetricUsageInfoResponse) interface{} { return m.SampleCount },
+	"first_seen":        func(m *MetricUsageInfoResponse) interface{} { return m.FirstSeen },
+	"last_seen":         func(m *MetricUsageInfoResponse) interface{} { return m.LastSeen },
+	"cardinality":       func(m *MetricUsageInfoResponse) interface{} { return m.Cardinality },
+	"label_cardinality": func(m *MetricUsageInfoResponse) interface{} { return m.LabelCardinality },
+	"min_value":         func(m *MetricUsageInfoResponse) interface{} { return m.MinValue },
+	"max_value":         func(m *MetricUsageInfoResponse) interface{} { return m.MaxValue },
+	"sum_value":         func(m *MetricUsageInfoResponse) interface{} { return m.SumValue },
+	"avg_value":         func(m *MetricUsageInfoResponse) interface{} { return m.AvgValue },
+	"type":              func(m *MetricUsageInfoResponse) interface{} { return m.Type },
+	"type_source":       func(m *MetricUsageInfoResponse) interface{} { return m.TypeSource },
+	"unit":              func(m *MetricUsageInfoResponse) interface{} { return m.Unit },
+}
+
+// usageListing filters and shapes the metrics of a usage listing
+type usageListing struct {
+	ndjson         bool
+	prefix         string
+	minCardinality int
+	fields         []string // All fields when empty
+}
+
+// parseUsageListing reads the format, filter and field selection of a usage
+// listing request. NDJSON is selected with format=ndjson or by preferring
+// application/x-ndjson in the Accept header.
+func parseUsageListing(r *http.Request) (usageListing, error) {
+	query := r.URL.Query()
+	listing := usageListing{prefix: query.Get("prefix")}
+
+	switch query.Get("format") {
+	case "":
+		listing.ndjson = goautoneg.Negotiate(r.Header.Get("Accept"), []string{contentTypeJSON, contentTypeNDJSON}) == contentTypeNDJSON
+	case "json":
+	case "ndjson":
+		listing.ndjson = true
+	default:
+		return listing, fmt.Errorf("invalid 'format' parameter: must be one of json, ndjson")
+	}
+
+	if v := query.Get("min_cardinality"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			return listing, fmt.Errorf("invalid 'min_cardinality' parameter")
+		}
+		listing.minCardinality = parsed
+	}
+
+	if v := query.Get("fields"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if _, known := usageFields[field]; !known {
+				return listing, fmt.Errorf("invalid 'fields' parameter: unknown field %s", field)
+			}
+			listing.fields = append(listing.fields, field)
+		}
+	}
+	return listing, nil
+}
+
+// item returns the selected fields of a metric
+func (l usageListing) item(info MetricUsageInfoResponse) interface{} {
+	if len(l.fields) == 0 {
+		return info
+	}
+	item := make(map[string]interface{}, len(l.fields))
+	for _, field := range l.fields {
+		item[field] = usageFields[field](&info)
+	}
+	return item
+}
+
+// streamMetricsUsage calls write with each tracked metric passing the filters,
+// in name order, and flushes the response every usageFlushEvery metrics. The
+// tracker is read one metric at a time, so the listing is never held in
+// memory whole. It returns how many metrics were written.
+func (h *RecommendationHandler) streamMetricsUsage(w http.ResponseWriter, listing usageListing, write func(item interface{}) error) int {
+	controller := http.NewResponseController(w)
+	written := 0
+	for _, name := range h.usageTracker.MetricNames() {
+		if !strings.HasPrefix(name, listing.prefix) {
+			continue
+		}
+		info := h.usageTracker.GetMetricInfo(name)
+		if info == nil || info.Cardinality < listing.minCardinality {
+			continue
+		}
+		if err := write(listing.item(convertToMetricUsageInfoResponse(info))); err != nil {
+			// The client went away
+			return written
+		}
+		written++
+		if written%usageFlushEvery == 0 {
+			controller.Flush()
+		}
+	}
+	return written
+}
+
+// writeMetricsUsageNDJSON streams the listing as one JSON object per line
+func (h *RecommendationHandler) writeMetricsUsageNDJSON(w http.ResponseWriter, listing usageListing) int {
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	encoder := json.NewEncoder(w)
+	return h.streamMetricsUsage(w, listing, func(item interface{}) error {
+		return encoder.Encode(item)
+	})
+}
+
+// writeMetricsUsageJSON streams the listing as a single JSON document of the
+// form {"metrics": [...], "total": n}, followed by the extra fields
+func (h *RecommendationHandler) writeMetricsUsageJSON(w http.ResponseWriter, listing usageListing, extra map[string]interface{}) int {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if _, err := w.Write([]byte(`{"metrics":[`)); err != nil {
+		return 0
+	}
+	separator := []byte{}
+	total := h.streamMetricsUsage(w, listing, func(item interface{}) error {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(separator, data...)); err != nil {
+			return err
+		}
+		separator = []byte{','}
+		return nil
+	})
+	fmt.Fprintf(w, `],"total":%d`, total)
+	for key, value := range extra {
+		data, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, `,%q:%s`, key, data)
+	}
+	w.Write([]byte("}\n"))
+	return total
+}
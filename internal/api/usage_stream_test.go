@@ -0,0 +1,80 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/metrics"
+)
+
+func newUsageListingTestHandler() *RecommendationHandler {
+	tracker := metrics.NewUsageTracker(time.Hour)
+	for _, pod := range []string{"a", "b", "c"} {
+		tracker.TrackMetric("http_requests_total", map[string]string{"pod": pod}, 1)
+	}
+	tracker.TrackMetric("http_errors_total", map[string]string{"pod": "a"}, 1)
+	tracker.TrackMetric("up", map[string]string{"job": "node"}, 1)
+	return NewRecommendationHandler(NewRecommendationStore(), tracker, nil, nil)
+}
+
+func TestListMetricsUsage_JSON(t *testing.T) {
+	h := newUsageListingTestHandler()
+
+	w := httptest.NewRecorder()
+	h.ListMetricsUsage(w, httptest.NewRequest(http.MethodGet, "/metrics-usage?prefix=http_&min_cardinality=2", nil))
+
+	var body struct {
+		Metrics []MetricUsageInfoResponse `json:"metrics"`
+		Total   int                       `json:"total"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if body.Total != 1 || len(body.Metrics) != 1 || body.Metrics[0].MetricName != "http_requests_total" {
+		t.Errorf("response = %+v, want only http_requests_total", body)
+	}
+}
+
+func TestListMetricsUsage_NDJSON(t *testing.T) {
+	h := newUsageListingTestHandler()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metrics-usage?fields=metric_name,cardinality", nil)
+	r.Header.Set("Accept", contentTypeNDJSON)
+	h.ListMetricsUsage(w, r)
+
+	if got := w.Header().Get("Content-Type"); got != contentTypeNDJSON {
+		t.Errorf("Content-Type = %s, want %s", got, contentTypeNDJSON)
+	}
+	var names []string
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var item map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		if len(item) != 2 {
+			t.Errorf("item = %v, want only the selected fields", item)
+		}
+		names = append(names, item["metric_name"].(string))
+	}
+	if len(names) != 3 || names[0] != "http_errors_total" || names[2] != "up" {
+		t.Errorf("metrics = %v, want all three in name order", names)
+	}
+}
+
+func TestListMetricsUsage_InvalidParameters(t *testing.T) {
+	h := newUsageListingTestHandler()
+
+	for _, query := range []string{"format=xml", "min_cardinality=-1", "fields=metric_name,unknown"} {
+		w := httptest.NewRecorder()
+		h.ListMetricsUsage(w, httptest.NewRequest(http.MethodGet, "/metrics-usage?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
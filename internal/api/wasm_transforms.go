@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+// SetupTransformRoutes sets up the routes for the WASM transform API
+func (h *Handler) SetupTransformRoutes(router *mux.Router) {
+	router.HandleFunc("/transforms", h.ListTransforms).Methods("GET", "OPTIONS")
+	router.HandleFunc("/transforms/{name}", h.GetTransform).Methods("GET", "OPTIONS")
+	router.HandleFunc("/transforms/{name}", h.UploadTransform).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/transforms/{name}", h.DeleteTransform).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/transforms/{name}/test", h.TestTransform).Methods("POST", "OPTIONS")
+}
+
+// ListTransforms returns all WASM transforms
+func (h *Handler) ListTransforms(w http.ResponseWriter, r *http.Request) {
+	transforms := h.ruleEngine.GetWasmTransforms()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"transforms": transforms,
+		"total":      len(transforms),
+	})
+}
+
+// GetTransform returns a WASM transform by name
+func (h *Handler) GetTransform(w http.ResponseWriter, r *http.Request) {
+	transform, err := h.ruleEngine.GetWasmTransform(mux.Vars(r)["name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transform)
+}
+
+// UploadTransform creates or replaces a WASM transform from the module in the
+// request body
+func (h *Handler) UploadTransform(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	_, getErr := h.ruleEngine.GetWasmTransform(name)
+	exists := getErr == nil
+
+	// Read one byte past the limit so larger modules are rejected by the engine
+	limit := int64(h.cfg.Aggregator.WasmTransforms.MaxModuleBytes)
+	body := r.Body
+	if limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit+1)
+	}
+	binary, err := io.ReadAll(body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "WASM module too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	transform, err := h.ruleEngine.SaveWasmTransform(name, binary)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(transform)
+}
+
+// DeleteTransform deletes a WASM transform that no rule uses
+func (h *Handler) DeleteTransform(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, err := h.ruleEngine.GetWasmTransform(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := h.ruleEngine.DeleteWasmTransform(name); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, rules.ErrWasmTransformInUse) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TestTransform runs a WASM transform on the sample in the request body and
+// returns the transformed sample, or dropped
+func (h *Handler) TestTransform(w http.ResponseWriter, r *http.Request) {
+	transform, err := h.ruleEngine.GetWasmTransform(mux.Vars(r)["name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var sample models.MetricSample
+	if err := json.NewDecoder(r.Body).Decode(&sample); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if sample.Timestamp.IsZero() {
+		sample.Timestamp = time.Now()
+	}
+
+	transformed, err := transform.Apply(&sample)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dropped": transformed == nil,
+		"sample":  transformed,
+	})
+}
@@ -0,0 +1,366 @@
+// Package backfill replays historical series through rules and writes the
+// aggregated history downstream, so adopting a rule does not leave a gap in
+// long-term dashboards
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/aggregator"
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/remote"
+)
+
+// Job statuses
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// SourceSpec selects where a job reads historical series from
+type SourceSpec struct {
+	Type    string            `json:"type"`              // prometheus or file
+	URL     string            `json:"url,omitempty"`     // Base URL of the query API for prometheus
+	Headers map[string]string `json:"headers,omitempty"` // e.g. X-Scope-OrgID for Mimir
+	Path    string            `json:"path,omitempty"`    // Dump file relative to the backfill directory
+}
+
+// OutputSpec selects where a job writes the aggregated series
+type OutputSpec struct {
+	Type string `json:"type"`           // remote_write or file
+	Path string `json:"path,omitempty"` // Output file relative to the backfill directory
+}
+
+// Request describes a backfill job
+type Request struct {
+	// RuleIDs are the rules replayed; all enabled rules if empty
+	RuleIDs []string   `json:"rule_ids"`
+	Start   time.Time  `json:"start"`
+	End     time.Time  `json:"end"`
+	Source  SourceSpec `json:"source"`
+	Output  OutputSpec `json:"output"`
+}
+
+// Job is a running or finished backfill job
+type Job struct {
+	ID            string     `json:"id"`
+	Request       Request    `json:"request"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	CurrentRule   string     `json:"current_rule,omitempty"`
+	RulesDone     int        `json:"rules_done"`
+	SamplesRead   int        `json:"samples_read"`
+	SeriesWritten int        `json:"series_written"` // Aggregated samples written downstream
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// Manager runs backfill jobs and keeps their status
+type Manager struct {
+	cfg        *config.Config
+	ruleEngine *rules.Engine
+	jobs       map[string]*Job
+	mu         sync.RWMutex
+}
+
+// NewManager creates a backfill job manager
+func NewManager(cfg *config.Config, ruleEngine *rules.Engine) *Manager {
+	return &Manager{
+		cfg:        cfg,
+		ruleEngine: ruleEngine,
+		jobs:       make(map[string]*Job),
+	}
+}
+
+// Start validates a request and starts its job in the background
+func (m *Manager) Start(req Request) (*Job, error) {
+	if !req.Start.Before(req.End) {
+		return nil, fmt.Errorf("start must be before end")
+	}
+	if req.End.After(time.Now()) {
+		return nil, fmt.Errorf("end must not be in the future")
+	}
+
+	selected, err := m.selectRules(req.RuleIDs)
+	if err != nil {
+		return nil, err
+	}
+	source, err := m.newSource(req.Source)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := m.newSink(req.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        fmt.Sprintf("backfill-%d", time.Now().UnixNano()),
+		Request:   req,
+		Status:    StatusRunning,
+		CreatedAt: time.Now(),
+		cancel:    cancel,
+	}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	go m.run(ctx, job, selected, source, sink)
+
+	return m.snapshot(job), nil
+}
+
+// Get returns a job by ID
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, exists := m.jobs[id]
+	if !exists {
+		return nil, false
+	}
+	copied := *job
+	return &copied, true
+}
+
+// List returns all jobs, newest first
+func (m *Manager) List() []*Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Cancel stops a running job
+func (m *Manager) Cancel(id string) error {
+	m.mu.RLock()
+	job, exists := m.jobs[id]
+	m.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("backfill job %s not found", id)
+	}
+	job.cancel()
+	return nil
+}
+
+// snapshot returns a copy of a job taken under the lock
+func (m *Manager) snapshot(job *Job) *Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	copied := *job
+	return &copied
+}
+
+// update changes a job under the lock
+func (m *Manager) update(job *Job, fn func(job *Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(job)
+}
+
+// selectRules returns the rules of a job. Shadow rules are not replayed
+// since their output is never written.
+func (m *Manager) selectRules(ids []string) ([]*models.Rule, error) {
+	if len(ids) == 0 {
+		all, err := m.ruleEngine.GetRules()
+		if err != nil {
+			return nil, err
+		}
+		var selected []*models.Rule
+		for _, rule := range all {
+			if rule.Enabled && !rule.Shadow {
+				selected = append(selected, rule)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("no enabled rules to backfill")
+		}
+		return selected, nil
+	}
+
+	selected := make([]*models.Rule, 0, len(ids))
+	for _, id := range ids {
+		rule, err := m.ruleEngine.GetRule(id)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Shadow {
+			return nil, fmt.Errorf("rule %s is a shadow rule, its output is never written", id)
+		}
+		selected = append(selected, rule)
+	}
+	return selected, nil
+}
+
+// newSource creates the source of a job
+func (m *Manager) newSource(spec SourceSpec) (Source, error) {
+	switch spec.Type {
+	case SourcePrometheus:
+		if spec.URL == "" {
+			return nil, fmt.Errorf("source url is required")
+		}
+		return &PrometheusSource{
+			URL:        spec.URL,
+			Headers:    spec.Headers,
+			Chunk:      time.Duration(m.cfg.Backfill.ChunkSeconds) * time.Second,
+			HTTPClient: &http.Client{Timeout: time.Duration(m.cfg.Backfill.TimeoutSeconds) * time.Second},
+		}, nil
+	case SourceFile:
+		path, err := m.filePath(spec.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &FileSource{Path: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown source type %q: must be one of %s, %s", spec.Type, SourcePrometheus, SourceFile)
+	}
+}
+
+// newSink creates the output of a job
+func (m *Manager) newSink(spec OutputSpec) (Sink, error) {
+	switch spec.Type {
+	case OutputRemoteWrite:
+		client, err := remote.NewClient(&m.cfg.RemoteWrite)
+		if err != nil {
+			return nil, err
+		}
+		return &remoteWriteSink{client: client}, nil
+	case OutputFile:
+		path, err := m.filePath(spec.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &fileSink{path: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown output type %q: must be one of %s, %s", spec.Type, OutputRemoteWrite, OutputFile)
+	}
+}
+
+// filePath resolves a job file path within the backfill directory
+func (m *Manager) filePath(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	cleaned := filepath.Clean(path)
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q must be relative to the backfill directory", path)
+	}
+	return filepath.Join(m.cfg.Backfill.Directory, cleaned), nil
+}
+
+// run replays each rule over the time range of a job
+func (m *Manager) run(ctx context.Context, job *Job, selected []*models.Rule, source Source, sink Sink) {
+	logger.LogInfoWithFields("Starting backfill job", logger.Fields{
+		"job_id": job.ID,
+		"rules":  len(selected),
+		"start":  job.Request.Start,
+		"end":    job.Request.End,
+	})
+
+	var err error
+	for _, rule := range selected {
+		m.update(job, func(job *Job) { job.CurrentRule = rule.ID })
+		if err = m.replay(ctx, job, rule, source, sink); err != nil {
+			err = fmt.Errorf("rule %s: %w", rule.ID, err)
+			break
+		}
+		m.update(job, func(job *Job) { job.RulesDone++ })
+	}
+	if closeErr := sink.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write output: %w", closeErr)
+	}
+
+	m.update(job, func(job *Job) {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.CurrentRule = ""
+		switch {
+		case ctx.Err() != nil:
+			job.Status = StatusCancelled
+		case err != nil:
+			job.Status = StatusFailed
+			job.Error = err.Error()
+		default:
+			job.Status = StatusCompleted
+		}
+	})
+	job.cancel()
+
+	fields := logger.Fields{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"samples_read":   job.SamplesRead,
+		"series_written": job.SeriesWritten,
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+		logger.LogErrorWithFields("Backfill job failed", fields)
+		return
+	}
+	logger.LogInfoWithFields("Backfill job finished", fields)
+}
+
+// replay aggregates the history of one rule and writes it in batches
+func (m *Manager) replay(ctx context.Context, job *Job, rule *models.Rule, source Source, sink Sink) error {
+	replayer := aggregator.NewReplayer(rule, m.cfg.ExternalLabels, m.cfg.Aggregator.OutputTimestamp)
+	if rule.WasmTransform != "" {
+		transform, err := m.ruleEngine.GetWasmTransform(rule.WasmTransform)
+		if err != nil {
+			return err
+		}
+		replayer.SetWasmTransform(transform)
+	}
+	write := func(outputs []*models.AggregatedMetric) error {
+		for len(outputs) > 0 {
+			n := min(len(outputs), max(1, m.cfg.Backfill.BatchSize))
+			if err := sink.Write(ctx, outputs[:n]); err != nil {
+				return err
+			}
+			m.update(job, func(job *Job) { job.SeriesWritten += n })
+			outputs = outputs[n:]
+		}
+		return nil
+	}
+
+	err := source.Read(ctx, rule, job.Request.Start, job.Request.End, func(samples []*models.MetricSample, through time.Time) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, sample := range samples {
+			replayer.Add(sample)
+		}
+		m.update(job, func(job *Job) { job.SamplesRead += len(samples) })
+		return write(replayer.Flush(through))
+	})
+	if err != nil {
+		return err
+	}
+
+	// The last bucket may end after the time range
+	interval := time.Duration(rule.Aggregation.IntervalSeconds) * time.Second
+	return write(replayer.Flush(job.Request.End.Add(interval)))
+}
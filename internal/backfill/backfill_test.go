@@ -0,0 +1,128 @@
+package backfill
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func testRule() *models.Rule {
+	return &models.Rule{
+		ID: "rule",
+		Matcher: models.MetricMatcher{
+			MetricNames: []string{"http_requests_*"},
+			Labels:      map[string]string{"job": "api"},
+			LabelRegex:  map[string]string{"path": "^/v1"},
+		},
+		Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 60},
+		Output:      models.OutputConfig{MetricName: "http_requests:sum"},
+	}
+}
+
+func TestRuleMatchers_Selector(t *testing.T) {
+	matchers, err := ruleMatchers(testRule())
+	if err != nil {
+		t.Fatalf("ruleMatchers() error = %v", err)
+	}
+	want := `{__name__=~"http_requests_.*",job="api",path=~".*(?:^/v1).*"}`
+	if got := selector(matchers); got != want {
+		t.Errorf("selector() = %s, want %s", got, want)
+	}
+}
+
+func TestPrometheusSource_Read(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"__name__":"http_requests_total","job":"api","path":"/v1"},"values":[[1700000000,"1"],[1700000030,"2"]]}
+		]}}`))
+	}))
+	defer server.Close()
+
+	source := &PrometheusSource{URL: server.URL, Chunk: time.Hour, HTTPClient: server.Client()}
+	start := time.Unix(1700000000, 0)
+
+	var samples []*models.MetricSample
+	var through []time.Time
+	err := source.Read(context.Background(), testRule(), start, start.Add(90*time.Minute), func(batch []*models.MetricSample, t time.Time) error {
+		samples = append(samples, batch...)
+		through = append(through, t)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	if len(queries) != 2 || !strings.HasSuffix(queries[0], "[1h]") || !strings.HasSuffix(queries[1], "[30m]") {
+		t.Errorf("queries = %v, want one range selector per chunk", queries)
+	}
+	if len(through) != 2 || !through[1].Equal(start.Add(90*time.Minute)) {
+		t.Errorf("chunks read through %v, want the end of the range last", through)
+	}
+	if len(samples) != 4 || samples[1].Value != 2 || samples[0].Labels["path"] != "/v1" {
+		t.Fatalf("samples = %d, want the raw samples of both chunks", len(samples))
+	}
+	if samples[0].Temporality != models.TemporalityCumulative || samples[0].Timestamp.Unix() != 1700000000 {
+		t.Errorf("sample = %+v, want a cumulative sample at its timestamp", samples[0])
+	}
+}
+
+func TestManager_FileBackfill(t *testing.T) {
+	dir := t.TempDir()
+	dump := `# TYPE http_requests counter
+http_requests_total{job="api",path="/v1/users"} 1 1700000000.000
+http_requests_total{job="api",path="/v2/users"} 7 1700000000.000
+http_requests_total{job="api",path="/v1/users"} 4 1700000030.000
+http_requests_total{job="api",path="/v1/users"} 9 1700000060.000
+# EOF
+`
+	if err := os.WriteFile(filepath.Join(dir, "dump.om"), []byte(dump), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+	job := &Job{ID: "job", Request: Request{Start: start, End: start.Add(2 * time.Minute)}}
+	m := &Manager{cfg: &config.Config{Backfill: config.BackfillConfig{Directory: dir, BatchSize: 10}}, jobs: map[string]*Job{"job": job}}
+
+	if _, err := m.filePath("../etc/passwd"); err == nil {
+		t.Error("filePath() accepted a path outside the backfill directory")
+	}
+	source, err := m.newSource(SourceSpec{Type: SourceFile, Path: "dump.om"})
+	if err != nil {
+		t.Fatalf("newSource() error = %v", err)
+	}
+	sink, err := m.newSink(OutputSpec{Type: OutputFile, Path: "out.om"})
+	if err != nil {
+		t.Fatalf("newSink() error = %v", err)
+	}
+
+	if err := m.replay(context.Background(), job, testRule(), source, sink); err != nil {
+		t.Fatalf("replay() error = %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if job.SamplesRead != 3 {
+		t.Errorf("read %d samples, want the 3 samples of matching series", job.SamplesRead)
+	}
+
+	output, err := os.ReadFile(filepath.Join(dir, "out.om"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(output), "# TYPE http_requests:sum unknown\n") || !strings.HasSuffix(string(output), "# EOF\n") {
+		t.Errorf("output is not an OpenMetrics file:\n%s", output)
+	}
+	if !strings.Contains(string(output), "http_requests:sum 8 ") {
+		t.Errorf("output misses the cumulative increase of 8:\n%s", output)
+	}
+}
@@ -0,0 +1,128 @@
+package backfill
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/remote"
+)
+
+// Output types of backfill jobs
+const (
+	// OutputRemoteWrite writes to the configured remote write endpoints; they
+	// must accept out-of-order samples for the backfilled range
+	OutputRemoteWrite = "remote_write"
+	// OutputFile writes an OpenMetrics file for promtool tsdb
+	// create-blocks-from openmetrics
+	OutputFile = "file"
+)
+
+// Sink receives the aggregated series of a backfill job
+type Sink interface {
+	Write(ctx context.Context, metrics []*models.AggregatedMetric) error
+	Close() error
+}
+
+// remoteWriteSink writes aggregated series with remote write
+type remoteWriteSink struct {
+	client *remote.Client
+}
+
+func (s *remoteWriteSink) Write(ctx context.Context, metrics []*models.AggregatedMetric) error {
+	return s.client.Send(ctx, metrics)
+}
+
+func (s *remoteWriteSink) Close() error {
+	return nil
+}
+
+// fileSink collects aggregated series and writes them as an OpenMetrics file
+// on close, since metric families must not be interleaved in the format
+type fileSink struct {
+	path    string
+	metrics []*models.AggregatedMetric
+}
+
+func (s *fileSink) Write(_ context.Context, metrics []*models.AggregatedMetric) error {
+	s.metrics = append(s.metrics, metrics...)
+	return nil
+}
+
+// Close writes the file, grouping samples by metric and series in time order
+func (s *fileSink) Close() error {
+	type point struct {
+		series string
+		metric *models.AggregatedMetric
+	}
+	points := make([]point, 0, len(s.metrics))
+	for _, metric := range s.metrics {
+		points = append(points, point{series: seriesString(metric), metric: metric})
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].metric.Name != points[j].metric.Name {
+			return points[i].metric.Name < points[j].metric.Name
+		}
+		if points[i].series != points[j].series {
+			return points[i].series < points[j].series
+		}
+		return points[i].metric.SampleTime().Before(points[j].metric.SampleTime())
+	})
+
+	file, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+
+	family := ""
+	for _, p := range points {
+		if p.metric.Name != family {
+			family = p.metric.Name
+			fmt.Fprintf(w, "# TYPE %s unknown\n", family)
+		}
+		fmt.Fprintf(w, "%s %s %s\n", p.series,
+			strconv.FormatFloat(p.metric.Value, 'g', -1, 64),
+			strconv.FormatFloat(float64(p.metric.SampleTime().UnixMilli())/1000, 'f', 3, 64))
+	}
+	fmt.Fprint(w, "# EOF\n")
+
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// labelValueEscaper escapes label values in the OpenMetrics format
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// seriesString formats the name and labels of an aggregated series
+func seriesString(metric *models.AggregatedMetric) string {
+	if len(metric.Labels) == 0 {
+		return metric.Name
+	}
+
+	names := make([]string, 0, len(metric.Labels))
+	for name := range metric.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(metric.Name)
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelValueEscaper.Replace(metric.Labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
@@ -0,0 +1,300 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+)
+
+// Source types of backfill jobs
+const (
+	// SourcePrometheus reads from a Prometheus-compatible query API
+	SourcePrometheus = "prometheus"
+	// SourceFile reads an OpenMetrics dump file
+	SourceFile = "file"
+)
+
+// Source reads the historical samples of the series a rule matches. It
+// passes them to fn in batches, in time order per series, with the time up
+// to which all samples have been read.
+type Source interface {
+	Read(ctx context.Context, rule *models.Rule, start, end time.Time, fn func(samples []*models.MetricSample, through time.Time) error) error
+}
+
+// ruleMatchers converts the matcher of a rule to series matchers. Metric name
+// globs and label regexes keep their meaning: PromQL regexes are anchored,
+// while label regexes of rules match anywhere in the value.
+func ruleMatchers(rule *models.Rule) ([]*labels.Matcher, error) {
+	names := make([]string, 0, len(rule.Matcher.MetricNames))
+	for _, name := range rule.Matcher.Names() {
+		names = append(names, strings.ReplaceAll(regexp.QuoteMeta(name), `\*`, ".*"))
+	}
+	nameMatcher, err := labels.NewMatcher(labels.MatchRegexp, labels.MetricName, strings.Join(names, "|"))
+	if err != nil {
+		return nil, err
+	}
+	matchers := []*labels.Matcher{nameMatcher}
+
+	for name, value := range rule.Matcher.Labels {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, name, value))
+	}
+	for name, pattern := range rule.Matcher.LabelRegex {
+		matcher, err := labels.NewMatcher(labels.MatchRegexp, name, ".*(?:"+pattern+").*")
+		if err != nil {
+			return nil, fmt.Errorf("invalid label regex for %s: %w", name, err)
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	// Deterministic selectors for logs and tests
+	sort.Slice(matchers[1:], func(i, j int) bool { return matchers[i+1].Name < matchers[j+1].Name })
+	return matchers, nil
+}
+
+// selector returns the PromQL series selector of matchers
+func selector(matchers []*labels.Matcher) string {
+	parts := make([]string, 0, len(matchers))
+	for _, matcher := range matchers {
+		parts = append(parts, matcher.String())
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// PrometheusSource reads raw samples from the query API of Prometheus, Mimir
+// or another compatible server, one chunk of the time range at a time
+type PrometheusSource struct {
+	URL        string
+	Headers    map[string]string
+	Chunk      time.Duration
+	HTTPClient *http.Client
+}
+
+// queryResponse is the response of the Prometheus instant query API for a
+// range vector selector
+type queryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string  `json:"metric"`
+			Values []model.SamplePair `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// Read implements Source. Each chunk is read with a range vector selector,
+// which returns the raw samples of the chunk rather than evaluated points.
+func (s *PrometheusSource) Read(ctx context.Context, rule *models.Rule, start, end time.Time, fn func([]*models.MetricSample, time.Time) error) error {
+	matchers, err := ruleMatchers(rule)
+	if err != nil {
+		return err
+	}
+	series := selector(matchers)
+
+	for chunkStart := start; chunkStart.Before(end); chunkStart = chunkStart.Add(s.Chunk) {
+		chunkEnd := chunkStart.Add(s.Chunk)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+
+		query := fmt.Sprintf("%s[%s]", series, model.Duration(chunkEnd.Sub(chunkStart)))
+		samples, err := s.query(ctx, query, chunkEnd)
+		if err != nil {
+			return fmt.Errorf("failed to query %s at %s: %w", query, chunkEnd.Format(time.RFC3339), err)
+		}
+		if err := fn(samples, chunkEnd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// query runs an instant query returning a matrix and converts it to samples
+func (s *PrometheusSource) query(ctx context.Context, query string, at time.Time) ([]*models.MetricSample, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatFloat(float64(at.UnixMilli())/1000, 'f', 3, 64))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(s.URL, "/")+"/api/v1/query?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result queryResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("unexpected response with status code %d: %s", resp.StatusCode, truncate(body, 1024))
+	}
+	if result.Status != "success" {
+		return nil, errors.New(result.Error)
+	}
+	if result.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("unexpected result type %s", result.Data.ResultType)
+	}
+
+	var samples []*models.MetricSample
+	for _, series := range result.Data.Result {
+		name := series.Metric[labels.MetricName]
+		sampleLabels := make(map[string]string, len(series.Metric))
+		for k, v := range series.Metric {
+			if k != labels.MetricName {
+				sampleLabels[k] = v
+			}
+		}
+		for _, point := range series.Values {
+			samples = append(samples, &models.MetricSample{
+				Name:        name,
+				Value:       float64(point.Value),
+				Timestamp:   point.Timestamp.Time(),
+				Labels:      sampleLabels,
+				Temporality: models.NameTemporality(name),
+			})
+		}
+	}
+
+	return samples, nil
+}
+
+// FileSource reads samples from an OpenMetrics dump file, as produced by
+// exporting series with timestamps. The whole file is read for each rule.
+type FileSource struct {
+	Path string
+}
+
+// Read implements Source
+func (s *FileSource) Read(ctx context.Context, rule *models.Rule, start, end time.Time, fn func([]*models.MetricSample, time.Time) error) error {
+	matchers, err := ruleMatchers(rule)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return err
+	}
+
+	types := make(map[string]model.MetricType)
+	var samples []*models.MetricSample
+	parser := textparse.NewOpenMetricsParser(data, labels.NewSymbolTable())
+	for {
+		entry, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", s.Path, err)
+		}
+
+		switch entry {
+		case textparse.EntryType:
+			family, metricType := parser.Type()
+			types[string(family)] = metricType
+			continue
+		case textparse.EntrySeries:
+		default:
+			continue
+		}
+
+		_, ts, value := parser.Series()
+		if ts == nil {
+			continue // Samples without a timestamp cannot be placed in history
+		}
+		timestamp := time.UnixMilli(*ts)
+		if timestamp.Before(start) || !timestamp.Before(end) {
+			continue
+		}
+
+		var lset labels.Labels
+		parser.Metric(&lset)
+		if !matchesAll(lset, matchers) {
+			continue
+		}
+
+		name := lset.Get(labels.MetricName)
+		samples = append(samples, &models.MetricSample{
+			Name:        name,
+			Value:       value,
+			Timestamp:   timestamp,
+			Labels:      lset.DropMetricName().Map(),
+			Temporality: fileTemporality(name, types),
+		})
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+	return fn(samples, end)
+}
+
+// fileTemporality returns the temporality of a series from the type of its
+// metric family, or from its name for untyped families
+func fileTemporality(name string, types map[string]model.MetricType) string {
+	if metricType, exists := types[name]; exists {
+		if metricType == model.MetricTypeCounter {
+			return models.TemporalityCumulative
+		}
+		if metricType != model.MetricTypeUnknown {
+			return "" // Gauges and summary quantiles
+		}
+	}
+	for _, suffix := range []string{"_total", "_bucket", "_count", "_sum"} {
+		family := strings.TrimSuffix(name, suffix)
+		if family == name {
+			continue
+		}
+		switch types[family] {
+		case model.MetricTypeCounter, model.MetricTypeHistogram, model.MetricTypeSummary:
+			return models.TemporalityCumulative
+		case model.MetricTypeGauge, model.MetricTypeGaugeHistogram:
+			return ""
+		}
+	}
+	return models.NameTemporality(name)
+}
+
+// matchesAll reports whether a label set matches all matchers
+func matchesAll(lset labels.Labels, matchers []*labels.Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(lset.Get(matcher.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// truncate returns at most n bytes of a response body for error messages
+func truncate(body []byte, n int) string {
+	if len(body) > n {
+		body = body[:n]
+	}
+	return string(body)
+}
@@ -17,6 +17,36 @@ type Config struct {
 	Plugin      PluginConfig      `mapstructure:"plugin"`
 	RemoteWrite RemoteWriteConfig `mapstructure:"remote_write"`
 	Logging     LoggingConfig     `mapstructure:"logging"`
+	Usage       UsageConfig       `mapstructure:"usage"`
+	Sharding    ShardingConfig    `mapstructure:"sharding"`
+	Ingest      IngestConfig      `mapstructure:"ingest"`
+	Tracing     TracingConfig     `mapstructure:"tracing"`
+	Profiling   ProfilingConfig   `mapstructure:"profiling"`
+	Memory      MemoryConfig      `mapstructure:"memory"`
+	Temporality TemporalityConfig `mapstructure:"temporality"`
+	// Recommendations holds the initial recommendation engine thresholds;
+	// they can be changed at runtime through the API
+	Recommendations RecommendationsConfig `mapstructure:"recommendations"`
+	// Protection lists metrics and labels that must never be aggregated or dropped;
+	// it can be changed at runtime through the API
+	Protection ProtectionConfig `mapstructure:"protection"`
+	// OutputNaming is the naming policy of the output metrics of rules
+	OutputNaming OutputNamingConfig `mapstructure:"output_naming"`
+	// IDs is how the IDs of new rules and templates are generated
+	IDs        IDConfig         `mapstructure:"ids"`
+	Lint       LintConfig       `mapstructure:"lint"`
+	Promotion  PromotionConfig  `mapstructure:"promotion"`
+	Ownership  OwnershipConfig  `mapstructure:"ownership"`
+	Reports    ReportsConfig    `mapstructure:"reports"`
+	Federation FederationConfig `mapstructure:"federation"`
+	Backfill   BackfillConfig   `mapstructure:"backfill"`
+	GitOps     GitOpsConfig     `mapstructure:"gitops"`
+	Kubernetes KubernetesConfig `mapstructure:"kubernetes"`
+	// ControlPlane manages the rules of fleets of instances from a central one
+	ControlPlane ControlPlaneConfig `mapstructure:"control_plane"`
+	// ExternalLabels are added to every aggregated series unless the series
+	// already has the label, like Prometheus external_labels
+	ExternalLabels map[string]string `mapstructure:"external_labels"`
 }
 
 // ServerConfig represents the server configuration
@@ -26,6 +56,111 @@ type ServerConfig struct {
 	ReadTimeoutSeconds  int    `mapstructure:"read_timeout_seconds"`
 	WriteTimeoutSeconds int    `mapstructure:"write_timeout_seconds"`
 	WebUIPath           string `mapstructure:"web_ui_path"`
+	// Compression gzips responses for clients accepting it
+	Compression bool `mapstructure:"compression"`
+	// IdleTimeoutSeconds closes keep-alive connections idle for longer
+	IdleTimeoutSeconds int `mapstructure:"idle_timeout_seconds"`
+	// ShutdownTimeoutSeconds bounds the whole shutdown sequence: draining
+	// ingestion, the processor, remote write and in-flight requests
+	ShutdownTimeoutSeconds int `mapstructure:"shutdown_timeout_seconds"`
+	// UserHeader identifies the user making API changes, as recorded in rule history
+	UserHeader string `mapstructure:"user_header"`
+	// Readiness configures the dependency probes of /ready
+	Readiness ReadinessConfig `mapstructure:"readiness"`
+	// RateLimit limits the request rate of each client to the API
+	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	// Auth protects the main listener: the management API and the web UI
+	Auth ListenerAuthConfig `mapstructure:"auth"`
+	// TLS serves the main listener over HTTPS
+	TLS ListenerTLSConfig `mapstructure:"tls"`
+	// RedirectHTTPAddress is a plain HTTP address, e.g. ":80", redirecting
+	// requests to the HTTPS main listener; requires TLS
+	RedirectHTTPAddress string `mapstructure:"redirect_http_address"`
+	// Ingest serves remote write on its own listener when its address is set
+	Ingest ListenerConfig `mapstructure:"ingest"`
+	// Metrics serves /metrics on its own listener when its address is set
+	Metrics ListenerConfig `mapstructure:"metrics"`
+	// DebugDumpDir is where debug dumps are written on SIGQUIT; the
+	// system temporary directory when empty
+	DebugDumpDir string `mapstructure:"debug_dump_dir"`
+	// DebugDumpKeep is the number of debug dumps kept, the oldest being
+	// deleted; 0 keeps all of them
+	DebugDumpKeep int `mapstructure:"debug_dump_keep"`
+	// DebugDumpIntervalSeconds is the least time between debug dumps
+	// requested through the API
+	DebugDumpIntervalSeconds int `mapstructure:"debug_dump_interval_seconds"`
+}
+
+// ListenerConfig represents an additional HTTP listener serving part of the
+// endpoints, so they can be exposed apart from the management API
+type ListenerConfig struct {
+	// Address to listen on, e.g. ":9091"; empty serves the endpoints on the
+	// main listener
+	Address string             `mapstructure:"address"`
+	TLS     ListenerTLSConfig  `mapstructure:"tls"`
+	Auth    ListenerAuthConfig `mapstructure:"auth"`
+}
+
+// ListenerTLSConfig serves a listener over HTTPS when a certificate is set.
+// The certificate and key are reloaded when their files change.
+type ListenerTLSConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ClientCAFile requires clients to present a certificate signed by one
+	// of its CAs (mutual TLS)
+	ClientCAFile string `mapstructure:"client_ca_file"`
+}
+
+// ListenerAuthConfig requires requests to a listener to carry a bearer token
+// or basic auth credentials; with both set either is accepted, with neither
+// requests are not authenticated
+type ListenerAuthConfig struct {
+	BearerToken     string `mapstructure:"bearer_token"`
+	BearerTokenFile string `mapstructure:"bearer_token_file"`
+	Username        string `mapstructure:"username"`
+	Password        string `mapstructure:"password"`
+	PasswordFile    string `mapstructure:"password_file"`
+}
+
+// RateLimitConfig represents the per-client request rate limits
+type RateLimitConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// By identifies clients: ip, tenant or api_key; requests without a
+	// tenant or API key, or that are not authenticated, are limited by IP
+	By string `mapstructure:"by"`
+	// APIKeyHeader holds the API key of a request when limiting by api_key
+	APIKeyHeader string `mapstructure:"api_key_header"`
+	// TrustForwardedFor takes the client IP from X-Forwarded-For, for
+	// deployments behind a proxy
+	TrustForwardedFor bool `mapstructure:"trust_forwarded_for"`
+	// Ingest limits remote write, agent, CloudWatch and Pushgateway requests
+	Ingest RequestLimitConfig `mapstructure:"ingest"`
+	// API limits the other API requests
+	API RequestLimitConfig `mapstructure:"api"`
+	// ClientLimits overrides the limits for specific clients
+	ClientLimits map[string]ClientLimitConfig `mapstructure:"client_limits"`
+}
+
+// RequestLimitConfig represents a token bucket request limit
+type RequestLimitConfig struct {
+	// RequestsPerSecond is the sustained request rate; 0 disables limiting
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	// Burst is the number of requests a client can send at once above the rate
+	Burst int `mapstructure:"burst"`
+}
+
+// ClientLimitConfig represents the request limits of a single client
+type ClientLimitConfig struct {
+	Ingest RequestLimitConfig `mapstructure:"ingest"`
+	API    RequestLimitConfig `mapstructure:"api"`
+}
+
+// ReadinessConfig represents the dependency probes of the readiness check
+type ReadinessConfig struct {
+	// ResolveRemoteWrite checks that the hosts of the remote write endpoints resolve
+	ResolveRemoteWrite bool `mapstructure:"resolve_remote_write"`
+	// TimeoutSeconds bounds each network probe
+	TimeoutSeconds int `mapstructure:"timeout_seconds"`
 }
 
 // AggregatorConfig represents the metrics aggregation configuration
@@ -34,6 +169,97 @@ type AggregatorConfig struct {
 	AggregationDelayMs int    `mapstructure:"aggregation_delay_ms"`
 	WorkerCount        int    `mapstructure:"worker_count"`
 	RulesPath          string `mapstructure:"rules_path"`
+	// OverflowPolicy applies when the input queue is full: drop, drop_oldest, block or reject
+	OverflowPolicy string `mapstructure:"overflow_policy"`
+	// OverflowTimeoutMs is how long the block policy waits for room in the queue
+	OverflowTimeoutMs int `mapstructure:"overflow_timeout_ms"`
+	// DeadMansSwitchIntervals is how many aggregation intervals a rule may receive
+	// input without producing output before it stops dropping original metrics; 0 disables
+	DeadMansSwitchIntervals int `mapstructure:"dead_mans_switch_intervals"`
+	// CounterStateTTLSeconds is how long the last value of a counter series is kept after it stops reporting
+	CounterStateTTLSeconds int `mapstructure:"counter_state_ttl_seconds"`
+	// CounterStateMaxSeries caps the counter series whose last value is kept; 0 is unlimited
+	CounterStateMaxSeries int `mapstructure:"counter_state_max_series"`
+	// CounterStateEviction applies when the cap is reached: oldest or new
+	CounterStateEviction string `mapstructure:"counter_state_eviction"`
+	// OutputTimestamp places the output sample of an interval at its end,
+	// start or midpoint
+	OutputTimestamp string `mapstructure:"output_timestamp"`
+	// FlushJitterMs spreads the flushes of rules over this window after the
+	// aggregation delay, so that rules with the same interval do not all
+	// flush at once
+	FlushJitterMs int `mapstructure:"flush_jitter_ms"`
+	// WorkerScaling sizes the worker pool with the load; WorkerCount is used
+	// when it is disabled
+	WorkerScaling WorkerScalingConfig `mapstructure:"worker_scaling"`
+	// DryStatistics counts what disabled rules would have matched
+	DryStatistics DryStatisticsConfig `mapstructure:"dry_statistics"`
+	// CatchAll aggregates high cardinality metrics no rule matches
+	CatchAll CatchAllConfig `mapstructure:"catch_all"`
+	// TransformPlugins are Go plugins changing samples before aggregation
+	// and aggregated series before output, applied in order
+	TransformPlugins []TransformPluginConfig `mapstructure:"transform_plugins"`
+	// WasmTransforms bounds the WASM modules rules apply to their samples
+	WasmTransforms WasmTransformsConfig `mapstructure:"wasm_transforms"`
+}
+
+// WasmTransformsConfig represents the limits of the WASM transforms uploaded
+// through the API
+type WasmTransformsConfig struct {
+	// MaxModuleBytes is the largest module that can be uploaded
+	MaxModuleBytes int `mapstructure:"max_module_bytes"`
+	// MaxMemoryMB is the largest memory of an instance of a module
+	MaxMemoryMB int `mapstructure:"max_memory_mb"`
+	// MaxInstructions is the number of instructions a module may execute
+	// per sample, beyond which the sample is dropped; it must be greater
+	// than 0
+	MaxInstructions int64 `mapstructure:"max_instructions"`
+	// MaxLocals is the number of locals of the functions a module has
+	// called at once, beyond which the sample is dropped; it must be
+	// greater than 0
+	MaxLocals int `mapstructure:"max_locals"`
+}
+
+// TransformPluginConfig represents a transform plugin to load
+type TransformPluginConfig struct {
+	// Path of the plugin, built with -buildmode=plugin
+	Path string `mapstructure:"path"`
+	// Params are passed to the Init function of the plugin
+	Params map[string]string `mapstructure:"params"`
+}
+
+// CatchAllConfig represents the built-in rule that aggregates the metrics no
+// other rule matches once they have enough series
+type CatchAllConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MinCardinality is the number of series a metric needs to be aggregated
+	MinCardinality int `mapstructure:"min_cardinality"`
+	// Segmentation is the labels the aggregated series keep
+	Segmentation []string `mapstructure:"segmentation"`
+	// Aggregation is the aggregation type: sum, avg, min, max, count, last or first
+	Aggregation     string `mapstructure:"aggregation"`
+	IntervalSeconds int    `mapstructure:"interval_seconds"`
+}
+
+// DryStatisticsConfig represents the counting of samples and series that
+// disabled rules would have matched over the last hour
+type DryStatisticsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxSeries caps the series counted per rule; 0 is unlimited
+	MaxSeries int `mapstructure:"max_series"`
+}
+
+// WorkerScalingConfig represents the adaptive sizing of the worker pool
+type WorkerScalingConfig struct {
+	Enabled    bool `mapstructure:"enabled"`
+	MinWorkers int  `mapstructure:"min_workers"`
+	// MaxWorkers bounds the pool; 0 allows 4 workers per CPU
+	MaxWorkers int `mapstructure:"max_workers"`
+	// IntervalMs is how often the pool is resized
+	IntervalMs int `mapstructure:"interval_ms"`
+	// TargetQueueWaitMs is the average time samples may wait in the input
+	// queue before workers are added
+	TargetQueueWaitMs int `mapstructure:"target_queue_wait_ms"`
 }
 
 // StorageConfig represents the storage configuration
@@ -47,6 +273,44 @@ type PluginConfig struct {
 	Enabled   bool   `mapstructure:"enabled"`
 	APIURL    string `mapstructure:"api_url"`
 	AuthToken string `mapstructure:"auth_token"`
+	// AuthTokenFile is read for the auth token instead of setting it in the config
+	AuthTokenFile string `mapstructure:"auth_token_file"`
+	// SyncIntervalSeconds is how often recommendations are imported from
+	// Grafana and local decisions on them reported back; 0 disables syncing
+	SyncIntervalSeconds int `mapstructure:"sync_interval_seconds"`
+	// RuleSync keeps the local rules and the rules of the plugin in sync
+	RuleSync RuleSyncConfig `mapstructure:"rule_sync"`
+}
+
+// RuleSyncConfig represents the two-way rule synchronization with the Grafana plugin
+type RuleSyncConfig struct {
+	Enabled         bool `mapstructure:"enabled"`
+	IntervalSeconds int  `mapstructure:"interval_seconds"`
+	// ConflictPolicy resolves rules changed on both sides since the last
+	// sync: prefer-local, prefer-remote or manual
+	ConflictPolicy string `mapstructure:"conflict_policy"`
+	// StateFile keeps the revisions of the last sync across restarts
+	StateFile string `mapstructure:"state_file"`
+}
+
+// RemoteWriteEndpointOptions represents the settings of a single remote write endpoint
+type RemoteWriteEndpointOptions struct {
+	// Name identifies the endpoint in the remote_write_target of rules
+	Name string `mapstructure:"name"`
+	// URL is the endpoint, as listed in endpoints. Endpoints not listed in
+	// endpoints only receive the metrics of rules routed to them.
+	URL         string `mapstructure:"url"`
+	Compression string `mapstructure:"compression"`
+}
+
+// RemoteWriteCircuitBreakerConfig represents the per-endpoint circuit breakers of remote write
+type RemoteWriteCircuitBreakerConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// FailureThreshold is the number of consecutive failed requests, retries
+	// included, that opens the circuit of an endpoint
+	FailureThreshold int `mapstructure:"failure_threshold"`
+	// OpenSeconds is how long an open circuit skips its endpoint before a probe request
+	OpenSeconds int `mapstructure:"open_seconds"`
 }
 
 // RemoteWriteConfig represents the Prometheus remote write configuration
@@ -55,15 +319,41 @@ type RemoteWriteConfig struct {
 	Endpoints     []string          `mapstructure:"endpoints"`
 	Username      string            `mapstructure:"username"`
 	Password      string            `mapstructure:"password"`
+	PasswordFile  string            `mapstructure:"password_file"`
 	Headers       map[string]string `mapstructure:"headers"`
 	MaxRetries    int               `mapstructure:"max_retries"`
 	RetryInterval int               `mapstructure:"retry_interval_seconds"`
 	BatchSize     int               `mapstructure:"batch_size"`
 	Timeout       int               `mapstructure:"timeout_seconds"`
+	// Compression is the codec of write requests: snappy or zstd. Endpoints
+	// answering zstd requests with 415 fall back to snappy.
+	Compression string `mapstructure:"compression"`
+	// EndpointOptions overrides settings for specific endpoints
+	EndpointOptions []RemoteWriteEndpointOptions `mapstructure:"endpoint_options"`
+	// CircuitBreaker stops sending to endpoints that keep failing
+	CircuitBreaker RemoteWriteCircuitBreakerConfig `mapstructure:"circuit_breaker"`
+	// TenantHeader is the request header carrying the tenant of rules with a
+	// remote_write_target tenant
+	TenantHeader string `mapstructure:"tenant_header"`
 	// Controls whether to write only metrics from recommendations or all metrics
 	RecommendationMetricsOnly bool `mapstructure:"recommendation_metrics_only"`
 }
 
+// EndpointURL resolves the name or URL of a remote write endpoint to its URL
+func (c *RemoteWriteConfig) EndpointURL(endpoint string) (string, bool) {
+	for _, options := range c.EndpointOptions {
+		if options.URL != "" && (options.Name == endpoint || options.URL == endpoint) {
+			return options.URL, true
+		}
+	}
+	for _, url := range c.Endpoints {
+		if url == endpoint {
+			return url, true
+		}
+	}
+	return "", false
+}
+
 // LoggingConfig represents the logging configuration
 type LoggingConfig struct {
 	// Format determines the log output format: "json" or "text"
@@ -76,6 +366,510 @@ type LoggingConfig struct {
 	IncludeCaller bool `mapstructure:"include_caller"`
 	// File is the path to a log file (optional - logs to stdout if not specified)
 	File string `mapstructure:"file"`
+	// SampleIntervalSeconds is how often repetitive hot-path warnings (such as dropped metrics) are logged
+	SampleIntervalSeconds int `mapstructure:"sample_interval_seconds"`
+	// AccessLog logs every HTTP request; health checks and metric scrapes
+	// are only logged at debug level
+	AccessLog bool `mapstructure:"access_log"`
+}
+
+// UsageConfig represents the metric usage tracking configuration
+type UsageConfig struct {
+	// RetentionHours is how long a metric is tracked after it was last seen
+	RetentionHours int `mapstructure:"retention_hours"`
+	// SeriesSketchPrecision is the log2 register count of the per-metric series cardinality sketch (4-16)
+	SeriesSketchPrecision int `mapstructure:"series_sketch_precision"`
+	// LabelSketchPrecision is the log2 register count of each per-label value cardinality sketch (4-16)
+	LabelSketchPrecision int `mapstructure:"label_sketch_precision"`
+	// MaxLabelsPerMetric bounds the number of label keys tracked for a single metric
+	MaxLabelsPerMetric int `mapstructure:"max_labels_per_metric"`
+	// TopValuesPerLabel is the number of most frequent values kept for each label
+	TopValuesPerLabel int `mapstructure:"top_values_per_label"`
+	// HistoryHours is how many hourly usage roll-ups are kept per metric
+	HistoryHours int `mapstructure:"history_hours"`
+	// HistoryFile is where usage roll-ups are persisted; empty keeps them in memory only
+	HistoryFile string `mapstructure:"history_file"`
+	// SampleRate analyzes 1 in SampleRate ingested samples; 1 analyzes every sample
+	SampleRate int `mapstructure:"sample_rate"`
+	// MaxSamplesPerSecond samples more aggressively when more samples arrive
+	// per second; 0 disables adaptive sampling
+	MaxSamplesPerSecond float64 `mapstructure:"max_samples_per_second"`
+	// CooccurrenceSampleSize is the number of series sampled per metric to
+	// estimate which label values occur together; negative disables sampling
+	CooccurrenceSampleSize int `mapstructure:"cooccurrence_sample_size"`
+	// DiscoverySeriesPerMetric is the number of series per metric whose
+	// labels are kept for the discovery endpoints; negative keeps none
+	DiscoverySeriesPerMetric int `mapstructure:"discovery_series_per_metric"`
+}
+
+// RecommendationsConfig represents the thresholds a metric must meet to be recommended for aggregation
+type RecommendationsConfig struct {
+	// MinSamples is the minimum number of samples seen for a metric
+	MinSamples int64 `mapstructure:"min_samples"`
+	// MinCardinality is the minimum number of series of a metric
+	MinCardinality int `mapstructure:"min_cardinality"`
+	// MinConfidence is the minimum confidence score (0-1) of a recommendation
+	MinConfidence float64 `mapstructure:"min_confidence"`
+	// VerificationHours is how long the impact of an applied recommendation is measured
+	VerificationHours int `mapstructure:"verification_hours"`
+	// DivergenceThreshold is the relative difference between measured and estimated
+	// cardinality reduction above which an applied recommendation is flagged
+	DivergenceThreshold float64 `mapstructure:"divergence_threshold"`
+	// MaxIntervalSeconds caps the aggregation interval recommended from the
+	// inferred scrape interval of a metric
+	MaxIntervalSeconds int `mapstructure:"max_interval_seconds"`
+	// ExpiryHours expires pending recommendations not suggested again for this
+	// many hours; 0 keeps them forever
+	ExpiryHours int `mapstructure:"expiry_hours"`
+	// RegenerateOnAccess recomputes the estimated impact of pending
+	// recommendations from current usage when they are read
+	RegenerateOnAccess bool `mapstructure:"regenerate_on_access"`
+	// Strategies are the recommendation strategies run on every generation,
+	// in order; empty runs the built-in cardinality strategy only
+	Strategies []RecommendationStrategyConfig `mapstructure:"strategies"`
+}
+
+// RecommendationStrategyConfig enables a recommendation strategy compiled
+// into the binary
+type RecommendationStrategyConfig struct {
+	Name string `mapstructure:"name"`
+	// Params are passed to the strategy when it is created
+	Params map[string]interface{} `mapstructure:"params"`
+}
+
+// ProtectionConfig represents the metrics, labels and series no rule may aggregate or drop
+type ProtectionConfig struct {
+	// Metrics are metric names no rule may match; "*" wildcards are allowed
+	Metrics []string `mapstructure:"metrics"`
+	// Labels are label names every rule must keep on its aggregated series
+	Labels []string `mapstructure:"labels"`
+	// Selectors are series selectors no rule may match, e.g. requests_total{slo="checkout"}
+	Selectors []string `mapstructure:"selectors"`
+}
+
+// OutputNamingConfig represents the prefix and suffix every output metric name must have
+type OutputNamingConfig struct {
+	// Prefix is required at the start of output metric names, e.g. "team_payments:"
+	Prefix string `mapstructure:"prefix"`
+	// Suffix is required at the end of output metric names, e.g. ":aggregated"
+	Suffix string `mapstructure:"suffix"`
+}
+
+// IDConfig represents the scheme of generated rule and template IDs. Existing
+// IDs keep working when it changes.
+type IDConfig struct {
+	// Scheme is random (16 hex characters) or uuidv7, which sorts by creation time
+	Scheme string `mapstructure:"scheme"`
+	// RulePrefix and TemplatePrefix start new IDs, e.g. "rule_"
+	RulePrefix     string `mapstructure:"rule_prefix"`
+	TemplatePrefix string `mapstructure:"template_prefix"`
+}
+
+// LintConfig represents the best-practice checks of rules
+type LintConfig struct {
+	// HighCardinalityLabels are labels rules should not segment by; empty
+	// uses pod, instance, id and similar labels
+	HighCardinalityLabels []string `mapstructure:"high_cardinality_labels"`
+	// OutputSuffixes are accepted endings of output metric names besides the
+	// aggregation type; empty uses :aggregated and _aggregated
+	OutputSuffixes []string `mapstructure:"output_suffixes"`
+	// ScrapeIntervalSeconds is assumed for metrics whose scrape interval has
+	// not been inferred; 0 skips the interval check for them
+	ScrapeIntervalSeconds int `mapstructure:"scrape_interval_seconds"`
+	// Disabled lists checks that are not run
+	Disabled []string `mapstructure:"disabled"`
+}
+
+// PromotionConfig represents where the mappings of rules promoted between
+// environments are read from
+type PromotionConfig struct {
+	// MappingsPath is the directory of mapping files, one per destination,
+	// selected by file name without extension
+	MappingsPath string `mapstructure:"mappings_path"`
+}
+
+// KubernetesConfig represents where generated Kubernetes monitors are written
+// and how they are reconciled with the rules
+type KubernetesConfig struct {
+	// MonitorsDir is where monitor files are written by default
+	MonitorsDir string `mapstructure:"monitors_dir"`
+	// ExistingMonitorsDir holds the existing monitor files rules in patch
+	// mode read, by path relative to it
+	ExistingMonitorsDir string `mapstructure:"existing_monitors_dir"`
+	// ReconcileOnStartup compares the rules with their monitor files at startup
+	// and logs missing and orphaned monitors
+	ReconcileOnStartup bool `mapstructure:"reconcile_on_startup"`
+	// AutoFix generates missing monitor files and deletes orphaned ones when
+	// reconciling at startup
+	AutoFix bool `mapstructure:"auto_fix"`
+	// CheckCluster also checks that the monitors of rules exist in the cluster
+	CheckCluster bool `mapstructure:"check_cluster"`
+	// MonitorOnApply generates the monitors of applied recommendations: none,
+	// file to write them to MonitorsDir, or cluster to apply them
+	MonitorOnApply string `mapstructure:"monitor_on_apply"`
+}
+
+// OwnershipConfig represents how rule ownership is inferred from the series a rule matches
+type OwnershipConfig struct {
+	// NamespaceLabel is the series label holding the namespace, e.g. the Kubernetes namespace
+	NamespaceLabel string `mapstructure:"namespace_label"`
+	// TeamLabel is the series label holding the owning team
+	TeamLabel string `mapstructure:"team_label"`
+	// NamespaceTeams maps namespaces to their owning team for series without a team label
+	NamespaceTeams map[string]string `mapstructure:"namespace_teams"`
+}
+
+// ReportsConfig represents the reports served under /api/v1/reports
+type ReportsConfig struct {
+	// JobLabel is the series label holding the job savings are broken down by
+	JobLabel string `mapstructure:"job_label"`
+	// CostPerSeries is the monthly cost of one active series, from which
+	// savings are estimated; 0 reports no cost savings
+	CostPerSeries float64 `mapstructure:"cost_per_series"`
+	// Currency of CostPerSeries
+	Currency string `mapstructure:"currency"`
+	// Schedules render savings reports periodically and deliver them
+	Schedules []ReportScheduleConfig `mapstructure:"schedules"`
+	// SMTP is the mail server scheduled reports are emailed through
+	SMTP SMTPConfig `mapstructure:"smtp"`
+}
+
+// ReportScheduleConfig represents a savings report rendered at the start of
+// every week or month, for the period that just ended, and delivered by
+// webhook, email or both
+type ReportScheduleConfig struct {
+	Name string `mapstructure:"name"`
+	// Period is weekly (sent on Mondays) or monthly (sent on the 1st), in UTC
+	Period string `mapstructure:"period"`
+	// WebhookURL receives the report as JSON
+	WebhookURL string `mapstructure:"webhook_url"`
+	// Emails receive the report as HTML, with the JSON attached
+	Emails []string `mapstructure:"emails"`
+}
+
+// SMTPConfig represents the mail server reports are sent through
+type SMTPConfig struct {
+	Host string `mapstructure:"host"`
+	Port int    `mapstructure:"port"`
+	// Username and Password authenticate with PLAIN auth when set
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// PasswordFile is read for the password instead of setting it in the config
+	PasswordFile string `mapstructure:"password_file"`
+	From         string `mapstructure:"from"`
+}
+
+// ShardingConfig represents the horizontal sharding configuration.
+// Each instance owns a hash range of the output series of rules, aggregates
+// only those, and forwards samples of other output series to their owners
+// over gRPC.
+type ShardingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// InstanceAddress is the gRPC address peers use to reach this instance,
+	// which identifies it in the ring
+	InstanceAddress string `mapstructure:"instance_address"`
+	// ListenAddress is where the gRPC service of peers is served
+	ListenAddress string `mapstructure:"listen_address"`
+	// Membership is static, with all instances listed in Peers, or gossip,
+	// where instances join through Peers and discover the others
+	Membership string `mapstructure:"membership"`
+	// Peers are the gRPC addresses of all instances in the ring, including
+	// this one, or the instances to join through with gossip membership
+	Peers []string `mapstructure:"peers"`
+	// GossipIntervalSeconds is how often an instance exchanges its view of
+	// the members with a random peer
+	GossipIntervalSeconds int `mapstructure:"gossip_interval_seconds"`
+	// MemberTimeoutSeconds is how long a member that is no longer heard of
+	// stays in the ring
+	MemberTimeoutSeconds int `mapstructure:"member_timeout_seconds"`
+	// Secret authenticates peers with each other; forwarded samples and
+	// gossip without it are rejected
+	Secret string `mapstructure:"secret"`
+	// SecretFile is read for the secret instead of setting it in the config
+	SecretFile string `mapstructure:"secret_file"`
+	// TLS serves peers over TLS and connects to them with it. With
+	// ClientCAFile, peers present their certificate as client certificate
+	// too, and authenticate each other with certificates of its CAs (mutual
+	// TLS); the secret is then optional.
+	TLS ListenerTLSConfig `mapstructure:"tls"`
+	// VirtualNodes is the number of ring positions per instance
+	VirtualNodes int `mapstructure:"virtual_nodes"`
+	// ForwardBatchSize is the maximum number of samples per forwarded request
+	ForwardBatchSize int `mapstructure:"forward_batch_size"`
+	// ForwardTimeoutSeconds is the timeout of forwarded requests
+	ForwardTimeoutSeconds int `mapstructure:"forward_timeout_seconds"`
+}
+
+// IngestConfig represents the ingestion limits configuration
+type IngestConfig struct {
+	// TenantHeader is the request header identifying the tenant of a remote write request
+	TenantHeader string `mapstructure:"tenant_header"`
+	// DefaultTenant is used for requests without a tenant header
+	DefaultTenant string `mapstructure:"default_tenant"`
+	// RateLimit is the default number of samples per second accepted per tenant; 0 disables limiting
+	RateLimit float64 `mapstructure:"rate_limit"`
+	// Burst is the default number of samples a tenant can send at once above the rate
+	Burst int `mapstructure:"burst"`
+	// TenantLimits overrides the default limits for specific tenants
+	TenantLimits map[string]TenantLimitConfig `mapstructure:"tenant_limits"`
+	// RetryAfterSeconds is sent in the Retry-After header of rejected requests
+	RetryAfterSeconds int `mapstructure:"retry_after_seconds"`
+	// HATracker deduplicates samples from HA Prometheus replica pairs
+	HATracker HATrackerConfig `mapstructure:"ha_tracker"`
+	// Filters drop series or pass them through before rule matching and usage tracking
+	Filters IngestFiltersConfig `mapstructure:"filters"`
+	// SampleAge bounds the timestamps of accepted samples
+	SampleAge SampleAgeConfig `mapstructure:"sample_age"`
+	// CloudWatch receives AWS CloudWatch Metric Streams delivered by Firehose
+	CloudWatch CloudWatchConfig `mapstructure:"cloudwatch"`
+}
+
+// CloudWatchConfig represents the ingestion of AWS CloudWatch Metric Streams
+type CloudWatchConfig struct {
+	// AccessKey must match the access key of the Firehose HTTP endpoint
+	// destination; empty accepts requests without one
+	AccessKey     string `mapstructure:"access_key"`
+	AccessKeyFile string `mapstructure:"access_key_file"`
+	// MetricPrefix starts the names of CloudWatch metrics, as in
+	// aws_ec2_cpu_utilization_maximum
+	MetricPrefix string `mapstructure:"metric_prefix"`
+}
+
+// SampleAgeConfig represents the bounds of ingested sample timestamps, which
+// protect buckets from samples of clients with a wrong clock
+type SampleAgeConfig struct {
+	// MaxAgeSeconds is how old a sample may be; 0 accepts any age
+	MaxAgeSeconds int `mapstructure:"max_age_seconds"`
+	// MaxFutureSeconds is how far in the future a sample may be; 0 accepts any
+	MaxFutureSeconds int `mapstructure:"max_future_seconds"`
+	// Clamp moves out of bounds timestamps to the nearest bound instead of
+	// rejecting the samples
+	Clamp bool `mapstructure:"clamp"`
+}
+
+// IngestFiltersConfig represents the series handled before rule matching and usage tracking
+type IngestFiltersConfig struct {
+	// Drop are series selectors whose samples are discarded, e.g. go_gc_*
+	Drop []string `mapstructure:"drop"`
+	// Pass are series selectors whose samples are accepted but neither tracked nor aggregated
+	Pass []string `mapstructure:"pass"`
+}
+
+// HATrackerConfig represents the deduplication of HA Prometheus replicas.
+// Replicas of a cluster send the same series, told apart by the replica
+// label; only the samples of one elected replica per cluster are accepted.
+type HATrackerConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// ClusterLabel is the external label naming the HA cluster of a request
+	ClusterLabel string `mapstructure:"cluster_label"`
+	// ReplicaLabel is the external label naming the replica; it is removed
+	// from accepted series
+	ReplicaLabel string `mapstructure:"replica_label"`
+	// FailoverTimeoutSeconds is how long the elected replica may send nothing
+	// before another replica of the cluster takes over
+	FailoverTimeoutSeconds int `mapstructure:"failover_timeout_seconds"`
+}
+
+// TenantLimitConfig represents the ingestion limits of a single tenant
+type TenantLimitConfig struct {
+	RateLimit float64 `mapstructure:"rate_limit"`
+	Burst     int     `mapstructure:"burst"`
+}
+
+// TracingConfig represents the OpenTelemetry tracing configuration
+type TracingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string `mapstructure:"service_name"`
+	// Endpoint is the host:port of the OTLP/HTTP collector
+	Endpoint string `mapstructure:"endpoint"`
+	// URLPath overrides the default /v1/traces path of the collector
+	URLPath string `mapstructure:"url_path"`
+	// Insecure disables TLS towards the collector
+	Insecure bool `mapstructure:"insecure"`
+	// Headers are sent with every export request, e.g. for authentication
+	Headers map[string]string `mapstructure:"headers"`
+	// SampleRatio is the fraction of traces recorded (0.0-1.0)
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// ProfilingConfig represents the Go runtime profiling configuration
+type ProfilingConfig struct {
+	// Enabled serves the pprof endpoints under /debug/pprof/
+	Enabled bool `mapstructure:"enabled"`
+	// Address serves the pprof endpoints on a separate listener, e.g.
+	// "127.0.0.1:6060", instead of the main one
+	Address string `mapstructure:"address"`
+	// MutexProfileFraction reports 1 in N mutex contention events (0 disables)
+	MutexProfileFraction int `mapstructure:"mutex_profile_fraction"`
+	// BlockProfileRate samples one blocking event per N nanoseconds spent
+	// blocked (0 disables)
+	BlockProfileRate int               `mapstructure:"block_profile_rate"`
+	Push             ProfilePushConfig `mapstructure:"push"`
+}
+
+// MemoryConfig represents the Go garbage collector settings. The GOGC and
+// GOMEMLIMIT environment variables take precedence.
+type MemoryConfig struct {
+	// GOGC is the garbage collection target percentage; 0 keeps the runtime
+	// default and -1 collects only when the memory limit is reached
+	GOGC int `mapstructure:"gogc"`
+	// LimitBytes is the soft memory limit of the runtime; 0 uses LimitRatio
+	LimitBytes int64 `mapstructure:"limit_bytes"`
+	// LimitRatio sets the soft memory limit to a fraction of the container
+	// memory limit, e.g. 0.9; 0 leaves the runtime without a limit
+	LimitRatio float64 `mapstructure:"limit_ratio"`
+	// BallastBytes is the size of a heap ballast, which is never touched but
+	// delays garbage collection of small heaps; 0 disables it
+	BallastBytes int64 `mapstructure:"ballast_bytes"`
+}
+
+// ProfilePushConfig represents the push of profiles to a continuous
+// profiling server with a Pyroscope compatible ingest API
+type ProfilePushConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// URL is the base URL of the server; profiles are posted to /ingest
+	URL             string `mapstructure:"url"`
+	ApplicationName string `mapstructure:"application_name"`
+	// IntervalSeconds is how often profiles are pushed, and how long each
+	// CPU profile covers
+	IntervalSeconds int `mapstructure:"interval_seconds"`
+	// Profiles to push: cpu, heap, mutex, block and goroutine
+	Profiles []string `mapstructure:"profiles"`
+	// Tags are attached to every profile, e.g. the environment
+	Tags map[string]string `mapstructure:"tags"`
+	// Headers are sent with every push, e.g. for authentication
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// TemporalityConfig represents how counter samples of each source are interpreted
+type TemporalityConfig struct {
+	// SourceHeader is the request header naming the source of a remote write request
+	SourceHeader string `mapstructure:"source_header"`
+	// Default is the temporality of counters from sources not listed: "cumulative" or "delta"
+	Default string `mapstructure:"default"`
+	// Sources maps source names to the temporality of their counters
+	Sources map[string]string `mapstructure:"sources"`
+}
+
+// FederationConfig represents the Prometheus servers scraped through /federate as an input
+type FederationConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// IntervalSeconds is how often each target is scraped
+	IntervalSeconds int `mapstructure:"interval_seconds"`
+	// TimeoutSeconds is the timeout of a scrape
+	TimeoutSeconds int                `mapstructure:"timeout_seconds"`
+	Targets        []FederationTarget `mapstructure:"targets"`
+}
+
+// FederationTarget represents one Prometheus server scraped through /federate
+type FederationTarget struct {
+	// Name identifies the target in logs and metrics; defaults to the URL
+	Name string `mapstructure:"name"`
+	// URL is the base URL of the Prometheus server, e.g. http://prometheus:9090
+	URL string `mapstructure:"url"`
+	// Match are the match[] series selectors of the scrape; at least one is required
+	Match    []string          `mapstructure:"match"`
+	Username string            `mapstructure:"username"`
+	Password string            `mapstructure:"password"`
+	Headers  map[string]string `mapstructure:"headers"`
+	// PasswordFile is read for the password instead of setting it in the config
+	PasswordFile string `mapstructure:"password_file"`
+}
+
+// BackfillConfig represents the replay of historical data through rules
+type BackfillConfig struct {
+	// Directory holds the dump files read and written by backfill jobs; job
+	// file paths are relative to it
+	Directory string `mapstructure:"directory"`
+	// ChunkSeconds is the time range read from the query API at once
+	ChunkSeconds int `mapstructure:"chunk_seconds"`
+	// TimeoutSeconds bounds each query to the query API
+	TimeoutSeconds int `mapstructure:"timeout_seconds"`
+	// BatchSize is the number of aggregated series written downstream at once
+	BatchSize int `mapstructure:"batch_size"`
+}
+
+// GitOpsConfig represents keeping the rules in sync with a Git repository
+type GitOpsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// RepoPath is a local clone of the repository, checked out on Branch
+	RepoPath string `mapstructure:"repo_path"`
+	// RulesDir is the directory of the rule files within the repository
+	RulesDir string `mapstructure:"rules_dir"`
+	Branch   string `mapstructure:"branch"`
+	// Remote is pulled before and pushed after every sync; empty keeps the
+	// repository local
+	Remote          string `mapstructure:"remote"`
+	Push            bool   `mapstructure:"push"`
+	IntervalSeconds int    `mapstructure:"interval_seconds"`
+	AuthorName      string `mapstructure:"author_name"`
+	AuthorEmail     string `mapstructure:"author_email"`
+	// PullRequest proposes rule changes as pull requests instead of pushing
+	// them to Branch
+	PullRequest GitOpsPullRequestConfig `mapstructure:"pull_request"`
+}
+
+// GitOpsPullRequestConfig represents opening GitHub pull requests for rule changes
+type GitOpsPullRequestConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	APIURL  string `mapstructure:"api_url"`
+	// Repository is the owner/name of the repository on GitHub
+	Repository string `mapstructure:"repository"`
+	Token      string `mapstructure:"token"`
+	// TokenFile is read for the token instead of setting it in the config
+	TokenFile string `mapstructure:"token_file"`
+}
+
+// ControlPlaneConfig represents serving the rules of a central instance and
+// polling them from edge instances
+type ControlPlaneConfig struct {
+	Serve ControlPlaneServeConfig `mapstructure:"serve"`
+	Poll  ControlPlanePollConfig  `mapstructure:"poll"`
+}
+
+// ControlPlaneServeConfig represents serving this instance's rules and config
+// fragments as a bundle for edge instances to poll
+type ControlPlaneServeConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// SigningKey is the base64 Ed25519 private key bundles are signed with;
+	// empty serves unsigned bundles
+	SigningKey string `mapstructure:"signing_key"`
+	// SigningKeyFile is read for the signing key instead of setting it in the config
+	SigningKeyFile string `mapstructure:"signing_key_file"`
+	// InstanceExpirySeconds is how long an edge instance that stopped polling
+	// is listed, which should be several of their poll intervals; 0 keeps
+	// them until restart
+	InstanceExpirySeconds int `mapstructure:"instance_expiry_seconds"`
+	// MaxInstances is the number of edge instances listed, beyond which new
+	// ones are not recorded until others expire; 0 disables the limit
+	MaxInstances int `mapstructure:"max_instances"`
+}
+
+// ControlPlanePollConfig represents replacing this instance's rules and config
+// fragments with a bundle polled from a central source
+type ControlPlanePollConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// URL of the bundle: the bundle endpoint of a central instance, or an
+	// http(s):// or s3://bucket/key URL serving a bundle file
+	URL string `mapstructure:"url"`
+	// Instance identifies this instance to the central one; defaults to the
+	// hostname
+	Instance        string `mapstructure:"instance"`
+	IntervalSeconds int    `mapstructure:"interval_seconds"`
+	TimeoutSeconds  int    `mapstructure:"timeout_seconds"`
+	// Token is sent as a bearer token
+	Token string `mapstructure:"token"`
+	// TokenFile is read for the token instead of setting it in the config
+	TokenFile string `mapstructure:"token_file"`
+	// PublicKey is the base64 Ed25519 public key bundles must be signed with;
+	// required unless InsecureSkipVerify is set
+	PublicKey string `mapstructure:"public_key"`
+	// InsecureSkipVerify accepts bundles without checking their signature
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+	// StateFile keeps when the last bundle applied was issued across
+	// restarts, so older bundles are rejected
+	StateFile string `mapstructure:"state_file"`
 }
 
 // Load loads the configuration from file and environment variables
@@ -130,6 +924,11 @@ func Load(customConfigPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	// Resolve secret files and references
+	if err := resolveSecrets(&config); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
@@ -141,12 +940,60 @@ func setDefaults() {
 	viper.SetDefault("server.read_timeout_seconds", 30)
 	viper.SetDefault("server.write_timeout_seconds", 30)
 	viper.SetDefault("server.web_ui_path", "web/build")
+	viper.SetDefault("server.idle_timeout_seconds", 120)
+	viper.SetDefault("server.compression", true)
+	viper.SetDefault("server.shutdown_timeout_seconds", 30)
+	viper.SetDefault("server.user_header", "X-Grafana-User")
+	viper.SetDefault("server.readiness.resolve_remote_write", false)
+	viper.SetDefault("server.readiness.timeout_seconds", 2)
+	viper.SetDefault("server.rate_limit.enabled", false)
+	viper.SetDefault("server.rate_limit.by", "ip")
+	viper.SetDefault("server.rate_limit.api_key_header", "Authorization")
+	viper.SetDefault("server.rate_limit.trust_forwarded_for", false)
+	viper.SetDefault("server.rate_limit.ingest.requests_per_second", 100)
+	viper.SetDefault("server.rate_limit.ingest.burst", 200)
+	viper.SetDefault("server.rate_limit.api.requests_per_second", 20)
+	viper.SetDefault("server.rate_limit.api.burst", 40)
+	viper.SetDefault("server.tls.cert_file", "")
+	viper.SetDefault("server.tls.key_file", "")
+	viper.SetDefault("server.tls.client_ca_file", "")
+	viper.SetDefault("server.redirect_http_address", "")
+	viper.SetDefault("server.ingest.address", "")
+	viper.SetDefault("server.debug_dump_dir", "")
+	viper.SetDefault("server.debug_dump_keep", 10)
+	viper.SetDefault("server.debug_dump_interval_seconds", 60)
+	viper.SetDefault("server.metrics.address", "")
 
 	// Aggregator defaults
 	viper.SetDefault("aggregator.batch_size", 1000)
 	viper.SetDefault("aggregator.aggregation_delay_ms", 60000) // 60 seconds
 	viper.SetDefault("aggregator.worker_count", 5)
 	viper.SetDefault("aggregator.rules_path", "configs/rules")
+	viper.SetDefault("aggregator.overflow_policy", "drop")
+	viper.SetDefault("aggregator.overflow_timeout_ms", 100)
+	viper.SetDefault("aggregator.dead_mans_switch_intervals", 3)
+	viper.SetDefault("aggregator.counter_state_ttl_seconds", 3600)
+	viper.SetDefault("aggregator.counter_state_max_series", 1000000)
+	viper.SetDefault("aggregator.counter_state_eviction", "oldest")
+	viper.SetDefault("aggregator.output_timestamp", "end")
+	viper.SetDefault("aggregator.flush_jitter_ms", 0)
+	viper.SetDefault("aggregator.worker_scaling.enabled", false)
+	viper.SetDefault("aggregator.worker_scaling.min_workers", 2)
+	viper.SetDefault("aggregator.worker_scaling.max_workers", 0)
+	viper.SetDefault("aggregator.worker_scaling.interval_ms", 1000)
+	viper.SetDefault("aggregator.worker_scaling.target_queue_wait_ms", 50)
+	viper.SetDefault("aggregator.dry_statistics.enabled", false)
+	viper.SetDefault("aggregator.dry_statistics.max_series", 10000)
+	viper.SetDefault("aggregator.catch_all.enabled", false)
+	viper.SetDefault("aggregator.catch_all.min_cardinality", 1000)
+	viper.SetDefault("aggregator.catch_all.segmentation", []string{"job", "namespace"})
+	viper.SetDefault("aggregator.catch_all.aggregation", "sum")
+	viper.SetDefault("aggregator.catch_all.interval_seconds", 60)
+	viper.SetDefault("aggregator.transform_plugins", []interface{}{})
+	viper.SetDefault("aggregator.wasm_transforms.max_module_bytes", 1048576)
+	viper.SetDefault("aggregator.wasm_transforms.max_memory_mb", 16)
+	viper.SetDefault("aggregator.wasm_transforms.max_instructions", 1000000)
+	viper.SetDefault("aggregator.wasm_transforms.max_locals", 65536)
 
 	// Storage defaults
 	viper.SetDefault("storage.type", "memory")
@@ -156,17 +1003,30 @@ func setDefaults() {
 	viper.SetDefault("plugin.enabled", false)
 	viper.SetDefault("plugin.api_url", "http://localhost:3000/api")
 	viper.SetDefault("plugin.auth_token", "")
+	viper.SetDefault("plugin.auth_token_file", "")
+	viper.SetDefault("plugin.sync_interval_seconds", 300)
+	viper.SetDefault("plugin.rule_sync.enabled", false)
+	viper.SetDefault("plugin.rule_sync.interval_seconds", 60)
+	viper.SetDefault("plugin.rule_sync.conflict_policy", "manual")
+	viper.SetDefault("plugin.rule_sync.state_file", "./data/rule_sync_state.json")
 
 	// Remote Write defaults
 	viper.SetDefault("remote_write.enabled", false)
 	viper.SetDefault("remote_write.endpoints", []string{})
 	viper.SetDefault("remote_write.username", "")
 	viper.SetDefault("remote_write.password", "")
+	viper.SetDefault("remote_write.password_file", "")
 	viper.SetDefault("remote_write.headers", map[string]string{})
 	viper.SetDefault("remote_write.max_retries", 3)
 	viper.SetDefault("remote_write.retry_interval_seconds", 30)
 	viper.SetDefault("remote_write.batch_size", 1000)
 	viper.SetDefault("remote_write.timeout_seconds", 30)
+	viper.SetDefault("remote_write.compression", "snappy")
+	viper.SetDefault("remote_write.endpoint_options", []interface{}{})
+	viper.SetDefault("remote_write.tenant_header", "X-Scope-OrgID")
+	viper.SetDefault("remote_write.circuit_breaker.enabled", true)
+	viper.SetDefault("remote_write.circuit_breaker.failure_threshold", 3)
+	viper.SetDefault("remote_write.circuit_breaker.open_seconds", 30)
 	viper.SetDefault("remote_write.recommendation_metrics_only", true)
 
 	// Logging defaults
@@ -175,4 +1035,178 @@ func setDefaults() {
 	viper.SetDefault("logging.include_timestamp", true)
 	viper.SetDefault("logging.include_caller", false)
 	viper.SetDefault("logging.file", "")
+	viper.SetDefault("logging.sample_interval_seconds", 10)
+	viper.SetDefault("logging.access_log", true)
+
+	// Usage tracking defaults
+	viper.SetDefault("usage.retention_hours", 90*24) // 90 days
+	viper.SetDefault("usage.series_sketch_precision", 14)
+	viper.SetDefault("usage.label_sketch_precision", 10)
+	viper.SetDefault("usage.max_labels_per_metric", 64)
+	viper.SetDefault("usage.top_values_per_label", 20)
+	viper.SetDefault("usage.history_hours", 7*24) // 1 week
+	viper.SetDefault("usage.history_file", "data/usage-history.json")
+	viper.SetDefault("usage.sample_rate", 1)
+	viper.SetDefault("usage.max_samples_per_second", 0)
+	viper.SetDefault("usage.cooccurrence_sample_size", 128)
+	viper.SetDefault("usage.discovery_series_per_metric", 20)
+
+	// Recommendation defaults
+	viper.SetDefault("recommendations.min_samples", 1000)
+	viper.SetDefault("recommendations.min_cardinality", 100)
+	viper.SetDefault("recommendations.min_confidence", 0.5)
+	viper.SetDefault("recommendations.verification_hours", 24)
+	viper.SetDefault("recommendations.divergence_threshold", 0.5)
+	viper.SetDefault("recommendations.max_interval_seconds", 600)
+	viper.SetDefault("recommendations.expiry_hours", 14*24) // 2 weeks
+	viper.SetDefault("recommendations.regenerate_on_access", false)
+	viper.SetDefault("recommendations.strategies", []interface{}{})
+
+	// Output naming defaults
+	viper.SetDefault("output_naming.prefix", "")
+	viper.SetDefault("output_naming.suffix", "")
+
+	// ID defaults
+	viper.SetDefault("ids.scheme", "random")
+	viper.SetDefault("ids.rule_prefix", "")
+	viper.SetDefault("ids.template_prefix", "")
+
+	// Lint defaults
+	viper.SetDefault("lint.high_cardinality_labels", []string{})
+	viper.SetDefault("lint.output_suffixes", []string{})
+	viper.SetDefault("lint.scrape_interval_seconds", 0)
+	viper.SetDefault("lint.disabled", []string{})
+
+	// Promotion defaults
+	viper.SetDefault("promotion.mappings_path", "configs/promotions")
+
+	// Ownership defaults
+	viper.SetDefault("ownership.namespace_label", "namespace")
+	viper.SetDefault("ownership.team_label", "team")
+	viper.SetDefault("reports.job_label", "job")
+	viper.SetDefault("reports.cost_per_series", 0)
+	viper.SetDefault("reports.currency", "USD")
+	viper.SetDefault("reports.schedules", []interface{}{})
+	viper.SetDefault("reports.smtp.port", 587)
+
+	// Kubernetes defaults
+	viper.SetDefault("kubernetes.monitors_dir", "kubernetes/monitors")
+	viper.SetDefault("kubernetes.existing_monitors_dir", "kubernetes/existing")
+	viper.SetDefault("kubernetes.reconcile_on_startup", true)
+	viper.SetDefault("kubernetes.auto_fix", false)
+	viper.SetDefault("kubernetes.check_cluster", false)
+	viper.SetDefault("kubernetes.monitor_on_apply", "none")
+
+	// Sharding defaults
+	viper.SetDefault("sharding.enabled", false)
+	viper.SetDefault("sharding.instance_address", "")
+	viper.SetDefault("sharding.listen_address", ":9095")
+	viper.SetDefault("sharding.membership", "static")
+	viper.SetDefault("sharding.peers", []string{})
+	viper.SetDefault("sharding.gossip_interval_seconds", 1)
+	viper.SetDefault("sharding.member_timeout_seconds", 10)
+	viper.SetDefault("sharding.tls.cert_file", "")
+	viper.SetDefault("sharding.tls.key_file", "")
+	viper.SetDefault("sharding.tls.client_ca_file", "")
+	viper.SetDefault("sharding.virtual_nodes", 128)
+	viper.SetDefault("sharding.forward_batch_size", 1000)
+	viper.SetDefault("sharding.forward_timeout_seconds", 10)
+
+	// Ingest defaults
+	viper.SetDefault("ingest.tenant_header", "X-Scope-OrgID")
+	viper.SetDefault("ingest.default_tenant", "anonymous")
+	viper.SetDefault("ingest.rate_limit", 0) // Unlimited
+	viper.SetDefault("ingest.burst", 0)
+	viper.SetDefault("ingest.tenant_limits", map[string]interface{}{})
+	viper.SetDefault("ingest.retry_after_seconds", 5)
+	viper.SetDefault("ingest.ha_tracker.enabled", false)
+	viper.SetDefault("ingest.ha_tracker.cluster_label", "cluster")
+	viper.SetDefault("ingest.ha_tracker.replica_label", "__replica__")
+	viper.SetDefault("ingest.ha_tracker.failover_timeout_seconds", 30)
+	viper.SetDefault("ingest.filters.drop", []string{})
+	viper.SetDefault("ingest.filters.pass", []string{})
+	viper.SetDefault("ingest.sample_age.max_age_seconds", 0) // Any age
+	viper.SetDefault("ingest.sample_age.max_future_seconds", 0)
+	viper.SetDefault("ingest.sample_age.clamp", false)
+	viper.SetDefault("ingest.cloudwatch.access_key", "")
+	viper.SetDefault("ingest.cloudwatch.metric_prefix", "aws")
+
+	// Tracing defaults
+	viper.SetDefault("tracing.enabled", false)
+	viper.SetDefault("tracing.service_name", "adaptive-metrics")
+	viper.SetDefault("tracing.endpoint", "localhost:4318")
+	viper.SetDefault("tracing.url_path", "")
+	viper.SetDefault("tracing.insecure", true)
+	viper.SetDefault("tracing.headers", map[string]string{})
+	viper.SetDefault("tracing.sample_ratio", 0.01)
+
+	// Profiling defaults
+	viper.SetDefault("profiling.enabled", false)
+	viper.SetDefault("profiling.address", "")
+	viper.SetDefault("profiling.mutex_profile_fraction", 0)
+	viper.SetDefault("profiling.block_profile_rate", 0)
+	viper.SetDefault("profiling.push.enabled", false)
+	viper.SetDefault("profiling.push.url", "http://localhost:4040")
+	viper.SetDefault("profiling.push.application_name", "adaptive-metrics")
+	viper.SetDefault("profiling.push.interval_seconds", 10)
+	viper.SetDefault("profiling.push.profiles", []string{"cpu", "heap"})
+	viper.SetDefault("profiling.push.tags", map[string]string{})
+	viper.SetDefault("profiling.push.headers", map[string]string{})
+
+	// Memory defaults
+	viper.SetDefault("memory.gogc", 0) // Runtime default
+	viper.SetDefault("memory.limit_bytes", 0)
+	viper.SetDefault("memory.limit_ratio", 0)
+	viper.SetDefault("memory.ballast_bytes", 0)
+
+	// Federation defaults
+	viper.SetDefault("federation.enabled", false)
+	viper.SetDefault("federation.interval_seconds", 60)
+	viper.SetDefault("federation.timeout_seconds", 10)
+	viper.SetDefault("federation.targets", []interface{}{})
+	viper.SetDefault("backfill.directory", "./data/backfill")
+	viper.SetDefault("backfill.chunk_seconds", 3600)
+	viper.SetDefault("backfill.timeout_seconds", 60)
+	viper.SetDefault("backfill.batch_size", 1000)
+
+	// GitOps defaults
+	viper.SetDefault("gitops.enabled", false)
+	viper.SetDefault("gitops.repo_path", "")
+	viper.SetDefault("gitops.rules_dir", "rules")
+	viper.SetDefault("gitops.branch", "main")
+	viper.SetDefault("gitops.remote", "origin")
+	viper.SetDefault("gitops.push", false)
+	viper.SetDefault("gitops.interval_seconds", 60)
+	viper.SetDefault("gitops.author_name", "adaptive-metrics")
+	viper.SetDefault("gitops.author_email", "adaptive-metrics@localhost")
+	viper.SetDefault("gitops.pull_request.enabled", false)
+	viper.SetDefault("gitops.pull_request.api_url", "https://api.github.com")
+	viper.SetDefault("gitops.pull_request.repository", "")
+	viper.SetDefault("gitops.pull_request.token", "")
+	viper.SetDefault("gitops.pull_request.token_file", "")
+
+	// Control plane defaults
+	viper.SetDefault("control_plane.serve.enabled", false)
+	viper.SetDefault("control_plane.serve.signing_key", "")
+	viper.SetDefault("control_plane.serve.signing_key_file", "")
+	viper.SetDefault("control_plane.serve.instance_expiry_seconds", 600)
+	viper.SetDefault("control_plane.serve.max_instances", 10000)
+	viper.SetDefault("control_plane.poll.enabled", false)
+	viper.SetDefault("control_plane.poll.url", "")
+	viper.SetDefault("control_plane.poll.instance", "")
+	viper.SetDefault("control_plane.poll.interval_seconds", 60)
+	viper.SetDefault("control_plane.poll.timeout_seconds", 30)
+	viper.SetDefault("control_plane.poll.token", "")
+	viper.SetDefault("control_plane.poll.token_file", "")
+	viper.SetDefault("control_plane.poll.public_key", "")
+	viper.SetDefault("control_plane.poll.insecure_skip_verify", false)
+	viper.SetDefault("control_plane.poll.state_file", "./data/control_plane_state.json")
+
+	// External labels defaults
+	viper.SetDefault("external_labels", map[string]string{})
+
+	// Temporality defaults
+	viper.SetDefault("temporality.source_header", "X-Metrics-Source")
+	viper.SetDefault("temporality.default", "cumulative")
+	viper.SetDefault("temporality.sources", map[string]string{})
 }
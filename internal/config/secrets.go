@@ -0,0 +1,262 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/pkg/kubernetes"
+)
+
+// RedactedSecret replaces secrets in redacted configs
+const RedactedSecret = "<secret>"
+
+// Prefixes of secret references resolved when the config is loaded
+const (
+	// k8s://namespace/name/key reads a key of a Kubernetes Secret with the
+	// service account of the pod
+	kubernetesSecretPrefix = "k8s://"
+	// vault://path#key reads a key of a Vault secret from VAULT_ADDR with
+	// VAULT_TOKEN; KV version 1 and 2 engines are supported
+	vaultSecretPrefix = "vault://"
+)
+
+// envReference matches ${VAR} environment variable references
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// secretField is a secret setting and the optional file it is read from
+type secretField struct {
+	name  string
+	value *string
+	file  string
+}
+
+// secretFields returns the secret settings of a config
+func (c *Config) secretFields() []secretField {
+	fields := []secretField{
+		{name: "plugin.auth_token", value: &c.Plugin.AuthToken, file: c.Plugin.AuthTokenFile},
+		{name: "remote_write.password", value: &c.RemoteWrite.Password, file: c.RemoteWrite.PasswordFile},
+		{name: "gitops.pull_request.token", value: &c.GitOps.PullRequest.Token, file: c.GitOps.PullRequest.TokenFile},
+		{name: "reports.smtp.password", value: &c.Reports.SMTP.Password, file: c.Reports.SMTP.PasswordFile},
+		{name: "ingest.cloudwatch.access_key", value: &c.Ingest.CloudWatch.AccessKey, file: c.Ingest.CloudWatch.AccessKeyFile},
+		{name: "control_plane.serve.signing_key", value: &c.ControlPlane.Serve.SigningKey, file: c.ControlPlane.Serve.SigningKeyFile},
+		{name: "control_plane.poll.token", value: &c.ControlPlane.Poll.Token, file: c.ControlPlane.Poll.TokenFile},
+		{name: "sharding.secret", value: &c.Sharding.Secret, file: c.Sharding.SecretFile},
+	}
+	listeners := []struct {
+		name string
+		auth *ListenerAuthConfig
+	}{
+		{"server.auth", &c.Server.Auth},
+		{"server.ingest.auth", &c.Server.Ingest.Auth},
+		{"server.metrics.auth", &c.Server.Metrics.Auth},
+	}
+	for _, listener := range listeners {
+		fields = append(fields,
+			secretField{name: listener.name + ".bearer_token", value: &listener.auth.BearerToken, file: listener.auth.BearerTokenFile},
+			secretField{name: listener.name + ".password", value: &listener.auth.Password, file: listener.auth.PasswordFile},
+		)
+	}
+	for i := range c.Federation.Targets {
+		target := &c.Federation.Targets[i]
+		fields = append(fields, secretField{
+			name:  fmt.Sprintf("federation.targets[%d].password", i),
+			value: &target.Password,
+			file:  target.PasswordFile,
+		})
+	}
+	return fields
+}
+
+// secretHeaders returns the header settings of a config, whose values often
+// hold credentials
+func (c *Config) secretHeaders() map[string]map[string]string {
+	headers := map[string]map[string]string{
+		"remote_write.headers":   c.RemoteWrite.Headers,
+		"tracing.headers":        c.Tracing.Headers,
+		"profiling.push.headers": c.Profiling.Push.Headers,
+	}
+	for i, target := range c.Federation.Targets {
+		headers[fmt.Sprintf("federation.targets[%d].headers", i)] = target.Headers
+	}
+	return headers
+}
+
+// resolveSecrets reads secret files and resolves environment variables and
+// secret references in secret settings and headers
+func resolveSecrets(c *Config) error {
+	resolver := &secretResolver{}
+
+	for _, field := range c.secretFields() {
+		if field.file != "" {
+			if *field.value != "" {
+				return fmt.Errorf("only one of %s and %s_file may be set", field.name, field.name)
+			}
+			data, err := os.ReadFile(field.file)
+			if err != nil {
+				return fmt.Errorf("failed to read %s_file: %w", field.name, err)
+			}
+			*field.value = strings.TrimRight(string(data), "\r\n")
+			continue
+		}
+
+		value, err := resolver.resolve(*field.value)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", field.name, err)
+		}
+		*field.value = value
+	}
+
+	for name, headers := range c.secretHeaders() {
+		for key, value := range headers {
+			resolved, err := resolver.resolve(value)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s.%s: %w", name, key, err)
+			}
+			headers[key] = resolved
+		}
+	}
+
+	return nil
+}
+
+// secretResolver resolves secret values, creating the clients of secret
+// stores on first use
+type secretResolver struct {
+	kubernetes *kubernetes.Client
+	httpClient *http.Client
+}
+
+// resolve expands environment variables in a value, then reads it from a
+// secret store if it is a secret reference
+func (r *secretResolver) resolve(value string) (string, error) {
+	var missing []string
+	value = envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		env, exists := os.LookupEnv(name)
+		if !exists {
+			missing = append(missing, name)
+		}
+		return env
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", missing[0])
+	}
+
+	switch {
+	case strings.HasPrefix(value, kubernetesSecretPrefix):
+		return r.kubernetesSecret(strings.TrimPrefix(value, kubernetesSecretPrefix))
+	case strings.HasPrefix(value, vaultSecretPrefix):
+		return r.vaultSecret(strings.TrimPrefix(value, vaultSecretPrefix))
+	default:
+		return value, nil
+	}
+}
+
+// kubernetesSecret reads a namespace/name/key reference
+func (r *secretResolver) kubernetesSecret(ref string) (string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", fmt.Errorf("invalid Kubernetes secret reference %q: must be %snamespace/name/key", ref, kubernetesSecretPrefix)
+	}
+
+	if r.kubernetes == nil {
+		client, err := kubernetes.NewInClusterClient()
+		if err != nil {
+			return "", err
+		}
+		r.kubernetes = client
+	}
+	return r.kubernetes.GetSecret(parts[0], parts[1], parts[2])
+}
+
+// vaultSecret reads a path#key reference
+func (r *secretResolver) vaultSecret(ref string) (string, error) {
+	path, key, found := strings.Cut(ref, "#")
+	if !found || path == "" || key == "" {
+		return "", fmt.Errorf("invalid Vault secret reference %q: must be %spath#key", ref, vaultSecretPrefix)
+	}
+	address, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if address == "" || token == "" {
+		return "", fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set to read Vault secrets")
+	}
+
+	if r.httpClient == nil {
+		r.httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to read Vault secret %s: status %d: %s", path, resp.StatusCode, body)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to decode Vault secret %s: %w", path, err)
+	}
+	data := secret.Data
+	// KV version 2 nests the secret and its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s in Vault has no string key %s", path, key)
+	}
+	return value, nil
+}
+
+// Redacted returns a copy of the config with secrets and header values
+// replaced, for dumping or logging the config
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.Federation.Targets = append([]FederationTarget(nil), c.Federation.Targets...)
+	redacted.RemoteWrite.Headers = redactHeaders(c.RemoteWrite.Headers)
+	redacted.Tracing.Headers = redactHeaders(c.Tracing.Headers)
+	redacted.Profiling.Push.Headers = redactHeaders(c.Profiling.Push.Headers)
+	for i := range redacted.Federation.Targets {
+		redacted.Federation.Targets[i].Headers = redactHeaders(c.Federation.Targets[i].Headers)
+	}
+
+	for _, field := range redacted.secretFields() {
+		if *field.value != "" {
+			*field.value = RedactedSecret
+		}
+	}
+	return &redacted
+}
+
+// redactHeaders returns a copy of headers with all values replaced
+func redactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(headers))
+	for key := range headers {
+		redacted[key] = RedactedSecret
+	}
+	return redacted
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/adaptive-metrics" || r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"token":"from-vault"},"metadata":{"version":1}}}`))
+	}))
+	defer vault.Close()
+
+	t.Setenv("AM_TEST_TOKEN", "from-env")
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	cfg := &Config{
+		Plugin:      PluginConfig{AuthToken: "Bearer ${AM_TEST_TOKEN}"},
+		RemoteWrite: RemoteWriteConfig{PasswordFile: passwordFile, Headers: map[string]string{"X-Token": "${AM_TEST_TOKEN}"}},
+		GitOps:      GitOpsConfig{PullRequest: GitOpsPullRequestConfig{Token: "vault://secret/data/adaptive-metrics#token"}},
+		Reports:     ReportsConfig{SMTP: SMTPConfig{PasswordFile: passwordFile}},
+		Profiling:   ProfilingConfig{Push: ProfilePushConfig{Headers: map[string]string{"Authorization": "Bearer ${AM_TEST_TOKEN}"}}},
+	}
+	if err := resolveSecrets(cfg); err != nil {
+		t.Fatalf("resolveSecrets() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"environment", cfg.Plugin.AuthToken, "Bearer from-env"},
+		{"file", cfg.RemoteWrite.Password, "from-file"},
+		{"smtp file", cfg.Reports.SMTP.Password, "from-file"},
+		{"header", cfg.RemoteWrite.Headers["X-Token"], "from-env"},
+		{"profile push header", cfg.Profiling.Push.Headers["Authorization"], "Bearer from-env"},
+		{"vault", cfg.GitOps.PullRequest.Token, "from-vault"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s secret = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// Secrets are redacted in a copy, leaving the config intact
+	redacted := cfg.Redacted()
+	if redacted.RemoteWrite.Password != RedactedSecret || redacted.RemoteWrite.Headers["X-Token"] != RedactedSecret {
+		t.Errorf("expected secrets to be redacted, got %+v", redacted.RemoteWrite)
+	}
+	if redacted.Profiling.Push.Headers["Authorization"] != RedactedSecret {
+		t.Errorf("expected profile push headers to be redacted, got %v", redacted.Profiling.Push.Headers)
+	}
+	if redacted.Reports.SMTP.Password != RedactedSecret {
+		t.Errorf("expected the SMTP password to be redacted, got %q", redacted.Reports.SMTP.Password)
+	}
+	if cfg.RemoteWrite.Password != "from-file" || cfg.RemoteWrite.Headers["X-Token"] != "from-env" {
+		t.Error("expected redacting to leave the config intact")
+	}
+
+	for name, cfg := range map[string]*Config{
+		"missing variable": {Plugin: PluginConfig{AuthToken: "${AM_TEST_MISSING}"}},
+		"value and file":   {RemoteWrite: RemoteWriteConfig{Password: "x", PasswordFile: passwordFile}},
+		"bad reference":    {Plugin: PluginConfig{AuthToken: "k8s://only-namespace"}},
+	} {
+		if err := resolveSecrets(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
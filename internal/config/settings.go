@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Settings returns the config as nested maps keyed by the names used in the
+// config file, for dumping the effective config. Redact the config first.
+func (c *Config) Settings() map[string]interface{} {
+	return settingsValue(reflect.ValueOf(*c)).(map[string]interface{})
+}
+
+// settingsValue converts structs to maps keyed by their mapstructure tags
+func settingsValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		settings := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			settings[name] = settingsValue(v.Field(i))
+		}
+		return settings
+	case reflect.Slice:
+		if v.IsNil() {
+			return []interface{}{}
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = settingsValue(v.Index(i))
+		}
+		return items
+	case reflect.Map:
+		if v.IsNil() {
+			return map[string]interface{}{}
+		}
+		entries := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries[fmt.Sprint(iter.Key().Interface())] = settingsValue(iter.Value())
+		}
+		return entries
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return settingsValue(v.Elem())
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestConfig_Settings(t *testing.T) {
+	cfg := &Config{
+		Server:      ServerConfig{Port: 8080},
+		RemoteWrite: RemoteWriteConfig{Password: "hunter2", Endpoints: []string{"http://mimir/api/v1/push"}},
+		Federation:  FederationConfig{Targets: []FederationTarget{{Name: "prom", Password: "hunter2"}}},
+	}
+
+	settings := cfg.Redacted().Settings()
+
+	server := settings["server"].(map[string]interface{})
+	if server["port"] != 8080 {
+		t.Errorf("server.port = %v, want 8080", server["port"])
+	}
+	remoteWrite := settings["remote_write"].(map[string]interface{})
+	if remoteWrite["password"] != RedactedSecret {
+		t.Errorf("remote_write.password = %v, want it redacted", remoteWrite["password"])
+	}
+	if endpoints := remoteWrite["endpoints"].([]interface{}); len(endpoints) != 1 {
+		t.Errorf("remote_write.endpoints = %v, want one endpoint", endpoints)
+	}
+	target := settings["federation"].(map[string]interface{})["targets"].([]interface{})[0].(map[string]interface{})
+	if target["password"] != RedactedSecret || target["name"] != "prom" {
+		t.Errorf("federation target = %v, want the password redacted", target)
+	}
+	if cfg.Federation.Targets[0].Password != "hunter2" {
+		t.Error("expected redacting to leave the config intact")
+	}
+}
@@ -0,0 +1,343 @@
+// Package controlplane manages the rules of fleets of instances, such as one
+// per cluster, from a central one. The central instance serves its rules and
+// config fragments as a signed bundle, which edge instances poll and apply in
+// place of their own.
+package controlplane
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// InstanceHeader identifies the edge instance polling a bundle
+const InstanceHeader = "X-Adaptive-Metrics-Instance"
+
+// instancesFullLog is sampled, since every poll of an unknown instance is
+// logged while the instances are full
+var instancesFullLog = logger.NewSampler(logger.Warn, "Too many edge instances polling the bundle, not recording instance")
+
+// Store holds the rules and config fragments served and replaced by bundles
+type Store interface {
+	GetRules() ([]*models.Rule, error)
+	SaveRule(rule *models.Rule) error
+	UpdateRule(rule *models.Rule) error
+	DeleteRule(id string) error
+	Protection() models.ProtectionList
+	SetProtection(list models.ProtectionList) error
+	IngestFilters() models.IngestFilters
+	SetIngestFilters(filters models.IngestFilters) error
+	GetWasmTransforms() []*rules.WasmTransform
+	WasmTransformBinary(name string) ([]byte, error)
+	SaveWasmTransform(name string, binary []byte) (*rules.WasmTransform, error)
+	DeleteWasmTransform(name string) error
+}
+
+// Bundle is the rule set and config fragments of an instance. A section left
+// out is not changed on the instances applying the bundle, while an empty one
+// removes everything in it.
+type Bundle struct {
+	// IssuedAt is when the content of the bundle last changed. Instances
+	// reject bundles issued before the last one they applied, so an older
+	// signed bundle cannot be replayed.
+	IssuedAt      time.Time              `json:"issued_at"`
+	Rules         []*models.Rule         `json:"rules"`
+	Protection    *models.ProtectionList `json:"protection,omitempty"`
+	IngestFilters *models.IngestFilters  `json:"ingest_filters,omitempty"`
+	// Transforms are the WASM modules of the transforms rules apply, by name
+	Transforms map[string][]byte `json:"transforms"`
+}
+
+// SignedBundle is a bundle as served, with the signature of its exact bytes
+type SignedBundle struct {
+	Bundle json.RawMessage `json:"bundle"`
+	// Signature is the base64 Ed25519 signature of Bundle; empty when unsigned
+	Signature string `json:"signature,omitempty"`
+}
+
+// validate checks a bundle before any of it is applied
+func (b *Bundle) validate() error {
+	ids := make(map[string]bool, len(b.Rules))
+	for i, rule := range b.Rules {
+		if rule == nil {
+			return fmt.Errorf("rule %d is empty", i)
+		}
+		if err := models.ValidateID(rule.ID); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		if ids[rule.ID] {
+			return fmt.Errorf("duplicate rule %s", rule.ID)
+		}
+		ids[rule.ID] = true
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
+	}
+	if b.Protection != nil {
+		if err := b.Protection.Validate(); err != nil {
+			return fmt.Errorf("protection: %w", err)
+		}
+	}
+	if b.IngestFilters != nil {
+		if err := b.IngestFilters.Validate(); err != nil {
+			return fmt.Errorf("ingest filters: %w", err)
+		}
+	}
+	for name := range b.Transforms {
+		if err := models.ValidateID(name); err != nil {
+			return fmt.Errorf("transform %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ParseSigningKey parses an Ed25519 private key, either as base64 of its seed
+// or of the full key, or as a PKCS #8 PEM block as written by
+// openssl genpkey -algorithm ed25519
+func ParseSigningKey(value string) (ed25519.PrivateKey, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(value))
+		if block == nil {
+			return nil, fmt.Errorf("invalid PEM signing key")
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key: %w", err)
+		}
+		private, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key is not an Ed25519 key")
+		}
+		return private, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 signing key: %w", err)
+	}
+	switch len(data) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(data), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(data), nil
+	}
+	return nil, fmt.Errorf("signing key is %d bytes, want %d or %d", len(data), ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// ParsePublicKey parses an Ed25519 public key, either as base64 or as a PKIX
+// PEM block as written by openssl pkey -pubout
+func ParsePublicKey(value string) (ed25519.PublicKey, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(value))
+		if block == nil {
+			return nil, fmt.Errorf("invalid PEM public key")
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		public, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key is not an Ed25519 key")
+		}
+		return public, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 public key: %w", err)
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key is %d bytes, want %d", len(data), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// InstanceStatus is an edge instance as last seen polling the bundle
+type InstanceStatus struct {
+	Instance string    `json:"instance"`
+	Address  string    `json:"address"`
+	LastPoll time.Time `json:"last_poll"`
+	// ETag of the bundle the instance had after its last poll
+	ETag    string `json:"etag"`
+	Current bool   `json:"current"` // Whether that bundle is the current one
+}
+
+// Publisher serves the rules and config fragments of the central instance as
+// signed bundles, and keeps track of the edge instances polling them
+type Publisher struct {
+	store     Store
+	key       ed25519.PrivateKey
+	mu        sync.Mutex
+	instances map[string]InstanceStatus
+	// Instances are forgotten once they have not polled for expiry, and at
+	// most maxInstances are kept, since they are named by the edge instances
+	expiry       time.Duration
+	maxInstances int
+	// Hash of the content of the last bundle, and when it was issued
+	content  [sha256.Size]byte
+	issuedAt time.Time
+}
+
+// NewPublisher creates a publisher of the bundles of a store
+func NewPublisher(cfg config.ControlPlaneServeConfig, store Store) (*Publisher, error) {
+	p := &Publisher{
+		store:        store,
+		instances:    make(map[string]InstanceStatus),
+		expiry:       time.Duration(cfg.InstanceExpirySeconds) * time.Second,
+		maxInstances: cfg.MaxInstances,
+	}
+	if cfg.SigningKey != "" {
+		key, err := ParseSigningKey(cfg.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+		p.key = key
+	}
+	return p, nil
+}
+
+// Bundle returns the current signed bundle and its ETag. Rules are ordered
+// by ID, so the same rules always make the same bundle, which keeps its
+// issue time until its content changes.
+func (p *Publisher) Bundle() ([]byte, string, error) {
+	ruleList, err := p.store.GetRules()
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(ruleList, func(i, j int) bool { return ruleList[i].ID < ruleList[j].ID })
+	protection := p.store.Protection()
+	filters := p.store.IngestFilters()
+
+	bundle := Bundle{
+		Rules:         append(make([]*models.Rule, 0, len(ruleList)), ruleList...),
+		Protection:    &protection,
+		IngestFilters: &filters,
+		Transforms:    make(map[string][]byte),
+	}
+	for _, transform := range p.store.GetWasmTransforms() {
+		binary, err := p.store.WasmTransformBinary(transform.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		bundle.Transforms[transform.Name] = binary
+	}
+
+	content, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, "", err
+	}
+	bundle.IssuedAt = p.issue(sha256.Sum256(content))
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, "", err
+	}
+	signed := SignedBundle{Bundle: data}
+	if p.key != nil {
+		signed.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(p.key, data))
+	}
+	out, err := json.Marshal(signed)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum := sha256.Sum256(out)
+	return out, `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// issue returns when the bundle of a content was issued, which is now when
+// the content changed since the last bundle
+func (p *Publisher) issue(content [sha256.Size]byte) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.issuedAt.IsZero() || content != p.content {
+		now := time.Now().UTC()
+		if !now.After(p.issuedAt) {
+			now = p.issuedAt.Add(time.Nanosecond)
+		}
+		p.content, p.issuedAt = content, now
+	}
+	return p.issuedAt
+}
+
+// RecordPoll records a poll by an edge instance, which has the bundle of
+// etag after it. New instances are not recorded while the maximum number of
+// instances have polled within the expiry.
+func (p *Publisher) RecordPoll(r *http.Request, etag string) {
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	instance := r.Header.Get(InstanceHeader)
+	if instance == "" {
+		instance = address
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.instances[instance]; !exists && p.maxInstances > 0 && len(p.instances) >= p.maxInstances {
+		p.expire(now)
+		if len(p.instances) >= p.maxInstances {
+			instancesFullLog.Log(logger.Fields{
+				"instance": instance,
+				"address":  address,
+				"max":      p.maxInstances,
+			})
+			return
+		}
+	}
+	p.instances[instance] = InstanceStatus{
+		Instance: instance,
+		Address:  address,
+		LastPoll: now,
+		ETag:     etag,
+	}
+}
+
+// expire forgets the instances that have not polled within the expiry.
+// p.mu must be held.
+func (p *Publisher) expire(now time.Time) {
+	if p.expiry <= 0 {
+		return
+	}
+	for name, instance := range p.instances {
+		if now.Sub(instance.LastPoll) > p.expiry {
+			delete(p.instances, name)
+		}
+	}
+}
+
+// Instances returns the edge instances that polled the bundle, by name,
+// marking those that have the bundle of the current ETag
+func (p *Publisher) Instances(current string) []InstanceStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire(time.Now())
+
+	instances := make([]InstanceStatus, 0, len(p.instances))
+	for _, instance := range p.instances {
+		instance.Current = instance.ETag == current
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Instance < instances[j].Instance })
+	return instances
+}
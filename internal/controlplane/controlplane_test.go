@@ -0,0 +1,367 @@
+package controlplane
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/rules"
+)
+
+func newEngine(t *testing.T) *rules.Engine {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Aggregator.RulesPath = t.TempDir()
+	engine, err := rules.NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	return engine
+}
+
+func testRule(id string) *models.Rule {
+	return &models.Rule{
+		ID:          id,
+		Name:        id,
+		Enabled:     true,
+		Matcher:     models.MetricMatcher{MetricNames: []string{id + "_total"}},
+		Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 60},
+		Output:      models.OutputConfig{MetricName: id + ":sum"},
+	}
+}
+
+func ruleIDs(t *testing.T, engine *rules.Engine) string {
+	t.Helper()
+	ruleList, err := engine.GetRules()
+	if err != nil {
+		t.Fatalf("GetRules() error = %v", err)
+	}
+	var ids []string
+	for _, rule := range ruleList {
+		ids = append(ids, rule.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// serve serves the bundles of a publisher like the control plane API,
+// counting the responses with a new bundle
+func serve(t *testing.T, publisher *Publisher, served *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bundle, etag, err := publisher.Bundle()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		publisher.RecordPoll(r, etag)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(served, 1)
+		w.Write(bundle)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPoller_AppliesBundle(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	central := newEngine(t)
+	for _, id := range []string{"requests", "latency"} {
+		if err := central.SaveRule(testRule(id)); err != nil {
+			t.Fatalf("SaveRule() error = %v", err)
+		}
+	}
+	if err := central.SetIngestFilters(models.IngestFilters{Drop: []string{"go_gc_*"}}); err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := NewPublisher(config.ControlPlaneServeConfig{
+		SigningKey: base64.StdEncoding.EncodeToString(private.Seed()),
+	}, central)
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	var served int32
+	server := serve(t, publisher, &served)
+
+	edge := newEngine(t)
+	if err := edge.SaveRule(testRule("local")); err != nil {
+		t.Fatal(err)
+	}
+	poller, err := NewPoller(config.ControlPlanePollConfig{
+		URL:             server.URL,
+		Instance:        "cluster-a",
+		IntervalSeconds: 60,
+		TimeoutSeconds:  5,
+		PublicKey:       base64.StdEncoding.EncodeToString(public),
+	}, edge)
+	if err != nil {
+		t.Fatalf("NewPoller() error = %v", err)
+	}
+
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if got := ruleIDs(t, edge); got != "latency,requests" {
+		t.Errorf("rules after the first poll = %s", got)
+	}
+	if got := edge.IngestFilters().Drop; len(got) != 1 || got[0] != "go_gc_*" {
+		t.Errorf("ingest filters = %v", got)
+	}
+	if status := poller.Status(); status.Changed != 4 || status.ETag == "" || status.LastError != "" {
+		t.Errorf("status = %+v, want 4 changes", status)
+	}
+
+	// An unchanged bundle is not downloaded again, and applying it changes nothing
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if atomic.LoadInt32(&served) != 1 || poller.Status().Changed != 0 {
+		t.Errorf("second poll: served %d bundles, changed %d", served, poller.Status().Changed)
+	}
+
+	// Local changes are reverted from the kept bundle
+	if err := edge.DeleteRule("latency"); err != nil {
+		t.Fatal(err)
+	}
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if got := ruleIDs(t, edge); got != "latency,requests" || atomic.LoadInt32(&served) != 1 {
+		t.Errorf("rules after a local change = %s, served %d bundles", got, served)
+	}
+
+	// Central changes are downloaded
+	if err := central.DeleteRule("requests"); err != nil {
+		t.Fatal(err)
+	}
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if got := ruleIDs(t, edge); got != "latency" || atomic.LoadInt32(&served) != 2 {
+		t.Errorf("rules after a central change = %s, served %d bundles", got, served)
+	}
+
+	_, etag, _ := publisher.Bundle()
+	instances := publisher.Instances(etag)
+	if len(instances) != 1 || instances[0].Instance != "cluster-a" || !instances[0].Current {
+		t.Errorf("instances = %+v", instances)
+	}
+}
+
+func TestPublisher_RecordPoll_Bounded(t *testing.T) {
+	publisher, err := NewPublisher(config.ControlPlaneServeConfig{InstanceExpirySeconds: 60, MaxInstances: 2}, newEngine(t))
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	poll := func(instance string) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/control-plane/bundle", nil)
+		r.Header.Set(InstanceHeader, instance)
+		publisher.RecordPoll(r, `"etag"`)
+	}
+	names := func() string {
+		var names []string
+		for _, instance := range publisher.Instances(`"etag"`) {
+			names = append(names, instance.Instance)
+		}
+		return strings.Join(names, ",")
+	}
+
+	// New instances are not recorded beyond the limit, known ones still are
+	poll("a")
+	poll("b")
+	poll("c")
+	poll("a")
+	if got := names(); got != "a,b" {
+		t.Errorf("instances = %s, want a,b", got)
+	}
+
+	// Instances that stopped polling expire, making room for others
+	publisher.mu.Lock()
+	stale := publisher.instances["b"]
+	stale.LastPoll = stale.LastPoll.Add(-2 * time.Minute)
+	publisher.instances["b"] = stale
+	publisher.mu.Unlock()
+	poll("c")
+	if got := names(); got != "a,c" {
+		t.Errorf("instances after b expired = %s, want a,c", got)
+	}
+}
+
+func TestPoller_RejectsUntrustedBundles(t *testing.T) {
+	public, _, _ := ed25519.GenerateKey(rand.Reader)
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+
+	central := newEngine(t)
+	if err := central.SaveRule(testRule("requests")); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, signingKey := range map[string]string{
+		"unsigned":  "",
+		"other key": base64.StdEncoding.EncodeToString(other),
+	} {
+		publisher, err := NewPublisher(config.ControlPlaneServeConfig{SigningKey: signingKey}, central)
+		if err != nil {
+			t.Fatalf("%s: NewPublisher() error = %v", name, err)
+		}
+		var served int32
+		server := serve(t, publisher, &served)
+
+		edge := newEngine(t)
+		poller, err := NewPoller(config.ControlPlanePollConfig{
+			URL:             server.URL,
+			IntervalSeconds: 60,
+			PublicKey:       base64.StdEncoding.EncodeToString(public),
+		}, edge)
+		if err != nil {
+			t.Fatalf("%s: NewPoller() error = %v", name, err)
+		}
+		if err := poller.Poll(); err == nil {
+			t.Errorf("%s: Poll() accepted the bundle", name)
+		}
+		if got := ruleIDs(t, edge); got != "" {
+			t.Errorf("%s: rules applied: %s", name, got)
+		}
+	}
+}
+
+func TestPoller_RejectsReplayedBundles(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	central := newEngine(t)
+	if err := central.SaveRule(testRule("requests")); err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := NewPublisher(config.ControlPlaneServeConfig{
+		SigningKey: base64.StdEncoding.EncodeToString(private.Seed()),
+	}, central)
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+
+	old, _, err := publisher.Bundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _, _ := publisher.Bundle(); string(again) != string(old) {
+		t.Error("an unchanged bundle was issued again")
+	}
+	if err := central.SaveRule(testRule("latency")); err != nil {
+		t.Fatal(err)
+	}
+	current, _, err := publisher.Bundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serves the current bundle, then the older one, both validly signed
+	var served atomic.Value
+	served.Store(current)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(served.Load().([]byte))
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := config.ControlPlanePollConfig{
+		URL:             server.URL,
+		IntervalSeconds: 60,
+		PublicKey:       base64.StdEncoding.EncodeToString(public),
+		StateFile:       filepath.Join(t.TempDir(), "state.json"),
+	}
+	edge := newEngine(t)
+	poller, err := NewPoller(cfg, edge)
+	if err != nil {
+		t.Fatalf("NewPoller() error = %v", err)
+	}
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+
+	served.Store(old)
+	if err := poller.Poll(); err == nil {
+		t.Error("Poll() accepted an older bundle")
+	}
+	if got := ruleIDs(t, edge); got != "latency,requests" {
+		t.Errorf("rules after a replayed bundle = %s", got)
+	}
+
+	// The last issue time is kept across restarts
+	restarted, err := NewPoller(cfg, newEngine(t))
+	if err != nil {
+		t.Fatalf("NewPoller() error = %v", err)
+	}
+	if err := restarted.Poll(); err == nil {
+		t.Error("Poll() after a restart accepted an older bundle")
+	}
+}
+
+func TestNewPoller_RequiresPublicKey(t *testing.T) {
+	cfg := config.ControlPlanePollConfig{URL: "https://central:8080/api/v1/control-plane/bundle", IntervalSeconds: 60}
+	if _, err := NewPoller(cfg, newEngine(t)); err == nil {
+		t.Error("NewPoller() without a public key succeeded")
+	}
+
+	// Unsigned bundles are only accepted when asked for
+	central := newEngine(t)
+	if err := central.SaveRule(testRule("requests")); err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := NewPublisher(config.ControlPlaneServeConfig{}, central)
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	var served int32
+	cfg.URL = serve(t, publisher, &served).URL
+	cfg.InsecureSkipVerify = true
+	edge := newEngine(t)
+	poller, err := NewPoller(cfg, edge)
+	if err != nil {
+		t.Fatalf("NewPoller() error = %v", err)
+	}
+	if err := poller.Poll(); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if got := ruleIDs(t, edge); got != "requests" {
+		t.Errorf("rules = %s", got)
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	for raw, want := range map[string]string{
+		"s3://fleet-config/adaptive-metrics/bundle.json":   "https://fleet-config.s3.amazonaws.com/adaptive-metrics/bundle.json",
+		"https://central:8080/api/v1/control-plane/bundle": "https://central:8080/api/v1/control-plane/bundle",
+		"s3://fleet-config":    "",
+		"ftp://central/bundle": "",
+		"central/api/v1/rules": "",
+	} {
+		got, err := resolveURL(raw)
+		if want == "" {
+			if err == nil {
+				t.Errorf("resolveURL(%q) = %q, want an error", raw, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("resolveURL(%q) = %q, %v, want %q", raw, got, err, want)
+		}
+	}
+}
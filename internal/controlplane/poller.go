@@ -0,0 +1,500 @@
+package controlplane
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// maxBundleBytes bounds the size of a polled bundle
+const maxBundleBytes = 64 << 20
+
+// PollStatus is the state of the polling of the bundle
+type PollStatus struct {
+	URL      string `json:"url"`
+	Instance string `json:"instance"`
+	ETag     string `json:"etag,omitempty"` // ETag of the last bundle received
+	// LastPoll is when the bundle was last polled, and LastReceived when a
+	// new bundle was last received
+	LastPoll     *time.Time `json:"last_poll,omitempty"`
+	LastReceived *time.Time `json:"last_received,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	Changed      int        `json:"changed"` // Rules, transforms and config fragments changed by the last poll
+}
+
+// Poller replaces the rules and config fragments of an edge instance with a
+// bundle polled from a central source. The bundle is only downloaded again
+// when its ETag changes, but every poll applies the last one received, so
+// local changes are reverted within an interval.
+type Poller struct {
+	cfg        config.ControlPlanePollConfig
+	url        string
+	instance   string
+	store      Store
+	publicKey  ed25519.PublicKey
+	httpClient *http.Client
+	mu         sync.Mutex // Serializes polls
+	etag       string
+	bundle     []byte    // Verified bundle of the last response
+	issuedAt   time.Time // When the last bundle received was issued
+	status     PollStatus
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewPoller creates a poller of the bundle at the configured URL
+func NewPoller(cfg config.ControlPlanePollConfig, store Store) (*Poller, error) {
+	bundleURL, err := resolveURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.IntervalSeconds <= 0 {
+		return nil, fmt.Errorf("control plane poll interval must be greater than 0")
+	}
+	instance := cfg.Instance
+	if instance == "" {
+		if instance, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("failed to get the hostname for the control plane instance: %w", err)
+		}
+	}
+
+	p := &Poller{
+		cfg:        cfg,
+		url:        bundleURL,
+		instance:   instance,
+		store:      store,
+		httpClient: &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+		status:     PollStatus{URL: bundleURL, Instance: instance},
+		stopCh:     make(chan struct{}),
+	}
+	switch {
+	case cfg.PublicKey != "":
+		if p.publicKey, err = ParsePublicKey(cfg.PublicKey); err != nil {
+			return nil, err
+		}
+	case !cfg.InsecureSkipVerify:
+		return nil, fmt.Errorf("control plane public key is required unless insecure_skip_verify is set")
+	}
+	if err := p.loadState(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// pollState is the state of the polling kept across restarts
+type pollState struct {
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// loadState reads when the last bundle received was issued, if known
+func (p *Poller) loadState() error {
+	if p.cfg.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p.cfg.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read control plane state: %w", err)
+	}
+	var state pollState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse control plane state: %w", err)
+	}
+	p.issuedAt = state.IssuedAt
+	return nil
+}
+
+// saveState persists when the last bundle received was issued
+func (p *Poller) saveState() error {
+	if p.cfg.StateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(pollState{IssuedAt: p.issuedAt})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.cfg.StateFile), 0755); err != nil {
+		return fmt.Errorf("failed to create control plane state directory: %w", err)
+	}
+	return os.WriteFile(p.cfg.StateFile, data, 0644)
+}
+
+// resolveURL checks the URL of a bundle, turning s3://bucket/key into the
+// HTTPS URL of the object
+func resolveURL(raw string) (string, error) {
+	if strings.HasPrefix(raw, "s3://") {
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(raw, "s3://"), "/")
+		if bucket == "" || key == "" {
+			return "", fmt.Errorf("invalid control plane URL %q: must be s3://bucket/key", raw)
+		}
+		return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucket, key), nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid control plane URL %q: must be an http(s):// or s3:// URL", raw)
+	}
+	return raw, nil
+}
+
+// Start starts polling in the background
+func (p *Poller) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(p.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			if err := p.Poll(); err != nil {
+				logger.LogErrorWithFields("Failed to poll the control plane", logger.Fields{
+					"url":   p.url,
+					"error": err.Error(),
+				})
+			}
+
+			select {
+			case <-p.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops polling and waits for a running poll to finish
+func (p *Poller) Stop() {
+	close(p.stopCh)
+	p.wg.Wait()
+}
+
+// Status returns the state of the polling
+func (p *Poller) Status() PollStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// Poll fetches the bundle if it changed and applies it
+func (p *Poller) Poll() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	received, err := p.fetch()
+	changed := 0
+	if err == nil && p.bundle != nil {
+		changed, err = p.apply()
+	}
+
+	now := time.Now()
+	p.status.LastPoll = &now
+	if received {
+		p.status.LastReceived = &now
+	}
+	p.status.ETag = p.etag
+	p.status.LastError = ""
+	p.status.Changed = changed
+	result := "unchanged"
+	switch {
+	case err != nil:
+		p.status.LastError = err.Error()
+		result = "error"
+	case received || changed > 0:
+		result = "changed"
+	}
+	metrics.RecordControlPlanePoll(result)
+
+	if changed > 0 {
+		logger.LogInfoWithFields("Applied the control plane bundle", logger.Fields{
+			"changed":  changed,
+			"received": received,
+			"etag":     p.etag,
+		})
+	}
+	return err
+}
+
+// fetch downloads the bundle unless the server reports it unchanged, and
+// keeps it once verified. It returns whether a new bundle was received. The
+// caller must hold the lock.
+func (p *Poller) fetch() (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(InstanceHeader, p.instance)
+	if p.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+	}
+	if p.etag != "" && p.bundle != nil {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, fmt.Errorf("failed to get bundle, status code: %d: %s", resp.StatusCode, body)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleBytes+1))
+	if err != nil {
+		return false, err
+	}
+	if len(data) > maxBundleBytes {
+		return false, fmt.Errorf("bundle is larger than %d bytes", maxBundleBytes)
+	}
+	raw, bundle, err := p.verify(data)
+	if err != nil {
+		return false, err
+	}
+	// Bundles are issued again when they change, so an older one is a replay
+	if bundle.IssuedAt.Before(p.issuedAt) {
+		return false, fmt.Errorf("bundle issued at %s is older than the last one received, issued at %s",
+			bundle.IssuedAt.Format(time.RFC3339Nano), p.issuedAt.Format(time.RFC3339Nano))
+	}
+
+	p.bundle = raw
+	p.etag = resp.Header.Get("ETag")
+	if !bundle.IssuedAt.Equal(p.issuedAt) {
+		p.issuedAt = bundle.IssuedAt
+		if err := p.saveState(); err != nil {
+			logger.LogWarnWithFields("Failed to save the control plane state", logger.Fields{
+				"file":  p.cfg.StateFile,
+				"error": err.Error(),
+			})
+		}
+	}
+	return true, nil
+}
+
+// verify checks the signature of a signed bundle and the bundle itself, and
+// returns the bundle, as received and decoded
+func (p *Poller) verify(data []byte) ([]byte, *Bundle, error) {
+	var signed SignedBundle
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse bundle: %w", err)
+	}
+	if len(signed.Bundle) == 0 {
+		return nil, nil, fmt.Errorf("response is not a bundle")
+	}
+
+	// Without a public key, insecure_skip_verify is set
+	if p.publicKey != nil {
+		if signed.Signature == "" {
+			return nil, nil, fmt.Errorf("bundle is not signed")
+		}
+		signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+		if err != nil || !ed25519.Verify(p.publicKey, signed.Bundle, signature) {
+			return nil, nil, fmt.Errorf("invalid bundle signature")
+		}
+	}
+
+	bundle, err := decodeBundle(signed.Bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bundle.IssuedAt.IsZero() {
+		return nil, nil, fmt.Errorf("bundle has no issued_at")
+	}
+	return signed.Bundle, bundle, nil
+}
+
+// decodeBundle parses and validates a bundle
+func decodeBundle(data []byte) (*Bundle, error) {
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle: %w", err)
+	}
+	if err := bundle.validate(); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	return &bundle, nil
+}
+
+// apply makes the local rules and config fragments match the last bundle
+// received. Transforms are saved before the rules applying them and deleted
+// after, and rules missing from the bundle are deleted before the others
+// are saved, so rules can move an output between them. It returns the
+// number of changes. The caller must hold the lock.
+func (p *Poller) apply() (int, error) {
+	// Decoded for every poll, so the store never shares the kept bundle
+	bundle, err := decodeBundle(p.bundle)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	var errs []error
+	current := make(map[string]string)
+	for _, transform := range p.store.GetWasmTransforms() {
+		current[transform.Name] = transform.SHA256
+	}
+	for name, binary := range bundle.Transforms {
+		sum := sha256.Sum256(binary)
+		if current[name] == hex.EncodeToString(sum[:]) {
+			continue
+		}
+		if _, err := p.store.SaveWasmTransform(name, binary); err != nil {
+			errs = append(errs, fmt.Errorf("transform %s: %w", name, err))
+			continue
+		}
+		changed++
+	}
+
+	if bundle.Protection != nil && !sameProtection(p.store.Protection(), *bundle.Protection) {
+		if err := p.store.SetProtection(*bundle.Protection); err != nil {
+			errs = append(errs, fmt.Errorf("protection: %w", err))
+		} else {
+			changed++
+		}
+	}
+	if bundle.IngestFilters != nil && !sameIngestFilters(p.store.IngestFilters(), *bundle.IngestFilters) {
+		if err := p.store.SetIngestFilters(*bundle.IngestFilters); err != nil {
+			errs = append(errs, fmt.Errorf("ingest filters: %w", err))
+		} else {
+			changed++
+		}
+	}
+
+	if bundle.Rules != nil {
+		n, err := p.applyRules(bundle.Rules)
+		changed += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if bundle.Transforms != nil {
+		for name := range current {
+			if _, exists := bundle.Transforms[name]; exists {
+				continue
+			}
+			if err := p.store.DeleteWasmTransform(name); err != nil {
+				errs = append(errs, fmt.Errorf("transform %s: %w", name, err))
+				continue
+			}
+			changed++
+		}
+	}
+
+	if len(errs) > 0 {
+		return changed, fmt.Errorf("failed to apply %d bundle changes, first: %w", len(errs), errs[0])
+	}
+	return changed, nil
+}
+
+// applyRules makes the local rules match the rules of a bundle
+func (p *Poller) applyRules(bundleRules []*models.Rule) (int, error) {
+	localRules, err := p.store.GetRules()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get local rules: %w", err)
+	}
+	local := make(map[string]*models.Rule, len(localRules))
+	for _, rule := range localRules {
+		local[rule.ID] = rule
+	}
+	wanted := make(map[string]bool, len(bundleRules))
+	for _, rule := range bundleRules {
+		wanted[rule.ID] = true
+	}
+
+	changed := 0
+	var errs []error
+	for id := range local {
+		if wanted[id] {
+			continue
+		}
+		if err := p.store.DeleteRule(id); err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", id, err))
+			continue
+		}
+		changed++
+	}
+
+	for _, rule := range bundleRules {
+		existing := local[rule.ID]
+		if existing != nil && sameRule(existing, rule) {
+			continue
+		}
+		if existing == nil {
+			err = p.store.SaveRule(rule)
+		} else {
+			err = p.store.UpdateRule(rule)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.ID, err))
+			continue
+		}
+		changed++
+	}
+
+	if len(errs) > 0 {
+		return changed, fmt.Errorf("failed to apply %d rules, first: %w", len(errs), errs[0])
+	}
+	return changed, nil
+}
+
+// sameRule reports whether two versions of a rule differ only in when and by
+// whom they were changed
+func sameRule(a, b *models.Rule) bool {
+	x, y := *a, *b
+	x.CreatedAt, x.UpdatedAt, x.UpdatedBy = time.Time{}, time.Time{}, ""
+	y.CreatedAt, y.UpdatedAt, y.UpdatedBy = time.Time{}, time.Time{}, ""
+	xData, xErr := json.Marshal(&x)
+	yData, yErr := json.Marshal(&y)
+	return xErr == nil && yErr == nil && bytes.Equal(xData, yData)
+}
+
+// sameProtection reports whether two protection lists are the same
+func sameProtection(a, b models.ProtectionList) bool {
+	return sameStrings(a.Metrics, b.Metrics) && sameStrings(a.Labels, b.Labels) && sameStrings(a.Selectors, b.Selectors)
+}
+
+// sameIngestFilters reports whether two sets of ingest filters are the same
+func sameIngestFilters(a, b models.IngestFilters) bool {
+	return sameStrings(a.Drop, b.Drop) && sameStrings(a.Pass, b.Pass)
+}
+
+// sameStrings reports whether two lists hold the same strings in the same
+// order; nil and empty lists are the same
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -12,7 +12,7 @@ import (
 type MetricProcessor interface {
 	Start()
 	Stop()
-	ProcessMetric(sample *models.MetricSample)
+	ProcessMetric(sample *models.MetricSample) error
 }
 
 // MetricTracker defines the interface for tracking metrics and API operations
@@ -0,0 +1,75 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAggregationReachesPrometheus(t *testing.T) {
+	prom := startPrometheus(t)
+	srv := startServer(t, fmt.Sprintf(`remote_write:
+  enabled: true
+  endpoints:
+    - %q
+  retry_interval_seconds: 1
+  recommendation_metrics_only: false
+`, prom.writeURL()))
+
+	srv.post(t, "/api/v1/rules", map[string]interface{}{
+		"id":      "e2e-inflight-by-path",
+		"name":    "In-flight requests by path",
+		"enabled": true,
+		"matcher": map[string]interface{}{
+			"metric_names": []string{"e2e_inflight_requests"},
+		},
+		"aggregation": map[string]interface{}{
+			"type":             "sum",
+			"interval_seconds": 10,
+			"segmentation":     []string{"path"},
+		},
+		"output": map[string]interface{}{
+			"metric_name": "e2e_inflight_requests_by_path",
+		},
+	})
+
+	now := time.Now()
+	srv.remoteWrite(t, now, []series{
+		{labels: map[string]string{"__name__": "e2e_inflight_requests", "instance": "a", "path": "/users"}, value: 1},
+		{labels: map[string]string{"__name__": "e2e_inflight_requests", "instance": "b", "path": "/users"}, value: 2},
+		{labels: map[string]string{"__name__": "e2e_inflight_requests", "instance": "c", "path": "/users"}, value: 3},
+		{labels: map[string]string{"__name__": "e2e_inflight_requests", "instance": "a", "path": "/orders"}, value: 10},
+		{labels: map[string]string{"__name__": "e2e_inflight_requests", "instance": "b", "path": "/orders"}, value: 20},
+		// Not matched by the rule
+		{labels: map[string]string{"__name__": "e2e_other", "instance": "a", "path": "/users"}, value: 100},
+	})
+
+	// Stopping flushes the open buckets and drains the remote write queue
+	srv.stop(t)
+
+	want := map[string]float64{"/users": 6, "/orders": 30}
+	// Output samples are stamped with the end of their interval, which may
+	// be ahead of the time of the query
+	at := now.Add(time.Minute)
+	eventually(t, 30*time.Second, func() error {
+		samples := prom.query(t, "last_over_time(e2e_inflight_requests_by_path[5m])", at)
+		if len(samples) != len(want) {
+			return fmt.Errorf("got %d aggregated series, want %d: %v", len(samples), len(want), samples)
+		}
+		for _, s := range samples {
+			expected, ok := want[s.Labels["path"]]
+			if !ok {
+				return fmt.Errorf("unexpected aggregated series %v", s.Labels)
+			}
+			if s.Value != expected {
+				return fmt.Errorf("path %s = %v, want %v", s.Labels["path"], s.Value, expected)
+			}
+			if _, ok := s.Labels["instance"]; ok {
+				return fmt.Errorf("aggregated series %v kept the instance label", s.Labels)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,22 @@
+// Package e2e holds the end-to-end tests of the service: the server is
+// started in-process with a Prometheus container as its remote write
+// destination, synthetic series are remote-written to it, and the aggregated
+// series are queried back from Prometheus.
+//
+// The tests need Docker and are only built with the e2e tag:
+//
+//	go test -tags e2e ./internal/e2e/
+//
+// They are skipped when Docker is not available. E2E_PROMETHEUS_IMAGE
+// overrides the Prometheus image.
+//
+// The container is managed with the docker CLI rather than testcontainers-go.
+// The module has a single go.mod, so testcontainers-go would add the Docker
+// Engine client and its dependencies to the dependency graph of the service
+// for the sake of one container, of which the tests only need run, port, logs
+// and rm. What testcontainers-go would otherwise provide is kept: containers
+// are removed when a test ends, and labelled with containerLabel so that
+// those left behind by a killed test run can be removed with
+//
+//	docker rm -f $(docker ps -aq --filter label=adaptive-metrics-e2e)
+package e2e
@@ -0,0 +1,341 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/server"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// defaultPrometheusImage is the Prometheus image the tests run against
+const defaultPrometheusImage = "prom/prometheus:v2.53.0"
+
+// containerLabel labels the containers started by the tests
+const containerLabel = "adaptive-metrics-e2e"
+
+// startupTimeout bounds how long the tests wait for Prometheus and the server
+const startupTimeout = 60 * time.Second
+
+// prometheus is a Prometheus container accepting remote write requests
+type prometheus struct {
+	url string
+}
+
+// startPrometheus runs a Prometheus container with the remote write receiver
+// enabled, removed when the test ends. The test is skipped without Docker.
+// See the package documentation for why the docker CLI is used.
+func startPrometheus(t *testing.T) *prometheus {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not installed")
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skip("docker is not running")
+	}
+
+	image := os.Getenv("E2E_PROMETHEUS_IMAGE")
+	if image == "" {
+		image = defaultPrometheusImage
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm", "--label", containerLabel, "-p", "127.0.0.1::9090", image,
+		"--config.file=/etc/prometheus/prometheus.yml",
+		"--storage.tsdb.path=/prometheus",
+		"--web.enable-remote-write-receiver",
+	).Output()
+	if err != nil {
+		t.Fatalf("Failed to start Prometheus container: %v", commandError(err))
+	}
+	id := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		if t.Failed() {
+			logs, _ := exec.Command("docker", "logs", "--tail", "50", id).CombinedOutput()
+			t.Logf("Prometheus logs:\n%s", logs)
+		}
+		exec.Command("docker", "rm", "-f", id).Run()
+	})
+
+	out, err = exec.Command("docker", "port", id, "9090/tcp").Output()
+	if err != nil {
+		t.Fatalf("Failed to get Prometheus port: %v", commandError(err))
+	}
+	// Docker lists one address per line when it binds several
+	address := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+
+	p := &prometheus{url: "http://" + address}
+	waitReady(t, p.url+"/-/ready")
+	return p
+}
+
+// writeURL is the remote write endpoint of Prometheus
+func (p *prometheus) writeURL() string {
+	return p.url + "/api/v1/write"
+}
+
+// sample is a series of an instant query result
+type sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// query runs an instant PromQL query at a time
+func (p *prometheus) query(t *testing.T, query string, at time.Time) []sample {
+	t.Helper()
+	params := url.Values{
+		"query": {query},
+		"time":  {strconv.FormatFloat(float64(at.UnixMilli())/1000, 'f', 3, 64)},
+	}
+	resp, err := http.Get(p.url + "/api/v1/query?" + params.Encode())
+	if err != nil {
+		t.Fatalf("Failed to query Prometheus: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Metric map[string]string `json:"metric"`
+				Value  [2]interface{}    `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode query response: %v", err)
+	}
+	if result.Status != "success" {
+		t.Fatalf("Query %q failed: %s", query, result.Error)
+	}
+
+	samples := make([]sample, 0, len(result.Data.Result))
+	for _, series := range result.Data.Result {
+		raw, _ := series.Value[1].(string)
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			t.Fatalf("Invalid sample value %q: %v", raw, err)
+		}
+		samples = append(samples, sample{Labels: series.Metric, Value: value})
+	}
+	return samples
+}
+
+// instance is the server under test
+type instance struct {
+	url    string
+	server *server.Server
+	done   chan error
+	// stopped is set once the server has been stopped
+	stopped bool
+}
+
+// startServer starts the server with a config file holding the given YAML on
+// top of settings isolating it in a temporary directory. It is stopped when
+// the test ends, unless the test stops it first.
+func startServer(t *testing.T, extraConfig string) *instance {
+	t.Helper()
+	dir := t.TempDir()
+	port := freePort(t)
+
+	configYAML := fmt.Sprintf(`server:
+  address: "127.0.0.1"
+  port: %d
+  shutdown_timeout_seconds: 10
+aggregator:
+  rules_path: %q
+  aggregation_delay_ms: 0
+usage:
+  history_file: %q
+kubernetes:
+  monitors_dir: %q
+`, port, filepath.Join(dir, "rules"), filepath.Join(dir, "usage-history.json"), filepath.Join(dir, "monitors"))
+	configYAML += extraConfig
+	if err := os.MkdirAll(filepath.Join(dir, "rules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(configYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	inst := &instance{
+		url:    fmt.Sprintf("http://127.0.0.1:%d", port),
+		server: srv,
+		done:   make(chan error, 1),
+	}
+	go func() {
+		inst.done <- srv.Start()
+	}()
+	t.Cleanup(func() {
+		inst.stop(t)
+	})
+
+	waitReady(t, inst.url+"/health")
+	return inst
+}
+
+// stop shuts the server down, which flushes every open aggregation bucket
+// and drains the remote write queue
+func (i *instance) stop(t *testing.T) {
+	t.Helper()
+	if i.stopped {
+		return
+	}
+	i.stopped = true
+	if err := i.server.Stop(); err != nil {
+		t.Errorf("Failed to stop server: %v", err)
+	}
+	if err := <-i.done; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Server failed: %v", err)
+	}
+}
+
+// post sends a JSON request to the API and fails the test unless it succeeds
+func (i *instance) post(t *testing.T, path string, body interface{}) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(i.url+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		t.Fatalf("POST %s returned %d: %s", path, resp.StatusCode, msg.String())
+	}
+}
+
+// series is a synthetic series to remote-write
+type series struct {
+	labels map[string]string
+	value  float64
+}
+
+// remoteWrite sends one sample of each series at a time to the server
+func (i *instance) remoteWrite(t *testing.T, at time.Time, batch []series) {
+	t.Helper()
+	req := &prompb.WriteRequest{}
+	for _, s := range batch {
+		ts := prompb.TimeSeries{
+			Samples: []prompb.Sample{{Value: s.value, Timestamp: at.UnixMilli()}},
+		}
+		names := make([]string, 0, len(s.labels))
+		for name := range s.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: name, Value: s.labels[name]})
+		}
+		req.Timeseries = append(req.Timeseries, ts)
+	}
+
+	data, err := req.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal write request: %v", err)
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, i.url+"/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatalf("Remote write failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.Fatalf("Remote write returned %d", resp.StatusCode)
+	}
+}
+
+// eventually retries a check until it passes or the timeout expires
+func eventually(t *testing.T, timeout time.Duration, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Condition not met after %s: %v", timeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// waitReady waits until a URL answers 200
+func waitReady(t *testing.T, target string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer cancel()
+	eventually(t, startupTimeout, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %d", target, resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+// freePort returns a TCP port free on the loopback interface
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// commandError includes the stderr of a failed command in its error
+func commandError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
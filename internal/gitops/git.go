@@ -0,0 +1,65 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// repo runs git commands in a local clone
+type repo struct {
+	path        string
+	authorName  string
+	authorEmail string
+}
+
+// run runs a git command and returns its trimmed standard output
+func (r *repo) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{
+		"-C", r.path,
+		"-c", "user.name=" + r.authorName,
+		"-c", "user.email=" + r.authorEmail,
+	}, args...)...)
+	// Never wait for credentials on a terminal
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// revision resolves a revision to a commit, or returns an empty string if it
+// does not exist, such as HEAD of a repository without commits
+func (r *repo) revision(ctx context.Context, rev string) string {
+	commit, err := r.run(ctx, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return ""
+	}
+	return commit
+}
+
+// change is a file added (A), modified (M) or deleted (D) between two commits
+// or in the index
+type change struct {
+	status string
+	path   string
+}
+
+// parseNameStatus parses the output of git diff --name-status --no-renames
+func parseNameStatus(output string) []change {
+	var changes []change
+	for _, line := range strings.Split(output, "\n") {
+		status, path, found := strings.Cut(line, "\t")
+		if !found {
+			continue
+		}
+		changes = append(changes, change{status: status[:1], path: path})
+	}
+	return changes
+}
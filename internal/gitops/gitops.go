@@ -0,0 +1,537 @@
+// Package gitops keeps the rules in sync with a Git repository, so rules can
+// be reviewed and changed in Git while the API and UI keep working
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+	"gopkg.in/yaml.v3"
+)
+
+// syncRef points at the commit of the last sync. Rule files changed in the
+// repository since then are imported.
+const syncRef = "refs/adaptive-metrics/last-sync"
+
+// syncTimeout bounds a sync, including pulling and pushing
+const syncTimeout = 5 * time.Minute
+
+// RuleStore is the local rule storage kept in sync with the repository
+type RuleStore interface {
+	GetRules() ([]*models.Rule, error)
+	SaveRule(rule *models.Rule) error
+	UpdateRule(rule *models.Rule) error
+	DeleteRule(id string) error
+}
+
+// Status is the state of the Git sync
+type Status struct {
+	Commit      string     `json:"commit,omitempty"` // Commit of the last sync
+	LastSync    *time.Time `json:"last_sync,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Imported    int        `json:"imported"` // Rules changed from the repository by the last sync
+	Exported    int        `json:"exported"` // Rule files changed by the last sync
+	PullRequest string     `json:"pull_request,omitempty"`
+}
+
+// Syncer keeps the rules in sync with a Git repository. Each sync pulls the
+// branch, imports rule files changed since the previous sync, writes every
+// local rule back to the repository and commits the difference. Rules
+// changed in the repository take precedence over local changes to the same
+// rule.
+type Syncer struct {
+	cfg      config.GitOpsConfig
+	store    RuleStore
+	repo     *repo
+	prs      *pullRequests
+	mu       sync.Mutex // Serializes syncs
+	status   Status
+	proposed string // Tree of the last pull request, so it is not opened again
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewSyncer creates a Git sync for a local clone of the repository
+func NewSyncer(cfg config.GitOpsConfig, store RuleStore) (*Syncer, error) {
+	if cfg.RepoPath == "" {
+		return nil, fmt.Errorf("gitops repo_path is required")
+	}
+	if cfg.IntervalSeconds <= 0 {
+		return nil, fmt.Errorf("gitops interval must be greater than 0")
+	}
+	if cfg.PullRequest.Enabled && (cfg.Remote == "" || cfg.PullRequest.Repository == "") {
+		return nil, fmt.Errorf("gitops pull requests require a remote and a repository")
+	}
+
+	s := &Syncer{
+		cfg:    cfg,
+		store:  store,
+		repo:   &repo{path: cfg.RepoPath, authorName: cfg.AuthorName, authorEmail: cfg.AuthorEmail},
+		stopCh: make(chan struct{}),
+	}
+	if cfg.PullRequest.Enabled {
+		s.prs = newPullRequests(cfg.PullRequest)
+	}
+	if _, err := s.repo.run(context.Background(), "rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("%s is not a Git repository: %w", cfg.RepoPath, err)
+	}
+
+	return s, nil
+}
+
+// Start starts syncing in the background
+func (s *Syncer) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(s.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			if err := s.Sync(); err != nil {
+				logger.LogErrorWithFields("Failed to sync rules with Git", logger.Fields{
+					"repo":  s.cfg.RepoPath,
+					"error": err.Error(),
+				})
+			}
+
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops syncing and waits for a running sync to finish
+func (s *Syncer) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+}
+
+// Status returns the state of the Git sync
+func (s *Syncer) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Sync runs one synchronization
+func (s *Syncer) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	imported, exported, err := s.sync(ctx)
+	now := time.Now()
+	s.status.LastSync = &now
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	s.status.Imported, s.status.Exported = imported, exported
+	metrics.RecordGitOpsSync(err == nil)
+
+	if imported > 0 || exported > 0 {
+		logger.LogInfoWithFields("Synced rules with Git", logger.Fields{
+			"imported": imported,
+			"exported": exported,
+			"commit":   s.status.Commit,
+		})
+	}
+	return err
+}
+
+// sync imports, exports and commits. The caller must hold the lock.
+func (s *Syncer) sync(ctx context.Context) (imported, exported int, err error) {
+	if err := s.pull(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	// Import errors are reported after the export, which keeps the files of
+	// rules that failed to import
+	imported, failed, importErr := s.importChanges(ctx)
+
+	changes, err := s.export(failed)
+	if err != nil {
+		return imported, 0, err
+	}
+	if _, err := s.repo.run(ctx, "add", "--all", "--", s.cfg.RulesDir); err != nil {
+		return imported, 0, err
+	}
+	staged, err := s.repo.run(ctx, "diff", "--cached", "--name-status", "--no-renames")
+	if err != nil {
+		return imported, 0, err
+	}
+	exported = len(parseNameStatus(staged))
+
+	if exported > 0 {
+		if s.prs != nil {
+			err = s.propose(ctx, changes)
+		} else {
+			err = s.commit(ctx, changes)
+		}
+		if err != nil {
+			return imported, exported, err
+		}
+	}
+
+	// Files that failed to import are imported again by the next sync;
+	// importing files that were already imported changes nothing
+	head := s.repo.revision(ctx, "HEAD")
+	if head != "" && importErr == nil {
+		if _, err := s.repo.run(ctx, "update-ref", syncRef, head); err != nil {
+			return imported, exported, err
+		}
+	}
+	s.status.Commit = head
+
+	return imported, exported, importErr
+}
+
+// pull brings the branch up to date with the remote, keeping local commits
+// that were not pushed on top
+func (s *Syncer) pull(ctx context.Context) error {
+	if s.cfg.Remote == "" {
+		return nil
+	}
+	if _, err := s.repo.run(ctx, "fetch", "--quiet", s.cfg.Remote, s.cfg.Branch); err != nil {
+		return err
+	}
+	upstream := s.repo.revision(ctx, "FETCH_HEAD")
+	if upstream == "" {
+		return nil // The remote branch does not exist yet
+	}
+
+	if s.repo.revision(ctx, "HEAD") == "" {
+		_, err := s.repo.run(ctx, "checkout", "--quiet", "-B", s.cfg.Branch, upstream)
+		return err
+	}
+	if _, err := s.repo.run(ctx, "rebase", "--quiet", upstream); err != nil {
+		s.repo.run(ctx, "rebase", "--abort")
+		return err
+	}
+	return nil
+}
+
+// importChanges applies the rule files changed since the previous sync to
+// the local rules. On the first sync all rule files are imported. It returns
+// the number of rules changed and the files that could not be imported.
+func (s *Syncer) importChanges(ctx context.Context) (int, map[string]bool, error) {
+	failed := make(map[string]bool)
+	local := make(map[string]*models.Rule)
+	rules, err := s.store.GetRules()
+	if err != nil {
+		return 0, failed, err
+	}
+	for _, rule := range rules {
+		local[rule.ID] = rule
+	}
+
+	last, head := s.repo.revision(ctx, syncRef), s.repo.revision(ctx, "HEAD")
+	var changes []change
+	switch {
+	case last == "":
+		files, err := s.ruleFiles()
+		if err != nil {
+			return 0, failed, err
+		}
+		for _, path := range files {
+			changes = append(changes, change{status: "A", path: path})
+		}
+	case last != head:
+		output, err := s.repo.run(ctx, "diff", "--name-status", "--no-renames", last, head, "--", s.cfg.RulesDir)
+		if err != nil {
+			return 0, failed, err
+		}
+		changes = parseNameStatus(output)
+	}
+
+	imported := 0
+	var errs []error
+	for _, c := range changes {
+		if !isRuleFile(c.path) {
+			continue
+		}
+		changed, err := s.importChange(ctx, c, last, local)
+		if err != nil {
+			failed[c.path] = true
+			errs = append(errs, fmt.Errorf("%s: %w", c.path, err))
+			continue
+		}
+		if changed {
+			imported++
+		}
+	}
+
+	if len(errs) > 0 {
+		return imported, failed, fmt.Errorf("failed to import %d rule files, first: %w", len(errs), errs[0])
+	}
+	return imported, failed, nil
+}
+
+// importChange applies one changed rule file, returning whether a local rule
+// changed
+func (s *Syncer) importChange(ctx context.Context, c change, last string, local map[string]*models.Rule) (bool, error) {
+	if c.status == "D" {
+		// The ID is read from the deleted file, which may not match its name
+		id := strings.TrimSuffix(filepath.Base(c.path), filepath.Ext(c.path))
+		if data, err := s.repo.run(ctx, "show", last+":"+c.path); err == nil {
+			var rule models.Rule
+			if yaml.Unmarshal([]byte(data), &rule) == nil && rule.ID != "" {
+				id = rule.ID
+			}
+		}
+		if local[id] == nil {
+			return false, nil
+		}
+		return true, s.store.DeleteRule(id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.cfg.RepoPath, c.path))
+	if err != nil {
+		return false, err
+	}
+	rule, err := parseRule(c.path, data)
+	if err != nil {
+		return false, err
+	}
+	existing := local[rule.ID]
+	if existing != nil && sameRule(existing, rule) {
+		return false, nil
+	}
+
+	// The history of the rule records who changed it in the repository
+	if author, err := s.repo.run(ctx, "log", "-1", "--format=%an", "--", c.path); err == nil && author != "" {
+		rule.UpdatedBy = author
+	}
+	rule.UpdatedAt = time.Now()
+	if existing == nil {
+		if rule.CreatedAt.IsZero() {
+			rule.CreatedAt = rule.UpdatedAt
+		}
+		return true, s.store.SaveRule(rule)
+	}
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = existing.CreatedAt
+	}
+	return true, s.store.UpdateRule(rule)
+}
+
+// export writes every local rule to the repository and removes the files of
+// rules that no longer exist, except files that failed to import. It
+// returns a description of each rule changed for the commit message.
+func (s *Syncer) export(failed map[string]bool) ([]string, error) {
+	rules, err := s.store.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.ruleFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// Map rule IDs to their files, which may be named differently
+	paths := make(map[string]string)
+	for _, path := range files {
+		data, err := os.ReadFile(filepath.Join(s.cfg.RepoPath, path))
+		if err != nil {
+			return nil, err
+		}
+		if rule, err := parseRule(path, data); err == nil {
+			paths[rule.ID] = path
+		}
+	}
+
+	var changes []string
+	current := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		path, exists := paths[rule.ID]
+		if !exists {
+			path = filepath.Join(s.cfg.RulesDir, rule.ID+".yaml")
+		}
+		current[path] = true
+
+		data, err := yaml.Marshal(rule)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal rule %s: %w", rule.ID, err)
+		}
+		// Files are only rewritten when the rule changed, which keeps their
+		// formatting and avoids commits for timestamps alone
+		full := filepath.Join(s.cfg.RepoPath, path)
+		if existing, err := os.ReadFile(full); err == nil {
+			if parsed, err := parseRule(path, existing); err == nil && sameRule(parsed, rule) {
+				continue
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(full, data, 0644); err != nil {
+			return nil, err
+		}
+
+		action := "Update"
+		if !exists {
+			action = "Add"
+		}
+		changes = append(changes, describeChange(action, rule.ID, rule.UpdatedBy))
+	}
+
+	for _, path := range files {
+		if current[path] || failed[path] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.cfg.RepoPath, path)); err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		changes = append(changes, describeChange("Delete", id, ""))
+	}
+
+	sort.Strings(changes)
+	return changes, nil
+}
+
+// commit commits the staged rule files to the branch and pushes them
+func (s *Syncer) commit(ctx context.Context, changes []string) error {
+	if _, err := s.repo.run(ctx, "commit", "--quiet", "-m", commitMessage(changes)); err != nil {
+		return err
+	}
+	if s.cfg.Remote == "" || !s.cfg.Push {
+		return nil
+	}
+	_, err := s.repo.run(ctx, "push", "--quiet", s.cfg.Remote, "HEAD:refs/heads/"+s.cfg.Branch)
+	return err
+}
+
+// propose commits the staged rule files to a new branch and opens a pull
+// request, leaving the branch unchanged until it is merged. The same changes
+// are proposed only once.
+func (s *Syncer) propose(ctx context.Context, changes []string) error {
+	tree, err := s.repo.run(ctx, "write-tree")
+	if err != nil {
+		return err
+	}
+	defer s.discard(ctx)
+	if tree == s.proposed {
+		return nil
+	}
+
+	branch := fmt.Sprintf("adaptive-metrics/rules-%d", time.Now().Unix())
+	message := commitMessage(changes)
+	if _, err := s.repo.run(ctx, "checkout", "--quiet", "-b", branch); err != nil {
+		return err
+	}
+	_, err = s.repo.run(ctx, "commit", "--quiet", "-m", message)
+	if err == nil {
+		_, err = s.repo.run(ctx, "push", "--quiet", s.cfg.Remote, branch)
+	}
+	if _, checkoutErr := s.repo.run(ctx, "checkout", "--quiet", s.cfg.Branch); checkoutErr != nil && err == nil {
+		err = checkoutErr
+	}
+	if err != nil {
+		return err
+	}
+
+	title, body, _ := strings.Cut(message, "\n\n")
+	url, err := s.prs.open(ctx, branch, s.cfg.Branch, title, body)
+	if err != nil {
+		return err
+	}
+	s.proposed = tree
+	s.status.PullRequest = url
+	return nil
+}
+
+// discard resets the rule files to the branch after proposing changes
+func (s *Syncer) discard(ctx context.Context) {
+	if s.repo.revision(ctx, "HEAD") == "" {
+		return
+	}
+	s.repo.run(ctx, "reset", "--quiet", "HEAD", "--", s.cfg.RulesDir)
+	s.repo.run(ctx, "checkout", "--quiet", "HEAD", "--", s.cfg.RulesDir)
+	s.repo.run(ctx, "clean", "--quiet", "--force", "--", s.cfg.RulesDir)
+}
+
+// ruleFiles returns the rule files in the repository, relative to its root
+func (s *Syncer) ruleFiles() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.cfg.RepoPath, s.cfg.RulesDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isRuleFile(entry.Name()) {
+			files = append(files, filepath.Join(s.cfg.RulesDir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// isRuleFile reports whether a path is a rule file
+func isRuleFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// parseRule parses a rule file; a rule without an ID takes the file name
+func parseRule(path string, data []byte) (*models.Rule, error) {
+	var rule models.Rule
+	if err := yaml.Unmarshal(data, &rule); err != nil {
+		return nil, fmt.Errorf("failed to parse rule: %w", err)
+	}
+	if rule.ID == "" {
+		rule.ID = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return &rule, nil
+}
+
+// sameRule reports whether two versions of a rule differ only in when and by
+// whom they were changed
+func sameRule(a, b *models.Rule) bool {
+	x, y := *a, *b
+	x.CreatedAt, x.UpdatedAt, x.UpdatedBy = time.Time{}, time.Time{}, ""
+	y.CreatedAt, y.UpdatedAt, y.UpdatedBy = time.Time{}, time.Time{}, ""
+	xData, xErr := yaml.Marshal(&x)
+	yData, yErr := yaml.Marshal(&y)
+	return xErr == nil && yErr == nil && bytes.Equal(xData, yData)
+}
+
+// describeChange describes a rule change in a commit message
+func describeChange(action, id, author string) string {
+	if author == "" {
+		return fmt.Sprintf("%s rule %s", action, id)
+	}
+	return fmt.Sprintf("%s rule %s (by %s)", action, id, author)
+}
+
+// commitMessage builds the message of a commit of rule changes
+func commitMessage(changes []string) string {
+	switch len(changes) {
+	case 0:
+		return "Update aggregation rules"
+	case 1:
+		return changes[0]
+	}
+	return fmt.Sprintf("Update %d aggregation rules\n\n- %s", len(changes), strings.Join(changes, "\n- "))
+}
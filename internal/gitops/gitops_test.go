@@ -0,0 +1,167 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+type memoryStore struct {
+	rules map[string]*models.Rule
+}
+
+func (s *memoryStore) GetRules() ([]*models.Rule, error) {
+	rules := make([]*models.Rule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (s *memoryStore) SaveRule(rule *models.Rule) error {
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+func (s *memoryStore) UpdateRule(rule *models.Rule) error {
+	if _, exists := s.rules[rule.ID]; !exists {
+		return fmt.Errorf("rule %s does not exist", rule.ID)
+	}
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+func (s *memoryStore) DeleteRule(id string) error {
+	delete(s.rules, id)
+	return nil
+}
+
+func testRule(id, aggregation string) *models.Rule {
+	return &models.Rule{
+		ID:          id,
+		Name:        id,
+		Enabled:     true,
+		Matcher:     models.MetricMatcher{MetricNames: []string{id + "_total"}},
+		Aggregation: models.AggregationConfig{Type: aggregation, IntervalSeconds: 60},
+		Output:      models.OutputConfig{MetricName: id + "_aggregated"},
+	}
+}
+
+// git runs a git command in dir for setting up tests
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	r := &repo{path: dir, authorName: "tester", authorEmail: "tester@example.com"}
+	out, err := r.run(context.Background(), args...)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return out
+}
+
+func TestSyncer_Sync(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	// A bare remote and a clone the syncer works in
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	clone := filepath.Join(t.TempDir(), "clone")
+	git(t, ".", "init", "--quiet", "--bare", "--initial-branch=main", remote)
+	git(t, ".", "clone", "--quiet", remote, clone)
+	git(t, clone, "checkout", "--quiet", "-B", "main")
+
+	// A rule committed to the repository before the first sync
+	if err := os.MkdirAll(filepath.Join(clone, "rules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(clone, "rules", "from-git.yaml"), []byte(
+		"id: from-git\nname: from-git\nenabled: true\nmatcher:\n  metric_names: [from_git_total]\n"+
+			"aggregation:\n  type: sum\n  interval_seconds: 60\noutput:\n  metric_name: from_git_aggregated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, clone, "add", ".")
+	git(t, clone, "commit", "--quiet", "-m", "Add rule")
+	git(t, clone, "push", "--quiet", "origin", "main")
+
+	store := &memoryStore{rules: map[string]*models.Rule{"local": testRule("local", "sum")}}
+	store.rules["local"].UpdatedBy = "alice"
+	syncer, err := NewSyncer(config.GitOpsConfig{
+		RepoPath:        clone,
+		RulesDir:        "rules",
+		Branch:          "main",
+		Remote:          "origin",
+		Push:            true,
+		IntervalSeconds: 60,
+		AuthorName:      "adaptive-metrics",
+		AuthorEmail:     "adaptive-metrics@localhost",
+	}, store)
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+
+	// The first sync imports the repository rule and commits the local one
+	if err := syncer.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if store.rules["from-git"] == nil || store.rules["from-git"].UpdatedBy != "tester" {
+		t.Fatalf("expected rule from-git to be imported with its Git author, got %+v", store.rules["from-git"])
+	}
+	if subject := git(t, remote, "log", "-1", "--format=%s", "main"); subject != "Add rule local (by alice)" {
+		t.Errorf("pushed commit = %q, want Add rule local (by alice)", subject)
+	}
+
+	// A sync without changes commits nothing
+	head := git(t, clone, "rev-parse", "HEAD")
+	if err := syncer.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := git(t, clone, "rev-parse", "HEAD"); got != head {
+		t.Error("expected no commit without changes")
+	}
+
+	// A rule changed and another deleted in the repository are applied
+	// locally, while a local change to a third rule is committed
+	other := filepath.Join(t.TempDir(), "other")
+	git(t, ".", "clone", "--quiet", remote, other)
+	edited := strings.Replace(readFile(t, filepath.Join(other, "rules", "from-git.yaml")), "type: sum", "type: max", 1)
+	if err := os.WriteFile(filepath.Join(other, "rules", "from-git.yaml"), []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, other, "rm", "--quiet", "rules/local.yaml")
+	git(t, other, "commit", "--quiet", "-am", "Edit rules")
+	git(t, other, "push", "--quiet", "origin", "main")
+
+	store.rules["new"] = testRule("new", "avg")
+	if err := syncer.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := store.rules["from-git"].Aggregation.Type; got != "max" {
+		t.Errorf("from-git aggregation = %s, want max", got)
+	}
+	if _, exists := store.rules["local"]; exists {
+		t.Error("expected rule local to be deleted")
+	}
+	git(t, other, "pull", "--quiet", "origin", "main")
+	if _, err := os.Stat(filepath.Join(other, "rules", "new.yaml")); err != nil {
+		t.Errorf("expected rule new to be pushed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(other, "rules", "local.yaml")); !os.IsNotExist(err) {
+		t.Error("expected the deleted rule not to be committed again")
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,70 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+)
+
+// pullRequests opens pull requests through the GitHub API
+type pullRequests struct {
+	cfg        config.GitOpsPullRequestConfig
+	httpClient *http.Client
+}
+
+func newPullRequests(cfg config.GitOpsPullRequestConfig) *pullRequests {
+	return &pullRequests{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// open opens a pull request of head into base and returns its URL
+func (p *pullRequests) open(ctx context.Context, head, base, title, body string) (string, error) {
+	payload, err := json.Marshal(map[string]string{
+		"title": title,
+		"head":  head,
+		"base":  base,
+		"body":  body,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/pulls", strings.TrimSuffix(p.cfg.APIURL, "/"), p.cfg.Repository)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if p.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to open pull request, status code: %d: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.HTMLURL, nil
+}
@@ -0,0 +1,147 @@
+// Package memory applies the garbage collector settings of the service and
+// reports its memory usage.
+package memory
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// Cgroup files holding the memory limit of the container (v2, then v1)
+var cgroupLimitFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// Settings are the garbage collector settings in effect
+type Settings struct {
+	// GOGC is the garbage collection target percentage; -1 when disabled
+	GOGC int `json:"gogc"`
+	// LimitBytes is the soft memory limit; math.MaxInt64 when there is none
+	LimitBytes int64 `json:"limit_bytes"`
+	// ContainerLimitBytes is the memory limit of the container, 0 if unknown
+	ContainerLimitBytes int64 `json:"container_limit_bytes,omitempty"`
+	BallastBytes        int64 `json:"ballast_bytes"`
+	// Source tells where each setting came from: config, env or default
+	Source map[string]string `json:"source"`
+}
+
+var (
+	mu       sync.Mutex
+	settings Settings
+	// ballast is referenced for the lifetime of the process, so the
+	// collector counts it as live heap
+	ballast []byte
+)
+
+// Apply sets the garbage collection target, the soft memory limit and the
+// ballast. Settings also given by the GOGC and GOMEMLIMIT environment
+// variables are left to the runtime.
+func Apply(cfg config.MemoryConfig) (Settings, error) {
+	if cfg.GOGC < -1 || cfg.LimitBytes < 0 || cfg.LimitRatio < 0 || cfg.LimitRatio > 1 || cfg.BallastBytes < 0 {
+		return Settings{}, fmt.Errorf("invalid memory config: gogc must be -1 or more, limit_ratio between 0 and 1 and sizes not negative")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := Settings{Source: map[string]string{"gogc": "default", "limit": "default"}}
+	s.ContainerLimitBytes = containerLimit()
+
+	switch {
+	case os.Getenv("GOGC") != "":
+		s.Source["gogc"] = "env"
+	case cfg.GOGC != 0:
+		debug.SetGCPercent(cfg.GOGC)
+		s.Source["gogc"] = "config"
+	}
+
+	limit := cfg.LimitBytes
+	if limit == 0 && cfg.LimitRatio > 0 && s.ContainerLimitBytes > 0 {
+		limit = int64(float64(s.ContainerLimitBytes) * cfg.LimitRatio)
+	}
+	switch {
+	case os.Getenv("GOMEMLIMIT") != "":
+		s.Source["limit"] = "env"
+	case limit > 0:
+		debug.SetMemoryLimit(limit)
+		s.Source["limit"] = "config"
+	case cfg.LimitRatio > 0:
+		logger.LogWarnWithFields("Container memory limit is unknown, not setting a memory limit", logger.Fields{
+			"limit_ratio": cfg.LimitRatio,
+		})
+	}
+
+	if int64(len(ballast)) != cfg.BallastBytes {
+		ballast = nil
+		if cfg.BallastBytes > 0 {
+			ballast = make([]byte, cfg.BallastBytes)
+		}
+	}
+	s.BallastBytes = int64(len(ballast))
+
+	s.GOGC = currentGCPercent()
+	s.LimitBytes = debug.SetMemoryLimit(-1)
+	settings = s
+
+	logger.LogInfoWithFields("Applied memory settings", logger.Fields{
+		"gogc":          s.GOGC,
+		"limit_bytes":   s.LimitBytes,
+		"ballast_bytes": s.BallastBytes,
+	})
+	return s, nil
+}
+
+// CurrentSettings returns the garbage collector settings in effect
+func CurrentSettings() Settings {
+	mu.Lock()
+	defer mu.Unlock()
+	if settings.Source == nil {
+		return Settings{
+			GOGC:       currentGCPercent(),
+			LimitBytes: debug.SetMemoryLimit(-1),
+			Source:     map[string]string{"gogc": "default", "limit": "default"},
+		}
+	}
+	return settings
+}
+
+// currentGCPercent returns the garbage collection target percentage; the
+// runtime only reports it when changing it
+func currentGCPercent() int {
+	percent := debug.SetGCPercent(100)
+	debug.SetGCPercent(percent)
+	return percent
+}
+
+// containerLimit returns the memory limit of the cgroup of the process, or 0
+// when there is none or it cannot be read
+func containerLimit() int64 {
+	for _, file := range cgroupLimitFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		return parseCgroupLimit(string(data))
+	}
+	return 0
+}
+
+// parseCgroupLimit parses the contents of a cgroup memory limit file. cgroup
+// v2 reports no limit as "max", v1 as a value close to math.MaxInt64.
+func parseCgroupLimit(value string) int64 {
+	value = strings.TrimSpace(value)
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit <= 0 || limit >= math.MaxInt64/2 {
+		return 0
+	}
+	return limit
+}
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"math"
+	"runtime/debug"
+	"strconv"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+)
+
+func TestParseCgroupLimit(t *testing.T) {
+	tests := map[string]int64{
+		"max\n":                 0,
+		"536870912\n":           536870912,
+		"9223372036854771712\n": 0, // cgroup v1 without a limit
+		"":                      0,
+	}
+	for value, want := range tests {
+		if got := parseCgroupLimit(value); got != want {
+			t.Errorf("parseCgroupLimit(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Setenv("GOGC", "")
+	t.Setenv("GOMEMLIMIT", "")
+	previousGC := debug.SetGCPercent(100)
+	previousLimit := debug.SetMemoryLimit(math.MaxInt64)
+	defer func() {
+		debug.SetGCPercent(previousGC)
+		debug.SetMemoryLimit(previousLimit)
+		ballast = nil
+	}()
+
+	settings, err := Apply(config.MemoryConfig{GOGC: 50, LimitBytes: 1 << 30, BallastBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if settings.GOGC != 50 || settings.LimitBytes != 1<<30 || settings.BallastBytes != 1<<20 {
+		t.Errorf("Apply() = %+v, want gogc 50, 1GiB limit and 1MiB ballast", settings)
+	}
+	if settings.Source["gogc"] != "config" || settings.Source["limit"] != "config" {
+		t.Errorf("Apply() sources = %v, want config", settings.Source)
+	}
+	if got := CurrentSettings(); got.GOGC != 50 {
+		t.Errorf("CurrentSettings().GOGC = %d, want 50", got.GOGC)
+	}
+
+	// The environment takes precedence
+	t.Setenv("GOGC", strconv.Itoa(previousGC))
+	settings, err = Apply(config.MemoryConfig{GOGC: 200})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if settings.Source["gogc"] != "env" || settings.GOGC == 200 || settings.BallastBytes != 0 {
+		t.Errorf("Apply() = %+v, want GOGC from the environment and no ballast", settings)
+	}
+
+	if _, err := Apply(config.MemoryConfig{LimitRatio: 1.5}); err == nil {
+		t.Error("Apply() with a limit ratio above 1 should fail")
+	}
+}
@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// seriesSample is a bottom-k sample of the distinct series of a metric: the
+// series with the lowest hashes, which is a uniform sample of the series
+// regardless of how often each one reports. It tells which label values occur
+// together, so the number of distinct combinations of a set of labels can be
+// estimated instead of multiplying their cardinalities. Samples are replaced
+// rather than modified, so snapshots share them without copying.
+type seriesSample struct {
+	size   int
+	names  []string        // Label names, by position in the values of series
+	series []sampledSeries // By ascending hash
+}
+
+// sampledSeries is a series of a sample with the hashes of its label values;
+// 0 for labels the series does not have
+type sampledSeries struct {
+	hash   uint64
+	values []uint64
+}
+
+// newSeriesSample creates an empty sample of up to size series
+func newSeriesSample(size int) *seriesSample {
+	return &seriesSample{size: size}
+}
+
+// full reports whether the sample holds as many series as it may
+func (s *seriesSample) full() bool {
+	return len(s.series) >= s.size
+}
+
+// accepts reports whether a series belongs in the sample and is not in it yet.
+// Once the sample is full, most series are rejected by a single comparison.
+func (s *seriesSample) accepts(hash uint64) bool {
+	if s.size <= 0 || (s.full() && hash >= s.series[len(s.series)-1].hash) {
+		return false
+	}
+	i := sort.Search(len(s.series), func(i int) bool { return s.series[i].hash >= hash })
+	return i == len(s.series) || s.series[i].hash != hash
+}
+
+// add returns a new sample with a series accepted by the sample, evicting the
+// series with the highest hash when it is full. Labels beyond maxLabels names
+// are not recorded.
+func (s *seriesSample) add(hash uint64, labels map[string]string, maxLabels int) *seriesSample {
+	next := &seriesSample{size: s.size, names: s.names}
+	for name, value := range labels {
+		if value == "" || next.position(name) >= 0 || len(next.names) >= maxLabels {
+			continue
+		}
+		if len(next.names) == len(s.names) {
+			next.names = append([]string(nil), s.names...)
+		}
+		next.names = append(next.names, name)
+	}
+
+	added := sampledSeries{hash: hash, values: make([]uint64, len(next.names))}
+	for i, name := range next.names {
+		if value := labels[name]; value != "" {
+			added.values[i] = valueHash(value)
+		}
+	}
+
+	i := sort.Search(len(s.series), func(i int) bool { return s.series[i].hash >= hash })
+	next.series = make([]sampledSeries, 0, len(s.series)+1)
+	next.series = append(next.series, s.series[:i]...)
+	next.series = append(next.series, added)
+	next.series = append(next.series, s.series[i:]...)
+	if len(next.series) > next.size {
+		next.series = next.series[:next.size]
+	}
+	return next
+}
+
+// position returns the position of a label name in the values of series, or -1
+func (s *seriesSample) position(name string) int {
+	for i, n := range s.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// merge returns the bottom-k sample of the union of two samples, such as the
+// two sketch generations of a metric
+func (s *seriesSample) merge(other *seriesSample) *seriesSample {
+	if other == nil || len(other.series) == 0 {
+		return s
+	}
+	if len(s.series) == 0 {
+		return other
+	}
+
+	merged := &seriesSample{size: s.size, names: append([]string(nil), s.names...)}
+	for _, name := range other.names {
+		if merged.position(name) < 0 {
+			merged.names = append(merged.names, name)
+		}
+	}
+	remap := func(from *seriesSample, series sampledSeries) sampledSeries {
+		values := make([]uint64, len(merged.names))
+		for i, name := range from.names {
+			if i < len(series.values) {
+				values[merged.position(name)] = series.values[i]
+			}
+		}
+		return sampledSeries{hash: series.hash, values: values}
+	}
+
+	i, j := 0, 0
+	for len(merged.series) < merged.size && (i < len(s.series) || j < len(other.series)) {
+		switch {
+		case j == len(other.series) || (i < len(s.series) && s.series[i].hash < other.series[j].hash):
+			merged.series = append(merged.series, remap(s, s.series[i]))
+			i++
+		case i == len(s.series) || other.series[j].hash < s.series[i].hash:
+			merged.series = append(merged.series, remap(other, other.series[j]))
+			j++
+		default:
+			// The same series in both
+			merged.series = append(merged.series, remap(s, s.series[i]))
+			i++
+			j++
+		}
+	}
+	return merged
+}
+
+// distinct estimates the number of distinct combinations of values of labels
+// among total series. A sample that is not full holds every series, so the
+// count is exact; otherwise it is extrapolated with the GEE estimator, which
+// scales up the combinations seen only once in the sample.
+func (s *seriesSample) distinct(labels []string, total int) int {
+	positions := make([]int, len(labels))
+	for i, label := range labels {
+		positions[i] = s.position(label)
+	}
+
+	counts := make(map[uint64]int)
+	var buf [8]byte
+	for _, series := range s.series {
+		hash := xxhash.New()
+		for _, position := range positions {
+			var value uint64
+			if position >= 0 && position < len(series.values) {
+				value = series.values[position]
+			}
+			for b := range buf {
+				buf[b] = byte(value >> (8 * b))
+			}
+			hash.Write(buf[:])
+		}
+		counts[hash.Sum64()]++
+	}
+
+	if !s.full() {
+		return len(counts)
+	}
+	singles, repeated := 0, 0
+	for _, count := range counts {
+		if count == 1 {
+			singles++
+		} else {
+			repeated++
+		}
+	}
+	scale := math.Sqrt(math.Max(float64(total), float64(len(s.series))) / float64(len(s.series)))
+	return int(math.Round(scale*float64(singles))) + repeated
+}
+
+// JointCardinality estimates the number of distinct combinations of values of
+// the given labels, the series a metric keeps when aggregated by them. It uses
+// the series sample of the metric when there is one; otherwise it multiplies
+// the cardinalities of the labels, which overestimates correlated labels.
+// Either way the estimate does not exceed the cardinality of the metric.
+func (info *MetricUsageInfo) JointCardinality(labels []string) int {
+	product := 1
+	for _, label := range labels {
+		if cardinality := info.LabelCardinality[label]; cardinality > 1 {
+			if product > math.MaxInt32/cardinality {
+				product = math.MaxInt32
+				break
+			}
+			product *= cardinality
+		}
+	}
+	if info.Cardinality > 0 && product > info.Cardinality {
+		product = info.Cardinality
+	}
+
+	current, previous := info.seriesSamples[0], info.seriesSamples[1]
+	if current == nil || len(labels) == 0 {
+		return product
+	}
+	sample := current.merge(previous)
+	if len(sample.series) == 0 {
+		return product
+	}
+	estimate := sample.distinct(labels, info.Cardinality)
+	if estimate > product {
+		return product
+	}
+	if estimate < 1 {
+		return 1
+	}
+	return estimate
+}
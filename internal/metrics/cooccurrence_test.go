@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUsageTracker_JointCardinality(t *testing.T) {
+	tracker := NewUsageTracker(time.Hour)
+
+	// Each pod has a single IP and runs on one of 4 nodes
+	for pod := 0; pod < 50; pod++ {
+		tracker.TrackMetric("container_memory_bytes", map[string]string{
+			"pod":    fmt.Sprintf("pod-%d", pod),
+			"pod_ip": fmt.Sprintf("10.0.0.%d", pod),
+			"node":   fmt.Sprintf("node-%d", pod%4),
+		}, 1)
+	}
+
+	info := tracker.GetMetricInfo("container_memory_bytes")
+	for _, tt := range []struct {
+		labels []string
+		want   int
+	}{
+		{[]string{"pod", "pod_ip"}, 50},
+		{[]string{"pod", "node"}, 50},
+		{[]string{"node"}, 4},
+		{nil, 1},
+	} {
+		if got := info.JointCardinality(tt.labels); got != tt.want {
+			t.Errorf("JointCardinality(%v) = %d, want %d", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestMetricUsageInfo_JointCardinality_WithoutSample(t *testing.T) {
+	info := &MetricUsageInfo{
+		Cardinality:      100,
+		LabelCardinality: map[string]int{"a": 5, "b": 4, "c": 10},
+	}
+
+	if got := info.JointCardinality([]string{"a", "b"}); got != 20 {
+		t.Errorf("JointCardinality(a, b) = %d, want the product 20", got)
+	}
+	if got := info.JointCardinality([]string{"a", "b", "c"}); got != 100 {
+		t.Errorf("JointCardinality(a, b, c) = %d, want the cardinality 100", got)
+	}
+}
+
+func TestSeriesSample_Distinct(t *testing.T) {
+	sample := newSeriesSample(64)
+	for i := 0; i < 1000; i++ {
+		hash := valueHash(fmt.Sprintf("series-%d", i))
+		if sample.accepts(hash) {
+			sample = sample.add(hash, map[string]string{
+				"id":    fmt.Sprintf("%d", i),
+				"shard": fmt.Sprintf("%d", i%2),
+			}, 64)
+		}
+	}
+	if !sample.full() {
+		t.Fatalf("sample holds %d series, want 64", len(sample.series))
+	}
+
+	// Values seen once are scaled up, repeated ones are not
+	if got := sample.distinct([]string{"shard"}, 1000); got != 2 {
+		t.Errorf("distinct(shard) = %d, want 2", got)
+	}
+	if got := sample.distinct([]string{"id"}, 1000); got < 200 || got > 1000 {
+		t.Errorf("distinct(id) = %d, want an estimate scaled up from 64", got)
+	}
+}
+
+func TestSeriesSample_Merge(t *testing.T) {
+	first, second := newSeriesSample(3), newSeriesSample(3)
+	first = first.add(1, map[string]string{"a": "x"}, 64)
+	first = first.add(5, map[string]string{"a": "y"}, 64)
+	second = second.add(3, map[string]string{"b": "z"}, 64)
+	second = second.add(5, map[string]string{"a": "y"}, 64)
+	second = second.add(7, map[string]string{"b": "w"}, 64)
+
+	merged := first.merge(second)
+	var hashes []uint64
+	for _, series := range merged.series {
+		hashes = append(hashes, series.hash)
+	}
+	if fmt.Sprint(hashes) != "[1 3 5]" {
+		t.Errorf("merged hashes = %v, want the 3 lowest [1 3 5]", hashes)
+	}
+	if got := merged.distinct([]string{"a", "b"}, 4); got != 3 {
+		t.Errorf("distinct(a, b) = %d, want 3", got)
+	}
+	if first.merge(nil) != first {
+		t.Error("merging with no sample should return the sample")
+	}
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+	"unsafe"
+)
+
+// SampledSeries is a series of a metric kept for discovery, with when it was
+// first and last seen
+type SampledSeries struct {
+	Labels    map[string]string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// discoverySample keeps the labels of up to size series of a metric: those
+// with the lowest hashes, so that the sample is uniform and stays the same
+// while its series keep reporting
+type discoverySample struct {
+	size   int
+	hashes []uint64 // Ascending
+	series []SampledSeries
+}
+
+// newDiscoverySample creates an empty sample of up to size series
+func newDiscoverySample(size int) *discoverySample {
+	return &discoverySample{size: size}
+}
+
+// observe records that a series was seen, adding it to the sample if its hash
+// is among the lowest
+func (s *discoverySample) observe(hash uint64, labels map[string]string, now time.Time) {
+	i := sort.Search(len(s.hashes), func(i int) bool { return s.hashes[i] >= hash })
+	if i < len(s.hashes) && s.hashes[i] == hash {
+		s.series[i].LastSeen = now
+		return
+	}
+	if s.size <= 0 || (len(s.hashes) >= s.size && i == len(s.hashes)) {
+		return
+	}
+
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	s.hashes = append(s.hashes, 0)
+	copy(s.hashes[i+1:], s.hashes[i:])
+	s.hashes[i] = hash
+	s.series = append(s.series, SampledSeries{})
+	copy(s.series[i+1:], s.series[i:])
+	s.series[i] = SampledSeries{Labels: copied, FirstSeen: now, LastSeen: now}
+
+	if len(s.hashes) > s.size {
+		s.hashes = s.hashes[:s.size]
+		s.series = s.series[:s.size]
+	}
+}
+
+// expire removes the series last seen before cutoff, making room for others
+func (s *discoverySample) expire(cutoff time.Time) {
+	kept := 0
+	for i, series := range s.series {
+		if series.LastSeen.Before(cutoff) {
+			continue
+		}
+		s.hashes[kept], s.series[kept] = s.hashes[i], series
+		kept++
+	}
+	clear(s.series[kept:])
+	s.hashes, s.series = s.hashes[:kept], s.series[:kept]
+}
+
+// bytes estimates the memory of the sample
+func (s *discoverySample) bytes() int64 {
+	size := int64(unsafe.Sizeof(*s)) + int64(len(s.hashes))*8
+	for _, series := range s.series {
+		size += int64(unsafe.Sizeof(series))
+		for k, v := range series.Labels {
+			size += int64(len(k)+len(v)) + stringEntryBytes
+		}
+	}
+	return size
+}
+
+// SampledSeries returns the series kept for discovery of a metric, up to
+// DiscoverySeriesPerMetric of them. Their labels must not be modified.
+func (ut *UsageTracker) SampledSeries(name string) []SampledSeries {
+	ut.mu.RLock()
+	defer ut.mu.RUnlock()
+
+	usage, exists := ut.metricsUsage[name]
+	if !exists {
+		return nil
+	}
+	return append([]SampledSeries(nil), usage.discovery.series...)
+}
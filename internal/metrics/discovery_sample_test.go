@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUsageTracker_SampledSeries(t *testing.T) {
+	tracker := NewUsageTrackerWithOptions(time.Hour, UsageTrackerOptions{DiscoverySeriesPerMetric: 5})
+	for i := 0; i < 100; i++ {
+		tracker.TrackMetric("requests", map[string]string{"pod": fmt.Sprintf("pod-%d", i), "zone": fmt.Sprintf("zone-%d", i%3)}, 1)
+	}
+
+	// The sample is bounded and holds real series
+	series := tracker.SampledSeries("requests")
+	if len(series) != 5 {
+		t.Fatalf("SampledSeries() = %d series, want 5", len(series))
+	}
+	for _, s := range series {
+		var i int
+		fmt.Sscanf(s.Labels["pod"], "pod-%d", &i)
+		if s.Labels["zone"] != fmt.Sprintf("zone-%d", i%3) {
+			t.Errorf("sampled series %v combines labels of different series", s.Labels)
+		}
+	}
+
+	// Sampled series stay sampled, and are only updated when seen again
+	first := series[0]
+	tracker.TrackMetric("requests", first.Labels, 1)
+	again := tracker.SampledSeries("requests")
+	if again[0].Labels["pod"] != first.Labels["pod"] || !again[0].FirstSeen.Equal(first.FirstSeen) || again[0].LastSeen.Before(first.LastSeen) {
+		t.Errorf("series seen again = %+v, want %+v with a later last seen time", again[0], first)
+	}
+
+	if series := tracker.SampledSeries("unknown"); series != nil {
+		t.Errorf("SampledSeries() of an unknown metric = %v, want nil", series)
+	}
+}
+
+func TestDiscoverySample_Expire(t *testing.T) {
+	sample := newDiscoverySample(2)
+	now := time.Now()
+	sample.observe(1, map[string]string{"pod": "a"}, now.Add(-time.Hour))
+	sample.observe(2, map[string]string{"pod": "b"}, now)
+	// Full with lower hashes
+	sample.observe(3, map[string]string{"pod": "c"}, now)
+	if len(sample.series) != 2 || sample.series[1].Labels["pod"] != "b" {
+		t.Fatalf("sample = %+v, want the series with the lowest hashes", sample.series)
+	}
+
+	// Expired series make room for others
+	sample.expire(now.Add(-time.Minute))
+	sample.observe(3, map[string]string{"pod": "c"}, now)
+	if len(sample.series) != 2 || sample.series[0].Labels["pod"] != "b" || sample.series[1].Labels["pod"] != "c" {
+		t.Errorf("sample after expiring = %+v, want b and c", sample.series)
+	}
+
+	// Disabled samples keep nothing
+	disabled := newDiscoverySample(-1)
+	disabled.observe(1, map[string]string{"pod": "a"}, now)
+	if len(disabled.series) != 0 {
+		t.Errorf("disabled sample = %+v, want empty", disabled.series)
+	}
+}
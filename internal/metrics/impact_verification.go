@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"math"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// minCalibrationFactor bounds how much inaccurate past estimates can lower confidence
+const minCalibrationFactor = 0.5
+
+// calibration accumulates the accuracy of applied recommendations' estimates
+type calibration struct {
+	accuracySum float64
+	outcomes    int
+}
+
+// BeginVerification marks a recommendation as applied and records the usage
+// baseline its measured impact is compared against
+func (re *RecommendationEngine) BeginVerification(rec *models.Recommendation, now time.Time) {
+	applied := now
+	rec.AppliedAt = &applied
+	rec.MeasuredImpact = &models.MeasuredImpact{MeasuredAt: now}
+
+	if len(rec.Rule.Matcher.MetricNames) == 0 {
+		return
+	}
+	if info := re.usageTracker.GetMetricInfo(rec.Rule.Matcher.MetricNames[0]); info != nil {
+		rec.MeasuredImpact.BaselineSamples = info.SampleCount
+	}
+}
+
+// MeasureImpact updates the measured impact of an applied recommendation from
+// the usage of its original and aggregated metrics. Once the verification
+// window has passed the measurement is marked complete and its accuracy feeds
+// into the confidence of future recommendations. It returns true if the
+// measurement was updated.
+func (re *RecommendationEngine) MeasureImpact(rec *models.Recommendation, now time.Time) bool {
+	measured := rec.MeasuredImpact
+	if rec.AppliedAt == nil || measured == nil || measured.Complete || len(rec.Rule.Matcher.MetricNames) == 0 {
+		return false
+	}
+
+	input := re.usageTracker.GetMetricInfo(rec.Rule.Matcher.MetricNames[0])
+	if input == nil {
+		return false
+	}
+	settings := re.Settings()
+
+	measured.MeasuredAt = now
+	measured.InputSeries = input.Cardinality
+	measured.InputSamples = max64(input.SampleCount-measured.BaselineSamples, 0)
+	measured.OutputSeries = 0
+	measured.OutputSamples = 0
+	if output := re.usageTracker.GetMetricInfo(rec.Rule.Output.MetricName); output != nil {
+		measured.OutputSeries = output.Cardinality
+		measured.OutputSamples = output.SampleCount
+	}
+
+	measured.CardinalityReduction = 0
+	measured.SavingsPercentage = 0
+	if measured.OutputSeries > 0 {
+		measured.CardinalityReduction = float64(measured.InputSeries) / float64(measured.OutputSeries)
+		measured.SavingsPercentage = (1.0 - float64(measured.OutputSeries)/float64(measured.InputSeries)) * 100.0
+	}
+	measured.SampleReduction = 0
+	if measured.OutputSamples > 0 {
+		measured.SampleReduction = float64(measured.InputSamples) / float64(measured.OutputSamples)
+	}
+
+	measured.Accuracy = 0
+	if rec.EstimatedImpact != nil && rec.EstimatedImpact.CardinalityReduction > 0 {
+		measured.Accuracy = measured.CardinalityReduction / rec.EstimatedImpact.CardinalityReduction
+	}
+	measured.Diverged = math.Abs(measured.Accuracy-1.0) > settings.DivergenceThreshold
+
+	window := time.Duration(settings.VerificationHours) * time.Hour
+	if now.Sub(*rec.AppliedAt) >= window {
+		measured.Complete = true
+		re.recordOutcome(measured.Accuracy)
+	}
+
+	return true
+}
+
+// recordOutcome adds the accuracy of a completed verification to the calibration.
+// Estimates that were exceeded count as accurate.
+func (re *RecommendationEngine) recordOutcome(accuracy float64) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	re.calibration.accuracySum += min(accuracy, 1.0)
+	re.calibration.outcomes++
+}
+
+// calibrationFactor returns the factor confidence scores are scaled by: the mean
+// accuracy of completed verifications, or 1 when there are none
+func (re *RecommendationEngine) calibrationFactor() float64 {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+
+	if re.calibration.outcomes == 0 {
+		return 1.0
+	}
+	return max(re.calibration.accuracySum/float64(re.calibration.outcomes), minCalibrationFactor)
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestRecommendationEngine_MeasureImpact(t *testing.T) {
+	tracker := NewUsageTracker(24 * time.Hour)
+	engine := NewRecommendationEngine(tracker, 1000, 100, 0.5)
+
+	for i := 0; i < 100; i++ {
+		tracker.TrackMetric("requests", map[string]string{"id": fmt.Sprintf("%d", i)}, 1.0)
+	}
+
+	rec := models.Recommendation{
+		Status: "applied",
+		Rule: models.Rule{
+			Matcher: models.MetricMatcher{MetricNames: []string{"requests"}},
+			Output:  models.OutputConfig{MetricName: "requests_aggregated"},
+		},
+		EstimatedImpact: &models.EstimatedImpact{CardinalityReduction: 50},
+	}
+	applied := time.Now()
+	engine.BeginVerification(&rec, applied)
+	if rec.MeasuredImpact.BaselineSamples != 100 {
+		t.Errorf("BaselineSamples = %d, want 100", rec.MeasuredImpact.BaselineSamples)
+	}
+
+	// After the rule is applied, 100 input series aggregate into 10 output series
+	for i := 0; i < 100; i++ {
+		tracker.TrackMetric("requests", map[string]string{"id": fmt.Sprintf("%d", i)}, 1.0)
+	}
+	for i := 0; i < 10; i++ {
+		tracker.TrackMetric("requests_aggregated", map[string]string{"group": fmt.Sprintf("%d", i)}, 10.0)
+	}
+
+	if !engine.MeasureImpact(&rec, applied.Add(time.Hour)) {
+		t.Fatal("MeasureImpact() = false, want true")
+	}
+	measured := rec.MeasuredImpact
+	if measured.Complete {
+		t.Error("measurement should not be complete inside the verification window")
+	}
+	if measured.InputSamples != 100 || measured.OutputSamples != 10 || measured.SampleReduction != 10 {
+		t.Errorf("samples = %d in, %d out, reduction %v, want 100, 10, 10", measured.InputSamples, measured.OutputSamples, measured.SampleReduction)
+	}
+	if measured.CardinalityReduction != 10 || !almostEqual(measured.Accuracy, 0.2, 0.0001) || !measured.Diverged {
+		t.Errorf("measured = %+v, want reduction 10, accuracy 0.2, diverged", measured)
+	}
+
+	// Confidence is not calibrated until the verification completes
+	if factor := engine.calibrationFactor(); factor != 1.0 {
+		t.Errorf("calibrationFactor() = %v before completion, want 1", factor)
+	}
+
+	if !engine.MeasureImpact(&rec, applied.Add(25*time.Hour)) || !rec.MeasuredImpact.Complete {
+		t.Fatal("measurement should complete after the verification window")
+	}
+	if engine.MeasureImpact(&rec, applied.Add(26*time.Hour)) {
+		t.Error("MeasureImpact() on a completed measurement = true, want false")
+	}
+	if factor := engine.calibrationFactor(); factor != minCalibrationFactor {
+		t.Errorf("calibrationFactor() = %v, want %v", factor, minCalibrationFactor)
+	}
+}
@@ -3,46 +3,162 @@ package metrics
 import (
 	"fmt"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/marcotuna/adaptive-metrics/internal/models"
 )
 
+// RecommendationSettings are the thresholds a metric must meet to be recommended for aggregation
+type RecommendationSettings struct {
+	MinSamples     int64   `json:"min_samples"`
+	MinCardinality int     `json:"min_cardinality"`
+	MinConfidence  float64 `json:"min_confidence"`
+	// VerificationHours is how long the impact of an applied recommendation is measured
+	VerificationHours int `json:"verification_hours"`
+	// DivergenceThreshold is the relative difference between measured and estimated
+	// cardinality reduction above which an applied recommendation is flagged
+	DivergenceThreshold float64 `json:"divergence_threshold"`
+	// MaxIntervalSeconds caps the aggregation interval picked from the
+	// inferred scrape interval of a metric
+	MaxIntervalSeconds int `json:"max_interval_seconds"`
+}
+
+// Validate checks that the settings are within range
+func (s RecommendationSettings) Validate() error {
+	if s.MinSamples < 0 {
+		return fmt.Errorf("min_samples must not be negative")
+	}
+	if s.MinCardinality < 0 {
+		return fmt.Errorf("min_cardinality must not be negative")
+	}
+	if s.MinConfidence < 0 || s.MinConfidence > 1 {
+		return fmt.Errorf("min_confidence must be between 0 and 1")
+	}
+	if s.VerificationHours <= 0 {
+		return fmt.Errorf("verification_hours must be positive")
+	}
+	if s.DivergenceThreshold <= 0 {
+		return fmt.Errorf("divergence_threshold must be positive")
+	}
+	if s.MaxIntervalSeconds <= 0 {
+		return fmt.Errorf("max_interval_seconds must be positive")
+	}
+	return nil
+}
+
+// ConsumerDetector reports the consumers (dashboards, alerts, recording rules)
+// known to query a metric, so recommendations can explain what they affect
+type ConsumerDetector interface {
+	Consumers(metricName string) []string
+}
+
+// ProtectionChecker reports the metrics and labels that must never be aggregated
+type ProtectionChecker interface {
+	IsProtectedMetric(name string) bool
+	ProtectedLabels() []string
+}
+
 // RecommendationEngine analyzes metric usage to generate aggregation rule recommendations
 type RecommendationEngine struct {
-	usageTracker       *UsageTracker
-	minSampleThreshold int64
-	minCardinalityThreshold int
-	minConfidence     float64
+	usageTracker *UsageTracker
+	mu           sync.RWMutex
+	settings     RecommendationSettings
+	consumers    ConsumerDetector
+	protection   ProtectionChecker
+	calibration  calibration
+	strategies   []namedStrategy // Enabled strategies; the built-in one when empty
 }
 
 // NewRecommendationEngine creates a new recommendation engine
 func NewRecommendationEngine(usageTracker *UsageTracker, minSampleThreshold int64, minCardinalityThreshold int, minConfidence float64) *RecommendationEngine {
 	return &RecommendationEngine{
-		usageTracker:       usageTracker,
-		minSampleThreshold: minSampleThreshold,
-		minCardinalityThreshold: minCardinalityThreshold,
-		minConfidence:     minConfidence,
+		usageTracker: usageTracker,
+		settings: RecommendationSettings{
+			MinSamples:     minSampleThreshold,
+			MinCardinality: minCardinalityThreshold,
+			MinConfidence:  minConfidence,
+			VerificationHours:   24,
+			DivergenceThreshold: 0.5,
+			MaxIntervalSeconds:  600,
+		},
+	}
+}
+
+// Settings returns the current recommendation thresholds
+func (re *RecommendationEngine) Settings() RecommendationSettings {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+	return re.settings
+}
+
+// UpdateSettings replaces the recommendation thresholds; they apply to the next generation
+func (re *RecommendationEngine) UpdateSettings(settings RecommendationSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
 	}
+
+	re.mu.Lock()
+	re.settings = settings
+	re.mu.Unlock()
+	return nil
+}
+
+// SetConsumerDetector sets the source of metric consumers listed in recommendation explanations
+func (re *RecommendationEngine) SetConsumerDetector(detector ConsumerDetector) {
+	re.mu.Lock()
+	re.consumers = detector
+	re.mu.Unlock()
+}
+
+// SetProtectionChecker sets the protection list recommendations must respect
+func (re *RecommendationEngine) SetProtectionChecker(checker ProtectionChecker) {
+	re.mu.Lock()
+	re.protection = checker
+	re.mu.Unlock()
+}
+
+// protectionChecker returns the protection list, if one is set
+func (re *RecommendationEngine) protectionChecker() ProtectionChecker {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+	return re.protection
 }
 
 // GenerateRecommendations analyzes metric usage to generate aggregation rule recommendations
 func (re *RecommendationEngine) GenerateRecommendations() []models.Recommendation {
 	var recommendations []models.Recommendation
 	metricsInfo := re.usageTracker.GetAllMetricsInfo()
+	settings := re.Settings()
+	protection := re.protectionChecker()
+	strategies := re.enabledStrategies()
+	env := StrategyEnv{Settings: settings, UsageTracker: re.usageTracker, Now: time.Now()}
 
-	// Filter metrics that meet the criteria for recommendation
+	// Strategies suggesting the same aggregation produce the same ID; the
+	// most confident suggestion is kept
+	byID := make(map[string]int)
 	for _, metricInfo := range metricsInfo {
-		// Skip metrics with low cardinality or sample count
-		if metricInfo.Cardinality < re.minCardinalityThreshold || metricInfo.SampleCount < re.minSampleThreshold {
+		// Never recommend aggregating protected metrics
+		if protection != nil && protection.IsProtectedMetric(metricInfo.MetricName) {
 			continue
 		}
 
-		// Generate recommendations for high-cardinality metrics
-		recommendation := re.generateRecommendationForMetric(metricInfo)
-		if recommendation != nil {
-			recommendations = append(recommendations, *recommendation)
+		for _, s := range strategies {
+			for _, rec := range s.strategy.Recommend(metricInfo, env) {
+				if rec.Confidence < settings.MinConfidence {
+					continue
+				}
+				completeRecommendation(&rec, s.name, metricInfo, env.Now)
+				if i, exists := byID[rec.ID]; exists {
+					if rec.Confidence > recommendations[i].Confidence {
+						recommendations[i] = rec
+					}
+					continue
+				}
+				byID[rec.ID] = len(recommendations)
+				recommendations = append(recommendations, rec)
+			}
 		}
 	}
 
@@ -51,14 +167,25 @@ func (re *RecommendationEngine) GenerateRecommendations() []models.Recommendatio
 
 // generateRecommendationForMetric creates a recommendation for a specific metric
 func (re *RecommendationEngine) generateRecommendationForMetric(metricInfo *MetricUsageInfo) *models.Recommendation {
+	settings := re.Settings()
+
 	// Analyze label cardinality to determine which labels to segment by
-	segmentationLabels := re.determineSegmentationLabels(metricInfo)
+	labelDecisions := re.explainLabels(metricInfo)
+	var segmentationLabels []string
+	for _, decision := range labelDecisions {
+		if decision.Kept {
+			segmentationLabels = append(segmentationLabels, decision.Label)
+		}
+	}
 	if len(segmentationLabels) == 0 {
 		return nil // No good segmentation labels found
 	}
 
 	// Determine the best aggregation type based on metric behavior
 	aggregationType := re.determineAggregationType(metricInfo)
+	if aggregationType == "" {
+		return nil // No meaningful aggregation for this type of metric
+	}
 
 	// Estimate the impact of aggregation
 	estimatedImpact := re.estimateImpact(metricInfo, segmentationLabels)
@@ -67,14 +194,18 @@ func (re *RecommendationEngine) generateRecommendationForMetric(metricInfo *Metr
 	}
 
 	// Calculate confidence score
-	confidence := re.calculateConfidence(metricInfo, estimatedImpact)
-	if confidence < re.minConfidence {
+	breakdown := re.confidenceBreakdown(metricInfo, estimatedImpact)
+	confidence := breakdown.Total()
+	if confidence < settings.MinConfidence {
 		return nil // Low confidence recommendation
 	}
 
+	// Runs suggesting the same aggregation produce the same recommendation and rule IDs
+	id := models.RecommendationID(metricInfo.MetricName, segmentationLabels, aggregationType)
+
 	// Create a rule based on the analysis
 	rule := models.Rule{
-		ID:          fmt.Sprintf("autogen-%s", uuid.New().String()[:8]),
+		ID:          "autogen-" + strings.TrimPrefix(id, "rec-"),
 		Name:        fmt.Sprintf("Recommended aggregation for %s", metricInfo.MetricName),
 		Description: fmt.Sprintf("Automatically generated rule to aggregate high-cardinality metric %s based on usage patterns", metricInfo.MetricName),
 		Enabled:     false, // Default to disabled until user confirms
@@ -105,62 +236,147 @@ func (re *RecommendationEngine) generateRecommendationForMetric(metricInfo *Metr
 	}
 
 	return &models.Recommendation{
-		ID:              uuid.New().String(),
+		ID:              id,
 		CreatedAt:       time.Now(),
 		Rule:            rule,
 		Confidence:      confidence,
 		EstimatedImpact: estimatedImpact,
 		Source:          "usage_analysis",
 		Status:          "pending",
+		Explanation: &models.RecommendationExplanation{
+			Stats: models.RecommendationStats{
+				SampleCount:    metricInfo.SampleCount,
+				Cardinality:    metricInfo.Cardinality,
+				MinSamples:     settings.MinSamples,
+				MinCardinality: settings.MinCardinality,
+				MinConfidence:  settings.MinConfidence,
+			},
+			Labels:     labelDecisions,
+			Consumers:  re.detectConsumers(metricInfo.MetricName),
+			Confidence: breakdown,
+		},
+	}
+}
+
+// detectConsumers returns the known consumers of a metric, if a detector is set
+func (re *RecommendationEngine) detectConsumers(metricName string) []string {
+	re.mu.RLock()
+	detector := re.consumers
+	re.mu.RUnlock()
+
+	if detector == nil {
+		return nil
 	}
+	return detector.Consumers(metricName)
 }
 
 // determineSegmentationLabels analyzes label usage to determine which labels to segment by
 func (re *RecommendationEngine) determineSegmentationLabels(metricInfo *MetricUsageInfo) []string {
-	type labelInfo struct {
-		name        string
-		cardinality int
+	var segmentationLabels []string
+	for _, decision := range re.explainLabels(metricInfo) {
+		if decision.Kept {
+			segmentationLabels = append(segmentationLabels, decision.Label)
+		}
 	}
 
+	return segmentationLabels
+}
+
+// explainLabels decides for every label of a metric whether it is kept for
+// segmentation, ordered by cardinality from lowest to highest
+func (re *RecommendationEngine) explainLabels(metricInfo *MetricUsageInfo) []models.LabelDecision {
 	// Create a list of labels sorted by their cardinality
-	var labels []labelInfo
+	var labels []models.LabelDecision
 	for label, cardinality := range metricInfo.LabelCardinality {
-		labels = append(labels, labelInfo{name: label, cardinality: cardinality})
+		labels = append(labels, models.LabelDecision{Label: label, Cardinality: cardinality})
+	}
+
+	// Protected labels are always kept, regardless of their cardinality. Rules
+	// must keep them even if the metric has not been seen with them yet.
+	protected := make(map[string]bool)
+	if protection := re.protectionChecker(); protection != nil {
+		for _, label := range protection.ProtectedLabels() {
+			protected[label] = true
+			if _, exists := metricInfo.LabelCardinality[label]; !exists {
+				labels = append(labels, models.LabelDecision{Label: label})
+			}
+		}
 	}
 
 	// Sort labels by cardinality from lowest to highest
 	sort.Slice(labels, func(i, j int) bool {
-		return labels[i].cardinality < labels[j].cardinality
+		if labels[i].Cardinality != labels[j].Cardinality {
+			return labels[i].Cardinality < labels[j].Cardinality
+		}
+		return labels[i].Label < labels[j].Label
 	})
 
 	// Select labels with moderate cardinality for segmentation
 	// High cardinality labels are filtered out as they would defeat the purpose of aggregation
 	// Very low cardinality labels might be too coarse for meaningful aggregation
-	var segmentationLabels []string
-	for _, label := range labels {
+	kept := 0
+	var keptLabels []string
+	for i := range labels {
+		label := &labels[i]
+		switch {
+		case protected[label.Label]:
+			label.Kept = true
+			label.Reason = models.LabelReasonProtected
+
+		// Buckets of a histogram are only meaningful with their bound
+		case label.Label == "le" && metricInfo.Type == MetricTypeHistogram:
+			label.Kept = true
+			label.Reason = models.LabelReasonBucketBound
+
 		// Skip labels with extremely high cardinality (more than 20% of total cardinality)
-		if float64(label.cardinality) > float64(metricInfo.Cardinality)*0.2 {
-			continue
-		}
+		case float64(label.Cardinality) > float64(metricInfo.Cardinality)*0.2:
+			label.Reason = models.LabelReasonCardinalityTooHigh
 
 		// Skip labels with extremely low cardinality (less than 2)
-		if label.cardinality < 2 {
-			continue
-		}
-
-		segmentationLabels = append(segmentationLabels, label.name)
+		case label.Cardinality < 2:
+			label.Reason = models.LabelReasonCardinalityTooLow
 
 		// Limit to 3 segmentation labels for efficiency
-		if len(segmentationLabels) >= 3 {
-			break
+		case kept >= 3:
+			label.Reason = models.LabelReasonSegmentationLimit
+
+		// Skip labels whose values combine with those already kept into too
+		// many series, even if each label alone has a moderate cardinality
+		case float64(metricInfo.JointCardinality(append(keptLabels, label.Label))) > float64(metricInfo.Cardinality)*0.2:
+			label.Reason = models.LabelReasonJointCardinalityTooHigh
+
+		default:
+			label.Kept = true
+			label.Reason = models.LabelReasonKept
+			keptLabels = append(keptLabels, label.Label)
+			kept++
 		}
 	}
 
-	return segmentationLabels
+	return labels
 }
 
-// determineAggregationType determines the best aggregation type based on metric behavior
+// determineAggregationType determines the best aggregation type based on metric behavior.
+// It returns an empty type for metrics that cannot be aggregated meaningfully.
 func (re *RecommendationEngine) determineAggregationType(metricInfo *MetricUsageInfo) string {
+	switch metricInfo.Type {
+	case MetricTypeCounter, MetricTypeHistogram:
+		// Counters and histogram buckets are summed; averaging them is meaningless
+		return "sum"
+	case MetricTypeSummary:
+		// Quantiles of different series cannot be combined
+		if !strings.HasSuffix(metricInfo.MetricName, "_sum") && !strings.HasSuffix(metricInfo.MetricName, "_count") {
+			return ""
+		}
+		return "sum"
+	case MetricTypeGauge:
+		// Summing ratios and temperatures is meaningless
+		switch metricInfo.Unit {
+		case "ratio", "percent", "celsius":
+			return "avg"
+		}
+	}
+
 	// Default to sum for most metrics
 	// In a real implementation, this would include more complex analysis
 	// of the metric's behavior over time
@@ -173,11 +389,13 @@ func (re *RecommendationEngine) determineAggregationType(metricInfo *MetricUsage
 	return "avg"
 }
 
-// determineAggregationInterval determines the best aggregation interval
+// defaultAggregationInterval is used for metrics whose scrape interval is not known yet
+const defaultAggregationInterval = 60
+
+// determineAggregationInterval picks a multiple of the inferred scrape
+// interval, so slowly scraped metrics are not undersampled
 func (re *RecommendationEngine) determineAggregationInterval(metricInfo *MetricUsageInfo) int {
-	// Simple implementation - in a real system, analyze the scrape interval
-	// and typical query patterns to determine the best interval
-	return 60 // Default to 60 seconds
+	return aggregationInterval(metricInfo.ScrapeInterval, defaultAggregationInterval, re.Settings().MaxIntervalSeconds)
 }
 
 // estimateImpact estimates the impact of applying a recommended aggregation
@@ -185,14 +403,9 @@ func (re *RecommendationEngine) estimateImpact(metricInfo *MetricUsageInfo, segm
 	// Estimate cardinality reduction 
 	// (total cardinality / estimated post-aggregation cardinality)
 	
-	// For each segmentation label, estimate its unique values
-	// This is a simplified calculation - in a real system would be more precise
-	estimatedPostAggregationCardinality := 1
-	for _, label := range segmentationLabels {
-		if cardinality, exists := metricInfo.LabelCardinality[label]; exists {
-			estimatedPostAggregationCardinality *= cardinality
-		}
-	}
+	// The combinations of values of the segmentation labels that actually
+	// occur together, rather than the product of their cardinalities
+	estimatedPostAggregationCardinality := metricInfo.JointCardinality(segmentationLabels)
 	
 	// Ensure we don't divide by zero
 	if estimatedPostAggregationCardinality == 0 {
@@ -215,10 +428,17 @@ func (re *RecommendationEngine) estimateImpact(metricInfo *MetricUsageInfo, segm
 
 // calculateConfidence calculates a confidence score for the recommendation
 func (re *RecommendationEngine) calculateConfidence(metricInfo *MetricUsageInfo, impact *models.EstimatedImpact) float64 {
+	breakdown := re.confidenceBreakdown(metricInfo, impact)
+	return breakdown.Total()
+}
+
+// confidenceBreakdown calculates the weighted components of a recommendation's confidence score
+func (re *RecommendationEngine) confidenceBreakdown(metricInfo *MetricUsageInfo, impact *models.EstimatedImpact) models.ConfidenceBreakdown {
 	// Simple formula: confidence is based on:
 	// 1. Sample count (more samples = more confidence)
 	// 2. Cardinality (higher cardinality = higher confidence)
 	// 3. Impact (higher impact = higher confidence)
+	// 4. Cardinality trend (growing metrics are more urgent, shrinking ones less)
 	
 	// Normalize sample count (0.0 - 1.0)
 	sampleScore := min(float64(metricInfo.SampleCount)/10000.0, 1.0)
@@ -230,7 +450,43 @@ func (re *RecommendationEngine) calculateConfidence(metricInfo *MetricUsageInfo,
 	impactScore := min(impact.CardinalityReduction/100.0, 1.0)
 	
 	// Combined confidence score (weighted average)
-	confidence := (sampleScore*0.3 + cardinalityScore*0.4 + impactScore*0.3)
-	
-	return confidence
-}
\ No newline at end of file
+	breakdown := models.ConfidenceBreakdown{
+		SampleScore:      sampleScore * 0.3,
+		CardinalityScore: cardinalityScore * 0.4,
+		ImpactScore:      impactScore * 0.3,
+	}
+	confidence := breakdown.SampleScore + breakdown.CardinalityScore + breakdown.ImpactScore
+
+	// Adjust by the cardinality trend over the last day, up to +/- 0.1
+	if trend, ok := re.usageTracker.CardinalityTrend(metricInfo.MetricName, 24*time.Hour); ok {
+		adjusted := confidence
+		if trend > 0 {
+			adjusted += min(trend, 1.0) * 0.1
+		} else if trend < -0.1 {
+			adjusted += max(trend, -1.0) * 0.1
+		}
+		breakdown.TrendAdjustment = max(min(adjusted, 1.0), 0.0) - confidence
+		confidence += breakdown.TrendAdjustment
+	}
+
+	// Scale down by how accurate estimates of applied recommendations turned out
+	breakdown.CalibrationAdjustment = confidence*re.calibrationFactor() - confidence
+
+	return breakdown
+}
+
+// RefreshImpact recomputes the estimated impact of a pending recommendation
+// from the current usage of its metric. It returns false if the metric is no
+// longer tracked.
+func (re *RecommendationEngine) RefreshImpact(rec *models.Recommendation, now time.Time) bool {
+	if rec.Status != "pending" || len(rec.Rule.Matcher.MetricNames) == 0 {
+		return false
+	}
+	info := re.usageTracker.GetMetricInfo(rec.Rule.Matcher.MetricNames[0])
+	if info == nil {
+		return false
+	}
+	rec.EstimatedImpact = re.estimateImpact(info, rec.Rule.Aggregation.Segmentation)
+	rec.ImpactRefreshedAt = &now
+	return true
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -407,4 +408,121 @@ func TestRecommendationEngine_GenerateRecommendationForMetric(t *testing.T) {
 // Helper function for approximate floating point comparison
 func almostEqual(a, b, tolerance float64) bool {
 	return (a-b) < tolerance && (b-a) < tolerance
-}
\ No newline at end of file
+}
+func TestRecommendationEngine_UpdateSettings(t *testing.T) {
+	usageTracker := NewUsageTracker(90 * 24 * time.Hour)
+	engine := NewRecommendationEngine(usageTracker, 1000, 100, 0.5)
+
+	if err := engine.UpdateSettings(RecommendationSettings{MinConfidence: 1.5}); err == nil {
+		t.Error("UpdateSettings() with min_confidence above 1 should fail")
+	}
+	if got := engine.Settings(); got.MinSamples != 1000 || got.MinCardinality != 100 || got.MinConfidence != 0.5 {
+		t.Errorf("Settings() after failed update = %+v, want unchanged", got)
+	}
+
+	want := RecommendationSettings{MinSamples: 10, MinCardinality: 5, MinConfidence: 0.7, VerificationHours: 12, DivergenceThreshold: 0.3, MaxIntervalSeconds: 300}
+	if err := engine.UpdateSettings(want); err != nil {
+		t.Fatalf("UpdateSettings() error = %v", err)
+	}
+	if got := engine.Settings(); got != want {
+		t.Errorf("Settings() = %+v, want %+v", got, want)
+	}
+}
+
+type staticConsumers map[string][]string
+
+func (c staticConsumers) Consumers(metricName string) []string {
+	return c[metricName]
+}
+
+func TestRecommendationEngine_Explanation(t *testing.T) {
+	usageTracker := NewUsageTracker(90 * 24 * time.Hour)
+	engine := NewRecommendationEngine(usageTracker, 1000, 100, 0.5)
+	engine.SetConsumerDetector(staticConsumers{"http_requests_total": {"dashboard:api-overview"}})
+
+	recommendation := engine.generateRecommendationForMetric(&MetricUsageInfo{
+		MetricName:  "http_requests_total",
+		SampleCount: 10000,
+		Cardinality: 1000,
+		LabelCardinality: map[string]int{
+			"method":      4,
+			"status_code": 5,
+			"job":         1,
+			"path":        500,
+		},
+	})
+	if recommendation == nil || recommendation.Explanation == nil {
+		t.Fatalf("generateRecommendationForMetric() = %+v, want a recommendation with an explanation", recommendation)
+	}
+
+	explanation := recommendation.Explanation
+	if explanation.Stats.SampleCount != 10000 || explanation.Stats.Cardinality != 1000 || explanation.Stats.MinCardinality != 100 {
+		t.Errorf("Stats = %+v, want the metric usage and engine thresholds", explanation.Stats)
+	}
+
+	reasons := make(map[string]string)
+	for _, decision := range explanation.Labels {
+		reasons[decision.Label] = decision.Reason
+	}
+	want := map[string]string{
+		"job":         models.LabelReasonCardinalityTooLow,
+		"method":      models.LabelReasonKept,
+		"status_code": models.LabelReasonKept,
+		"path":        models.LabelReasonCardinalityTooHigh,
+	}
+	for label, reason := range want {
+		if reasons[label] != reason {
+			t.Errorf("label %q reason = %q, want %q", label, reasons[label], reason)
+		}
+	}
+
+	if len(explanation.Consumers) != 1 || explanation.Consumers[0] != "dashboard:api-overview" {
+		t.Errorf("Consumers = %v, want [dashboard:api-overview]", explanation.Consumers)
+	}
+
+	c := explanation.Confidence
+	if total := c.Total(); !almostEqual(total, recommendation.Confidence, 0.0001) {
+		t.Errorf("confidence components add up to %v, want %v", total, recommendation.Confidence)
+	}
+}
+
+type staticProtection struct {
+	metrics map[string]bool
+	labels  []string
+}
+
+func (p staticProtection) IsProtectedMetric(name string) bool { return p.metrics[name] }
+func (p staticProtection) ProtectedLabels() []string       { return p.labels }
+
+func TestRecommendationEngine_Protection(t *testing.T) {
+	usageTracker := NewUsageTracker(90 * 24 * time.Hour)
+	engine := NewRecommendationEngine(usageTracker, 1, 1, 0)
+	engine.SetProtectionChecker(staticProtection{
+		metrics: map[string]bool{"slo_requests_total": true},
+		labels:  []string{"tenant"},
+	})
+
+	for _, name := range []string{"slo_requests_total", "http_requests_total"} {
+		for i := 0; i < 200; i++ {
+			usageTracker.TrackMetric(name, map[string]string{
+				"id":     fmt.Sprintf("%d", i),
+				"method": fmt.Sprintf("%d", i%4),
+			}, 1.0)
+		}
+	}
+
+	recommendations := engine.GenerateRecommendations()
+	if len(recommendations) != 1 || recommendations[0].Rule.Matcher.MetricNames[0] != "http_requests_total" {
+		t.Fatalf("GenerateRecommendations() = %d recommendations, want one for http_requests_total", len(recommendations))
+	}
+
+	// Protected labels are kept even when the metric does not carry them
+	segmentation := recommendations[0].Rule.Aggregation.Segmentation
+	found := false
+	for _, label := range segmentation {
+		found = found || label == "tenant"
+	}
+	if !found {
+		t.Errorf("Segmentation = %v, want it to include protected label tenant", segmentation)
+	}
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// scrapeIntervalGaps is the number of recent arrival gaps kept per metric
+	scrapeIntervalGaps = 16
+	// minScrapeIntervalGaps is the number of gaps needed before an interval is inferred
+	minScrapeIntervalGaps = 3
+	// probeStaleAfter is how long the probe series may be silent before another
+	// series of the metric replaces it
+	probeStaleAfter = 10 * time.Minute
+)
+
+// scrapeProbe infers the scrape interval of a metric from the arrivals of one
+// of its series. Every series of a scraped metric reports once per scrape, so
+// following a single one is enough and costs a few bytes per metric.
+type scrapeProbe struct {
+	series uint64
+	last   time.Time
+	gaps   [scrapeIntervalGaps]time.Duration
+	count  int
+	next   int
+}
+
+// observe records the arrival of a sample of a series. Gaps are only recorded
+// for samples analyzed at a rate of 1, as sampled arrivals skip scrapes.
+func (p *scrapeProbe) observe(series uint64, now time.Time, weight int64) {
+	if p.last.IsZero() || (series != p.series && now.Sub(p.last) > probeStaleAfter) {
+		*p = scrapeProbe{series: series, last: now}
+		return
+	}
+	if series != p.series {
+		return
+	}
+
+	gap := now.Sub(p.last)
+	p.last = now
+	// Samples of the same scrape can arrive in one request
+	if weight != 1 || gap < time.Second {
+		return
+	}
+	p.gaps[p.next] = gap
+	p.next = (p.next + 1) % scrapeIntervalGaps
+	if p.count < scrapeIntervalGaps {
+		p.count++
+	}
+}
+
+// seed sets a known interval, such as one imported from another instance,
+// until the arrivals of a series replace it
+func (p *scrapeProbe) seed(interval time.Duration, last time.Time) {
+	*p = scrapeProbe{last: last, count: minScrapeIntervalGaps, next: minScrapeIntervalGaps}
+	for i := 0; i < minScrapeIntervalGaps; i++ {
+		p.gaps[i] = interval
+	}
+}
+
+// interval returns the median of the recent gaps rounded to the second, or 0
+// until enough have been observed. The median ignores late and retried
+// remote write batches.
+func (p *scrapeProbe) interval() time.Duration {
+	if p.count < minScrapeIntervalGaps {
+		return 0
+	}
+	gaps := make([]time.Duration, p.count)
+	copy(gaps, p.gaps[:p.count])
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	return gaps[len(gaps)/2].Round(time.Second)
+}
+
+// aggregationInterval returns the aggregation interval for a metric scraped
+// every scrapeInterval: the smallest multiple of it covering the default
+// interval and at least two scrapes, so each bucket of every series receives
+// samples, capped at maxInterval.
+func aggregationInterval(scrapeInterval time.Duration, defaultSeconds, maxSeconds int) int {
+	scrape := int((scrapeInterval + time.Second - 1) / time.Second)
+	if scrape <= 0 {
+		if defaultSeconds > maxSeconds {
+			return maxSeconds
+		}
+		return defaultSeconds
+	}
+
+	want := 2 * scrape
+	if want < defaultSeconds {
+		want = defaultSeconds
+	}
+	interval := (want + scrape - 1) / scrape * scrape
+	if interval > maxSeconds {
+		// The largest multiple under the ceiling, or the ceiling itself for
+		// metrics scraped less often than that
+		interval = maxSeconds / scrape * scrape
+		if interval == 0 {
+			interval = maxSeconds
+		}
+	}
+	return interval
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScrapeProbe(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var probe scrapeProbe
+
+	// Another series reporting in between is ignored
+	for i := 0; i < 5; i++ {
+		at := start.Add(time.Duration(i) * 30 * time.Second)
+		probe.observe(1, at.Add(time.Duration(i%2)*200*time.Millisecond), 1)
+		probe.observe(2, at.Add(5*time.Second), 1)
+	}
+	// A retried batch arrives right after the previous one
+	probe.observe(1, start.Add(150*time.Second), 1)
+	probe.observe(1, start.Add(150*time.Second+500*time.Millisecond), 1)
+	if got := probe.interval(); got != 30*time.Second {
+		t.Errorf("interval() = %v, want 30s", got)
+	}
+
+	// Sampled arrivals are not recorded
+	sampled := scrapeProbe{}
+	for i := 0; i < 5; i++ {
+		sampled.observe(1, start.Add(time.Duration(i)*time.Minute), 4)
+	}
+	if got := sampled.interval(); got != 0 {
+		t.Errorf("interval() of sampled arrivals = %v, want 0", got)
+	}
+
+	// A stale probe series is replaced by the next one seen
+	probe.observe(2, start.Add(time.Hour), 1)
+	if probe.series != 2 || probe.count != 0 {
+		t.Errorf("probe = %+v, want series 2 without gaps", probe)
+	}
+}
+
+func TestAggregationInterval(t *testing.T) {
+	tests := []struct {
+		scrape time.Duration
+		max    int
+		want   int
+	}{
+		{0, 600, 60},
+		{0, 30, 30},
+		{15 * time.Second, 600, 60},
+		{45 * time.Second, 600, 90},
+		{2 * time.Minute, 600, 240},
+		{5 * time.Minute, 600, 600},
+		{5 * time.Minute, 400, 300},
+		{10 * time.Minute, 300, 300},
+	}
+	for _, tt := range tests {
+		if got := aggregationInterval(tt.scrape, 60, tt.max); got != tt.want {
+			t.Errorf("aggregationInterval(%v, 60, %d) = %d, want %d", tt.scrape, tt.max, got, tt.want)
+		}
+	}
+}
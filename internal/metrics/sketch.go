@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	// minSketchPrecision and maxSketchPrecision bound the log2 register count of a sketch
+	minSketchPrecision = 4
+	maxSketchPrecision = 16
+)
+
+// hyperLogLog estimates the number of distinct hashed items it has seen.
+// While the number of items is small it keeps the exact hash set (sparse mode),
+// so low cardinalities are counted exactly; once the set would use more memory
+// than the registers it switches to the dense HyperLogLog representation.
+type hyperLogLog struct {
+	precision uint8
+	sparse    map[uint64]struct{}
+	registers []uint8
+}
+
+// newHyperLogLog creates a sketch with 2^precision registers
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	return &hyperLogLog{
+		precision: clampPrecision(precision),
+		sparse:    make(map[uint64]struct{}),
+	}
+}
+
+// clampPrecision keeps a configured precision within the supported range
+func clampPrecision(precision uint8) uint8 {
+	if precision < minSketchPrecision {
+		return minSketchPrecision
+	}
+	if precision > maxSketchPrecision {
+		return maxSketchPrecision
+	}
+	return precision
+}
+
+// registerCount returns the number of registers in dense mode
+func (h *hyperLogLog) registerCount() int {
+	return 1 << h.precision
+}
+
+// sparseLimit returns the largest exact set kept before switching to dense mode
+func (h *hyperLogLog) sparseLimit() int {
+	// A map entry costs roughly 16 bytes, a dense register one byte
+	return h.registerCount() / 16
+}
+
+// Add records a hashed item
+func (h *hyperLogLog) Add(hash uint64) {
+	if h.registers == nil {
+		h.sparse[hash] = struct{}{}
+		if len(h.sparse) > h.sparseLimit() {
+			h.toDense()
+		}
+		return
+	}
+	h.addDense(hash)
+}
+
+// addDense updates the register selected by the hash
+func (h *hyperLogLog) addDense(hash uint64) {
+	idx := hash >> (64 - h.precision)
+	// Set a guard bit so the rank is bounded by the remaining bits
+	w := hash<<h.precision | 1<<(h.precision-1)
+	rank := uint8(bits.LeadingZeros64(w)) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// toDense converts the exact hash set into registers
+func (h *hyperLogLog) toDense() {
+	h.registers = make([]uint8, h.registerCount())
+	for hash := range h.sparse {
+		h.addDense(hash)
+	}
+	h.sparse = nil
+}
+
+// Estimate returns the approximate number of distinct items
+func (h *hyperLogLog) Estimate() int {
+	if h.registers == nil {
+		return len(h.sparse)
+	}
+	return estimateRegisters(h.registers)
+}
+
+// EstimateUnion returns the approximate number of distinct items seen by either sketch.
+// Both sketches must have the same precision.
+func (h *hyperLogLog) EstimateUnion(other *hyperLogLog) int {
+	if other == nil {
+		return h.Estimate()
+	}
+
+	if h.registers == nil && other.registers == nil {
+		union := len(h.sparse)
+		for hash := range other.sparse {
+			if _, exists := h.sparse[hash]; !exists {
+				union++
+			}
+		}
+		return union
+	}
+
+	merged := make([]uint8, h.registerCount())
+	for _, sketch := range []*hyperLogLog{h, other} {
+		if sketch.registers != nil {
+			for i, r := range sketch.registers {
+				if r > merged[i] {
+					merged[i] = r
+				}
+			}
+			continue
+		}
+		tmp := &hyperLogLog{precision: sketch.precision, registers: merged}
+		for hash := range sketch.sparse {
+			tmp.addDense(hash)
+		}
+	}
+	return estimateRegisters(merged)
+}
+
+// estimateRegisters computes the HyperLogLog estimate from a register array,
+// falling back to linear counting for small cardinalities
+func estimateRegisters(registers []uint8) int {
+	m := float64(len(registers))
+
+	sum := 0.0
+	zeros := 0
+	for _, r := range registers {
+		sum += 1.0 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int(estimate + 0.5)
+}
+
+// seriesHash returns a stable hash of a label set, independent of map iteration order
+func seriesHash(labels map[string]string) uint64 {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte(0xff)
+		b.WriteString(labels[k])
+		b.WriteByte(0xfe)
+	}
+	return xxhash.Sum64String(b.String())
+}
+
+// valueHash returns the hash of a single label value
+func valueHash(value string) uint64 {
+	return xxhash.Sum64String(value)
+}
+
+// topValues tracks the most frequent values of a label using the Space-Saving
+// algorithm: at most capacity counters are kept, and a new value replaces the
+// least frequent one, inheriting its count as an upper bound on overestimation.
+type topValues struct {
+	capacity int
+	counts   map[string]int64
+}
+
+// newTopValues creates a heavy hitters tracker with the given number of counters
+func newTopValues(capacity int) *topValues {
+	return &topValues{
+		capacity: capacity,
+		counts:   make(map[string]int64, capacity),
+	}
+}
+
+// Add records one occurrence of a value
+func (t *topValues) Add(value string) {
+	if _, exists := t.counts[value]; exists || len(t.counts) < t.capacity {
+		t.counts[value]++
+		return
+	}
+
+	// Replace the least frequent value
+	minValue := ""
+	var minCount int64 = -1
+	for v, c := range t.counts {
+		if minCount < 0 || c < minCount {
+			minValue, minCount = v, c
+		}
+	}
+	delete(t.counts, minValue)
+	t.counts[value] = minCount + 1
+}
+
+// ValueCount is an approximate occurrence count of a label value
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// mergeTopValues combines the counters of several trackers and returns them sorted by count
+func mergeTopValues(trackers ...*topValues) []ValueCount {
+	merged := make(map[string]int64)
+	for _, t := range trackers {
+		if t == nil {
+			continue
+		}
+		for v, c := range t.counts {
+			merged[v] += c
+		}
+	}
+
+	result := make([]ValueCount, 0, len(merged))
+	for v, c := range merged {
+		result = append(result, ValueCount{Value: v, Count: c})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+
+	return result
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLog_Estimate(t *testing.T) {
+	tests := []struct {
+		name      string
+		precision uint8
+		items     int
+		tolerance float64 // Allowed relative error
+	}{
+		{name: "exact while sparse", precision: 10, items: 50, tolerance: 0},
+		{name: "small dense", precision: 10, items: 500, tolerance: 0.1},
+		{name: "large dense", precision: 14, items: 200000, tolerance: 0.03},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sketch := newHyperLogLog(tt.precision)
+			for i := 0; i < tt.items; i++ {
+				// Add every item twice; duplicates must not be counted
+				hash := valueHash(fmt.Sprintf("value-%d", i))
+				sketch.Add(hash)
+				sketch.Add(hash)
+			}
+
+			got := sketch.Estimate()
+			relErr := math.Abs(float64(got)-float64(tt.items)) / float64(tt.items)
+			if relErr > tt.tolerance {
+				t.Errorf("Estimate() = %v, want %v (relative error %.4f > %.4f)", got, tt.items, relErr, tt.tolerance)
+			}
+		})
+	}
+}
+
+func TestHyperLogLog_EstimateUnion(t *testing.T) {
+	a := newHyperLogLog(12)
+	b := newHyperLogLog(12)
+
+	// 0..9999 in a, 5000..14999 in b: 15000 distinct items overall
+	for i := 0; i < 10000; i++ {
+		a.Add(valueHash(fmt.Sprintf("item-%d", i)))
+		b.Add(valueHash(fmt.Sprintf("item-%d", i+5000)))
+	}
+
+	got := a.EstimateUnion(b)
+	if relErr := math.Abs(float64(got)-15000) / 15000; relErr > 0.05 {
+		t.Errorf("EstimateUnion() = %v, want ~15000", got)
+	}
+
+	// Union with a sparse sketch
+	c := newHyperLogLog(12)
+	c.Add(valueHash("item-1"))
+	c.Add(valueHash("item-new"))
+	if got := c.EstimateUnion(newHyperLogLog(12)); got != 2 {
+		t.Errorf("EstimateUnion() of sparse sketches = %v, want 2", got)
+	}
+}
+
+func TestSeriesHash_OrderIndependent(t *testing.T) {
+	a := map[string]string{"a": "1", "b": "2", "c": "3"}
+	b := map[string]string{"c": "3", "b": "2", "a": "1"}
+	if seriesHash(a) != seriesHash(b) {
+		t.Error("seriesHash() differs for equal label sets")
+	}
+
+	// Label boundaries must be part of the hash
+	if seriesHash(map[string]string{"ab": "c"}) == seriesHash(map[string]string{"a": "bc"}) {
+		t.Error("seriesHash() collides for different label sets")
+	}
+}
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// CardinalityStrategy is the name of the built-in strategy, which keeps the
+// labels of a high-cardinality metric worth segmenting by
+const CardinalityStrategy = "cardinality"
+
+// RecommendationStrategy suggests aggregation rules for metrics. Strategies
+// are compiled into the binary, registered with RegisterStrategy from an init
+// function and enabled by name in recommendations.strategies.
+type RecommendationStrategy interface {
+	// Recommend returns the recommendations for a metric, or nil. The engine
+	// fills in the ID, source, status and creation time left empty.
+	Recommend(metric *MetricUsageInfo, env StrategyEnv) []models.Recommendation
+}
+
+// StrategyEnv is the state strategies generate recommendations from
+type StrategyEnv struct {
+	Settings     RecommendationSettings
+	UsageTracker *UsageTracker
+	Now          time.Time
+}
+
+// StrategyFactory creates a strategy from the parameters of its config entry
+type StrategyFactory func(params map[string]interface{}) (RecommendationStrategy, error)
+
+// StrategyConfig enables a registered strategy with its parameters
+type StrategyConfig struct {
+	Name   string
+	Params map[string]interface{}
+}
+
+// namedStrategy is an enabled strategy
+type namedStrategy struct {
+	name     string
+	strategy RecommendationStrategy
+}
+
+var (
+	strategyMu        sync.RWMutex
+	strategyFactories = make(map[string]StrategyFactory)
+)
+
+// RegisterStrategy makes a strategy available under a name. It panics if the
+// name is empty or already registered, like other registries resolved at init.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategyMu.Lock()
+	defer strategyMu.Unlock()
+
+	if name == "" || factory == nil {
+		panic("metrics: RegisterStrategy requires a name and a factory")
+	}
+	if _, exists := strategyFactories[name]; exists || name == CardinalityStrategy {
+		panic(fmt.Sprintf("metrics: recommendation strategy %q registered twice", name))
+	}
+	strategyFactories[name] = factory
+}
+
+// RegisteredStrategies returns the names of the available strategies
+func RegisteredStrategies() []string {
+	strategyMu.RLock()
+	defer strategyMu.RUnlock()
+
+	names := []string{CardinalityStrategy}
+	for name := range strategyFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// SetStrategies enables the configured strategies, which run in order on
+// every generation. Without any, only the built-in strategy runs.
+func (re *RecommendationEngine) SetStrategies(configs []StrategyConfig) error {
+	strategies := make([]namedStrategy, 0, len(configs))
+	seen := make(map[string]bool, len(configs))
+	for _, cfg := range configs {
+		if seen[cfg.Name] {
+			return fmt.Errorf("recommendation strategy %q is enabled twice", cfg.Name)
+		}
+		seen[cfg.Name] = true
+
+		if cfg.Name == CardinalityStrategy {
+			strategies = append(strategies, namedStrategy{name: cfg.Name, strategy: &cardinalityAnalyzer{re}})
+			continue
+		}
+
+		strategyMu.RLock()
+		factory, exists := strategyFactories[cfg.Name]
+		strategyMu.RUnlock()
+		if !exists {
+			return fmt.Errorf("unknown recommendation strategy %q, available: %s", cfg.Name, strings.Join(RegisteredStrategies(), ", "))
+		}
+		strategy, err := factory(cfg.Params)
+		if err != nil {
+			return fmt.Errorf("invalid recommendation strategy %q: %w", cfg.Name, err)
+		}
+		strategies = append(strategies, namedStrategy{name: cfg.Name, strategy: strategy})
+	}
+
+	re.mu.Lock()
+	re.strategies = strategies
+	re.mu.Unlock()
+	return nil
+}
+
+// Strategies returns the names of the enabled strategies, in order
+func (re *RecommendationEngine) Strategies() []string {
+	var names []string
+	for _, s := range re.enabledStrategies() {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// enabledStrategies returns the strategies run on every generation
+func (re *RecommendationEngine) enabledStrategies() []namedStrategy {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+	if len(re.strategies) == 0 {
+		return []namedStrategy{{name: CardinalityStrategy, strategy: &cardinalityAnalyzer{re}}}
+	}
+	return re.strategies
+}
+
+// completeRecommendation fills in the fields a strategy left empty
+func completeRecommendation(rec *models.Recommendation, strategy string, metric *MetricUsageInfo, now time.Time) {
+	if rec.ID == "" {
+		rec.ID = models.RecommendationID(metric.MetricName, rec.Rule.Aggregation.Segmentation, rec.Rule.Aggregation.Type)
+	}
+	if rec.Rule.ID == "" {
+		rec.Rule.ID = "autogen-" + strings.TrimPrefix(rec.ID, "rec-")
+	}
+	if rec.Source == "" {
+		rec.Source = strategy
+	}
+	if rec.Status == "" {
+		rec.Status = "pending"
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = now
+	}
+}
+
+// cardinalityAnalyzer is the built-in strategy
+type cardinalityAnalyzer struct {
+	re *RecommendationEngine
+}
+
+// Recommend suggests aggregating a metric with enough samples and series by
+// the labels worth segmenting by
+func (a *cardinalityAnalyzer) Recommend(metric *MetricUsageInfo, env StrategyEnv) []models.Recommendation {
+	if metric.Cardinality < env.Settings.MinCardinality || metric.SampleCount < env.Settings.MinSamples {
+		return nil
+	}
+	if rec := a.re.generateRecommendationForMetric(metric); rec != nil {
+		return []models.Recommendation{*rec}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// fixedStrategy recommends summing every metric by one label
+type fixedStrategy struct {
+	label      string
+	confidence float64
+}
+
+func (s *fixedStrategy) Recommend(metric *MetricUsageInfo, env StrategyEnv) []models.Recommendation {
+	return []models.Recommendation{{
+		Confidence: s.confidence,
+		Rule: models.Rule{
+			Name:        "fixed " + metric.MetricName,
+			Matcher:     models.MetricMatcher{MetricNames: []string{metric.MetricName}},
+			Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 60, Segmentation: []string{s.label}},
+			Output:      models.OutputConfig{MetricName: metric.MetricName + ":sum"},
+		},
+	}}
+}
+
+func init() {
+	RegisterStrategy("test-fixed", func(params map[string]interface{}) (RecommendationStrategy, error) {
+		label, _ := params["label"].(string)
+		if label == "" {
+			return nil, fmt.Errorf("label is required")
+		}
+		return &fixedStrategy{label: label, confidence: 0.9}, nil
+	})
+}
+
+func TestRecommendationEngine_Strategies(t *testing.T) {
+	tracker := NewUsageTracker(time.Hour)
+	tracker.TrackMetric("requests_total", map[string]string{"service": "api"}, 1)
+	engine := NewRecommendationEngine(tracker, 1000, 100, 0.5)
+
+	if got := engine.Strategies(); len(got) != 1 || got[0] != CardinalityStrategy {
+		t.Errorf("Strategies() = %v, want the built-in strategy by default", got)
+	}
+
+	err := engine.SetStrategies([]StrategyConfig{
+		{Name: CardinalityStrategy},
+		{Name: "test-fixed", Params: map[string]interface{}{"label": "service"}},
+	})
+	if err != nil {
+		t.Fatalf("SetStrategies() error = %v", err)
+	}
+
+	// The metric is below the thresholds of the built-in strategy
+	recs := engine.GenerateRecommendations()
+	if len(recs) != 1 {
+		t.Fatalf("GenerateRecommendations() returned %d recommendations, want 1", len(recs))
+	}
+	rec := recs[0]
+	wantID := models.RecommendationID("requests_total", []string{"service"}, "sum")
+	if rec.ID != wantID || rec.Source != "test-fixed" || rec.Status != "pending" || rec.Rule.ID == "" || rec.CreatedAt.IsZero() {
+		t.Errorf("recommendation = %+v, want ID %s, source test-fixed, pending, with a rule ID and creation time", rec, wantID)
+	}
+}
+
+func TestRecommendationEngine_SetStrategies_Invalid(t *testing.T) {
+	engine := NewRecommendationEngine(NewUsageTracker(time.Hour), 1000, 100, 0.5)
+
+	for _, configs := range [][]StrategyConfig{
+		{{Name: "unknown"}},
+		{{Name: "test-fixed"}}, // Missing parameter
+		{{Name: CardinalityStrategy}, {Name: CardinalityStrategy}},
+	} {
+		if err := engine.SetStrategies(configs); err == nil {
+			t.Errorf("SetStrategies(%v) error = nil, want an error", configs)
+		}
+	}
+}
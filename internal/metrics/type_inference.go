@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"strings"
+)
+
+// Metric types inferred by the usage tracker
+const (
+	MetricTypeCounter   = "counter"
+	MetricTypeGauge     = "gauge"
+	MetricTypeHistogram = "histogram"
+	MetricTypeSummary   = "summary"
+	MetricTypeUnknown   = "unknown"
+)
+
+// Sources of an inferred metric type, from the most to the least reliable
+const (
+	TypeSourceMetadata = "metadata"
+	TypeSourceName     = "name"
+	TypeSourceValues   = "values"
+)
+
+// minValueChanges is the number of value changes of the probe series needed
+// before a type is inferred from them
+const minValueChanges = 5
+
+// unitSuffixes are the base units of the Prometheus naming conventions, and a
+// few common scaled ones, recognized at the end of metric names
+var unitSuffixes = []string{
+	"seconds", "milliseconds", "microseconds", "nanoseconds",
+	"bytes", "bits", "ratio", "percent",
+	"celsius", "meters", "volts", "amperes", "joules", "grams", "hertz", "watts",
+}
+
+// metricMetadata is the type and unit of a metric family sent by clients
+type metricMetadata struct {
+	Type string
+	Unit string
+}
+
+// valueProbe follows the values of the series the scrape probe follows:
+// counters never go down except when they reset, gauges do
+type valueProbe struct {
+	series    uint64
+	last      float64
+	set       bool
+	increases int
+	decreases int
+}
+
+// observe records a value of a series, restarting the probe when it is
+// another series than the one followed so far
+func (p *valueProbe) observe(series uint64, value float64) {
+	if !p.set || series != p.series {
+		*p = valueProbe{series: series, last: value, set: true}
+		return
+	}
+
+	switch {
+	case value > p.last:
+		p.increases++
+	case value < p.last:
+		p.decreases++
+	}
+	p.last = value
+}
+
+// SetMetricMetadata records the type and unit of a metric family, as sent by
+// clients with remote write metadata. The type is one of the metric types;
+// others are ignored. Metadata takes precedence over inference.
+func (ut *UsageTracker) SetMetricMetadata(family, metricType, unit string) {
+	switch metricType {
+	case MetricTypeCounter, MetricTypeGauge, MetricTypeHistogram, MetricTypeSummary:
+	default:
+		metricType = ""
+	}
+	if metricType == "" && unit == "" {
+		return
+	}
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	metadata := &metricMetadata{Type: metricType, Unit: unit}
+	if current, exists := ut.metadata[family]; exists && *current == *metadata {
+		return
+	}
+	ut.metadata[family] = metadata
+	for _, name := range familySeriesNames(family) {
+		if usage, exists := ut.metricsUsage[name]; exists {
+			usage.metadata = metadata
+		}
+	}
+}
+
+// familyMetadata returns the metadata of the family of a series name, if any
+func (ut *UsageTracker) familyMetadata(name string) *metricMetadata {
+	if metadata, exists := ut.metadata[name]; exists {
+		return metadata
+	}
+	for _, suffix := range []string{"_total", "_bucket", "_sum", "_count"} {
+		if family, ok := strings.CutSuffix(name, suffix); ok {
+			if metadata, exists := ut.metadata[family]; exists {
+				return metadata
+			}
+		}
+	}
+	return nil
+}
+
+// familySeriesNames returns the series names a metric family may be tracked as
+func familySeriesNames(family string) []string {
+	return []string{family, family + "_total", family + "_bucket", family + "_sum", family + "_count"}
+}
+
+// inferType returns the type of a metric and what it was inferred from:
+// remote write metadata, then the name and the le and quantile labels, then
+// the behavior of the values of one series
+func (mu *metricUsage) inferType() (string, string) {
+	name := mu.info.MetricName
+	if mu.metadata != nil && mu.metadata.Type != "" {
+		return mu.metadata.Type, TypeSourceMetadata
+	}
+
+	switch {
+	case strings.HasSuffix(name, "_bucket") && mu.bucketLabel:
+		return MetricTypeHistogram, TypeSourceName
+	case mu.quantileLabel:
+		return MetricTypeSummary, TypeSourceName
+	case strings.HasSuffix(name, "_total"), strings.HasSuffix(name, "_count"), strings.HasSuffix(name, "_sum"):
+		return MetricTypeCounter, TypeSourceName
+	}
+
+	if mu.info.MinValue < 0 || mu.values.decreases > 1 {
+		return MetricTypeGauge, TypeSourceValues
+	}
+	if mu.values.increases+mu.values.decreases >= minValueChanges {
+		// A single decrease is taken as a counter reset
+		return MetricTypeCounter, TypeSourceValues
+	}
+	return MetricTypeUnknown, ""
+}
+
+// inferUnit returns the unit of a metric from its metadata or the unit
+// suffix of its name. The _count and _bucket series of histograms and
+// summaries count observations and have no unit.
+func (mu *metricUsage) inferUnit() string {
+	name := mu.info.MetricName
+	if strings.HasSuffix(name, "_count") || strings.HasSuffix(name, "_bucket") {
+		return ""
+	}
+	if mu.metadata != nil && mu.metadata.Unit != "" {
+		return mu.metadata.Unit
+	}
+
+	name = strings.TrimSuffix(name, "_total")
+	name = strings.TrimSuffix(name, "_sum")
+	for _, unit := range unitSuffixes {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	return ""
+}
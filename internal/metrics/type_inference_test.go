@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageTracker_TypeInference(t *testing.T) {
+	tracker := NewUsageTracker(time.Hour)
+
+	// Names and labels
+	tracker.TrackMetric("http_request_duration_seconds_bucket", map[string]string{"le": "0.5"}, 3)
+	tracker.TrackMetric("http_request_duration_seconds_sum", map[string]string{}, 1.5)
+	tracker.TrackMetric("rpc_latency_seconds", map[string]string{"quantile": "0.99"}, 0.2)
+	tracker.TrackMetric("process_cpu_seconds_total", map[string]string{}, 10)
+
+	// Values of one series
+	for i := 0; i < 6; i++ {
+		tracker.TrackMetric("jobs_processed", map[string]string{"queue": "a"}, float64(i*10))
+		tracker.TrackMetric("queue_depth", map[string]string{"queue": "a"}, float64(i%2))
+	}
+	tracker.TrackMetric("temperature_celsius", map[string]string{}, -4)
+	tracker.TrackMetric("build_info", map[string]string{}, 1)
+
+	// Metadata, sent before or after the series is seen
+	tracker.SetMetricMetadata("cache_size", MetricTypeGauge, "bytes")
+	tracker.TrackMetric("cache_size", map[string]string{}, 10)
+	tracker.TrackMetric("node_load1", map[string]string{}, 0.5)
+	tracker.SetMetricMetadata("node_load1", MetricTypeGauge, "")
+
+	for _, tt := range []struct {
+		name, wantType, wantSource, wantUnit string
+	}{
+		{"http_request_duration_seconds_bucket", MetricTypeHistogram, TypeSourceName, ""},
+		{"http_request_duration_seconds_sum", MetricTypeCounter, TypeSourceName, "seconds"},
+		{"rpc_latency_seconds", MetricTypeSummary, TypeSourceName, "seconds"},
+		{"process_cpu_seconds_total", MetricTypeCounter, TypeSourceName, "seconds"},
+		{"jobs_processed", MetricTypeCounter, TypeSourceValues, ""},
+		{"queue_depth", MetricTypeGauge, TypeSourceValues, ""},
+		{"temperature_celsius", MetricTypeGauge, TypeSourceValues, "celsius"},
+		{"build_info", MetricTypeUnknown, "", ""},
+		{"cache_size", MetricTypeGauge, TypeSourceMetadata, "bytes"},
+		{"node_load1", MetricTypeGauge, TypeSourceMetadata, ""},
+	} {
+		info := tracker.GetMetricInfo(tt.name)
+		if info.Type != tt.wantType || info.TypeSource != tt.wantSource || info.Unit != tt.wantUnit {
+			t.Errorf("%s: type %q from %q, unit %q; want %q from %q, unit %q", tt.name, info.Type, info.TypeSource, info.Unit, tt.wantType, tt.wantSource, tt.wantUnit)
+		}
+	}
+}
+
+func TestRecommendationEngine_DetermineAggregationType_InferredType(t *testing.T) {
+	engine := NewRecommendationEngine(NewUsageTracker(time.Hour), 1000, 100, 0.5)
+
+	for _, tt := range []struct {
+		info *MetricUsageInfo
+		want string
+	}{
+		// Negative values would otherwise suggest avg
+		{&MetricUsageInfo{MetricName: "balance_change_total", Type: MetricTypeCounter, MinValue: -1}, "sum"},
+		{&MetricUsageInfo{MetricName: "cpu_usage_ratio", Type: MetricTypeGauge, Unit: "ratio"}, "avg"},
+		{&MetricUsageInfo{MetricName: "rpc_latency_seconds", Type: MetricTypeSummary}, ""},
+		{&MetricUsageInfo{MetricName: "rpc_latency_seconds_count", Type: MetricTypeSummary}, "sum"},
+	} {
+		if got := engine.determineAggregationType(tt.info); got != tt.want {
+			t.Errorf("determineAggregationType(%s) = %q, want %q", tt.info.MetricName, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// Orderings supported by TopMetrics
+const (
+	TopByCardinality = "cardinality"
+	TopBySampleRate  = "sample_rate"
+	TopByGrowth      = "growth"
+)
+
+// TopMetric is a metric ranked by the cardinality explorer
+type TopMetric struct {
+	MetricName  string  `json:"metric_name"`
+	Cardinality int     `json:"cardinality"`
+	SampleRate  float64 `json:"sample_rate"` // Samples per second since the metric was first seen
+	Growth      int     `json:"growth"`      // Change in cardinality over the requested window
+}
+
+// LabelDistribution describes the values of one label of a metric
+type LabelDistribution struct {
+	Label       string       `json:"label"`
+	Cardinality int          `json:"cardinality"`
+	TopValues   []ValueCount `json:"top_values"`
+}
+
+// growth returns the change in cardinality since the start of the window.
+// Metrics first seen inside the window grow from zero.
+func growth(info *MetricUsageInfo, history []UsagePoint, now time.Time, window time.Duration) int {
+	start := now.Add(-window)
+	if !info.FirstSeen.Before(start) {
+		return info.Cardinality
+	}
+
+	baseline, ok := baselinePoint(history, start)
+	if !ok {
+		return 0
+	}
+
+	return info.Cardinality - baseline.Cardinality
+}
+
+// TopMetrics returns up to limit metrics ordered by the given criterion:
+// TopByCardinality, TopBySampleRate or TopByGrowth. Growth is measured over window.
+func (ut *UsageTracker) TopMetrics(by string, limit int, window time.Duration) []TopMetric {
+	ut.mu.RLock()
+	now := time.Now()
+	result := make([]TopMetric, 0, len(ut.metricsUsage))
+	for name, usage := range ut.metricsUsage {
+		info := usage.snapshot()
+
+		rate := float64(info.SampleCount)
+		if elapsed := info.LastSeen.Sub(info.FirstSeen).Seconds(); elapsed >= 1 {
+			rate = float64(info.SampleCount) / elapsed
+		}
+
+		result = append(result, TopMetric{
+			MetricName:  name,
+			Cardinality: info.Cardinality,
+			SampleRate:  rate,
+			Growth:      growth(info, ut.history[name], now, window),
+		})
+	}
+	ut.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		switch by {
+		case TopBySampleRate:
+			if a.SampleRate != b.SampleRate {
+				return a.SampleRate > b.SampleRate
+			}
+		case TopByGrowth:
+			if a.Growth != b.Growth {
+				return a.Growth > b.Growth
+			}
+		default:
+			if a.Cardinality != b.Cardinality {
+				return a.Cardinality > b.Cardinality
+			}
+		}
+		return a.MetricName < b.MetricName
+	})
+
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result
+}
+
+// GetLabelDistribution returns the cardinality and most frequent values of each
+// label of a metric, ordered by cardinality. It returns nil for unknown metrics.
+func (ut *UsageTracker) GetLabelDistribution(name string) []LabelDistribution {
+	ut.mu.RLock()
+	defer ut.mu.RUnlock()
+
+	usage, exists := ut.metricsUsage[name]
+	if !exists {
+		return nil
+	}
+
+	info := usage.snapshot()
+	result := make([]LabelDistribution, 0, len(info.LabelCardinality))
+	for label, cardinality := range info.LabelCardinality {
+		var current, previous *topValues
+		if sketch, exists := usage.current.labels[label]; exists {
+			current = sketch.top
+		}
+		if usage.previous != nil {
+			if sketch, exists := usage.previous.labels[label]; exists {
+				previous = sketch.top
+			}
+		}
+
+		topValues := mergeTopValues(current, previous)
+		if len(topValues) > ut.options.TopValuesPerLabel {
+			topValues = topValues[:ut.options.TopValuesPerLabel]
+		}
+
+		result = append(result, LabelDistribution{
+			Label:       label,
+			Cardinality: cardinality,
+			TopValues:   topValues,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Cardinality != result[j].Cardinality {
+			return result[i].Cardinality > result[j].Cardinality
+		}
+		return result[i].Label < result[j].Label
+	})
+
+	return result
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUsageTracker_TopMetrics(t *testing.T) {
+	tracker := NewUsageTracker(1 * time.Hour)
+
+	// high_card has 50 series, low_card has 2 series but many samples
+	for i := 0; i < 50; i++ {
+		tracker.TrackMetric("high_card", map[string]string{"id": fmt.Sprintf("%d", i)}, 1.0)
+	}
+	for i := 0; i < 100; i++ {
+		tracker.TrackMetric("low_card", map[string]string{"id": fmt.Sprintf("%d", i%2)}, 1.0)
+	}
+
+	top := tracker.TopMetrics(TopByCardinality, 1, 24*time.Hour)
+	if len(top) != 1 {
+		t.Fatalf("TopMetrics() returned %d metrics, want 1", len(top))
+	}
+	if top[0].MetricName != "high_card" || top[0].Cardinality != 50 {
+		t.Errorf("TopMetrics(cardinality)[0] = %+v, want high_card with cardinality 50", top[0])
+	}
+
+	top = tracker.TopMetrics(TopBySampleRate, 0, 24*time.Hour)
+	if len(top) != 2 || top[0].MetricName != "low_card" {
+		t.Errorf("TopMetrics(sample_rate) = %+v, want low_card first", top)
+	}
+
+	// Metrics first seen inside the window grow from zero
+	top = tracker.TopMetrics(TopByGrowth, 0, 24*time.Hour)
+	if top[0].MetricName != "high_card" || top[0].Growth != 50 {
+		t.Errorf("TopMetrics(growth)[0] = %+v, want high_card with growth 50", top[0])
+	}
+}
+
+func TestUsageTracker_GetLabelDistribution(t *testing.T) {
+	tracker := NewUsageTrackerWithOptions(1*time.Hour, UsageTrackerOptions{TopValuesPerLabel: 2})
+
+	for i := 0; i < 10; i++ {
+		tracker.TrackMetric("requests", map[string]string{"method": "GET", "path": fmt.Sprintf("/p%d", i)}, 1.0)
+	}
+	for i := 0; i < 3; i++ {
+		tracker.TrackMetric("requests", map[string]string{"method": "POST", "path": "/p0"}, 1.0)
+	}
+
+	labels := tracker.GetLabelDistribution("requests")
+	if len(labels) != 2 {
+		t.Fatalf("GetLabelDistribution() returned %d labels, want 2", len(labels))
+	}
+
+	// Ordered by cardinality: path (10 values) before method (2 values)
+	if labels[0].Label != "path" || labels[0].Cardinality != 10 {
+		t.Errorf("labels[0] = %+v, want path with cardinality 10", labels[0])
+	}
+	if len(labels[0].TopValues) != 2 {
+		t.Errorf("path has %d top values, want 2", len(labels[0].TopValues))
+	}
+
+	method := labels[1]
+	if method.Label != "method" || len(method.TopValues) != 2 {
+		t.Fatalf("labels[1] = %+v, want method with 2 top values", method)
+	}
+	if method.TopValues[0].Value != "GET" || method.TopValues[0].Count != 10 {
+		t.Errorf("method top value = %+v, want GET with count 10", method.TopValues[0])
+	}
+
+	if tracker.GetLabelDistribution("unknown") != nil {
+		t.Error("GetLabelDistribution() for unknown metric should return nil")
+	}
+}
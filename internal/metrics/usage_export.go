@@ -0,0 +1,291 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// UsageExportVersion is the version of the usage export format
+const UsageExportVersion = 1
+
+// SketchState is the serialized form of a cardinality sketch: the exact
+// hashes while it is sparse, the registers once it is dense
+type SketchState struct {
+	Precision uint8    `json:"precision"`
+	Hashes    []uint64 `json:"hashes,omitempty"`
+	Registers []byte   `json:"registers,omitempty"`
+}
+
+// LabelUsageState is the serialized usage of one label of a metric
+type LabelUsageState struct {
+	Values    SketchState  `json:"values"`
+	TopValues []ValueCount `json:"top_values,omitempty"`
+}
+
+// UsageRecord is the exported usage of one metric. It carries the sketches
+// of the metric, so cardinalities keep being estimated across an import, and
+// the estimates themselves for offline analysis.
+type UsageRecord struct {
+	MetricName            string                     `json:"metric_name"`
+	SampleCount           int64                      `json:"sample_count"`
+	FirstSeen             time.Time                  `json:"first_seen"`
+	LastSeen              time.Time                  `json:"last_seen"`
+	MinValue              float64                    `json:"min_value"`
+	MaxValue              float64                    `json:"max_value"`
+	SumValue              float64                    `json:"sum_value"`
+	ScrapeIntervalSeconds float64                    `json:"scrape_interval_seconds,omitempty"`
+	Cardinality           int                        `json:"cardinality"`
+	LabelCardinality      map[string]int             `json:"label_cardinality,omitempty"`
+	Series                SketchState                `json:"series"`
+	Labels                map[string]LabelUsageState `json:"labels,omitempty"`
+	History               []UsagePoint               `json:"history,omitempty"`
+}
+
+// UsageImportResult summarizes an import of usage records
+type UsageImportResult struct {
+	Imported int `json:"imported"`
+	// SketchesSkipped counts dense sketches whose precision differs from the
+	// configured one; they cannot be merged and the estimates they held are lost
+	SketchesSkipped int `json:"sketches_skipped"`
+}
+
+// state returns the serialized form of the sketch
+func (h *hyperLogLog) state() SketchState {
+	state := SketchState{Precision: h.precision}
+	if h.registers != nil {
+		state.Registers = append([]byte(nil), h.registers...)
+		return state
+	}
+	state.Hashes = make([]uint64, 0, len(h.sparse))
+	for hash := range h.sparse {
+		state.Hashes = append(state.Hashes, hash)
+	}
+	sort.Slice(state.Hashes, func(i, j int) bool { return state.Hashes[i] < state.Hashes[j] })
+	return state
+}
+
+// mergeState adds the items of a serialized sketch. Exact hashes can be added
+// to a sketch of any precision, registers only to one of the same precision.
+func (h *hyperLogLog) mergeState(state SketchState) bool {
+	if state.Registers == nil {
+		for _, hash := range state.Hashes {
+			h.Add(hash)
+		}
+		return true
+	}
+	if state.Precision != h.precision || len(state.Registers) != h.registerCount() {
+		return false
+	}
+	if h.registers == nil {
+		h.toDense()
+	}
+	for i, r := range state.Registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return true
+}
+
+// mergeSketches returns a sketch of the items of all sketches, such as the
+// two generations of a metric
+func mergeSketches(precision uint8, sketches ...*hyperLogLog) *hyperLogLog {
+	merged := newHyperLogLog(precision)
+	for _, sketch := range sketches {
+		if sketch != nil {
+			merged.mergeState(sketch.state())
+		}
+	}
+	return merged
+}
+
+// ExportUsage returns the usage of up to limit metrics whose names sort after
+// the given name, and whether more metrics follow. Resuming from the name of
+// the last returned metric walks all metrics, so an interrupted export can be
+// continued or retried page by page.
+func (ut *UsageTracker) ExportUsage(after string, limit int) ([]UsageRecord, bool) {
+	names := ut.MetricNames()
+	start := sort.SearchStrings(names, after)
+	if start < len(names) && names[start] == after {
+		start++
+	}
+	names = names[start:]
+	more := len(names) > limit
+	if more {
+		names = names[:limit]
+	}
+
+	ut.mu.RLock()
+	defer ut.mu.RUnlock()
+
+	records := make([]UsageRecord, 0, len(names))
+	for _, name := range names {
+		usage, exists := ut.metricsUsage[name]
+		if !exists {
+			continue
+		}
+		records = append(records, ut.exportMetric(usage))
+	}
+	return records, more
+}
+
+// exportMetric returns the record of a metric; the caller holds the read lock
+func (ut *UsageTracker) exportMetric(usage *metricUsage) UsageRecord {
+	info := usage.snapshot()
+	record := UsageRecord{
+		MetricName:            info.MetricName,
+		SampleCount:           info.SampleCount,
+		FirstSeen:             info.FirstSeen,
+		LastSeen:              info.LastSeen,
+		MinValue:              info.MinValue,
+		MaxValue:              info.MaxValue,
+		SumValue:              info.SumValue,
+		ScrapeIntervalSeconds: info.ScrapeInterval.Seconds(),
+		Cardinality:           info.Cardinality,
+		LabelCardinality:      info.LabelCardinality,
+		History:               append([]UsagePoint(nil), ut.history[info.MetricName]...),
+	}
+
+	var previousSeries *hyperLogLog
+	if usage.previous != nil {
+		previousSeries = usage.previous.series
+	}
+	record.Series = mergeSketches(ut.options.SeriesPrecision, usage.current.series, previousSeries).state()
+
+	record.Labels = make(map[string]LabelUsageState, len(info.LabelCardinality))
+	for key := range info.LabelCardinality {
+		var values []*hyperLogLog
+		var tops []*topValues
+		for _, generation := range []*sketchGeneration{usage.current, usage.previous} {
+			if generation == nil {
+				continue
+			}
+			if sketch, exists := generation.labels[key]; exists {
+				values = append(values, sketch.values)
+				tops = append(tops, sketch.top)
+			}
+		}
+		top := mergeTopValues(tops...)
+		if len(top) > ut.options.TopValuesPerLabel {
+			top = top[:ut.options.TopValuesPerLabel]
+		}
+		record.Labels[key] = LabelUsageState{
+			Values:    mergeSketches(ut.options.LabelPrecision, values...).state(),
+			TopValues: top,
+		}
+	}
+	return record
+}
+
+// ImportUsage merges exported usage records into the tracker. Sketches and
+// history are unioned with what is tracked; sample counts, values and top
+// label values are taken from whichever side has seen more samples. Importing
+// the same records twice therefore changes nothing, so a failed import can be
+// retried. Records are validated before any is applied.
+func (ut *UsageTracker) ImportUsage(records []UsageRecord) (UsageImportResult, error) {
+	var result UsageImportResult
+	for i, record := range records {
+		if record.MetricName == "" {
+			return result, fmt.Errorf("record %d has no metric name", i)
+		}
+		for _, state := range append([]SketchState{record.Series}, labelStates(record)...) {
+			if state.Registers != nil && (state.Precision < minSketchPrecision || state.Precision > maxSketchPrecision || len(state.Registers) != 1<<state.Precision) {
+				return result, fmt.Errorf("metric %s has an invalid sketch", record.MetricName)
+			}
+		}
+	}
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	for _, record := range records {
+		usage, exists := ut.metricsUsage[record.MetricName]
+		if !exists {
+			usage = &metricUsage{
+				info:      MetricUsageInfo{MetricName: record.MetricName},
+				current:   ut.newSketchGeneration(),
+				discovery: newDiscoverySample(ut.options.DiscoverySeriesPerMetric),
+			}
+			ut.metricsUsage[record.MetricName] = usage
+		}
+		replace := !exists || record.SampleCount > usage.info.SampleCount
+
+		info := &usage.info
+		if info.FirstSeen.IsZero() || (!record.FirstSeen.IsZero() && record.FirstSeen.Before(info.FirstSeen)) {
+			info.FirstSeen = record.FirstSeen
+		}
+		if record.LastSeen.After(info.LastSeen) {
+			info.LastSeen = record.LastSeen
+		}
+		if replace {
+			info.SampleCount = record.SampleCount
+			info.MinValue, info.MaxValue, info.SumValue = record.MinValue, record.MaxValue, record.SumValue
+		}
+		if usage.probe.interval() == 0 && record.ScrapeIntervalSeconds > 0 {
+			usage.probe.seed(time.Duration(record.ScrapeIntervalSeconds*float64(time.Second)), info.LastSeen)
+		}
+
+		if !usage.current.series.mergeState(record.Series) {
+			result.SketchesSkipped++
+		}
+		for key, state := range record.Labels {
+			sketch, exists := usage.current.labels[key]
+			if !exists {
+				if len(usage.current.labels) >= ut.options.MaxLabelsPerMetric {
+					continue
+				}
+				sketch = &labelSketch{
+					values: newHyperLogLog(ut.options.LabelPrecision),
+					top:    newTopValues(ut.options.TopValuesPerLabel),
+				}
+				usage.current.labels[key] = sketch
+			}
+			if !sketch.values.mergeState(state.Values) {
+				result.SketchesSkipped++
+			}
+			if replace || !exists {
+				sketch.top.counts = make(map[string]int64, ut.options.TopValuesPerLabel)
+				for i, value := range state.TopValues {
+					if i >= ut.options.TopValuesPerLabel {
+						break
+					}
+					sketch.top.counts[value.Value] = value.Count
+				}
+			}
+		}
+
+		ut.history[record.MetricName] = ut.mergeHistory(ut.history[record.MetricName], record.History)
+		result.Imported++
+	}
+	return result, nil
+}
+
+// labelStates returns the value sketches of the labels of a record
+func labelStates(record UsageRecord) []SketchState {
+	states := make([]SketchState, 0, len(record.Labels))
+	for _, label := range record.Labels {
+		states = append(states, label.Values)
+	}
+	return states
+}
+
+// mergeHistory returns the union of two roll-up histories, oldest first and
+// bounded to the configured number of points
+func (ut *UsageTracker) mergeHistory(tracked, imported []UsagePoint) []UsagePoint {
+	byTime := make(map[int64]UsagePoint, len(tracked)+len(imported))
+	for _, points := range [][]UsagePoint{imported, tracked} {
+		for _, point := range points {
+			byTime[point.Time.UnixNano()] = point
+		}
+	}
+	merged := make([]UsagePoint, 0, len(byTime))
+	for _, point := range byTime {
+		merged = append(merged, point)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+	if len(merged) > ut.options.HistoryPoints {
+		merged = merged[len(merged)-ut.options.HistoryPoints:]
+	}
+	return merged
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUsageTracker_ExportImport(t *testing.T) {
+	source := NewUsageTracker(time.Hour)
+	for i := 0; i < 2000; i++ {
+		source.TrackMetric("http_requests_total", map[string]string{"pod": fmt.Sprintf("pod-%d", i), "code": "200"}, 1)
+	}
+	for i := 0; i < 5; i++ {
+		source.TrackMetric(fmt.Sprintf("metric_%d", i), map[string]string{"job": "node"}, float64(i))
+	}
+
+	// Walk all metrics in pages of two
+	var records []UsageRecord
+	after := ""
+	for {
+		page, more := source.ExportUsage(after, 2)
+		records = append(records, page...)
+		if !more {
+			break
+		}
+		after = page[len(page)-1].MetricName
+	}
+	if len(records) != 6 {
+		t.Fatalf("exported %d metrics, want 6", len(records))
+	}
+
+	target := NewUsageTracker(time.Hour)
+	target.TrackMetric("http_requests_total", map[string]string{"pod": "pod-new", "code": "500"}, 1)
+	for i := 0; i < 2; i++ {
+		result, err := target.ImportUsage(records)
+		if err != nil {
+			t.Fatalf("ImportUsage() error = %v", err)
+		}
+		if result.Imported != 6 || result.SketchesSkipped != 0 {
+			t.Errorf("import result = %+v, want 6 imported", result)
+		}
+	}
+
+	// Sketches are unioned with the tracked series, counts are not doubled
+	want := source.GetMetricInfo("http_requests_total")
+	got := target.GetMetricInfo("http_requests_total")
+	if got.SampleCount != want.SampleCount {
+		t.Errorf("sample count = %d, want %d", got.SampleCount, want.SampleCount)
+	}
+	if got.Cardinality < want.Cardinality {
+		t.Errorf("cardinality = %d, want at least %d", got.Cardinality, want.Cardinality)
+	}
+	if got.LabelCardinality["code"] != 2 {
+		t.Errorf("code label cardinality = %d, want 2", got.LabelCardinality["code"])
+	}
+	if info := target.GetMetricInfo("metric_3"); info == nil || info.MaxValue != 3 {
+		t.Errorf("metric_3 = %+v, want imported with max 3", info)
+	}
+}
+
+func TestUsageTracker_ImportUsage_InvalidSketch(t *testing.T) {
+	tracker := NewUsageTracker(time.Hour)
+	_, err := tracker.ImportUsage([]UsageRecord{{
+		MetricName: "up",
+		Series:     SketchState{Precision: 14, Registers: make([]byte, 10)},
+	}})
+	if err == nil {
+		t.Error("ImportUsage() with a truncated sketch succeeded")
+	}
+	if tracker.GetMetricInfo("up") != nil {
+		t.Error("invalid import was applied")
+	}
+}
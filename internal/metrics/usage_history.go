@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// rollupInterval is how often per-metric usage roll-ups are recorded
+const rollupInterval = time.Hour
+
+// UsagePoint is a roll-up of a metric's usage at a point in time
+type UsagePoint struct {
+	Time        time.Time `json:"time"`
+	Cardinality int       `json:"cardinality"`
+	SampleCount int64     `json:"sample_count"`
+	SampleRate  float64   `json:"sample_rate"` // Samples per second since the previous roll-up
+}
+
+// historyFile is the on-disk format of persisted roll-ups
+type historyFile struct {
+	Version int                     `json:"version"`
+	Metrics map[string][]UsagePoint `json:"metrics"`
+}
+
+// rollup records a usage point for every tracked metric and persists the history
+// if a history file is configured. The caller must hold the write lock.
+func (ut *UsageTracker) rollup(now time.Time) {
+	ut.lastRollup = now
+
+	for name, usage := range ut.metricsUsage {
+		info := usage.snapshot()
+		point := UsagePoint{
+			Time:        now,
+			Cardinality: info.Cardinality,
+			SampleCount: info.SampleCount,
+		}
+
+		history := ut.history[name]
+		if n := len(history); n > 0 {
+			prev := history[n-1]
+			if elapsed := now.Sub(prev.Time).Seconds(); elapsed > 0 && point.SampleCount >= prev.SampleCount {
+				point.SampleRate = float64(point.SampleCount-prev.SampleCount) / elapsed
+			}
+		}
+
+		history = append(history, point)
+		if len(history) > ut.options.HistoryPoints {
+			history = history[len(history)-ut.options.HistoryPoints:]
+		}
+		ut.history[name] = history
+	}
+
+	if ut.options.HistoryFile == "" {
+		return
+	}
+
+	// Copy the history so it can be written without holding the lock
+	data := historyFile{Version: 1, Metrics: make(map[string][]UsagePoint, len(ut.history))}
+	for name, points := range ut.history {
+		data.Metrics[name] = append([]UsagePoint(nil), points...)
+	}
+	go func() {
+		if err := writeHistoryFile(ut.options.HistoryFile, &data); err != nil {
+			logger.LogErrorWithFields("Failed to persist usage history", logger.Fields{
+				"file":  ut.options.HistoryFile,
+				"error": err.Error(),
+			})
+		}
+	}()
+}
+
+// writeHistoryFile atomically writes roll-ups to disk
+func writeHistoryFile(path string, data *historyFile) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage history: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, encoded, 0644); err != nil {
+		return fmt.Errorf("failed to write usage history: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// LoadHistory restores persisted roll-ups from the configured history file.
+// A missing file is not an error.
+func (ut *UsageTracker) LoadHistory() error {
+	if ut.options.HistoryFile == "" {
+		return nil
+	}
+
+	encoded, err := os.ReadFile(ut.options.HistoryFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read usage history: %w", err)
+	}
+
+	var data historyFile
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		return fmt.Errorf("failed to parse usage history: %w", err)
+	}
+
+	cutoff := time.Now().Add(-time.Duration(ut.options.HistoryPoints) * rollupInterval)
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	for name, points := range data.Metrics {
+		kept := make([]UsagePoint, 0, len(points))
+		for _, point := range points {
+			if point.Time.After(cutoff) {
+				kept = append(kept, point)
+			}
+		}
+		if len(kept) > 0 {
+			ut.history[name] = kept
+		}
+	}
+
+	return nil
+}
+
+// GetHistory returns the roll-ups of a metric recorded within the window, oldest first
+func (ut *UsageTracker) GetHistory(name string, window time.Duration) []UsagePoint {
+	ut.mu.RLock()
+	defer ut.mu.RUnlock()
+
+	start := time.Now().Add(-window)
+	result := make([]UsagePoint, 0, len(ut.history[name]))
+	for _, point := range ut.history[name] {
+		if !point.Time.Before(start) {
+			result = append(result, point)
+		}
+	}
+
+	return result
+}
+
+// CardinalityTrend returns the relative change in cardinality of a metric over
+// the window (0.5 means it grew by 50%). The second return value is false when
+// there is not enough history to compute a trend.
+func (ut *UsageTracker) CardinalityTrend(name string, window time.Duration) (float64, bool) {
+	ut.mu.RLock()
+	defer ut.mu.RUnlock()
+
+	history := ut.history[name]
+	if len(history) < 2 {
+		return 0, false
+	}
+
+	latest := history[len(history)-1]
+	baseline, ok := baselinePoint(history, latest.Time.Add(-window))
+	if !ok || baseline.Time.Equal(latest.Time) || baseline.Cardinality == 0 {
+		return 0, false
+	}
+
+	return float64(latest.Cardinality-baseline.Cardinality) / float64(baseline.Cardinality), true
+}
+
+// baselinePoint returns the latest roll-up taken at or before start, falling
+// back to the oldest one available
+func baselinePoint(history []UsagePoint, start time.Time) (UsagePoint, bool) {
+	if len(history) == 0 {
+		return UsagePoint{}, false
+	}
+
+	baseline := history[0]
+	for _, point := range history {
+		if point.Time.After(start) {
+			break
+		}
+		baseline = point
+	}
+
+	return baseline, true
+}
+
+// WindowUsage is the usage of a metric over a window
+type WindowUsage struct {
+	// Cardinality is the average number of series over the roll-ups of the
+	// window and now
+	Cardinality float64
+	// Samples is the number of samples received during the window
+	Samples int64
+}
+
+// UsageOverWindow returns the usage of a metric over the window ending now,
+// from its roll-ups. Samples are counted from the last roll-up before the
+// window; when there is none, or the count restarted since, from when the
+// metric was first seen. It reports false for unknown metrics.
+func (ut *UsageTracker) UsageOverWindow(name string, window time.Duration) (WindowUsage, bool) {
+	ut.mu.RLock()
+	defer ut.mu.RUnlock()
+
+	usage, exists := ut.metricsUsage[name]
+	if !exists {
+		return WindowUsage{}, false
+	}
+	info := usage.snapshot()
+	start := time.Now().Add(-window)
+
+	cardinality, points := float64(info.Cardinality), 1
+	for _, point := range ut.history[name] {
+		if !point.Time.Before(start) {
+			cardinality += float64(point.Cardinality)
+			points++
+		}
+	}
+
+	result := WindowUsage{Cardinality: cardinality / float64(points), Samples: info.SampleCount}
+	if baseline, ok := baselinePoint(ut.history[name], start); ok && !baseline.Time.After(start) && baseline.SampleCount <= info.SampleCount {
+		result.Samples -= baseline.SampleCount
+	}
+	return result, true
+}
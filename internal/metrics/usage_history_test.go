@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageTracker_CardinalityTrend(t *testing.T) {
+	tracker := NewUsageTracker(24 * time.Hour)
+	start := time.Now()
+
+	// Cardinality doubles between the first and the last roll-up
+	for hour, series := range []int{10, 15, 20} {
+		for i := 0; i < series; i++ {
+			tracker.TrackMetric("requests", map[string]string{"id": fmt.Sprintf("%d", i)}, 1.0)
+		}
+		tracker.mu.Lock()
+		tracker.rollup(start.Add(time.Duration(hour) * time.Hour))
+		tracker.mu.Unlock()
+	}
+
+	history := tracker.GetHistory("requests", 24*time.Hour)
+	if len(history) != 3 {
+		t.Fatalf("GetHistory() returned %d points, want 3", len(history))
+	}
+	if history[2].SampleRate <= 0 {
+		t.Errorf("history[2].SampleRate = %v, want > 0", history[2].SampleRate)
+	}
+
+	trend, ok := tracker.CardinalityTrend("requests", 24*time.Hour)
+	if !ok || trend != 1.0 {
+		t.Errorf("CardinalityTrend() = %v, %v, want 1.0, true", trend, ok)
+	}
+
+	if _, ok := tracker.CardinalityTrend("unknown", 24*time.Hour); ok {
+		t.Error("CardinalityTrend() for unknown metric should not be ok")
+	}
+}
+
+func TestUsageTracker_LoadHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.json")
+	data := &historyFile{Version: 1, Metrics: map[string][]UsagePoint{
+		"requests": {
+			{Time: time.Now().Add(-30 * 24 * time.Hour), Cardinality: 5},
+			{Time: time.Now().Add(-2 * time.Hour), Cardinality: 10},
+			{Time: time.Now().Add(-1 * time.Hour), Cardinality: 20},
+		},
+	}}
+	if err := writeHistoryFile(file, data); err != nil {
+		t.Fatalf("writeHistoryFile() error = %v", err)
+	}
+
+	tracker := NewUsageTrackerWithOptions(24*time.Hour, UsageTrackerOptions{HistoryFile: file})
+	if err := tracker.LoadHistory(); err != nil {
+		t.Fatalf("LoadHistory() error = %v", err)
+	}
+
+	// Points older than the history length are dropped
+	history := tracker.GetHistory("requests", 365*24*time.Hour)
+	if len(history) != 2 {
+		t.Fatalf("GetHistory() returned %d points, want 2", len(history))
+	}
+	if history[1].Cardinality != 20 {
+		t.Errorf("history[1].Cardinality = %d, want 20", history[1].Cardinality)
+	}
+}
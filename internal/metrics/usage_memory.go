@@ -0,0 +1,71 @@
+package metrics
+
+import "unsafe"
+
+// UsageMemory is the estimated memory held by the usage tracker, in bytes
+type UsageMemory struct {
+	Metrics   int   `json:"metrics"`
+	Sketches  int64 `json:"sketches"`   // Cardinality sketches and series samples
+	TopValues int64 `json:"top_values"` // Most frequent values of each label
+	History   int64 `json:"history"`    // Hourly roll-ups
+	Total     int64 `json:"total"`
+}
+
+// Approximate sizes of map entries used by the estimate
+const (
+	hashEntryBytes   = 8 + 16      // uint64 key and bucket overhead
+	stringEntryBytes = 16 + 8 + 16 // String header, value and bucket overhead
+)
+
+// MemoryUsage estimates the memory held by the tracked metrics. Sketches in
+// dense mode use one byte per register; sparse ones grow with their items.
+func (ut *UsageTracker) MemoryUsage() UsageMemory {
+	ut.mu.RLock()
+	defer ut.mu.RUnlock()
+
+	usage := UsageMemory{Metrics: len(ut.metricsUsage)}
+	for name, metric := range ut.metricsUsage {
+		usage.Sketches += int64(unsafe.Sizeof(*metric)) + int64(len(name)) + stringEntryBytes
+		for _, generation := range []*sketchGeneration{metric.current, metric.previous} {
+			if generation == nil {
+				continue
+			}
+			usage.Sketches += generation.series.bytes() + generation.sample.bytes()
+			for label, sketch := range generation.labels {
+				usage.Sketches += int64(len(label)) + stringEntryBytes + sketch.values.bytes()
+				if sketch.top != nil {
+					for value := range sketch.top.counts {
+						usage.TopValues += int64(len(value)) + stringEntryBytes
+					}
+				}
+			}
+		}
+		usage.Sketches += metric.discovery.bytes()
+		for label, value := range metric.info.Labels {
+			usage.Sketches += int64(len(label)+len(value)) + stringEntryBytes
+		}
+	}
+	for name, points := range ut.history {
+		usage.History += int64(len(name)) + stringEntryBytes + int64(cap(points))*int64(unsafe.Sizeof(UsagePoint{}))
+	}
+
+	usage.Total = usage.Sketches + usage.TopValues + usage.History
+	return usage
+}
+
+// bytes estimates the memory of the sample
+func (s *seriesSample) bytes() int64 {
+	size := int64(unsafe.Sizeof(*s)) + int64(len(s.names))*16
+	for _, series := range s.series {
+		size += int64(unsafe.Sizeof(series)) + int64(len(series.values))*8
+	}
+	return size
+}
+
+// bytes estimates the memory of the sketch
+func (h *hyperLogLog) bytes() int64 {
+	if h.registers != nil {
+		return int64(len(h.registers))
+	}
+	return int64(len(h.sparse)) * hashEntryBytes
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	pkgmetrics "github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// samplingWindow is how often the adaptive sampling rate is recomputed
+const samplingWindow = time.Second
+
+// usageSampler decides which samples the usage tracker analyzes. It keeps
+// 1 in N samples at random, and raises N when more samples arrive than the
+// budget allows. Kept samples are weighted by N, so sample counts and sums
+// remain unbiased estimates of the true totals.
+type usageSampler struct {
+	minRate     uint32  // configured 1-in-N rate
+	budget      float64 // samples analyzed per second; 0 disables adaptive sampling
+	rate        atomic.Uint32
+	arrivals    atomic.Uint64
+	windowStart atomic.Int64 // Unix nanoseconds
+}
+
+// newUsageSampler creates a sampler keeping 1 in sampleRate samples, or more
+// rarely when the arrival rate exceeds maxPerSecond
+func newUsageSampler(sampleRate int, maxPerSecond float64) *usageSampler {
+	s := &usageSampler{minRate: 1, budget: maxPerSecond}
+	if sampleRate > 1 {
+		s.minRate = uint32(sampleRate)
+	}
+	s.rate.Store(s.minRate)
+	s.windowStart.Store(time.Now().UnixNano())
+	pkgmetrics.UpdateUsageSamplingRate(int(s.minRate))
+	return s
+}
+
+// sample reports whether a sample is analyzed, and how many samples it stands for
+func (s *usageSampler) sample() (int64, bool) {
+	if s.budget > 0 {
+		s.arrivals.Add(1)
+		s.adjust(time.Now().UnixNano())
+	}
+	// Samples are picked at random rather than every Nth, so that the order of
+	// series in remote write requests cannot bias which ones are analyzed
+	n := s.rate.Load()
+	if n > 1 && rand.Uint32N(n) != 0 {
+		return 0, false
+	}
+	return int64(n), true
+}
+
+// adjust recomputes the rate once per window from the arrival rate of the
+// previous window
+func (s *usageSampler) adjust(now int64) {
+	start := s.windowStart.Load()
+	elapsed := time.Duration(now - start)
+	if elapsed < samplingWindow || !s.windowStart.CompareAndSwap(start, now) {
+		return
+	}
+
+	perSecond := float64(s.arrivals.Swap(0)) / elapsed.Seconds()
+	rate := s.minRate
+	if needed := math.Ceil(perSecond / s.budget); needed > float64(rate) {
+		rate = uint32(min(needed, math.MaxUint32))
+	}
+	if s.rate.Swap(rate) != rate {
+		pkgmetrics.UpdateUsageSamplingRate(int(rate))
+	}
+}
+
+// current returns the current 1-in-N sampling rate
+func (s *usageSampler) current() int {
+	return int(s.rate.Load())
+}
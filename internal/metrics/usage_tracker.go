@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
@@ -12,106 +13,244 @@ type MetricUsageInfo struct {
 	SampleCount      int64
 	LastSeen         time.Time
 	FirstSeen        time.Time
-	Cardinality      int
-	LabelCardinality map[string]int // Maps label keys to their cardinality
+	Cardinality      int            // Approximate number of distinct series
+	LabelCardinality map[string]int // Maps label keys to their approximate cardinality
 	MinValue         float64
 	MaxValue         float64
 	SumValue         float64
+	// ScrapeInterval is inferred from the arrivals of one series; 0 until known
+	ScrapeInterval time.Duration
+	// Type is the metric type from remote write metadata, or inferred from
+	// the name and values; TypeSource tells which
+	Type       string
+	TypeSource string
+	// Unit is the unit from metadata or the name, such as seconds or bytes
+	Unit string
+
+	// seriesSamples are the series samples of the current and previous
+	// sketch generations, used by JointCardinality
+	seriesSamples [2]*seriesSample
+}
+
+// UsageTrackerOptions controls the memory/accuracy trade-off of a UsageTracker
+type UsageTrackerOptions struct {
+	// SeriesPrecision is the log2 register count of the per-metric series sketch (4-16)
+	SeriesPrecision uint8
+	// LabelPrecision is the log2 register count of each per-label value sketch (4-16)
+	LabelPrecision uint8
+	// MaxLabelsPerMetric bounds the number of label keys tracked for a single metric
+	MaxLabelsPerMetric int
+	// TopValuesPerLabel is the number of most frequent values kept for each label
+	TopValuesPerLabel int
+	// HistoryPoints is the number of hourly roll-ups kept per metric
+	HistoryPoints int
+	// HistoryFile is where roll-ups are persisted; empty keeps them in memory only
+	HistoryFile string
+	// SampleRate analyzes 1 in SampleRate samples; counts and sums are scaled
+	// back up, cardinality estimates are not. 0 or 1 analyzes every sample
+	SampleRate int
+	// MaxSamplesPerSecond raises the sample rate when more samples arrive per
+	// second than can be analyzed; 0 disables adaptive sampling
+	MaxSamplesPerSecond float64
+	// CooccurrenceSampleSize is the number of series sampled per metric to
+	// estimate which label values occur together; negative disables sampling
+	CooccurrenceSampleSize int
+	// DiscoverySeriesPerMetric is the number of series per metric whose
+	// labels are kept for the discovery endpoints; negative keeps none
+	DiscoverySeriesPerMetric int
+}
+
+// DefaultUsageTrackerOptions returns the default sketch configuration.
+// A dense series sketch uses 16KB and a dense label sketch 1KB; both stay
+// much smaller while the observed cardinality is low.
+func DefaultUsageTrackerOptions() UsageTrackerOptions {
+	return UsageTrackerOptions{
+		SeriesPrecision:    14,
+		LabelPrecision:     10,
+		MaxLabelsPerMetric: 64,
+		TopValuesPerLabel:  20,
+		HistoryPoints:      7 * 24, // One week
+
+		CooccurrenceSampleSize:   128,
+		DiscoverySeriesPerMetric: 20,
+	}
+}
+
+// sketchGeneration holds the cardinality sketches of one retention half-window
+type sketchGeneration struct {
+	series *hyperLogLog
+	labels map[string]*labelSketch
+	sample *seriesSample
+}
+
+// labelSketch holds the value cardinality and most frequent values of one label
+type labelSketch struct {
+	values *hyperLogLog
+	top    *topValues
 }
 
-// UsageTracker tracks usage information for metrics
+// metricUsage is the internal per-metric state of the tracker
+type metricUsage struct {
+	info     MetricUsageInfo
+	current  *sketchGeneration
+	previous *sketchGeneration
+	probe    scrapeProbe
+	values   valueProbe
+	metadata *metricMetadata
+	// discovery holds the labels of a sample of series
+	discovery *discoverySample
+
+	// Whether series were seen with the le label of histogram buckets or the
+	// quantile label of summaries
+	bucketLabel   bool
+	quantileLabel bool
+}
+
+// UsageTracker tracks usage information for metrics.
+// Series and label value cardinalities are estimated with HyperLogLog sketches,
+// so memory per metric is bounded regardless of the number of series. Sketches
+// cannot forget individual items, so they are kept in two generations that are
+// rotated every half retention period; estimates cover both generations.
 type UsageTracker struct {
 	mu              sync.RWMutex
-	metricsUsage    map[string]*MetricUsageInfo            // Tracks usage by metric name
-	detailedUsage   map[string]map[string]*MetricUsageInfo // Tracks usage by metric name + label hash
+	metricsUsage    map[string]*metricUsage    // Tracks usage by metric name
+	history         map[string][]UsagePoint    // Hourly roll-ups by metric name, oldest first
+	metadata        map[string]*metricMetadata // Remote write metadata by family name
+	options         UsageTrackerOptions
+	sampler         *usageSampler
 	retentionPeriod time.Duration
 	lastCleanup     time.Time
+	lastRotation    time.Time
+	lastRollup      time.Time
 }
 
-// NewUsageTracker creates a new usage tracker
+// NewUsageTracker creates a new usage tracker with the default sketch configuration
 func NewUsageTracker(retentionPeriod time.Duration) *UsageTracker {
+	return NewUsageTrackerWithOptions(retentionPeriod, DefaultUsageTrackerOptions())
+}
+
+// NewUsageTrackerWithOptions creates a new usage tracker with the given sketch configuration
+func NewUsageTrackerWithOptions(retentionPeriod time.Duration, options UsageTrackerOptions) *UsageTracker {
+	defaults := DefaultUsageTrackerOptions()
+	if options.SeriesPrecision == 0 {
+		options.SeriesPrecision = defaults.SeriesPrecision
+	}
+	if options.LabelPrecision == 0 {
+		options.LabelPrecision = defaults.LabelPrecision
+	}
+	if options.MaxLabelsPerMetric <= 0 {
+		options.MaxLabelsPerMetric = defaults.MaxLabelsPerMetric
+	}
+	if options.TopValuesPerLabel <= 0 {
+		options.TopValuesPerLabel = defaults.TopValuesPerLabel
+	}
+	if options.HistoryPoints <= 0 {
+		options.HistoryPoints = defaults.HistoryPoints
+	}
+	if options.CooccurrenceSampleSize == 0 {
+		options.CooccurrenceSampleSize = defaults.CooccurrenceSampleSize
+	}
+	if options.DiscoverySeriesPerMetric == 0 {
+		options.DiscoverySeriesPerMetric = defaults.DiscoverySeriesPerMetric
+	}
+	options.SeriesPrecision = clampPrecision(options.SeriesPrecision)
+	options.LabelPrecision = clampPrecision(options.LabelPrecision)
+
 	return &UsageTracker{
-		metricsUsage:    make(map[string]*MetricUsageInfo),
-		detailedUsage:   make(map[string]map[string]*MetricUsageInfo),
+		metricsUsage:    make(map[string]*metricUsage),
+		history:         make(map[string][]UsagePoint),
+		metadata:        make(map[string]*metricMetadata),
+		options:         options,
+		sampler:         newUsageSampler(options.SampleRate, options.MaxSamplesPerSecond),
 		retentionPeriod: retentionPeriod,
 		lastCleanup:     time.Now(),
+		lastRotation:    time.Now(),
+		lastRollup:      time.Now(),
+	}
+}
+
+// newSketchGeneration creates an empty set of sketches for a metric
+func (ut *UsageTracker) newSketchGeneration() *sketchGeneration {
+	return &sketchGeneration{
+		series: newHyperLogLog(ut.options.SeriesPrecision),
+		labels: make(map[string]*labelSketch),
+		sample: newSeriesSample(ut.options.CooccurrenceSampleSize),
 	}
 }
 
 // TrackMetric records usage information for a metric
 func (ut *UsageTracker) TrackMetric(name string, labels map[string]string, value float64) {
+	weight, sampled := ut.sampler.sample()
+	if !sampled {
+		return
+	}
+
 	ut.mu.Lock()
 	defer ut.mu.Unlock()
 
+	now := time.Now()
+
 	// Track summary usage by metric name
-	if _, exists := ut.metricsUsage[name]; !exists {
-		ut.metricsUsage[name] = &MetricUsageInfo{
-			MetricName:       name,
-			SampleCount:      0,
-			FirstSeen:        time.Now(),
-			LastSeen:         time.Now(),
-			Cardinality:      0,
-			LabelCardinality: make(map[string]int),
-			MinValue:         value,
-			MaxValue:         value,
-			SumValue:         0,
+	usage, exists := ut.metricsUsage[name]
+	if !exists {
+		usage = &metricUsage{
+			info: MetricUsageInfo{
+				MetricName: name,
+				FirstSeen:  now,
+				MinValue:   value,
+				MaxValue:   value,
+			},
+			current:   ut.newSketchGeneration(),
+			metadata:  ut.familyMetadata(name),
+			discovery: newDiscoverySample(ut.options.DiscoverySeriesPerMetric),
 		}
+		ut.metricsUsage[name] = usage
 	}
 
-	info := ut.metricsUsage[name]
-	info.SampleCount++
-	info.LastSeen = time.Now()
+	info := &usage.info
+	info.SampleCount += weight
+	info.LastSeen = now
 	info.MinValue = min(info.MinValue, value)
 	info.MaxValue = max(info.MaxValue, value)
-	info.SumValue += value
-
-	// Track detailed usage with label combinations
-	labelHash := hashLabels(labels)
-	if _, exists := ut.detailedUsage[name]; !exists {
-		ut.detailedUsage[name] = make(map[string]*MetricUsageInfo)
-	}
-
-	if _, exists := ut.detailedUsage[name][labelHash]; !exists {
-		info.Cardinality++
-		ut.detailedUsage[name][labelHash] = &MetricUsageInfo{
-			MetricName:  name,
-			Labels:      copyLabels(labels),
-			SampleCount: 0,
-			FirstSeen:   time.Now(),
-			LastSeen:    time.Now(),
-			MinValue:    value,
-			MaxValue:    value,
-			SumValue:    0,
-		}
+	info.SumValue += value * float64(weight)
 
-		// Update label cardinality only when we see a new unique combination
-		for k, v := range labels {
-			// Initialize tracking structures for this label if needed
-			if _, exists := info.LabelCardinality[k]; !exists {
-				info.LabelCardinality[k] = 0
-			}
-
-			// Check if this is a new value for this label
-			isNewValue := true
-			for existingHash, existingInfo := range ut.detailedUsage[name] {
-				if existingHash != labelHash && existingInfo.Labels[k] == v {
-					isNewValue = false
-					break
-				}
+	// Track series and label value cardinality
+	series := seriesHash(labels)
+	usage.current.series.Add(series)
+	if usage.current.sample.accepts(series) {
+		usage.current.sample = usage.current.sample.add(series, labels, ut.options.MaxLabelsPerMetric)
+	}
+	usage.discovery.observe(series, labels, now)
+	usage.probe.observe(series, now, weight)
+	if series == usage.probe.series {
+		usage.values.observe(series, value)
+	}
+	if _, exists := labels["le"]; exists {
+		usage.bucketLabel = true
+	}
+	if _, exists := labels["quantile"]; exists {
+		usage.quantileLabel = true
+	}
+	for k, v := range labels {
+		sketch, exists := usage.current.labels[k]
+		if !exists {
+			if len(usage.current.labels) >= ut.options.MaxLabelsPerMetric {
+				continue
 			}
-
-			if isNewValue {
-				info.LabelCardinality[k]++
+			sketch = &labelSketch{
+				values: newHyperLogLog(ut.options.LabelPrecision),
+				top:    newTopValues(ut.options.TopValuesPerLabel),
 			}
+			usage.current.labels[k] = sketch
 		}
+		sketch.values.Add(valueHash(v))
+		sketch.top.Add(v)
 	}
 
-	detailedInfo := ut.detailedUsage[name][labelHash]
-	detailedInfo.SampleCount++
-	detailedInfo.LastSeen = time.Now()
-	detailedInfo.MinValue = min(detailedInfo.MinValue, value)
-	detailedInfo.MaxValue = max(detailedInfo.MaxValue, value)
-	detailedInfo.SumValue += value
+	// Record hourly roll-ups used for growth and trends
+	if now.Sub(ut.lastRollup) >= rollupInterval {
+		ut.rollup(now)
+	}
 
 	// Periodically clean up old metrics
 	if time.Since(ut.lastCleanup) > ut.retentionPeriod/10 {
@@ -119,12 +258,22 @@ func (ut *UsageTracker) TrackMetric(name string, labels map[string]string, value
 	}
 }
 
+// SamplingRate returns the current 1-in-N rate at which samples are analyzed
+func (ut *UsageTracker) SamplingRate() int {
+	return ut.sampler.current()
+}
+
 // GetMetricInfo returns usage information for a metric
 func (ut *UsageTracker) GetMetricInfo(name string) *MetricUsageInfo {
 	ut.mu.RLock()
 	defer ut.mu.RUnlock()
 
-	return ut.metricsUsage[name]
+	usage, exists := ut.metricsUsage[name]
+	if !exists {
+		return nil
+	}
+
+	return usage.snapshot()
 }
 
 // GetAllMetricsInfo returns usage information for all metrics
@@ -134,33 +283,118 @@ func (ut *UsageTracker) GetAllMetricsInfo() map[string]*MetricUsageInfo {
 
 	result := make(map[string]*MetricUsageInfo, len(ut.metricsUsage))
 	for k, v := range ut.metricsUsage {
-		result[k] = v
+		result[k] = v.snapshot()
 	}
 
 	return result
 }
 
-// cleanup removes metrics that haven't been seen for the retention period
+// SeriesCardinality returns the estimated number of series of a metric, or 0
+// for unknown metrics, without the cost of a full GetMetricInfo
+func (ut *UsageTracker) SeriesCardinality(name string) int {
+	ut.mu.RLock()
+	defer ut.mu.RUnlock()
+
+	usage, exists := ut.metricsUsage[name]
+	if !exists {
+		return 0
+	}
+	if usage.previous == nil {
+		return usage.current.series.Estimate()
+	}
+	return usage.current.series.EstimateUnion(usage.previous.series)
+}
+
+// MetricNames returns the names of all tracked metrics in order, so callers
+// can walk large trackers one GetMetricInfo at a time instead of copying them
+// whole
+func (ut *UsageTracker) MetricNames() []string {
+	ut.mu.RLock()
+	names := make([]string, 0, len(ut.metricsUsage))
+	for name := range ut.metricsUsage {
+		names = append(names, name)
+	}
+	ut.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// snapshot returns a copy of the usage information with cardinalities estimated
+// from the sketches of both generations
+func (mu *metricUsage) snapshot() *MetricUsageInfo {
+	info := mu.info
+	info.ScrapeInterval = mu.probe.interval()
+	info.Type, info.TypeSource = mu.inferType()
+	info.Unit = mu.inferUnit()
+	info.seriesSamples[0] = mu.current.sample
+	if mu.previous != nil {
+		info.seriesSamples[1] = mu.previous.sample
+	}
+	info.LabelCardinality = make(map[string]int, len(mu.current.labels))
+
+	if mu.previous == nil {
+		info.Cardinality = mu.current.series.Estimate()
+		for k, sketch := range mu.current.labels {
+			info.LabelCardinality[k] = sketch.values.Estimate()
+		}
+		return &info
+	}
+
+	info.Cardinality = mu.current.series.EstimateUnion(mu.previous.series)
+	for k, sketch := range mu.current.labels {
+		if prev, exists := mu.previous.labels[k]; exists {
+			info.LabelCardinality[k] = sketch.values.EstimateUnion(prev.values)
+		} else {
+			info.LabelCardinality[k] = sketch.values.Estimate()
+		}
+	}
+	for k, sketch := range mu.previous.labels {
+		if _, exists := mu.current.labels[k]; !exists {
+			info.LabelCardinality[k] = sketch.values.Estimate()
+		}
+	}
+
+	return &info
+}
+
+// cleanup removes metrics that haven't been seen for the retention period and
+// rotates sketch generations so series that stopped reporting age out
 func (ut *UsageTracker) cleanup() {
-	cutoff := time.Now().Add(-ut.retentionPeriod)
-	ut.lastCleanup = time.Now()
+	now := time.Now()
+	cutoff := now.Add(-ut.retentionPeriod)
+	ut.lastCleanup = now
 
-	for metricName, metricInfo := range ut.metricsUsage {
-		if metricInfo.LastSeen.Before(cutoff) {
+	rotate := now.Sub(ut.lastRotation) > ut.retentionPeriod/2
+	if rotate {
+		ut.lastRotation = now
+	}
+
+	for metricName, usage := range ut.metricsUsage {
+		if usage.info.LastSeen.Before(cutoff) {
 			delete(ut.metricsUsage, metricName)
-			delete(ut.detailedUsage, metricName)
+			delete(ut.history, metricName)
 			continue
 		}
 
-		// Clean up individual label combinations
-		if details, exists := ut.detailedUsage[metricName]; exists {
-			for labelHash, detailInfo := range details {
-				if detailInfo.LastSeen.Before(cutoff) {
-					delete(details, labelHash)
-					metricInfo.Cardinality--
-				}
+		usage.discovery.expire(cutoff)
+		if rotate {
+			usage.previous = usage.current
+			usage.current = ut.newSketchGeneration()
+		}
+	}
+
+	for family := range ut.metadata {
+		tracked := false
+		for _, name := range familySeriesNames(family) {
+			if _, exists := ut.metricsUsage[name]; exists {
+				tracked = true
+				break
 			}
 		}
+		if !tracked {
+			delete(ut.metadata, family)
+		}
 	}
 }
 
@@ -178,20 +412,3 @@ func max(a, b float64) float64 {
 	}
 	return b
 }
-
-func hashLabels(labels map[string]string) string {
-	// Simple implementation - for production, use a more robust hashing mechanism
-	result := ""
-	for k, v := range labels {
-		result += k + ":" + v + ";"
-	}
-	return result
-}
-
-func copyLabels(labels map[string]string) map[string]string {
-	result := make(map[string]string, len(labels))
-	for k, v := range labels {
-		result[k] = v
-	}
-	return result
-}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"fmt"
 	"testing"
 	"time"
 )
@@ -223,4 +224,70 @@ func TestUsageTracker_EdgeCases(t *testing.T) {
 	if extremeInfo.MaxValue != 1000000.0 {
 		t.Errorf("MaxValue = %v, want %v", extremeInfo.MaxValue, 1000000.0)
 	}
-}
\ No newline at end of file
+}
+
+func TestUsageTracker_Sampling(t *testing.T) {
+	options := DefaultUsageTrackerOptions()
+	options.SampleRate = 4
+	tracker := NewUsageTrackerWithOptions(time.Hour, options)
+
+	const samples = 4000
+	for i := 0; i < samples; i++ {
+		tracker.TrackMetric("sampled_metric", map[string]string{"instance": "a"}, 2)
+	}
+
+	info := tracker.GetMetricInfo("sampled_metric")
+	if info == nil {
+		t.Fatal("Expected metric info but got nil")
+	}
+	if info.SampleCount%4 != 0 {
+		t.Errorf("SampleCount = %v, want a multiple of the sample rate", info.SampleCount)
+	}
+	// Kept samples are scaled back up, so the count stays close to the total
+	if info.SampleCount < samples*8/10 || info.SampleCount > samples*12/10 {
+		t.Errorf("SampleCount = %v, want about %v", info.SampleCount, samples)
+	}
+	if info.SumValue != float64(info.SampleCount)*2 {
+		t.Errorf("SumValue = %v, want %v", info.SumValue, float64(info.SampleCount)*2)
+	}
+}
+
+func TestUsageSampler_Adaptive(t *testing.T) {
+	sampler := newUsageSampler(2, 100)
+	start := sampler.windowStart.Load()
+
+	// 10000 samples per second against a budget of 100 keeps 1 in 100
+	sampler.arrivals.Store(10000)
+	sampler.adjust(start + int64(time.Second))
+	if rate := sampler.current(); rate != 100 {
+		t.Errorf("rate = %v, want %v", rate, 100)
+	}
+
+	// Below the budget the configured rate applies again
+	sampler.arrivals.Store(50)
+	sampler.adjust(start + int64(2*time.Second))
+	if rate := sampler.current(); rate != 2 {
+		t.Errorf("rate = %v, want %v", rate, 2)
+	}
+}
+
+func TestUsageTracker_MemoryUsage(t *testing.T) {
+	tracker := NewUsageTracker(time.Hour)
+	if usage := tracker.MemoryUsage(); usage.Metrics != 0 || usage.Total != 0 {
+		t.Errorf("MemoryUsage() of an empty tracker = %+v", usage)
+	}
+
+	tracker.TrackMetric("requests_total", map[string]string{"pod": "a"}, 1)
+	small := tracker.MemoryUsage()
+	for i := 0; i < 1000; i++ {
+		tracker.TrackMetric("requests_total", map[string]string{"pod": fmt.Sprintf("pod-%d", i)}, 1)
+	}
+	large := tracker.MemoryUsage()
+
+	if small.Metrics != 1 || small.Total <= 0 {
+		t.Errorf("MemoryUsage() = %+v, want one metric", small)
+	}
+	if large.Sketches <= small.Sketches || large.Total != large.Sketches+large.TopValues+large.History {
+		t.Errorf("MemoryUsage() = %+v after 1000 series, want more than %+v", large, small)
+	}
+}
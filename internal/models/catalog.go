@@ -0,0 +1,108 @@
+package models
+
+import (
+	"fmt"
+)
+
+// CatalogEntry is a ready-made rule of the built-in catalog for the metrics
+// of a common exporter. Its rule may reference variables like a template.
+type CatalogEntry struct {
+	ID          string `json:"id" yaml:"id"`
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+	// Exporter is the exporter whose metrics the rule aggregates, e.g. node_exporter
+	Exporter string `json:"exporter" yaml:"exporter"`
+	// Variables maps the variables of the rule to their default values
+	Variables map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
+	Rule      Rule              `json:"rule" yaml:"rule"`
+}
+
+// CatalogInstall customizes a catalog rule when it is installed
+type CatalogInstall struct {
+	// ID of the installed rule; generated when empty
+	ID string `json:"id,omitempty"`
+	// Values override the defaults of the variables of the entry
+	Values map[string]string `json:"values,omitempty"`
+	// Namespace restricts the rule to the series of a namespace and sets the
+	// namespace of the rule
+	Namespace string `json:"namespace,omitempty"`
+	// Labels are added to the label matchers of the rule
+	Labels map[string]string `json:"labels,omitempty"`
+	// AdditionalLabels are added to the output series of the rule
+	AdditionalLabels map[string]string `json:"additional_labels,omitempty"`
+	Owner            string            `json:"owner,omitempty"`
+	Team             string            `json:"team,omitempty"`
+}
+
+// Install returns the rule of the entry customized by install. namespaceLabel
+// is the series label holding the namespace, by default namespace.
+func (e *CatalogEntry) Install(install CatalogInstall, namespaceLabel string) (*Rule, error) {
+	if namespaceLabel == "" {
+		namespaceLabel = "namespace"
+	}
+	if install.ID != "" {
+		if err := ValidateID(install.ID); err != nil {
+			return nil, err
+		}
+	}
+	values := make(map[string]string, len(e.Variables))
+	for name, value := range e.Variables {
+		values[name] = value
+	}
+	for name, value := range install.Values {
+		if _, declared := e.Variables[name]; !declared {
+			return nil, fmt.Errorf("catalog rule %s has no variable %s", e.ID, name)
+		}
+		values[name] = value
+	}
+
+	variables := make([]string, 0, len(values))
+	for name := range values {
+		variables = append(variables, name)
+	}
+	// The output name follows the naming policy of the instance
+	base := e.Rule
+	base.Output.MetricName = CurrentOutputNamePolicy().Apply(base.Output.MetricName)
+	tmpl := RuleTemplate{ID: e.ID, Name: e.Name, Variables: variables, Rule: base}
+	rule, err := tmpl.Instantiate(values)
+	if err != nil {
+		return nil, err
+	}
+	// Catalog rules are not tied to a stored template
+	rule.TemplateID = ""
+	rule.ID = install.ID
+	rule.Source = CatalogSource(e.ID)
+
+	if install.Namespace != "" {
+		if rule.Matcher.Labels == nil {
+			rule.Matcher.Labels = make(map[string]string)
+		}
+		rule.Matcher.Labels[namespaceLabel] = install.Namespace
+		rule.Namespace = install.Namespace
+	}
+	for name, value := range install.Labels {
+		if rule.Matcher.Labels == nil {
+			rule.Matcher.Labels = make(map[string]string)
+		}
+		rule.Matcher.Labels[name] = value
+	}
+	for name, value := range install.AdditionalLabels {
+		if rule.Output.AdditionalLabels == nil {
+			rule.Output.AdditionalLabels = make(map[string]string)
+		}
+		rule.Output.AdditionalLabels[name] = value
+	}
+	if install.Owner != "" {
+		rule.Owner = install.Owner
+	}
+	if install.Team != "" {
+		rule.Team = install.Team
+	}
+
+	return rule, nil
+}
+
+// CatalogSource is the source recorded on rules installed from a catalog entry
+func CatalogSource(entryID string) string {
+	return "catalog:" + entryID
+}
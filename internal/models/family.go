@@ -0,0 +1,96 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FamilySource is a metric merged into the output family of a rule, such as
+// the legacy name of a metric being renamed. Its samples are aggregated with
+// the other metrics of the rule once their labels are mapped onto the labels
+// of the family.
+type FamilySource struct {
+	MetricName string `json:"metric_name" yaml:"metric_name"`
+	// RenameLabels maps label names of the source to the names the family uses
+	RenameLabels map[string]string `json:"rename_labels,omitempty" yaml:"rename_labels,omitempty"`
+	// Labels are stamped on every sample of the source, e.g. to tell the
+	// sources apart when grouping by them
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// Names returns the metric names and family sources a matcher selects
+func (m *MetricMatcher) Names() []string {
+	if len(m.Family) == 0 {
+		return m.MetricNames
+	}
+	names := make([]string, 0, len(m.MetricNames)+len(m.Family))
+	names = append(names, m.MetricNames...)
+	for _, source := range m.Family {
+		names = append(names, source.MetricName)
+	}
+	return names
+}
+
+// FamilySource returns the family source of a metric name, or nil if the
+// metric is not one
+func (m *MetricMatcher) FamilySource(name string) *FamilySource {
+	for i := range m.Family {
+		if m.Family[i].MetricName == name {
+			return &m.Family[i]
+		}
+	}
+	return nil
+}
+
+// ApplyFamily returns the sample with its labels mapped onto the family when
+// it belongs to a family source, and the sample itself otherwise
+func (m *MetricMatcher) ApplyFamily(sample *MetricSample) *MetricSample {
+	source := m.FamilySource(sample.Name)
+	if source == nil || (len(source.RenameLabels) == 0 && len(source.Labels) == 0) {
+		return sample
+	}
+
+	labels := make(map[string]string, len(sample.Labels)+len(source.Labels))
+	for name, value := range sample.Labels {
+		if renamed, exists := source.RenameLabels[name]; exists {
+			name = renamed
+		}
+		labels[name] = value
+	}
+	for name, value := range source.Labels {
+		labels[name] = value
+	}
+
+	mapped := *sample
+	mapped.Labels = labels
+	return &mapped
+}
+
+// validateFamily checks the family sources of a matcher
+func (m *MetricMatcher) validateFamily() error {
+	seen := make(map[string]bool, len(m.Family))
+	for _, source := range m.Family {
+		if source.MetricName == "" {
+			return fmt.Errorf("family source metric name is required")
+		}
+		if strings.Contains(source.MetricName, "*") {
+			return fmt.Errorf("family source %s must be an exact metric name", source.MetricName)
+		}
+		if seen[source.MetricName] {
+			return fmt.Errorf("duplicate family source %s", source.MetricName)
+		}
+		seen[source.MetricName] = true
+
+		for from, to := range source.RenameLabels {
+			if from == "" || to == "" || from == "__name__" || to == "__name__" {
+				return fmt.Errorf("invalid label rename %q to %q for family source %s", from, to, source.MetricName)
+			}
+		}
+		for name := range source.Labels {
+			if name == "" || name == "__name__" {
+				return fmt.Errorf("invalid stamped label %q for family source %s", name, source.MetricName)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// RuleGroup is a named set of rules that share an evaluation priority.
+// Rules reference their group by name through Rule.Group.
+type RuleGroup struct {
+	Name        string    `json:"name" yaml:"name"`
+	Description string    `json:"description" yaml:"description"`
+	CreatedAt   time.Time `json:"created_at" yaml:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" yaml:"updated_at"`
+
+	// Priority orders groups relative to each other; higher values are evaluated first
+	Priority int `json:"priority" yaml:"priority"`
+}
+
+// Validate checks if the group configuration is valid
+func (g *RuleGroup) Validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("rule group name is required")
+	}
+	// The name is the group's file name and path segment in the API
+	if err := ValidateID(g.Name); err != nil {
+		return fmt.Errorf("invalid rule group name: %w", err)
+	}
+
+	return nil
+}
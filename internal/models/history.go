@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Actions recorded in rule history
+const (
+	RevisionCreated  = "created"
+	RevisionUpdated  = "updated"
+	RevisionDeleted  = "deleted"
+	RevisionRestored = "restored"
+)
+
+// SystemAuthor is the author of changes made by the service itself, such as
+// disabling expired rules
+const SystemAuthor = "system"
+
+// RuleRevision is one persisted version of a rule. A deletion keeps the last
+// body of the rule so it can be restored.
+type RuleRevision struct {
+	Revision  int       `json:"revision" yaml:"revision"`
+	Action    string    `json:"action" yaml:"action"`
+	Author    string    `json:"author,omitempty" yaml:"author,omitempty"`
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+	Rule      Rule      `json:"rule" yaml:"rule"`
+}
+
+// RuleChange is a field of a rule that differs between two revisions. Path
+// is the JSON path of the field; a nil value means the field is not set.
+type RuleChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// DiffRules returns the fields that differ between two versions of a rule,
+// sorted by path. A nil rule has no fields, so diffing against nil lists
+// every field that is set.
+func DiffRules(before, after *Rule) []RuleChange {
+	oldFields, newFields := flattenRule(before), flattenRule(after)
+
+	changes := []RuleChange{}
+	for path, oldValue := range oldFields {
+		newValue, exists := newFields[path]
+		if !exists {
+			changes = append(changes, RuleChange{Path: path, Old: oldValue})
+			continue
+		}
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, RuleChange{Path: path, Old: oldValue, New: newValue})
+		}
+	}
+	for path, newValue := range newFields {
+		if _, exists := oldFields[path]; !exists {
+			changes = append(changes, RuleChange{Path: path, New: newValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return changes
+}
+
+// flattenRule maps the JSON paths of the set fields of a rule to their
+// values. Lists are compared as a whole.
+func flattenRule(rule *Rule) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if rule == nil {
+		return fields
+	}
+
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return fields
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fields
+	}
+	flattenInto(fields, "", decoded)
+
+	return fields
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, value map[string]interface{}) {
+	for key, field := range value {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		switch v := field.(type) {
+		case nil:
+		case map[string]interface{}:
+			flattenInto(fields, path, v)
+		default:
+			fields[path] = v
+		}
+	}
+}
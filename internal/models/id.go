@@ -0,0 +1,51 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Schemes of generated rule and template IDs
+const (
+	// IDSchemeRandom IDs are 16 random hex characters
+	IDSchemeRandom = "random"
+	// IDSchemeUUIDv7 IDs are UUIDv7, which sort by creation time
+	IDSchemeUUIDv7 = "uuidv7"
+)
+
+// maxIDLength bounds the length of rule and template IDs, which name files
+const maxIDLength = 200
+
+// NewID returns a new ID of the given scheme, or of the random scheme when it
+// is empty, after prefix
+func NewID(scheme, prefix string) string {
+	if scheme == IDSchemeUUIDv7 {
+		id, err := uuid.NewV7()
+		if err != nil {
+			panic(fmt.Sprintf("failed to generate UUIDv7: %v", err))
+		}
+		return prefix + id.String()
+	}
+
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(fmt.Sprintf("failed to generate random ID: %v", err))
+	}
+	return prefix + hex.EncodeToString(bytes)
+}
+
+// ValidateID checks that an ID can name a file and a path segment of the API.
+// IDs of every scheme pass, as do IDs chosen by users within those limits.
+func ValidateID(id string) error {
+	if id == "" || id == "." || id == ".." || len(id) > maxIDLength {
+		return fmt.Errorf("invalid ID %q", id)
+	}
+	if strings.ContainsAny(id, `/\?#%`) || strings.IndexFunc(id, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+		return fmt.Errorf("invalid ID %q: must not contain path separators, ?, #, %% or control characters", id)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewID(t *testing.T) {
+	if id := NewID(IDSchemeRandom, ""); !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(id) {
+		t.Errorf("random ID = %s, want 16 hex characters", id)
+	}
+
+	uuidv7 := regexp.MustCompile(`^rule_[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	previous := ""
+	for i := 0; i < 100; i++ {
+		id := NewID(IDSchemeUUIDv7, "rule_")
+		if !uuidv7.MatchString(id) {
+			t.Fatalf("UUIDv7 ID = %s, want a prefixed UUIDv7", id)
+		}
+		// Later IDs sort after earlier ones
+		if id <= previous {
+			t.Fatalf("ID %s does not sort after %s", id, previous)
+		}
+		previous = id
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	valid := []string{
+		"3f9a1c2b7d4e5f60",
+		"rule_01920f4e-8c6a-7b3d-9e2f-4a5b6c7d8e9f",
+		"autogen-3f9a1c2b7d4e5f60",
+		"http requests (team a)",
+	}
+	for _, id := range valid {
+		if err := ValidateID(id); err != nil {
+			t.Errorf("ValidateID(%q) error = %v", id, err)
+		}
+	}
+
+	invalid := []string{"", ".", "..", "../rules", `a\b`, "a?b", "a#b", "a%2Fb", "a\nb", strings.Repeat("a", 201)}
+	for _, id := range invalid {
+		if err := ValidateID(id); err == nil {
+			t.Errorf("ValidateID(%q) succeeded", id)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+// Actions taken on an ingested series
+const (
+	// IngestActionProcess tracks and aggregates the series as usual
+	IngestActionProcess = "process"
+	// IngestActionDrop discards the series
+	IngestActionDrop = "drop"
+	// IngestActionPass accepts the series untouched, without tracking or aggregating it
+	IngestActionPass = "pass"
+)
+
+// IngestFilters select series that are handled before rule matching and usage
+// tracking, so that series nobody aggregates, such as go_gc_*, cost no memory
+type IngestFilters struct {
+	// Series selectors whose samples are discarded, e.g. go_gc_* or {job="canary"}
+	Drop []string `json:"drop" yaml:"drop"`
+
+	// Series selectors whose samples are accepted but neither tracked nor
+	// aggregated. Drop selectors take precedence.
+	Pass []string `json:"pass" yaml:"pass"`
+}
+
+// Validate checks that all selectors can be parsed
+func (f *IngestFilters) Validate() error {
+	for _, selectors := range [][]string{f.Drop, f.Pass} {
+		for _, selector := range selectors {
+			if _, err := ParseSelector(selector); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package models
+
+import "fmt"
+
+// Label transform actions, applied to label values before aggregation
+const (
+	LabelTransformHash     = "hash"     // Replaces values with a hex digest
+	LabelTransformTruncate = "truncate" // Keeps the first length characters of values
+)
+
+// Hash algorithms of label transforms
+const (
+	HashSHA256 = "sha256" // HMAC-SHA256 when a salt is set
+	HashXXHash = "xxhash" // Faster, not suitable when values can be guessed
+)
+
+// DefaultHashLength is the number of hex characters kept of a hashed value
+const DefaultHashLength = 16
+
+// LabelTransform hashes or truncates the values of labels holding personal
+// data, such as user IDs or emails, so they still correlate series without
+// being stored in metrics
+type LabelTransform struct {
+	Labels []string `json:"labels" yaml:"labels"`
+	// Action is hash (default) or truncate
+	Action string `json:"action,omitempty" yaml:"action,omitempty"`
+	// Algorithm of hash transforms: sha256 (default) or xxhash
+	Algorithm string `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`
+	// Salt is mixed into hashes so values cannot be recovered by hashing guesses
+	Salt string `json:"salt,omitempty" yaml:"salt,omitempty"`
+	// Length is the number of characters kept: hex digits of hashes (default
+	// 16) or the prefix of truncated values
+	Length int `json:"length,omitempty" yaml:"length,omitempty"`
+}
+
+// ActionOrDefault returns the transform action, defaulting to hash
+func (t *LabelTransform) ActionOrDefault() string {
+	if t.Action == "" {
+		return LabelTransformHash
+	}
+	return t.Action
+}
+
+// Validate checks that the transform can be applied
+func (t *LabelTransform) Validate() error {
+	if len(t.Labels) == 0 {
+		return fmt.Errorf("label transform requires at least one label")
+	}
+	for _, label := range t.Labels {
+		if label == MetricNameLabel {
+			return fmt.Errorf("label transform cannot change the metric name")
+		}
+	}
+	if t.Length < 0 {
+		return fmt.Errorf("label transform length must not be negative")
+	}
+
+	switch action := t.ActionOrDefault(); action {
+	case LabelTransformHash:
+		switch t.Algorithm {
+		case "", HashSHA256, HashXXHash:
+		default:
+			return fmt.Errorf("invalid label transform algorithm: %s", t.Algorithm)
+		}
+	case LabelTransformTruncate:
+		if t.Length == 0 {
+			return fmt.Errorf("label transform action %s requires a length", action)
+		}
+	default:
+		return fmt.Errorf("invalid label transform action: %s", action)
+	}
+	return nil
+}
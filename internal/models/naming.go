@@ -0,0 +1,79 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// OutputNamePolicy is the prefix and suffix every output metric name must have
+type OutputNamePolicy struct {
+	Prefix string `json:"prefix"`
+	Suffix string `json:"suffix"`
+}
+
+// outputNamePolicy is the policy enforced by Rule.Validate
+var outputNamePolicy atomic.Pointer[OutputNamePolicy]
+
+// SetOutputNamePolicy sets the policy enforced on the output metric names of rules
+func SetOutputNamePolicy(policy OutputNamePolicy) {
+	outputNamePolicy.Store(&policy)
+}
+
+// CurrentOutputNamePolicy returns the policy enforced on output metric names
+func CurrentOutputNamePolicy() OutputNamePolicy {
+	if policy := outputNamePolicy.Load(); policy != nil {
+		return *policy
+	}
+	return OutputNamePolicy{}
+}
+
+// Check returns an error if an output metric name lacks the prefix or suffix
+func (p OutputNamePolicy) Check(name string) error {
+	if !strings.HasPrefix(name, p.Prefix) {
+		return fmt.Errorf("output metric name %s must start with %q", name, p.Prefix)
+	}
+	if !strings.HasSuffix(name, p.Suffix) {
+		return fmt.Errorf("output metric name %s must end with %q", name, p.Suffix)
+	}
+	return nil
+}
+
+// Apply adds the prefix and suffix a name lacks
+func (p OutputNamePolicy) Apply(name string) string {
+	if !strings.HasPrefix(name, p.Prefix) {
+		name = p.Prefix + name
+	}
+	if !strings.HasSuffix(name, p.Suffix) {
+		name += p.Suffix
+	}
+	return name
+}
+
+// OutputLabelNames returns the sorted label names of the aggregated series of
+// a rule, apart from external labels. It reports false when they cannot be
+// known without evaluating the rule: PromQL aggregations and output
+// relabeling may set any labels.
+func (r *Rule) OutputLabelNames() ([]string, bool) {
+	if r.Aggregation.Type == AggregationPromQL || len(r.Output.Relabeling) > 0 {
+		return nil, false
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, label := range r.GroupingLabels() {
+		if !seen[label] {
+			seen[label] = true
+			names = append(names, label)
+		}
+	}
+	for label := range r.Output.AdditionalLabels {
+		if !seen[label] {
+			seen[label] = true
+			names = append(names, label)
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOutputNamePolicy(t *testing.T) {
+	defer SetOutputNamePolicy(OutputNamePolicy{})
+	SetOutputNamePolicy(OutputNamePolicy{Prefix: "payments:", Suffix: ":aggregated"})
+
+	rule := &Rule{
+		Name:        "requests",
+		Matcher:     MetricMatcher{MetricNames: []string{"http_requests_total"}},
+		Aggregation: AggregationConfig{Type: "sum", IntervalSeconds: 60},
+		Output:      OutputConfig{MetricName: "http_requests"},
+	}
+	if err := rule.Validate(); err == nil {
+		t.Error("Validate() of a name without prefix and suffix should fail")
+	}
+
+	rule.Output.MetricName = CurrentOutputNamePolicy().Apply(rule.Output.MetricName)
+	if rule.Output.MetricName != "payments:http_requests:aggregated" {
+		t.Errorf("Apply() = %s, want payments:http_requests:aggregated", rule.Output.MetricName)
+	}
+	if err := rule.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if name := CurrentOutputNamePolicy().Apply(rule.Output.MetricName); name != rule.Output.MetricName {
+		t.Errorf("Apply() of a compliant name = %s, want it unchanged", name)
+	}
+}
+
+func TestRule_OutputLabelNames(t *testing.T) {
+	rule := &Rule{
+		Aggregation: AggregationConfig{Type: "sum", Segmentation: []string{"service", "route"}},
+		Output:      OutputConfig{AdditionalLabels: map[string]string{"aggregated_by": "adaptive_metrics", "service": "x"}},
+	}
+	labels, known := rule.OutputLabelNames()
+	if want := []string{"aggregated_by", "route", "service"}; !known || !reflect.DeepEqual(labels, want) {
+		t.Errorf("OutputLabelNames() = %v, %v, want %v, true", labels, known, want)
+	}
+
+	rule.Output.Relabeling = []RelabelConfig{{Action: RelabelLabelDrop, Regex: "route"}}
+	if _, known := rule.OutputLabelNames(); known {
+		t.Error("OutputLabelNames() of a relabeled rule should be unknown")
+	}
+}
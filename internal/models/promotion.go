@@ -0,0 +1,123 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PromotionMapping rewrites the environment-specific parts of rules when they
+// are promoted between environments, for example from staging to prod.
+// Values without a mapping are kept, or with Strict rejected.
+type PromotionMapping struct {
+	// Namespaces maps namespaces of the source to those of the destination.
+	// They are rewritten in the namespace of rules and Kubernetes outputs, and
+	// in label matchers and output labels of the namespace label.
+	Namespaces map[string]string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	// Labels maps label names to a mapping of their values, for example
+	// cluster: {staging-eu: prod-eu}. They are rewritten in exact label
+	// matchers and output labels.
+	Labels map[string]map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Tenants maps remote write tenants
+	Tenants map[string]string `json:"tenants,omitempty" yaml:"tenants,omitempty"`
+	// Endpoints maps remote write endpoints
+	Endpoints map[string]string `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+	// Strict rejects rules with a namespace, tenant, endpoint or value of a
+	// mapped label that has no mapping
+	Strict bool `json:"strict,omitempty" yaml:"strict,omitempty"`
+}
+
+// PromotionChange is a value rewritten by a promotion
+type PromotionChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// LoadPromotionMapping reads a promotion mapping file. Unknown fields are
+// rejected so a misspelled section does not silently map nothing.
+func LoadPromotionMapping(path string) (*PromotionMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping PromotionMapping
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&mapping); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid promotion mapping %s: %w", path, err)
+	}
+	return &mapping, nil
+}
+
+// Promote returns a copy of a rule rewritten for the destination environment,
+// with the values it changed. namespaceLabel is the series label holding the
+// namespace.
+func (m *PromotionMapping) Promote(rule *Rule, namespaceLabel string) (*Rule, []PromotionChange, error) {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to copy rule: %w", err)
+	}
+	var promoted Rule
+	if err := json.Unmarshal(data, &promoted); err != nil {
+		return nil, nil, fmt.Errorf("failed to copy rule: %w", err)
+	}
+
+	var changes []PromotionChange
+	var errs []error
+	rewrite := func(field string, mapping map[string]string, value *string) {
+		if *value == "" || mapping == nil {
+			return
+		}
+		to, ok := mapping[*value]
+		if !ok {
+			if m.Strict {
+				errs = append(errs, fmt.Errorf("%s %q has no mapping", field, *value))
+			}
+			return
+		}
+		if to != *value {
+			changes = append(changes, PromotionChange{Field: field, From: *value, To: to})
+			*value = to
+		}
+	}
+	rewriteLabels := func(field string, labels map[string]string) {
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			mapping := m.Labels[name]
+			if name == namespaceLabel && m.Namespaces != nil {
+				mapping = m.Namespaces
+			}
+			value := labels[name]
+			rewrite(field+"."+name, mapping, &value)
+			labels[name] = value
+		}
+	}
+
+	rewrite("namespace", m.Namespaces, &promoted.Namespace)
+	rewriteLabels("matcher.labels", promoted.Matcher.Labels)
+	rewriteLabels("output.additional_labels", promoted.Output.AdditionalLabels)
+	if promoted.OutputKubernetes != nil {
+		rewrite("output_kubernetes.namespace", m.Namespaces, &promoted.OutputKubernetes.Namespace)
+	}
+	if target := promoted.Output.RemoteWriteTarget; target != nil {
+		rewrite("output.remote_write_target.tenant", m.Tenants, &target.Tenant)
+		rewrite("output.remote_write_target.endpoint", m.Endpoints, &target.Endpoint)
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return &promoted, changes, nil
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPromotionMapping_Promote(t *testing.T) {
+	mapping := &PromotionMapping{
+		Namespaces: map[string]string{"payments-staging": "payments"},
+		Labels:     map[string]map[string]string{"cluster": {"staging-eu": "prod-eu"}},
+		Tenants:    map[string]string{"staging": "prod"},
+	}
+	rule := &Rule{
+		ID:        "payments-requests",
+		Namespace: "payments-staging",
+		Matcher: MetricMatcher{
+			MetricNames: []string{"http_requests_total"},
+			Labels:      map[string]string{"namespace": "payments-staging", "cluster": "staging-eu", "job": "api"},
+		},
+		Output: OutputConfig{
+			MetricName:        "http_requests:sum",
+			AdditionalLabels:  map[string]string{"cluster": "staging-eu"},
+			RemoteWriteTarget: &RemoteWriteTarget{Tenant: "staging", Endpoint: "mimir"},
+		},
+	}
+
+	promoted, changes, err := mapping.Promote(rule, "namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promoted.Namespace != "payments" || promoted.Matcher.Labels["namespace"] != "payments" || promoted.Matcher.Labels["cluster"] != "prod-eu" {
+		t.Errorf("promoted = %+v", promoted)
+	}
+	if promoted.Matcher.Labels["job"] != "api" || promoted.Output.AdditionalLabels["cluster"] != "prod-eu" {
+		t.Errorf("labels = %v, additional labels = %v", promoted.Matcher.Labels, promoted.Output.AdditionalLabels)
+	}
+	if target := promoted.Output.RemoteWriteTarget; target.Tenant != "prod" || target.Endpoint != "mimir" {
+		t.Errorf("remote write target = %+v", target)
+	}
+	if len(changes) != 5 {
+		t.Errorf("changes = %+v, want 5", changes)
+	}
+	// The original rule is not modified
+	if rule.Namespace != "payments-staging" || rule.Matcher.Labels["cluster"] != "staging-eu" || rule.Output.RemoteWriteTarget.Tenant != "staging" {
+		t.Errorf("original rule modified: %+v", rule)
+	}
+
+	mapping.Strict = true
+	if _, _, err := mapping.Promote(rule, "namespace"); err != nil {
+		t.Errorf("strict promotion of mapped values: %v", err)
+	}
+	rule.Namespace = "search-staging"
+	if _, _, err := mapping.Promote(rule, "namespace"); err == nil {
+		t.Error("expected error for unmapped namespace in strict mode")
+	}
+}
+
+func TestLoadPromotionMapping(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prod.yaml")
+
+	if err := os.WriteFile(path, []byte("namespaces:\n  a: b\nstrict: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := LoadPromotionMapping(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Namespaces["a"] != "b" || !mapping.Strict {
+		t.Errorf("mapping = %+v", mapping)
+	}
+
+	if err := os.WriteFile(path, []byte("namespace:\n  a: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPromotionMapping(path); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProtectionList lists metrics, labels and series that must never be
+// aggregated or dropped, such as series backing SLOs
+type ProtectionList struct {
+	// Metric names that no rule may match; "*" wildcards are allowed
+	Metrics []string `json:"metrics" yaml:"metrics"`
+
+	// Label names that every rule must keep on its aggregated series
+	Labels []string `json:"labels" yaml:"labels"`
+
+	// Series selectors no rule may match, e.g. http_requests_total{slo="checkout"}.
+	// Only equality label matchers are supported.
+	Selectors []string `json:"selectors" yaml:"selectors"`
+}
+
+// Validate checks that all selectors can be parsed
+func (p *ProtectionList) Validate() error {
+	for _, name := range p.Metrics {
+		if name == "" {
+			return fmt.Errorf("protected metric name must not be empty")
+		}
+	}
+	for _, label := range p.Labels {
+		if label == "" {
+			return fmt.Errorf("protected label name must not be empty")
+		}
+	}
+	for _, selector := range p.Selectors {
+		if _, err := ParseSelector(selector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseSelector parses a series selector such as name{label="value"} into a
+// matcher. The metric name may contain "*" wildcards and may be omitted, in
+// which case the selector matches every metric.
+func ParseSelector(selector string) (MetricMatcher, error) {
+	matcher := MetricMatcher{Labels: make(map[string]string)}
+
+	s := strings.TrimSpace(selector)
+	name := s
+	body := ""
+	if open := strings.Index(s, "{"); open >= 0 {
+		if !strings.HasSuffix(s, "}") {
+			return matcher, fmt.Errorf("invalid selector %q: missing closing brace", selector)
+		}
+		name = strings.TrimSpace(s[:open])
+		body = s[open+1 : len(s)-1]
+	}
+
+	if name == "" {
+		name = "*"
+	}
+	matcher.MetricNames = []string{name}
+
+	for body = strings.TrimSpace(body); body != ""; {
+		eq := strings.Index(body, "=")
+		if eq <= 0 {
+			return matcher, fmt.Errorf("invalid selector %q: expected label=\"value\"", selector)
+		}
+		label := strings.TrimSpace(body[:eq])
+		if strings.ContainsAny(label, "!~") || strings.HasPrefix(body[eq+1:], "~") {
+			return matcher, fmt.Errorf("invalid selector %q: only = label matchers are supported", selector)
+		}
+
+		value, rest, err := unquotePrefix(strings.TrimSpace(body[eq+1:]))
+		if err != nil {
+			return matcher, fmt.Errorf("invalid selector %q: %w", selector, err)
+		}
+		matcher.Labels[label] = value
+
+		body = strings.TrimSpace(rest)
+		if body != "" {
+			if body[0] != ',' {
+				return matcher, fmt.Errorf("invalid selector %q: expected ',' between label matchers", selector)
+			}
+			body = strings.TrimSpace(body[1:])
+		}
+	}
+
+	return matcher, nil
+}
+
+// unquotePrefix unquotes the double-quoted string at the start of s and returns
+// it along with the remainder of s
+func unquotePrefix(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("label value must be quoted")
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid label value %s", s[:i+1])
+			}
+			return value, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated label value")
+}
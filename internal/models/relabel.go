@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Relabeling actions supported in aggregation output relabeling
+const (
+	RelabelReplace   = "replace"   // Sets target_label to the expanded replacement when regex matches
+	RelabelKeep      = "keep"      // Drops the series unless regex matches the source labels
+	RelabelDrop      = "drop"      // Drops the series when regex matches the source labels
+	RelabelHashMod   = "hashmod"   // Sets target_label to the hash of the source labels modulo modulus
+	RelabelHash      = "hash"      // Replaces target_label with a short hash of the source labels
+	RelabelLabelMap  = "labelmap"  // Copies labels whose name matches regex to the expanded replacement
+	RelabelLabelDrop = "labeldrop" // Removes labels whose name matches regex
+	RelabelLabelKeep = "labelkeep" // Removes labels whose name does not match regex
+	RelabelLowercase = "lowercase" // Sets target_label to the lowercased source labels
+	RelabelUppercase = "uppercase" // Sets target_label to the uppercased source labels
+)
+
+// Relabeling defaults, matching Prometheus
+const (
+	DefaultRelabelSeparator   = ";"
+	DefaultRelabelRegex       = "(.*)"
+	DefaultRelabelReplacement = "$1"
+)
+
+// MetricNameLabel is the pseudo label holding the metric name during relabeling
+const MetricNameLabel = "__name__"
+
+// ActionOrDefault returns the relabeling action, defaulting to replace
+func (c *RelabelConfig) ActionOrDefault() string {
+	if c.Action == "" {
+		return RelabelReplace
+	}
+	return c.Action
+}
+
+// Validate checks that the relabeling configuration can be applied
+func (c *RelabelConfig) Validate() error {
+	if c.Regex != "" {
+		if _, err := regexp.Compile("^(?:" + c.Regex + ")$"); err != nil {
+			return fmt.Errorf("invalid relabel regex %q: %w", c.Regex, err)
+		}
+	}
+
+	switch action := c.ActionOrDefault(); action {
+	case RelabelReplace, RelabelHash, RelabelLowercase, RelabelUppercase:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel action %s requires a target label", action)
+		}
+	case RelabelHashMod:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel action %s requires a target label", action)
+		}
+		if c.Modulus == 0 {
+			return fmt.Errorf("relabel action %s requires a modulus greater than 0", action)
+		}
+	case RelabelKeep, RelabelDrop, RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+	default:
+		return fmt.Errorf("invalid relabel action: %s", action)
+	}
+
+	return nil
+}
@@ -1,8 +1,15 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Rule represents a metrics aggregation rule that matches Grafana's Adaptive Metrics format
@@ -13,9 +20,23 @@ type Rule struct {
 	Enabled          bool             `json:"enabled" yaml:"enabled"`
 	CreatedAt        time.Time        `json:"created_at" yaml:"created_at"`
 	UpdatedAt        time.Time        `json:"updated_at" yaml:"updated_at"`
-	
+	// Who last changed the rule, as recorded in its history
+	UpdatedBy        string           `json:"updated_by,omitempty" yaml:"updated_by,omitempty"`
+
+	// Evaluation ordering: rules are evaluated by group priority, then rule priority (higher first).
+	// A terminal rule stops evaluation of any lower-ordered rules once it matches a sample.
+	Group            string           `json:"group,omitempty" yaml:"group,omitempty"`
+	Priority         int              `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Terminal         bool             `json:"terminal,omitempty" yaml:"terminal,omitempty"`
+
 	// Matching criteria for metrics
 	Matcher          MetricMatcher    `json:"matcher" yaml:"matcher"`
+
+	// Label values hashed or truncated before aggregation and output
+	LabelTransforms  []LabelTransform `json:"label_transforms,omitempty" yaml:"label_transforms,omitempty"`
+
+	// Name of an uploaded WASM transform applied to matched samples before aggregation
+	WasmTransform    string           `json:"wasm_transform,omitempty" yaml:"wasm_transform,omitempty"`
 	
 	// Aggregation configuration
 	Aggregation      AggregationConfig `json:"aggregation" yaml:"aggregation"`
@@ -31,6 +52,35 @@ type Rule struct {
 	Source           string           `json:"source,omitempty" yaml:"source,omitempty"`
 	Confidence       float64          `json:"confidence,omitempty" yaml:"confidence,omitempty"`
 	EstimatedImpact  *EstimatedImpact `json:"estimated_impact,omitempty" yaml:"estimated_impact,omitempty"`
+
+	// ID of the template this rule was instantiated from (if any)
+	TemplateID       string           `json:"template_id,omitempty" yaml:"template_id,omitempty"`
+
+	// Ownership: who maintains the rule and which team and namespace benefit from it.
+	// Team and namespace are inferred from the matched series when left empty.
+	Owner            string           `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Team             string           `json:"team,omitempty" yaml:"team,omitempty"`
+	Namespace        string           `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+
+	// Temporary rules disable themselves once they expire
+	ExpiresAt        *time.Time       `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+
+	// Scheduled rules only match samples during their time windows
+	Schedule         *RuleSchedule    `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+
+	// Shadow rules are aggregated and measured, but their output is not written
+	// downstream and original metrics are never dropped
+	Shadow           bool             `json:"shadow,omitempty" yaml:"shadow,omitempty"`
+
+	// Set when dropping original metrics was suspended because the rule
+	// stopped producing output; cleared by setting the rollout again
+	DropsSuspended   *DropSuspension  `json:"drops_suspended,omitempty" yaml:"drops_suspended,omitempty"`
+}
+
+// DropSuspension records why and when a rule stopped dropping its original metrics
+type DropSuspension struct {
+	At     time.Time `json:"at" yaml:"at"`
+	Reason string    `json:"reason" yaml:"reason"`
 }
 
 // EstimatedImpact represents the estimated impact of applying a rule
@@ -49,13 +99,19 @@ type MetricMatcher struct {
 	// Grafana-specific matcher options
 	IncludeMetaLabels bool              `json:"include_meta_labels,omitempty" yaml:"include_meta_labels,omitempty"`
 	ExcludeLabels     []string          `json:"exclude_labels,omitempty" yaml:"exclude_labels,omitempty"`
+	// Family lists differently named metrics aggregated into the same output,
+	// with the labels mapped onto the labels of the other metrics
+	Family []FamilySource `json:"family,omitempty" yaml:"family,omitempty"`
 }
 
 // AggregationConfig defines how metrics should be aggregated
 type AggregationConfig struct {
-	// Aggregation type: sum, avg, min, max, count
+	// Aggregation type: sum, avg, min, max, count, last, first or promql
 	Type string `json:"type" yaml:"type"`
 	
+	// PromQL expression evaluated over the samples of each interval (type promql only)
+	Expression string `json:"expression,omitempty" yaml:"expression,omitempty"`
+	
 	// The interval for aggregation in seconds
 	IntervalSeconds int `json:"interval_seconds" yaml:"interval_seconds"`
 	
@@ -89,8 +145,41 @@ type OutputConfig struct {
 	// Whether to drop original metrics after aggregation
 	DropOriginal bool `json:"drop_original" yaml:"drop_original"`
 	
+	// Percentage (0-100) of original series dropped, chosen by a hash of
+	// RolloutLabels; all of them when unset
+	RolloutPercentage *int `json:"rollout_percentage,omitempty" yaml:"rollout_percentage,omitempty"`
+	
+	// Labels hashed to pick the series dropped during a rollout (default __name__ and instance)
+	RolloutLabels []string `json:"rollout_labels,omitempty" yaml:"rollout_labels,omitempty"`
+	
 	// Grafana-specific output options
 	KeepLabels []string `json:"keep_labels,omitempty" yaml:"keep_labels,omitempty"`
+	
+	// Relabeling applied to aggregated metrics before they are written
+	Relabeling []RelabelConfig `json:"relabeling,omitempty" yaml:"relabeling,omitempty"`
+	
+	// Remote write destination of the aggregated metrics; the default endpoints when unset
+	RemoteWriteTarget *RemoteWriteTarget `json:"remote_write_target,omitempty" yaml:"remote_write_target,omitempty"`
+	
+	// What an aggregated gauge represents within each interval: last, min, max or avg.
+	// Sent as the gauge_semantics label and in the remote write metadata.
+	GaugeSemantics string `json:"gauge_semantics,omitempty" yaml:"gauge_semantics,omitempty"`
+	
+	// Additional statistics emitted from the same buckets, each as its own metric
+	Statistics []OutputStatistic `json:"statistics,omitempty" yaml:"statistics,omitempty"`
+}
+
+// RemoteWriteTarget routes the aggregated metrics of a rule to a specific
+// remote write endpoint or tenant
+type RemoteWriteTarget struct {
+	// Name or URL of a configured remote write endpoint; all default endpoints when empty
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	
+	// Tenant sent in the configured tenant header
+	Tenant string `json:"tenant,omitempty" yaml:"tenant,omitempty"`
+	
+	// Headers added to, or overriding, the configured headers
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
 }
 
 // KubernetesOutputConfig defines the configuration for generating Kubernetes monitoring resources
@@ -110,6 +199,9 @@ type KubernetesOutputConfig struct {
 	// Name of the existing monitor to modify (required for mode="modify" or "patch")
 	ExistingMonitorName string `json:"existing_monitor_name,omitempty" yaml:"existing_monitor_name,omitempty"`
 	
+	// File holding the existing monitor for mode="patch"; when empty it is read from the cluster
+	ExistingMonitorFile string `json:"existing_monitor_file,omitempty" yaml:"existing_monitor_file,omitempty"`
+	
 	// Labels to add to the monitor resource
 	Labels map[string]string `json:"labels" yaml:"labels"`
 	
@@ -123,6 +215,10 @@ type KubernetesOutputConfig struct {
 	// Interval for scraping
 	Interval string `json:"interval" yaml:"interval"`
 	
+	// Workloads to generate a monitor for each. When empty, a single monitor
+	// is generated from Namespace, Selector, Port, Path and Interval.
+	Targets []MonitorTarget `json:"targets,omitempty" yaml:"targets,omitempty"`
+	
 	// Advanced metric relabeling configuration
 	MetricRelabeling []RelabelConfig `json:"metric_relabeling,omitempty" yaml:"metric_relabeling,omitempty"`
 	
@@ -134,6 +230,85 @@ type KubernetesOutputConfig struct {
 	
 	// TLS configuration
 	TLSConfig *TLSConfig `json:"tls_config,omitempty" yaml:"tls_config,omitempty"`
+	
+	// Health alerts generated alongside the monitor (optional)
+	Alerts *AlertsConfig `json:"alerts,omitempty" yaml:"alerts,omitempty"`
+}
+
+// AlertsConfig controls the PrometheusRule generated to alert on the health of an aggregation
+type AlertsConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	
+	// How long a condition must hold before alerting (default 10m)
+	For string `json:"for,omitempty" yaml:"for,omitempty"`
+	
+	// Severity label of the alerts (default warning)
+	Severity string `json:"severity,omitempty" yaml:"severity,omitempty"`
+	
+	// Additional labels to add to the alerts
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// MonitorTarget is one workload an aggregated metric originates from. Empty
+// fields fall back to the values of the enclosing KubernetesOutputConfig.
+type MonitorTarget struct {
+	// Name distinguishes the generated monitor; defaults to the namespace
+	Name                string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Namespace           string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Selector            map[string]string `json:"selector,omitempty" yaml:"selector,omitempty"`
+	Port                string            `json:"port,omitempty" yaml:"port,omitempty"`
+	Path                string            `json:"path,omitempty" yaml:"path,omitempty"`
+	Interval            string            `json:"interval,omitempty" yaml:"interval,omitempty"`
+	ExistingMonitorName string            `json:"existing_monitor_name,omitempty" yaml:"existing_monitor_name,omitempty"`
+	ExistingMonitorFile string            `json:"existing_monitor_file,omitempty" yaml:"existing_monitor_file,omitempty"`
+}
+
+// MonitorTargets returns the targets to generate monitors for, with empty
+// fields filled in from the config. Without explicit targets, the config
+// itself is the only target.
+func (c *KubernetesOutputConfig) MonitorTargets() []MonitorTarget {
+	if len(c.Targets) == 0 {
+		return []MonitorTarget{{
+			Namespace:           c.Namespace,
+			Selector:            c.Selector,
+			Port:                c.Port,
+			Path:                c.Path,
+			Interval:            c.Interval,
+			ExistingMonitorName: c.ExistingMonitorName,
+			ExistingMonitorFile: c.ExistingMonitorFile,
+		}}
+	}
+
+	targets := make([]MonitorTarget, len(c.Targets))
+	for i, target := range c.Targets {
+		if target.Namespace == "" {
+			target.Namespace = c.Namespace
+		}
+		if target.Name == "" {
+			target.Name = target.Namespace
+		}
+		if target.Selector == nil {
+			target.Selector = c.Selector
+		}
+		if target.Port == "" {
+			target.Port = c.Port
+		}
+		if target.Path == "" {
+			target.Path = c.Path
+		}
+		if target.Interval == "" {
+			target.Interval = c.Interval
+		}
+		if target.ExistingMonitorName == "" {
+			target.ExistingMonitorName = c.ExistingMonitorName
+		}
+		if target.ExistingMonitorFile == "" {
+			target.ExistingMonitorFile = c.ExistingMonitorFile
+		}
+		targets[i] = target
+	}
+
+	return targets
 }
 
 // RelabelConfig represents a metric relabeling configuration
@@ -156,12 +331,62 @@ type TLSConfig struct {
 	ServerName         string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
 }
 
+// AggregationPromQL is the aggregation type of rules whose output is a PromQL
+// expression evaluated over the samples of each interval
+const AggregationPromQL = "promql"
+
+// aggregationTypes are the valid aggregation types
+var aggregationTypes = map[string]bool{
+	"sum":             true,
+	"avg":             true,
+	"min":             true,
+	"max":             true,
+	"count":           true,
+	"last":            true,
+	"first":           true,
+	AggregationPromQL: true,
+}
+
+// Temporalities of counter samples
+const (
+	// TemporalityCumulative counters report the total since the series started
+	TemporalityCumulative = "cumulative"
+	// TemporalityDelta counters report the increase since the previous sample
+	TemporalityDelta = "delta"
+)
+
+// NameTemporality returns the temporality of a series whose type is unknown:
+// cumulative if its name follows the counter, histogram or summary naming
+// conventions, and empty for a gauge otherwise
+func NameTemporality(name string) string {
+	for _, suffix := range []string{"_total", "_bucket", "_count", "_sum"} {
+		if strings.HasSuffix(name, suffix) {
+			return TemporalityCumulative
+		}
+	}
+	return ""
+}
+
 // MetricSample represents a single metric sample
 type MetricSample struct {
 	Name      string            `json:"name"`
 	Value     float64           `json:"value"`
 	Timestamp time.Time         `json:"timestamp"`
 	Labels    map[string]string `json:"labels"`
+	// Temporality is set for counter samples; empty for gauges
+	Temporality string `json:"temporality,omitempty"`
+	// Tenant is the tenant of the request that delivered the sample
+	Tenant string `json:"-"`
+	// Forwarded is set for samples received from a peer instance; they are
+	// always aggregated locally and never forwarded again
+	Forwarded bool `json:"-"`
+	// Rules are the IDs of the rules a forwarded sample is aggregated by, as
+	// matched by the instance that received it
+	Rules []string `json:"-"`
+	// SpanContext is the trace context of the request that delivered the sample
+	SpanContext trace.SpanContext `json:"-"`
+	// EnqueuedAt is when the sample entered the input queue of the processor
+	EnqueuedAt time.Time `json:"-"`
 }
 
 // AggregatedMetric represents an aggregated metric result
@@ -173,6 +398,23 @@ type AggregatedMetric struct {
 	Labels     map[string]string `json:"labels"`
 	SourceRule string            `json:"source_rule"`
 	Count      int               `json:"count"` // Number of samples aggregated
+	// Timestamp is the time of the output sample within the interval; the
+	// end of the interval when not set
+	Timestamp time.Time `json:"timestamp"`
+	// SpanContext is the trace context of the flush that produced the metric
+	SpanContext trace.SpanContext `json:"-"`
+	// Target is the remote write destination of the source rule, if any
+	Target *RemoteWriteTarget `json:"-"`
+	// Semantics are the gauge semantics declared by the source rule, if any
+	Semantics string `json:"semantics,omitempty"`
+}
+
+// SampleTime returns the timestamp of the output sample
+func (m *AggregatedMetric) SampleTime() time.Time {
+	if m.Timestamp.IsZero() {
+		return m.EndTime
+	}
+	return m.Timestamp
 }
 
 // Validate checks if the rule configuration is valid
@@ -182,21 +424,25 @@ func (r *Rule) Validate() error {
 		return fmt.Errorf("rule name is required")
 	}
 	
-	if len(r.Matcher.MetricNames) == 0 {
+	if len(r.Matcher.MetricNames) == 0 && len(r.Matcher.Family) == 0 {
 		return fmt.Errorf("at least one metric name must be specified")
 	}
+	if err := r.Matcher.validateFamily(); err != nil {
+		return err
+	}
+	if r.Schedule != nil {
+		if _, err := r.Schedule.Compile(); err != nil {
+			return err
+		}
+	}
 	
 	// Validate aggregation type
-	validTypes := map[string]bool{
-		"sum":   true,
-		"avg":   true,
-		"min":   true,
-		"max":   true,
-		"count": true,
-	}
-	if !validTypes[r.Aggregation.Type] {
+	if !aggregationTypes[r.Aggregation.Type] {
 		return fmt.Errorf("invalid aggregation type: %s", r.Aggregation.Type)
 	}
+	if err := r.validateExpression(); err != nil {
+		return err
+	}
 	
 	// Validate interval
 	if r.Aggregation.IntervalSeconds <= 0 {
@@ -232,17 +478,261 @@ func (r *Rule) Validate() error {
 	if r.Output.MetricName == "" {
 		return fmt.Errorf("output metric name is required")
 	}
+	if err := CurrentOutputNamePolicy().Check(r.Output.MetricName); err != nil {
+		return err
+	}
+	
+	// Keep labels must include the segmentation labels, or segments would collide
+	if len(r.Output.KeepLabels) > 0 {
+		keep := make(map[string]bool, len(r.Output.KeepLabels))
+		for _, label := range r.Output.KeepLabels {
+			keep[label] = true
+		}
+		for _, label := range r.Aggregation.Segmentation {
+			if !keep[label] {
+				return fmt.Errorf("keep labels must include segmentation label %s", label)
+			}
+		}
+	}
+	
+	for i := range r.LabelTransforms {
+		if err := r.LabelTransforms[i].Validate(); err != nil {
+			return fmt.Errorf("label transform %d: %w", i, err)
+		}
+	}
+	
+	if r.WasmTransform != "" {
+		if err := ValidateID(r.WasmTransform); err != nil {
+			return fmt.Errorf("wasm transform: %w", err)
+		}
+	}
+	
+	for i := range r.Output.Relabeling {
+		if err := r.Output.Relabeling[i].Validate(); err != nil {
+			return fmt.Errorf("output relabeling %d: %w", i, err)
+		}
+	}
+	
+	if p := r.Output.RolloutPercentage; p != nil && (*p < 0 || *p > 100) {
+		return fmt.Errorf("rollout percentage must be between 0 and 100")
+	}
+	
+	if err := r.validateGaugeSemantics(); err != nil {
+		return err
+	}
+	if err := r.validateStatistics(); err != nil {
+		return err
+	}
 	
 	return nil
 }
 
+// validateExpression checks that promql rules, and only those, have an
+// expression that evaluates to an instant vector or a scalar
+func (r *Rule) validateExpression() error {
+	if r.Aggregation.Type != AggregationPromQL {
+		if r.Aggregation.Expression != "" {
+			return fmt.Errorf("expression is only supported by the %s aggregation type", AggregationPromQL)
+		}
+		return nil
+	}
+	
+	if r.Aggregation.Expression == "" {
+		return fmt.Errorf("expression is required for the %s aggregation type", AggregationPromQL)
+	}
+	expr, err := parser.ParseExpr(r.Aggregation.Expression)
+	if err != nil {
+		return fmt.Errorf("invalid expression: %w", err)
+	}
+	if t := expr.Type(); t != parser.ValueTypeVector && t != parser.ValueTypeScalar {
+		return fmt.Errorf("expression must evaluate to an instant vector or a scalar, not a %s", t)
+	}
+	return nil
+}
+
+// Expired reports whether the rule has an expiry time at or before now
+func (r *Rule) Expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !r.ExpiresAt.After(now)
+}
+
+// DropsOriginals reports whether the original metrics of the rule are dropped.
+// Shadow rules never drop them.
+func (r *Rule) DropsOriginals() bool {
+	if r.Shadow {
+		return false
+	}
+	return r.Output.DropOriginal || (r.OutputKubernetes != nil && r.OutputKubernetes.DropOriginalMetrics)
+}
+
+// DropPercentage returns the percentage of original series the rule drops
+func (r *Rule) DropPercentage() int {
+	if !r.DropsOriginals() || r.DropsSuspended != nil {
+		return 0
+	}
+	if r.Output.RolloutPercentage != nil {
+		return *r.Output.RolloutPercentage
+	}
+	return 100
+}
+
+// RolloutHashLabels returns the labels hashed to pick the original series
+// dropped during a rollout
+func (r *Rule) RolloutHashLabels() []string {
+	if len(r.Output.RolloutLabels) > 0 {
+		return r.Output.RolloutLabels
+	}
+	return []string{MetricNameLabel, "instance"}
+}
+
+// GroupingLabels returns the labels aggregated series are grouped by and keep:
+// Output.KeepLabels when set, otherwise Aggregation.Segmentation
+func (r *Rule) GroupingLabels() []string {
+	if len(r.Output.KeepLabels) > 0 {
+		return r.Output.KeepLabels
+	}
+	return r.Aggregation.Segmentation
+}
+
 // Recommendation represents a suggested aggregation rule from the recommendation engine
 type Recommendation struct {
-	ID              string          `json:"id"`
-	CreatedAt       time.Time       `json:"created_at"`
-	Rule            Rule            `json:"rule"`
-	Confidence      float64         `json:"confidence"`
-	EstimatedImpact *EstimatedImpact `json:"estimated_impact"`
-	Source          string          `json:"source"`
-	Status          string          `json:"status"` // "pending", "applied", "rejected"
-}
\ No newline at end of file
+	ID              string                     `json:"id"`
+	CreatedAt       time.Time                  `json:"created_at"`
+	Rule            Rule                       `json:"rule"`
+	Confidence      float64                    `json:"confidence"`
+	EstimatedImpact *EstimatedImpact           `json:"estimated_impact"`
+	Source          string                     `json:"source"`
+	Status          string                     `json:"status"` // "pending", "applied", "rejected", "expired"
+	Explanation     *RecommendationExplanation `json:"explanation,omitempty"`
+	AppliedAt       *time.Time                 `json:"applied_at,omitempty"`
+	MeasuredImpact  *MeasuredImpact            `json:"measured_impact,omitempty"`
+	// UpstreamID is the ID of a recommendation imported from Grafana
+	UpstreamID string `json:"upstream_id,omitempty"`
+	// UpstreamStatus is the status Grafana last reported or acknowledged
+	UpstreamStatus string `json:"upstream_status,omitempty"`
+	// LastRegeneratedAt is when the engine last suggested the recommendation
+	// again, and Regenerations how many times it did
+	LastRegeneratedAt *time.Time `json:"last_regenerated_at,omitempty"`
+	Regenerations     int        `json:"regenerations,omitempty"`
+	// ExpiredAt is when a pending recommendation expired for being based on
+	// outdated usage data
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
+	// ImpactRefreshedAt is when the estimated impact was last recomputed from
+	// current usage data without regenerating the recommendation
+	ImpactRefreshedAt *time.Time `json:"impact_refreshed_at,omitempty"`
+}
+
+// Stale reports whether a pending recommendation was last suggested by the
+// engine more than maxAge ago
+func (r *Recommendation) Stale(now time.Time, maxAge time.Duration) bool {
+	if r.Status != "pending" || maxAge <= 0 {
+		return false
+	}
+	suggested := r.CreatedAt
+	if r.LastRegeneratedAt != nil && r.LastRegeneratedAt.After(suggested) {
+		suggested = *r.LastRegeneratedAt
+	}
+	return now.Sub(suggested) > maxAge
+}
+
+// RecommendationID derives the ID of a generated recommendation from what it
+// suggests, so that runs suggesting the same aggregation of a metric produce
+// the same ID
+func RecommendationID(metricName string, segmentation []string, aggregationType string) string {
+	labels := append([]string(nil), segmentation...)
+	sort.Strings(labels)
+	sum := sha256.Sum256([]byte(metricName + "\x00" + strings.Join(labels, ",") + "\x00" + aggregationType))
+	return "rec-" + hex.EncodeToString(sum[:8])
+}
+
+// Merge updates a stored recommendation with a regenerated one of the same ID.
+// Pending recommendations take the new rule, estimates and explanation, and
+// expired ones become pending again with them; applied and rejected ones keep
+// their decision and rule. All keep their creation time and record the
+// regeneration.
+func (r *Recommendation) Merge(regenerated Recommendation, now time.Time) {
+	if r.Status == "expired" {
+		r.Status = "pending"
+		r.ExpiredAt = nil
+	}
+	if r.Status == "pending" {
+		r.Rule = regenerated.Rule
+		r.Confidence = regenerated.Confidence
+		r.EstimatedImpact = regenerated.EstimatedImpact
+		r.Explanation = regenerated.Explanation
+	}
+	r.LastRegeneratedAt = &now
+	r.Regenerations++
+}
+
+// RecommendationSourceGrafana is the source of recommendations imported from
+// the Grafana Adaptive Metrics plugin or Grafana Cloud
+const RecommendationSourceGrafana = "grafana"
+
+// MeasuredImpact is the impact of an applied recommendation observed from usage
+// data after it was applied
+type MeasuredImpact struct {
+	MeasuredAt           time.Time `json:"measured_at"`
+	Complete             bool      `json:"complete"`         // The verification window has passed
+	BaselineSamples      int64     `json:"baseline_samples"` // Samples of the original metric seen before it was applied
+	InputSeries          int       `json:"input_series"`
+	OutputSeries         int       `json:"output_series"`
+	InputSamples         int64     `json:"input_samples"` // Samples of the original metric seen since it was applied
+	OutputSamples        int64     `json:"output_samples"`
+	CardinalityReduction float64   `json:"cardinality_reduction"`
+	SampleReduction      float64   `json:"sample_reduction"`
+	SavingsPercentage    float64   `json:"savings_percentage"`
+	Accuracy             float64   `json:"accuracy"` // Measured over estimated cardinality reduction
+	Diverged             bool      `json:"diverged"`
+}
+
+// Reasons a label was kept or excluded from a recommended rule's segmentation
+const (
+	LabelReasonKept               = "kept"
+	LabelReasonCardinalityTooHigh = "cardinality_too_high"
+	LabelReasonCardinalityTooLow  = "cardinality_too_low"
+	LabelReasonSegmentationLimit  = "segmentation_limit"
+	LabelReasonProtected          = "protected"
+	LabelReasonBucketBound        = "bucket_bound"
+	LabelReasonJointCardinalityTooHigh = "joint_cardinality_too_high"
+)
+
+// RecommendationExplanation describes why the recommendation engine suggested a rule
+type RecommendationExplanation struct {
+	Stats      RecommendationStats `json:"stats"`
+	Labels     []LabelDecision     `json:"labels"`
+	Consumers  []string            `json:"consumers,omitempty"` // Dashboards, alerts or queries known to use the metric
+	Confidence ConfidenceBreakdown `json:"confidence"`
+}
+
+// RecommendationStats are the usage statistics and thresholds a recommendation was based on
+type RecommendationStats struct {
+	SampleCount    int64   `json:"sample_count"`
+	Cardinality    int     `json:"cardinality"`
+	MinSamples     int64   `json:"min_samples"`
+	MinCardinality int     `json:"min_cardinality"`
+	MinConfidence  float64 `json:"min_confidence"`
+}
+
+// LabelDecision records whether a label was kept for segmentation and why
+type LabelDecision struct {
+	Label       string `json:"label"`
+	Cardinality int    `json:"cardinality"`
+	Kept        bool   `json:"kept"`
+	Reason      string `json:"reason"`
+}
+
+// ConfidenceBreakdown holds the weighted components that add up to a recommendation's confidence
+type ConfidenceBreakdown struct {
+	SampleScore      float64 `json:"sample_score"`
+	CardinalityScore float64 `json:"cardinality_score"`
+	ImpactScore      float64 `json:"impact_score"`
+	TrendAdjustment  float64 `json:"trend_adjustment"`
+	// CalibrationAdjustment lowers confidence when applied recommendations
+	// saved less than estimated
+	CalibrationAdjustment float64 `json:"calibration_adjustment"`
+}
+
+// Total returns the confidence score the components add up to
+func (c ConfidenceBreakdown) Total() float64 {
+	return c.SampleScore + c.CardinalityScore + c.ImpactScore + c.TrendAdjustment + c.CalibrationAdjustment
+}
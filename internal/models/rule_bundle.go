@@ -0,0 +1,119 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RuleBundleKind identifies rule bundle documents
+const RuleBundleKind = "RuleBundle"
+
+// RuleBundle is an export of all rules, used for backups and to promote rules
+// between environments. Its hash detects rules changed after the export.
+type RuleBundle struct {
+	Kind          string    `json:"kind" yaml:"kind"`
+	ExportedAt    time.Time `json:"exported_at" yaml:"exported_at"`
+	ServerVersion string    `json:"server_version" yaml:"server_version"`
+	// Hash is the SHA-256 digest of the rules, see HashRules
+	Hash  string  `json:"hash" yaml:"hash"`
+	Rules []*Rule `json:"rules" yaml:"rules"`
+}
+
+// NewRuleBundle creates a bundle of rules with their hash
+func NewRuleBundle(rules []*Rule, serverVersion string, now time.Time) (*RuleBundle, error) {
+	hash, err := HashRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return &RuleBundle{
+		Kind:          RuleBundleKind,
+		ExportedAt:    now,
+		ServerVersion: serverVersion,
+		Hash:          hash,
+		Rules:         rules,
+	}, nil
+}
+
+// Verify checks that the rules of the bundle match its hash
+func (b *RuleBundle) Verify() error {
+	if b.Hash == "" {
+		return fmt.Errorf("rule bundle has no hash")
+	}
+	hash, err := HashRules(b.Rules)
+	if err != nil {
+		return err
+	}
+	if hash != b.Hash {
+		return fmt.Errorf("rule bundle hash mismatch: rules were modified after the export")
+	}
+	return nil
+}
+
+// HashRules returns the SHA-256 digest of a set of rules, independent of their
+// order and of whether the bundle was encoded as JSON or YAML
+func HashRules(rules []*Rule) (string, error) {
+	sorted := make([]*Rule, len(rules))
+	copy(sorted, rules)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	// The rules are hashed in a canonical JSON form: keys are sorted, and empty
+	// values dropped, as YAML does not tell null from empty collections
+	data, err := json.Marshal(sorted)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode rules: %w", err)
+	}
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return "", fmt.Errorf("failed to encode rules: %w", err)
+	}
+	canonical, err := json.Marshal(dropEmpty(document))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode rules: %w", err)
+	}
+
+	sum := sha256.Sum256(canonical)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// dropEmpty removes null values and empty collections from object fields
+func dropEmpty(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			field = dropEmpty(field)
+			if isEmpty(field) {
+				delete(v, key)
+				continue
+			}
+			v[key] = field
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = dropEmpty(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// isEmpty reports whether a JSON value is null or an empty collection
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
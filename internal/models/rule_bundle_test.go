@@ -0,0 +1,82 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRuleBundle_Verify(t *testing.T) {
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	rules := []*Rule{
+		{
+			ID:        "b",
+			Name:      "requests",
+			Enabled:   true,
+			Matcher:   MetricMatcher{MetricNames: []string{"http_requests_total"}},
+			Output:    OutputConfig{MetricName: "http_requests:sum"},
+			CreatedAt: time.Now(),
+			ExpiresAt: &expiresAt,
+		},
+		{
+			ID:      "a",
+			Name:    "latency",
+			Matcher: MetricMatcher{MetricNames: []string{"latency_seconds"}, Labels: map[string]string{}},
+			Aggregation: AggregationConfig{
+				Type:            "avg",
+				IntervalSeconds: 60,
+				Segmentation:    []string{"service"},
+			},
+		},
+	}
+
+	bundle, err := NewRuleBundle(rules, "1.2.3", time.Now())
+	if err != nil {
+		t.Fatalf("NewRuleBundle() error = %v", err)
+	}
+
+	t.Run("JSON round trip", func(t *testing.T) {
+		data, err := json.Marshal(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded RuleBundle
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(); err != nil {
+			t.Errorf("Verify() error = %v", err)
+		}
+	})
+
+	t.Run("YAML round trip", func(t *testing.T) {
+		data, err := yaml.Marshal(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded RuleBundle
+		if err := yaml.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(); err != nil {
+			t.Errorf("Verify() error = %v", err)
+		}
+	})
+
+	t.Run("order independent", func(t *testing.T) {
+		reversed, _ := HashRules([]*Rule{rules[1], rules[0]})
+		if reversed != bundle.Hash {
+			t.Errorf("HashRules() of reordered rules = %s, want %s", reversed, bundle.Hash)
+		}
+	})
+
+	t.Run("modified rule", func(t *testing.T) {
+		modified := *bundle
+		modified.Rules = []*Rule{rules[0], {ID: "a", Name: "changed"}}
+		if err := modified.Verify(); err == nil {
+			t.Error("Expected a hash mismatch for a modified rule")
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -118,6 +119,47 @@ func TestRule_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "output metric name is required",
 		},
+		{
+			name: "keep labels missing segmentation label",
+			rule: Rule{
+				Name: "Test Rule",
+				Matcher: MetricMatcher{
+					MetricNames: []string{"http_requests_total"},
+				},
+				Aggregation: AggregationConfig{
+					Type:            "sum",
+					IntervalSeconds: 60,
+					Segmentation:    []string{"service", "status"},
+				},
+				Output: OutputConfig{
+					MetricName: "http_requests_aggregated",
+					KeepLabels: []string{"service"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "keep labels must include segmentation label status",
+		},
+		{
+			name: "invalid output relabeling",
+			rule: Rule{
+				Name: "Test Rule",
+				Matcher: MetricMatcher{
+					MetricNames: []string{"http_requests_total"},
+				},
+				Aggregation: AggregationConfig{
+					Type:            "sum",
+					IntervalSeconds: 60,
+				},
+				Output: OutputConfig{
+					MetricName: "http_requests_aggregated",
+					Relabeling: []RelabelConfig{
+						{Action: RelabelHashMod, TargetLabel: "shard"},
+					},
+				},
+			},
+			wantErr: true,
+			errMsg:  "output relabeling 0: relabel action hashmod requires a modulus greater than 0",
+		},
 		{
 			name: "invalid segmentation rule - missing label",
 			rule: Rule{
@@ -218,6 +260,43 @@ func TestRule_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "segmentation values must be specified for type include",
 		},
+		{
+			name: "valid promql rule",
+			rule: Rule{
+				Name: "Test Rule",
+				Matcher: MetricMatcher{
+					MetricNames: []string{"http_errors_total", "http_requests_total"},
+				},
+				Aggregation: AggregationConfig{
+					Type:            AggregationPromQL,
+					Expression:      "sum by (service) (http_errors_total) / sum by (service) (http_requests_total)",
+					IntervalSeconds: 60,
+				},
+				Output: OutputConfig{
+					MetricName: "service:http_error_ratio",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "promql rule with a range vector expression",
+			rule: Rule{
+				Name: "Test Rule",
+				Matcher: MetricMatcher{
+					MetricNames: []string{"http_requests_total"},
+				},
+				Aggregation: AggregationConfig{
+					Type:            AggregationPromQL,
+					Expression:      "http_requests_total[1m]",
+					IntervalSeconds: 60,
+				},
+				Output: OutputConfig{
+					MetricName: "http_requests_aggregated",
+				},
+			},
+			wantErr: true,
+			errMsg:  "expression must evaluate to an instant vector or a scalar, not a matrix",
+		},
 	}
 
 	for _, tt := range tests {
@@ -329,4 +408,177 @@ func TestAggregatedMetric(t *testing.T) {
 	if metric.Count != 10 {
 		t.Errorf("AggregatedMetric.Count = %v, want %v", metric.Count, 10)
 	}
-}
\ No newline at end of file
+}
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector   string
+		wantName   string
+		wantLabels map[string]string
+		wantErr    bool
+	}{
+		{selector: "up", wantName: "up", wantLabels: map[string]string{}},
+		{selector: `http_requests_total{service="checkout", code="200"}`, wantName: "http_requests_total", wantLabels: map[string]string{"service": "checkout", "code": "200"}},
+		{selector: `{slo="true"}`, wantName: "*", wantLabels: map[string]string{"slo": "true"}},
+		{selector: `up{path="a\"b"}`, wantName: "up", wantLabels: map[string]string{"path": `a"b`}},
+		{selector: `up{job!="api"}`, wantErr: true},
+		{selector: `up{job=~"api"}`, wantErr: true},
+		{selector: `up{job=api}`, wantErr: true},
+		{selector: `up{job="api"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			matcher, err := ParseSelector(tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if matcher.MetricNames[0] != tt.wantName {
+				t.Errorf("ParseSelector() name = %v, want %v", matcher.MetricNames[0], tt.wantName)
+			}
+			if len(matcher.Labels) != len(tt.wantLabels) {
+				t.Fatalf("ParseSelector() labels = %v, want %v", matcher.Labels, tt.wantLabels)
+			}
+			for k, v := range tt.wantLabels {
+				if matcher.Labels[k] != v {
+					t.Errorf("ParseSelector() label %s = %v, want %v", k, matcher.Labels[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestRecommendationID(t *testing.T) {
+	id := RecommendationID("http_requests_total", []string{"service", "method"}, "sum")
+	if got := RecommendationID("http_requests_total", []string{"method", "service"}, "sum"); got != id {
+		t.Errorf("RecommendationID() = %s with reordered segmentation, want %s", got, id)
+	}
+	if !strings.HasPrefix(id, "rec-") {
+		t.Errorf("RecommendationID() = %s, want rec- prefix", id)
+	}
+	if got := RecommendationID("http_requests_total", []string{"service"}, "sum"); got == id {
+		t.Error("RecommendationID() should differ for another segmentation")
+	}
+	if got := RecommendationID("http_requests_total", []string{"service", "method"}, "avg"); got == id {
+		t.Error("RecommendationID() should differ for another aggregation type")
+	}
+}
+
+func TestRecommendation_Merge(t *testing.T) {
+	now := time.Now()
+	regenerated := Recommendation{
+		Rule:        Rule{Name: "new"},
+		Confidence:  0.9,
+		Explanation: &RecommendationExplanation{},
+	}
+
+	pending := Recommendation{Status: "pending", Rule: Rule{Name: "old"}, Confidence: 0.5}
+	pending.Merge(regenerated, now)
+	if pending.Rule.Name != "new" || pending.Confidence != 0.9 || pending.Explanation != regenerated.Explanation {
+		t.Errorf("pending recommendation was not updated: %+v", pending)
+	}
+	if pending.Regenerations != 1 || pending.LastRegeneratedAt == nil || !pending.LastRegeneratedAt.Equal(now) {
+		t.Errorf("regeneration not recorded: %d, %v", pending.Regenerations, pending.LastRegeneratedAt)
+	}
+
+	rejected := Recommendation{Status: "rejected", Rule: Rule{Name: "old"}, Confidence: 0.5}
+	rejected.Merge(regenerated, now)
+	rejected.Merge(regenerated, now)
+	if rejected.Status != "rejected" || rejected.Rule.Name != "old" || rejected.Confidence != 0.5 {
+		t.Errorf("rejected recommendation should keep its decision: %+v", rejected)
+	}
+	if rejected.Regenerations != 2 {
+		t.Errorf("Regenerations = %d, want 2", rejected.Regenerations)
+	}
+
+	expiredAt := now.Add(-time.Hour)
+	expired := Recommendation{Status: "expired", ExpiredAt: &expiredAt, Rule: Rule{Name: "old"}}
+	expired.Merge(regenerated, now)
+	if expired.Status != "pending" || expired.ExpiredAt != nil || expired.Rule.Name != "new" {
+		t.Errorf("expired recommendation should be pending again with the new rule: %+v", expired)
+	}
+}
+
+func TestRecommendation_Stale(t *testing.T) {
+	now := time.Now()
+	maxAge := 24 * time.Hour
+	regenerated := now.Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		rec  Recommendation
+		want bool
+	}{
+		{"recent", Recommendation{Status: "pending", CreatedAt: now.Add(-time.Hour)}, false},
+		{"old", Recommendation{Status: "pending", CreatedAt: now.Add(-48 * time.Hour)}, true},
+		{"old but regenerated", Recommendation{Status: "pending", CreatedAt: now.Add(-48 * time.Hour), LastRegeneratedAt: &regenerated}, false},
+		{"old but applied", Recommendation{Status: "applied", CreatedAt: now.Add(-48 * time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rec.Stale(now, maxAge); got != tt.want {
+			t.Errorf("%s: Stale() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRule_Validate_GaugeSemantics(t *testing.T) {
+	tests := []struct {
+		aggregation, semantics string
+		wantErr                bool
+	}{
+		{"max", GaugeSemanticsMax, false},
+		{"sum", GaugeSemanticsLast, false},
+		{AggregationPromQL, GaugeSemanticsAvg, false},
+		{"max", GaugeSemanticsMin, true},
+		{"count", GaugeSemanticsLast, true},
+		{"last", GaugeSemanticsLast, false},
+		{"first", GaugeSemanticsLast, true},
+		{"sum", "median", true},
+	}
+	for _, tt := range tests {
+		rule := Rule{
+			Name:        "Gauge Rule",
+			Matcher:     MetricMatcher{MetricNames: []string{"memory_bytes"}},
+			Aggregation: AggregationConfig{Type: tt.aggregation, IntervalSeconds: 60},
+			Output:      OutputConfig{MetricName: "memory_bytes:agg", GaugeSemantics: tt.semantics},
+		}
+		if tt.aggregation == AggregationPromQL {
+			rule.Aggregation.Expression = "avg(memory_bytes)"
+		}
+		if err := rule.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate() with %s semantics on %s error = %v, wantErr %v", tt.semantics, tt.aggregation, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRule_Validate_Statistics(t *testing.T) {
+	tests := []struct {
+		name       string
+		statistics []OutputStatistic
+		wantErr    bool
+	}{
+		{"count and max", []OutputStatistic{{Type: "count"}, {Type: "max"}}, false},
+		{"same type as the rule", []OutputStatistic{{Type: "sum"}}, true},
+		{"twice", []OutputStatistic{{Type: "count"}, {Type: "count", MetricName: "other"}}, true},
+		{"same name", []OutputStatistic{{Type: "count", MetricName: "request_bytes"}}, true},
+		{"unknown type", []OutputStatistic{{Type: "p99"}}, true},
+	}
+	for _, tt := range tests {
+		rule := Rule{
+			Name:        "Statistics Rule",
+			Matcher:     MetricMatcher{MetricNames: []string{"request_bytes"}},
+			Aggregation: AggregationConfig{Type: "sum", IntervalSeconds: 60},
+			Output:      OutputConfig{MetricName: "request_bytes", Statistics: tt.statistics},
+		}
+		if err := rule.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	rule := Rule{Output: OutputConfig{MetricName: "request_bytes", Statistics: []OutputStatistic{{Type: "count"}}}}
+	if got := rule.OutputMetricNames(); len(got) != 2 || got[1] != "request_bytes_count" {
+		t.Errorf("OutputMetricNames() = %v, want request_bytes and request_bytes_count", got)
+	}
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RuleSchedule restricts a rule to recurring time windows. Each window has
+// the form "[days] [HH:MM-HH:MM]", e.g. "mon-fri 09:00-17:00", "sat,sun" or
+// "22:00-06:00"; days default to every day and times to the whole day. A
+// window ending before it starts runs past midnight into the next day.
+type RuleSchedule struct {
+	// Timezone is the IANA name of the timezone of the windows (default UTC)
+	Timezone string   `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	Windows  []string `json:"windows" yaml:"windows"`
+	// Exclude makes the rule active outside the windows instead of inside them
+	Exclude bool `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+}
+
+// CompiledSchedule is a parsed RuleSchedule
+type CompiledSchedule struct {
+	location *time.Location
+	windows  []scheduleWindow
+	exclude  bool
+}
+
+// scheduleWindow is a parsed window; start and end are minutes of the day
+type scheduleWindow struct {
+	days       [7]bool // Indexed by time.Weekday
+	start, end int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Compile parses the schedule
+func (s *RuleSchedule) Compile() (*CompiledSchedule, error) {
+	if len(s.Windows) == 0 {
+		return nil, fmt.Errorf("schedule must have at least one window")
+	}
+
+	compiled := &CompiledSchedule{location: time.UTC, exclude: s.Exclude}
+	if s.Timezone != "" {
+		location, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule timezone %q: %w", s.Timezone, err)
+		}
+		compiled.location = location
+	}
+
+	for _, expr := range s.Windows {
+		window, err := parseScheduleWindow(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule window %q: %w", expr, err)
+		}
+		compiled.windows = append(compiled.windows, window)
+	}
+	return compiled, nil
+}
+
+// Active reports whether a rule with the schedule is active at a time
+func (c *CompiledSchedule) Active(t time.Time) bool {
+	t = t.In(c.location)
+	day := t.Weekday()
+	minute := t.Hour()*60 + t.Minute()
+
+	inside := false
+	for i := range c.windows {
+		if c.windows[i].contains(day, minute) {
+			inside = true
+			break
+		}
+	}
+	return inside != c.exclude
+}
+
+// contains reports whether a minute of a day falls in the window
+func (w *scheduleWindow) contains(day time.Weekday, minute int) bool {
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// The window runs past midnight: its end belongs to the previous day
+	previous := (day + 6) % 7
+	return (w.days[day] && minute >= w.start) || (w.days[previous] && minute < w.end)
+}
+
+// parseScheduleWindow parses a window expression
+func parseScheduleWindow(expr string) (scheduleWindow, error) {
+	window := scheduleWindow{start: 0, end: 24 * 60}
+	fields := strings.Fields(strings.ToLower(expr))
+	if len(fields) == 0 || len(fields) > 2 {
+		return window, fmt.Errorf("expected \"[days] [HH:MM-HH:MM]\"")
+	}
+
+	daysSet := false
+	for _, field := range fields {
+		if strings.Contains(field, ":") {
+			start, end, found := strings.Cut(field, "-")
+			if !found {
+				return window, fmt.Errorf("time range must have the form HH:MM-HH:MM")
+			}
+			var err error
+			if window.start, err = parseMinuteOfDay(start); err != nil {
+				return window, err
+			}
+			if window.end, err = parseMinuteOfDay(end); err != nil {
+				return window, err
+			}
+			if window.start == window.end {
+				return window, fmt.Errorf("time range is empty")
+			}
+			continue
+		}
+
+		if daysSet {
+			return window, fmt.Errorf("days specified twice")
+		}
+		if err := parseWeekdays(field, &window.days); err != nil {
+			return window, err
+		}
+		daysSet = true
+	}
+
+	if !daysSet {
+		for i := range window.days {
+			window.days[i] = true
+		}
+	}
+	return window, nil
+}
+
+// parseWeekdays parses a comma-separated list of days and day ranges, e.g.
+// "mon-fri" or "sat,sun"; ranges may wrap around the end of the week
+func parseWeekdays(expr string, days *[7]bool) error {
+	for _, part := range strings.Split(expr, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return fmt.Errorf("unknown day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseMinuteOfDay parses HH:MM into minutes since midnight; 24:00 is the end
+// of the day
+func parseMinuteOfDay(value string) (int, error) {
+	hours, minutes, found := strings.Cut(value, ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !found || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return h*60 + m, nil
+}
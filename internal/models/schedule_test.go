@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompiledSchedule_Active(t *testing.T) {
+	// 2024-01-05 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		schedule RuleSchedule
+		time     time.Time
+		want     bool
+	}{
+		{"inside business hours", RuleSchedule{Windows: []string{"mon-fri 09:00-17:00"}}, at(5, 12, 0), true},
+		{"end is exclusive", RuleSchedule{Windows: []string{"mon-fri 09:00-17:00"}}, at(5, 17, 0), false},
+		{"weekend", RuleSchedule{Windows: []string{"mon-fri 09:00-17:00"}}, at(6, 12, 0), false},
+		{"whole days", RuleSchedule{Windows: []string{"sat,sun"}}, at(6, 23, 59), true},
+		{"overnight before midnight", RuleSchedule{Windows: []string{"fri 22:00-06:00"}}, at(5, 23, 0), true},
+		{"overnight after midnight", RuleSchedule{Windows: []string{"fri 22:00-06:00"}}, at(6, 5, 59), true},
+		{"overnight belongs to the start day", RuleSchedule{Windows: []string{"fri 22:00-06:00"}}, at(5, 5, 0), false},
+		{"day range wrapping the week", RuleSchedule{Windows: []string{"fri-mon"}}, at(7, 12, 0), true},
+		{"excluded trading hours", RuleSchedule{Windows: []string{"mon-fri 09:30-16:00"}, Exclude: true}, at(5, 10, 0), false},
+		{"outside excluded trading hours", RuleSchedule{Windows: []string{"mon-fri 09:30-16:00"}, Exclude: true}, at(5, 20, 0), true},
+		{"timezone", RuleSchedule{Timezone: "America/New_York", Windows: []string{"09:00-17:00"}}, at(5, 15, 0), true},
+		{"timezone outside", RuleSchedule{Timezone: "America/New_York", Windows: []string{"09:00-17:00"}}, at(5, 23, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := tt.schedule.Compile()
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			if got := compiled.Active(tt.time); got != tt.want {
+				t.Errorf("Active(%v) = %v, want %v", tt.time, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleSchedule_Compile_Invalid(t *testing.T) {
+	for _, schedule := range []RuleSchedule{
+		{},
+		{Windows: []string{"weekdays"}},
+		{Windows: []string{"mon-fri 9-17"}},
+		{Windows: []string{"mon 25:00-26:00"}},
+		{Windows: []string{"10:00-10:00"}},
+		{Windows: []string{"mon tue 10:00-11:00"}},
+		{Timezone: "Mars/Olympus", Windows: []string{"mon"}},
+	} {
+		if _, err := schedule.Compile(); err == nil {
+			t.Errorf("Compile(%+v) error = nil, want an error", schedule)
+		}
+	}
+}
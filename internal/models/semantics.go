@@ -0,0 +1,74 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Gauge semantics declare what the samples of an aggregated gauge represent
+// within each aggregation interval
+const (
+	// GaugeSemanticsLast is the instantaneous value at the end of the interval
+	GaugeSemanticsLast = "last"
+	// GaugeSemanticsMin is the lowest value seen during the interval
+	GaugeSemanticsMin = "min"
+	// GaugeSemanticsMax is the highest value seen during the interval
+	GaugeSemanticsMax = "max"
+	// GaugeSemanticsAvg is the average value over the interval
+	GaugeSemanticsAvg = "avg"
+)
+
+// GaugeSemanticsLabel is the label carrying the gauge semantics of an
+// aggregated series. Its value is the PromQL function that preserves them
+// when the series is queried over a range.
+const GaugeSemanticsLabel = "gauge_semantics"
+
+// gaugeSemantics maps each semantics to the range function compatible with it
+// and a description for the metric help
+var gaugeSemantics = map[string]struct {
+	function    string
+	description string
+}{
+	GaugeSemanticsLast: {"last_over_time", "value at the end"},
+	GaugeSemanticsMin:  {"min_over_time", "minimum"},
+	GaugeSemanticsMax:  {"max_over_time", "maximum"},
+	GaugeSemanticsAvg:  {"avg_over_time", "average"},
+}
+
+// GaugeSemanticsFunction returns the PromQL range function compatible with
+// the semantics, or an empty string for unknown semantics
+func GaugeSemanticsFunction(semantics string) string {
+	return gaugeSemantics[semantics].function
+}
+
+// GaugeSemanticsHelp returns the metric help describing an aggregated gauge
+// with the semantics over intervals of the given length
+func GaugeSemanticsHelp(semantics string, interval time.Duration) string {
+	s, exists := gaugeSemantics[semantics]
+	if !exists {
+		return ""
+	}
+	return fmt.Sprintf("Aggregated gauge: %s of each %s interval; query with %s", s.description, interval, s.function)
+}
+
+// validateGaugeSemantics checks that the declared semantics are known and do
+// not contradict the aggregation type
+func (r *Rule) validateGaugeSemantics() error {
+	semantics := r.Output.GaugeSemantics
+	if semantics == "" {
+		return nil
+	}
+	if _, exists := gaugeSemantics[semantics]; !exists {
+		return fmt.Errorf("invalid gauge semantics %q: must be last, min, max or avg", semantics)
+	}
+
+	switch r.Aggregation.Type {
+	case "count", "first":
+		return fmt.Errorf("gauge semantics do not apply to the %s aggregation type", r.Aggregation.Type)
+	case GaugeSemanticsLast, GaugeSemanticsMin, GaugeSemanticsMax, GaugeSemanticsAvg:
+		if semantics != r.Aggregation.Type {
+			return fmt.Errorf("gauge semantics %s contradict the %s aggregation type", semantics, r.Aggregation.Type)
+		}
+	}
+	return nil
+}
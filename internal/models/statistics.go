@@ -0,0 +1,67 @@
+package models
+
+import "fmt"
+
+// OutputStatistic is an additional statistic computed from the buckets of a
+// rule and emitted as its own metric, next to the aggregation type
+type OutputStatistic struct {
+	// Aggregation type of the statistic: sum, avg, min, max, count, last or first
+	Type string `json:"type" yaml:"type"`
+
+	// Name of the emitted metric; the output metric name with the type as a
+	// suffix (e.g. http_requests_count) when empty
+	MetricName string `json:"metric_name,omitempty" yaml:"metric_name,omitempty"`
+}
+
+// StatisticMetricName returns the name of the metric emitted for a statistic
+// of the rule
+func (r *Rule) StatisticMetricName(stat OutputStatistic) string {
+	if stat.MetricName != "" {
+		return stat.MetricName
+	}
+	return r.Output.MetricName + "_" + stat.Type
+}
+
+// OutputMetricNames returns the names of all metrics the rule writes: the
+// output metric and those of its statistics
+func (r *Rule) OutputMetricNames() []string {
+	names := make([]string, 0, 1+len(r.Output.Statistics))
+	names = append(names, r.Output.MetricName)
+	for _, stat := range r.Output.Statistics {
+		names = append(names, r.StatisticMetricName(stat))
+	}
+	return names
+}
+
+// validateStatistics checks that every statistic has a distinct type and
+// metric name and that the names follow the output naming policy
+func (r *Rule) validateStatistics() error {
+	if len(r.Output.Statistics) == 0 {
+		return nil
+	}
+	if r.Aggregation.Type == AggregationPromQL {
+		return fmt.Errorf("output statistics are not supported by the %s aggregation type", AggregationPromQL)
+	}
+
+	types := map[string]bool{r.Aggregation.Type: true}
+	names := map[string]bool{r.Output.MetricName: true}
+	for _, stat := range r.Output.Statistics {
+		if !aggregationTypes[stat.Type] || stat.Type == AggregationPromQL {
+			return fmt.Errorf("invalid output statistic type: %s", stat.Type)
+		}
+		if types[stat.Type] {
+			return fmt.Errorf("output statistic %s is computed twice", stat.Type)
+		}
+		types[stat.Type] = true
+
+		name := r.StatisticMetricName(stat)
+		if names[name] {
+			return fmt.Errorf("output statistic %s writes %s, which the rule already writes", stat.Type, name)
+		}
+		names[name] = true
+		if err := CurrentOutputNamePolicy().Check(name); err != nil {
+			return fmt.Errorf("output statistic %s: %w", stat.Type, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleTemplate is a parameterized rule definition. String fields of the
+// embedded rule may reference variables using Go template syntax, for example
+// {{.namespace}} or {{.service}}, which are substituted on instantiation.
+type RuleTemplate struct {
+	ID          string    `json:"id" yaml:"id"`
+	Name        string    `json:"name" yaml:"name"`
+	Description string    `json:"description" yaml:"description"`
+	CreatedAt   time.Time `json:"created_at" yaml:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" yaml:"updated_at"`
+
+	// Variables lists the names that must be provided when instantiating the template
+	Variables []string `json:"variables" yaml:"variables"`
+
+	// Rule is the rule body containing template placeholders
+	Rule Rule `json:"rule" yaml:"rule"`
+}
+
+// Validate checks if the template configuration is valid
+func (t *RuleTemplate) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("template name is required")
+	}
+
+	if len(t.Variables) == 0 {
+		return fmt.Errorf("at least one template variable must be declared")
+	}
+
+	seen := make(map[string]bool, len(t.Variables))
+	for _, v := range t.Variables {
+		if v == "" {
+			return fmt.Errorf("template variable name cannot be empty")
+		}
+		if seen[v] {
+			return fmt.Errorf("duplicate template variable: %s", v)
+		}
+		seen[v] = true
+	}
+
+	// Make sure every string field parses as a template
+	_, err := t.render(func(tmpl *template.Template) (string, error) {
+		return "", nil
+	})
+	return err
+}
+
+// Instantiate renders the template with the given variable values and returns
+// the resulting concrete rule. Every declared variable must have a value.
+func (t *RuleTemplate) Instantiate(values map[string]string) (*Rule, error) {
+	for _, v := range t.Variables {
+		if _, ok := values[v]; !ok {
+			return nil, fmt.Errorf("missing value for template variable: %s", v)
+		}
+	}
+
+	rule, err := t.render(func(tmpl *template.Template) (string, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, values); err != nil {
+			return "", fmt.Errorf("failed to render template: %w", err)
+		}
+		return buf.String(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rule.TemplateID = t.ID
+
+	if err := rule.Validate(); err != nil {
+		return nil, fmt.Errorf("rendered rule is invalid: %w", err)
+	}
+
+	return rule, nil
+}
+
+// render returns a copy of the template rule with every string field, and
+// map key, replaced by the result of exec on that string parsed as a
+// template. Fields are rendered separately so that values are never parsed
+// as part of the rule.
+func (t *RuleTemplate) render(exec func(*template.Template) (string, error)) (*Rule, error) {
+	body, err := yaml.Marshal(t.Rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal template rule: %w", err)
+	}
+	var rule Rule
+	if err := yaml.Unmarshal(body, &rule); err != nil {
+		return nil, fmt.Errorf("failed to copy template rule: %w", err)
+	}
+
+	renderString := func(s string) (string, error) {
+		tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid template rule: %w", err)
+		}
+		return exec(tmpl)
+	}
+	if err := renderStrings(reflect.ValueOf(&rule).Elem(), renderString); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// renderStrings replaces the strings reachable from v with their rendering
+func renderStrings(v reflect.Value, render func(string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		s, err := render(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return renderStrings(v.Elem(), render)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				if err := renderStrings(v.Field(i), render); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := renderStrings(v.Index(i), render); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		rendered := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := reflect.New(v.Type().Key()).Elem()
+			key.Set(iter.Key())
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(iter.Value())
+			if err := renderStrings(key, render); err != nil {
+				return err
+			}
+			if err := renderStrings(value, render); err != nil {
+				return err
+			}
+			rendered.SetMapIndex(key, value)
+		}
+		v.Set(rendered)
+	}
+	return nil
+}
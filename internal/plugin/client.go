@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/marcotuna/adaptive-metrics/internal/config"
@@ -143,4 +144,75 @@ func (c *Client) GetRecommendations() ([]models.Recommendation, error) {
 	}
 
 	return result.Recommendations, nil
-}
\ No newline at end of file
+}
+// UpdateRecommendationStatus reports the status of a recommendation, applied
+// or rejected, back to the Grafana plugin
+func (c *Client) UpdateRecommendationStatus(id, status string) error {
+	if !c.cfg.Enabled {
+		return nil
+	}
+
+	data, err := json.Marshal(map[string]string{
+		"status": status,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/recommendations/%s/status", c.cfg.APIURL, url.PathEscape(id)), bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.cfg.AuthToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.cfg.AuthToken))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to update recommendation status, status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// GetRules fetches the rules of the Grafana plugin
+func (c *Client) GetRules() ([]*models.Rule, error) {
+	if !c.cfg.Enabled {
+		return []*models.Rule{}, nil
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/rules", c.cfg.APIURL), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cfg.AuthToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.cfg.AuthToken))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get rules, status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Rules []*models.Rule `json:"rules"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result.Rules, nil
+}
@@ -0,0 +1,470 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// Conflict resolution policies for rules changed on both sides
+const (
+	ConflictPreferLocal  = "prefer-local"
+	ConflictPreferRemote = "prefer-remote"
+	ConflictManual       = "manual"
+)
+
+// Sides a conflict can be resolved to
+const (
+	ResolveLocal  = "local"
+	ResolveRemote = "remote"
+)
+
+// RuleStore is the local rule storage kept in sync
+type RuleStore interface {
+	GetRules() ([]*models.Rule, error)
+	SaveRule(rule *models.Rule) error
+	UpdateRule(rule *models.Rule) error
+	DeleteRule(id string) error
+}
+
+// RuleUpstream is the plugin API holding the remote rules. SyncRules
+// receives the complete set of rules the plugin should have.
+type RuleUpstream interface {
+	GetRules() ([]*models.Rule, error)
+	SyncRules(rules []*models.Rule) error
+}
+
+// RuleConflict is a rule changed both locally and remotely since the last
+// sync. A nil side means the rule was deleted there.
+type RuleConflict struct {
+	RuleID     string       `json:"rule_id"`
+	Local      *models.Rule `json:"local"`
+	Remote     *models.Rule `json:"remote"`
+	DetectedAt time.Time    `json:"detected_at"`
+}
+
+// RuleSyncStatus is the state of the rule synchronization
+type RuleSyncStatus struct {
+	Policy    string         `json:"policy"`
+	Revision  int64          `json:"revision"` // Incremented by every sync that changed either side
+	LastSync  *time.Time     `json:"last_sync,omitempty"`
+	LastError string         `json:"last_error,omitempty"`
+	Pushed    int            `json:"pushed"` // Rules sent to the plugin by the last sync
+	Pulled    int            `json:"pulled"` // Rules taken from the plugin by the last sync
+	Conflicts []RuleConflict `json:"conflicts"`
+}
+
+// ruleSyncState is the persisted state of the last sync: the revision of
+// every rule both sides agreed on
+type ruleSyncState struct {
+	Revision int64             `json:"revision"`
+	Rules    map[string]string `json:"rules"`
+}
+
+// RuleSync keeps the local rules and the rules of the Grafana plugin in sync.
+// Each side's rules are compared with the revisions of the last sync: a rule
+// changed on one side is copied to the other, and a rule changed differently
+// on both sides is a conflict resolved by the configured policy.
+type RuleSync struct {
+	cfg         config.RuleSyncConfig
+	store       RuleStore
+	upstream    RuleUpstream
+	mu          sync.Mutex // Serializes syncs
+	state       ruleSyncState
+	status      RuleSyncStatus
+	resolutions map[string]string // Manual resolutions applied by the next sync
+	stopCh      chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewRuleSync creates a rule sync, loading the state of the last sync
+func NewRuleSync(cfg config.RuleSyncConfig, store RuleStore, upstream RuleUpstream) (*RuleSync, error) {
+	switch cfg.ConflictPolicy {
+	case ConflictPreferLocal, ConflictPreferRemote, ConflictManual:
+	default:
+		return nil, fmt.Errorf("invalid rule sync conflict policy %q: must be one of %s, %s, %s",
+			cfg.ConflictPolicy, ConflictPreferLocal, ConflictPreferRemote, ConflictManual)
+	}
+	if cfg.IntervalSeconds <= 0 {
+		return nil, fmt.Errorf("rule sync interval must be greater than 0")
+	}
+
+	s := &RuleSync{
+		cfg:         cfg,
+		store:       store,
+		upstream:    upstream,
+		state:       ruleSyncState{Rules: make(map[string]string)},
+		status:      RuleSyncStatus{Policy: cfg.ConflictPolicy, Conflicts: []RuleConflict{}},
+		resolutions: make(map[string]string),
+		stopCh:      make(chan struct{}),
+	}
+	if err := s.loadState(); err != nil {
+		return nil, err
+	}
+	s.status.Revision = s.state.Revision
+
+	return s, nil
+}
+
+// Start starts syncing in the background
+func (s *RuleSync) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(s.cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			if err := s.Sync(); err != nil {
+				logger.LogErrorWithFields("Failed to sync rules with Grafana", logger.Fields{
+					"error": err.Error(),
+				})
+			}
+
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops syncing and waits for a running sync to finish
+func (s *RuleSync) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+}
+
+// Status returns the state of the synchronization
+func (s *RuleSync) Status() RuleSyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.Conflicts = append([]RuleConflict(nil), s.status.Conflicts...)
+	return status
+}
+
+// Resolve resolves a conflict to the local or remote side of the rule and
+// syncs right away
+func (s *RuleSync) Resolve(ruleID, side string) error {
+	if side != ResolveLocal && side != ResolveRemote {
+		return fmt.Errorf("invalid side %q: must be %s or %s", side, ResolveLocal, ResolveRemote)
+	}
+
+	s.mu.Lock()
+	found := false
+	for _, conflict := range s.status.Conflicts {
+		if conflict.RuleID == ruleID {
+			found = true
+			break
+		}
+	}
+	if found {
+		s.resolutions[ruleID] = side
+	}
+	s.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("no conflict for rule %s", ruleID)
+	}
+	return s.Sync()
+}
+
+// Sync runs one synchronization
+func (s *RuleSync) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pushed, pulled, err := s.sync()
+	now := time.Now()
+	s.status.LastSync = &now
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	metrics.RecordRuleSync(err == nil, len(s.status.Conflicts))
+	if err != nil {
+		return err
+	}
+
+	s.status.Pushed, s.status.Pulled = pushed, pulled
+	if pushed > 0 || pulled > 0 {
+		logger.LogInfoWithFields("Synced rules with Grafana", logger.Fields{
+			"pushed":    pushed,
+			"pulled":    pulled,
+			"conflicts": len(s.status.Conflicts),
+			"revision":  s.state.Revision,
+		})
+	}
+	return nil
+}
+
+// sync compares both sides with the last sync and reconciles them. The
+// caller must hold the lock.
+func (s *RuleSync) sync() (pushed, pulled int, err error) {
+	localRules, err := s.store.GetRules()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get local rules: %w", err)
+	}
+	remoteRules, err := s.upstream.GetRules()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get remote rules: %w", err)
+	}
+
+	local := indexRules(localRules)
+	remote := indexRules(remoteRules)
+	ids := make(map[string]bool)
+	for id := range local {
+		ids[id] = true
+	}
+	for id := range remote {
+		ids[id] = true
+	}
+	for id := range s.state.Rules {
+		ids[id] = true
+	}
+
+	previous := make(map[string]RuleConflict, len(s.status.Conflicts))
+	for _, conflict := range s.status.Conflicts {
+		previous[conflict.RuleID] = conflict
+	}
+
+	next := make(map[string]string, len(ids))
+	desired := make(map[string]*models.Rule, len(remote)) // Rules the plugin should have
+	var conflicts []RuleConflict
+	var errs []error
+
+	for id := range ids {
+		localRev, remoteRev, baseRev := ruleRevision(local[id]), ruleRevision(remote[id]), s.state.Rules[id]
+		if remote[id] != nil {
+			desired[id] = remote[id]
+		}
+
+		useLocal := false
+		switch {
+		case localRev == remoteRev:
+			// In sync, or changed the same way on both sides
+			if localRev != "" {
+				next[id] = localRev
+			}
+			continue
+		case remoteRev == baseRev:
+			useLocal = true
+		case localRev == baseRev:
+			useLocal = false
+		default:
+			resolution := s.resolutions[id]
+			switch {
+			case resolution == ResolveLocal || (resolution == "" && s.cfg.ConflictPolicy == ConflictPreferLocal):
+				useLocal = true
+			case resolution == ResolveRemote || (resolution == "" && s.cfg.ConflictPolicy == ConflictPreferRemote):
+				useLocal = false
+			default:
+				conflict := RuleConflict{RuleID: id, Local: local[id], Remote: remote[id], DetectedAt: time.Now()}
+				if existing, exists := previous[id]; exists {
+					conflict.DetectedAt = existing.DetectedAt
+				}
+				conflicts = append(conflicts, conflict)
+				if baseRev != "" {
+					next[id] = baseRev
+				}
+				continue
+			}
+		}
+
+		if useLocal {
+			if local[id] != nil {
+				desired[id] = local[id]
+			} else {
+				delete(desired, id)
+			}
+			pushed++
+			if localRev != "" {
+				next[id] = localRev
+			}
+			continue
+		}
+
+		if err := s.pull(local[id], remote[id]); err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", id, err))
+			if baseRev != "" {
+				next[id] = baseRev
+			}
+			continue
+		}
+		pulled++
+		if remoteRev != "" {
+			next[id] = remoteRev
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].RuleID < conflicts[j].RuleID })
+	s.status.Conflicts = append([]RuleConflict{}, conflicts...)
+
+	if pushed > 0 {
+		rules := make([]*models.Rule, 0, len(desired))
+		for _, rule := range desired {
+			rules = append(rules, rule)
+		}
+		sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+		if err := s.upstream.SyncRules(rules); err != nil {
+			// Local changes are pushed again by the next sync
+			return 0, pulled, fmt.Errorf("failed to push rules: %w", err)
+		}
+	}
+
+	s.resolutions = make(map[string]string)
+	if pushed > 0 || pulled > 0 {
+		s.state.Revision++
+		s.status.Revision = s.state.Revision
+	}
+	s.state.Rules = next
+	if err := s.saveState(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return pushed, pulled, fmt.Errorf("failed to apply %d rule changes, first: %w", len(errs), errs[0])
+	}
+	return pushed, pulled, nil
+}
+
+// pull applies the remote side of a rule locally; a nil rule was deleted
+func (s *RuleSync) pull(local, remote *models.Rule) error {
+	switch {
+	case remote == nil:
+		return s.store.DeleteRule(local.ID)
+	case local == nil:
+		rule := *remote
+		return s.store.SaveRule(&rule)
+	default:
+		rule := *remote
+		return s.store.UpdateRule(&rule)
+	}
+}
+
+// loadState reads the state of the last sync, if any
+func (s *RuleSync) loadState() error {
+	if s.cfg.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.cfg.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read rule sync state: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return fmt.Errorf("failed to parse rule sync state: %w", err)
+	}
+	if s.state.Rules == nil {
+		s.state.Rules = make(map[string]string)
+	}
+	return nil
+}
+
+// saveState persists the state of the last sync
+func (s *RuleSync) saveState() error {
+	if s.cfg.StateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.cfg.StateFile), 0755); err != nil {
+		return fmt.Errorf("failed to create rule sync state directory: %w", err)
+	}
+	return os.WriteFile(s.cfg.StateFile, data, 0644)
+}
+
+// indexRules maps rules by ID
+func indexRules(rules []*models.Rule) map[string]*models.Rule {
+	index := make(map[string]*models.Rule, len(rules))
+	for _, rule := range rules {
+		if rule != nil && rule.ID != "" {
+			index[rule.ID] = rule
+		}
+	}
+	return index
+}
+
+// ruleRevision returns a content hash of a rule, or an empty string for a
+// missing rule. Timestamps and authors are ignored, and empty fields are
+// dropped so both sides hash a rule the same regardless of how they
+// serialize empty values.
+func ruleRevision(rule *models.Rule) string {
+	if rule == nil {
+		return ""
+	}
+
+	copied := *rule
+	copied.CreatedAt, copied.UpdatedAt, copied.UpdatedBy = time.Time{}, time.Time{}, ""
+	data, err := json.Marshal(copied)
+	if err != nil {
+		return ""
+	}
+	var fields interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ""
+	}
+	normalized, _ := json.Marshal(dropEmpty(fields))
+
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:8])
+}
+
+// dropEmpty removes null, zero and empty values from decoded JSON
+func dropEmpty(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			field = dropEmpty(field)
+			if field == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = field
+		}
+		if len(v) == 0 {
+			return nil
+		}
+		return v
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		for i := range v {
+			v[i] = dropEmpty(v[i])
+		}
+		return v
+	case string:
+		if v == "" {
+			return nil
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	}
+	return value
+}
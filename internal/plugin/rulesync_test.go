@@ -0,0 +1,216 @@
+package plugin
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+type ruleMap map[string]*models.Rule
+
+func (m ruleMap) list() []*models.Rule {
+	rules := make([]*models.Rule, 0, len(m))
+	for _, rule := range m {
+		copied := *rule
+		rules = append(rules, &copied)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+type memoryRuleStore struct{ rules ruleMap }
+
+func (s *memoryRuleStore) GetRules() ([]*models.Rule, error) { return s.rules.list(), nil }
+
+func (s *memoryRuleStore) SaveRule(rule *models.Rule) error {
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+func (s *memoryRuleStore) UpdateRule(rule *models.Rule) error {
+	if _, exists := s.rules[rule.ID]; !exists {
+		return fmt.Errorf("rule %s does not exist", rule.ID)
+	}
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+func (s *memoryRuleStore) DeleteRule(id string) error {
+	delete(s.rules, id)
+	return nil
+}
+
+type fakeRuleUpstream struct {
+	rules  ruleMap
+	pushes int
+}
+
+func (u *fakeRuleUpstream) GetRules() ([]*models.Rule, error) { return u.rules.list(), nil }
+
+func (u *fakeRuleUpstream) SyncRules(rules []*models.Rule) error {
+	u.pushes++
+	u.rules = make(ruleMap)
+	for _, rule := range rules {
+		copied := *rule
+		u.rules[rule.ID] = &copied
+	}
+	return nil
+}
+
+func testRule(id, description string) *models.Rule {
+	return &models.Rule{ID: id, Name: id, Description: description, Enabled: true}
+}
+
+func newTestRuleSync(t *testing.T, policy string, local, remote ruleMap) (*RuleSync, *memoryRuleStore, *fakeRuleUpstream) {
+	t.Helper()
+	store := &memoryRuleStore{rules: local}
+	upstream := &fakeRuleUpstream{rules: remote}
+	s, err := NewRuleSync(config.RuleSyncConfig{
+		IntervalSeconds: 60,
+		ConflictPolicy:  policy,
+		StateFile:       filepath.Join(t.TempDir(), "state.json"),
+	}, store, upstream)
+	if err != nil {
+		t.Fatalf("NewRuleSync: %v", err)
+	}
+	return s, store, upstream
+}
+
+func TestRuleSync_PropagatesOneSidedChanges(t *testing.T) {
+	s, store, upstream := newTestRuleSync(t, ConflictManual,
+		ruleMap{"a": testRule("a", "v1"), "local-only": testRule("local-only", "")},
+		ruleMap{"a": testRule("a", "v1"), "remote-only": testRule("remote-only", "")},
+	)
+
+	// The first sync has no base, so rules present on one side only are
+	// treated as created there
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if store.rules["remote-only"] == nil || upstream.rules["local-only"] == nil {
+		t.Fatalf("expected both sides to have all rules, local %v remote %v", store.rules, upstream.rules)
+	}
+
+	// A local edit is pushed, a remote deletion is pulled
+	store.rules["a"] = testRule("a", "v2")
+	delete(upstream.rules, "remote-only")
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := upstream.rules["a"].Description; got != "v2" {
+		t.Errorf("expected remote rule a to be v2, got %s", got)
+	}
+	if _, exists := store.rules["remote-only"]; exists {
+		t.Error("expected remote deletion to be applied locally")
+	}
+
+	// Nothing changed, so nothing is pushed
+	pushes := upstream.pushes
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if upstream.pushes != pushes {
+		t.Errorf("expected no push when in sync, got %d", upstream.pushes-pushes)
+	}
+	if status := s.Status(); status.Pushed != 0 || status.Pulled != 0 || status.Revision != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestRuleSync_Conflicts(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{ConflictPreferLocal, "local"},
+		{ConflictPreferRemote, "remote"},
+		{ConflictManual, "base"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			s, store, upstream := newTestRuleSync(t, tt.policy,
+				ruleMap{"a": testRule("a", "base")},
+				ruleMap{"a": testRule("a", "base")},
+			)
+			if err := s.Sync(); err != nil {
+				t.Fatalf("Sync: %v", err)
+			}
+
+			store.rules["a"] = testRule("a", "local")
+			upstream.rules["a"] = testRule("a", "remote")
+			if err := s.Sync(); err != nil {
+				t.Fatalf("Sync: %v", err)
+			}
+
+			if tt.policy != ConflictManual {
+				if store.rules["a"].Description != tt.want || upstream.rules["a"].Description != tt.want {
+					t.Errorf("expected both sides to be %s, got local %s remote %s",
+						tt.want, store.rules["a"].Description, upstream.rules["a"].Description)
+				}
+				if conflicts := s.Status().Conflicts; len(conflicts) != 0 {
+					t.Errorf("expected no conflicts, got %v", conflicts)
+				}
+				return
+			}
+
+			// Manual conflicts leave both sides untouched until resolved
+			conflicts := s.Status().Conflicts
+			if len(conflicts) != 1 || conflicts[0].RuleID != "a" {
+				t.Fatalf("expected a conflict for rule a, got %v", conflicts)
+			}
+			if store.rules["a"].Description != "local" || upstream.rules["a"].Description != "remote" {
+				t.Error("expected an unresolved conflict to change neither side")
+			}
+
+			if err := s.Resolve("a", ResolveRemote); err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if store.rules["a"].Description != "remote" {
+				t.Errorf("expected local rule to be resolved to remote, got %s", store.rules["a"].Description)
+			}
+			if conflicts := s.Status().Conflicts; len(conflicts) != 0 {
+				t.Errorf("expected the conflict to be resolved, got %v", conflicts)
+			}
+			if err := s.Resolve("a", ResolveLocal); err == nil {
+				t.Error("expected resolving a rule without a conflict to fail")
+			}
+		})
+	}
+}
+
+func TestRuleSync_PersistsState(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	cfg := config.RuleSyncConfig{IntervalSeconds: 60, ConflictPolicy: ConflictManual, StateFile: stateFile}
+	store := &memoryRuleStore{rules: ruleMap{"a": testRule("a", "v1")}}
+	upstream := &fakeRuleUpstream{rules: ruleMap{}}
+
+	s, err := NewRuleSync(cfg, store, upstream)
+	if err != nil {
+		t.Fatalf("NewRuleSync: %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// After a restart, a rule deleted locally is recognized as a deletion
+	// rather than a remote creation
+	delete(store.rules, "a")
+	s, err = NewRuleSync(cfg, store, upstream)
+	if err != nil {
+		t.Fatalf("NewRuleSync: %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if _, exists := upstream.rules["a"]; exists {
+		t.Error("expected the local deletion to be pushed")
+	}
+	if _, exists := store.rules["a"]; exists {
+		t.Error("expected the deleted rule not to be restored locally")
+	}
+}
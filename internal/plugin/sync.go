@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/types"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// importedIDPrefix prefixes the local IDs of imported recommendations, so they
+// never collide with locally generated ones
+const importedIDPrefix = "grafana-"
+
+// Upstream is the Grafana API recommendations are synced with
+type Upstream interface {
+	GetRecommendations() ([]models.Recommendation, error)
+	UpdateRecommendationStatus(id, status string) error
+}
+
+// RecommendationSync periodically imports recommendations from Grafana into
+// the local store and reports local decisions on them back
+type RecommendationSync struct {
+	upstream Upstream
+	store    types.RecommendationStore
+	interval time.Duration
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewRecommendationSync creates a recommendation sync job
+func NewRecommendationSync(upstream Upstream, store types.RecommendationStore, interval time.Duration) *RecommendationSync {
+	return &RecommendationSync{
+		upstream: upstream,
+		store:    store,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start starts syncing in the background
+func (s *RecommendationSync) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.Sync()
+
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops syncing and waits for a running sync to finish
+func (s *RecommendationSync) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+}
+
+// Sync reports applied and rejected imported recommendations upstream, then
+// imports the current upstream recommendations. Recommendations decided
+// locally keep their status; pending ones follow upstream, and are removed
+// when upstream withdraws them.
+func (s *RecommendationSync) Sync() {
+	reported := s.reportStatuses()
+
+	upstream, err := s.upstream.GetRecommendations()
+	if err != nil {
+		metrics.RecordPluginSync(false)
+		logger.LogErrorWithFields("Failed to fetch recommendations from Grafana", logger.Fields{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	local := make(map[string]models.Recommendation)
+	for _, rec := range s.store.GetAllRecommendations() {
+		if rec.Source == models.RecommendationSourceGrafana {
+			local[rec.ID] = rec
+		}
+	}
+
+	imported, updated := 0, 0
+	seen := make(map[string]bool, len(upstream))
+	for _, rec := range upstream {
+		if rec.ID == "" {
+			continue
+		}
+		id := importedIDPrefix + rec.ID
+		seen[id] = true
+
+		existing, exists := local[id]
+		if exists && existing.Status != "pending" {
+			continue // Decided locally
+		}
+		if rec.Status == "" {
+			rec.Status = "pending"
+		}
+		if rec.CreatedAt.IsZero() {
+			rec.CreatedAt = time.Now()
+		}
+		rec.UpstreamID = rec.ID
+		rec.UpstreamStatus = rec.Status
+		rec.ID = id
+		rec.Source = models.RecommendationSourceGrafana
+
+		if exists {
+			rec.CreatedAt = existing.CreatedAt
+			s.store.UpdateRecommendation(rec)
+			updated++
+			continue
+		}
+		s.store.AddRecommendation(rec)
+		imported++
+	}
+
+	removed := 0
+	for id, rec := range local {
+		if !seen[id] && rec.Status == "pending" {
+			s.store.DeleteRecommendation(id)
+			removed++
+		}
+	}
+
+	metrics.RecordPluginSync(true)
+	logger.LogDebugWithFields("Synced recommendations with Grafana", logger.Fields{
+		"imported": imported,
+		"updated":  updated,
+		"removed":  removed,
+		"reported": reported,
+	})
+}
+
+// reportStatuses reports imported recommendations applied or rejected locally
+// that upstream has not acknowledged yet. It returns the number reported.
+func (s *RecommendationSync) reportStatuses() int {
+	reported := 0
+	for _, rec := range s.store.GetAllRecommendations() {
+		if rec.Source != models.RecommendationSourceGrafana || rec.Status == "pending" || rec.Status == rec.UpstreamStatus {
+			continue
+		}
+
+		if err := s.upstream.UpdateRecommendationStatus(rec.UpstreamID, rec.Status); err != nil {
+			logger.LogWarnWithFields("Failed to report recommendation status to Grafana", logger.Fields{
+				"recommendation_id": rec.ID,
+				"status":            rec.Status,
+				"error":             err.Error(),
+			})
+			continue
+		}
+		rec.UpstreamStatus = rec.Status
+		s.store.UpdateRecommendation(rec)
+		reported++
+	}
+	return reported
+}
@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+type memoryStore struct {
+	recs map[string]models.Recommendation
+}
+
+func (s *memoryStore) AddRecommendation(rec models.Recommendation) { s.recs[rec.ID] = rec }
+
+func (s *memoryStore) GetAllRecommendations() []models.Recommendation {
+	recs := make([]models.Recommendation, 0, len(s.recs))
+	for _, rec := range s.recs {
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func (s *memoryStore) UpdateRecommendation(rec models.Recommendation) bool {
+	if _, exists := s.recs[rec.ID]; !exists {
+		return false
+	}
+	s.recs[rec.ID] = rec
+	return true
+}
+
+func (s *memoryStore) DeleteRecommendation(id string) bool {
+	_, exists := s.recs[id]
+	delete(s.recs, id)
+	return exists
+}
+
+type fakeUpstream struct {
+	recs     []models.Recommendation
+	statuses map[string]string
+}
+
+func (u *fakeUpstream) GetRecommendations() ([]models.Recommendation, error) {
+	return u.recs, nil
+}
+
+func (u *fakeUpstream) UpdateRecommendationStatus(id, status string) error {
+	u.statuses[id] = status
+	return nil
+}
+
+func TestRecommendationSync_Sync(t *testing.T) {
+	store := &memoryStore{recs: map[string]models.Recommendation{
+		"local": {ID: "local", Source: "usage_analysis", Status: "pending"},
+	}}
+	upstream := &fakeUpstream{
+		recs: []models.Recommendation{
+			{ID: "a", Confidence: 0.9},
+			{ID: "b", Confidence: 0.5},
+		},
+		statuses: make(map[string]string),
+	}
+	sync := NewRecommendationSync(upstream, store, 0)
+
+	sync.Sync()
+	imported, exists := store.recs["grafana-a"]
+	if !exists || imported.Source != models.RecommendationSourceGrafana || imported.Status != "pending" || imported.UpstreamID != "a" {
+		t.Fatalf("imported recommendation = %+v, want a pending grafana recommendation", imported)
+	}
+	if len(store.recs) != 3 {
+		t.Fatalf("store holds %d recommendations, want 3", len(store.recs))
+	}
+
+	// a is applied locally, b is withdrawn upstream and a is refreshed
+	imported.Status = "applied"
+	store.recs["grafana-a"] = imported
+	upstream.recs = []models.Recommendation{{ID: "a", Confidence: 0.1}}
+
+	sync.Sync()
+	if upstream.statuses["a"] != "applied" {
+		t.Errorf("upstream statuses = %v, want a reported as applied", upstream.statuses)
+	}
+	if rec := store.recs["grafana-a"]; rec.Status != "applied" || rec.UpstreamStatus != "applied" || rec.Confidence != 0.9 {
+		t.Errorf("applied recommendation = %+v, want the local decision kept and acknowledged", rec)
+	}
+	if _, exists := store.recs["grafana-b"]; exists {
+		t.Error("pending recommendation withdrawn upstream was kept")
+	}
+	if _, exists := store.recs["local"]; !exists {
+		t.Error("local recommendation was removed")
+	}
+
+	// Acknowledged statuses are not reported again
+	delete(upstream.statuses, "a")
+	sync.Sync()
+	if len(upstream.statuses) != 0 {
+		t.Errorf("statuses reported again: %v", upstream.statuses)
+	}
+}
@@ -0,0 +1,149 @@
+// Package profiling exposes the Go runtime profiles of the service and
+// optionally pushes them to a continuous profiling server.
+package profiling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// Settings are the sampling rates of the mutex and block profiles, which
+// are off unless set since they add overhead to every contended lock and
+// blocking operation
+type Settings struct {
+	// MutexProfileFraction reports 1 in N mutex contention events (0 disables)
+	MutexProfileFraction int `json:"mutex_profile_fraction"`
+	// BlockProfileRate samples one blocking event per N nanoseconds spent
+	// blocked (0 disables)
+	BlockProfileRate int `json:"block_profile_rate"`
+}
+
+var (
+	// The runtime has no getter for the block profile rate
+	settingsMu sync.Mutex
+	settings   Settings
+)
+
+// CurrentSettings returns the sampling rates of the mutex and block profiles
+func CurrentSettings() Settings {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	return settings
+}
+
+// Apply sets the sampling rates of the mutex and block profiles
+func Apply(s Settings) error {
+	if s.MutexProfileFraction < 0 || s.BlockProfileRate < 0 {
+		return fmt.Errorf("profile rates must not be negative")
+	}
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	runtime.SetMutexProfileFraction(s.MutexProfileFraction)
+	runtime.SetBlockProfileRate(s.BlockProfileRate)
+	settings = s
+	return nil
+}
+
+// Handler serves the pprof endpoints under /debug/pprof/, and the mutex and
+// block profile rates at /debug/pprof/settings
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/pprof/settings", serveSettings)
+	return mux
+}
+
+// serveSettings returns the profile rates, or changes them on PUT
+func serveSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		s := CurrentSettings()
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := Apply(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.LogInfoWithFields("Changed profile rates", logger.Fields{
+			"mutex_profile_fraction": s.MutexProfileFraction,
+			"block_profile_rate":     s.BlockProfileRate,
+		})
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CurrentSettings())
+}
+
+// Start applies the profile rates and starts the profiling listener and the
+// push of profiles, as configured. When no address is set, the pprof
+// endpoints are left to be served by the main router. The returned function
+// stops both and must be called on shutdown.
+func Start(cfg config.ProfilingConfig) (func(context.Context) error, error) {
+	if err := Apply(Settings{
+		MutexProfileFraction: cfg.MutexProfileFraction,
+		BlockProfileRate:     cfg.BlockProfileRate,
+	}); err != nil {
+		return nil, err
+	}
+
+	var pusher *Pusher
+	if cfg.Push.Enabled {
+		var err error
+		pusher, err = NewPusher(cfg.Push)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize profile push: %w", err)
+		}
+		pusher.Start()
+	}
+
+	var server *http.Server
+	if cfg.Enabled && cfg.Address != "" {
+		server = &http.Server{
+			Addr:              cfg.Address,
+			Handler:           Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.LogErrorWithFields("Profiling listener failed", logger.Fields{
+					"address": cfg.Address,
+					"error":   err.Error(),
+				})
+			}
+		}()
+		logger.LogInfoWithFields("Serving profiles", logger.Fields{
+			"address": cfg.Address,
+		})
+	}
+
+	return func(ctx context.Context) error {
+		if pusher != nil {
+			pusher.Stop()
+		}
+		if server != nil {
+			return server.Shutdown(ctx)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,122 @@
+package profiling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+)
+
+func TestHandler_Settings(t *testing.T) {
+	defer Apply(Settings{})
+	handler := Handler()
+
+	req := httptest.NewRequest(http.MethodPut, "/debug/pprof/settings", strings.NewReader(`{"mutex_profile_fraction": 5}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT settings returned %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := CurrentSettings(); got.MutexProfileFraction != 5 || got.BlockProfileRate != 0 {
+		t.Errorf("CurrentSettings() = %+v, want mutex fraction 5", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/debug/pprof/settings", strings.NewReader(`{"block_profile_rate": -1}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT negative rate returned %d, want 400", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/pprof/heap", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("GET heap profile returned %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestApplicationName(t *testing.T) {
+	if got := applicationName("app", nil); got != "app" {
+		t.Errorf("applicationName() = %q, want app", got)
+	}
+	got := applicationName("app", map[string]string{"region": "eu", "env": "prod"})
+	if got != "app{env=prod,region=eu}" {
+		t.Errorf("applicationName() = %q, want app{env=prod,region=eu}", got)
+	}
+}
+
+func TestPusher(t *testing.T) {
+	var mu sync.Mutex
+	pushed := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ingest" || r.URL.Query().Get("format") != "pprof" {
+			t.Errorf("Unexpected push %s", r.URL)
+		}
+		if r.Header.Get("X-Scope-OrgID") != "team-a" {
+			t.Errorf("Missing push header")
+		}
+		file, _, err := r.FormFile("profile")
+		if err != nil {
+			t.Errorf("Push without profile: %v", err)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		if len(data) == 0 {
+			t.Errorf("Empty profile pushed for %s", r.URL.Query().Get("name"))
+		}
+		mu.Lock()
+		pushed[r.URL.Query().Get("name")]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	pusher, err := NewPusher(config.ProfilePushConfig{
+		Enabled:         true,
+		URL:             server.URL,
+		ApplicationName: "adaptive-metrics",
+		IntervalSeconds: 1,
+		Profiles:        []string{ProfileCPU, ProfileHeap},
+		Tags:            map[string]string{"env": "test"},
+		Headers:         map[string]string{"X-Scope-OrgID": "team-a"},
+	})
+	if err != nil {
+		t.Fatalf("NewPusher() error = %v", err)
+	}
+	pusher.Start()
+	time.Sleep(100 * time.Millisecond)
+	// Stopping pushes the profiles of the interval cut short
+	pusher.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range []string{"adaptive-metrics.cpu{env=test}", "adaptive-metrics.heap{env=test}"} {
+		if pushed[name] == 0 {
+			t.Errorf("No profile pushed as %s, got %v", name, pushed)
+		}
+	}
+}
+
+func TestNewPusher_UnknownProfile(t *testing.T) {
+	_, err := NewPusher(config.ProfilePushConfig{URL: "http://localhost:4040", Profiles: []string{"threads"}})
+	if err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+func TestStart_Listener(t *testing.T) {
+	stop, err := Start(config.ProfilingConfig{Enabled: true, Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := stop(context.Background()); err != nil {
+		t.Errorf("stop() error = %v", err)
+	}
+}
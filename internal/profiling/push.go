@@ -0,0 +1,240 @@
+package profiling
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	pkgmetrics "github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// Profiles that can be pushed
+const (
+	ProfileCPU       = "cpu"
+	ProfileHeap      = "heap"
+	ProfileMutex     = "mutex"
+	ProfileBlock     = "block"
+	ProfileGoroutine = "goroutine"
+)
+
+// cpuSampleRate is the rate, in Hz, at which the Go runtime samples CPU profiles
+const cpuSampleRate = 100
+
+// Pusher collects profiles periodically and pushes them to a Pyroscope
+// compatible ingest endpoint
+type Pusher struct {
+	cfg    config.ProfilePushConfig
+	client *http.Client
+	// name is the application name with its tags, as Pyroscope expects it
+	name     string
+	interval time.Duration
+	stopCh   chan struct{}
+	done     chan struct{}
+}
+
+// NewPusher creates a pusher from the push configuration
+func NewPusher(cfg config.ProfilePushConfig) (*Pusher, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("push URL is required")
+	}
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid push URL: %w", err)
+	}
+	for _, profile := range cfg.Profiles {
+		switch profile {
+		case ProfileCPU, ProfileHeap, ProfileMutex, ProfileBlock, ProfileGoroutine:
+		default:
+			return nil, fmt.Errorf("unknown profile %q", profile)
+		}
+	}
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	return &Pusher{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: interval},
+		name:     applicationName(cfg.ApplicationName, cfg.Tags),
+		interval: interval,
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// applicationName formats an application name with tags, e.g.
+// "adaptive-metrics{env=prod,region=eu}"
+func applicationName(app string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return app
+	}
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return app + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Start starts pushing profiles every interval
+func (p *Pusher) Start() {
+	go p.run()
+}
+
+// Stop stops pushing profiles, after pushing those of the current interval
+func (p *Pusher) Stop() {
+	close(p.stopCh)
+	<-p.done
+}
+
+func (p *Pusher) run() {
+	defer close(p.done)
+	for {
+		start := time.Now()
+		cpu, cpuErr := p.collectCPU()
+		end := time.Now()
+
+		if cpuErr != nil {
+			// The CPU profile is already being collected, e.g. through
+			// /debug/pprof/profile
+			logger.LogDebugWithFields("Skipped CPU profile push", logger.Fields{
+				"error": cpuErr.Error(),
+			})
+		} else if cpu != nil {
+			p.push(ProfileCPU, cpu, start, end)
+		}
+		for _, profile := range p.cfg.Profiles {
+			if profile == ProfileCPU {
+				continue
+			}
+			var buf bytes.Buffer
+			if err := pprof.Lookup(profile).WriteTo(&buf, 0); err != nil {
+				pkgmetrics.RecordProfilePush(profile, err)
+				continue
+			}
+			p.push(profile, buf.Bytes(), start, end)
+		}
+
+		select {
+		case <-p.stopCh:
+			return
+		default:
+		}
+	}
+}
+
+// collectCPU profiles the CPU for an interval, or until stopped. Without the
+// CPU profile, it only waits for the interval.
+func (p *Pusher) collectCPU() ([]byte, error) {
+	timer := time.NewTimer(p.interval)
+	defer timer.Stop()
+
+	if !p.pushes(ProfileCPU) {
+		select {
+		case <-timer.C:
+		case <-p.stopCh:
+		}
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		select {
+		case <-timer.C:
+		case <-p.stopCh:
+		}
+		return nil, err
+	}
+	select {
+	case <-timer.C:
+	case <-p.stopCh:
+	}
+	pprof.StopCPUProfile()
+	return buf.Bytes(), nil
+}
+
+// pushes reports whether a profile is pushed
+func (p *Pusher) pushes(profile string) bool {
+	for _, pushed := range p.cfg.Profiles {
+		if pushed == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// push uploads a profile in pprof format covering a time range
+func (p *Pusher) push(profile string, data []byte, from, until time.Time) {
+	err := p.upload(profile, data, from, until)
+	pkgmetrics.RecordProfilePush(profile, err)
+	if err != nil {
+		logger.LogWarnWithFields("Failed to push profile", logger.Fields{
+			"profile": profile,
+			"url":     p.cfg.URL,
+			"error":   err.Error(),
+		})
+	}
+}
+
+func (p *Pusher) upload(profile string, data []byte, from, until time.Time) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	// The profile type is the suffix of the application name, e.g. app.cpu
+	name := p.name
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		name = name[:i] + "." + profile + name[i:]
+	} else {
+		name += "." + profile
+	}
+	query := url.Values{
+		"name":    {name},
+		"from":    {strconv.FormatInt(from.Unix(), 10)},
+		"until":   {strconv.FormatInt(until.Unix(), 10)},
+		"format":  {"pprof"},
+		"spyName": {"gospy"},
+	}
+	if profile == ProfileCPU {
+		query.Set("sampleRate", strconv.Itoa(cpuSampleRate))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(p.cfg.URL, "/")+"/ingest?"+query.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	for key, value := range p.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package rules
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// catalogFiles holds the built-in catalog, one file of entries per exporter
+//
+//go:embed catalog/*.yaml
+var catalogFiles embed.FS
+
+var (
+	catalogOnce    sync.Once
+	catalogEntries []*models.CatalogEntry
+	catalogErr     error
+)
+
+// Catalog returns the entries of the built-in catalog of ready-made rules,
+// ordered by exporter and ID
+func Catalog() ([]*models.CatalogEntry, error) {
+	catalogOnce.Do(func() {
+		catalogEntries, catalogErr = loadCatalog(catalogFiles)
+	})
+	return catalogEntries, catalogErr
+}
+
+// loadCatalog parses the catalog files of fsys
+func loadCatalog(fsys fs.FS) ([]*models.CatalogEntry, error) {
+	files, err := fs.Glob(fsys, "catalog/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*models.CatalogEntry
+	seen := make(map[string]bool)
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read catalog file %s: %w", file, err)
+		}
+		var fileEntries []*models.CatalogEntry
+		if err := yaml.Unmarshal(data, &fileEntries); err != nil {
+			return nil, fmt.Errorf("failed to parse catalog file %s: %w", file, err)
+		}
+		for _, entry := range fileEntries {
+			if seen[entry.ID] {
+				return nil, fmt.Errorf("duplicate catalog entry %s in %s", entry.ID, file)
+			}
+			seen[entry.ID] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Exporter != entries[j].Exporter {
+			return entries[i].Exporter < entries[j].Exporter
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// CatalogEntry returns the catalog entry with the given ID
+func CatalogEntry(id string) (*models.CatalogEntry, error) {
+	entries, err := Catalog()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("catalog entry %s does not exist", id)
+}
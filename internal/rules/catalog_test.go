@@ -0,0 +1,79 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestCatalog_EntriesInstall(t *testing.T) {
+	entries, err := Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exporters := make(map[string]bool)
+	for _, entry := range entries {
+		exporters[entry.Exporter] = true
+
+		rule, err := entry.Install(models.CatalogInstall{}, "")
+		if err != nil {
+			t.Errorf("%s: %v", entry.ID, err)
+			continue
+		}
+		if rule.Source != "catalog:"+entry.ID || rule.ID != "" {
+			t.Errorf("%s: source = %q, id = %q", entry.ID, rule.Source, rule.ID)
+		}
+		if warnings := Lint(rule, LintOptions{DeadMansSwitch: true}); len(warnings) > 0 {
+			t.Errorf("%s: lint warnings %+v", entry.ID, warnings)
+		}
+	}
+
+	for _, exporter := range []string{"kube-state-metrics", "node_exporter", "nginx-ingress", "istio"} {
+		if !exporters[exporter] {
+			t.Errorf("no catalog entries for %s", exporter)
+		}
+	}
+}
+
+func TestCatalog_InstallCustomizes(t *testing.T) {
+	entry, err := CatalogEntry("istio-requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := entry.Install(models.CatalogInstall{
+		ID:               "payments-requests",
+		Values:           map[string]string{"reporter": "source"},
+		Namespace:        "payments",
+		Labels:           map[string]string{"cluster": "eu-1"},
+		AdditionalLabels: map[string]string{"env": "prod"},
+	}, "kubernetes_namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"reporter": "source", "kubernetes_namespace": "payments", "cluster": "eu-1"}
+	for label, value := range want {
+		if rule.Matcher.Labels[label] != value {
+			t.Errorf("matcher label %s = %q, want %q", label, rule.Matcher.Labels[label], value)
+		}
+	}
+	if rule.ID != "payments-requests" || rule.Namespace != "payments" || rule.Output.AdditionalLabels["env"] != "prod" {
+		t.Errorf("rule = %+v", rule)
+	}
+	// Installing does not modify the entry
+	if entry.Rule.Matcher.Labels["reporter"] != "{{.reporter}}" || len(entry.Rule.Matcher.Labels) != 1 {
+		t.Errorf("entry matcher labels = %v", entry.Rule.Matcher.Labels)
+	}
+
+	if _, err := entry.Install(models.CatalogInstall{Values: map[string]string{"unknown": "x"}}, ""); err == nil {
+		t.Error("expected error for undeclared variable")
+	}
+	if _, err := entry.Install(models.CatalogInstall{ID: "a/b"}, ""); err == nil {
+		t.Error("expected error for invalid ID")
+	}
+	if _, err := CatalogEntry("missing"); err == nil {
+		t.Error("expected error for missing entry")
+	}
+}
@@ -0,0 +1,183 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// CatchAllSource is the source of the rules generated by the catch-all rule
+const CatchAllSource = "catch_all"
+
+// catchAllRulePrefix starts the IDs of the rules generated by the catch-all rule
+const catchAllRulePrefix = "catch-all:"
+
+// catchAllRecheck is how long whether a metric is caught is kept before its
+// cardinality is checked again
+const catchAllRecheck = time.Minute
+
+// CardinalitySource reports the number of series of a metric, such as the
+// usage tracker
+type CardinalitySource interface {
+	SeriesCardinality(name string) int
+}
+
+// catchAll is the built-in rule that aggregates the metrics no other rule
+// matches, once they have enough series. A rule is generated for each caught
+// metric, so that each is aggregated into its own output metric.
+type catchAll struct {
+	cfg     config.CatchAllConfig
+	mu      sync.RWMutex
+	source  CardinalitySource
+	metrics map[string]*caughtMetric
+}
+
+// caughtMetric is whether a metric is caught, as of when it was checked
+type caughtMetric struct {
+	rule      *models.Rule // nil when the metric is not caught
+	checkedAt time.Time
+}
+
+// SetCardinalitySource sets where the catch-all rule gets the cardinality of
+// metrics from; without one no metric is caught
+func (e *Engine) SetCardinalitySource(source CardinalitySource) {
+	e.catchAll.mu.Lock()
+	e.catchAll.source = source
+	e.catchAll.metrics = nil
+	e.catchAll.mu.Unlock()
+}
+
+// CatchAllRules returns the rules generated for the metrics currently caught
+// by the catch-all rule, by metric name
+func (e *Engine) CatchAllRules() []*models.Rule {
+	e.catchAll.mu.RLock()
+	defer e.catchAll.mu.RUnlock()
+
+	var rules []*models.Rule
+	for _, caught := range e.catchAll.metrics {
+		if caught.rule != nil {
+			rules = append(rules, caught.rule)
+		}
+	}
+	sortRules(rules, nil)
+	return rules
+}
+
+// validate checks that the catch-all configuration generates valid rules
+func (c *catchAll) validate() error {
+	if !c.cfg.Enabled {
+		return nil
+	}
+	if err := c.rule("metric", nil).Validate(); err != nil {
+		return fmt.Errorf("invalid catch-all rule: %w", err)
+	}
+	return nil
+}
+
+// match returns the rule aggregating a sample no other rule matched, or nil
+// if its metric is not caught
+func (c *catchAll) match(e *Engine, sample *models.MetricSample, now time.Time) *models.Rule {
+	if !c.cfg.Enabled {
+		return nil
+	}
+	// Quantiles of summaries cannot be combined across series
+	if _, exists := sample.Labels["quantile"]; exists {
+		return nil
+	}
+
+	c.mu.RLock()
+	caught, exists := c.metrics[sample.Name]
+	c.mu.RUnlock()
+	if exists && now.Sub(caught.checkedAt) < catchAllRecheck {
+		return caught.rule
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another worker may have checked the metric meanwhile
+	caught, exists = c.metrics[sample.Name]
+	if exists && now.Sub(caught.checkedAt) < catchAllRecheck {
+		return caught.rule
+	}
+	if c.source == nil {
+		return nil
+	}
+
+	var previous *models.Rule
+	if exists {
+		previous = caught.rule
+	}
+	caught = &caughtMetric{checkedAt: now}
+	cardinality := c.source.SeriesCardinality(sample.Name)
+	if cardinality >= c.cfg.MinCardinality && !e.IsProtectedMetric(sample.Name) {
+		caught.rule = previous
+		if caught.rule == nil {
+			caught.rule = c.rule(sample.Name, e.ProtectedLabels())
+			logger.LogInfoWithFields("Metric caught by the catch-all rule", logger.Fields{
+				"metric":      sample.Name,
+				"cardinality": cardinality,
+				"output":      caught.rule.Output.MetricName,
+			})
+		}
+	}
+	if c.metrics == nil {
+		c.metrics = make(map[string]*caughtMetric)
+	}
+	c.metrics[sample.Name] = caught
+	return caught.rule
+}
+
+// forwardedRule returns the rule aggregating a metric a peer caught,
+// generating it unless the metric is caught locally too
+func (c *catchAll) forwardedRule(e *Engine, name string) *models.Rule {
+	c.mu.RLock()
+	caught, exists := c.metrics[name]
+	c.mu.RUnlock()
+	if exists && caught.rule != nil {
+		return caught.rule
+	}
+	return c.rule(name, e.ProtectedLabels())
+}
+
+// rule generates the rule aggregating a metric. It keeps the configured
+// segmentation labels, the protected labels, and the bucket bounds of
+// histograms.
+func (c *catchAll) rule(name string, protected []string) *models.Rule {
+	labels := append([]string(nil), c.cfg.Segmentation...)
+	labels = append(labels, protected...)
+	if strings.HasSuffix(name, "_bucket") {
+		labels = append(labels, "le")
+	}
+
+	seen := make(map[string]bool, len(labels))
+	segmentation := labels[:0]
+	for _, label := range labels {
+		if !seen[label] {
+			seen[label] = true
+			segmentation = append(segmentation, label)
+		}
+	}
+
+	return &models.Rule{
+		ID:          catchAllRulePrefix + name,
+		Name:        "Catch-all " + name,
+		Description: "Generated by the catch-all rule for a metric no other rule matches",
+		Enabled:     true,
+		Source:      CatchAllSource,
+		Matcher:     models.MetricMatcher{MetricNames: []string{name}},
+		Aggregation: models.AggregationConfig{
+			Type:            c.cfg.Aggregation,
+			IntervalSeconds: c.cfg.IntervalSeconds,
+			Segmentation:    segmentation,
+		},
+		Output: models.OutputConfig{
+			MetricName: models.CurrentOutputNamePolicy().Apply(name + ":catch_all"),
+		},
+	}
+}
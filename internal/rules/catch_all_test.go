@@ -0,0 +1,93 @@
+package rules
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// fakeCardinality is a cardinality source with fixed cardinalities
+type fakeCardinality map[string]int
+
+func (f fakeCardinality) SeriesCardinality(name string) int {
+	return f[name]
+}
+
+func TestMatcher_MatchingRules_CatchAll(t *testing.T) {
+	engine := &Engine{rules: make(map[string]*models.Rule)}
+	engine.catchAll.cfg = config.CatchAllConfig{
+		Enabled:         true,
+		MinCardinality:  1000,
+		Segmentation:    []string{"job", "namespace"},
+		Aggregation:     "sum",
+		IntervalSeconds: 60,
+	}
+	engine.SetCardinalitySource(fakeCardinality{
+		"http_requests_total":                50000,
+		"rpc_duration_seconds_bucket":        20000,
+		"rpc_duration_seconds":               20000,
+		"build_info":                         10,
+		"container_memory_working_set_bytes": 80000,
+	})
+	matcher := NewMatcher(engine)
+
+	engine.rules["memory"] = &models.Rule{
+		ID:      "memory",
+		Enabled: true,
+		Matcher: models.MetricMatcher{MetricNames: []string{"container_memory_*"}},
+	}
+
+	sample := &models.MetricSample{Name: "http_requests_total", Labels: map[string]string{"job": "api", "pod": "api-1"}}
+	got := matcher.MatchingRules(sample)
+	if len(got) != 1 || got[0].Source != CatchAllSource {
+		t.Fatalf("MatchingRules(%s) = %v, want the catch-all rule", sample.Name, got)
+	}
+	rule := got[0]
+	if rule.Output.MetricName != "http_requests_total:catch_all" || !reflect.DeepEqual(rule.Aggregation.Segmentation, []string{"job", "namespace"}) {
+		t.Errorf("catch-all rule outputs %s by %v", rule.Output.MetricName, rule.Aggregation.Segmentation)
+	}
+	if err := rule.Validate(); err != nil {
+		t.Errorf("catch-all rule is invalid: %v", err)
+	}
+	// The same rule is returned until the metric is checked again
+	if again := matcher.MatchingRules(sample); len(again) != 1 || again[0] != rule {
+		t.Errorf("second match = %v, want the same rule", again)
+	}
+
+	// Histogram buckets keep their bounds
+	got = matcher.MatchingRules(&models.MetricSample{Name: "rpc_duration_seconds_bucket", Labels: map[string]string{"le": "0.5"}})
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Aggregation.Segmentation, []string{"job", "namespace", "le"}) {
+		t.Errorf("histogram bucket rules = %v", got)
+	}
+
+	for _, sample := range []*models.MetricSample{
+		// Below the cardinality threshold
+		{Name: "build_info"},
+		// Matched by another rule
+		{Name: "container_memory_working_set_bytes"},
+		// Summary quantiles cannot be aggregated
+		{Name: "rpc_duration_seconds", Labels: map[string]string{"quantile": "0.99"}},
+	} {
+		for _, rule := range matcher.MatchingRules(sample) {
+			if rule.Source == CatchAllSource {
+				t.Errorf("%s caught by the catch-all rule", sample.Name)
+			}
+		}
+	}
+
+	if rules := engine.CatchAllRules(); len(rules) != 2 || rules[0].ID != "catch-all:http_requests_total" {
+		t.Errorf("CatchAllRules() = %v", rules)
+	}
+}
+
+func TestMatcher_MatchingRules_CatchAllDisabled(t *testing.T) {
+	engine := &Engine{rules: make(map[string]*models.Rule)}
+	engine.SetCardinalitySource(fakeCardinality{"http_requests_total": 50000})
+	matcher := NewMatcher(engine)
+
+	if got := matcher.MatchingRules(&models.MetricSample{Name: "http_requests_total"}); len(got) != 0 {
+		t.Errorf("MatchingRules() = %v with the catch-all rule disabled", got)
+	}
+}
@@ -0,0 +1,87 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// DecodeRules decodes a JSON array of rules, an exported rule bundle,
+// or YAML documents each holding a rule, a list of rules or a bundle. The
+// hashes of bundles are verified.
+func DecodeRules(body []byte) ([]*models.Rule, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var imported []*models.Rule
+		if err := json.Unmarshal(trimmed, &imported); err != nil {
+			return nil, fmt.Errorf("invalid JSON rules: %w", err)
+		}
+		return imported, nil
+	}
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var bundle models.RuleBundle
+		if err := json.Unmarshal(trimmed, &bundle); err == nil && bundle.Kind == models.RuleBundleKind {
+			if err := bundle.Verify(); err != nil {
+				return nil, err
+			}
+			return bundle.Rules, nil
+		}
+	}
+
+	var imported []*models.Rule
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	for document := 1; ; document++ {
+		var node yaml.Node
+		if err := decoder.Decode(&node); errors.Is(err, io.EOF) {
+			return imported, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid YAML document %d: %w", document, err)
+		}
+		if len(node.Content) == 0 || node.Content[0].Tag == "!!null" {
+			continue
+		}
+
+		if isRuleBundle(node.Content[0]) {
+			var bundle models.RuleBundle
+			if err := node.Decode(&bundle); err != nil {
+				return nil, fmt.Errorf("invalid YAML document %d: %w", document, err)
+			}
+			if err := bundle.Verify(); err != nil {
+				return nil, fmt.Errorf("YAML document %d: %w", document, err)
+			}
+			imported = append(imported, bundle.Rules...)
+			continue
+		}
+		if node.Content[0].Kind == yaml.SequenceNode {
+			var list []*models.Rule
+			if err := node.Decode(&list); err != nil {
+				return nil, fmt.Errorf("invalid YAML document %d: %w", document, err)
+			}
+			imported = append(imported, list...)
+			continue
+		}
+		var rule models.Rule
+		if err := node.Decode(&rule); err != nil {
+			return nil, fmt.Errorf("invalid YAML document %d: %w", document, err)
+		}
+		imported = append(imported, &rule)
+	}
+}
+
+// isRuleBundle reports whether a YAML node is a rule bundle
+func isRuleBundle(node *yaml.Node) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "kind" {
+			return node.Content[i+1].Value == models.RuleBundleKind
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDecodeImportedRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "multi-document YAML",
+			body: "id: a\nname: first\n---\n---\n- id: b\n  name: second\n- id: c\n  name: third\n",
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "JSON array",
+			body: `[{"id": "a", "name": "first"}, {"id": "b", "name": "second"}]`,
+			want: []string{"a", "b"},
+		},
+		{
+			name:    "invalid YAML",
+			body:    "id: a\n---\nid: [b\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imported, err := DecodeRules([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(imported) != len(tt.want) {
+				t.Fatalf("DecodeRules() returned %d rules, want %d", len(imported), len(tt.want))
+			}
+			for i, rule := range imported {
+				if rule.ID != tt.want[i] {
+					t.Errorf("rule %d ID = %s, want %s", i, rule.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeImportedRules_Bundle(t *testing.T) {
+	bundle, err := models.NewRuleBundle([]*models.Rule{{ID: "a", Name: "first"}}, "dev", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, marshal := range map[string]func(interface{}) ([]byte, error){"JSON": json.Marshal, "YAML": yaml.Marshal} {
+		t.Run(name, func(t *testing.T) {
+			data, err := marshal(bundle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			imported, err := DecodeRules(data)
+			if err != nil {
+				t.Fatalf("DecodeRules() error = %v", err)
+			}
+			if len(imported) != 1 || imported[0].ID != "a" {
+				t.Errorf("DecodeRules() = %v, want rule a", imported)
+			}
+
+			tampered := bytes.Replace(data, []byte("first"), []byte("changed"), 1)
+			if _, err := DecodeRules(tampered); err == nil {
+				t.Error("Expected an error for a modified bundle")
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package rules
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// dryStatisticsWindow is how far back dry statistics count matches, in minutes
+const dryStatisticsWindow = 60
+
+// DryStatistics are the samples and series a disabled rule would have matched
+// over the last hour, so that its matcher can be checked before enabling it.
+// Terminal rules ordered before it and its schedule are not taken into account.
+type DryStatistics struct {
+	Samples int64 `json:"samples"`
+	Series  int   `json:"series"`
+	// SeriesLimited is set when more series matched than are counted
+	SeriesLimited bool `json:"series_limited,omitempty"`
+	// Since is when counting started, later than an hour ago for rules that
+	// were disabled or changed recently
+	Since time.Time `json:"since"`
+}
+
+// dryStatistics counts what disabled rules would match. Entries are reset
+// when their rule is replaced, since an update may change its matcher.
+type dryStatistics struct {
+	enabled   bool
+	maxSeries int // 0 is unlimited
+
+	mu      sync.RWMutex
+	entries map[string]*dryEntry
+}
+
+// dryEntry counts the matches of one version of a disabled rule
+type dryEntry struct {
+	rule  *models.Rule
+	since time.Time
+
+	// Samples per minute, indexed by the Unix minute modulo the window
+	minutes [dryStatisticsWindow]dryMinute
+
+	seriesMu sync.Mutex
+	series   map[uint64]int64 // Unix minute each series was last seen
+	limited  bool
+}
+
+// dryMinute counts the samples matched during one minute
+type dryMinute struct {
+	minute  atomic.Int64
+	samples atomic.Int64
+}
+
+// record counts a sample a disabled rule matched
+func (s *dryStatistics) record(rule *models.Rule, sample *models.MetricSample, now time.Time) {
+	entry := s.entry(rule, now)
+	minute := now.Unix() / 60
+
+	bucket := &entry.minutes[minute%dryStatisticsWindow]
+	if previous := bucket.minute.Load(); previous != minute && bucket.minute.CompareAndSwap(previous, minute) {
+		bucket.samples.Store(0)
+	}
+	bucket.samples.Add(1)
+
+	hash := drySeriesHash(sample)
+	entry.seriesMu.Lock()
+	if _, exists := entry.series[hash]; exists || s.maxSeries <= 0 || len(entry.series) < s.maxSeries {
+		entry.series[hash] = minute
+	} else {
+		entry.limited = true
+	}
+	entry.seriesMu.Unlock()
+}
+
+// entry returns the counts of a rule, starting new ones when the rule is new
+// or was replaced
+func (s *dryStatistics) entry(rule *models.Rule, now time.Time) *dryEntry {
+	s.mu.RLock()
+	entry, exists := s.entries[rule.ID]
+	s.mu.RUnlock()
+	if exists && entry.rule == rule {
+		return entry
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, exists := s.entries[rule.ID]; exists && entry.rule == rule {
+		return entry
+	}
+	if s.entries == nil {
+		s.entries = make(map[string]*dryEntry)
+	}
+	entry = &dryEntry{rule: rule, since: now, series: make(map[uint64]int64)}
+	s.entries[rule.ID] = entry
+	return entry
+}
+
+// snapshot returns the statistics of the given version of a rule over the
+// last hour, dropping series not seen since
+func (s *dryStatistics) snapshot(rule *models.Rule, now time.Time) *DryStatistics {
+	s.mu.RLock()
+	entry, exists := s.entries[rule.ID]
+	s.mu.RUnlock()
+
+	since := now.Add(-dryStatisticsWindow * time.Minute)
+	stats := &DryStatistics{Since: since}
+	if !exists || entry.rule != rule {
+		stats.Since = now
+		return stats
+	}
+	if entry.since.After(since) {
+		stats.Since = entry.since
+	}
+
+	oldest := now.Unix()/60 - dryStatisticsWindow
+	for i := range entry.minutes {
+		if entry.minutes[i].minute.Load() > oldest {
+			stats.Samples += entry.minutes[i].samples.Load()
+		}
+	}
+
+	entry.seriesMu.Lock()
+	for hash, minute := range entry.series {
+		if minute <= oldest {
+			delete(entry.series, hash)
+		}
+	}
+	stats.Series = len(entry.series)
+	stats.SeriesLimited = entry.limited
+	if len(entry.series) == 0 {
+		entry.limited = false
+	}
+	entry.seriesMu.Unlock()
+
+	return stats
+}
+
+// retain drops the counts of rules that were enabled, replaced or deleted
+func (s *dryStatistics) retain(rules map[string]*models.Rule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, entry := range s.entries {
+		if rule, exists := rules[id]; !exists || rule != entry.rule || rule.Enabled {
+			delete(s.entries, id)
+		}
+	}
+}
+
+// forget drops the counts of a rule
+func (s *dryStatistics) forget(id string) {
+	s.mu.Lock()
+	delete(s.entries, id)
+	s.mu.Unlock()
+}
+
+// drySeriesHash identifies a series by its name and labels
+func drySeriesHash(sample *models.MetricSample) uint64 {
+	keys := make([]string, 0, len(sample.Labels))
+	for key := range sample.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := fnv.New64a()
+	hash.Write([]byte(sample.Name))
+	for _, key := range keys {
+		hash.Write([]byte{0xff})
+		hash.Write([]byte(key))
+		hash.Write([]byte{0xfe})
+		hash.Write([]byte(sample.Labels[key]))
+	}
+	return hash.Sum64()
+}
+
+// DryStatistics returns the dry statistics of disabled rules by rule ID. It
+// returns nil when dry statistics are not enabled.
+func (e *Engine) DryStatistics() map[string]*DryStatistics {
+	if !e.dryStatistics.enabled {
+		return nil
+	}
+
+	now := time.Now()
+	e.ruleMu.RLock()
+	defer e.ruleMu.RUnlock()
+
+	result := make(map[string]*DryStatistics)
+	for id, rule := range e.rules {
+		if !rule.Enabled {
+			result[id] = e.dryStatistics.snapshot(rule, now)
+		}
+	}
+	e.dryStatistics.retain(e.rules)
+	return result
+}
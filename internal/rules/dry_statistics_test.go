@@ -0,0 +1,78 @@
+package rules
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestMatcher_MatchingRules_DryStatistics(t *testing.T) {
+	engine := &Engine{rules: make(map[string]*models.Rule)}
+	engine.dryStatistics.enabled = true
+	engine.dryStatistics.maxSeries = 2
+	matcher := NewMatcher(engine)
+
+	rule := &models.Rule{
+		ID:      "disabled",
+		Matcher: models.MetricMatcher{MetricNames: []string{"http_*"}},
+	}
+	engine.rules[rule.ID] = rule
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				sample := &models.MetricSample{Name: "http_requests_total", Labels: map[string]string{"pod": string(rune('a' + i%3))}}
+				if got := matcher.MatchingRules(sample); len(got) != 0 {
+					t.Errorf("disabled rule matched: %v", got)
+				}
+				matcher.MatchingRules(&models.MetricSample{Name: "node_cpu_seconds_total"})
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	stats := engine.DryStatistics()[rule.ID]
+	if stats == nil {
+		t.Fatal("no dry statistics for the disabled rule")
+	}
+	if stats.Samples != 400 || stats.Series != 2 || !stats.SeriesLimited {
+		t.Errorf("stats = %+v, want 400 samples and 2 limited series", stats)
+	}
+
+	// Replacing the rule starts counting again
+	updated := *rule
+	engine.rules[rule.ID] = &updated
+	if stats := engine.DryStatistics()[rule.ID]; stats.Samples != 0 || stats.Series != 0 {
+		t.Errorf("stats after update = %+v", stats)
+	}
+
+	// Enabled rules have none
+	updated.Enabled = true
+	if _, exists := engine.DryStatistics()[rule.ID]; exists {
+		t.Error("enabled rule has dry statistics")
+	}
+}
+
+func TestDryStatistics_Window(t *testing.T) {
+	s := &dryStatistics{}
+	rule := &models.Rule{ID: "disabled"}
+	start := time.Unix(1700000000, 0)
+
+	s.record(rule, &models.MetricSample{Name: "a"}, start)
+	s.record(rule, &models.MetricSample{Name: "b"}, start.Add(30*time.Minute))
+
+	stats := s.snapshot(rule, start.Add(45*time.Minute))
+	if stats.Samples != 2 || stats.Series != 2 || !stats.Since.Equal(start) {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	stats = s.snapshot(rule, start.Add(80*time.Minute))
+	if stats.Samples != 1 || stats.Series != 1 {
+		t.Errorf("stats an hour later = %+v, want only the second sample", stats)
+	}
+}
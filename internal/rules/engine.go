@@ -1,13 +1,13 @@
 package rules
 
 import (
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/marcotuna/adaptive-metrics/internal/config"
 	"github.com/marcotuna/adaptive-metrics/internal/models"
@@ -16,27 +16,113 @@ import (
 
 // Engine is responsible for managing and processing metric rules
 type Engine struct {
-	cfg     *config.Config
-	rules   map[string]*models.Rule
-	ruleMu  sync.RWMutex
-	matcher *Matcher
+	cfg        *config.Config
+	rules      map[string]*models.Rule
+	ruleMu     sync.RWMutex
+	templates  map[string]*models.RuleTemplate
+	templateMu sync.RWMutex
+	groups     map[string]*models.RuleGroup
+	groupMu    sync.RWMutex
+	matcher    *Matcher
+	schedules  scheduleCache
+
+	// Rules in evaluation order, computed again after rules or groups change
+	ordered atomic.Pointer[[]*models.Rule]
+
+	// Matches of disabled rules, counted when dry statistics are enabled
+	dryStatistics dryStatistics
+
+	// Aggregates the metrics no rule matches, when enabled
+	catchAll catchAll
+
+	// WASM modules rules apply to their samples, by name
+	wasmTransforms map[string]*WasmTransform
+	wasmMu         sync.RWMutex
+
+	protection   *protection
+	protectionMu sync.RWMutex
+
+	// Ingest filters are read for every ingested series
+	ingestFilter atomic.Pointer[ingestFilter]
+
+	// Serializes revision numbering of rule history
+	historyMu sync.Mutex
 }
 
 // NewEngine creates a new rule engine
 func NewEngine(cfg *config.Config) (*Engine, error) {
+	switch cfg.IDs.Scheme {
+	case "", models.IDSchemeRandom, models.IDSchemeUUIDv7:
+	default:
+		return nil, fmt.Errorf("invalid ids.scheme %q: must be %s or %s", cfg.IDs.Scheme, models.IDSchemeRandom, models.IDSchemeUUIDv7)
+	}
+	if err := ValidateLintChecks(cfg.Lint.Disabled); err != nil {
+		return nil, fmt.Errorf("invalid lint.disabled: %w", err)
+	}
+
 	engine := &Engine{
-		cfg:   cfg,
-		rules: make(map[string]*models.Rule),
+		cfg:       cfg,
+		rules:     make(map[string]*models.Rule),
+		templates: make(map[string]*models.RuleTemplate),
+		groups:    make(map[string]*models.RuleGroup),
+
+		wasmTransforms: make(map[string]*WasmTransform),
 	}
 
 	// Initialize rule matcher
 	engine.matcher = NewMatcher(engine)
+	engine.dryStatistics.enabled = cfg.Aggregator.DryStatistics.Enabled
+	engine.dryStatistics.maxSeries = cfg.Aggregator.DryStatistics.MaxSeries
+	engine.catchAll.cfg = cfg.Aggregator.CatchAll
+
+	// Load the protection list rules are checked against
+	err := engine.SetProtection(models.ProtectionList{
+		Metrics:   cfg.Protection.Metrics,
+		Labels:    cfg.Protection.Labels,
+		Selectors: cfg.Protection.Selectors,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid protection config: %w", err)
+	}
+
+	// Output metric names are checked against the naming policy on validation
+	models.SetOutputNamePolicy(models.OutputNamePolicy{
+		Prefix: cfg.OutputNaming.Prefix,
+		Suffix: cfg.OutputNaming.Suffix,
+	})
+	if err := engine.catchAll.validate(); err != nil {
+		return nil, err
+	}
+
+	// Load the filters applied to series before rule matching
+	err = engine.SetIngestFilters(models.IngestFilters{
+		Drop: cfg.Ingest.Filters.Drop,
+		Pass: cfg.Ingest.Filters.Pass,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ingest filters config: %w", err)
+	}
+
+	// Load rule groups before rules so ordering is known
+	if err := engine.loadGroupsFromDisk(); err != nil {
+		return nil, fmt.Errorf("failed to load rule groups: %w", err)
+	}
+
+	// Load WASM transforms before the rules applying them
+	if err := engine.loadWasmTransformsFromDisk(); err != nil {
+		return nil, fmt.Errorf("failed to load wasm transforms: %w", err)
+	}
 
 	// Load rules from disk if path exists
 	if err := engine.loadRulesFromDisk(); err != nil {
 		return nil, fmt.Errorf("failed to load rules: %w", err)
 	}
 
+	// Load rule templates from disk
+	if err := engine.loadTemplatesFromDisk(); err != nil {
+		return nil, fmt.Errorf("failed to load templates: %w", err)
+	}
+
 	return engine, nil
 }
 
@@ -76,12 +162,13 @@ func (e *Engine) loadRulesFromDisk() error {
 
 		// Generate ID if not present
 		if rule.ID == "" {
-			rule.ID = generateID()
+			rule.ID = e.newRuleID()
 		}
 
 		// Add to rules map
 		e.ruleMu.Lock()
 		e.rules[rule.ID] = &rule
+		e.rulesChanged()
 		e.ruleMu.Unlock()
 	}
 
@@ -90,9 +177,19 @@ func (e *Engine) loadRulesFromDisk() error {
 
 // SaveRule saves a rule and persists it to disk
 func (e *Engine) SaveRule(rule *models.Rule) error {
+	return e.saveRule(rule, models.RevisionCreated)
+}
+
+// saveRule creates or replaces a rule, recording the revision with the given
+// action; replacing a rule is recorded as an update unless an action other
+// than created is given
+func (e *Engine) saveRule(rule *models.Rule, action string) error {
 	// Generate ID if not present
 	if rule.ID == "" {
-		rule.ID = generateID()
+		rule.ID = e.newRuleID()
+	}
+	if err := models.ValidateID(rule.ID); err != nil {
+		return err
 	}
 
 	// Validate rule
@@ -100,13 +197,48 @@ func (e *Engine) SaveRule(rule *models.Rule) error {
 		return err
 	}
 
-	// Add to rules map
+	// Reject rules that would aggregate protected metrics
+	if err := e.CheckProtection(rule); err != nil {
+		return err
+	}
+
+	if err := e.checkRemoteWriteTarget(rule); err != nil {
+		return err
+	}
+
+	if err := e.checkWasmTransform(rule); err != nil {
+		return err
+	}
+
+	// Check for conflicts with other rules and add to rules map
 	e.ruleMu.Lock()
+	if err := e.checkConflictsLocked(rule); err != nil {
+		e.ruleMu.Unlock()
+		return err
+	}
+	_, existed := e.rules[rule.ID]
 	e.rules[rule.ID] = rule
+	e.rulesChanged()
 	e.ruleMu.Unlock()
+	if existed && action == models.RevisionCreated {
+		action = models.RevisionUpdated
+	}
 
 	// Persist to disk
-	return e.saveRuleToDisk(rule)
+	return e.persistRule(rule, action)
+}
+
+// checkRemoteWriteTarget verifies that the remote write target of a rule names
+// a configured endpoint
+func (e *Engine) checkRemoteWriteTarget(rule *models.Rule) error {
+	target := rule.Output.RemoteWriteTarget
+	if target == nil || target.Endpoint == "" || !e.cfg.RemoteWrite.Enabled {
+		return nil
+	}
+	if _, exists := e.cfg.RemoteWrite.EndpointURL(target.Endpoint); !exists {
+		return fmt.Errorf("unknown remote write endpoint %q", target.Endpoint)
+	}
+	return nil
 }
 
 // UpdateRule updates an existing rule
@@ -125,17 +257,45 @@ func (e *Engine) UpdateRule(rule *models.Rule) error {
 		return err
 	}
 
-	// Update in rules map
+	// Reject rules that would aggregate protected metrics
+	if err := e.CheckProtection(rule); err != nil {
+		return err
+	}
+
+	if err := e.checkRemoteWriteTarget(rule); err != nil {
+		return err
+	}
+
+	if err := e.checkWasmTransform(rule); err != nil {
+		return err
+	}
+
+	// Check for conflicts with other rules and update in rules map, unless
+	// the rule was deleted meanwhile
 	e.ruleMu.Lock()
+	if _, exists := e.rules[rule.ID]; !exists {
+		e.ruleMu.Unlock()
+		return fmt.Errorf("rule with ID %s does not exist", rule.ID)
+	}
+	if err := e.checkConflictsLocked(rule); err != nil {
+		e.ruleMu.Unlock()
+		return err
+	}
 	e.rules[rule.ID] = rule
+	e.rulesChanged()
 	e.ruleMu.Unlock()
 
 	// Persist to disk
-	return e.saveRuleToDisk(rule)
+	return e.persistRule(rule, models.RevisionUpdated)
 }
 
 // DeleteRule removes a rule
 func (e *Engine) DeleteRule(id string) error {
+	return e.DeleteRuleBy(id, "")
+}
+
+// DeleteRuleBy removes a rule, recording who deleted it in its history
+func (e *Engine) DeleteRuleBy(id, author string) error {
 	// Check if rule exists
 	e.ruleMu.RLock()
 	rule, exists := e.rules[id]
@@ -148,6 +308,9 @@ func (e *Engine) DeleteRule(id string) error {
 	// Remove from rules map
 	e.ruleMu.Lock()
 	delete(e.rules, id)
+	e.rulesChanged()
+	e.schedules.forget(id)
+	e.dryStatistics.forget(id)
 	e.ruleMu.Unlock()
 
 	// Remove from disk
@@ -158,7 +321,7 @@ func (e *Engine) DeleteRule(id string) error {
 		return fmt.Errorf("failed to delete rule file: %w", err)
 	}
 
-	return nil
+	return e.recordRevision(rule, models.RevisionDeleted, author, time.Now())
 }
 
 // GetRule retrieves a rule by ID
@@ -174,17 +337,13 @@ func (e *Engine) GetRule(id string) (*models.Rule, error) {
 	return rule, nil
 }
 
-// GetRules returns all rules
+// GetRules returns all rules in evaluation order
 func (e *Engine) GetRules() ([]*models.Rule, error) {
 	e.ruleMu.RLock()
 	defer e.ruleMu.RUnlock()
 
-	rules := make([]*models.Rule, 0, len(e.rules))
-	for _, rule := range e.rules {
-		rules = append(rules, rule)
-	}
-
-	return rules, nil
+	ordered := e.orderedRules()
+	return append(make([]*models.Rule, 0, len(ordered)), ordered...), nil
 }
 
 // FindMatchingRules returns all rules that match a given metric sample
@@ -192,6 +351,81 @@ func (e *Engine) FindMatchingRules(sample *models.MetricSample) []*models.Rule {
 	return e.matcher.MatchingRules(sample)
 }
 
+// ForwardedRules returns the enabled rules a peer matched to a forwarded
+// sample, without matching it again. Rules of the catch-all rule are
+// generated for the sample's metric, since the peer decided it was caught.
+func (e *Engine) ForwardedRules(sample *models.MetricSample) []*models.Rule {
+	e.ruleMu.RLock()
+	defer e.ruleMu.RUnlock()
+
+	rules := make([]*models.Rule, 0, len(sample.Rules))
+	for _, id := range sample.Rules {
+		if rule, exists := e.rules[id]; exists && rule.Enabled {
+			rules = append(rules, rule)
+		} else if id == catchAllRulePrefix+sample.Name && e.catchAll.cfg.Enabled {
+			rules = append(rules, e.catchAll.forwardedRule(e, sample.Name))
+		}
+	}
+	return rules
+}
+
+// ExpireRules disables enabled rules whose expiry time has passed and persists
+// them. It returns the rules that were disabled and the first persistence error.
+func (e *Engine) ExpireRules(now time.Time) ([]models.Rule, error) {
+	var expired []*models.Rule
+
+	e.ruleMu.Lock()
+	for id, rule := range e.rules {
+		if !rule.Enabled || !rule.Expired(now) {
+			continue
+		}
+		// Replace rather than modify the rule, which may be in use by the processor
+		disabled := *rule
+		disabled.Enabled = false
+		disabled.UpdatedAt = now
+		disabled.UpdatedBy = models.SystemAuthor
+		e.rules[id] = &disabled
+		expired = append(expired, &disabled)
+	}
+	if len(expired) > 0 {
+		e.rulesChanged()
+	}
+	e.ruleMu.Unlock()
+
+	var firstErr error
+	result := make([]models.Rule, 0, len(expired))
+	for _, rule := range expired {
+		if err := e.persistRule(rule, models.RevisionUpdated); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		result = append(result, *rule)
+	}
+
+	return result, firstErr
+}
+
+// SuspendDrops stops a rule from dropping its original metrics and persists
+// it. It returns the suspended rule, or nil if the rule does not exist or
+// drops nothing.
+func (e *Engine) SuspendDrops(id, reason string, now time.Time) (*models.Rule, error) {
+	e.ruleMu.Lock()
+	rule, exists := e.rules[id]
+	if !exists || rule.DropPercentage() == 0 {
+		e.ruleMu.Unlock()
+		return nil, nil
+	}
+	// Replace rather than modify the rule, which may be in use by the processor
+	suspended := *rule
+	suspended.DropsSuspended = &models.DropSuspension{At: now, Reason: reason}
+	suspended.UpdatedAt = now
+	suspended.UpdatedBy = models.SystemAuthor
+	e.rules[id] = &suspended
+	e.rulesChanged()
+	e.ruleMu.Unlock()
+
+	return &suspended, e.persistRule(&suspended, models.RevisionUpdated)
+}
+
 // AddRule adds a new rule (implements the RuleStore interface)
 func (e *Engine) AddRule(rule models.Rule) error {
 	return e.SaveRule(&rule)
@@ -223,11 +457,12 @@ func (e *Engine) saveRuleToDisk(rule *models.Rule) error {
 	return nil
 }
 
-// generateID generates a unique ID for a rule
-func generateID() string {
-	bytes := make([]byte, 8)
-	if _, err := rand.Read(bytes); err != nil {
-		panic(fmt.Sprintf("failed to generate random ID: %v", err))
-	}
-	return hex.EncodeToString(bytes)
+// newRuleID generates a unique ID for a rule with the configured scheme
+func (e *Engine) newRuleID() string {
+	return models.NewID(e.cfg.IDs.Scheme, e.cfg.IDs.RulePrefix)
+}
+
+// newTemplateID generates a unique ID for a rule template with the configured scheme
+func (e *Engine) newTemplateID() string {
+	return models.NewID(e.cfg.IDs.Scheme, e.cfg.IDs.TemplatePrefix)
 }
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -430,4 +431,159 @@ output:
 	if rule.Output.MetricName != "disk_metric_aggregated" {
 		t.Errorf("Loaded rule output metric name = %v, want %v", rule.Output.MetricName, "disk_metric_aggregated")
 	}
-}
\ No newline at end of file
+}
+func TestEngine_ExpireRules(t *testing.T) {
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{RulesPath: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	temporary := newGroupTestRule("temporary", "", 0, false, "temporary_out")
+	temporary.ExpiresAt = &past
+	permanent := newGroupTestRule("permanent", "", 1, false, "permanent_out")
+	later := newGroupTestRule("later", "", 2, false, "later_out")
+	later.ExpiresAt = &future
+	for _, rule := range []*models.Rule{temporary, permanent, later} {
+		if err := engine.SaveRule(rule); err != nil {
+			t.Fatalf("Failed to save rule: %v", err)
+		}
+	}
+
+	// Expired rules stop matching before they are disabled
+	sample := &models.MetricSample{Name: "http_requests_total", Labels: map[string]string{}}
+	if got := engine.FindMatchingRules(sample); len(got) != 2 {
+		t.Errorf("FindMatchingRules() returned %d rules, want 2", len(got))
+	}
+
+	expired, err := engine.ExpireRules(now)
+	if err != nil {
+		t.Fatalf("ExpireRules() error = %v", err)
+	}
+	if len(expired) != 1 || expired[0].ID != "temporary" {
+		t.Fatalf("ExpireRules() = %v, want [temporary]", expired)
+	}
+
+	rule, _ := engine.GetRule("temporary")
+	if rule.Enabled {
+		t.Error("expired rule is still enabled")
+	}
+	if expired, _ := engine.ExpireRules(now); len(expired) != 0 {
+		t.Errorf("second ExpireRules() = %v, want none", expired)
+	}
+}
+
+func TestEngine_SuspendDrops(t *testing.T) {
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{RulesPath: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	dropping := newGroupTestRule("dropping", "", 0, false, "dropping_out")
+	dropping.Output.DropOriginal = true
+	keeping := newGroupTestRule("keeping", "", 1, false, "keeping_out")
+	for _, rule := range []*models.Rule{dropping, keeping} {
+		if err := engine.SaveRule(rule); err != nil {
+			t.Fatalf("Failed to save rule: %v", err)
+		}
+	}
+
+	suspended, err := engine.SuspendDrops("dropping", "no output", time.Now())
+	if err != nil || suspended == nil {
+		t.Fatalf("SuspendDrops() = %v, %v, want the suspended rule", suspended, err)
+	}
+	rule, _ := engine.GetRule("dropping")
+	if rule.DropPercentage() != 0 || rule.DropsSuspended == nil || rule.DropsSuspended.Reason != "no output" {
+		t.Errorf("suspended rule = %+v, want drops suspended", rule)
+	}
+
+	// Rules that drop nothing are left alone
+	if suspended, _ := engine.SuspendDrops("keeping", "no output", time.Now()); suspended != nil {
+		t.Errorf("SuspendDrops() of a rule without drops = %v, want nil", suspended)
+	}
+}
+
+func TestEngine_RemoteWriteTarget(t *testing.T) {
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{RulesPath: t.TempDir()},
+		RemoteWrite: config.RemoteWriteConfig{
+			Enabled:         true,
+			Endpoints:       []string{"http://mimir:9009/api/v1/push"},
+			EndpointOptions: []config.RemoteWriteEndpointOptions{{Name: "archive", URL: "http://archive:9009/api/v1/push"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	for _, endpoint := range []string{"archive", "http://mimir:9009/api/v1/push", ""} {
+		rule := newGroupTestRule("routed", "", 0, false, "out")
+		rule.Output.RemoteWriteTarget = &models.RemoteWriteTarget{Endpoint: endpoint, Tenant: "long-retention"}
+		if err := engine.SaveRule(rule); err != nil {
+			t.Errorf("SaveRule() with endpoint %q error = %v", endpoint, err)
+		}
+		engine.DeleteRule(rule.ID)
+	}
+
+	rule := newGroupTestRule("routed", "", 0, false, "out")
+	rule.Output.RemoteWriteTarget = &models.RemoteWriteTarget{Endpoint: "missing"}
+	if err := engine.SaveRule(rule); err == nil {
+		t.Error("SaveRule() with an unknown remote write endpoint should fail")
+	}
+}
+
+func TestEngine_IDScheme(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Aggregator.RulesPath = t.TempDir()
+	cfg.IDs = config.IDConfig{Scheme: models.IDSchemeUUIDv7, RulePrefix: "rule_"}
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	newRule := func(id string) *models.Rule {
+		return &models.Rule{
+			ID:          id,
+			Name:        "Requests by path",
+			Matcher:     models.MetricMatcher{MetricNames: []string{"http_requests_total"}},
+			Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 60},
+			Output:      models.OutputConfig{MetricName: "http_requests_by_path"},
+		}
+	}
+
+	// New rules get IDs of the configured scheme, rules of the previous one keep theirs
+	generated := newRule("")
+	if err := engine.SaveRule(generated); err != nil {
+		t.Fatalf("SaveRule() error = %v", err)
+	}
+	if !strings.HasPrefix(generated.ID, "rule_") || len(generated.ID) != len("rule_")+36 {
+		t.Errorf("generated ID = %s, want a prefixed UUIDv7", generated.ID)
+	}
+	if err := engine.SaveRule(newRule("3f9a1c2b7d4e5f60")); err != nil {
+		t.Fatalf("SaveRule() with a random ID error = %v", err)
+	}
+	if err := engine.SaveRule(newRule("../escape")); err == nil {
+		t.Error("SaveRule() with a path in its ID succeeded")
+	}
+
+	reloaded, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	for _, id := range []string{generated.ID, "3f9a1c2b7d4e5f60"} {
+		if _, err := reloaded.GetRule(id); err != nil {
+			t.Errorf("GetRule(%s) after reload error = %v", id, err)
+		}
+	}
+
+	cfg.IDs.Scheme = "uuidv4"
+	if _, err := NewEngine(cfg); err == nil {
+		t.Error("NewEngine() with an unknown ID scheme succeeded")
+	}
+}
@@ -0,0 +1,376 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// groupsDir is the subdirectory of the rules path where rule groups are persisted
+const groupsDir = "groups"
+
+// loadGroupsFromDisk loads rule group definitions from disk
+func (e *Engine) loadGroupsFromDisk() error {
+	groupsPath := filepath.Join(e.cfg.Aggregator.RulesPath, groupsDir)
+
+	files, err := os.ReadDir(groupsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // No groups to load
+		}
+		return fmt.Errorf("failed to read groups directory: %w", err)
+	}
+
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".yaml" && filepath.Ext(file.Name()) != ".yml" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(groupsPath, file.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read group file %s: %w", file.Name(), err)
+		}
+
+		var group models.RuleGroup
+		if err := yaml.Unmarshal(data, &group); err != nil {
+			return fmt.Errorf("failed to parse group file %s: %w", file.Name(), err)
+		}
+
+		if err := group.Validate(); err != nil {
+			return fmt.Errorf("invalid group file %s: %w", file.Name(), err)
+		}
+
+		e.groupMu.Lock()
+		e.groups[group.Name] = &group
+		e.groupMu.Unlock()
+	}
+	e.groupsChanged()
+
+	return nil
+}
+
+// SaveGroup creates or updates a rule group and persists it to disk
+func (e *Engine) SaveGroup(group *models.RuleGroup) error {
+	if err := group.Validate(); err != nil {
+		return err
+	}
+
+	e.groupMu.Lock()
+	e.groups[group.Name] = group
+	e.groupMu.Unlock()
+	e.groupsChanged()
+
+	return e.saveGroupToDisk(group)
+}
+
+// GetGroup retrieves a rule group by name
+func (e *Engine) GetGroup(name string) (*models.RuleGroup, error) {
+	e.groupMu.RLock()
+	defer e.groupMu.RUnlock()
+
+	group, exists := e.groups[name]
+	if !exists {
+		return nil, fmt.Errorf("rule group %s does not exist", name)
+	}
+
+	return group, nil
+}
+
+// GetGroups returns all rule groups ordered by priority
+func (e *Engine) GetGroups() []*models.RuleGroup {
+	e.groupMu.RLock()
+	defer e.groupMu.RUnlock()
+
+	groups := make([]*models.RuleGroup, 0, len(e.groups))
+	for _, group := range e.groups {
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Priority != groups[j].Priority {
+			return groups[i].Priority > groups[j].Priority
+		}
+		return groups[i].Name < groups[j].Name
+	})
+
+	return groups
+}
+
+// DeleteGroup removes a rule group. Groups that still have rules cannot be deleted.
+func (e *Engine) DeleteGroup(name string) error {
+	if err := models.ValidateID(name); err != nil {
+		return err
+	}
+	if _, err := e.GetGroup(name); err != nil {
+		return err
+	}
+
+	e.ruleMu.RLock()
+	for _, rule := range e.rules {
+		if rule.Group == name {
+			e.ruleMu.RUnlock()
+			return fmt.Errorf("rule group %s is still used by rule %s", name, rule.ID)
+		}
+	}
+	e.ruleMu.RUnlock()
+
+	e.groupMu.Lock()
+	delete(e.groups, name)
+	e.groupMu.Unlock()
+	e.groupsChanged()
+
+	groupPath := filepath.Join(e.cfg.Aggregator.RulesPath, groupsDir, fmt.Sprintf("%s.yaml", name))
+	if err := os.Remove(groupPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete group file: %w", err)
+	}
+
+	return nil
+}
+
+// saveGroupToDisk persists a rule group to disk
+func (e *Engine) saveGroupToDisk(group *models.RuleGroup) error {
+	groupsPath := filepath.Join(e.cfg.Aggregator.RulesPath, groupsDir)
+
+	if err := os.MkdirAll(groupsPath, 0755); err != nil {
+		return fmt.Errorf("failed to create groups directory: %w", err)
+	}
+
+	data, err := yaml.Marshal(group)
+	if err != nil {
+		return fmt.Errorf("failed to marshal group: %w", err)
+	}
+
+	groupPath := filepath.Join(groupsPath, fmt.Sprintf("%s.yaml", group.Name))
+	if err := os.WriteFile(groupPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write group file: %w", err)
+	}
+
+	return nil
+}
+
+// groupPriorities returns a snapshot of the priority of every known group
+func (e *Engine) groupPriorities() map[string]int {
+	e.groupMu.RLock()
+	defer e.groupMu.RUnlock()
+
+	priorities := make(map[string]int, len(e.groups))
+	for name, group := range e.groups {
+		priorities[name] = group.Priority
+	}
+	return priorities
+}
+
+// orderedRules returns the rules in evaluation order, sorting them only after
+// rules or groups changed. ruleMu must be held.
+func (e *Engine) orderedRules() []*models.Rule {
+	if ordered := e.ordered.Load(); ordered != nil {
+		return *ordered
+	}
+
+	ordered := make([]*models.Rule, 0, len(e.rules))
+	for _, rule := range e.rules {
+		ordered = append(ordered, rule)
+	}
+	sortRules(ordered, e.groupPriorities())
+	e.ordered.Store(&ordered)
+	return ordered
+}
+
+// rulesChanged discards the evaluation order of the rules. ruleMu must be
+// held for writing.
+func (e *Engine) rulesChanged() {
+	e.ordered.Store(nil)
+}
+
+// groupsChanged discards the evaluation order of the rules after a group
+// priority may have changed. Holding ruleMu keeps an order being computed
+// from older priorities from being stored after it is discarded.
+func (e *Engine) groupsChanged() {
+	e.ruleMu.Lock()
+	e.rulesChanged()
+	e.ruleMu.Unlock()
+}
+
+// sortRules orders rules for evaluation: group priority, then rule priority
+// (both descending), then group name and rule ID so the order is deterministic.
+func sortRules(rules []*models.Rule, groupPriorities map[string]int) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if pa, pb := groupPriorities[a.Group], groupPriorities[b.Group]; pa != pb {
+			return pa > pb
+		}
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.ID < b.ID
+	})
+}
+
+// checkConflictsLocked verifies that a rule does not conflict with other enabled rules.
+// Two rules conflict when their matchers can select the same series and either
+// they write the same output metric, or they share an evaluation priority and
+// one of them is terminal (so the outcome would depend on the ID tie-break).
+// Rules writing the same output metric must also give it the same labels.
+// ruleMu must be held, and kept until the rule is stored, so that rules saved
+// concurrently cannot conflict with each other.
+func (e *Engine) checkConflictsLocked(rule *models.Rule) error {
+	if !rule.Enabled {
+		return nil
+	}
+
+	if rule.Group != "" {
+		if _, err := e.GetGroup(rule.Group); err != nil {
+			return err
+		}
+	}
+
+	priorities := e.groupPriorities()
+	for _, other := range e.rules {
+		if err := ruleConflict(rule, other, priorities); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ruleConflict returns an error if an enabled rule conflicts with another rule
+func ruleConflict(rule, other *models.Rule, priorities map[string]int) error {
+	if other.ID == rule.ID || !other.Enabled {
+		return nil
+	}
+
+	overlap := matchersOverlap(&rule.Matcher, &other.Matcher)
+	if name := sharedOutput(rule, other); name != "" {
+		if overlap {
+			return fmt.Errorf("rule conflicts with rule %s: both write %s for overlapping matchers",
+				other.ID, name)
+		}
+		if err := labelSchemaConflict(rule, other, name); err != nil {
+			return err
+		}
+	}
+	if !overlap {
+		return nil
+	}
+
+	samePriority := priorities[rule.Group] == priorities[other.Group] && rule.Priority == other.Priority
+	if samePriority && (rule.Terminal || other.Terminal) {
+		return fmt.Errorf("rule conflicts with rule %s: overlapping matchers with the same priority and a terminal rule",
+			other.ID)
+	}
+
+	return nil
+}
+
+// sharedOutput returns the name of a metric both rules write, or an empty
+// string if they write different metrics
+func sharedOutput(rule, other *models.Rule) string {
+	for _, name := range rule.OutputMetricNames() {
+		if slices.Contains(other.OutputMetricNames(), name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// labelSchemaConflict returns an error if two rules writing the same output
+// metric would give it different label names. Rules whose labels are only
+// known once evaluated are not checked.
+func labelSchemaConflict(rule, other *models.Rule, name string) error {
+	labels, known := rule.OutputLabelNames()
+	otherLabels, otherKnown := other.OutputLabelNames()
+	if !known || !otherKnown || slices.Equal(labels, otherLabels) {
+		return nil
+	}
+	return fmt.Errorf("rule conflicts with rule %s: both write %s with different labels %v and %v",
+		other.ID, name, labels, otherLabels)
+}
+
+// matchersOverlap reports whether two matchers could both select the same sample.
+// The check is conservative: it only rules out overlap when metric names or
+// exact label matchers are provably disjoint.
+func matchersOverlap(a, b *models.MetricMatcher) bool {
+	namesOverlap := false
+	for _, nameA := range a.Names() {
+		for _, nameB := range b.Names() {
+			if metricNamesOverlap(nameA, nameB) {
+				namesOverlap = true
+				break
+			}
+		}
+		if namesOverlap {
+			break
+		}
+	}
+	if !namesOverlap {
+		return false
+	}
+
+	for key, valueA := range a.Labels {
+		if valueB, exists := b.Labels[key]; exists && valueA != valueB {
+			return false
+		}
+	}
+
+	return true
+}
+
+// metricNamesOverlap reports whether two metric name patterns (which may contain
+// "*" wildcards) could match the same metric name
+func metricNamesOverlap(a, b string) bool {
+	if a == b || a == "*" || b == "*" {
+		return true
+	}
+
+	wildA := strings.Contains(a, "*")
+	wildB := strings.Contains(b, "*")
+
+	switch {
+	case !wildA && !wildB:
+		return false
+	case wildA && !wildB:
+		return globMatches(a, b)
+	case !wildA && wildB:
+		return globMatches(b, a)
+	}
+
+	// Both are patterns: compare the literal prefixes and suffixes around the wildcards
+	prefixA, prefixB := a[:strings.Index(a, "*")], b[:strings.Index(b, "*")]
+	suffixA, suffixB := a[strings.LastIndex(a, "*")+1:], b[strings.LastIndex(b, "*")+1:]
+
+	prefixesCompatible := strings.HasPrefix(prefixA, prefixB) || strings.HasPrefix(prefixB, prefixA)
+	suffixesCompatible := strings.HasSuffix(suffixA, suffixB) || strings.HasSuffix(suffixB, suffixA)
+
+	return prefixesCompatible && suffixesCompatible
+}
+
+// globMatches reports whether a "*" glob pattern matches a literal name
+func globMatches(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(name, part)
+		if idx < 0 {
+			return false
+		}
+		name = name[idx+len(part):]
+	}
+
+	return strings.HasSuffix(name, last)
+}
@@ -0,0 +1,236 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func newGroupTestRule(id, group string, priority int, terminal bool, output string) *models.Rule {
+	return &models.Rule{
+		ID:       id,
+		Name:     id,
+		Enabled:  true,
+		Group:    group,
+		Priority: priority,
+		Terminal: terminal,
+		Matcher: models.MetricMatcher{
+			MetricNames: []string{"http_requests_total"},
+		},
+		Aggregation: models.AggregationConfig{
+			Type:            "sum",
+			IntervalSeconds: 60,
+		},
+		Output: models.OutputConfig{
+			MetricName: output,
+		},
+	}
+}
+
+func TestMatcher_MatchingRulesOrderAndTerminal(t *testing.T) {
+	engine := &Engine{
+		rules: map[string]*models.Rule{
+			"low":      newGroupTestRule("low", "", 0, false, "low_out"),
+			"high":     newGroupTestRule("high", "", 10, false, "high_out"),
+			"critical": newGroupTestRule("critical", "critical", 0, false, "critical_out"),
+		},
+		groups: map[string]*models.RuleGroup{
+			"critical": {Name: "critical", Priority: 100},
+		},
+	}
+	matcher := NewMatcher(engine)
+	sample := &models.MetricSample{Name: "http_requests_total", Labels: map[string]string{}}
+
+	got := matcher.MatchingRules(sample)
+	wantOrder := []string{"critical", "high", "low"}
+	if len(got) != len(wantOrder) {
+		t.Fatalf("MatchingRules() returned %d rules, want %d", len(got), len(wantOrder))
+	}
+	for i, id := range wantOrder {
+		if got[i].ID != id {
+			t.Errorf("MatchingRules()[%d] = %v, want %v", i, got[i].ID, id)
+		}
+	}
+
+	// A terminal rule stops evaluation of lower ordered rules
+	engine.rules["high"].Terminal = true
+	got = matcher.MatchingRules(sample)
+	if len(got) != 2 || got[1].ID != "high" {
+		t.Errorf("MatchingRules() with terminal rule = %v, want [critical high]", got)
+	}
+}
+
+func TestEngine_GroupChangesReorderRules(t *testing.T) {
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{
+			RulesPath: t.TempDir(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	if err := engine.SaveGroup(&models.RuleGroup{Name: "critical", Priority: 100}); err != nil {
+		t.Fatalf("Failed to save group: %v", err)
+	}
+	for _, rule := range []*models.Rule{
+		newGroupTestRule("grouped", "critical", 0, false, "grouped_out"),
+		newGroupTestRule("high", "", 10, false, "high_out"),
+	} {
+		if err := engine.SaveRule(rule); err != nil {
+			t.Fatalf("Failed to save rule: %v", err)
+		}
+	}
+	sample := &models.MetricSample{Name: "http_requests_total", Labels: map[string]string{}}
+	if got := engine.FindMatchingRules(sample); len(got) != 2 || got[0].ID != "grouped" {
+		t.Fatalf("FindMatchingRules() = %v, want grouped first", got)
+	}
+
+	// Lowering the group priority reorders the rules already matched
+	if err := engine.SaveGroup(&models.RuleGroup{Name: "critical", Priority: -1}); err != nil {
+		t.Fatalf("Failed to save group: %v", err)
+	}
+	if got := engine.FindMatchingRules(sample); len(got) != 2 || got[0].ID != "high" {
+		t.Errorf("FindMatchingRules() after a group change = %v, want high first", got)
+	}
+
+	// Group names are file names
+	for _, name := range []string{"../escape", "a/b", ".."} {
+		if err := engine.SaveGroup(&models.RuleGroup{Name: name}); err == nil {
+			t.Errorf("SaveGroup(%q) succeeded", name)
+		}
+		if err := engine.DeleteGroup(name); err == nil {
+			t.Errorf("DeleteGroup(%q) succeeded", name)
+		}
+	}
+}
+
+func TestEngine_CheckConflicts(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "rules-groups-test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{
+			RulesPath: tempDir,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	if err := engine.SaveRule(newGroupTestRule("base", "", 0, true, "base_out")); err != nil {
+		t.Fatalf("Failed to save rule: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		rule    *models.Rule
+		wantErr bool
+	}{
+		{
+			name:    "same output metric",
+			rule:    newGroupTestRule("dup-output", "", 5, false, "base_out"),
+			wantErr: true,
+		},
+		{
+			name:    "same priority as terminal rule",
+			rule:    newGroupTestRule("same-priority", "", 0, false, "other_out"),
+			wantErr: true,
+		},
+		{
+			name:    "different priority",
+			rule:    newGroupTestRule("higher", "", 1, false, "other_out"),
+			wantErr: false,
+		},
+		{
+			name:    "unknown group",
+			rule:    newGroupTestRule("grouped", "missing", 1, false, "grouped_out"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.SaveRule(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SaveRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Disjoint exact label matchers do not overlap
+	disjoint := newGroupTestRule("disjoint", "", 0, false, "base_out")
+	disjoint.Matcher.Labels = map[string]string{"service": "a"}
+	engine.rules["base"].Matcher.Labels = map[string]string{"service": "b"}
+	if err := engine.SaveRule(disjoint); err != nil {
+		t.Errorf("SaveRule() with disjoint labels error = %v, want nil", err)
+	}
+
+	// Rules writing the same output metric must give it the same labels
+	segmented := newGroupTestRule("segmented", "", 0, false, "base_out")
+	segmented.Matcher.Labels = map[string]string{"service": "c"}
+	segmented.Aggregation.Segmentation = []string{"route"}
+	if err := engine.SaveRule(segmented); err == nil {
+		t.Error("SaveRule() with a different label schema should fail")
+	}
+}
+
+func TestEngine_CheckConflicts_Concurrent(t *testing.T) {
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{
+			RulesPath: t.TempDir(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	// Rules writing the same output metric, saved at once: only one is kept
+	var wg sync.WaitGroup
+	saved := make(chan string, 10)
+	for i := 0; i < cap(saved); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("rule-%d", i)
+			if err := engine.SaveRule(newGroupTestRule(id, "", i, false, "shared_out")); err == nil {
+				saved <- id
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(saved)
+
+	if n := len(saved); n != 1 {
+		t.Errorf("%d conflicting rules saved concurrently, want 1", n)
+	}
+}
+
+func TestMetricNamesOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"http_requests_total", "http_requests_total", true},
+		{"http_requests_total", "node_cpu_seconds_total", false},
+		{"http_*", "http_requests_total", true},
+		{"http_*", "node_cpu_seconds_total", false},
+		{"*", "anything", true},
+		{"http_*", "http_requests_*", true},
+		{"http_*", "node_*", false},
+		{"*_total", "*_bytes", false},
+	}
+
+	for _, tt := range tests {
+		if got := metricNamesOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("metricNamesOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// historyDir is the subdirectory of the rules path where every revision of
+// every rule is persisted, one directory per rule
+const historyDir = "history"
+
+// persistRule writes a rule to disk and records it as a new revision
+func (e *Engine) persistRule(rule *models.Rule, action string) error {
+	if err := e.saveRuleToDisk(rule); err != nil {
+		return err
+	}
+	return e.recordRevision(rule, action, rule.UpdatedBy, time.Now())
+}
+
+// recordRevision appends a revision to the history of a rule
+func (e *Engine) recordRevision(rule *models.Rule, action, author string, now time.Time) error {
+	e.historyMu.Lock()
+	defer e.historyMu.Unlock()
+
+	revisions, err := e.revisionNumbers(rule.ID)
+	if err != nil {
+		return err
+	}
+	next := 1
+	if len(revisions) > 0 {
+		next = revisions[len(revisions)-1] + 1
+	}
+
+	revision := models.RuleRevision{
+		Revision:  next,
+		Action:    action,
+		Author:    author,
+		Timestamp: now,
+		Rule:      *rule,
+	}
+	data, err := yaml.Marshal(revision)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rule revision: %w", err)
+	}
+
+	dir := e.historyPath(rule.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create rule history directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.yaml", next)), data, 0644); err != nil {
+		return fmt.Errorf("failed to write rule revision: %w", err)
+	}
+
+	return nil
+}
+
+// RuleHistory returns all revisions of a rule, newest first. The history of
+// a deleted rule is kept.
+func (e *Engine) RuleHistory(id string) ([]models.RuleRevision, error) {
+	// Reject IDs that would resolve outside the history directory of the rule
+	if err := models.ValidateID(id); err != nil {
+		return nil, err
+	}
+
+	e.historyMu.Lock()
+	defer e.historyMu.Unlock()
+
+	numbers, err := e.revisionNumbers(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("rule with ID %s has no history", id)
+	}
+
+	revisions := make([]models.RuleRevision, 0, len(numbers))
+	for i := len(numbers) - 1; i >= 0; i-- {
+		revision, err := e.readRevision(id, numbers[i])
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *revision)
+	}
+
+	return revisions, nil
+}
+
+// RuleRevision returns one revision of a rule
+func (e *Engine) RuleRevision(id string, revision int) (*models.RuleRevision, error) {
+	// Reject IDs that would resolve outside the history directory of the rule
+	if err := models.ValidateID(id); err != nil {
+		return nil, err
+	}
+
+	e.historyMu.Lock()
+	defer e.historyMu.Unlock()
+
+	return e.readRevision(id, revision)
+}
+
+// RestoreRevision makes a revision of a rule its current version again,
+// recreating the rule if it was deleted. The restore is recorded as a new
+// revision.
+func (e *Engine) RestoreRevision(id string, revision int, author string) (*models.Rule, error) {
+	stored, err := e.RuleRevision(id, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := stored.Rule
+	rule.ID = id
+	rule.UpdatedAt = time.Now()
+	rule.UpdatedBy = author
+	if err := e.saveRule(&rule, models.RevisionRestored); err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+// historyPath returns the directory holding the revisions of a rule
+func (e *Engine) historyPath(id string) string {
+	return filepath.Join(e.cfg.Aggregator.RulesPath, historyDir, id)
+}
+
+// revisionNumbers returns the stored revision numbers of a rule in ascending
+// order. The caller must hold historyMu.
+func (e *Engine) revisionNumbers(id string) ([]int, error) {
+	files, err := os.ReadDir(e.historyPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read rule history directory: %w", err)
+	}
+
+	var numbers []int
+	for _, file := range files {
+		number, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".yaml"))
+		if err != nil || filepath.Ext(file.Name()) != ".yaml" {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	return numbers, nil
+}
+
+// readRevision reads a revision of a rule. The caller must hold historyMu.
+func (e *Engine) readRevision(id string, revision int) (*models.RuleRevision, error) {
+	data, err := os.ReadFile(filepath.Join(e.historyPath(id), fmt.Sprintf("%d.yaml", revision)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("revision %d of rule %s does not exist", revision, id)
+		}
+		return nil, fmt.Errorf("failed to read rule revision: %w", err)
+	}
+
+	var stored models.RuleRevision
+	if err := yaml.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse revision %d of rule %s: %w", revision, id, err)
+	}
+
+	return &stored, nil
+}
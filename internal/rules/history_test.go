@@ -0,0 +1,102 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestEngine_RuleHistory(t *testing.T) {
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{RulesPath: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	rule := newGroupTestRule("history", "", 0, false, "history_out")
+	rule.UpdatedBy = "alice"
+	if err := engine.SaveRule(rule); err != nil {
+		t.Fatalf("Failed to save rule: %v", err)
+	}
+	updated := *rule
+	updated.Aggregation.Type = "avg"
+	updated.UpdatedBy = "bob"
+	if err := engine.UpdateRule(&updated); err != nil {
+		t.Fatalf("Failed to update rule: %v", err)
+	}
+	if err := engine.DeleteRuleBy(rule.ID, "carol"); err != nil {
+		t.Fatalf("Failed to delete rule: %v", err)
+	}
+
+	revisions, err := engine.RuleHistory(rule.ID)
+	if err != nil {
+		t.Fatalf("RuleHistory() error = %v", err)
+	}
+	want := []struct {
+		action string
+		author string
+	}{
+		{models.RevisionDeleted, "carol"},
+		{models.RevisionUpdated, "bob"},
+		{models.RevisionCreated, "alice"},
+	}
+	if len(revisions) != len(want) {
+		t.Fatalf("RuleHistory() returned %d revisions, want %d", len(revisions), len(want))
+	}
+	for i, w := range want {
+		if revisions[i].Revision != len(want)-i || revisions[i].Action != w.action || revisions[i].Author != w.author {
+			t.Errorf("revision %d = %d %s by %s, want %d %s by %s", i,
+				revisions[i].Revision, revisions[i].Action, revisions[i].Author, len(want)-i, w.action, w.author)
+		}
+	}
+
+	// Restoring the first revision recreates the deleted rule
+	restored, err := engine.RestoreRevision(rule.ID, 1, "dave")
+	if err != nil {
+		t.Fatalf("RestoreRevision() error = %v", err)
+	}
+	if restored.Aggregation.Type != "sum" {
+		t.Errorf("restored aggregation = %s, want sum", restored.Aggregation.Type)
+	}
+	if _, err := engine.GetRule(rule.ID); err != nil {
+		t.Errorf("restored rule not found: %v", err)
+	}
+	latest, err := engine.RuleRevision(rule.ID, 4)
+	if err != nil {
+		t.Fatalf("RuleRevision() error = %v", err)
+	}
+	if latest.Action != models.RevisionRestored || latest.Author != "dave" {
+		t.Errorf("latest revision = %s by %s, want restored by dave", latest.Action, latest.Author)
+	}
+
+	if _, err := engine.RuleHistory("../groups"); err == nil {
+		t.Error("expected an invalid rule ID to be rejected")
+	}
+}
+
+func TestDiffRules(t *testing.T) {
+	before := newGroupTestRule("diff", "", 0, false, "diff_out")
+	after := *before
+	after.Aggregation.Type = "max"
+	after.Aggregation.Segmentation = []string{"job"}
+
+	changes := models.DiffRules(before, &after)
+	if len(changes) != 2 {
+		t.Fatalf("DiffRules() returned %d changes, want 2: %+v", len(changes), changes)
+	}
+	if changes[0].Path != "aggregation.segmentation" || changes[0].Old != nil {
+		t.Errorf("changes[0] = %+v, want added aggregation.segmentation", changes[0])
+	}
+	if changes[1].Path != "aggregation.type" || changes[1].Old != "sum" || changes[1].New != "max" {
+		t.Errorf("changes[1] = %+v, want aggregation.type sum -> max", changes[1])
+	}
+
+	// Diffing against no rule lists every field as added
+	for _, change := range models.DiffRules(nil, before) {
+		if change.Old != nil {
+			t.Errorf("change %s has old value %v, want none", change.Path, change.Old)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// Outcomes of importing a rule
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportFailed  = "failed"
+	// ImportSkipped rules are valid but were not applied because another rule
+	// of an atomic import failed
+	ImportSkipped = "skipped"
+)
+
+// ImportResult is the outcome of importing one rule of a batch
+type ImportResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportRules creates or replaces a batch of rules by ID. Each rule is checked
+// against the existing rules with the rest of the batch applied, so rules of
+// a batch may rely on each other's changes. An atomic import applies every
+// rule or, when any of them fails, none; otherwise the valid rules are applied
+// one by one. A dry run only reports what would happen. It returns the result
+// of each rule and whether any rule was applied.
+func (e *Engine) ImportRules(rules []*models.Rule, atomic, dryRun bool) ([]ImportResult, bool) {
+	now := time.Now()
+	plan := e.planImport(rules, now)
+
+	failed := false
+	for _, result := range plan {
+		failed = failed || result.Status == ImportFailed
+	}
+	if dryRun {
+		return plan, false
+	}
+
+	if atomic {
+		if failed {
+			for i := range plan {
+				if plan[i].Status != ImportFailed {
+					plan[i].Status = ImportSkipped
+				}
+			}
+			return plan, false
+		}
+		return e.applyImport(rules, plan)
+	}
+
+	applied := false
+	for i, rule := range rules {
+		if plan[i].Status == ImportFailed {
+			continue
+		}
+		// Rules are saved one by one, so each is checked again against the
+		// rules saved before it
+		if err := e.saveRule(rule, models.RevisionCreated); err != nil {
+			plan[i].Status, plan[i].Error = ImportFailed, err.Error()
+			continue
+		}
+		applied = true
+	}
+	return plan, applied
+}
+
+// planImport assigns IDs and timestamps to a batch of rules and checks each
+// of them against the existing rules with the batch applied
+func (e *Engine) planImport(rules []*models.Rule, now time.Time) []ImportResult {
+	e.ruleMu.RLock()
+	merged := make(map[string]*models.Rule, len(e.rules)+len(rules))
+	for id, rule := range e.rules {
+		merged[id] = rule
+	}
+	e.ruleMu.RUnlock()
+
+	results := make([]ImportResult, len(rules))
+	batch := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		if rule.ID == "" {
+			rule.ID = e.newRuleID()
+		}
+		results[i] = ImportResult{Index: i, ID: rule.ID, Name: rule.Name, Status: ImportCreated}
+		if err := models.ValidateID(rule.ID); err != nil {
+			results[i].Status, results[i].Error = ImportFailed, err.Error()
+			continue
+		}
+
+		if batch[rule.ID] {
+			results[i].Status, results[i].Error = ImportFailed, fmt.Sprintf("duplicate rule ID %s in import", rule.ID)
+			continue
+		}
+		batch[rule.ID] = true
+
+		rule.CreatedAt, rule.UpdatedAt = now, now
+		if existing, exists := merged[rule.ID]; exists {
+			results[i].Status = ImportUpdated
+			rule.CreatedAt = existing.CreatedAt
+		}
+		merged[rule.ID] = rule
+	}
+
+	priorities := e.groupPriorities()
+	for i, rule := range rules {
+		if results[i].Status == ImportFailed {
+			continue
+		}
+		if err := e.checkImportedRule(rule, merged, priorities, now); err != nil {
+			results[i].Status, results[i].Error = ImportFailed, err.Error()
+		}
+	}
+
+	return results
+}
+
+// checkImportedRule validates a rule of an import against the merged rule set
+func (e *Engine) checkImportedRule(rule *models.Rule, merged map[string]*models.Rule, priorities map[string]int, now time.Time) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if rule.Enabled && rule.Expired(now) {
+		return fmt.Errorf("rule has expired: set a later expires_at to enable it")
+	}
+	if err := e.CheckProtection(rule); err != nil {
+		return err
+	}
+	if err := e.checkRemoteWriteTarget(rule); err != nil {
+		return err
+	}
+	if err := e.checkWasmTransform(rule); err != nil {
+		return err
+	}
+
+	if !rule.Enabled {
+		return nil
+	}
+	if rule.Group != "" {
+		if _, err := e.GetGroup(rule.Group); err != nil {
+			return err
+		}
+	}
+	for _, other := range merged {
+		if err := ruleConflict(rule, other, priorities); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyImport applies a checked batch of rules at once, and rolls back the
+// rules already persisted if one of them cannot be
+func (e *Engine) applyImport(rules []*models.Rule, results []ImportResult) ([]ImportResult, bool) {
+	previous := make([]*models.Rule, len(rules))
+	e.ruleMu.Lock()
+	for i, rule := range rules {
+		previous[i] = e.rules[rule.ID]
+		e.rules[rule.ID] = rule
+	}
+	e.rulesChanged()
+	e.ruleMu.Unlock()
+
+	for i, rule := range rules {
+		action := models.RevisionCreated
+		if previous[i] != nil {
+			action = models.RevisionUpdated
+		}
+		if err := e.persistRule(rule, action); err != nil {
+			e.rollbackImport(rules, previous, i)
+			for j := range results {
+				results[j].Status, results[j].Error = ImportSkipped, fmt.Sprintf("rolled back: rule %s failed to persist", rule.ID)
+			}
+			results[i].Status, results[i].Error = ImportFailed, err.Error()
+			return results, false
+		}
+	}
+
+	return results, true
+}
+
+// rollbackImport restores the rules replaced by an import and deletes the
+// ones it created, after the rule at index failed to persist. The rules
+// persisted before it were recorded in their history, so their rollback is
+// recorded too.
+func (e *Engine) rollbackImport(rules, previous []*models.Rule, failed int) {
+	e.ruleMu.Lock()
+	for i := failed; i < len(rules); i++ {
+		if previous[i] != nil {
+			e.rules[rules[i].ID] = previous[i]
+		} else {
+			delete(e.rules, rules[i].ID)
+		}
+	}
+	e.rulesChanged()
+	e.ruleMu.Unlock()
+
+	// The failed rule may have been written before its revision failed
+	if previous[failed] != nil {
+		e.saveRuleToDisk(previous[failed])
+	} else {
+		os.Remove(filepath.Join(e.cfg.Aggregator.RulesPath, fmt.Sprintf("%s.yaml", rules[failed].ID)))
+	}
+
+	for i := 0; i < failed; i++ {
+		if previous[i] == nil {
+			e.DeleteRuleBy(rules[i].ID, rules[i].UpdatedBy)
+			continue
+		}
+		e.ruleMu.Lock()
+		e.rules[rules[i].ID] = previous[i]
+		e.rulesChanged()
+		e.ruleMu.Unlock()
+		e.persistRule(previous[i], models.RevisionRestored)
+	}
+}
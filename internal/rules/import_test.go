@@ -0,0 +1,113 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func newImportTestEngine(t *testing.T) *Engine {
+	t.Helper()
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{RulesPath: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.SaveRule(newGroupTestRule("existing", "", 0, false, "existing_out")); err != nil {
+		t.Fatalf("Failed to save rule: %v", err)
+	}
+	return engine
+}
+
+func TestEngine_ImportRules(t *testing.T) {
+	valid := func() []*models.Rule {
+		updated := newGroupTestRule("existing", "", 0, false, "renamed_out")
+		// Conflicts with the existing rule unless it is renamed in the same import
+		created := newGroupTestRule("new", "", 0, false, "existing_out")
+		return []*models.Rule{updated, created}
+	}
+
+	t.Run("atomic", func(t *testing.T) {
+		engine := newImportTestEngine(t)
+		results, applied := engine.ImportRules(valid(), true, false)
+		if !applied {
+			t.Fatalf("Expected the import to be applied, got %+v", results)
+		}
+		if results[0].Status != ImportUpdated || results[1].Status != ImportCreated {
+			t.Errorf("Statuses = %s, %s, want %s, %s", results[0].Status, results[1].Status, ImportUpdated, ImportCreated)
+		}
+		rule, err := engine.GetRule("new")
+		if err != nil || rule.Output.MetricName != "existing_out" {
+			t.Errorf("Expected imported rule new, got %v (%v)", rule, err)
+		}
+		if _, err := os.Stat(filepath.Join(engine.cfg.Aggregator.RulesPath, "new.yaml")); err != nil {
+			t.Errorf("Expected imported rule to be persisted: %v", err)
+		}
+	})
+
+	t.Run("atomic with an invalid rule", func(t *testing.T) {
+		engine := newImportTestEngine(t)
+		batch := append(valid(), &models.Rule{ID: "invalid", Name: "invalid"})
+		results, applied := engine.ImportRules(batch, true, false)
+		if applied {
+			t.Fatal("Expected no rule to be applied")
+		}
+		if results[0].Status != ImportSkipped || results[2].Status != ImportFailed || results[2].Error == "" {
+			t.Errorf("Unexpected results %+v", results)
+		}
+		if _, err := engine.GetRule("new"); err == nil {
+			t.Error("Expected rule new not to be created")
+		}
+		if rule, _ := engine.GetRule("existing"); rule.Output.MetricName != "existing_out" {
+			t.Errorf("Expected rule existing to be unchanged, got output %s", rule.Output.MetricName)
+		}
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		engine := newImportTestEngine(t)
+		batch := []*models.Rule{
+			newGroupTestRule("new", "", 0, false, "existing_out"),
+			newGroupTestRule("other", "", 0, false, "other_out"),
+		}
+		results, applied := engine.ImportRules(batch, false, false)
+		if !applied {
+			t.Fatal("Expected the valid rule to be applied")
+		}
+		if results[0].Status != ImportFailed || results[1].Status != ImportCreated {
+			t.Errorf("Unexpected results %+v", results)
+		}
+		if _, err := engine.GetRule("other"); err != nil {
+			t.Errorf("Expected rule other to be created: %v", err)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		engine := newImportTestEngine(t)
+		results, applied := engine.ImportRules(valid(), true, true)
+		if applied {
+			t.Fatal("Expected a dry run not to apply rules")
+		}
+		if results[0].Status != ImportUpdated || results[1].Status != ImportCreated {
+			t.Errorf("Unexpected results %+v", results)
+		}
+		if _, err := engine.GetRule("new"); err == nil {
+			t.Error("Expected rule new not to be created by a dry run")
+		}
+	})
+
+	t.Run("duplicate IDs", func(t *testing.T) {
+		engine := newImportTestEngine(t)
+		batch := []*models.Rule{
+			newGroupTestRule("dup", "", 0, false, "a_out"),
+			newGroupTestRule("dup", "", 0, false, "b_out"),
+		}
+		results, _ := engine.ImportRules(batch, false, true)
+		if results[0].Status != ImportCreated || results[1].Status != ImportFailed {
+			t.Errorf("Unexpected results %+v", results)
+		}
+	})
+}
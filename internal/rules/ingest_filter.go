@@ -0,0 +1,84 @@
+package rules
+
+import (
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// ingestFilter is a set of ingest filters with their selectors parsed
+type ingestFilter struct {
+	filters models.IngestFilters
+	drop    []models.MetricMatcher
+	pass    []models.MetricMatcher
+}
+
+// newIngestFilter parses the selectors of a set of ingest filters
+func newIngestFilter(filters models.IngestFilters) (*ingestFilter, error) {
+	if err := filters.Validate(); err != nil {
+		return nil, err
+	}
+
+	f := &ingestFilter{filters: filters}
+	for _, selector := range filters.Drop {
+		matcher, _ := models.ParseSelector(selector)
+		f.drop = append(f.drop, matcher)
+	}
+	for _, selector := range filters.Pass {
+		matcher, _ := models.ParseSelector(selector)
+		f.pass = append(f.pass, matcher)
+	}
+
+	return f, nil
+}
+
+// IngestFilters returns the current ingest filters
+func (e *Engine) IngestFilters() models.IngestFilters {
+	if f := e.ingestFilter.Load(); f != nil {
+		return f.filters
+	}
+	return models.IngestFilters{}
+}
+
+// SetIngestFilters replaces the ingest filters
+func (e *Engine) SetIngestFilters(filters models.IngestFilters) error {
+	f, err := newIngestFilter(filters)
+	if err != nil {
+		return err
+	}
+	e.ingestFilter.Store(f)
+	return nil
+}
+
+// IngestAction returns what to do with an ingested series: drop it, pass it
+// through untouched, or process it
+func (e *Engine) IngestAction(name string, labels map[string]string) string {
+	f := e.ingestFilter.Load()
+	if f == nil {
+		return models.IngestActionProcess
+	}
+
+	for i := range f.drop {
+		if selectorMatches(&f.drop[i], name, labels) {
+			return models.IngestActionDrop
+		}
+	}
+	for i := range f.pass {
+		if selectorMatches(&f.pass[i], name, labels) {
+			return models.IngestActionPass
+		}
+	}
+
+	return models.IngestActionProcess
+}
+
+// selectorMatches reports whether a parsed series selector matches a series
+func selectorMatches(selector *models.MetricMatcher, name string, labels map[string]string) bool {
+	if !metricNameMatches(selector.MetricNames[0], name) {
+		return false
+	}
+	for label, value := range selector.Labels {
+		if labels[label] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestEngine_IngestAction(t *testing.T) {
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{RulesPath: t.TempDir()},
+		Ingest: config.IngestConfig{
+			Filters: config.IngestFiltersConfig{
+				Drop: []string{"go_gc_*", `{job="canary"}`},
+				Pass: []string{"up", "go_*"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		metric string
+		labels map[string]string
+		want   string
+	}{
+		{"dropped by name pattern", "go_gc_duration_seconds", nil, models.IngestActionDrop},
+		{"dropped by label selector", "http_requests_total", map[string]string{"job": "canary"}, models.IngestActionDrop},
+		{"drop takes precedence over pass", "go_gc_cycles_total", nil, models.IngestActionDrop},
+		{"passed through by name", "up", map[string]string{"job": "api"}, models.IngestActionPass},
+		{"passed through by name pattern", "go_goroutines", nil, models.IngestActionPass},
+		{"processed", "http_requests_total", map[string]string{"job": "api"}, models.IngestActionProcess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := engine.IngestAction(tt.metric, tt.labels); got != tt.want {
+				t.Errorf("IngestAction(%s, %v) = %s, want %s", tt.metric, tt.labels, got, tt.want)
+			}
+		})
+	}
+
+	// Invalid selectors keep the current filters
+	if err := engine.SetIngestFilters(models.IngestFilters{Drop: []string{`up{job=api}`}}); err == nil {
+		t.Error("Expected an error for an unquoted label value")
+	}
+	if got := engine.IngestAction("up", nil); got != models.IngestActionPass {
+		t.Errorf("IngestAction(up) = %s after a failed update, want %s", got, models.IngestActionPass)
+	}
+}
@@ -0,0 +1,201 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// Lint checks of rules
+const (
+	LintWildcardMatcher             = "wildcard_matcher"
+	LintHighCardinalitySegmentation = "high_cardinality_segmentation"
+	LintIntervalNotScrapeMultiple   = "interval_not_scrape_multiple"
+	LintDropWithoutAlerting         = "drop_without_alerting"
+	LintOutputSuffix                = "output_suffix"
+)
+
+// LintChecks lists all lint checks
+var LintChecks = []string{
+	LintWildcardMatcher,
+	LintHighCardinalitySegmentation,
+	LintIntervalNotScrapeMultiple,
+	LintDropWithoutAlerting,
+	LintOutputSuffix,
+}
+
+// defaultHighCardinalityLabels are labels with a value per pod, process or
+// request, which defeat aggregation when rules segment by them
+var defaultHighCardinalityLabels = []string{"pod", "pod_name", "instance", "id", "uid", "container_id", "request_id", "trace_id"}
+
+// defaultOutputSuffixes mark output metrics as aggregated besides a suffix
+// naming the aggregation type
+var defaultOutputSuffixes = []string{":aggregated", "_aggregated"}
+
+// LintWarning is a best-practice finding on a rule. Warnings do not prevent
+// saving a rule.
+type LintWarning struct {
+	RuleID   string `json:"rule_id,omitempty"`
+	RuleName string `json:"rule_name,omitempty"`
+	Check    string `json:"check"`
+	Message  string `json:"message"`
+}
+
+// LintOptions configures the lint checks
+type LintOptions struct {
+	// HighCardinalityLabels are labels not to segment by; empty uses pod,
+	// instance, id and similar labels
+	HighCardinalityLabels []string
+	// OutputSuffixes are accepted endings of output metric names besides the
+	// aggregation type, such as ":sum"; empty uses :aggregated and _aggregated
+	OutputSuffixes []string
+	// ScrapeInterval returns the scrape interval of a metric, or 0 when it is
+	// unknown and its interval check is skipped
+	ScrapeInterval func(metric string) time.Duration
+	// DeadMansSwitch is whether rules that stop producing output stop
+	// dropping their original metrics
+	DeadMansSwitch bool
+	// Disabled lists checks that are not run
+	Disabled []string
+}
+
+// LintOptionsFromConfig returns the lint options of the configuration;
+// scrapeInterval returns the inferred scrape interval of a metric, if any
+func LintOptionsFromConfig(cfg *config.Config, scrapeInterval func(metric string) time.Duration) LintOptions {
+	fallback := time.Duration(cfg.Lint.ScrapeIntervalSeconds) * time.Second
+	return LintOptions{
+		HighCardinalityLabels: cfg.Lint.HighCardinalityLabels,
+		OutputSuffixes:        cfg.Lint.OutputSuffixes,
+		ScrapeInterval: func(metric string) time.Duration {
+			if scrapeInterval != nil {
+				if interval := scrapeInterval(metric); interval > 0 {
+					return interval
+				}
+			}
+			return fallback
+		},
+		DeadMansSwitch: cfg.Aggregator.DeadMansSwitchIntervals > 0,
+		Disabled:       cfg.Lint.Disabled,
+	}
+}
+
+// ValidateLintChecks checks that the names of disabled checks are known
+func ValidateLintChecks(checks []string) error {
+	for _, check := range checks {
+		known := false
+		for _, name := range LintChecks {
+			known = known || name == check
+		}
+		if !known {
+			return fmt.Errorf("unknown lint check %q: must be one of %s", check, strings.Join(LintChecks, ", "))
+		}
+	}
+	return nil
+}
+
+// Lint returns the best-practice warnings of a rule
+func Lint(rule *models.Rule, opts LintOptions) []LintWarning {
+	var warnings []LintWarning
+	warn := func(check, format string, args ...interface{}) {
+		for _, disabled := range opts.Disabled {
+			if disabled == check {
+				return
+			}
+		}
+		warnings = append(warnings, LintWarning{
+			RuleID:   rule.ID,
+			RuleName: rule.Name,
+			Check:    check,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	names := concreteMetricNames(rule)
+	if len(names) == 0 && len(rule.Matcher.Family) == 0 && len(rule.Matcher.Labels) == 0 && len(rule.Matcher.LabelRegex) == 0 {
+		warn(LintWildcardMatcher, "matcher only has wildcard metric names and no label matchers, so the rule matches every metric")
+	}
+
+	highCardinality := opts.HighCardinalityLabels
+	if len(highCardinality) == 0 {
+		highCardinality = defaultHighCardinalityLabels
+	}
+	for _, label := range rule.Aggregation.Segmentation {
+		for _, high := range highCardinality {
+			if label == high {
+				warn(LintHighCardinalitySegmentation, "segmentation by %s keeps a series per %s value, so the rule barely reduces cardinality", label, label)
+			}
+		}
+	}
+
+	if opts.ScrapeInterval != nil && rule.Aggregation.IntervalSeconds > 0 {
+		interval := time.Duration(rule.Aggregation.IntervalSeconds) * time.Second
+		for _, name := range names {
+			scrape := opts.ScrapeInterval(name)
+			if scrape > 0 && interval%scrape != 0 {
+				warn(LintIntervalNotScrapeMultiple, "interval of %s is not a multiple of the %s scrape interval of %s, so intervals receive uneven numbers of samples", interval, scrape, name)
+			}
+		}
+	}
+
+	if rule.Output.DropOriginal && !rule.Shadow && !opts.DeadMansSwitch {
+		warn(LintDropWithoutAlerting, "original metrics are dropped while the dead man's switch is disabled, so data is lost unnoticed if the rule stops producing output")
+	}
+
+	if output := rule.Output.MetricName; output != "" && !hasAggregationSuffix(output, rule.Aggregation.Type, opts.OutputSuffixes) {
+		warn(LintOutputSuffix, "output metric %s does not end in a suffix marking it as aggregated, such as :%s", output, rule.Aggregation.Type)
+	}
+
+	return warnings
+}
+
+// concreteMetricNames returns the metric names of a matcher that are not
+// only wildcards, sorted
+func concreteMetricNames(rule *models.Rule) []string {
+	var names []string
+	for _, name := range rule.Matcher.MetricNames {
+		if strings.Trim(name, "*") != "" {
+			names = append(names, name)
+		}
+	}
+	for _, source := range rule.Matcher.Family {
+		names = append(names, source.MetricName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hasAggregationSuffix reports whether an output metric name ends in the
+// aggregation type, such as :sum or _sum, or one of the accepted suffixes
+func hasAggregationSuffix(name, aggregationType string, suffixes []string) bool {
+	if len(suffixes) == 0 {
+		suffixes = defaultOutputSuffixes
+	}
+	if aggregationType != "" && aggregationType != "promql" {
+		suffixes = append([]string{":" + aggregationType, "_" + aggregationType}, suffixes...)
+	}
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// LintRules returns the warnings of all rules, ordered by rule ID
+func (e *Engine) LintRules(opts LintOptions) ([]LintWarning, error) {
+	all, err := e.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+
+	warnings := []LintWarning{}
+	for _, rule := range all {
+		warnings = append(warnings, Lint(rule, opts)...)
+	}
+	return warnings, nil
+}
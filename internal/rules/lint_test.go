@@ -0,0 +1,84 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestLint(t *testing.T) {
+	clean := func() *models.Rule {
+		return &models.Rule{
+			ID:          "r1",
+			Name:        "Requests by path",
+			Matcher:     models.MetricMatcher{MetricNames: []string{"http_requests_total"}},
+			Aggregation: models.AggregationConfig{Type: "sum", IntervalSeconds: 60, Segmentation: []string{"path"}},
+			Output:      models.OutputConfig{MetricName: "http_requests:sum", DropOriginal: true},
+		}
+	}
+	opts := LintOptions{
+		ScrapeInterval: func(metric string) time.Duration {
+			if metric == "http_requests_total" {
+				return 30 * time.Second
+			}
+			return 0
+		},
+		DeadMansSwitch: true,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*models.Rule, *LintOptions)
+		want   []string
+	}{
+		{"clean rule", func(*models.Rule, *LintOptions) {}, nil},
+		{"wildcard matcher", func(r *models.Rule, _ *LintOptions) { r.Matcher.MetricNames = []string{"*"} }, []string{LintWildcardMatcher}},
+		{"wildcard with label matcher", func(r *models.Rule, _ *LintOptions) {
+			r.Matcher.MetricNames = []string{"*"}
+			r.Matcher.Labels = map[string]string{"job": "api"}
+		}, nil},
+		{"high cardinality segmentation", func(r *models.Rule, _ *LintOptions) {
+			r.Aggregation.Segmentation = []string{"path", "pod"}
+		}, []string{LintHighCardinalitySegmentation}},
+		{"configured high cardinality labels", func(r *models.Rule, o *LintOptions) {
+			o.HighCardinalityLabels = []string{"path"}
+		}, []string{LintHighCardinalitySegmentation}},
+		{"interval not a scrape multiple", func(r *models.Rule, _ *LintOptions) {
+			r.Aggregation.IntervalSeconds = 45
+		}, []string{LintIntervalNotScrapeMultiple}},
+		{"drop without dead man's switch", func(r *models.Rule, o *LintOptions) {
+			o.DeadMansSwitch = false
+		}, []string{LintDropWithoutAlerting}},
+		{"output without aggregation suffix", func(r *models.Rule, _ *LintOptions) {
+			r.Output.MetricName = "http_requests"
+		}, []string{LintOutputSuffix}},
+		{"accepted output suffix", func(r *models.Rule, _ *LintOptions) {
+			r.Output.MetricName = "http_requests_aggregated"
+		}, nil},
+		{"disabled check", func(r *models.Rule, o *LintOptions) {
+			r.Output.MetricName = "http_requests"
+			o.Disabled = []string{LintOutputSuffix}
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, o := clean(), opts
+			tt.modify(rule, &o)
+			warnings := Lint(rule, o)
+			if len(warnings) != len(tt.want) {
+				t.Fatalf("Lint() = %+v, want checks %v", warnings, tt.want)
+			}
+			for i, warning := range warnings {
+				if warning.Check != tt.want[i] || warning.RuleID != "r1" {
+					t.Errorf("warning %d = %+v, want check %s", i, warning, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if err := ValidateLintChecks([]string{"output_suffix", "nonsense"}); err == nil {
+		t.Error("ValidateLintChecks() accepted an unknown check")
+	}
+}
@@ -3,6 +3,8 @@ package rules
 import (
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/marcotuna/adaptive-metrics/internal/models"
 )
@@ -11,6 +13,7 @@ import (
 type Matcher struct {
 	engine *Engine
 	regexCache map[string]*regexp.Regexp
+	regexMu sync.RWMutex // Matching runs concurrently on the processor workers
 }
 
 // NewMatcher creates a new rule matcher
@@ -21,15 +24,37 @@ func NewMatcher(engine *Engine) *Matcher {
 	}
 }
 
-// MatchingRules returns all rules that match a given metric sample
+// MatchingRules returns all rules that match a given metric sample, in evaluation
+// order. Rules ordered after the first matching terminal rule are not returned.
 func (m *Matcher) MatchingRules(sample *models.MetricSample) []*models.Rule {
 	m.engine.ruleMu.RLock()
 	defer m.engine.ruleMu.RUnlock()
 	
 	var matchingRules []*models.Rule
+	now := time.Now()
+	// Set when a rule matches outside of its schedule, so the sample is not
+	// left to the catch-all rule
+	claimed := false
 	
-	for _, rule := range m.engine.rules {
+	for _, rule := range m.engine.orderedRules() {
 		if !rule.Enabled {
+			// Disabled rules only count what they would have matched
+			if m.engine.dryStatistics.enabled && m.matchesRule(sample, rule) {
+				m.engine.dryStatistics.record(rule, sample, now)
+			}
+			continue
+		}
+
+		// Expired rules stop matching before the engine disables them
+		if rule.ExpiresAt != nil && rule.Expired(now) {
+			continue
+		}
+
+		// Scheduled rules only match during their windows
+		if !m.engine.schedules.active(rule, now) {
+			if m.engine.catchAll.cfg.Enabled && !claimed {
+				claimed = m.matchesRule(sample, rule)
+			}
 			continue
 		}
 		
@@ -37,6 +62,23 @@ func (m *Matcher) MatchingRules(sample *models.MetricSample) []*models.Rule {
 			matchingRules = append(matchingRules, rule)
 		}
 	}
+
+	// Samples no rule matches may be aggregated by the catch-all rule
+	if len(matchingRules) == 0 {
+		if claimed {
+			return nil
+		}
+		if rule := m.engine.catchAll.match(m.engine, sample, now); rule != nil {
+			return []*models.Rule{rule}
+		}
+		return nil
+	}
+
+	for i, rule := range matchingRules {
+		if rule.Terminal {
+			return matchingRules[:i+1]
+		}
+	}
 	
 	return matchingRules
 }
@@ -44,7 +86,7 @@ func (m *Matcher) MatchingRules(sample *models.MetricSample) []*models.Rule {
 // matchesRule checks if a metric sample matches a specific rule
 func (m *Matcher) matchesRule(sample *models.MetricSample, rule *models.Rule) bool {
 	// Check metric name
-	nameMatched := false
+	nameMatched := rule.Matcher.FamilySource(sample.Name) != nil
 	for _, metricName := range rule.Matcher.MetricNames {
 		if metricName == sample.Name || metricName == "*" {
 			nameMatched = true
@@ -54,11 +96,7 @@ func (m *Matcher) matchesRule(sample *models.MetricSample, rule *models.Rule) bo
 		// Check for glob patterns in metric name
 		if strings.Contains(metricName, "*") {
 			pattern := "^" + strings.ReplaceAll(metricName, "*", ".*") + "$"
-			re, exists := m.regexCache[pattern]
-			if !exists {
-				re = regexp.MustCompile(pattern)
-				m.regexCache[pattern] = re
-			}
+			re := m.regexp(pattern, pattern)
 			
 			if re.MatchString(sample.Name) {
 				nameMatched = true
@@ -70,6 +108,9 @@ func (m *Matcher) matchesRule(sample *models.MetricSample, rule *models.Rule) bo
 	if !nameMatched {
 		return false
 	}
+
+	// Label matchers apply to the labels of family sources once mapped
+	sample = rule.Matcher.ApplyFamily(sample)
 	
 	// Check label matchers
 	for labelKey, labelValue := range rule.Matcher.Labels {
@@ -86,12 +127,7 @@ func (m *Matcher) matchesRule(sample *models.MetricSample, rule *models.Rule) bo
 			return false
 		}
 		
-		cacheKey := labelKey + ":" + regexStr
-		re, exists := m.regexCache[cacheKey]
-		if !exists {
-			re = regexp.MustCompile(regexStr)
-			m.regexCache[cacheKey] = re
-		}
+		re := m.regexp(labelKey+":"+regexStr, regexStr)
 		
 		if !re.MatchString(sampleValue) {
 			return false
@@ -101,19 +137,46 @@ func (m *Matcher) matchesRule(sample *models.MetricSample, rule *models.Rule) bo
 	return true
 }
 
+// regexp returns the compiled regular expression cached under a key
+func (m *Matcher) regexp(key, expr string) *regexp.Regexp {
+	m.regexMu.RLock()
+	re, exists := m.regexCache[key]
+	m.regexMu.RUnlock()
+	if exists {
+		return re
+	}
+
+	re = regexp.MustCompile(expr)
+	m.regexMu.Lock()
+	m.regexCache[key] = re
+	m.regexMu.Unlock()
+	return re
+}
+
 // GetRulesByMetricName returns all rules that might apply to metrics with the given name
 func (m *Matcher) GetRulesByMetricName(metricName string) []*models.Rule {
 	m.engine.ruleMu.RLock()
 	defer m.engine.ruleMu.RUnlock()
 	
 	var matchingRules []*models.Rule
+	now := time.Now()
 	
 	for _, rule := range m.engine.rules {
 		if !rule.Enabled {
 			continue
 		}
+
+		// Expired rules stop matching before the engine disables them
+		if rule.ExpiresAt != nil && rule.Expired(now) {
+			continue
+		}
+
+		// Scheduled rules only match during their windows
+		if !m.engine.schedules.active(rule, now) {
+			continue
+		}
 		
-		for _, ruleMetricName := range rule.Matcher.MetricNames {
+		for _, ruleMetricName := range rule.Matcher.Names() {
 			if ruleMetricName == metricName || ruleMetricName == "*" {
 				matchingRules = append(matchingRules, rule)
 				break
@@ -122,11 +185,7 @@ func (m *Matcher) GetRulesByMetricName(metricName string) []*models.Rule {
 			// Check for glob patterns in metric name
 			if strings.Contains(ruleMetricName, "*") {
 				pattern := "^" + strings.ReplaceAll(ruleMetricName, "*", ".*") + "$"
-				re, exists := m.regexCache[pattern]
-				if !exists {
-					re = regexp.MustCompile(pattern)
-					m.regexCache[pattern] = re
-				}
+				re := m.regexp(pattern, pattern)
 				
 				if re.MatchString(metricName) {
 					matchingRules = append(matchingRules, rule)
@@ -422,4 +422,61 @@ func TestMatcher_GetRulesByMetricName(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestMatcher_matchesRule_Family(t *testing.T) {
+	matcher := NewMatcher(&Engine{rules: make(map[string]*models.Rule)})
+	rule := &models.Rule{
+		Matcher: models.MetricMatcher{
+			MetricNames: []string{"http_requests_total"},
+			Labels:      map[string]string{"service": "api"},
+			Family: []models.FamilySource{
+				{MetricName: "legacy_http_requests", RenameLabels: map[string]string{"svc": "service"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		sample *models.MetricSample
+		want   bool
+	}{
+		{"current name", &models.MetricSample{Name: "http_requests_total", Labels: map[string]string{"service": "api"}}, true},
+		{"legacy name with renamed label", &models.MetricSample{Name: "legacy_http_requests", Labels: map[string]string{"svc": "api"}}, true},
+		{"legacy name with other value", &models.MetricSample{Name: "legacy_http_requests", Labels: map[string]string{"svc": "web"}}, false},
+		{"unrelated metric", &models.MetricSample{Name: "legacy_other", Labels: map[string]string{"svc": "api"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matcher.matchesRule(tt.sample, rule); got != tt.want {
+				t.Errorf("Matcher.matchesRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcher_MatchingRules_Schedule(t *testing.T) {
+	engine := &Engine{rules: make(map[string]*models.Rule)}
+	matcher := NewMatcher(engine)
+	rule := &models.Rule{
+		ID:      "scheduled",
+		Enabled: true,
+		Matcher: models.MetricMatcher{MetricNames: []string{"http_requests_total"}},
+		// Never active: excluded for the whole of every day
+		Schedule: &models.RuleSchedule{Windows: []string{"00:00-24:00"}, Exclude: true},
+	}
+	engine.rules[rule.ID] = rule
+	sample := &models.MetricSample{Name: "http_requests_total"}
+
+	if got := matcher.MatchingRules(sample); len(got) != 0 {
+		t.Errorf("MatchingRules() = %d rules outside the schedule, want 0", len(got))
+	}
+
+	// Updating the schedule replaces the cached one
+	updated := *rule
+	updated.Schedule = &models.RuleSchedule{Windows: []string{"00:00-24:00"}}
+	engine.rules[rule.ID] = &updated
+	engine.rulesChanged()
+	if got := matcher.MatchingRules(sample); len(got) != 1 {
+		t.Errorf("MatchingRules() = %d rules inside the schedule, want 1", len(got))
+	}
+}
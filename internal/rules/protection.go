@@ -0,0 +1,146 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// protection is a protection list with its selectors parsed
+type protection struct {
+	list      models.ProtectionList
+	selectors []models.MetricMatcher
+}
+
+// ProtectionViolation is a rule that violates the protection list
+type ProtectionViolation struct {
+	RuleID string `json:"rule_id"`
+	Error  string `json:"error"`
+}
+
+// newProtection parses the selectors of a protection list
+func newProtection(list models.ProtectionList) (*protection, error) {
+	if err := list.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &protection{list: list}
+	for _, selector := range list.Selectors {
+		matcher, err := models.ParseSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		p.selectors = append(p.selectors, matcher)
+	}
+
+	return p, nil
+}
+
+// currentProtection returns the protection list in effect; an engine without
+// one protects nothing
+func (e *Engine) currentProtection() *protection {
+	e.protectionMu.RLock()
+	defer e.protectionMu.RUnlock()
+
+	if e.protection == nil {
+		return &protection{}
+	}
+	return e.protection
+}
+
+// Protection returns the current protection list
+func (e *Engine) Protection() models.ProtectionList {
+	return e.currentProtection().list
+}
+
+// SetProtection replaces the protection list. Existing rules are not changed;
+// use ProtectionViolations to find the ones that violate the new list.
+func (e *Engine) SetProtection(list models.ProtectionList) error {
+	p, err := newProtection(list)
+	if err != nil {
+		return err
+	}
+
+	e.protectionMu.Lock()
+	e.protection = p
+	e.protectionMu.Unlock()
+	return nil
+}
+
+// CheckProtection returns an error if the rule could aggregate or drop a
+// protected metric, series or label
+func (e *Engine) CheckProtection(rule *models.Rule) error {
+	p := e.currentProtection()
+
+	for _, name := range p.list.Metrics {
+		if matchersOverlap(&rule.Matcher, &models.MetricMatcher{MetricNames: []string{name}}) {
+			return fmt.Errorf("rule matches protected metric %s", name)
+		}
+	}
+
+	for i, selector := range p.selectors {
+		if matchersOverlap(&rule.Matcher, &selector) {
+			return fmt.Errorf("rule matches protected series %s", p.list.Selectors[i])
+		}
+	}
+
+	grouping := make(map[string]bool)
+	for _, label := range rule.GroupingLabels() {
+		grouping[label] = true
+	}
+	for _, label := range p.list.Labels {
+		if !grouping[label] {
+			return fmt.Errorf("rule aggregates away protected label %s", label)
+		}
+	}
+
+	return nil
+}
+
+// ProtectionViolations returns the rules that violate the protection list
+func (e *Engine) ProtectionViolations() []ProtectionViolation {
+	rules, _ := e.GetRules()
+
+	var violations []ProtectionViolation
+	for _, rule := range rules {
+		if err := e.CheckProtection(rule); err != nil {
+			violations = append(violations, ProtectionViolation{RuleID: rule.ID, Error: err.Error()})
+		}
+	}
+
+	return violations
+}
+
+// IsProtectedMetric reports whether any series of the metric is protected,
+// either by name or by a selector
+func (e *Engine) IsProtectedMetric(name string) bool {
+	p := e.currentProtection()
+
+	for _, pattern := range p.list.Metrics {
+		if metricNameMatches(pattern, name) {
+			return true
+		}
+	}
+	for _, selector := range p.selectors {
+		if metricNameMatches(selector.MetricNames[0], name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ProtectedLabels returns the label names every rule must keep
+func (e *Engine) ProtectedLabels() []string {
+	return e.currentProtection().list.Labels
+}
+
+// metricNameMatches reports whether a metric name pattern (which may contain
+// "*" wildcards) matches a literal metric name
+func metricNameMatches(pattern, name string) bool {
+	if pattern == name || pattern == "*" {
+		return true
+	}
+	return strings.Contains(pattern, "*") && globMatches(pattern, name)
+}
@@ -0,0 +1,74 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestEngine_CheckProtection(t *testing.T) {
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{RulesPath: t.TempDir()},
+		Protection: config.ProtectionConfig{
+			Metrics:   []string{"slo_*"},
+			Labels:    []string{"tenant"},
+			Selectors: []string{`http_requests_total{service="checkout"}`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	newRule := func(name string, labels map[string]string) *models.Rule {
+		rule := newGroupTestRule("rule", "", 0, false, "out")
+		rule.Matcher.MetricNames = []string{name}
+		rule.Matcher.Labels = labels
+		rule.Aggregation.Segmentation = []string{"tenant"}
+		return rule
+	}
+
+	tests := []struct {
+		name    string
+		rule    *models.Rule
+		wantErr bool
+	}{
+		{"protected metric", newRule("slo_latency_seconds", nil), true},
+		{"glob overlapping protected metric", newRule("slo*", nil), true},
+		{"protected series", newRule("http_requests_total", nil), true},
+		{"protected series with matching label", newRule("http_requests_total", map[string]string{"service": "checkout"}), true},
+		{"disjoint from protected series", newRule("http_requests_total", map[string]string{"service": "search"}), false},
+		{"unprotected metric", newRule("queue_depth", nil), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.CheckProtection(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckProtection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Rules must keep protected labels
+	rule := newRule("queue_depth", nil)
+	rule.Aggregation.Segmentation = []string{"queue"}
+	if err := engine.SaveRule(rule); err == nil {
+		t.Error("SaveRule() dropping a protected label should fail")
+	}
+
+	if !engine.IsProtectedMetric("slo_errors_total") || !engine.IsProtectedMetric("http_requests_total") {
+		t.Error("IsProtectedMetric() = false for protected metrics, want true")
+	}
+	if engine.IsProtectedMetric("queue_depth") {
+		t.Error("IsProtectedMetric(queue_depth) = true, want false")
+	}
+
+	// Invalid selectors are rejected and keep the current list
+	if err := engine.SetProtection(models.ProtectionList{Selectors: []string{`up{job=~"api"}`}}); err == nil {
+		t.Error("SetProtection() with a regex matcher should fail")
+	}
+	if len(engine.Protection().Metrics) != 1 {
+		t.Errorf("Protection() = %+v, want the configured list", engine.Protection())
+	}
+}
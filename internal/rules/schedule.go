@@ -0,0 +1,83 @@
+package rules
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// scheduleCache holds the compiled schedules of rules with their last state.
+// Windows have minute resolution, so matching evaluates a schedule at most
+// once a minute.
+type scheduleCache struct {
+	mu      sync.RWMutex
+	entries map[string]*scheduleEntry
+}
+
+// scheduleEntry is the compiled schedule of a rule
+type scheduleEntry struct {
+	schedule *models.RuleSchedule // The schedule compiled, replaced when the rule is updated
+	compiled *models.CompiledSchedule
+	state    atomic.Pointer[scheduleState]
+}
+
+// scheduleState is whether a schedule is active during a minute
+type scheduleState struct {
+	active bool
+	minute time.Time
+}
+
+// active reports whether the schedule of a rule is active. Rules without a
+// schedule, or with one that does not compile, are always active.
+func (c *scheduleCache) active(rule *models.Rule, now time.Time) bool {
+	if rule.Schedule == nil {
+		return true
+	}
+
+	entry := c.entry(rule)
+	if entry == nil {
+		return true
+	}
+	minute := now.Truncate(time.Minute)
+	if state := entry.state.Load(); state != nil && state.minute.Equal(minute) {
+		return state.active
+	}
+
+	state := &scheduleState{active: entry.compiled.Active(now), minute: minute}
+	entry.state.Store(state)
+	return state.active
+}
+
+// entry returns the compiled schedule of a rule, compiling it when the rule
+// is new or its schedule changed
+func (c *scheduleCache) entry(rule *models.Rule) *scheduleEntry {
+	c.mu.RLock()
+	entry, exists := c.entries[rule.ID]
+	c.mu.RUnlock()
+	if exists && entry.schedule == rule.Schedule {
+		return entry
+	}
+
+	compiled, err := rule.Schedule.Compile()
+	if err != nil {
+		return nil
+	}
+	entry = &scheduleEntry{schedule: rule.Schedule, compiled: compiled}
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*scheduleEntry)
+	}
+	c.entries[rule.ID] = entry
+	c.mu.Unlock()
+	return entry
+}
+
+// forget drops the compiled schedule of a deleted rule
+func (c *scheduleCache) forget(ruleID string) {
+	c.mu.Lock()
+	delete(c.entries, ruleID)
+	c.mu.Unlock()
+}
@@ -0,0 +1,193 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// templatesDir is the subdirectory of the rules path where templates are persisted
+const templatesDir = "templates"
+
+// loadTemplatesFromDisk loads rule template definitions from disk
+func (e *Engine) loadTemplatesFromDisk() error {
+	templatesPath := filepath.Join(e.cfg.Aggregator.RulesPath, templatesDir)
+
+	files, err := os.ReadDir(templatesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // No templates to load
+		}
+		return fmt.Errorf("failed to read templates directory: %w", err)
+	}
+
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".yaml" && filepath.Ext(file.Name()) != ".yml" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(templatesPath, file.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read template file %s: %w", file.Name(), err)
+		}
+
+		var tmpl models.RuleTemplate
+		if err := yaml.Unmarshal(data, &tmpl); err != nil {
+			return fmt.Errorf("failed to parse template file %s: %w", file.Name(), err)
+		}
+
+		if tmpl.ID == "" {
+			tmpl.ID = e.newTemplateID()
+		}
+		if err := models.ValidateID(tmpl.ID); err != nil {
+			return fmt.Errorf("invalid template file %s: %w", file.Name(), err)
+		}
+		if err := tmpl.Validate(); err != nil {
+			return fmt.Errorf("invalid template file %s: %w", file.Name(), err)
+		}
+
+		e.templateMu.Lock()
+		e.templates[tmpl.ID] = &tmpl
+		e.templateMu.Unlock()
+	}
+
+	return nil
+}
+
+// SaveTemplate saves a rule template and persists it to disk
+func (e *Engine) SaveTemplate(tmpl *models.RuleTemplate) error {
+	if tmpl.ID == "" {
+		tmpl.ID = e.newTemplateID()
+	}
+	if err := models.ValidateID(tmpl.ID); err != nil {
+		return err
+	}
+
+	if err := tmpl.Validate(); err != nil {
+		return err
+	}
+
+	e.templateMu.Lock()
+	e.templates[tmpl.ID] = tmpl
+	e.templateMu.Unlock()
+
+	return e.saveTemplateToDisk(tmpl)
+}
+
+// GetTemplate retrieves a rule template by ID
+func (e *Engine) GetTemplate(id string) (*models.RuleTemplate, error) {
+	e.templateMu.RLock()
+	defer e.templateMu.RUnlock()
+
+	tmpl, exists := e.templates[id]
+	if !exists {
+		return nil, fmt.Errorf("template with ID %s does not exist", id)
+	}
+
+	return tmpl, nil
+}
+
+// GetTemplates returns all rule templates
+func (e *Engine) GetTemplates() []*models.RuleTemplate {
+	e.templateMu.RLock()
+	defer e.templateMu.RUnlock()
+
+	templates := make([]*models.RuleTemplate, 0, len(e.templates))
+	for _, tmpl := range e.templates {
+		templates = append(templates, tmpl)
+	}
+
+	return templates
+}
+
+// DeleteTemplate removes a rule template. Rules already instantiated from it are kept.
+func (e *Engine) DeleteTemplate(id string) error {
+	e.templateMu.Lock()
+	_, exists := e.templates[id]
+	if !exists {
+		e.templateMu.Unlock()
+		return fmt.Errorf("template with ID %s does not exist", id)
+	}
+	delete(e.templates, id)
+	e.templateMu.Unlock()
+
+	templatePath := filepath.Join(e.cfg.Aggregator.RulesPath, templatesDir, fmt.Sprintf("%s.yaml", id))
+	if err := os.Remove(templatePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete template file: %w", err)
+	}
+
+	return nil
+}
+
+// InstantiateTemplate renders a template once per set of variable values and
+// saves the resulting rules. All value sets are rendered, and the rules
+// checked against each other and the existing rules, before any rule is
+// saved, so a bad value set does not leave partial results behind.
+func (e *Engine) InstantiateTemplate(id string, valueSets []map[string]string) ([]*models.Rule, error) {
+	tmpl, err := e.GetTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(valueSets) == 0 {
+		return nil, fmt.Errorf("at least one set of template values must be provided")
+	}
+
+	now := time.Now()
+	rules := make([]*models.Rule, 0, len(valueSets))
+	seenIDs := make(map[string]bool, len(valueSets))
+	for i, values := range valueSets {
+		rule, err := tmpl.Instantiate(values)
+		if err != nil {
+			return nil, fmt.Errorf("value set %d: %w", i, err)
+		}
+		// A template with a fixed rule ID would make every instance overwrite the previous one
+		if rule.ID != "" {
+			if seenIDs[rule.ID] {
+				return nil, fmt.Errorf("value set %d: duplicate rule ID %s", i, rule.ID)
+			}
+			seenIDs[rule.ID] = true
+		}
+		rule.CreatedAt = now
+		rule.UpdatedAt = now
+		rules = append(rules, rule)
+	}
+
+	// Rules are saved as an atomic import: all of them or none
+	results, applied := e.ImportRules(rules, true, false)
+	if !applied {
+		for _, result := range results {
+			if result.Status == ImportFailed {
+				return nil, fmt.Errorf("value set %d: failed to save rule %s: %s", result.Index, result.Name, result.Error)
+			}
+		}
+		return nil, fmt.Errorf("failed to save rules")
+	}
+
+	return rules, nil
+}
+
+// saveTemplateToDisk persists a rule template to disk
+func (e *Engine) saveTemplateToDisk(tmpl *models.RuleTemplate) error {
+	templatesPath := filepath.Join(e.cfg.Aggregator.RulesPath, templatesDir)
+
+	if err := os.MkdirAll(templatesPath, 0755); err != nil {
+		return fmt.Errorf("failed to create templates directory: %w", err)
+	}
+
+	data, err := yaml.Marshal(tmpl)
+	if err != nil {
+		return fmt.Errorf("failed to marshal template: %w", err)
+	}
+
+	templatePath := filepath.Join(templatesPath, fmt.Sprintf("%s.yaml", tmpl.ID))
+	if err := os.WriteFile(templatePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write template file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func newTestTemplate() *models.RuleTemplate {
+	return &models.RuleTemplate{
+		ID:        "per-service-http",
+		Name:      "Per-service HTTP aggregation",
+		Variables: []string{"namespace", "service"},
+		Rule: models.Rule{
+			ID:      "http-{{.namespace}}-{{.service}}",
+			Name:    "HTTP aggregation for {{.service}}",
+			Enabled: true,
+			Matcher: models.MetricMatcher{
+				MetricNames: []string{"http_requests_total"},
+				Labels: map[string]string{
+					"namespace": "{{.namespace}}",
+					"service":   "{{.service}}",
+				},
+			},
+			Aggregation: models.AggregationConfig{
+				Type:            "sum",
+				IntervalSeconds: 60,
+				Segmentation:    []string{"status_code"},
+			},
+			Output: models.OutputConfig{
+				MetricName: "{{.service}}_http_requests_aggregated",
+			},
+		},
+	}
+}
+
+func TestEngine_InstantiateTemplate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "rules-template-test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := &config.Config{
+		Aggregator: config.AggregatorConfig{
+			RulesPath: tempDir,
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tmpl := newTestTemplate()
+	if err := engine.SaveTemplate(tmpl); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+
+	// Verify the template was persisted
+	if _, err := os.Stat(filepath.Join(tempDir, templatesDir, tmpl.ID+".yaml")); err != nil {
+		t.Errorf("Template file was not created: %v", err)
+	}
+
+	rules, err := engine.InstantiateTemplate(tmpl.ID, []map[string]string{
+		{"namespace": "prod", "service": "checkout"},
+		{"namespace": "prod", "service": "payments"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to instantiate template: %v", err)
+	}
+
+	if len(rules) != 2 {
+		t.Fatalf("Instantiated %d rules, want 2", len(rules))
+	}
+
+	rule, err := engine.GetRule("http-prod-payments")
+	if err != nil {
+		t.Fatalf("Failed to get instantiated rule: %v", err)
+	}
+	if rule.Output.MetricName != "payments_http_requests_aggregated" {
+		t.Errorf("Output.MetricName = %v, want %v", rule.Output.MetricName, "payments_http_requests_aggregated")
+	}
+	if rule.Matcher.Labels["service"] != "payments" {
+		t.Errorf("Matcher.Labels[service] = %v, want %v", rule.Matcher.Labels["service"], "payments")
+	}
+	if rule.TemplateID != tmpl.ID {
+		t.Errorf("TemplateID = %v, want %v", rule.TemplateID, tmpl.ID)
+	}
+
+	// Templates should be reloaded from disk by a new engine
+	reloaded, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if _, err := reloaded.GetTemplate(tmpl.ID); err != nil {
+		t.Errorf("Template was not reloaded from disk: %v", err)
+	}
+}
+
+func TestEngine_InstantiateTemplateErrors(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "rules-template-errors-test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := &config.Config{
+		Aggregator: config.AggregatorConfig{
+			RulesPath: tempDir,
+		},
+	}
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tmpl := newTestTemplate()
+	if err := engine.SaveTemplate(tmpl); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		values []map[string]string
+	}{
+		{
+			name:   "no value sets",
+			values: nil,
+		},
+		{
+			name:   "missing variable",
+			values: []map[string]string{{"namespace": "prod"}},
+		},
+		{
+			name: "duplicate rule IDs",
+			values: []map[string]string{
+				{"namespace": "prod", "service": "checkout"},
+				{"namespace": "prod", "service": "checkout"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := engine.InstantiateTemplate(tmpl.ID, tt.values); err == nil {
+				t.Error("InstantiateTemplate() expected error, got nil")
+			}
+		})
+	}
+
+	// No rule should have been saved by the failed attempts
+	rules, _ := engine.GetRules()
+	if len(rules) != 0 {
+		t.Errorf("Engine has %d rules after failed instantiations, want 0", len(rules))
+	}
+}
+
+func TestEngine_InstantiateTemplateConflict(t *testing.T) {
+	engine, err := NewEngine(&config.Config{
+		Aggregator: config.AggregatorConfig{
+			RulesPath: t.TempDir(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tmpl := newTestTemplate()
+	if err := engine.SaveTemplate(tmpl); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+	existing := newGroupTestRule("existing", "", 0, false, "payments_http_requests_aggregated")
+	if err := engine.SaveRule(existing); err != nil {
+		t.Fatalf("Failed to save rule: %v", err)
+	}
+
+	// The second value set writes the output metric of the existing rule
+	_, err = engine.InstantiateTemplate(tmpl.ID, []map[string]string{
+		{"namespace": "prod", "service": "checkout"},
+		{"namespace": "prod", "service": "payments"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "value set 1") {
+		t.Fatalf("InstantiateTemplate() error = %v, want a conflict of value set 1", err)
+	}
+
+	// The first value set was not saved either
+	if _, err := engine.GetRule("http-prod-checkout"); err == nil {
+		t.Error("rule of the first value set was saved")
+	}
+	if _, err := os.Stat(filepath.Join(engine.cfg.Aggregator.RulesPath, "http-prod-checkout.yaml")); !os.IsNotExist(err) {
+		t.Errorf("rule file of the first value set: %v, want it not written", err)
+	}
+	if rules, _ := engine.GetRules(); len(rules) != 1 {
+		t.Errorf("Engine has %d rules, want only the existing rule", len(rules))
+	}
+}
+
+func TestEngine_InstantiateTemplateValues(t *testing.T) {
+	cfg := &config.Config{
+		Aggregator: config.AggregatorConfig{
+			RulesPath: t.TempDir(),
+		},
+	}
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tmpl := newTestTemplate()
+	tmpl.Variables = append(tmpl.Variables, "team")
+	tmpl.Rule.Matcher.Labels["team"] = "{{.team}}"
+	if err := engine.SaveTemplate(tmpl); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+
+	// Values are substituted verbatim, without being parsed as YAML
+	teams := []string{"a: b", "it's", "#frontend", "x\nshadow: true"}
+	for i, team := range teams {
+		rules, err := engine.InstantiateTemplate(tmpl.ID, []map[string]string{
+			{"namespace": "prod", "service": fmt.Sprintf("svc%d", i), "team": team},
+		})
+		if err != nil {
+			t.Fatalf("InstantiateTemplate(%q) error = %v", team, err)
+		}
+		if got := rules[0].Matcher.Labels["team"]; got != team {
+			t.Errorf("Matcher.Labels[team] = %q, want %q", got, team)
+		}
+		if rules[0].Shadow {
+			t.Errorf("value %q set another field of the rule", team)
+		}
+	}
+}
+
+func TestEngine_LoadInvalidTemplate(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tempDir, templatesDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	invalid := "id: broken\nname: Broken\nvariables: [service]\nrule:\n  name: \"{{.service\"\n"
+	if err := os.WriteFile(filepath.Join(tempDir, templatesDir, "broken.yaml"), []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewEngine(&config.Config{Aggregator: config.AggregatorConfig{RulesPath: tempDir}}); err == nil {
+		t.Error("NewEngine() loaded an invalid template")
+	}
+}
@@ -0,0 +1,349 @@
+package rules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/wasm"
+)
+
+// transformsDir is the subdirectory of the rules path where WASM transforms
+// are persisted
+const transformsDir = "transforms"
+
+// Exports of a WASM transform. The host calls alloc(len) for a buffer in the
+// module's memory, writes the sample there as JSON, then calls
+// transform(ptr, len), which returns the pointer and length of the
+// transformed sample as ptr<<32 | len, or 0 to drop the sample.
+const (
+	wasmMemoryExport    = "memory"
+	wasmAllocExport     = "alloc"
+	wasmTransformExport = "transform"
+)
+
+// ErrWasmTransformInUse is returned when deleting a WASM transform that rules apply
+var ErrWasmTransformInUse = errors.New("wasm transform is used by rules")
+
+// WasmTransform is a WASM module uploaded to transform the samples matched by
+// rules, such as to parse a label into several. It is safe for concurrent use.
+type WasmTransform struct {
+	Name      string    `json:"name"`
+	Size      int       `json:"size"`
+	SHA256    string    `json:"sha256"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	module *wasm.Module
+	limits wasm.Limits
+	// Instances are reused between samples, and discarded when they trap
+	instances sync.Pool
+}
+
+// wasmSample is the JSON representation of a sample exchanged with WASM
+// transforms. Values are strings, as in the Prometheus HTTP API, so NaN and
+// infinities can be represented.
+type wasmSample struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	Value     string            `json:"value"`
+	Timestamp int64             `json:"timestamp"` // Milliseconds
+}
+
+// newWasmTransform compiles a WASM transform, checking that it exports the
+// transform ABI and instantiates within the limits
+func newWasmTransform(name string, binary []byte, cfg config.WasmTransformsConfig, updatedAt time.Time) (*WasmTransform, error) {
+	if cfg.MaxInstructions <= 0 {
+		return nil, fmt.Errorf("aggregator.wasm_transforms.max_instructions must be greater than 0")
+	}
+	if cfg.MaxLocals <= 0 {
+		return nil, fmt.Errorf("aggregator.wasm_transforms.max_locals must be greater than 0")
+	}
+	if cfg.MaxModuleBytes > 0 && len(binary) > cfg.MaxModuleBytes {
+		return nil, fmt.Errorf("wasm transform is %d bytes, more than the limit of %d", len(binary), cfg.MaxModuleBytes)
+	}
+
+	module, err := wasm.Compile(binary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wasm transform: %w", err)
+	}
+	if !module.ExportsMemory(wasmMemoryExport) {
+		return nil, fmt.Errorf("wasm transform must export its memory as %q", wasmMemoryExport)
+	}
+	if err := checkSignature(module, wasmAllocExport, []wasm.ValueType{wasm.I32}, []wasm.ValueType{wasm.I32}); err != nil {
+		return nil, err
+	}
+	if err := checkSignature(module, wasmTransformExport, []wasm.ValueType{wasm.I32, wasm.I32}, []wasm.ValueType{wasm.I64}); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(binary)
+	t := &WasmTransform{
+		Name:      name,
+		Size:      len(binary),
+		SHA256:    hex.EncodeToString(sum[:]),
+		UpdatedAt: updatedAt,
+		module:    module,
+		limits: wasm.Limits{
+			MaxMemoryPages:  uint32(cfg.MaxMemoryMB * (1 << 20) / wasm.PageSize),
+			MaxInstructions: cfg.MaxInstructions,
+			MaxLocals:       cfg.MaxLocals,
+		},
+	}
+	inst, err := module.Instantiate(t.limits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate wasm transform: %w", err)
+	}
+	t.instances.Put(inst)
+	return t, nil
+}
+
+// checkSignature checks that a module exports a function with a signature
+func checkSignature(module *wasm.Module, name string, params, results []wasm.ValueType) error {
+	want := wasm.FuncType{Params: params, Results: results}
+	typ, exists := module.ExportedFunction(name)
+	if !exists {
+		return fmt.Errorf("wasm transform must export function %q", name)
+	}
+	if typ.String() != want.String() {
+		return fmt.Errorf("wasm transform function %q has signature %s, want %s", name, typ, &want)
+	}
+	return nil
+}
+
+// Apply runs the transform on a sample. It returns a transformed copy of the
+// sample, or nil if the transform dropped it.
+func (t *WasmTransform) Apply(sample *models.MetricSample) (*models.MetricSample, error) {
+	inst, _ := t.instances.Get().(*wasm.Instance)
+	if inst == nil {
+		var err error
+		if inst, err = t.module.Instantiate(t.limits); err != nil {
+			return nil, err
+		}
+	}
+
+	transformed, err := t.apply(inst, sample)
+	if err != nil {
+		// The instance may be left in an inconsistent state
+		return nil, err
+	}
+	t.instances.Put(inst)
+	return transformed, nil
+}
+
+func (t *WasmTransform) apply(inst *wasm.Instance, sample *models.MetricSample) (*models.MetricSample, error) {
+	in := wasmSample{
+		Name:      sample.Name,
+		Labels:    sample.Labels,
+		Value:     strconv.FormatFloat(sample.Value, 'f', -1, 64),
+		Timestamp: sample.Timestamp.UnixMilli(),
+	}
+	input, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := inst.Call(wasmAllocExport, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(results[0])
+	memory := inst.Memory()
+	if uint64(ptr)+uint64(len(input)) > uint64(len(memory)) {
+		return nil, fmt.Errorf("alloc returned a buffer out of bounds of the memory")
+	}
+	copy(memory[ptr:], input)
+
+	if results, err = inst.Call(wasmTransformExport, uint64(ptr), uint64(len(input))); err != nil {
+		return nil, err
+	}
+	if results[0] == 0 {
+		return nil, nil
+	}
+	outPtr, outLen := results[0]>>32, results[0]&0xffffffff
+	memory = inst.Memory()
+	if outPtr+outLen > uint64(len(memory)) {
+		return nil, fmt.Errorf("transform returned a sample out of bounds of the memory")
+	}
+
+	// Fields left out of the output keep their input value
+	out := in
+	out.Labels = nil
+	if err := json.Unmarshal(memory[outPtr:outPtr+outLen], &out); err != nil {
+		return nil, fmt.Errorf("transform returned an invalid sample: %w", err)
+	}
+	if out.Name == "" {
+		return nil, fmt.Errorf("transform returned a sample without a name")
+	}
+	if out.Labels == nil {
+		out.Labels = sample.Labels
+	}
+	value, err := strconv.ParseFloat(out.Value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("transform returned an invalid value %q", out.Value)
+	}
+
+	transformed := *sample
+	transformed.Name = out.Name
+	transformed.Labels = out.Labels
+	transformed.Value = value
+	if out.Timestamp != in.Timestamp {
+		transformed.Timestamp = time.UnixMilli(out.Timestamp)
+	}
+	return &transformed, nil
+}
+
+// loadWasmTransformsFromDisk loads the WASM transforms persisted with the rules
+func (e *Engine) loadWasmTransformsFromDisk() error {
+	transformsPath := filepath.Join(e.cfg.Aggregator.RulesPath, transformsDir)
+
+	files, err := os.ReadDir(transformsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // No transforms to load
+		}
+		return fmt.Errorf("failed to read transforms directory: %w", err)
+	}
+
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".wasm" {
+			continue
+		}
+
+		binary, err := os.ReadFile(filepath.Join(transformsPath, file.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read transform file %s: %w", file.Name(), err)
+		}
+		info, err := file.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read transform file %s: %w", file.Name(), err)
+		}
+
+		name := strings.TrimSuffix(file.Name(), ".wasm")
+		t, err := newWasmTransform(name, binary, e.cfg.Aggregator.WasmTransforms, info.ModTime())
+		if err != nil {
+			return fmt.Errorf("transform file %s: %w", file.Name(), err)
+		}
+
+		e.wasmMu.Lock()
+		e.wasmTransforms[name] = t
+		e.wasmMu.Unlock()
+	}
+
+	return nil
+}
+
+// SaveWasmTransform compiles a WASM transform and persists it to disk,
+// replacing the transform of the same name. Rules using it apply the new
+// module from their next sample.
+func (e *Engine) SaveWasmTransform(name string, binary []byte) (*WasmTransform, error) {
+	if err := models.ValidateID(name); err != nil {
+		return nil, err
+	}
+	t, err := newWasmTransform(name, binary, e.cfg.Aggregator.WasmTransforms, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	transformsPath := filepath.Join(e.cfg.Aggregator.RulesPath, transformsDir)
+	if err := os.MkdirAll(transformsPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create transforms directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(transformsPath, name+".wasm"), binary, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write transform file: %w", err)
+	}
+
+	e.wasmMu.Lock()
+	e.wasmTransforms[name] = t
+	e.wasmMu.Unlock()
+	return t, nil
+}
+
+// GetWasmTransform retrieves a WASM transform by name
+func (e *Engine) GetWasmTransform(name string) (*WasmTransform, error) {
+	e.wasmMu.RLock()
+	defer e.wasmMu.RUnlock()
+
+	t, exists := e.wasmTransforms[name]
+	if !exists {
+		return nil, fmt.Errorf("wasm transform %s does not exist", name)
+	}
+	return t, nil
+}
+
+// GetWasmTransforms returns all WASM transforms, by name
+func (e *Engine) GetWasmTransforms() []*WasmTransform {
+	e.wasmMu.RLock()
+	defer e.wasmMu.RUnlock()
+
+	transforms := make([]*WasmTransform, 0, len(e.wasmTransforms))
+	for _, t := range e.wasmTransforms {
+		transforms = append(transforms, t)
+	}
+	sort.Slice(transforms, func(i, j int) bool {
+		return transforms[i].Name < transforms[j].Name
+	})
+	return transforms
+}
+
+// WasmTransformBinary returns the module of a WASM transform as uploaded
+func (e *Engine) WasmTransformBinary(name string) ([]byte, error) {
+	if _, err := e.GetWasmTransform(name); err != nil {
+		return nil, err
+	}
+	binary, err := os.ReadFile(filepath.Join(e.cfg.Aggregator.RulesPath, transformsDir, name+".wasm"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transform file: %w", err)
+	}
+	return binary, nil
+}
+
+// DeleteWasmTransform removes a WASM transform that no rule uses
+func (e *Engine) DeleteWasmTransform(name string) error {
+	e.ruleMu.RLock()
+	var users []string
+	for _, rule := range e.rules {
+		if rule.WasmTransform == name {
+			users = append(users, rule.ID)
+		}
+	}
+	e.ruleMu.RUnlock()
+	if len(users) > 0 {
+		sort.Strings(users)
+		return fmt.Errorf("%w: %s used by %s", ErrWasmTransformInUse, name, strings.Join(users, ", "))
+	}
+
+	e.wasmMu.Lock()
+	_, exists := e.wasmTransforms[name]
+	if !exists {
+		e.wasmMu.Unlock()
+		return fmt.Errorf("wasm transform %s does not exist", name)
+	}
+	delete(e.wasmTransforms, name)
+	e.wasmMu.Unlock()
+
+	transformPath := filepath.Join(e.cfg.Aggregator.RulesPath, transformsDir, name+".wasm")
+	if err := os.Remove(transformPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete transform file: %w", err)
+	}
+	return nil
+}
+
+// checkWasmTransform verifies that the WASM transform of a rule exists
+func (e *Engine) checkWasmTransform(rule *models.Rule) error {
+	if rule.WasmTransform == "" {
+		return nil
+	}
+	_, err := e.GetWasmTransform(rule.WasmTransform)
+	return err
+}
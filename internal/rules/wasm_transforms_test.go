@@ -0,0 +1,212 @@
+package rules
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// transformModule assembles a WASM transform with one page of memory holding
+// data at address 0, alloc returning address 1024 and the given transform body
+func transformModule(transform []byte, data string) []byte {
+	leb := func(v int) []byte {
+		var out []byte
+		for {
+			b := byte(v & 0x7f)
+			v >>= 7
+			if v != 0 {
+				b |= 0x80
+			}
+			out = append(out, b)
+			if v == 0 {
+				return out
+			}
+		}
+	}
+	section := func(id byte, count int, payload ...byte) []byte {
+		content := append(leb(count), payload...)
+		return append(append([]byte{id}, leb(len(content))...), content...)
+	}
+	name := func(s string) []byte {
+		return append(leb(len(s)), s...)
+	}
+	var exports []byte
+	exports = append(append(exports, name("memory")...), 0x02, 0x00)
+	exports = append(append(exports, name("alloc")...), 0x00, 0x00)
+	exports = append(append(exports, name("transform")...), 0x00, 0x01)
+	alloc := []byte{0x00, 0x41, 0x80, 0x08, 0x0b} // i32.const 1024
+	body := append([]byte{0x00}, transform...)
+	code := append(append(leb(len(alloc)), alloc...), append(leb(len(body)), body...)...)
+	segment := append([]byte{0x00, 0x41, 0x00, 0x0b}, name(data)...)
+
+	module := []byte("\x00asm\x01\x00\x00\x00")
+	module = append(module, section(1, 2, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e)...)
+	module = append(module, section(3, 2, 0x00, 0x01)...)
+	module = append(module, section(5, 1, 0x00, 0x01)...)
+	module = append(module, section(7, 3, exports...)...)
+	module = append(module, section(10, 2, code...)...)
+	module = append(module, section(11, 1, segment...)...)
+	return module
+}
+
+// Transform bodies
+var (
+	// Returns the input: ptr<<32 | len
+	identityTransform = []byte{0x20, 0x00, 0xad, 0x42, 0x20, 0x86, 0x20, 0x01, 0xad, 0x84, 0x0b}
+	dropTransform     = []byte{0x42, 0x00, 0x0b}
+	spinTransform     = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00, 0x0b}
+)
+
+// constantTransform returns the data at address 0, of length n below 8192
+func constantTransform(n int) []byte {
+	return []byte{0x42, byte(n&0x7f) | 0x80, byte(n >> 7), 0x0b}
+}
+
+func newTransformEngine(t *testing.T) *Engine {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Aggregator.RulesPath = t.TempDir()
+	cfg.Aggregator.WasmTransforms = config.WasmTransformsConfig{MaxModuleBytes: 4096, MaxMemoryMB: 1, MaxInstructions: 10000, MaxLocals: 1000}
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	return engine
+}
+
+func TestWasmTransform_Apply(t *testing.T) {
+	engine := newTransformEngine(t)
+	output := `{"name":"http_requests","labels":{"service":"checkout","env":"prod"}}`
+
+	for name, module := range map[string][]byte{
+		"identity": transformModule(identityTransform, ""),
+		"constant": transformModule(constantTransform(len(output)), output),
+		"drop":     transformModule(dropTransform, ""),
+		"spin":     transformModule(spinTransform, ""),
+	} {
+		if _, err := engine.SaveWasmTransform(name, module); err != nil {
+			t.Fatalf("SaveWasmTransform(%s) error = %v", name, err)
+		}
+	}
+
+	now := time.UnixMilli(1760000000000)
+	sample := &models.MetricSample{
+		Name:      "http_requests_total",
+		Labels:    map[string]string{"route": "checkout/prod"},
+		Value:     42,
+		Timestamp: now,
+	}
+	apply := func(name string) (*models.MetricSample, error) {
+		transform, err := engine.GetWasmTransform(name)
+		if err != nil {
+			t.Fatalf("GetWasmTransform(%s) error = %v", name, err)
+		}
+		return transform.Apply(sample)
+	}
+
+	// Twice, the second time with a reused instance
+	for i := 0; i < 2; i++ {
+		got, err := apply("identity")
+		if err != nil {
+			t.Fatalf("identity error = %v", err)
+		}
+		if !reflect.DeepEqual(got, sample) {
+			t.Errorf("identity = %+v, want %+v", got, sample)
+		}
+	}
+
+	// Fields left out of the output are kept
+	got, err := apply("constant")
+	if err != nil {
+		t.Fatalf("constant error = %v", err)
+	}
+	if got.Name != "http_requests" || !reflect.DeepEqual(got.Labels, map[string]string{"service": "checkout", "env": "prod"}) || got.Value != 42 || !got.Timestamp.Equal(now) {
+		t.Errorf("constant = %+v", got)
+	}
+	if sample.Name != "http_requests_total" || len(sample.Labels) != 1 {
+		t.Errorf("input sample changed: %+v", sample)
+	}
+
+	if got, err := apply("drop"); got != nil || err != nil {
+		t.Errorf("drop = %v, %v, want nil", got, err)
+	}
+	if _, err := apply("spin"); err == nil || !strings.Contains(err.Error(), "instruction limit exceeded") {
+		t.Errorf("spin error = %v, want the instruction limit", err)
+	}
+}
+
+func TestEngine_SaveWasmTransform_Invalid(t *testing.T) {
+	engine := newTransformEngine(t)
+
+	for name, module := range map[string][]byte{
+		"not wasm":  []byte("function transform() {}"),
+		"no alloc":  []byte("\x00asm\x01\x00\x00\x00"),
+		"too large": transformModule(identityTransform, strings.Repeat("x", 5000)),
+	} {
+		if _, err := engine.SaveWasmTransform("invalid", module); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, err := engine.SaveWasmTransform("../escape", transformModule(identityTransform, "")); err == nil {
+		t.Error("invalid name accepted")
+	}
+	if len(engine.GetWasmTransforms()) != 0 {
+		t.Errorf("invalid transforms saved: %v", engine.GetWasmTransforms())
+	}
+
+	engine.cfg.Aggregator.WasmTransforms.MaxInstructions = 0
+	if _, err := engine.SaveWasmTransform("unlimited", transformModule(identityTransform, "")); err == nil {
+		t.Error("transform saved without an instruction limit")
+	}
+}
+
+func TestEngine_WasmTransformRules(t *testing.T) {
+	engine := newTransformEngine(t)
+	rule := &models.Rule{
+		ID:            "parse-route",
+		Name:          "Parse route",
+		Enabled:       true,
+		Matcher:       models.MetricMatcher{MetricNames: []string{"http_requests_total"}},
+		WasmTransform: "split-route",
+		Aggregation:   models.AggregationConfig{Type: "sum", IntervalSeconds: 60, Segmentation: []string{"service"}},
+		Output:        models.OutputConfig{MetricName: "http_requests:sum"},
+	}
+	if err := engine.SaveRule(rule); err == nil {
+		t.Fatal("rule with an unknown transform saved")
+	}
+
+	if _, err := engine.SaveWasmTransform("split-route", transformModule(identityTransform, "")); err != nil {
+		t.Fatalf("SaveWasmTransform() error = %v", err)
+	}
+	if err := engine.SaveRule(rule); err != nil {
+		t.Fatalf("SaveRule() error = %v", err)
+	}
+	if err := engine.DeleteWasmTransform("split-route"); !errors.Is(err, ErrWasmTransformInUse) {
+		t.Errorf("DeleteWasmTransform() of a used transform error = %v", err)
+	}
+
+	// Transforms are persisted with the rules
+	reloaded, err := NewEngine(engine.cfg)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	transforms := reloaded.GetWasmTransforms()
+	if len(transforms) != 1 || transforms[0].Name != "split-route" || transforms[0].SHA256 == "" {
+		t.Fatalf("reloaded transforms = %v", transforms)
+	}
+
+	if err := engine.DeleteRule(rule.ID); err != nil {
+		t.Fatalf("DeleteRule() error = %v", err)
+	}
+	if err := engine.DeleteWasmTransform("split-route"); err != nil {
+		t.Errorf("DeleteWasmTransform() error = %v", err)
+	}
+	if reloaded, _ := NewEngine(engine.cfg); len(reloaded.GetWasmTransforms()) != 0 {
+		t.Error("deleted transform reloaded")
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// watchDumpSignal writes a debug dump on every SIGQUIT until
+// stopDumpSignal, instead of the default of printing the stacks and exiting
+func (s *Server) watchDumpSignal() {
+	s.dumpSignals = make(chan os.Signal, 1)
+	signal.Notify(s.dumpSignals, syscall.SIGQUIT)
+	go func(signals <-chan os.Signal) {
+		for range signals {
+			if _, err := s.apiHandler.WriteDebugDump(); err != nil {
+				logger.LogErrorWithFields("Failed to write debug dump", logger.Fields{
+					"error": err.Error(),
+				})
+			}
+		}
+	}(s.dumpSignals)
+}
+
+// stopDumpSignal restores the default handling of SIGQUIT
+func (s *Server) stopDumpSignal() {
+	if s.dumpSignals == nil {
+		return
+	}
+	signal.Stop(s.dumpSignals)
+	close(s.dumpSignals)
+	s.dumpSignals = nil
+}
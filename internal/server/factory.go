@@ -5,8 +5,11 @@ import (
 	"github.com/marcotuna/adaptive-metrics/internal/aggregator"
 	"github.com/marcotuna/adaptive-metrics/internal/api"
 	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
 	"github.com/marcotuna/adaptive-metrics/internal/rules"
 	"github.com/marcotuna/adaptive-metrics/internal/types"
+	"github.com/marcotuna/adaptive-metrics/pkg/federation"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
 )
 
 // createMetricTracker creates a new MetricTracker (API handler) instance
@@ -40,3 +43,33 @@ type metricTrackerAdapter struct {
 func (a *metricTrackerAdapter) TrackMetric(name string, labels map[string]string, value float64) {
 	a.tracker.TrackMetric(name, labels, value)
 }
+
+// ingestFilter decides what happens to an ingested series
+type ingestFilter interface {
+	IngestAction(name string, labels map[string]string) string
+}
+
+// createFederationInput returns the processor input of federated samples,
+// which applies the ingest filters of the rule engine like remote write does
+func createFederationInput(processor types.MetricProcessor, ruleEngine interface{}) federation.Processor {
+	filter, ok := ruleEngine.(ingestFilter)
+	if !ok {
+		return processor
+	}
+	return &filteredProcessor{processor: processor, filter: filter}
+}
+
+// filteredProcessor only hands samples of unfiltered series to the processor
+type filteredProcessor struct {
+	processor federation.Processor
+	filter    ingestFilter
+}
+
+// ProcessMetric drops samples of filtered series and delegates the others
+func (f *filteredProcessor) ProcessMetric(sample *models.MetricSample) error {
+	if action := f.filter.IngestAction(sample.Name, sample.Labels); action != models.IngestActionProcess {
+		metrics.RecordIngestFilteredSamples(action, 1)
+		return nil
+	}
+	return f.processor.ProcessMetric(sample)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/marcotuna/adaptive-metrics/internal/api"
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// listener is an additional HTTP listener serving part of the endpoints,
+// with its own TLS and auth settings
+type listener struct {
+	name   string
+	cfg    config.ListenerConfig
+	router *mux.Router
+	server *http.Server
+}
+
+// newListener creates the listener of a config. It returns nil when no
+// address is set, and the endpoints are served by the main listener.
+func newListener(name string, cfg config.ListenerConfig, serverCfg config.ServerConfig) (*listener, error) {
+	if cfg.Address == "" {
+		return nil, nil
+	}
+	if err := validateTLS(name, cfg.TLS); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("%s listener: %w", name, err)
+	}
+
+	router := mux.NewRouter()
+	return &listener{
+		name:   name,
+		cfg:    cfg,
+		router: router,
+		server: &http.Server{
+			Addr:         cfg.Address,
+			Handler:      api.AuthMiddleware(cfg.Auth)(router),
+			TLSConfig:    tlsConfig,
+			ReadTimeout:  time.Duration(serverCfg.ReadTimeoutSeconds) * time.Second,
+			WriteTimeout: time.Duration(serverCfg.WriteTimeoutSeconds) * time.Second,
+			IdleTimeout:  time.Duration(serverCfg.IdleTimeoutSeconds) * time.Second,
+		},
+	}, nil
+}
+
+// validateListeners checks that the listeners use distinct addresses, which
+// also differ from the gRPC address sharding peers are served on
+func validateListeners(mainAddress string, listeners []*listener, sharding config.ShardingConfig) error {
+	addresses := map[string]string{mainAddress: "main"}
+	for _, l := range listeners {
+		if other, exists := addresses[l.cfg.Address]; exists {
+			return fmt.Errorf("%s listener address %s is already used by the %s listener", l.name, l.cfg.Address, other)
+		}
+		addresses[l.cfg.Address] = l.name
+	}
+	if other, exists := addresses[sharding.ListenAddress]; exists && sharding.Enabled {
+		return fmt.Errorf("sharding.listen_address %s is already used by the %s listener; peers are served over gRPC on their own address", sharding.ListenAddress, other)
+	}
+	return nil
+}
+
+// listeners returns the additional listeners that are configured
+func (s *Server) listeners() []*listener {
+	var listeners []*listener
+	for _, l := range []*listener{s.ingest, s.metrics, s.redirect} {
+		if l != nil {
+			listeners = append(listeners, l)
+		}
+	}
+	return listeners
+}
+
+// routerFor returns the router serving the endpoints of a listener: its own,
+// or the main router when it is not configured
+func (s *Server) routerFor(l *listener) *mux.Router {
+	if l == nil {
+		return s.router
+	}
+	return l.router
+}
+
+// serve binds the listener and serves it in the background
+func (l *listener) serve() error {
+	ln, err := net.Listen("tcp", l.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("%s listener: %w", l.name, err)
+	}
+	go func() {
+		var err error
+		if l.server.TLSConfig != nil {
+			// The certificate comes from the TLS config, which reloads it
+			err = l.server.ServeTLS(ln, "", "")
+		} else {
+			err = l.server.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.LogErrorWithFields("Listener failed", logger.Fields{
+				"listener": l.name,
+				"address":  l.cfg.Address,
+				"error":    err.Error(),
+			})
+		}
+	}()
+	logger.LogInfoWithFields("Serving "+l.name+" endpoints", logger.Fields{
+		"address": l.cfg.Address,
+		"tls":     l.server.TLSConfig != nil,
+	})
+	return nil
+}
+
+// shutdown stops the listener once its in-flight requests finish, or drops
+// their connections when the context ends first
+func (l *listener) shutdown(ctx context.Context) error {
+	if err := l.server.Shutdown(ctx); err != nil {
+		l.server.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+)
+
+func TestValidateListeners(t *testing.T) {
+	ingest := &listener{name: "ingest", cfg: config.ListenerConfig{Address: ":9090"}}
+	sharding := config.ShardingConfig{Enabled: true, ListenAddress: ":9095"}
+
+	if err := validateListeners(":8080", []*listener{ingest}, sharding); err != nil {
+		t.Errorf("validateListeners() with distinct addresses error = %v", err)
+	}
+	if err := validateListeners(":9090", []*listener{ingest}, sharding); err == nil {
+		t.Error("validateListeners() should reject listeners sharing an address")
+	}
+
+	// Peers are not served by the ingest listener
+	sharding.ListenAddress = ":9090"
+	if err := validateListeners(":8080", []*listener{ingest}, sharding); err == nil {
+		t.Error("validateListeners() should reject sharding peers on the ingest address")
+	}
+	sharding.Enabled = false
+	if err := validateListeners(":8080", []*listener{ingest}, sharding); err != nil {
+		t.Errorf("validateListeners() with sharding disabled error = %v", err)
+	}
+}
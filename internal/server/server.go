@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -12,7 +13,16 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/marcotuna/adaptive-metrics/internal/api"
 	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/controlplane"
+	"github.com/marcotuna/adaptive-metrics/internal/gitops"
+	"github.com/marcotuna/adaptive-metrics/internal/memory"
+	"github.com/marcotuna/adaptive-metrics/internal/plugin"
+	"github.com/marcotuna/adaptive-metrics/internal/profiling"
+	"github.com/marcotuna/adaptive-metrics/internal/tracing"
 	"github.com/marcotuna/adaptive-metrics/internal/types"
+	"github.com/marcotuna/adaptive-metrics/pkg/federation"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/web"
 )
 
 // FileServer is a convenient wrapper for http.FileServer
@@ -58,11 +68,48 @@ type Server struct {
 	router     *mux.Router
 	apiHandler types.MetricTracker
 	processor  types.MetricProcessor
+	// federation scrapes Prometheus servers as an input when enabled
+	federation *federation.Scraper
+	// grafanaSync imports recommendations from Grafana when enabled
+	grafanaSync *plugin.RecommendationSync
+	// ruleSync syncs rules with the Grafana plugin when enabled
+	ruleSync *plugin.RuleSync
+	// gitSync syncs rules with a Git repository when enabled
+	gitSync *gitops.Syncer
+	// publisher serves the rules to edge instances and poller replaces them
+	// with those of a central instance, when enabled
+	publisher *controlplane.Publisher
+	poller    *controlplane.Poller
+	// shutdownTracing flushes pending spans
+	shutdownTracing func(context.Context) error
+	// stopProfiling stops the profiling listener and the push of profiles
+	stopProfiling func(context.Context) error
+	// ingest and metrics serve remote write and /metrics apart from the
+	// management API when configured; nil when served by the main listener
+	ingest  *listener
+	metrics *listener
+	// redirect redirects plain HTTP requests to the main listener when it
+	// serves HTTPS
+	redirect *listener
+	// dumpSignals receives SIGQUIT, which writes a debug dump
+	dumpSignals chan os.Signal
 }
 
 // New creates a new server instance
 func New(cfg *config.Config) (*Server, error) {
 	router := mux.NewRouter()
+	// Set up tracing before any component creates spans
+	shutdownTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		return nil, err
+	}
+	stopProfiling, err := profiling.Start(cfg.Profiling)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := memory.Apply(cfg.Memory); err != nil {
+		return nil, err
+	}
 	// Create API handler using our factory
 	apiHandler, err := createMetricTracker(cfg)
 	if err != nil {
@@ -76,6 +123,66 @@ func New(cfg *config.Config) (*Server, error) {
 	// Connect the processor to the API handler
 	apiHandler.SetProcessor(processor)
 
+	var scraper *federation.Scraper
+	if cfg.Federation.Enabled {
+		scraper, err = federation.NewScraper(&cfg.Federation, createFederationInput(processor, apiHandler.GetRuleEngine()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize federation: %w", err)
+		}
+	}
+
+	var grafanaSync *plugin.RecommendationSync
+	if cfg.Plugin.Enabled && cfg.Plugin.SyncIntervalSeconds > 0 {
+		grafanaSync = plugin.NewRecommendationSync(
+			plugin.NewClient(&cfg.Plugin),
+			apiHandler.GetRecommendationStore(),
+			time.Duration(cfg.Plugin.SyncIntervalSeconds)*time.Second,
+		)
+	}
+
+	var ruleSync *plugin.RuleSync
+	if cfg.Plugin.Enabled && cfg.Plugin.RuleSync.Enabled {
+		store, ok := apiHandler.GetRuleEngine().(plugin.RuleStore)
+		if !ok {
+			return nil, fmt.Errorf("rule engine does not support rule sync")
+		}
+		ruleSync, err = plugin.NewRuleSync(cfg.Plugin.RuleSync, store, plugin.NewClient(&cfg.Plugin))
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize rule sync: %w", err)
+		}
+	}
+
+	var gitSync *gitops.Syncer
+	if cfg.GitOps.Enabled {
+		store, ok := apiHandler.GetRuleEngine().(gitops.RuleStore)
+		if !ok {
+			return nil, fmt.Errorf("rule engine does not support gitops")
+		}
+		gitSync, err = gitops.NewSyncer(cfg.GitOps, store)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize gitops: %w", err)
+		}
+	}
+
+	var publisher *controlplane.Publisher
+	var poller *controlplane.Poller
+	if cfg.ControlPlane.Serve.Enabled || cfg.ControlPlane.Poll.Enabled {
+		store, ok := apiHandler.GetRuleEngine().(controlplane.Store)
+		if !ok {
+			return nil, fmt.Errorf("rule engine does not support the control plane")
+		}
+		if cfg.ControlPlane.Serve.Enabled {
+			if publisher, err = controlplane.NewPublisher(cfg.ControlPlane.Serve, store); err != nil {
+				return nil, fmt.Errorf("failed to initialize the control plane: %w", err)
+			}
+		}
+		if cfg.ControlPlane.Poll.Enabled {
+			if poller, err = controlplane.NewPoller(cfg.ControlPlane.Poll, store); err != nil {
+				return nil, fmt.Errorf("failed to initialize control plane polling: %w", err)
+			}
+		}
+	}
+
 	// Construct the address with the configured port
 	address := cfg.Server.Address
 	// If Address doesn't contain a port (like ":8080") but we have a port set,
@@ -88,42 +195,154 @@ func New(cfg *config.Config) (*Server, error) {
 		address = fmt.Sprintf("%s:%d", address, cfg.Server.Port)
 	}
 
+	if err := validateTLS("main", cfg.Server.TLS); err != nil {
+		return nil, err
+	}
+	if cfg.Server.RedirectHTTPAddress != "" && cfg.Server.TLS.CertFile == "" {
+		return nil, fmt.Errorf("redirect_http_address needs TLS on the main listener")
+	}
+	tlsConfig, err := newTLSConfig(cfg.Server.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("main listener: %w", err)
+	}
+	ingest, err := newListener("ingest", cfg.Server.Ingest, cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	metricsListener, err := newListener("metrics", cfg.Server.Metrics, cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	redirect, err := newListener("redirect", config.ListenerConfig{Address: cfg.Server.RedirectHTTPAddress}, cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+
 	srv := &Server{
-		cfg:        cfg,
-		router:     router,
-		apiHandler: apiHandler,
-		processor:  processor,
+		cfg:             cfg,
+		router:          router,
+		apiHandler:      apiHandler,
+		processor:       processor,
+		federation:      scraper,
+		grafanaSync:     grafanaSync,
+		ruleSync:        ruleSync,
+		gitSync:         gitSync,
+		publisher:       publisher,
+		poller:          poller,
+		shutdownTracing: shutdownTracing,
+		stopProfiling:   stopProfiling,
+		ingest:          ingest,
+		metrics:         metricsListener,
+		redirect:        redirect,
 		httpServer: &http.Server{
 			Addr:         address,
-			Handler:      router,
+			Handler:      api.AuthMiddleware(cfg.Server.Auth)(router),
+			TLSConfig:    tlsConfig,
 			ReadTimeout:  time.Duration(cfg.Server.ReadTimeoutSeconds) * time.Second,
 			WriteTimeout: time.Duration(cfg.Server.WriteTimeoutSeconds) * time.Second,
+			IdleTimeout:  time.Duration(cfg.Server.IdleTimeoutSeconds) * time.Second,
 		},
 	}
+	if err := validateListeners(address, srv.listeners(), cfg.Sharding); err != nil {
+		return nil, err
+	}
 	srv.setupRoutes()
 	return srv, nil
 }
 
+// useMiddleware sets up the middleware shared by the routers of all listeners
+func (s *Server) useMiddleware(router *mux.Router) {
+	// Record latency, status codes and access logs of all routes
+	router.Use(api.RequestMiddleware(s.cfg.Logging.AccessLog))
+	// Per-client request rate limits
+	if s.cfg.Server.RateLimit.Enabled {
+		router.Use(api.NewRequestLimiter(s.cfg.Server.RateLimit, s.cfg.Ingest).Middleware)
+	}
+	// Response compression and protobuf encoding
+	if s.cfg.Server.Compression {
+		router.Use(api.CompressionMiddleware)
+	}
+	router.Use(api.NegotiationMiddleware)
+	// Apply CORS middleware to all routes
+	router.Use(api.CORSMiddleware)
+}
+
 // setupRoutes configures the server routes
 func (s *Server) setupRoutes() {
-	// Apply CORS middleware to all routes
-	s.router.Use(api.CORSMiddleware)
+	s.useMiddleware(s.router)
+	// Additional listeners answer health checks too, for their own probes
+	for _, l := range s.listeners() {
+		s.useMiddleware(l.router)
+		l.router.HandleFunc("/health", s.apiHandler.HealthCheck).Methods(http.MethodGet, http.MethodOptions)
+		l.router.HandleFunc("/ready", s.apiHandler.ReadinessCheck).Methods(http.MethodGet, http.MethodOptions)
+	}
+	if s.redirect != nil {
+		s.redirect.router.PathPrefix("/").Handler(httpsRedirect(s.httpServer.Addr))
+	}
 
 	// API endpoints - match Grafana's API structure
 	apiRouter := s.router.PathPrefix("/api/v1").Subrouter()
-	// Rules management
+	// Rules management; bulk export and import come first so that their
+	// paths are not taken for rule IDs
+	s.apiHandler.SetupRuleBundleRoutes(apiRouter)
+	s.apiHandler.SetupLintRoutes(apiRouter)
+	apiRouter.HandleFunc("/rules/catch-all", s.apiHandler.ListCatchAllRules).Methods(http.MethodGet, http.MethodOptions)
 	apiRouter.HandleFunc("/rules", s.apiHandler.ListRules).Methods(http.MethodGet, http.MethodOptions)
 	apiRouter.HandleFunc("/rules", s.apiHandler.CreateRule).Methods(http.MethodPost, http.MethodOptions)
 	apiRouter.HandleFunc("/rules/{id}", s.apiHandler.GetRule).Methods(http.MethodGet, http.MethodOptions)
 	apiRouter.HandleFunc("/rules/{id}", s.apiHandler.UpdateRule).Methods(http.MethodPut, http.MethodOptions)
 	apiRouter.HandleFunc("/rules/{id}", s.apiHandler.DeleteRule).Methods(http.MethodDelete, http.MethodOptions)
+	apiRouter.HandleFunc("/rules/{id}/rollout", s.apiHandler.UpdateRuleRollout).Methods(http.MethodPut, http.MethodOptions)
+	// Rule revisions, diffs and restores
+	s.apiHandler.SetupRuleHistoryRoutes(apiRouter)
+	// Rule templates and groups
+	s.apiHandler.SetupTemplateRoutes(apiRouter)
+	// WASM transforms applied by rules
+	s.apiHandler.SetupTransformRoutes(apiRouter)
+	s.apiHandler.SetupCatalogRoutes(apiRouter)
+	s.apiHandler.SetupRuleGroupRoutes(apiRouter)
+	// Protected metrics and labels
+	s.apiHandler.SetupProtectionRoutes(apiRouter)
+	s.apiHandler.SetupIngestFilterRoutes(apiRouter)
+	// Rule ownership and per-team savings
+	s.apiHandler.SetupOwnershipRoutes(apiRouter)
+	// Savings reports for chargeback
+	s.apiHandler.SetupReportRoutes(apiRouter)
+	// Replay of historical data through rules
+	s.apiHandler.SetupBackfillRoutes(apiRouter)
 	// Kubernetes monitor generation for rules
 	apiRouter.HandleFunc("/rules/{id}/kubernetes-monitor", s.apiHandler.KubernetesMonitor).Methods(http.MethodGet, http.MethodOptions)
 	apiRouter.HandleFunc("/rules/{id}/kubernetes-monitor", s.apiHandler.SaveKubernetesMonitor).Methods(http.MethodPost, http.MethodOptions)
+	apiRouter.HandleFunc("/rules/{id}/kubernetes-alerts", s.apiHandler.KubernetesAlerts).Methods(http.MethodGet, http.MethodOptions)
+	s.apiHandler.SetupKubernetesRoutes(apiRouter)
+	// Rule sync with the Grafana plugin
+	if s.ruleSync != nil {
+		api.NewRuleSyncHandler(s.ruleSync).SetupRoutes(apiRouter)
+	}
+	// Rule sync with a Git repository
+	if s.gitSync != nil {
+		api.NewGitOpsHandler(s.gitSync).SetupRoutes(apiRouter)
+	}
+	// Bundles served to and polled from a control plane
+	if s.publisher != nil || s.poller != nil {
+		api.NewControlPlaneHandler(s.publisher, s.poller).SetupRoutes(apiRouter)
+	}
 	// Setup recommendation routes using the new handler
 	s.apiHandler.SetupRecommendationRoutes(apiRouter)
+	// Prometheus-compatible label and series discovery for Grafana
+	s.apiHandler.SetupDiscoveryRoutes(apiRouter)
 	// Prometheus remote_write endpoint
-	s.router.HandleFunc("/api/v1/write", s.apiHandler.PrometheusRemoteWrite).Methods(http.MethodPost, http.MethodOptions)
+	s.routerFor(s.ingest).HandleFunc("/api/v1/write", s.apiHandler.PrometheusRemoteWrite).Methods(http.MethodPost, http.MethodOptions)
+	s.routerFor(s.ingest).HandleFunc("/api/v1/ingest", s.apiHandler.AgentIngest).Methods(http.MethodPost, http.MethodOptions)
+	s.routerFor(s.ingest).HandleFunc("/api/v1/ingest/cloudwatch", s.apiHandler.CloudWatchIngest).Methods(http.MethodPost, http.MethodOptions)
+	// Pushgateway-compatible push of batch jobs
+	s.routerFor(s.ingest).PathPrefix("/metrics/job").HandlerFunc(s.apiHandler.PushgatewayPush).Methods(http.MethodPut, http.MethodPost)
+	s.routerFor(s.ingest).PathPrefix("/metrics/job").HandlerFunc(s.apiHandler.PushgatewayDelete).Methods(http.MethodDelete)
+	s.router.HandleFunc("/api/v1/status/buildinfo", s.apiHandler.BuildInfo).Methods(http.MethodGet, http.MethodOptions)
+	// Effective config and runtime state for debugging
+	s.apiHandler.SetupStatusRoutes(apiRouter)
+	// Open aggregation buckets
+	s.apiHandler.SetupDebugRoutes(apiRouter)
 	// Metrics operations
 	apiRouter.HandleFunc("/metrics/analyze", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -135,9 +354,15 @@ func (s *Server) setupRoutes() {
 		w.Write([]byte(`{"enabled":false}`))
 	}).Methods(http.MethodGet, http.MethodOptions)
 
+	// Runtime profiles, unless served on their own listener
+	if s.cfg.Profiling.Enabled && s.cfg.Profiling.Address == "" {
+		s.router.PathPrefix("/debug/pprof/").Handler(profiling.Handler())
+	}
+
 	// Health and metrics
 	s.router.HandleFunc("/health", s.apiHandler.HealthCheck).Methods(http.MethodGet, http.MethodOptions)
-	s.router.HandleFunc("/metrics", s.apiHandler.Metrics).Methods(http.MethodGet, http.MethodOptions)
+	s.router.HandleFunc("/ready", s.apiHandler.ReadinessCheck).Methods(http.MethodGet, http.MethodOptions)
+	s.routerFor(s.metrics).HandleFunc("/metrics", s.apiHandler.Metrics).Methods(http.MethodGet, http.MethodOptions)
 
 	// Add a custom 404 handler for API routes
 	s.router.PathPrefix("/api").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -147,23 +372,160 @@ func (s *Server) setupRoutes() {
 	})
 
 	// File server for serving the web UI - only use index fallback for frontend routes
-	webUIPath := filepath.Join(s.cfg.Server.WebUIPath)
-	fileServer := NewFileServer(http.Dir(webUIPath), true)
+	fileServer := NewFileServer(s.webUI(), true)
 	s.router.PathPrefix("/").Handler(fileServer)
 }
 
+// webUI returns the filesystem the web UI is served from. A built UI in the
+// configured directory takes precedence, so it can be replaced without
+// rebuilding; otherwise the UI embedded in the binary is used.
+func (s *Server) webUI() http.FileSystem {
+	webUIPath := filepath.Join(s.cfg.Server.WebUIPath)
+	if _, err := os.Stat(filepath.Join(webUIPath, "index.html")); err == nil {
+		return http.Dir(webUIPath)
+	}
+	if ui := web.UI(); ui != nil {
+		return http.FS(ui)
+	}
+	return http.Dir(webUIPath)
+}
+
 // Start starts the server and processors
 func (s *Server) Start() error {
+	s.watchDumpSignal()
 	// Start the metric processor
 	s.processor.Start()
+	if s.federation != nil {
+		s.federation.Start()
+	}
+	if s.grafanaSync != nil {
+		s.grafanaSync.Start()
+	}
+	if s.ruleSync != nil {
+		s.ruleSync.Start()
+	}
+	if s.gitSync != nil {
+		s.gitSync.Start()
+	}
+	if s.poller != nil {
+		s.poller.Start()
+	}
+	s.apiHandler.StartBackgroundJobs()
+	for _, l := range s.listeners() {
+		if err := l.serve(); err != nil {
+			return err
+		}
+	}
+	if s.httpServer.TLSConfig != nil {
+		// The certificate comes from the TLS config, which reloads it
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
 	return s.httpServer.ListenAndServe()
 }
 
-// Stop gracefully shuts down the server
+// Stop gracefully shuts down the server in order: remote write requests are
+// rejected and the in-flight ones drained, the other inputs and background
+// jobs stopped, the processor drained and flushed to remote write, and then
+// the HTTP server closed once the remaining requests finish. The whole
+// sequence is bounded by the shutdown timeout.
 func (s *Server) Stop() error {
-	// Stop the processor first
-	s.processor.Stop()
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	timeout := time.Duration(s.cfg.Server.ShutdownTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	return s.httpServer.Shutdown(ctx)
+	logger.LogInfoWithFields("Shutting down", logger.Fields{
+		"timeout": timeout.String(),
+	})
+
+	// Clients must not reuse connections to an instance going away
+	s.httpServer.SetKeepAlivesEnabled(false)
+	for _, l := range s.listeners() {
+		l.server.SetKeepAlivesEnabled(false)
+	}
+
+	err := shutdownStep(ctx, "Stopping ingestion", func() error {
+		if s.federation != nil {
+			s.federation.Stop()
+		}
+		return s.apiHandler.StopIngest(ctx)
+	})
+	if err == nil {
+		err = shutdownStep(ctx, "Stopping background jobs", func() error {
+			if s.poller != nil {
+				s.poller.Stop()
+			}
+			if s.gitSync != nil {
+				s.gitSync.Stop()
+			}
+			if s.ruleSync != nil {
+				s.ruleSync.Stop()
+			}
+			if s.grafanaSync != nil {
+				s.grafanaSync.Stop()
+			}
+			s.apiHandler.StopBackgroundJobs()
+			s.stopDumpSignal()
+			return nil
+		})
+	}
+	if err == nil {
+		err = shutdownStep(ctx, "Draining processor and remote write", func() error {
+			s.processor.Stop()
+			return nil
+		})
+	}
+
+	if serr := shutdownStep(ctx, "Stopping HTTP server", func() error {
+		for _, l := range s.listeners() {
+			if err := l.shutdown(ctx); err != nil {
+				return err
+			}
+		}
+		return s.httpServer.Shutdown(ctx)
+	}); serr != nil {
+		// Drop the connections of requests that did not finish in time
+		s.httpServer.Close()
+		if err == nil {
+			err = serr
+		}
+	}
+	if perr := s.stopProfiling(ctx); perr != nil && err == nil {
+		err = perr
+	}
+	if terr := s.shutdownTracing(ctx); terr != nil && err == nil {
+		err = terr
+	}
+	return err
+}
+
+// shutdownStep runs a step of the shutdown sequence, logging its progress,
+// and gives up waiting for it when the shutdown deadline passes
+func shutdownStep(ctx context.Context, name string, step func() error) error {
+	start := time.Now()
+	logger.LogInfo(name)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- step()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		logger.LogErrorWithFields(name+" failed", logger.Fields{
+			"duration": time.Since(start).String(),
+			"error":    err.Error(),
+		})
+		return fmt.Errorf("%s: %w", strings.ToLower(name), err)
+	}
+	logger.LogInfoWithFields(name+" done", logger.Fields{
+		"duration": time.Since(start).String(),
+	})
+	return nil
 }
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// certReloadCheckInterval is how often the certificate files are checked
+// for changes, at most, during TLS handshakes
+const certReloadCheckInterval = 10 * time.Second
+
+// certificateReloader serves a certificate read from files and reloads it
+// when the files change, so rotated certificates are picked up without a
+// restart
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // Latest modification of the loaded files
+	checked time.Time // Last check for changes
+}
+
+// newCertificateReloader loads the certificate of a listener
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first when the
+// files changed since the last check
+func (c *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := time.Now(); now.Sub(c.checked) >= certReloadCheckInterval {
+		if err := c.reload(now); err != nil {
+			// Keep serving the previous certificate until the files are fixed
+			logger.LogWarnWithFields("Failed to reload TLS certificate", logger.Fields{
+				"cert_file": c.certFile,
+				"error":     err.Error(),
+			})
+		}
+	}
+	return c.cert, nil
+}
+
+// reload loads the certificate if its files changed since it was last
+// loaded; callers hold the lock
+func (c *certificateReloader) reload(now time.Time) error {
+	c.checked = now
+
+	var modTime time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	if c.cert != nil {
+		logger.LogInfoWithFields("Reloaded TLS certificate", logger.Fields{
+			"cert_file": c.certFile,
+		})
+	}
+	c.cert, c.modTime = &cert, modTime
+	return nil
+}
+
+// newTLSConfig returns the TLS config of a listener, or nil when it serves
+// plain HTTP
+func newTLSConfig(cfg config.ListenerTLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	reloader, err := newCertificateReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// validateTLS checks that the TLS settings of a listener are complete
+func validateTLS(name string, cfg config.ListenerTLSConfig) error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return fmt.Errorf("%s listener TLS needs both cert_file and key_file", name)
+	}
+	if cfg.ClientCAFile != "" && cfg.CertFile == "" {
+		return fmt.Errorf("%s listener TLS client_ca_file needs a certificate", name)
+	}
+	return nil
+}
+
+// httpsRedirect redirects plain HTTP requests to the same path on the HTTPS
+// listener at address
+func httpsRedirect(address string) http.Handler {
+	_, port, _ := net.SplitHostPort(address)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for a common name
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for file, block := range files {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	now := time.Now()
+	writeCertificate(t, certFile, keyFile, "first", now.Add(-time.Minute))
+
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertificateReloader() error = %v", err)
+	}
+	commonName := func() string {
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Subject.CommonName
+	}
+
+	// Rotated files are picked up once the check interval passed
+	writeCertificate(t, certFile, keyFile, "second", now)
+	if got := commonName(); got != "first" {
+		t.Errorf("certificate before the check interval = %s, want first", got)
+	}
+	reloader.checked = now.Add(-certReloadCheckInterval)
+	if got := commonName(); got != "second" {
+		t.Errorf("certificate after rotation = %s, want second", got)
+	}
+
+	// A broken rotation keeps the previous certificate
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(keyFile, now.Add(time.Minute), now.Add(time.Minute))
+	reloader.checked = now.Add(-certReloadCheckInterval)
+	if got := commonName(); got != "second" {
+		t.Errorf("certificate after a broken rotation = %s, want second", got)
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		address, host, want string
+	}{
+		{":8443", "metrics.example.com:8080", "https://metrics.example.com:8443/api/v1/rules?owner=a"},
+		{":443", "metrics.example.com", "https://metrics.example.com/api/v1/rules?owner=a"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/rules?owner=a", nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		httpsRedirect(tt.address).ServeHTTP(w, r)
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.want {
+			t.Errorf("redirect to %s = %d %s, want %s", tt.address, w.Code, w.Header().Get("Location"), tt.want)
+		}
+	}
+}
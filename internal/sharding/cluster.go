@@ -0,0 +1,189 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Cluster is the local instance of a sharded deployment. It serves the gRPC
+// service peers forward samples and gossip to, and forwards the samples the
+// local instance does not own.
+type Cluster struct {
+	ring       *Ring
+	forwarder  *Forwarder
+	membership *membership
+	peers      *peerConns
+	server     *grpc.Server
+	listener   net.Listener
+	secret     string
+	tls        *peerTLS
+	receive    func(*models.MetricSample) error
+}
+
+// NewCluster creates the local instance of a sharded deployment and listens
+// for peers. Samples forwarded by peers are passed to receive.
+func NewCluster(cfg config.ShardingConfig, receive func(*models.MetricSample) error) (*Cluster, error) {
+	if err := validate(cfg); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for sharding peers: %w", err)
+	}
+	c, err := newCluster(cfg, listener, receive)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// validate checks a sharding configuration
+func validate(cfg config.ShardingConfig) error {
+	if cfg.InstanceAddress == "" {
+		return fmt.Errorf("sharding.instance_address is required")
+	}
+	if cfg.Secret == "" && cfg.TLS.ClientCAFile == "" {
+		return fmt.Errorf("sharding.secret or sharding.tls.client_ca_file is required, so that peers authenticate each other")
+	}
+	if cfg.Membership != MembershipStatic && cfg.Membership != MembershipGossip {
+		return fmt.Errorf("invalid sharding.membership %q: must be %s or %s", cfg.Membership, MembershipStatic, MembershipGossip)
+	}
+	return nil
+}
+
+// newCluster creates the local instance serving peers on a listener
+func newCluster(cfg config.ShardingConfig, listener net.Listener, receive func(*models.MetricSample) error) (*Cluster, error) {
+	// With gossip the ring starts with the local instance alone, and grows
+	// as the other members are heard of
+	members := cfg.Peers
+	if cfg.Membership == MembershipGossip {
+		members = []string{cfg.InstanceAddress}
+	}
+	ring, err := NewRing(cfg.InstanceAddress, members, cfg.VirtualNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	peerTLS, err := newPeerTLS(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	options := []grpc.ServerOption{grpc.ForceServerCodec(codec{})}
+	if peerTLS != nil {
+		options = append(options, grpc.Creds(peerTLS.serverCredentials()))
+	}
+
+	peers := newPeerConns(cfg.Secret, peerTLS)
+	c := &Cluster{
+		ring:      ring,
+		forwarder: newForwarder(peers, cfg.ForwardBatchSize, time.Duration(cfg.ForwardTimeoutSeconds)*time.Second),
+		peers:     peers,
+		server:    grpc.NewServer(options...),
+		listener:  listener,
+		secret:    cfg.Secret,
+		tls:       peerTLS,
+		receive:   receive,
+	}
+	if cfg.Membership == MembershipGossip {
+		c.membership = newMembership(cfg.InstanceAddress, cfg.Peers, ring, peers,
+			time.Duration(cfg.GossipIntervalSeconds)*time.Second,
+			time.Duration(cfg.MemberTimeoutSeconds)*time.Second)
+	}
+	c.server.RegisterService(&serviceDesc, c)
+	return c, nil
+}
+
+// Start serves peers and starts gossiping
+func (c *Cluster) Start() {
+	go func() {
+		if err := c.server.Serve(c.listener); err != nil {
+			logger.LogErrorWithFields("Sharding peer service stopped", logger.Fields{
+				"error": err.Error(),
+			})
+		}
+	}()
+	if c.membership != nil {
+		c.membership.start()
+	}
+}
+
+// StopReceiving stops serving peers, waiting for the samples they are
+// forwarding to be received
+func (c *Cluster) StopReceiving() {
+	c.server.GracefulStop()
+}
+
+// Stop stops gossiping and flushes the samples pending for peers
+func (c *Cluster) Stop() {
+	c.server.GracefulStop()
+	if c.membership != nil {
+		c.membership.stop()
+	}
+	c.forwarder.Stop()
+	c.peers.close()
+}
+
+// Owner returns the member owning a group hash
+func (c *Cluster) Owner(hash uint64) string {
+	return c.ring.Owner(hash)
+}
+
+// Self returns the address of the local instance
+func (c *Cluster) Self() string {
+	return c.ring.Self()
+}
+
+// Forward queues a sample for its owner. It returns false if the sample was
+// dropped because the queue of the owner is full.
+func (c *Cluster) Forward(owner string, sample *models.MetricSample) bool {
+	return c.forwarder.Forward(owner, sample)
+}
+
+// authenticate checks that a call comes from a peer, which presented a
+// certificate of the CAs with mutual TLS, or otherwise knows the secret
+func (c *Cluster) authenticate(ctx context.Context) error {
+	if c.tls.mutual() && verifiedPeer(ctx) {
+		return nil
+	}
+	return authenticate(ctx, c.secret)
+}
+
+// forward receives the samples forwarded by a peer
+func (c *Cluster) forward(ctx context.Context, req *prompb.WriteRequest) error {
+	if err := c.authenticate(ctx); err != nil {
+		return err
+	}
+	samples := parseWriteRequest(req, metadataValue(ctx, tenantMetadata), metadataValue(ctx, temporalityMetadata))
+	for _, sample := range samples {
+		if err := c.receive(sample); err != nil {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+	}
+	return nil
+}
+
+// gossip merges the view of the members of a peer and replies with the
+// local one
+func (c *Cluster) gossip(ctx context.Context, state *gossipState) (*gossipState, error) {
+	if err := c.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	if c.membership == nil {
+		return nil, status.Error(codes.FailedPrecondition, "membership is static")
+	}
+	c.membership.merge(state, time.Now())
+	c.membership.mu.Lock()
+	defer c.membership.mu.Unlock()
+	return c.membership.state(), nil
+}
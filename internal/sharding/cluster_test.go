@@ -0,0 +1,197 @@
+package sharding
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// listen opens a listener on a free local port
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return listener
+}
+
+// startCluster starts the local instance serving peers on a listener
+func startCluster(t *testing.T, cfg config.ShardingConfig, listener net.Listener, receive func(*models.MetricSample) error) *Cluster {
+	t.Helper()
+	cfg.InstanceAddress = listener.Addr().String()
+	if cfg.Secret == "" && cfg.TLS.ClientCAFile == "" {
+		cfg.Secret = "secret"
+	}
+	if cfg.Membership == "" {
+		cfg.Membership = MembershipStatic
+	}
+	cfg.VirtualNodes = 16
+	cfg.ForwardTimeoutSeconds = 5
+	c, err := newCluster(cfg, listener, receive)
+	if err != nil {
+		t.Fatalf("newCluster() error = %v", err)
+	}
+	if c.membership != nil {
+		c.membership.interval = 20 * time.Millisecond
+		c.membership.timeout = 500 * time.Millisecond
+	}
+	c.Start()
+	return c
+}
+
+func TestNewCluster_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.ShardingConfig
+	}{
+		{"no instance address", config.ShardingConfig{Secret: "secret", Membership: MembershipStatic}},
+		{"no secret", config.ShardingConfig{InstanceAddress: "a:9095", Membership: MembershipStatic}},
+		{"unknown membership", config.ShardingConfig{InstanceAddress: "a:9095", Secret: "secret", Membership: "dns"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCluster(tt.cfg, nil); err == nil {
+				t.Error("NewCluster() should fail")
+			}
+		})
+	}
+}
+
+func TestCluster_Forward(t *testing.T) {
+	la, lb := listen(t), listen(t)
+	peers := []string{la.Addr().String(), lb.Addr().String()}
+
+	received := make(chan *models.MetricSample, 10)
+	a := startCluster(t, config.ShardingConfig{Peers: peers}, la, func(*models.MetricSample) error { return nil })
+	defer a.Stop()
+	b := startCluster(t, config.ShardingConfig{Peers: peers}, lb, func(sample *models.MetricSample) error {
+		received <- sample
+		return nil
+	})
+	defer b.Stop()
+
+	timestamp := time.UnixMilli(1700000000000)
+	a.Forward(b.Self(), &models.MetricSample{
+		Name:        "http_requests_total",
+		Value:       42,
+		Timestamp:   timestamp,
+		Labels:      map[string]string{"service": "api"},
+		Temporality: models.TemporalityCumulative,
+		Tenant:      "team-a",
+		Rules:       []string{"by-service", "by-method"},
+	})
+	a.forwarder.Stop()
+
+	select {
+	case sample := <-received:
+		want := &models.MetricSample{
+			Name:        "http_requests_total",
+			Value:       42,
+			Timestamp:   timestamp,
+			Labels:      map[string]string{"service": "api"},
+			Temporality: models.TemporalityCumulative,
+			Tenant:      "team-a",
+			Rules:       []string{"by-service", "by-method"},
+			Forwarded:   true,
+		}
+		if !reflect.DeepEqual(sample, want) {
+			t.Errorf("received %+v, want %+v", sample, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarded sample was not received")
+	}
+}
+
+func TestCluster_RejectsUnauthenticatedPeers(t *testing.T) {
+	listener := listen(t)
+	c := startCluster(t, config.ShardingConfig{Membership: MembershipGossip}, listener, func(*models.MetricSample) error {
+		t.Error("sample of an unauthenticated peer was received")
+		return nil
+	})
+	defer c.Stop()
+
+	for _, secret := range []string{"wrong", ""} {
+		peers := newPeerConns(secret, nil)
+		conn, err := peers.get(c.Self())
+		if err != nil {
+			t.Fatalf("get() error = %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req := buildWriteRequest([]*models.MetricSample{{Name: "up", Value: 1, Timestamp: time.Now(), Rules: []string{"up"}}})
+		if err := conn.Invoke(ctx, forwardMethod, req, &ack{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("forwarding with secret %q: error = %v, want Unauthenticated", secret, err)
+		}
+		state := &gossipState{Members: map[string]uint64{"intruder:9095": 1}}
+		if err := conn.Invoke(ctx, gossipMethod, state, &gossipState{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("gossip with secret %q: error = %v, want Unauthenticated", secret, err)
+		}
+		cancel()
+		peers.close()
+	}
+	if members := c.ring.Members(); len(members) != 1 {
+		t.Errorf("ring members = %v, want only the local instance", members)
+	}
+}
+
+func TestParseWriteRequest_WithoutRules(t *testing.T) {
+	req := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}}
+	if samples := parseWriteRequest(req, "", ""); len(samples) != 0 {
+		t.Errorf("parseWriteRequest() = %d samples, want series without rules to be skipped", len(samples))
+	}
+}
+
+func TestCluster_Gossip(t *testing.T) {
+	listeners := []net.Listener{listen(t), listen(t), listen(t)}
+	seed := []string{listeners[0].Addr().String()}
+
+	clusters := make([]*Cluster, len(listeners))
+	for i, listener := range listeners {
+		clusters[i] = startCluster(t, config.ShardingConfig{Membership: MembershipGossip, Peers: seed}, listener,
+			func(*models.MetricSample) error { return nil })
+	}
+	defer func() {
+		for _, c := range clusters[:2] {
+			c.Stop()
+		}
+	}()
+
+	// waitMembers waits for the rings of instances to have a number of members
+	waitMembers := func(instances []*Cluster, want int) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for _, c := range instances {
+			for len(c.ring.Members()) != want {
+				if time.Now().After(deadline) {
+					t.Fatalf("ring of %s has members %v, want %d", c.Self(), c.ring.Members(), want)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+
+	// Instances joining through the same seed discover each other
+	waitMembers(clusters, 3)
+
+	// A stopped instance is removed once it is no longer heard of
+	clusters[2].Stop()
+	waitMembers(clusters[:2], 2)
+	for _, c := range clusters[:2] {
+		for _, member := range c.ring.Members() {
+			if member == clusters[2].Self() {
+				t.Errorf("ring of %s still has the stopped instance", c.Self())
+			}
+		}
+	}
+}
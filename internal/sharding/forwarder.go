@@ -0,0 +1,283 @@
+package sharding
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// Sampled logs for failed forwarding
+var forwardFailedLog = logger.NewSampler(logger.Error, "Failed to forward samples to peer")
+
+// Forwarder sends samples owned by other instances to them over gRPC, as
+// Prometheus remote write requests. Each peer has its own queue and worker,
+// so a slow peer does not delay forwarding to the others.
+type Forwarder struct {
+	peers     *peerConns
+	batchSize int
+	timeout   time.Duration
+	queues    map[string]chan *models.MetricSample
+	mu        sync.Mutex
+	done      chan struct{}
+	wg        sync.WaitGroup
+	stopped   bool
+}
+
+// batchKey groups the samples sent in one request, which carries the tenant
+// and temporality of all of them
+type batchKey struct {
+	tenant      string
+	temporality string
+}
+
+// newForwarder creates a new forwarder
+func newForwarder(peers *peerConns, batchSize int, timeout time.Duration) *Forwarder {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	return &Forwarder{
+		peers:     peers,
+		batchSize: batchSize,
+		timeout:   timeout,
+		queues:    make(map[string]chan *models.MetricSample),
+		done:      make(chan struct{}),
+	}
+}
+
+// Stop flushes pending samples and stops all peer workers
+func (f *Forwarder) Stop() {
+	f.mu.Lock()
+	if f.stopped {
+		f.mu.Unlock()
+		return
+	}
+	f.stopped = true
+	close(f.done)
+	f.mu.Unlock()
+
+	f.wg.Wait()
+}
+
+// Forward queues a sample for the given peer. It returns false if the sample
+// was dropped because the peer queue is full or the forwarder is stopped.
+func (f *Forwarder) Forward(peer string, sample *models.MetricSample) bool {
+	f.mu.Lock()
+	if f.stopped {
+		f.mu.Unlock()
+		return false
+	}
+	queue, exists := f.queues[peer]
+	if !exists {
+		queue = make(chan *models.MetricSample, f.batchSize*2)
+		f.queues[peer] = queue
+		f.wg.Add(1)
+		go f.worker(peer, queue)
+	}
+	f.mu.Unlock()
+
+	select {
+	case queue <- sample:
+		return true
+	default:
+		return false
+	}
+}
+
+// worker batches samples for one peer and sends them
+func (f *Forwarder) worker(peer string, queue chan *models.MetricSample) {
+	defer f.wg.Done()
+
+	batch := make([]*models.MetricSample, 0, f.batchSize)
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		batches := make(map[batchKey][]*models.MetricSample)
+		for _, sample := range batch {
+			key := batchKey{tenant: sample.Tenant, temporality: sample.Temporality}
+			batches[key] = append(batches[key], sample)
+		}
+		for key, samples := range batches {
+			if err := f.send(peer, key, samples); err != nil {
+				forwardFailedLog.Log(logger.Fields{
+					"peer":    peer,
+					"samples": len(samples),
+					"error":   err.Error(),
+				})
+			}
+		}
+		batch = make([]*models.MetricSample, 0, f.batchSize)
+	}
+
+	for {
+		select {
+		case <-f.done:
+			// Drain what is already queued before exiting
+			for {
+				select {
+				case sample := <-queue:
+					batch = append(batch, sample)
+				default:
+					flush()
+					return
+				}
+			}
+		case sample := <-queue:
+			batch = append(batch, sample)
+			if len(batch) >= f.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// send writes a batch of samples to a peer
+func (f *Forwarder) send(peer string, key batchKey, samples []*models.MetricSample) error {
+	conn, err := f.peers.get(peer)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		tenantMetadata, key.tenant,
+		temporalityMetadata, key.temporality,
+	)
+
+	if err := conn.Invoke(ctx, forwardMethod, buildWriteRequest(samples), &ack{}); err != nil {
+		return callError(peer, "forwarding", err)
+	}
+	return nil
+}
+
+// buildWriteRequest converts samples to a Prometheus write request, with the
+// rules of each sample in a reserved label
+func buildWriteRequest(samples []*models.MetricSample) *prompb.WriteRequest {
+	request := &prompb.WriteRequest{
+		Timeseries: make([]prompb.TimeSeries, 0, len(samples)),
+	}
+
+	for _, sample := range samples {
+		labels := make([]prompb.Label, 0, len(sample.Labels)+2)
+		labels = append(labels, prompb.Label{Name: "__name__", Value: sample.Name})
+		labels = append(labels, prompb.Label{Name: rulesLabel, Value: strings.Join(sample.Rules, "\n")})
+		for k, v := range sample.Labels {
+			labels = append(labels, prompb.Label{Name: k, Value: v})
+		}
+
+		request.Timeseries = append(request.Timeseries, prompb.TimeSeries{
+			Labels: labels,
+			Samples: []prompb.Sample{{
+				Value:     sample.Value,
+				Timestamp: sample.Timestamp.UnixNano() / int64(time.Millisecond),
+			}},
+		})
+	}
+
+	return request
+}
+
+// parseWriteRequest converts a forwarded write request back to samples
+func parseWriteRequest(req *prompb.WriteRequest, tenant, temporality string) []*models.MetricSample {
+	var samples []*models.MetricSample
+	for _, ts := range req.Timeseries {
+		name, rules := "", ""
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			switch l.Name {
+			case "__name__":
+				name = l.Value
+			case rulesLabel:
+				rules = l.Value
+			default:
+				labels[l.Name] = l.Value
+			}
+		}
+		if name == "" || rules == "" {
+			continue
+		}
+
+		for _, s := range ts.Samples {
+			samples = append(samples, &models.MetricSample{
+				Name:        name,
+				Value:       s.Value,
+				Timestamp:   time.Unix(0, s.Timestamp*int64(time.Millisecond)),
+				Labels:      labels,
+				Temporality: temporality,
+				Tenant:      tenant,
+				Rules:       strings.Split(rules, "\n"),
+				Forwarded:   true,
+			})
+		}
+	}
+	return samples
+}
+
+// peerConns are the client connections to peers, shared by forwarding and
+// gossip
+type peerConns struct {
+	options []grpc.DialOption
+	conns   map[string]*grpc.ClientConn
+	mu      sync.Mutex
+}
+
+// newPeerConns creates the connections to peers, authenticated with a
+// secret, a client certificate, or both. Without TLS settings peers are
+// connected to in plain text.
+func newPeerConns(secret string, peerTLS *peerTLS) *peerConns {
+	options := []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{}))}
+	if peerTLS != nil {
+		options = append(options, grpc.WithTransportCredentials(peerTLS.clientCredentials()))
+	} else {
+		options = append(options, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if secret != "" {
+		options = append(options, grpc.WithPerRPCCredentials(secretCredentials(secret)))
+	}
+	return &peerConns{
+		options: options,
+		conns:   make(map[string]*grpc.ClientConn),
+	}
+}
+
+// get returns the connection to a peer, which connects lazily
+func (p *peerConns) get(peer string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, exists := p.conns[peer]; exists {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(peer, p.options...)
+	if err != nil {
+		return nil, callError(peer, "connecting", err)
+	}
+	p.conns[peer] = conn
+	return conn, nil
+}
+
+// close closes the connections to all peers
+func (p *peerConns) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for peer, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, peer)
+	}
+}
@@ -0,0 +1,234 @@
+package sharding
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+)
+
+// Sampled logs for failed gossip rounds
+var gossipFailedLog = logger.NewSampler(logger.Warn, "Failed to gossip with peer")
+
+// Membership kinds
+const (
+	// MembershipStatic uses the configured peers as the ring members
+	MembershipStatic = "static"
+	// MembershipGossip discovers the ring members by gossip, starting from
+	// the configured peers
+	MembershipGossip = "gossip"
+)
+
+// membership maintains the ring members by gossip. Every instance increases
+// its own heartbeat periodically and exchanges the heartbeats it knows with
+// a random peer; members whose heartbeat stops increasing are removed from
+// the ring once the member timeout passes.
+//
+// This is deliberately not hashicorp/memberlist: memberlist runs its own TCP
+// and UDP listeners with their own encryption keys, while gossip here is a
+// method of the peer gRPC service, so it shares the one sharding listener,
+// its TLS and its secret or client certificate authentication. The ring only
+// needs eventually consistent membership, for which heartbeats are enough;
+// the failure detection of SWIM, with indirect probes and suspicion, is not
+// worth a second transport and its dependencies.
+type membership struct {
+	self     string
+	seeds    []string
+	ring     *Ring
+	peers    *peerConns
+	interval time.Duration
+	timeout  time.Duration
+
+	mu        sync.Mutex
+	heartbeat uint64
+	members   map[string]*member
+	// Heartbeats of removed members, which are only added back once they
+	// are heard of with a newer one
+	removed map[string]uint64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// member is a ring member as last heard of
+type member struct {
+	heartbeat uint64
+	seen      time.Time
+}
+
+// newMembership creates the gossip membership of the local instance
+func newMembership(self string, seeds []string, ring *Ring, peers *peerConns, interval, timeout time.Duration) *membership {
+	return &membership{
+		self:     self,
+		seeds:    seeds,
+		ring:     ring,
+		peers:    peers,
+		interval: interval,
+		timeout:  timeout,
+		// Heartbeats start from the current time, so that a restarted
+		// instance is not taken for a stale view of its previous run
+		heartbeat: uint64(time.Now().UnixNano()),
+		members:   make(map[string]*member),
+		removed:   make(map[string]uint64),
+		done:      make(chan struct{}),
+	}
+}
+
+// start gossips periodically until stop
+func (m *membership) start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+				m.round(time.Now())
+			}
+		}
+	}()
+}
+
+// stop stops gossiping
+func (m *membership) stop() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+// round exchanges the known heartbeats with a random peer and removes the
+// members that timed out
+func (m *membership) round(now time.Time) {
+	m.mu.Lock()
+	m.heartbeat++
+	state := m.state()
+	target := m.target()
+	m.mu.Unlock()
+
+	if target != "" {
+		if err := m.exchange(target, state, now); err != nil {
+			gossipFailedLog.Log(logger.Fields{
+				"peer":  target,
+				"error": err.Error(),
+			})
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for name, known := range m.members {
+		if now.Sub(known.seen) > m.timeout {
+			delete(m.members, name)
+			m.removed[name] = known.heartbeat
+			changed = true
+		}
+	}
+	if changed {
+		m.updateRing()
+	}
+}
+
+// exchange sends the known heartbeats to a peer and merges its reply
+func (m *membership) exchange(peer string, state *gossipState, now time.Time) error {
+	conn, err := m.peers.get(peer)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+	defer cancel()
+
+	reply := &gossipState{}
+	if err := conn.Invoke(ctx, gossipMethod, state, reply); err != nil {
+		return callError(peer, "gossip", err)
+	}
+	m.merge(reply, now)
+	return nil
+}
+
+// merge records the newer heartbeats of a peer's view
+func (m *membership) merge(state *gossipState, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changed := false
+	for name, heartbeat := range state.Members {
+		if name == m.self || name == "" {
+			continue
+		}
+		if removed, exists := m.removed[name]; exists {
+			if heartbeat <= removed {
+				continue
+			}
+			delete(m.removed, name)
+		}
+		known, exists := m.members[name]
+		if !exists {
+			m.members[name] = &member{heartbeat: heartbeat, seen: now}
+			changed = true
+		} else if heartbeat > known.heartbeat {
+			known.heartbeat, known.seen = heartbeat, now
+		}
+	}
+	if changed {
+		m.updateRing()
+	}
+}
+
+// state returns the known heartbeats, including the local one. It is called
+// with mu held.
+func (m *membership) state() *gossipState {
+	state := &gossipState{Members: make(map[string]uint64, len(m.members)+1)}
+	state.Members[m.self] = m.heartbeat
+	for name, known := range m.members {
+		state.Members[name] = known.heartbeat
+	}
+	return state
+}
+
+// target picks a random member or seed to gossip with. It is called with mu
+// held.
+func (m *membership) target() string {
+	candidates := make(map[string]bool, len(m.members)+len(m.seeds))
+	for name := range m.members {
+		candidates[name] = true
+	}
+	for _, seed := range m.seeds {
+		candidates[seed] = true
+	}
+	delete(candidates, m.self)
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(candidates))
+	for name := range candidates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names[rand.Intn(len(names))]
+}
+
+// updateRing sets the ring members to the live members. It is called with mu
+// held.
+func (m *membership) updateRing() {
+	members := make([]string, 0, len(m.members)+1)
+	members = append(members, m.self)
+	for name := range m.members {
+		members = append(members, name)
+	}
+	if err := m.ring.SetMembers(members); err != nil {
+		logger.LogErrorWithFields("Failed to update the ring members", logger.Fields{
+			"error": err.Error(),
+		})
+		return
+	}
+	logger.LogInfoWithFields("Ring members changed", logger.Fields{
+		"members": m.ring.Members(),
+	})
+}
@@ -0,0 +1,156 @@
+// Package sharding distributes the output series of rules across adaptive
+// metrics instances, so that each instance aggregates only the series it
+// owns.
+package sharding
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Ring is a consistent hash ring that maps group hashes to instances.
+// Each member is placed on the ring several times (virtual nodes) so that
+// series are spread evenly and only a fraction of them move when
+// membership changes.
+type Ring struct {
+	mu           sync.RWMutex
+	self         string
+	members      []string
+	tokens       []token
+	virtualNodes int
+}
+
+// token is a position on the ring owned by a member
+type token struct {
+	hash   uint64
+	member string
+}
+
+// NewRing creates a ring for the given members. self identifies the local
+// instance and must be one of the members.
+func NewRing(self string, members []string, virtualNodes int) (*Ring, error) {
+	if virtualNodes <= 0 {
+		virtualNodes = 128
+	}
+
+	r := &Ring{
+		self:         self,
+		virtualNodes: virtualNodes,
+	}
+	if err := r.SetMembers(members); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// SetMembers replaces the ring membership
+func (r *Ring) SetMembers(members []string) error {
+	unique := make(map[string]bool, len(members))
+	for _, member := range members {
+		if member == "" {
+			return fmt.Errorf("ring member cannot be empty")
+		}
+		unique[member] = true
+	}
+	if !unique[r.self] {
+		return fmt.Errorf("local instance %q is not a ring member", r.self)
+	}
+
+	sorted := make([]string, 0, len(unique))
+	for member := range unique {
+		sorted = append(sorted, member)
+	}
+	sort.Strings(sorted)
+
+	tokens := make([]token, 0, len(sorted)*r.virtualNodes)
+	for _, member := range sorted {
+		for i := 0; i < r.virtualNodes; i++ {
+			tokens = append(tokens, token{
+				hash:   xxhash.Sum64String(member + "#" + strconv.Itoa(i)),
+				member: member,
+			})
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].hash < tokens[j].hash
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.members = sorted
+	r.tokens = tokens
+
+	return nil
+}
+
+// Members returns the ring members in sorted order
+func (r *Ring) Members() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string(nil), r.members...)
+}
+
+// Self returns the identifier of the local instance
+func (r *Ring) Self() string {
+	return r.self
+}
+
+// Owner returns the member owning the given group hash: the first token
+// clockwise from the hash
+func (r *Ring) Owner(hash uint64) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := sort.Search(len(r.tokens), func(i int) bool {
+		return r.tokens[i].hash >= hash
+	})
+	if i == len(r.tokens) {
+		i = 0
+	}
+
+	return r.tokens[i].member
+}
+
+// Owns reports whether the local instance owns the given group hash
+func (r *Ring) Owns(hash uint64) bool {
+	return r.Owner(hash) == r.self
+}
+
+// SeriesHash returns a stable hash of a series, independent of label order
+func SeriesHash(name string, labels map[string]string) uint64 {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := xxhash.New()
+	h.WriteString(name)
+	for _, k := range keys {
+		h.Write([]byte{0xff})
+		h.WriteString(k)
+		h.Write([]byte{0xfe})
+		h.WriteString(labels[k])
+	}
+
+	return h.Sum64()
+}
+
+// GroupHash returns a stable hash of an output series of a rule, identified
+// by its grouping key. All samples aggregated into the same output series
+// hash to the same instance, so that no two instances write partial
+// aggregates of it.
+func GroupHash(ruleID, groupKey string) uint64 {
+	h := xxhash.New()
+	h.WriteString(ruleID)
+	h.Write([]byte{0xff})
+	h.WriteString(groupKey)
+
+	return h.Sum64()
+}
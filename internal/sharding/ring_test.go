@@ -0,0 +1,74 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing_Distribution(t *testing.T) {
+	members := []string{"a:9095", "b:9095", "c:9095"}
+	ring, err := NewRing("a:9095", members, 128)
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 30000; i++ {
+		hash := GroupHash("http-requests", fmt.Sprintf("[id=%d]", i))
+		counts[ring.Owner(hash)]++
+	}
+
+	// Each member should own roughly a third of the groups
+	for _, member := range members {
+		if counts[member] < 7000 || counts[member] > 13000 {
+			t.Errorf("member %s owns %d of 30000 groups, want roughly 10000", member, counts[member])
+		}
+	}
+}
+
+func TestRing_SetMembers(t *testing.T) {
+	ring, err := NewRing("a:9095", []string{"a:9095", "b:9095"}, 64)
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+
+	// Adding a member only moves groups to the new member
+	before := make(map[uint64]string)
+	for i := 0; i < 1000; i++ {
+		hash := GroupHash("up", fmt.Sprintf("[instance=%d]", i))
+		before[hash] = ring.Owner(hash)
+	}
+	if err := ring.SetMembers([]string{"a:9095", "b:9095", "c:9095"}); err != nil {
+		t.Fatalf("SetMembers() error = %v", err)
+	}
+	for hash, owner := range before {
+		if now := ring.Owner(hash); now != owner && now != "c:9095" {
+			t.Errorf("group moved from %s to %s, want only moves to the new member", owner, now)
+		}
+	}
+
+	if err := ring.SetMembers([]string{"b:9095"}); err == nil {
+		t.Error("SetMembers() without the local instance should fail")
+	}
+}
+
+func TestGroupHash(t *testing.T) {
+	if GroupHash("up", "[job=api]") != GroupHash("up", "[job=api]") {
+		t.Error("GroupHash() should be stable")
+	}
+	if GroupHash("a", "bc") == GroupHash("ab", "c") {
+		t.Error("GroupHash() should separate rule IDs from grouping keys")
+	}
+}
+
+func TestSeriesHash_LabelOrder(t *testing.T) {
+	a := SeriesHash("up", map[string]string{"job": "api", "instance": "1"})
+	b := SeriesHash("up", map[string]string{"instance": "1", "job": "api"})
+	if a != b {
+		t.Error("SeriesHash() should not depend on label order")
+	}
+
+	if SeriesHash("up", map[string]string{"a": "bc"}) == SeriesHash("up", map[string]string{"ab": "c"}) {
+		t.Error("SeriesHash() should separate label names from values")
+	}
+}
@@ -0,0 +1,109 @@
+package sharding
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// peerTLS holds the TLS settings of the connections between peers. The
+// certificate is read at every handshake, so a rotated certificate is used
+// by the next connection; connections between peers are long lived, so this
+// is rare.
+type peerTLS struct {
+	certFile string
+	keyFile  string
+	// cas verify the certificates of peers, both as clients and as servers,
+	// when mutual TLS is enabled
+	cas *x509.CertPool
+}
+
+// newPeerTLS loads the TLS settings of peers. It returns nil when peers
+// connect without TLS.
+func newPeerTLS(cfg config.ListenerTLSConfig) (*peerTLS, error) {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("sharding.tls needs both cert_file and key_file")
+	}
+	if cfg.CertFile == "" {
+		if cfg.ClientCAFile != "" {
+			return nil, fmt.Errorf("sharding.tls.client_ca_file needs a certificate")
+		}
+		return nil, nil
+	}
+
+	t := &peerTLS{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if _, err := t.certificate(); err != nil {
+		return nil, fmt.Errorf("failed to load sharding TLS certificate: %w", err)
+	}
+	if cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sharding client CA file: %w", err)
+		}
+		t.cas = x509.NewCertPool()
+		if !t.cas.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in sharding client CA file %s", cfg.ClientCAFile)
+		}
+	}
+	return t, nil
+}
+
+// certificate reads the certificate of the local instance
+func (t *peerTLS) certificate() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// mutual reports whether peers authenticate each other with certificates
+func (t *peerTLS) mutual() bool {
+	return t != nil && t.cas != nil
+}
+
+// serverCredentials returns the credentials of the peer service, which
+// requires a certificate of the CAs from clients with mutual TLS
+func (t *peerTLS) serverCredentials() credentials.TransportCredentials {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return t.certificate()
+		},
+	}
+	if t.cas != nil {
+		cfg.ClientCAs = t.cas
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(cfg)
+}
+
+// clientCredentials returns the credentials of the connections to peers,
+// which present the certificate of the local instance as client certificate
+// and verify peers with the CAs, or the system roots without mutual TLS
+func (t *peerTLS) clientCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    t.cas,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return t.certificate()
+		},
+	})
+}
+
+// verifiedPeer reports whether a call comes from a peer that presented a
+// certificate verified against the CAs
+func verifiedPeer(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(info.State.VerifiedChains) > 0
+}
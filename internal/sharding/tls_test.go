@@ -0,0 +1,171 @@
+package sharding
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA issues certificates for peers on the local address
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+// newTestCA creates a CA and writes its certificate to a file
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(dir, name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes a certificate for 127.0.0.1, usable by servers and clients
+func (ca *testCA) issue(t *testing.T, dir, name string) config.ListenerTLSConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.ListenerTLSConfig{
+		CertFile:     filepath.Join(dir, name+".crt"),
+		KeyFile:      filepath.Join(dir, name+".key"),
+		ClientCAFile: ca.file,
+	}
+	writePEM(t, cfg.CertFile, "CERTIFICATE", der)
+	writePEM(t, cfg.KeyFile, "EC PRIVATE KEY", keyDER)
+	return cfg
+}
+
+// pool returns a pool of the CA certificate
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCluster_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	la, lb := listen(t), listen(t)
+	peers := []string{la.Addr().String(), lb.Addr().String()}
+
+	// Peers authenticate with their certificates alone
+	received := make(chan *models.MetricSample, 10)
+	a := startCluster(t, config.ShardingConfig{Peers: peers, TLS: ca.issue(t, dir, "a")}, la,
+		func(*models.MetricSample) error { return nil })
+	defer a.Stop()
+	b := startCluster(t, config.ShardingConfig{Peers: peers, TLS: ca.issue(t, dir, "b")}, lb, func(sample *models.MetricSample) error {
+		received <- sample
+		return nil
+	})
+	defer b.Stop()
+
+	a.Forward(b.Self(), &models.MetricSample{Name: "up", Value: 1, Timestamp: time.Now(), Rules: []string{"up"}})
+	a.forwarder.Stop()
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sample forwarded with a client certificate was not received")
+	}
+
+	// Peers with a certificate of another CA, or without one, are rejected
+	otherCfg := newTestCA(t, dir, "other").issue(t, dir, "c")
+	other, err := tls.LoadX509KeyPair(otherCfg.CertFile, otherCfg.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := map[string]*tls.Config{
+		"a certificate of another CA": {RootCAs: ca.pool(), Certificates: []tls.Certificate{other}},
+		"no certificate":              {RootCAs: ca.pool()},
+	}
+	for name, client := range clients {
+		conn, err := grpc.NewClient(b.Self(),
+			grpc.WithTransportCredentials(credentials.NewTLS(client)),
+			grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})))
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req := buildWriteRequest([]*models.MetricSample{{Name: "up", Value: 1, Timestamp: time.Now(), Rules: []string{"up"}}})
+		if err := conn.Invoke(ctx, forwardMethod, req, &ack{}); err == nil {
+			t.Errorf("forwarding with %s succeeded, want it rejected", name)
+		}
+		cancel()
+		conn.Close()
+	}
+}
+
+func TestNewPeerTLS_Validate(t *testing.T) {
+	if _, err := newPeerTLS(config.ListenerTLSConfig{CertFile: "peer.crt"}); err == nil {
+		t.Error("newPeerTLS() without a key should fail")
+	}
+	if _, err := newPeerTLS(config.ListenerTLSConfig{ClientCAFile: "ca.pem"}); err == nil {
+		t.Error("newPeerTLS() with a CA but no certificate should fail")
+	}
+	if peerTLS, err := newPeerTLS(config.ListenerTLSConfig{}); peerTLS != nil || err != nil {
+		t.Errorf("newPeerTLS() without settings = %v, %v, want plain text", peerTLS, err)
+	}
+}
@@ -0,0 +1,154 @@
+package sharding
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata of the calls between peers
+const (
+	// secretMetadata holds the shared secret of the ring
+	secretMetadata = "authorization"
+	// tenantMetadata holds the tenant of the requests that delivered the
+	// forwarded samples
+	tenantMetadata = "x-adaptive-metrics-tenant"
+	// temporalityMetadata states the counter temporality of all series in a
+	// forwarded batch; it is empty for gauges
+	temporalityMetadata = "x-adaptive-metrics-temporality"
+)
+
+// rulesLabel lists the IDs of the rules a forwarded sample is aggregated by,
+// separated by newlines, which IDs cannot contain
+const rulesLabel = "__adaptive_metrics_rules__"
+
+// Full names of the methods of the peer service
+const (
+	forwardMethod = "/adaptivemetrics.sharding.Peer/Forward"
+	gossipMethod  = "/adaptivemetrics.sharding.Peer/Gossip"
+)
+
+// peerService handles the calls of peers
+type peerService interface {
+	forward(ctx context.Context, req *prompb.WriteRequest) error
+	gossip(ctx context.Context, state *gossipState) (*gossipState, error)
+}
+
+// serviceDesc describes the peer service. Messages are encoded by codec, so
+// that forwarded samples are sent as Prometheus remote write requests.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "adaptivemetrics.sharding.Peer",
+	HandlerType: (*peerService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Forward",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &prompb.WriteRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				if err := srv.(peerService).forward(ctx, req); err != nil {
+					return nil, err
+				}
+				return &ack{}, nil
+			},
+		},
+		{
+			MethodName: "Gossip",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				state := &gossipState{}
+				if err := dec(state); err != nil {
+					return nil, err
+				}
+				return srv.(peerService).gossip(ctx, state)
+			},
+		},
+	},
+	Metadata: "sharding",
+}
+
+// ack is the empty reply to forwarded samples
+type ack struct{}
+
+// gossipState is the view of the ring members an instance has: the latest
+// heartbeat of each member
+type gossipState struct {
+	Members map[string]uint64 `json:"members"`
+}
+
+// marshaler and unmarshaler are implemented by protobuf messages such as
+// Prometheus remote write requests
+type marshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type unmarshaler interface {
+	Unmarshal([]byte) error
+}
+
+// codec encodes protobuf messages as protobuf, and the other messages of the
+// peer service as JSON
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(marshaler); ok {
+		return m.Marshal()
+	}
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(unmarshaler); ok {
+		return m.Unmarshal(data)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return "adaptive-metrics"
+}
+
+// secretCredentials send the shared secret with every call
+type secretCredentials string
+
+func (s secretCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{secretMetadata: "Bearer " + string(s)}, nil
+}
+
+func (s secretCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// authenticate checks that a call comes from a peer knowing the shared secret
+func authenticate(ctx context.Context, secret string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get(secretMetadata) {
+		token, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "peer is not authenticated")
+}
+
+// metadataValue returns the first value of a metadata key of a call
+func metadataValue(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// callError describes the error of a call to a peer
+func callError(peer, method string, err error) error {
+	return fmt.Errorf("%s to %s failed: %w", method, peer, err)
+}
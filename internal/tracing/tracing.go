@@ -0,0 +1,87 @@
+// Package tracing configures OpenTelemetry tracing for the metrics pipeline.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans created by this service
+const instrumentationName = "github.com/marcotuna/adaptive-metrics"
+
+// MaxLinks bounds the number of span links recorded on a single span, so
+// that flushes of large buckets do not produce huge spans
+const MaxLinks = 32
+
+// Init installs the global tracer provider and propagator. When tracing is
+// disabled the global no-op provider is kept and spans cost almost nothing.
+// The returned function flushes pending spans and must be called on shutdown.
+func Init(cfg config.TracingConfig) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(cfg.Endpoint),
+	}
+	if cfg.URLPath != "" {
+		options = append(options, otlptracehttp.WithURLPath(cfg.URLPath))
+	}
+	if cfg.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer used for all spans of the service
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Links converts span contexts to span links, skipping invalid contexts and
+// keeping at most MaxLinks
+func Links(contexts []trace.SpanContext) []trace.Link {
+	links := make([]trace.Link, 0, min(len(contexts), MaxLinks))
+	for _, sc := range contexts {
+		if len(links) >= MaxLinks {
+			break
+		}
+		if sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+
+	return links
+}
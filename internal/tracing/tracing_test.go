@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInit_Disabled(t *testing.T) {
+	shutdown, err := Init(config.TracingConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+
+	// The global provider stays a no-op
+	_, span := Tracer().Start(context.Background(), "test")
+	defer span.End()
+	if span.SpanContext().IsValid() {
+		t.Error("spans should not be recorded when tracing is disabled")
+	}
+}
+
+func TestLinks(t *testing.T) {
+	contexts := []trace.SpanContext{{}} // Invalid contexts are skipped
+	for i := 0; i < MaxLinks+10; i++ {
+		contexts = append(contexts, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{byte(i + 1)},
+			TraceFlags: trace.FlagsSampled,
+		}))
+	}
+
+	links := Links(contexts)
+	if len(links) != MaxLinks {
+		t.Fatalf("Links() returned %d links, want %d", len(links), MaxLinks)
+	}
+	if links[0].SpanContext.SpanID() != (trace.SpanID{1}) {
+		t.Errorf("first link = %v, want the first valid span context", links[0].SpanContext.SpanID())
+	}
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -11,8 +12,18 @@ import (
 type MetricProcessor interface {
 	Start()
 	Stop()
-	ProcessMetric(sample *models.MetricSample)
-	GetOutputChannel() <-chan *models.AggregatedMetric
+	ProcessMetric(sample *models.MetricSample) error
+	// Subscribe returns a channel of the aggregated series of a rule, or of
+	// every rule for an empty rule ID, and a function ending the subscription
+	Subscribe(name, ruleID string, buffer int) (<-chan *models.AggregatedMetric, func())
+}
+
+// RecommendationStore defines the interface for storing recommendations
+type RecommendationStore interface {
+	AddRecommendation(rec models.Recommendation)
+	GetAllRecommendations() []models.Recommendation
+	UpdateRecommendation(rec models.Recommendation) bool
+	DeleteRecommendation(id string) bool
 }
 
 // MetricTracker defines the interface for tracking metrics and API operations
@@ -23,25 +34,65 @@ type MetricTracker interface {
 	// Rule management
 	GetRuleEngine() interface{}
 	ListRules(w http.ResponseWriter, r *http.Request)
+	ListCatchAllRules(w http.ResponseWriter, r *http.Request)
 	CreateRule(w http.ResponseWriter, r *http.Request)
 	GetRule(w http.ResponseWriter, r *http.Request)
 	UpdateRule(w http.ResponseWriter, r *http.Request)
 	DeleteRule(w http.ResponseWriter, r *http.Request)
+	UpdateRuleRollout(w http.ResponseWriter, r *http.Request)
+	SetupRuleBundleRoutes(router *mux.Router)
+	SetupLintRoutes(router *mux.Router)
+
+	// Rule templates and groups
+	SetupTemplateRoutes(router *mux.Router)
+	SetupTransformRoutes(router *mux.Router)
+	SetupCatalogRoutes(router *mux.Router)
+	SetupRuleGroupRoutes(router *mux.Router)
+	SetupProtectionRoutes(router *mux.Router)
+	SetupIngestFilterRoutes(router *mux.Router)
+	SetupOwnershipRoutes(router *mux.Router)
+	SetupReportRoutes(router *mux.Router)
+	SetupBackfillRoutes(router *mux.Router)
+	SetupRuleHistoryRoutes(router *mux.Router)
+	SetupStatusRoutes(router *mux.Router)
+	SetupDebugRoutes(router *mux.Router)
+	// WriteDebugDump writes a debug bundle of the process to a file and
+	// returns its path
+	WriteDebugDump() (string, error)
 
 	// Health and metrics
 	HealthCheck(w http.ResponseWriter, r *http.Request)
+	ReadinessCheck(w http.ResponseWriter, r *http.Request)
+	// StopIngest rejects new remote write requests and waits for the
+	// in-flight ones before shutdown
+	StopIngest(ctx context.Context) error
 	Metrics(w http.ResponseWriter, r *http.Request)
+	BuildInfo(w http.ResponseWriter, r *http.Request)
 
 	// Kubernetes monitors
 	KubernetesMonitor(w http.ResponseWriter, r *http.Request)
 	SaveKubernetesMonitor(w http.ResponseWriter, r *http.Request)
+	KubernetesAlerts(w http.ResponseWriter, r *http.Request)
+	SetupKubernetesRoutes(router *mux.Router)
 
 	// Remote write
 	PrometheusRemoteWrite(w http.ResponseWriter, r *http.Request)
+	AgentIngest(w http.ResponseWriter, r *http.Request)
+	CloudWatchIngest(w http.ResponseWriter, r *http.Request)
+	PushgatewayPush(w http.ResponseWriter, r *http.Request)
+	PushgatewayDelete(w http.ResponseWriter, r *http.Request)
 
 	// Recommendations
 	SetupRecommendationRoutes(router *mux.Router)
+	SetupDiscoveryRoutes(router *mux.Router)
 
 	// Processor management
 	SetProcessor(processor MetricProcessor)
+
+	// GetRecommendationStore returns the store of recommendations
+	GetRecommendationStore() RecommendationStore
+	// StartBackgroundJobs starts periodic jobs such as expiring stale
+	// recommendations, and StopBackgroundJobs stops them
+	StartBackgroundJobs()
+	StopBackgroundJobs()
 }
@@ -0,0 +1,297 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Opcodes with immediates or handled outside of the numeric instructions
+const (
+	opUnreachable  = 0x00
+	opNop          = 0x01
+	opBlock        = 0x02
+	opLoop         = 0x03
+	opIf           = 0x04
+	opElse         = 0x05
+	opEnd          = 0x0b
+	opBr           = 0x0c
+	opBrIf         = 0x0d
+	opBrTable      = 0x0e
+	opReturn       = 0x0f
+	opCall         = 0x10
+	opCallIndirect = 0x11
+	opDrop         = 0x1a
+	opSelect       = 0x1b
+	opSelectTyped  = 0x1c
+	opLocalGet     = 0x20
+	opLocalSet     = 0x21
+	opLocalTee     = 0x22
+	opGlobalGet    = 0x23
+	opGlobalSet    = 0x24
+	opI32Load      = 0x28
+	opI64Store32   = 0x3e
+	opMemorySize   = 0x3f
+	opMemoryGrow   = 0x40
+	opI32Const     = 0x41
+	opI64Const     = 0x42
+	opF32Const     = 0x43
+	opF64Const     = 0x44
+	opFirstNumeric = 0x45
+	opLastNumeric  = 0xc4
+	opPrefixFC     = 0xfc
+)
+
+// Sub-opcodes of 0xfc instructions
+const (
+	opTruncSatFirst = 0x00
+	opTruncSatLast  = 0x07
+	opMemoryInit    = 0x08
+	opDataDrop      = 0x09
+	opMemoryCopy    = 0x0a
+	opMemoryFill    = 0x0b
+)
+
+// instr is a decoded instruction. Blocks know the positions of their else and
+// end, so branches do not scan the code.
+type instr struct {
+	op  byte
+	sub byte // Sub-opcode of 0xfc instructions
+	// a holds the immediate: an index, a constant, the offset of a memory
+	// access or the default target of br_table
+	a uint64
+	// Blocks, loops and ifs: the number of parameters and results, and the
+	// positions of their else (or end, without else) and end
+	params, results int
+	els, end        int
+	targets         []uint32 // Targets of br_table
+}
+
+// compile decodes the instructions of a function body, checking the indices
+// it refers to
+func (m *Module) compile(r *reader, locals, funcs int) ([]instr, error) {
+	var code []instr
+	// Open blocks, the function body being the outermost
+	control := []int{-1}
+	for len(control) > 0 {
+		op, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		in := instr{op: op}
+		pos := len(code)
+
+		switch {
+		case op == opBlock || op == opLoop || op == opIf:
+			if in.params, in.results, err = m.blockType(r); err != nil {
+				return nil, err
+			}
+			control = append(control, pos)
+		case op == opElse:
+			open := control[len(control)-1]
+			if open < 0 || code[open].op != opIf || code[open].els != 0 {
+				return nil, fmt.Errorf("else outside of if")
+			}
+			code[open].els = pos
+		case op == opEnd:
+			if open := control[len(control)-1]; open >= 0 {
+				code[open].end = pos
+				if code[open].els == 0 {
+					code[open].els = pos
+				} else {
+					code[code[open].els].end = pos
+				}
+			}
+			control = control[:len(control)-1]
+		case op == opBr || op == opBrIf:
+			if in.a, err = r.uleb(32); err != nil {
+				return nil, err
+			}
+			if in.a >= uint64(len(control)) {
+				return nil, fmt.Errorf("branch depth %d out of range", in.a)
+			}
+		case op == opBrTable:
+			n, err := r.count()
+			if err != nil {
+				return nil, err
+			}
+			in.targets = make([]uint32, n)
+			for i := range in.targets {
+				if in.targets[i], err = r.u32(); err != nil {
+					return nil, err
+				}
+				if int(in.targets[i]) >= len(control) {
+					return nil, fmt.Errorf("branch depth %d out of range", in.targets[i])
+				}
+			}
+			if in.a, err = r.uleb(32); err != nil {
+				return nil, err
+			}
+			if in.a >= uint64(len(control)) {
+				return nil, fmt.Errorf("branch depth %d out of range", in.a)
+			}
+		case op == opCall:
+			if in.a, err = r.uleb(32); err != nil {
+				return nil, err
+			}
+			if in.a >= uint64(funcs) {
+				return nil, fmt.Errorf("function %d out of range", in.a)
+			}
+		case op == opCallIndirect:
+			if in.a, err = r.uleb(32); err != nil {
+				return nil, err
+			}
+			if in.a >= uint64(len(m.types)) {
+				return nil, fmt.Errorf("type %d out of range", in.a)
+			}
+			if table, err := r.u32(); err != nil || table != 0 || m.table == nil {
+				return nil, fmt.Errorf("call_indirect without a table")
+			}
+		case op == opSelectTyped:
+			n, err := r.count()
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < n; i++ {
+				if _, err := r.valueType(); err != nil {
+					return nil, err
+				}
+			}
+		case op >= opLocalGet && op <= opLocalTee:
+			if in.a, err = r.uleb(32); err != nil {
+				return nil, err
+			}
+			if in.a >= uint64(locals) {
+				return nil, fmt.Errorf("local %d out of range", in.a)
+			}
+		case op == opGlobalGet || op == opGlobalSet:
+			if in.a, err = r.uleb(32); err != nil {
+				return nil, err
+			}
+			if in.a >= uint64(len(m.globals)) {
+				return nil, fmt.Errorf("global %d out of range", in.a)
+			}
+			if op == opGlobalSet && !m.globals[in.a].mutable {
+				return nil, fmt.Errorf("global %d is immutable", in.a)
+			}
+		case op >= opI32Load && op <= opI64Store32:
+			if m.memory == nil {
+				return nil, fmt.Errorf("memory access without a memory")
+			}
+			if _, err := r.uleb(32); err != nil { // Alignment hint
+				return nil, err
+			}
+			if in.a, err = r.uleb(32); err != nil {
+				return nil, err
+			}
+		case op == opMemorySize || op == opMemoryGrow:
+			if m.memory == nil {
+				return nil, fmt.Errorf("memory instruction without a memory")
+			}
+			if mem, err := r.byte(); err != nil || mem != 0 {
+				return nil, fmt.Errorf("invalid memory index")
+			}
+		case op == opI32Const:
+			v, err := r.sleb(32)
+			if err != nil {
+				return nil, err
+			}
+			in.a = uint64(uint32(v))
+		case op == opI64Const:
+			v, err := r.sleb(64)
+			if err != nil {
+				return nil, err
+			}
+			in.a = uint64(v)
+		case op == opF32Const:
+			b, err := r.bytes(4)
+			if err != nil {
+				return nil, err
+			}
+			in.a = uint64(binary.LittleEndian.Uint32(b))
+		case op == opF64Const:
+			b, err := r.bytes(8)
+			if err != nil {
+				return nil, err
+			}
+			in.a = binary.LittleEndian.Uint64(b)
+		case op == opPrefixFC:
+			if err := m.compilePrefixFC(r, &in); err != nil {
+				return nil, err
+			}
+		case op == opUnreachable || op == opNop || op == opReturn || op == opDrop || op == opSelect:
+		case op >= opFirstNumeric && op <= opLastNumeric:
+		default:
+			return nil, fmt.Errorf("unsupported opcode 0x%x", op)
+		}
+		code = append(code, in)
+	}
+
+	if !r.done() {
+		return nil, fmt.Errorf("code after the end of the function")
+	}
+	return code, nil
+}
+
+// compilePrefixFC decodes a 0xfc instruction
+func (m *Module) compilePrefixFC(r *reader, in *instr) error {
+	sub, err := r.u32()
+	if err != nil {
+		return err
+	}
+	in.sub = byte(sub)
+	switch {
+	case sub <= opTruncSatLast:
+	case sub == opMemoryInit || sub == opDataDrop:
+		if in.a, err = r.uleb(32); err != nil {
+			return err
+		}
+		if in.a >= uint64(m.dataCount) {
+			return fmt.Errorf("data segment %d out of range", in.a)
+		}
+		if sub == opMemoryInit {
+			if mem, err := r.byte(); err != nil || mem != 0 || m.memory == nil {
+				return fmt.Errorf("memory.init without a memory")
+			}
+		}
+	case sub == opMemoryCopy:
+		dst, err := r.byte()
+		if err != nil {
+			return err
+		}
+		src, err := r.byte()
+		if err != nil || dst != 0 || src != 0 || m.memory == nil {
+			return fmt.Errorf("memory.copy without a memory")
+		}
+	case sub == opMemoryFill:
+		if mem, err := r.byte(); err != nil || mem != 0 || m.memory == nil {
+			return fmt.Errorf("memory.fill without a memory")
+		}
+	default:
+		return fmt.Errorf("unsupported opcode 0xfc 0x%x", sub)
+	}
+	return nil
+}
+
+// blockType decodes the type of a block, returning its number of parameters
+// and results
+func (m *Module) blockType(r *reader) (params, results int, err error) {
+	if r.pos < len(r.data) {
+		switch b := r.data[r.pos]; {
+		case b == 0x40:
+			r.pos++
+			return 0, 0, nil
+		case ValueType(b) == I32 || ValueType(b) == I64 || ValueType(b) == F32 || ValueType(b) == F64:
+			r.pos++
+			return 0, 1, nil
+		}
+	}
+	index, err := r.sleb(33)
+	if err != nil {
+		return 0, 0, err
+	}
+	if index < 0 || index >= int64(len(m.types)) {
+		return 0, 0, fmt.Errorf("invalid block type")
+	}
+	t := &m.types[index]
+	return len(t.Params), len(t.Results), nil
+}
@@ -0,0 +1,634 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Section IDs of the binary format
+const (
+	sectionCustom    = 0
+	sectionType      = 1
+	sectionImport    = 2
+	sectionFunction  = 3
+	sectionTable     = 4
+	sectionMemory    = 5
+	sectionGlobal    = 6
+	sectionExport    = 7
+	sectionStart     = 8
+	sectionElement   = 9
+	sectionCode      = 10
+	sectionData      = 11
+	sectionDataCount = 12
+)
+
+// Kinds of exports
+const (
+	exportFunc   = 0
+	exportTable  = 1
+	exportMemory = 2
+	exportGlobal = 3
+)
+
+// funcRef is the element type of tables
+const funcRef = 0x70
+
+// errUnexpectedEnd is returned when the binary ends in the middle of a value
+var errUnexpectedEnd = errors.New("unexpected end of module")
+
+// reader decodes the values of the binary format
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) done() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errUnexpectedEnd
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errUnexpectedEnd
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uleb reads an unsigned LEB128 value of at most bits bits
+func (r *reader) uleb(bits uint) (uint64, error) {
+	var result uint64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits {
+			return 0, fmt.Errorf("integer too large")
+		}
+		result |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if bits < 64 && result>>bits != 0 {
+				return 0, fmt.Errorf("integer too large")
+			}
+			return result, nil
+		}
+	}
+}
+
+// sleb reads a signed LEB128 value of at most bits bits
+func (r *reader) sleb(bits uint) (int64, error) {
+	var result int64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits {
+			return 0, fmt.Errorf("integer too large")
+		}
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			if bits < 64 && (result < -(1<<(bits-1)) || result >= 1<<(bits-1)) {
+				return 0, fmt.Errorf("integer too large")
+			}
+			return result, nil
+		}
+	}
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return uint32(v), err
+}
+
+// count reads the length of a vector, bounded by the remaining bytes so a
+// corrupt length cannot cause a huge allocation
+func (r *reader) count() (int, error) {
+	n, err := r.u32()
+	if err != nil {
+		return 0, err
+	}
+	if int(n) > len(r.data)-r.pos {
+		return 0, errUnexpectedEnd
+	}
+	return int(n), nil
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.count()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(n)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("invalid UTF-8 name")
+	}
+	return string(b), nil
+}
+
+func (r *reader) valueType() (ValueType, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch t := ValueType(b); t {
+	case I32, I64, F32, F64:
+		return t, nil
+	default:
+		return 0, fmt.Errorf("unsupported value type 0x%x", b)
+	}
+}
+
+func (r *reader) limits() (min uint32, max uint32, hasMax bool, err error) {
+	flag, err := r.byte()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if flag > 1 {
+		return 0, 0, false, fmt.Errorf("unsupported limits flag 0x%x", flag)
+	}
+	if min, err = r.u32(); err != nil {
+		return 0, 0, false, err
+	}
+	if flag == 1 {
+		if max, err = r.u32(); err != nil {
+			return 0, 0, false, err
+		}
+		if max < min {
+			return 0, 0, false, fmt.Errorf("limits maximum below minimum")
+		}
+		hasMax = true
+	}
+	return min, max, hasMax, nil
+}
+
+// constExpr reads a constant expression, such as the initial value of a
+// global or the offset of a segment
+func (r *reader) constExpr() (constExpr, error) {
+	var expr constExpr
+	op, err := r.byte()
+	if err != nil {
+		return expr, err
+	}
+	expr.op = op
+	switch op {
+	case opI32Const:
+		v, err := r.sleb(32)
+		if err != nil {
+			return expr, err
+		}
+		expr.value = uint64(uint32(v))
+	case opI64Const:
+		v, err := r.sleb(64)
+		if err != nil {
+			return expr, err
+		}
+		expr.value = uint64(v)
+	case opF32Const:
+		b, err := r.bytes(4)
+		if err != nil {
+			return expr, err
+		}
+		expr.value = uint64(binary.LittleEndian.Uint32(b))
+	case opF64Const:
+		b, err := r.bytes(8)
+		if err != nil {
+			return expr, err
+		}
+		expr.value = binary.LittleEndian.Uint64(b)
+	case opGlobalGet:
+		v, err := r.u32()
+		if err != nil {
+			return expr, err
+		}
+		expr.value = uint64(v)
+	default:
+		return expr, fmt.Errorf("unsupported constant expression opcode 0x%x", op)
+	}
+	if end, err := r.byte(); err != nil || end != opEnd {
+		return expr, fmt.Errorf("constant expression not terminated")
+	}
+	return expr, nil
+}
+
+// Compile decodes and compiles a module in the binary format. Modules may not
+// import anything: they can only compute over their own memory.
+func Compile(binary []byte) (*Module, error) {
+	r := &reader{data: binary}
+	header, err := r.bytes(8)
+	if err != nil || string(header[:4]) != "\x00asm" {
+		return nil, fmt.Errorf("not a WebAssembly module")
+	}
+	if header[4] != 1 || header[5] != 0 || header[6] != 0 || header[7] != 0 {
+		return nil, fmt.Errorf("unsupported WebAssembly version")
+	}
+
+	m := &Module{exports: make(map[string]export), start: -1}
+	var funcTypes []uint32
+	var lastID byte
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		body, _ := r.bytes(size)
+		if id != sectionCustom {
+			// Sections are in order, except the data count section which
+			// comes before the code section
+			order := id * 2
+			if id == sectionDataCount {
+				order = sectionCode*2 - 1
+			}
+			if order <= lastID {
+				return nil, fmt.Errorf("section %d out of order", id)
+			}
+			lastID = order
+		}
+
+		s := &reader{data: body}
+		switch id {
+		case sectionCustom:
+			continue
+		case sectionType:
+			err = m.decodeTypes(s)
+		case sectionImport:
+			err = fmt.Errorf("imports are not supported")
+		case sectionFunction:
+			funcTypes, err = decodeFunctions(s, len(m.types))
+		case sectionTable:
+			err = m.decodeTables(s)
+		case sectionMemory:
+			err = m.decodeMemories(s)
+		case sectionGlobal:
+			err = m.decodeGlobals(s)
+		case sectionExport:
+			err = m.decodeExports(s, len(funcTypes))
+		case sectionStart:
+			var start uint32
+			if start, err = s.u32(); err == nil {
+				if int(start) >= len(funcTypes) {
+					err = fmt.Errorf("start function %d out of range", start)
+				}
+				m.start = int(start)
+			}
+		case sectionElement:
+			err = m.decodeElements(s)
+		case sectionCode:
+			err = m.decodeCode(s, funcTypes)
+		case sectionData:
+			err = m.decodeData(s)
+		case sectionDataCount:
+			var count uint32
+			if count, err = s.u32(); err == nil {
+				m.dataCount = int(count)
+				m.hasDataCount = true
+			}
+		default:
+			err = fmt.Errorf("unknown section %d", id)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, err)
+		}
+		if !s.done() {
+			return nil, fmt.Errorf("section %d: unexpected trailing bytes", id)
+		}
+	}
+
+	if len(m.funcs) != len(funcTypes) {
+		return nil, fmt.Errorf("function and code sections do not match")
+	}
+	for _, seg := range m.elements {
+		for _, f := range seg.funcs {
+			if int(f) >= len(m.funcs) {
+				return nil, fmt.Errorf("element function %d out of range", f)
+			}
+		}
+	}
+	for _, g := range m.globals {
+		if g.init.op == opGlobalGet {
+			return nil, fmt.Errorf("globals initialized from imported globals are not supported")
+		}
+	}
+	return m, nil
+}
+
+func (m *Module) decodeTypes(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if form, err := r.byte(); err != nil || form != 0x60 {
+			return fmt.Errorf("invalid function type")
+		}
+		var t FuncType
+		for _, types := range []*[]ValueType{&t.Params, &t.Results} {
+			count, err := r.count()
+			if err != nil {
+				return err
+			}
+			for j := 0; j < count; j++ {
+				vt, err := r.valueType()
+				if err != nil {
+					return err
+				}
+				*types = append(*types, vt)
+			}
+		}
+		m.types = append(m.types, t)
+	}
+	return nil
+}
+
+func decodeFunctions(r *reader, types int) ([]uint32, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	funcTypes := make([]uint32, n)
+	for i := range funcTypes {
+		if funcTypes[i], err = r.u32(); err != nil {
+			return nil, err
+		}
+		if int(funcTypes[i]) >= types {
+			return nil, fmt.Errorf("function type %d out of range", funcTypes[i])
+		}
+	}
+	return funcTypes, nil
+}
+
+func (m *Module) decodeTables(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return fmt.Errorf("multiple tables are not supported")
+	}
+	if n == 1 {
+		if elem, err := r.byte(); err != nil || elem != funcRef {
+			return fmt.Errorf("unsupported table element type")
+		}
+		min, max, hasMax, err := r.limits()
+		if err != nil {
+			return err
+		}
+		m.table = &tableType{min: min, max: max, hasMax: hasMax}
+	}
+	return nil
+}
+
+func (m *Module) decodeMemories(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return fmt.Errorf("multiple memories are not supported")
+	}
+	if n == 1 {
+		min, max, hasMax, err := r.limits()
+		if err != nil {
+			return err
+		}
+		if min > maxPages || (hasMax && max > maxPages) {
+			return fmt.Errorf("memory size out of range")
+		}
+		m.memory = &memoryType{min: min, max: max, hasMax: hasMax}
+	}
+	return nil
+}
+
+func (m *Module) decodeGlobals(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		vt, err := r.valueType()
+		if err != nil {
+			return err
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if mut > 1 {
+			return fmt.Errorf("invalid global mutability")
+		}
+		init, err := r.constExpr()
+		if err != nil {
+			return err
+		}
+		m.globals = append(m.globals, globalType{valueType: vt, mutable: mut == 1, init: init})
+	}
+	return nil
+}
+
+func (m *Module) decodeExports(r *reader, funcs int) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		index, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if _, exists := m.exports[name]; exists {
+			return fmt.Errorf("duplicate export %s", name)
+		}
+		switch kind {
+		case exportFunc:
+			if int(index) >= funcs {
+				return fmt.Errorf("exported function %d out of range", index)
+			}
+		case exportTable, exportMemory, exportGlobal:
+		default:
+			return fmt.Errorf("invalid export kind %d", kind)
+		}
+		m.exports[name] = export{kind: kind, index: index}
+	}
+	return nil
+}
+
+func (m *Module) decodeElements(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		flag, err := r.u32()
+		if err != nil {
+			return err
+		}
+		// Only active segments of function indices are supported, the only
+		// kind emitted by compilers for indirect calls
+		switch flag {
+		case 0:
+		case 2:
+			if table, err := r.u32(); err != nil || table != 0 {
+				return fmt.Errorf("invalid element table")
+			}
+		default:
+			return fmt.Errorf("unsupported element segment kind %d", flag)
+		}
+		offset, err := r.constExpr()
+		if err != nil {
+			return err
+		}
+		if offset.op != opI32Const {
+			return fmt.Errorf("unsupported element offset")
+		}
+		if flag == 2 {
+			if kind, err := r.byte(); err != nil || kind != 0 {
+				return fmt.Errorf("invalid element kind")
+			}
+		}
+		count, err := r.count()
+		if err != nil {
+			return err
+		}
+		seg := elementSegment{offset: uint32(offset.value), funcs: make([]uint32, count)}
+		for j := range seg.funcs {
+			if seg.funcs[j], err = r.u32(); err != nil {
+				return err
+			}
+		}
+		m.elements = append(m.elements, seg)
+	}
+	return nil
+}
+
+func (m *Module) decodeCode(r *reader, funcTypes []uint32) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	if n != len(funcTypes) {
+		return fmt.Errorf("function and code sections do not match")
+	}
+	for i := 0; i < n; i++ {
+		size, err := r.count()
+		if err != nil {
+			return err
+		}
+		body, _ := r.bytes(size)
+		f := &function{typ: &m.types[funcTypes[i]]}
+		br := &reader{data: body}
+		groups, err := br.count()
+		if err != nil {
+			return err
+		}
+		locals := len(f.typ.Params)
+		for j := 0; j < groups; j++ {
+			count, err := br.u32()
+			if err != nil {
+				return err
+			}
+			if _, err := br.valueType(); err != nil {
+				return err
+			}
+			locals += int(count)
+			if locals > maxLocals {
+				return fmt.Errorf("function %d: too many locals", i)
+			}
+		}
+		f.locals = locals
+		if f.code, err = m.compile(br, locals, len(funcTypes)); err != nil {
+			return fmt.Errorf("function %d: %w", i, err)
+		}
+		if err := m.validate(f.typ, f.code, funcTypes); err != nil {
+			return fmt.Errorf("function %d: %w", i, err)
+		}
+		m.funcs = append(m.funcs, f)
+	}
+	return nil
+}
+
+func (m *Module) decodeData(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	if m.hasDataCount && n != m.dataCount {
+		return fmt.Errorf("data count and data sections do not match")
+	}
+	for i := 0; i < n; i++ {
+		flag, err := r.u32()
+		if err != nil {
+			return err
+		}
+		seg := dataSegment{}
+		switch flag {
+		case 0, 2:
+			if flag == 2 {
+				if mem, err := r.u32(); err != nil || mem != 0 {
+					return fmt.Errorf("invalid data memory")
+				}
+			}
+			offset, err := r.constExpr()
+			if err != nil {
+				return err
+			}
+			if offset.op != opI32Const {
+				return fmt.Errorf("unsupported data offset")
+			}
+			seg.active = true
+			seg.offset = uint32(offset.value)
+		case 1:
+		default:
+			return fmt.Errorf("invalid data segment kind %d", flag)
+		}
+		size, err := r.count()
+		if err != nil {
+			return err
+		}
+		if seg.data, err = r.bytes(size); err != nil {
+			return err
+		}
+		m.data = append(m.data, seg)
+	}
+	return nil
+}
+
+// f32 and f64 convert between floats and their stored bits
+func f32(v uint64) float32     { return math.Float32frombits(uint32(v)) }
+func f64(v uint64) float64     { return math.Float64frombits(v) }
+func f32bits(v float32) uint64 { return uint64(math.Float32bits(v)) }
+func f64bits(v float64) uint64 { return math.Float64bits(v) }
@@ -0,0 +1,287 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// label is an open block, loop or if
+type label struct {
+	cont   int  // Where execution continues after a branch to the label
+	arity  int  // Number of values carried by a branch to the label
+	height int  // Height of the stack below the values of the block
+	loop   bool // Branches to loops stay within the block
+}
+
+func (inst *Instance) push(v uint64) {
+	inst.stack = append(inst.stack, v)
+}
+
+func (inst *Instance) pop() uint64 {
+	v := inst.stack[len(inst.stack)-1]
+	inst.stack = inst.stack[:len(inst.stack)-1]
+	return v
+}
+
+// call calls a function with its arguments on the stack, leaving its results
+// in their place
+func (inst *Instance) call(index int) {
+	f := inst.module.funcs[index]
+	inst.depth++
+	if inst.depth > maxCallDepth {
+		trap("call stack exhausted")
+	}
+	// Locals are allocated and zeroed by the host, so they are bounded
+	// across the call stack and charged like instructions
+	inst.locals += f.locals
+	if inst.locals > inst.limits.MaxLocals {
+		trap("locals limit exceeded")
+	}
+	inst.fuel -= int64(f.locals)
+	if inst.fuel < 0 {
+		trap("instruction limit exceeded")
+	}
+
+	base := len(inst.stack) - len(f.typ.Params)
+	locals := make([]uint64, f.locals)
+	copy(locals, inst.stack[base:])
+	inst.stack = inst.stack[:base]
+
+	inst.exec(f, locals)
+
+	// Validation guarantees the results are on the stack
+	results := len(f.typ.Results)
+	copy(inst.stack[base:], inst.stack[len(inst.stack)-results:])
+	inst.stack = inst.stack[:base+results]
+	inst.depth--
+	inst.locals -= f.locals
+}
+
+// branch unwinds to the label depth blocks out, keeping the values it carries
+func (inst *Instance) branch(labels []label, depth int) ([]label, int) {
+	target := len(labels) - 1 - depth
+	l := labels[target]
+	top := len(inst.stack)
+	copy(inst.stack[l.height:], inst.stack[top-l.arity:])
+	inst.stack = inst.stack[:l.height+l.arity]
+	if l.loop {
+		return labels[:target+1], l.cont
+	}
+	return labels[:target], l.cont
+}
+
+// exec runs the code of a function
+func (inst *Instance) exec(f *function, locals []uint64) {
+	code := f.code
+	labels := make([]label, 1, 16)
+	labels[0] = label{cont: len(code), arity: len(f.typ.Results), height: len(inst.stack)}
+
+	for pc := 0; pc < len(code); {
+		in := &code[pc]
+		pc++
+		inst.fuel--
+		if inst.fuel < 0 {
+			trap("instruction limit exceeded")
+		}
+
+		switch op := in.op; {
+		case op >= opFirstNumeric && op <= opLastNumeric:
+			inst.numeric(op)
+
+		case op == opUnreachable:
+			trap("unreachable")
+		case op == opNop:
+		case op == opBlock:
+			labels = append(labels, label{cont: in.end + 1, arity: in.results, height: len(inst.stack) - in.params})
+		case op == opLoop:
+			labels = append(labels, label{cont: pc, arity: in.params, height: len(inst.stack) - in.params, loop: true})
+		case op == opIf:
+			cond := uint32(inst.pop())
+			labels = append(labels, label{cont: in.end + 1, arity: in.results, height: len(inst.stack) - in.params})
+			if cond == 0 {
+				if code[in.els].op == opElse {
+					pc = in.els + 1
+				} else {
+					pc = in.end
+				}
+			}
+		case op == opElse:
+			// End of the then branch
+			pc = in.end
+		case op == opEnd:
+			labels = labels[:len(labels)-1]
+		case op == opBr:
+			labels, pc = inst.branch(labels, int(in.a))
+		case op == opBrIf:
+			if uint32(inst.pop()) != 0 {
+				labels, pc = inst.branch(labels, int(in.a))
+			}
+		case op == opBrTable:
+			i := uint32(inst.pop())
+			depth := int(in.a)
+			if int(i) < len(in.targets) {
+				depth = int(in.targets[i])
+			}
+			labels, pc = inst.branch(labels, depth)
+		case op == opReturn:
+			labels, pc = inst.branch(labels, len(labels)-1)
+		case op == opCall:
+			inst.call(int(in.a))
+		case op == opCallIndirect:
+			i := uint32(inst.pop())
+			if int(i) >= len(inst.table) {
+				trap("undefined element")
+			}
+			callee := inst.table[i]
+			if callee < 0 {
+				trap("uninitialized element")
+			}
+			if !inst.module.funcs[callee].typ.equal(&inst.module.types[in.a]) {
+				trap("indirect call type mismatch")
+			}
+			inst.call(int(callee))
+
+		case op == opDrop:
+			inst.pop()
+		case op == opSelect || op == opSelectTyped:
+			cond := uint32(inst.pop())
+			b := inst.pop()
+			if cond == 0 {
+				inst.stack[len(inst.stack)-1] = b
+			}
+
+		case op == opLocalGet:
+			inst.push(locals[in.a])
+		case op == opLocalSet:
+			locals[in.a] = inst.pop()
+		case op == opLocalTee:
+			locals[in.a] = inst.stack[len(inst.stack)-1]
+		case op == opGlobalGet:
+			inst.push(inst.globals[in.a])
+		case op == opGlobalSet:
+			inst.globals[in.a] = inst.pop()
+
+		case op >= opI32Load && op <= opI64Store32:
+			inst.memoryAccess(op, in.a)
+		case op == opMemorySize:
+			inst.push(uint64(len(inst.memory) / PageSize))
+		case op == opMemoryGrow:
+			delta := uint32(inst.pop())
+			pages := uint32(len(inst.memory) / PageSize)
+			if uint64(pages)+uint64(delta) > uint64(inst.maxPages) {
+				inst.push(uint64(math.MaxUint32)) // -1
+			} else {
+				inst.memory = append(inst.memory, make([]byte, int(delta)*PageSize)...)
+				inst.push(uint64(pages))
+			}
+
+		case op == opI32Const || op == opI64Const || op == opF32Const || op == opF64Const:
+			inst.push(in.a)
+
+		case op == opPrefixFC:
+			inst.prefixFC(in)
+		}
+	}
+}
+
+// address returns the effective address of a memory access of size bytes,
+// trapping when it is out of bounds
+func (inst *Instance) address(offset uint64, size int) int {
+	addr := uint64(uint32(inst.pop())) + offset
+	if addr+uint64(size) > uint64(len(inst.memory)) {
+		trap("out of bounds memory access")
+	}
+	return int(addr)
+}
+
+// memoryAccess runs a load or store
+func (inst *Instance) memoryAccess(op byte, offset uint64) {
+	mem := inst.memory
+	le := binary.LittleEndian
+	if op >= 0x36 {
+		// Stores take the address below the value
+		v := inst.pop()
+		switch op {
+		case 0x36, 0x38: // i32.store, f32.store
+			le.PutUint32(mem[inst.address(offset, 4):], uint32(v))
+		case 0x37, 0x39: // i64.store, f64.store
+			le.PutUint64(mem[inst.address(offset, 8):], v)
+		case 0x3a, 0x3c: // i32.store8, i64.store8
+			mem[inst.address(offset, 1)] = byte(v)
+		case 0x3b, 0x3d: // i32.store16, i64.store16
+			le.PutUint16(mem[inst.address(offset, 2):], uint16(v))
+		case 0x3e: // i64.store32
+			le.PutUint32(mem[inst.address(offset, 4):], uint32(v))
+		}
+		return
+	}
+
+	var v uint64
+	switch op {
+	case 0x28, 0x2a: // i32.load, f32.load
+		v = uint64(le.Uint32(mem[inst.address(offset, 4):]))
+	case 0x29, 0x2b: // i64.load, f64.load
+		v = le.Uint64(mem[inst.address(offset, 8):])
+	case 0x2c: // i32.load8_s
+		v = uint64(uint32(int32(int8(mem[inst.address(offset, 1)]))))
+	case 0x2d, 0x31: // i32.load8_u, i64.load8_u
+		v = uint64(mem[inst.address(offset, 1)])
+	case 0x2e: // i32.load16_s
+		v = uint64(uint32(int32(int16(le.Uint16(mem[inst.address(offset, 2):])))))
+	case 0x2f, 0x33: // i32.load16_u, i64.load16_u
+		v = uint64(le.Uint16(mem[inst.address(offset, 2):]))
+	case 0x30: // i64.load8_s
+		v = uint64(int64(int8(mem[inst.address(offset, 1)])))
+	case 0x32: // i64.load16_s
+		v = uint64(int64(int16(le.Uint16(mem[inst.address(offset, 2):]))))
+	case 0x34: // i64.load32_s
+		v = uint64(int64(int32(le.Uint32(mem[inst.address(offset, 4):]))))
+	case 0x35: // i64.load32_u
+		v = uint64(le.Uint32(mem[inst.address(offset, 4):]))
+	}
+	inst.push(v)
+}
+
+// prefixFC runs a 0xfc instruction
+func (inst *Instance) prefixFC(in *instr) {
+	if in.sub <= opTruncSatLast {
+		inst.truncSat(in.sub)
+		return
+	}
+	if in.sub == opDataDrop {
+		inst.dropped[in.a] = true
+		return
+	}
+
+	n := uint64(uint32(inst.pop()))
+	switch in.sub {
+	case opMemoryInit:
+		src := uint64(uint32(inst.pop()))
+		dst := uint64(uint32(inst.pop()))
+		var data []byte
+		if !inst.dropped[in.a] {
+			data = inst.module.data[in.a].data
+		}
+		if src+n > uint64(len(data)) || dst+n > uint64(len(inst.memory)) {
+			trap("out of bounds memory access")
+		}
+		copy(inst.memory[dst:dst+n], data[src:])
+	case opMemoryCopy:
+		src := uint64(uint32(inst.pop()))
+		dst := uint64(uint32(inst.pop()))
+		if src+n > uint64(len(inst.memory)) || dst+n > uint64(len(inst.memory)) {
+			trap("out of bounds memory access")
+		}
+		copy(inst.memory[dst:dst+n], inst.memory[src:src+n])
+	case opMemoryFill:
+		value := byte(inst.pop())
+		dst := uint64(uint32(inst.pop()))
+		if dst+n > uint64(len(inst.memory)) {
+			trap("out of bounds memory access")
+		}
+		fill := inst.memory[dst : dst+n]
+		for i := range fill {
+			fill[i] = value
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package wasm is a small WebAssembly interpreter for running untrusted,
+// user-supplied modules in the aggregation pipeline.
+//
+// It supports the MVP instruction set with the sign extension, saturating
+// truncation, bulk memory and multi-value extensions, which is what compilers
+// emit by default. Modules cannot import functions, so they have no access to
+// the host beyond their own memory, and every call is bounded by an
+// instruction budget and every instance by a memory limit.
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValueType is the type of a WebAssembly value
+type ValueType byte
+
+// Value types. Values are passed as uint64: integers in their two's
+// complement representation and floats as their IEEE 754 bits.
+const (
+	I32 ValueType = 0x7f
+	I64 ValueType = 0x7e
+	F32 ValueType = 0x7d
+	F64 ValueType = 0x7c
+)
+
+// String returns the name of the type in the text format
+func (t ValueType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	default:
+		return fmt.Sprintf("0x%x", byte(t))
+	}
+}
+
+const (
+	// PageSize is the size of a page of memory
+	PageSize = 65536
+	// maxPages is the largest memory addressable with 32-bit addresses
+	maxPages = 65536
+	// maxLocals is the number of locals of a function, including parameters
+	maxLocals = 50000
+	// maxCallDepth bounds the recursion of calls
+	maxCallDepth = 1000
+)
+
+// FuncType is the signature of a function
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+// String returns the signature, such as (i32, i32) -> (i64)
+func (t *FuncType) String() string {
+	join := func(types []ValueType) string {
+		names := make([]string, len(types))
+		for i, vt := range types {
+			names[i] = vt.String()
+		}
+		return strings.Join(names, ", ")
+	}
+	return "(" + join(t.Params) + ") -> (" + join(t.Results) + ")"
+}
+
+func (t *FuncType) equal(o *FuncType) bool {
+	if len(t.Params) != len(o.Params) || len(t.Results) != len(o.Results) {
+		return false
+	}
+	for i := range t.Params {
+		if t.Params[i] != o.Params[i] {
+			return false
+		}
+	}
+	for i := range t.Results {
+		if t.Results[i] != o.Results[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Module is a compiled module, from which any number of instances can be
+// created. It is safe for concurrent use.
+type Module struct {
+	types    []FuncType
+	funcs    []*function
+	table    *tableType
+	memory   *memoryType
+	globals  []globalType
+	exports  map[string]export
+	start    int
+	elements []elementSegment
+	data     []dataSegment
+	// dataCount is the number of data segments declared ahead of the code,
+	// which instructions referring to data segments require
+	dataCount    int
+	hasDataCount bool
+}
+
+type function struct {
+	typ    *FuncType
+	locals int // Including parameters
+	code   []instr
+}
+
+type tableType struct {
+	min, max uint32
+	hasMax   bool
+}
+
+type memoryType struct {
+	min, max uint32
+	hasMax   bool
+}
+
+type globalType struct {
+	valueType ValueType
+	mutable   bool
+	init      constExpr
+}
+
+type constExpr struct {
+	op    byte
+	value uint64
+}
+
+type export struct {
+	kind  byte
+	index uint32
+}
+
+type elementSegment struct {
+	offset uint32
+	funcs  []uint32
+}
+
+type dataSegment struct {
+	active bool
+	offset uint32
+	data   []byte
+}
+
+// ExportedFunction returns the signature of an exported function
+func (m *Module) ExportedFunction(name string) (*FuncType, bool) {
+	e, exists := m.exports[name]
+	if !exists || e.kind != exportFunc {
+		return nil, false
+	}
+	return m.funcs[e.index].typ, true
+}
+
+// ExportsMemory reports whether the module exports its memory under a name
+func (m *Module) ExportsMemory(name string) bool {
+	e, exists := m.exports[name]
+	return exists && e.kind == exportMemory && m.memory != nil
+}
+
+// Limits bound the resources used by an instance
+type Limits struct {
+	// MaxMemoryPages is the largest size of the memory, in pages of 64 KiB
+	MaxMemoryPages uint32
+	// MaxInstructions is the number of instructions a call may execute; it
+	// must be positive. Calls are also charged one instruction per local of
+	// the functions they enter.
+	MaxInstructions int64
+	// MaxLocals is the number of locals, including parameters, of the
+	// functions on the call stack at once; it must be positive
+	MaxLocals int
+}
+
+// Trap is the error of a call that was aborted, because the module hit an
+// error such as an out of bounds memory access or exceeded its limits. An
+// instance may be left in an inconsistent state by a trap.
+type Trap struct {
+	Reason string
+}
+
+func (t *Trap) Error() string {
+	return "wasm trap: " + t.Reason
+}
+
+// trap aborts the running call
+func trap(format string, args ...interface{}) {
+	panic(&Trap{Reason: fmt.Sprintf(format, args...)})
+}
+
+// Instance is an instantiated module, with its own memory and globals. It is
+// not safe for concurrent use.
+type Instance struct {
+	module   *Module
+	limits   Limits
+	memory   []byte
+	maxPages uint32
+	globals  []uint64
+	table    []int64 // Function indices, -1 for null
+	dropped  []bool  // Dropped passive data segments
+	stack    []uint64
+	fuel     int64
+	depth    int
+	locals   int // Locals of the functions on the call stack
+}
+
+// Instantiate creates an instance of the module, initializing its memory,
+// table and globals and running its start function
+func (m *Module) Instantiate(limits Limits) (*Instance, error) {
+	if limits.MaxInstructions <= 0 {
+		return nil, fmt.Errorf("instruction limit must be positive")
+	}
+	if limits.MaxLocals <= 0 {
+		return nil, fmt.Errorf("locals limit must be positive")
+	}
+	inst := &Instance{module: m, limits: limits, dropped: make([]bool, len(m.data))}
+
+	if m.memory != nil {
+		inst.maxPages = limits.MaxMemoryPages
+		if m.memory.hasMax && m.memory.max < inst.maxPages {
+			inst.maxPages = m.memory.max
+		}
+		if m.memory.min > inst.maxPages {
+			return nil, fmt.Errorf("module requires %d pages of memory, more than the limit of %d", m.memory.min, inst.maxPages)
+		}
+		inst.memory = make([]byte, int(m.memory.min)*PageSize)
+	}
+
+	inst.globals = make([]uint64, len(m.globals))
+	for i, g := range m.globals {
+		inst.globals[i] = g.init.value
+	}
+
+	if m.table != nil {
+		inst.table = make([]int64, m.table.min)
+		for i := range inst.table {
+			inst.table[i] = -1
+		}
+	}
+	for _, seg := range m.elements {
+		if uint64(seg.offset)+uint64(len(seg.funcs)) > uint64(len(inst.table)) {
+			return nil, fmt.Errorf("element segment out of bounds of the table")
+		}
+		for i, f := range seg.funcs {
+			inst.table[int(seg.offset)+i] = int64(f)
+		}
+	}
+
+	for i, seg := range m.data {
+		if !seg.active {
+			continue
+		}
+		if uint64(seg.offset)+uint64(len(seg.data)) > uint64(len(inst.memory)) {
+			return nil, fmt.Errorf("data segment out of bounds of the memory")
+		}
+		copy(inst.memory[seg.offset:], seg.data)
+		inst.dropped[i] = true
+	}
+
+	if m.start >= 0 {
+		if _, err := inst.invoke(m.start, nil); err != nil {
+			return nil, fmt.Errorf("start function: %w", err)
+		}
+	}
+	return inst, nil
+}
+
+// Call calls an exported function. Trapped calls return a *Trap.
+func (inst *Instance) Call(name string, args ...uint64) ([]uint64, error) {
+	e, exists := inst.module.exports[name]
+	if !exists || e.kind != exportFunc {
+		return nil, fmt.Errorf("function %s is not exported", name)
+	}
+	if params := len(inst.module.funcs[e.index].typ.Params); len(args) != params {
+		return nil, fmt.Errorf("function %s takes %d arguments, got %d", name, params, len(args))
+	}
+	return inst.invoke(int(e.index), args)
+}
+
+// invoke runs a function with a fresh instruction budget. Modules are
+// validated when compiled, so only traps are recovered: any other panic is a
+// bug of the interpreter.
+func (inst *Instance) invoke(index int, args []uint64) (results []uint64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t, ok := r.(*Trap)
+			if !ok {
+				panic(r)
+			}
+			inst.stack = inst.stack[:0]
+			inst.depth = 0
+			inst.locals = 0
+			err = t
+		}
+	}()
+
+	inst.fuel = inst.limits.MaxInstructions
+	inst.stack = append(inst.stack[:0], args...)
+	inst.call(index)
+	results = append([]uint64(nil), inst.stack...)
+	inst.stack = inst.stack[:0]
+	return results, nil
+}
+
+// Memory returns the memory of the instance. The slice is invalidated when
+// the memory grows, by any call.
+func (inst *Instance) Memory() []byte {
+	return inst.memory
+}
@@ -0,0 +1,254 @@
+package wasm
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Helpers assembling modules in the binary format
+
+func uleb(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if v == 0 {
+			return out
+		}
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func vec(items ...[]byte) []byte {
+	return concat(append([][]byte{uleb(uint64(len(items)))}, items...)...)
+}
+
+func str(s string) []byte {
+	return concat(uleb(uint64(len(s))), []byte(s))
+}
+
+func section(id byte, items ...[]byte) []byte {
+	content := vec(items...)
+	return concat([]byte{id}, uleb(uint64(len(content))), content)
+}
+
+func funcType(params, results []byte) []byte {
+	return concat([]byte{0x60}, uleb(uint64(len(params))), params, uleb(uint64(len(results))), results)
+}
+
+func body(locals []byte, code ...byte) []byte {
+	b := concat(locals, code)
+	return concat(uleb(uint64(len(b))), b)
+}
+
+func assemble(sections ...[]byte) []byte {
+	return concat(append([][]byte{[]byte("\x00asm\x01\x00\x00\x00")}, sections...)...)
+}
+
+var noLocals = []byte{0x00}
+
+// testModule exports functions exercising control flow, calls, memory,
+// integer and float arithmetic and traps
+func testModule() []byte {
+	i32, i64, f64 := byte(I32), byte(I64), byte(F64)
+	names := []string{"fac", "sum", "spin", "recurse", "div", "unreachable", "store", "load", "grow", "classify", "hyp", "fill", "hog"}
+	types := []byte{0, 1, 2, 2, 3, 2, 4, 1, 1, 1, 5, 6, 2}
+
+	var functions, exports [][]byte
+	for i, name := range names {
+		functions = append(functions, uleb(uint64(types[i])))
+		exports = append(exports, concat(str(name), []byte{exportFunc}, uleb(uint64(i))))
+	}
+	exports = append(exports, concat(str("memory"), []byte{exportMemory, 0}))
+
+	return assemble(
+		section(sectionType,
+			funcType([]byte{i64}, []byte{i64}),
+			funcType([]byte{i32}, []byte{i32}),
+			funcType(nil, nil),
+			funcType([]byte{i32, i32}, []byte{i32}),
+			funcType([]byte{i32, i32}, nil),
+			funcType([]byte{f64, f64}, []byte{f64}),
+			funcType([]byte{i32, i32, i32}, nil),
+		),
+		section(sectionFunction, functions...),
+		section(sectionMemory, []byte{0x01, 0x01, 0x04}),
+		section(sectionExport, exports...),
+		section(sectionCode,
+			// fac: if n == 0 then 1 else n * fac(n - 1)
+			body(noLocals, 0x20, 0x00, 0x50, 0x04, 0x7e, 0x42, 0x01, 0x05, 0x20, 0x00, 0x20, 0x00, 0x42, 0x01, 0x7d, 0x10, 0x00, 0x7e, 0x0b, 0x0b),
+			// sum: adds n, n - 1, ... 1 in a loop
+			body([]byte{0x01, 0x01, i32}, 0x02, 0x40, 0x03, 0x40, 0x20, 0x00, 0x45, 0x0d, 0x01, 0x20, 0x01, 0x20, 0x00, 0x6a, 0x21, 0x01, 0x20, 0x00, 0x41, 0x01, 0x6b, 0x21, 0x00, 0x0c, 0x00, 0x0b, 0x0b, 0x20, 0x01, 0x0b),
+			// spin: loops forever
+			body(noLocals, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b),
+			// recurse: calls itself forever
+			body(noLocals, 0x10, 0x03, 0x0b),
+			// div: a / b, signed
+			body(noLocals, 0x20, 0x00, 0x20, 0x01, 0x6d, 0x0b),
+			body(noLocals, 0x00, 0x0b),
+			// store: stores v at addr
+			body(noLocals, 0x20, 0x00, 0x20, 0x01, 0x36, 0x02, 0x00, 0x0b),
+			// load: loads from addr
+			body(noLocals, 0x20, 0x00, 0x28, 0x02, 0x00, 0x0b),
+			// grow: grows the memory by n pages
+			body(noLocals, 0x20, 0x00, 0x40, 0x00, 0x0b),
+			// classify: br_table returning 10, 20 or 30 for 0, 1 and above
+			body(noLocals, 0x02, 0x40, 0x02, 0x40, 0x02, 0x40, 0x20, 0x00, 0x0e, 0x02, 0x00, 0x01, 0x02, 0x0b, 0x41, 0x0a, 0x0f, 0x0b, 0x41, 0x14, 0x0f, 0x0b, 0x41, 0x1e, 0x0b),
+			// hyp: sqrt(a * a + b * b)
+			body(noLocals, 0x20, 0x00, 0x20, 0x00, 0xa2, 0x20, 0x01, 0x20, 0x01, 0xa2, 0xa0, 0x9f, 0x0b),
+			// fill: memory.fill(dst, value, n)
+			body(noLocals, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0xfc, 0x0b, 0x00, 0x0b),
+			// hog: calls itself forever with 100 locals
+			body([]byte{0x01, 0x64, i64}, 0x10, 0x0c, 0x0b),
+		),
+		section(sectionData, concat([]byte{0x00, 0x41, 0x10, 0x0b}, str("hello"))),
+	)
+}
+
+func instantiate(t *testing.T) *Instance {
+	t.Helper()
+	module, err := Compile(testModule())
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	inst, err := module.Instantiate(Limits{MaxMemoryPages: 2, MaxInstructions: 100000, MaxLocals: 1000})
+	if err != nil {
+		t.Fatalf("Instantiate() error = %v", err)
+	}
+	return inst
+}
+
+func call(t *testing.T, inst *Instance, name string, args ...uint64) uint64 {
+	t.Helper()
+	results, err := inst.Call(name, args...)
+	if err != nil {
+		t.Fatalf("%s() error = %v", name, err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("%s() returned %d values", name, len(results))
+	}
+	return results[0]
+}
+
+func TestInstance_Call(t *testing.T) {
+	inst := instantiate(t)
+
+	if got := call(t, inst, "fac", 20); got != 2432902008176640000 {
+		t.Errorf("fac(20) = %d", got)
+	}
+	if got := call(t, inst, "sum", 100); got != 5050 {
+		t.Errorf("sum(100) = %d", got)
+	}
+	if got := int32(call(t, inst, "div", uint64(uint32(0xfffffff6)), 3)); got != -3 {
+		t.Errorf("div(-10, 3) = %d", got)
+	}
+	for i, want := range []uint64{10, 20, 30, 30} {
+		if got := call(t, inst, "classify", uint64(i)); got != want {
+			t.Errorf("classify(%d) = %d, want %d", i, got, want)
+		}
+	}
+	if got := math.Float64frombits(call(t, inst, "hyp", math.Float64bits(3), math.Float64bits(4))); got != 5 {
+		t.Errorf("hyp(3, 4) = %v", got)
+	}
+}
+
+func TestInstance_Memory(t *testing.T) {
+	inst := instantiate(t)
+
+	if got := string(inst.Memory()[16:21]); got != "hello" {
+		t.Errorf("data segment = %q", got)
+	}
+	if _, err := inst.Call("fill", 16, 'j', 1); err != nil {
+		t.Fatalf("fill() error = %v", err)
+	}
+	if got := string(inst.Memory()[16:21]); got != "jello" {
+		t.Errorf("filled memory = %q", got)
+	}
+
+	// The memory grows up to the limit of the instance, below the module's
+	if got := call(t, inst, "grow", 1); got != 1 {
+		t.Errorf("grow(1) = %d, want 1", got)
+	}
+	if got := uint32(call(t, inst, "grow", 1)); got != math.MaxUint32 {
+		t.Errorf("grow(1) beyond the limit = %d, want -1", int32(got))
+	}
+	if _, err := inst.Call("store", PageSize+8, 42); err != nil {
+		t.Fatalf("store() error = %v", err)
+	}
+	if got := call(t, inst, "load", PageSize+8); got != 42 {
+		t.Errorf("load() = %d, want 42", got)
+	}
+}
+
+func TestInstance_Traps(t *testing.T) {
+	inst := instantiate(t)
+
+	for _, tt := range []struct {
+		name   string
+		args   []uint64
+		reason string
+	}{
+		{"div", []uint64{1, 0}, "integer divide by zero"},
+		{"unreachable", nil, "unreachable"},
+		{"load", []uint64{2*PageSize - 2}, "out of bounds memory access"},
+		{"spin", nil, "instruction limit exceeded"},
+		{"recurse", nil, "call stack exhausted"},
+		{"hog", nil, "locals limit exceeded"},
+	} {
+		_, err := inst.Call(tt.name, tt.args...)
+		var trap *Trap
+		if !errors.As(err, &trap) || trap.Reason != tt.reason {
+			t.Errorf("%s() error = %v, want trap %q", tt.name, err, tt.reason)
+		}
+	}
+
+	// The instance can still be called after a trap
+	if got := call(t, inst, "sum", 10); got != 55 {
+		t.Errorf("sum(10) after traps = %d", got)
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		module []byte
+		err    string
+	}{
+		{"not wasm", []byte("\x7fELF"), "not a WebAssembly module"},
+		{"imports", assemble(section(sectionImport, concat(str("env"), str("now"), []byte{0x00, 0x00}))), "imports are not supported"},
+		{"truncated", testModule()[:40], "unexpected end"},
+		{"local out of range", assemble(
+			section(sectionType, funcType(nil, nil)),
+			section(sectionFunction, []byte{0x00}),
+			section(sectionCode, body(noLocals, 0x20, 0x05, 0x1a, 0x0b)),
+		), "local 5 out of range"},
+		{"stack underflow", assemble(
+			section(sectionType, funcType(nil, nil)),
+			section(sectionFunction, []byte{0x00}),
+			section(sectionCode, body(noLocals, 0x41, 0x01, 0x6a, 0x1a, 0x0b)),
+		), "stack underflow"},
+		{"values left", assemble(
+			section(sectionType, funcType(nil, nil)),
+			section(sectionFunction, []byte{0x00}),
+			section(sectionCode, body(noLocals, 0x02, 0x40, 0x41, 0x01, 0x0b, 0x0b)),
+		), "values left on the stack"},
+	} {
+		_, err := Compile(tt.module)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: Compile() error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
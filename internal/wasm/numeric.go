@@ -0,0 +1,458 @@
+package wasm
+
+import (
+	"math"
+	"math/bits"
+)
+
+func boolValue(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// numeric runs a numeric instruction, 0x45 to 0xc4
+func (inst *Instance) numeric(op byte) {
+	switch {
+	case op == 0x45: // i32.eqz
+		inst.push(boolValue(uint32(inst.pop()) == 0))
+	case op <= 0x4f:
+		b, a := uint32(inst.pop()), uint32(inst.pop())
+		inst.push(boolValue(i32Compare(op, a, b)))
+	case op == 0x50: // i64.eqz
+		inst.push(boolValue(inst.pop() == 0))
+	case op <= 0x5a:
+		b, a := inst.pop(), inst.pop()
+		inst.push(boolValue(i64Compare(op, a, b)))
+	case op <= 0x60:
+		b, a := float64(f32(inst.pop())), float64(f32(inst.pop()))
+		inst.push(boolValue(floatCompare(op-0x5b, a, b)))
+	case op <= 0x66:
+		b, a := f64(inst.pop()), f64(inst.pop())
+		inst.push(boolValue(floatCompare(op-0x61, a, b)))
+
+	case op <= 0x69:
+		a := uint32(inst.pop())
+		var v int
+		switch op {
+		case 0x67: // i32.clz
+			v = bits.LeadingZeros32(a)
+		case 0x68: // i32.ctz
+			v = bits.TrailingZeros32(a)
+		case 0x69: // i32.popcnt
+			v = bits.OnesCount32(a)
+		}
+		inst.push(uint64(v))
+	case op <= 0x78:
+		b, a := uint32(inst.pop()), uint32(inst.pop())
+		inst.push(uint64(i32Binary(op, a, b)))
+	case op <= 0x7b:
+		a := inst.pop()
+		var v int
+		switch op {
+		case 0x79: // i64.clz
+			v = bits.LeadingZeros64(a)
+		case 0x7a: // i64.ctz
+			v = bits.TrailingZeros64(a)
+		case 0x7b: // i64.popcnt
+			v = bits.OnesCount64(a)
+		}
+		inst.push(uint64(v))
+	case op <= 0x8a:
+		b, a := inst.pop(), inst.pop()
+		inst.push(i64Binary(op, a, b))
+
+	case op <= 0x91:
+		a := f32(inst.pop())
+		inst.push(f32bits(f32Unary(op, a)))
+	case op <= 0x98:
+		b, a := f32(inst.pop()), f32(inst.pop())
+		inst.push(f32bits(f32Binary(op, a, b)))
+	case op <= 0x9f:
+		a := f64(inst.pop())
+		inst.push(f64bits(floatUnary(op-0x99, a)))
+	case op <= 0xa6:
+		b, a := f64(inst.pop()), f64(inst.pop())
+		inst.push(f64bits(floatBinary(op-0xa0, a, b)))
+
+	default:
+		inst.push(convert(op, inst.pop()))
+	}
+}
+
+func i32Compare(op byte, a, b uint32) bool {
+	switch op {
+	case 0x46: // eq
+		return a == b
+	case 0x47: // ne
+		return a != b
+	case 0x48: // lt_s
+		return int32(a) < int32(b)
+	case 0x49: // lt_u
+		return a < b
+	case 0x4a: // gt_s
+		return int32(a) > int32(b)
+	case 0x4b: // gt_u
+		return a > b
+	case 0x4c: // le_s
+		return int32(a) <= int32(b)
+	case 0x4d: // le_u
+		return a <= b
+	case 0x4e: // ge_s
+		return int32(a) >= int32(b)
+	default: // ge_u
+		return a >= b
+	}
+}
+
+func i64Compare(op byte, a, b uint64) bool {
+	switch op {
+	case 0x51: // eq
+		return a == b
+	case 0x52: // ne
+		return a != b
+	case 0x53: // lt_s
+		return int64(a) < int64(b)
+	case 0x54: // lt_u
+		return a < b
+	case 0x55: // gt_s
+		return int64(a) > int64(b)
+	case 0x56: // gt_u
+		return a > b
+	case 0x57: // le_s
+		return int64(a) <= int64(b)
+	case 0x58: // le_u
+		return a <= b
+	case 0x59: // ge_s
+		return int64(a) >= int64(b)
+	default: // ge_u
+		return a >= b
+	}
+}
+
+// floatCompare compares floats, op counting from eq. f32 values are compared
+// as float64, which is exact.
+func floatCompare(op byte, a, b float64) bool {
+	switch op {
+	case 0: // eq
+		return a == b
+	case 1: // ne
+		return a != b
+	case 2: // lt
+		return a < b
+	case 3: // gt
+		return a > b
+	case 4: // le
+		return a <= b
+	default: // ge
+		return a >= b
+	}
+}
+
+func i32Binary(op byte, a, b uint32) uint32 {
+	switch op {
+	case 0x6a: // add
+		return a + b
+	case 0x6b: // sub
+		return a - b
+	case 0x6c: // mul
+		return a * b
+	case 0x6d: // div_s
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint32(int32(a) / int32(b))
+	case 0x6e: // div_u
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a / b
+	case 0x6f: // rem_s
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case 0x70: // rem_u
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a % b
+	case 0x71: // and
+		return a & b
+	case 0x72: // or
+		return a | b
+	case 0x73: // xor
+		return a ^ b
+	case 0x74: // shl
+		return a << (b % 32)
+	case 0x75: // shr_s
+		return uint32(int32(a) >> (b % 32))
+	case 0x76: // shr_u
+		return a >> (b % 32)
+	case 0x77: // rotl
+		return bits.RotateLeft32(a, int(b%32))
+	default: // rotr
+		return bits.RotateLeft32(a, -int(b%32))
+	}
+}
+
+func i64Binary(op byte, a, b uint64) uint64 {
+	switch op {
+	case 0x7c: // add
+		return a + b
+	case 0x7d: // sub
+		return a - b
+	case 0x7e: // mul
+		return a * b
+	case 0x7f: // div_s
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint64(int64(a) / int64(b))
+	case 0x80: // div_u
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a / b
+	case 0x81: // rem_s
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case 0x82: // rem_u
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a % b
+	case 0x83: // and
+		return a & b
+	case 0x84: // or
+		return a | b
+	case 0x85: // xor
+		return a ^ b
+	case 0x86: // shl
+		return a << (b % 64)
+	case 0x87: // shr_s
+		return uint64(int64(a) >> (b % 64))
+	case 0x88: // shr_u
+		return a >> (b % 64)
+	case 0x89: // rotl
+		return bits.RotateLeft64(a, int(b%64))
+	default: // rotr
+		return bits.RotateLeft64(a, -int(b%64))
+	}
+}
+
+// f32Unary computes f32 abs to sqrt. Rounding the float64 result is exact,
+// or correctly rounded for sqrt.
+func f32Unary(op byte, a float32) float32 {
+	switch op {
+	case 0x8b: // abs
+		return math.Float32frombits(math.Float32bits(a) &^ (1 << 31))
+	case 0x8c: // neg
+		return math.Float32frombits(math.Float32bits(a) ^ (1 << 31))
+	default:
+		return float32(floatUnary(op-0x8b, float64(a)))
+	}
+}
+
+// floatUnary computes abs, neg, ceil, floor, trunc, nearest or sqrt, op
+// counting from abs
+func floatUnary(op byte, a float64) float64 {
+	switch op {
+	case 0: // abs
+		return math.Abs(a)
+	case 1: // neg
+		return -a
+	case 2: // ceil
+		return math.Ceil(a)
+	case 3: // floor
+		return math.Floor(a)
+	case 4: // trunc
+		return math.Trunc(a)
+	case 5: // nearest
+		return math.RoundToEven(a)
+	default: // sqrt
+		return math.Sqrt(a)
+	}
+}
+
+func f32Binary(op byte, a, b float32) float32 {
+	switch op {
+	case 0x92: // add
+		return a + b
+	case 0x93: // sub
+		return a - b
+	case 0x94: // mul
+		return a * b
+	case 0x95: // div
+		return a / b
+	default:
+		return float32(floatBinary(op-0x92, float64(a), float64(b)))
+	}
+}
+
+// floatBinary computes add, sub, mul, div, min, max or copysign, op counting
+// from add
+func floatBinary(op byte, a, b float64) float64 {
+	switch op {
+	case 0: // add
+		return a + b
+	case 1: // sub
+		return a - b
+	case 2: // mul
+		return a * b
+	case 3: // div
+		return a / b
+	case 4: // min
+		return math.Min(a, b)
+	case 5: // max
+		return math.Max(a, b)
+	default: // copysign
+		return math.Copysign(a, b)
+	}
+}
+
+// convert runs a conversion, 0xa7 to 0xc4
+func convert(op byte, v uint64) uint64 {
+	switch op {
+	case 0xa7: // i32.wrap_i64
+		return uint64(uint32(v))
+	case 0xa8: // i32.trunc_f32_s
+		return uint64(uint32(truncSigned(float64(f32(v)), 32)))
+	case 0xa9: // i32.trunc_f32_u
+		return truncUnsigned(float64(f32(v)), 32)
+	case 0xaa: // i32.trunc_f64_s
+		return uint64(uint32(truncSigned(f64(v), 32)))
+	case 0xab: // i32.trunc_f64_u
+		return truncUnsigned(f64(v), 32)
+	case 0xac: // i64.extend_i32_s
+		return uint64(int64(int32(v)))
+	case 0xad: // i64.extend_i32_u
+		return uint64(uint32(v))
+	case 0xae: // i64.trunc_f32_s
+		return uint64(truncSigned(float64(f32(v)), 64))
+	case 0xaf: // i64.trunc_f32_u
+		return truncUnsigned(float64(f32(v)), 64)
+	case 0xb0: // i64.trunc_f64_s
+		return uint64(truncSigned(f64(v), 64))
+	case 0xb1: // i64.trunc_f64_u
+		return truncUnsigned(f64(v), 64)
+	case 0xb2: // f32.convert_i32_s
+		return f32bits(float32(int32(v)))
+	case 0xb3: // f32.convert_i32_u
+		return f32bits(float32(uint32(v)))
+	case 0xb4: // f32.convert_i64_s
+		return f32bits(float32(int64(v)))
+	case 0xb5: // f32.convert_i64_u
+		return f32bits(float32(v))
+	case 0xb6: // f32.demote_f64
+		return f32bits(float32(f64(v)))
+	case 0xb7: // f64.convert_i32_s
+		return f64bits(float64(int32(v)))
+	case 0xb8: // f64.convert_i32_u
+		return f64bits(float64(uint32(v)))
+	case 0xb9: // f64.convert_i64_s
+		return f64bits(float64(int64(v)))
+	case 0xba: // f64.convert_i64_u
+		return f64bits(float64(v))
+	case 0xbb: // f64.promote_f32
+		return f64bits(float64(f32(v)))
+	case 0xbc, 0xbe: // i32.reinterpret_f32, f32.reinterpret_i32
+		return uint64(uint32(v))
+	case 0xbd, 0xbf: // i64.reinterpret_f64, f64.reinterpret_i64
+		return v
+	case 0xc0: // i32.extend8_s
+		return uint64(uint32(int32(int8(v))))
+	case 0xc1: // i32.extend16_s
+		return uint64(uint32(int32(int16(v))))
+	case 0xc2: // i64.extend8_s
+		return uint64(int64(int8(v)))
+	case 0xc3: // i64.extend16_s
+		return uint64(int64(int16(v)))
+	default: // i64.extend32_s
+		return uint64(int64(int32(v)))
+	}
+}
+
+// truncSigned truncates a float to a signed integer of size bits, trapping
+// when it is NaN or out of range
+func truncSigned(f float64, size uint) int64 {
+	if math.IsNaN(f) {
+		trap("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	limit := math.Ldexp(1, int(size-1))
+	if t < -limit || t >= limit {
+		trap("integer overflow")
+	}
+	return int64(t)
+}
+
+// truncUnsigned truncates a float to an unsigned integer of size bits,
+// trapping when it is NaN or out of range
+func truncUnsigned(f float64, size uint) uint64 {
+	if math.IsNaN(f) {
+		trap("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	if t < 0 || t >= math.Ldexp(1, int(size)) {
+		trap("integer overflow")
+	}
+	return uint64(t)
+}
+
+// truncSat runs a saturating truncation, 0xfc 0x00 to 0x07
+func (inst *Instance) truncSat(sub byte) {
+	v := inst.pop()
+	var f float64
+	if sub%4 < 2 {
+		f = float64(f32(v))
+	} else {
+		f = f64(v)
+	}
+	size := uint(32)
+	if sub >= 4 {
+		size = 64
+	}
+
+	var result uint64
+	switch {
+	case math.IsNaN(f):
+	case sub%2 == 0: // Signed
+		max := math.Ldexp(1, int(size-1))
+		switch t := math.Trunc(f); {
+		case t < -max:
+			result = uint64(int64(-1) << (size - 1))
+		case t >= max:
+			result = 1<<(size-1) - 1
+		default:
+			result = uint64(int64(t))
+		}
+	default:
+		switch t := math.Trunc(f); {
+		case t <= 0:
+		case t >= math.Ldexp(1, int(size)):
+			result = math.MaxUint64 >> (64 - size)
+		default:
+			result = uint64(t)
+		}
+	}
+	if size == 32 {
+		result = uint64(uint32(result))
+	}
+	inst.push(result)
+}
@@ -0,0 +1,228 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// numericCase runs an instruction on arguments, as in the assert_return and
+// assert_trap commands of the WebAssembly spec test suite. Values are raw
+// bits; nan expects any NaN and trap a trap reason.
+type numericCase struct {
+	op     []byte
+	params []ValueType
+	result ValueType
+	args   []uint64
+	want   uint64
+	nan    bool
+	trap   string
+}
+
+func f32c(v float32) uint64 { return uint64(math.Float32bits(v)) }
+func f64c(v float64) uint64 { return math.Float64bits(v) }
+func i32c(v int32) uint64   { return uint64(uint32(v)) }
+
+var f32NaN, f64NaN = f32c(float32(math.NaN())), f64c(math.NaN())
+
+func i32Op(op byte, a, b, want uint64) numericCase {
+	return numericCase{op: []byte{op}, params: []ValueType{I32, I32}, result: I32, args: []uint64{a, b}, want: want}
+}
+
+func i64Op(op byte, a, b, want uint64) numericCase {
+	return numericCase{op: []byte{op}, params: []ValueType{I64, I64}, result: I64, args: []uint64{a, b}, want: want}
+}
+
+func unaryOp(op byte, param, result ValueType, a, want uint64) numericCase {
+	return numericCase{op: []byte{op}, params: []ValueType{param}, result: result, args: []uint64{a}, want: want}
+}
+
+func binaryOp(op byte, typ ValueType, a, b, want uint64) numericCase {
+	return numericCase{op: []byte{op}, params: []ValueType{typ, typ}, result: typ, args: []uint64{a, b}, want: want}
+}
+
+func trapping(c numericCase, reason string) numericCase {
+	c.trap = reason
+	return c
+}
+
+func nanResult(c numericCase) numericCase {
+	c.nan = true
+	return c
+}
+
+// numericModule exports an instruction as function f
+func numericModule(c numericCase) []byte {
+	var params []byte
+	var code []byte
+	for i, p := range c.params {
+		params = append(params, byte(p))
+		code = append(code, 0x20, byte(i))
+	}
+	code = append(append(code, c.op...), 0x0b)
+	return assemble(
+		section(sectionType, funcType(params, []byte{byte(c.result)})),
+		section(sectionFunction, []byte{0x00}),
+		section(sectionExport, concat(str("f"), []byte{exportFunc, 0x00})),
+		section(sectionCode, body(noLocals, code...)),
+	)
+}
+
+// Cases taken from i32.wast, i64.wast, f32.wast, f64.wast, conversions.wast
+// and the sign extension and saturating conversion proposals
+var numericCases = []numericCase{
+	// i32
+	i32Op(0x6a, 0x7fffffff, 1, 0x80000000),
+	i32Op(0x6b, 0x80000000, 1, 0x7fffffff),
+	i32Op(0x6c, 0x01234567, 0x76543210, 0x358e7470),
+	trapping(i32Op(0x6d, 0x80000000, i32c(-1), 0), "integer overflow"),
+	trapping(i32Op(0x6d, 1, 0, 0), "integer divide by zero"),
+	i32Op(0x6d, i32c(-7), 2, i32c(-3)),
+	i32Op(0x6e, 0x80000000, 2, 0x40000000),
+	i32Op(0x6e, i32c(-7), 2, 0x7ffffffc),
+	i32Op(0x6f, 0x80000000, i32c(-1), 0),
+	i32Op(0x6f, i32c(-7), 2, i32c(-1)),
+	i32Op(0x6f, 7, i32c(-2), 1),
+	i32Op(0x70, 0x80000000, i32c(-1), 0x80000000),
+	trapping(i32Op(0x70, 1, 0, 0), "integer divide by zero"),
+	i32Op(0x74, 1, 32, 1),
+	i32Op(0x74, 1, 31, 0x80000000),
+	i32Op(0x75, 0x80000000, 1, 0xc0000000),
+	i32Op(0x75, i32c(-1), 33, i32c(-1)),
+	i32Op(0x76, 0x80000000, 33, 0x40000000),
+	i32Op(0x77, 0xabcd9876, 1, 0x579b30ed),
+	i32Op(0x78, 0xb0c1d2e3, 0x0005, 0x1d860e97),
+	i32Op(0x78, 0xb0c1d2e3, 0xff05, 0x1d860e97),
+	unaryOp(0x67, I32, I32, 0, 32),
+	unaryOp(0x67, I32, I32, 0x00008000, 16),
+	unaryOp(0x68, I32, I32, 0, 32),
+	unaryOp(0x68, I32, I32, 0x80000000, 31),
+	unaryOp(0x69, I32, I32, i32c(-1), 32),
+	unaryOp(0x69, I32, I32, 0xaaaaaaaa, 16),
+	unaryOp(0x45, I32, I32, 0, 1),
+	binaryOp(0x48, I32, i32c(-1), 0, 1),
+	binaryOp(0x49, I32, i32c(-1), 0, 0),
+
+	// i64
+	trapping(i64Op(0x7f, 0x8000000000000000, math.MaxUint64, 0), "integer overflow"),
+	i64Op(0x81, 0x8000000000000000, math.MaxUint64, 0),
+	i64Op(0x7f, uint64(1<<64-7), 2, uint64(1<<64-3)),
+	i64Op(0x80, uint64(1<<64-7), 2, 0x7ffffffffffffffc),
+	i64Op(0x86, 1, 64, 1),
+	i64Op(0x87, 0x8000000000000000, 65, 0xc000000000000000),
+	i64Op(0x89, 0xabcd987602468ace, 1, 0x579b30ec048d159d),
+	i64Op(0x8a, 0x0123456789abcdef, 4, 0xf0123456789abcde),
+	unaryOp(0x79, I64, I64, 0, 64),
+	unaryOp(0x7a, I64, I64, 0x8000000000000000, 63),
+	unaryOp(0x7b, I64, I64, 0x5555555555555555, 32),
+
+	// f32, computed in single precision
+	binaryOp(0x92, F32, f32c(16777216), f32c(1), f32c(16777216)),
+	binaryOp(0x94, F32, f32c(1e20), f32c(1e20), f32c(float32(math.Inf(1)))),
+	binaryOp(0x96, F32, f32c(float32(math.Copysign(0, -1))), f32c(0), 0x80000000),
+	binaryOp(0x97, F32, f32c(float32(math.Copysign(0, -1))), f32c(0), 0),
+	nanResult(binaryOp(0x96, F32, f32NaN, f32c(1), 0)),
+	nanResult(binaryOp(0x97, F32, f32c(1), f32NaN, 0)),
+	binaryOp(0x98, F32, f32c(1), 0x80000000, f32c(-1)),
+	unaryOp(0x90, F32, F32, f32c(2.5), f32c(2)),
+	unaryOp(0x90, F32, F32, f32c(-3.5), f32c(-4)),
+	unaryOp(0x90, F32, F32, f32c(-0.5), 0x80000000),
+	unaryOp(0x8e, F32, F32, f32c(-0.5), f32c(-1)),
+	unaryOp(0x8d, F32, F32, f32c(-0.5), 0x80000000),
+	nanResult(unaryOp(0x91, F32, F32, f32c(-1), 0)),
+	unaryOp(0x8c, F32, F32, f32NaN, f32NaN|0x80000000),
+	unaryOp(0x8b, F32, F32, f32c(-1), f32c(1)),
+
+	// f64
+	binaryOp(0xa4, F64, f64c(math.Copysign(0, -1)), 0, 0x8000000000000000),
+	binaryOp(0xa5, F64, f64c(math.Copysign(0, -1)), 0, 0),
+	nanResult(binaryOp(0xa4, F64, 0, f64NaN, 0)),
+	unaryOp(0x9e, F64, F64, f64c(4.5), f64c(4)),
+	unaryOp(0x9e, F64, F64, f64c(-0.5), 0x8000000000000000),
+	unaryOp(0x9d, F64, F64, f64c(-1.5), f64c(-1)),
+	binaryOp(0xa6, F64, f64c(2), f64NaN|1<<63, f64c(-2)),
+
+	// Conversions
+	unaryOp(0xa7, I64, I32, 0x123456789, 0x23456789),
+	unaryOp(0xac, I32, I64, 0x80000000, 0xffffffff80000000),
+	unaryOp(0xad, I32, I64, 0x80000000, 0x80000000),
+	trapping(unaryOp(0xa8, F32, I32, f32NaN, 0), "invalid conversion to integer"),
+	trapping(unaryOp(0xa8, F32, I32, f32c(2147483648), 0), "integer overflow"),
+	unaryOp(0xa8, F32, I32, f32c(-2147483648), 0x80000000),
+	unaryOp(0xaa, F64, I32, f64c(-2147483648.9), 0x80000000),
+	trapping(unaryOp(0xaa, F64, I32, f64c(-2147483649), 0), "integer overflow"),
+	unaryOp(0xab, F64, I32, f64c(-0.9), 0),
+	unaryOp(0xab, F64, I32, f64c(4294967295.9), 0xffffffff),
+	trapping(unaryOp(0xab, F64, I32, f64c(4294967296), 0), "integer overflow"),
+	unaryOp(0xb0, F64, I64, f64c(-9223372036854775808), 0x8000000000000000),
+	trapping(unaryOp(0xb0, F64, I64, f64c(9223372036854775808), 0), "integer overflow"),
+	trapping(unaryOp(0xb1, F64, I64, f64c(18446744073709551616), 0), "integer overflow"),
+	unaryOp(0xb1, F64, I64, f64c(18446744073709549568), 0xfffffffffffff800),
+	unaryOp(0xb4, I64, F32, 9007199791611905, f32c(9007200328482816)),
+	unaryOp(0xb5, I64, F32, math.MaxUint64, 0x5f800000),
+	unaryOp(0xb3, I32, F32, 0xffffffff, f32c(4294967296)),
+	unaryOp(0xba, I64, F64, math.MaxUint64, f64c(18446744073709551616)),
+	unaryOp(0xb6, F64, F32, f64c(1e300), f32c(float32(math.Inf(1)))),
+	unaryOp(0xbb, F32, F64, f32c(1.5), f64c(1.5)),
+	unaryOp(0xbc, F32, I32, 0x80000000, 0x80000000),
+
+	// Sign extension
+	unaryOp(0xc0, I32, I32, 0x80, 0xffffff80),
+	unaryOp(0xc1, I32, I32, 0x7fff, 0x7fff),
+	unaryOp(0xc2, I64, I64, 0x01ff, math.MaxUint64),
+	unaryOp(0xc4, I64, I64, 0x80000000, 0xffffffff80000000),
+
+	// Saturating conversions
+	{op: []byte{0xfc, 0x00}, params: []ValueType{F32}, result: I32, args: []uint64{f32NaN}, want: 0},
+	{op: []byte{0xfc, 0x00}, params: []ValueType{F32}, result: I32, args: []uint64{f32c(float32(math.Inf(1)))}, want: 0x7fffffff},
+	{op: []byte{0xfc, 0x03}, params: []ValueType{F64}, result: I32, args: []uint64{f64c(-1)}, want: 0},
+	{op: []byte{0xfc, 0x06}, params: []ValueType{F64}, result: I64, args: []uint64{f64c(math.Inf(-1))}, want: 0x8000000000000000},
+	{op: []byte{0xfc, 0x07}, params: []ValueType{F64}, result: I64, args: []uint64{f64c(1e30)}, want: math.MaxUint64},
+}
+
+func TestNumeric_SpecCases(t *testing.T) {
+	for _, c := range numericCases {
+		name := fmt.Sprintf("%x%v", c.op, c.args)
+		module, err := Compile(numericModule(c))
+		if err != nil {
+			t.Errorf("%s: Compile() error = %v", name, err)
+			continue
+		}
+		inst, err := module.Instantiate(Limits{MaxInstructions: 100, MaxLocals: 100})
+		if err != nil {
+			t.Errorf("%s: Instantiate() error = %v", name, err)
+			continue
+		}
+
+		results, err := inst.Call("f", c.args...)
+		if c.trap != "" {
+			var trap *Trap
+			if !errors.As(err, &trap) || trap.Reason != c.trap {
+				t.Errorf("%s: error = %v, want trap %q", name, err, c.trap)
+			}
+			continue
+		}
+		if err != nil || len(results) != 1 {
+			t.Errorf("%s: results = %v, error = %v", name, results, err)
+			continue
+		}
+
+		got := results[0]
+		if c.result == I32 || c.result == F32 {
+			got &= math.MaxUint32
+		}
+		switch {
+		case c.nan && c.result == F32:
+			if !math.IsNaN(float64(math.Float32frombits(uint32(got)))) {
+				t.Errorf("%s = %#x, want NaN", name, got)
+			}
+		case c.nan:
+			if !math.IsNaN(math.Float64frombits(got)) {
+				t.Errorf("%s = %#x, want NaN", name, got)
+			}
+		case got != c.want:
+			t.Errorf("%s = %#x, want %#x", name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package wasm
+
+import "fmt"
+
+// frame is a block open while validating a function
+type frame struct {
+	start           int // Position of the block, -1 for the function body
+	height          int // Height of the stack below the values of the block
+	params, results int
+	loop            bool
+	// unreachable is set after an unconditional branch, from which the
+	// stack is polymorphic: popping below the block yields any value
+	unreachable bool
+}
+
+// stackValidator tracks the height of the stack through the instructions of
+// a function
+type stackValidator struct {
+	frames []frame
+	height int
+}
+
+// validate checks that the instructions of a function never pop a value a
+// block does not hold, and that blocks end with exactly their results on the
+// stack, so that running them cannot underflow the stack. funcTypes are the
+// type indices of the functions of the module.
+func (m *Module) validate(typ *FuncType, code []instr, funcTypes []uint32) error {
+	v := &stackValidator{frames: []frame{{start: -1, results: len(typ.Results)}}}
+	for pos := range code {
+		if err := v.instr(m, code, pos, funcTypes); err != nil {
+			return fmt.Errorf("instruction %d: %w", pos, err)
+		}
+	}
+	return nil
+}
+
+// pop removes n values from the stack
+func (v *stackValidator) pop(n int) error {
+	top := &v.frames[len(v.frames)-1]
+	if v.height-n < top.height {
+		if !top.unreachable {
+			return fmt.Errorf("stack underflow")
+		}
+		v.height = top.height
+		return nil
+	}
+	v.height -= n
+	return nil
+}
+
+// popPush pops pops values and pushes pushes values
+func (v *stackValidator) popPush(pops, pushes int) error {
+	if err := v.pop(pops); err != nil {
+		return err
+	}
+	v.height += pushes
+	return nil
+}
+
+// unreachable marks the rest of the block as unreachable
+func (v *stackValidator) unreachable() {
+	top := &v.frames[len(v.frames)-1]
+	v.height = top.height
+	top.unreachable = true
+}
+
+// arity returns the number of values carried by a branch depth blocks out
+func (v *stackValidator) arity(depth int) int {
+	f := &v.frames[len(v.frames)-1-depth]
+	if f.loop {
+		return f.params
+	}
+	return f.results
+}
+
+// branch checks that a branch can carry the values of its target
+func (v *stackValidator) branch(depth int) error {
+	n := v.arity(depth)
+	return v.popPush(n, n)
+}
+
+// endBlock checks that a block ends with exactly its results on the stack
+func (v *stackValidator) endBlock() error {
+	top := &v.frames[len(v.frames)-1]
+	if err := v.pop(top.results); err != nil {
+		return err
+	}
+	if v.height != top.height {
+		return fmt.Errorf("values left on the stack at the end of a block")
+	}
+	return nil
+}
+
+func (v *stackValidator) instr(m *Module, code []instr, pos int, funcTypes []uint32) error {
+	in := &code[pos]
+	switch op := in.op; {
+	case op >= opFirstNumeric && op <= opLastNumeric:
+		return v.popPush(numericParams(op), 1)
+
+	case op == opUnreachable:
+		v.unreachable()
+	case op == opNop:
+	case op == opBlock || op == opLoop || op == opIf:
+		if op == opIf {
+			if err := v.pop(1); err != nil {
+				return err
+			}
+		}
+		if err := v.pop(in.params); err != nil {
+			return err
+		}
+		v.frames = append(v.frames, frame{start: pos, height: v.height, params: in.params, results: in.results, loop: op == opLoop})
+		v.height += in.params
+	case op == opElse:
+		if err := v.endBlock(); err != nil {
+			return err
+		}
+		top := &v.frames[len(v.frames)-1]
+		top.unreachable = false
+		v.height += top.params
+	case op == opEnd:
+		if err := v.endBlock(); err != nil {
+			return err
+		}
+		top := v.frames[len(v.frames)-1]
+		if top.start >= 0 && code[top.start].op == opIf && code[top.start].els == pos && top.params != top.results {
+			return fmt.Errorf("if without else must have as many results as parameters")
+		}
+		v.frames = v.frames[:len(v.frames)-1]
+		v.height += top.results
+	case op == opBr:
+		if err := v.pop(v.arity(int(in.a))); err != nil {
+			return err
+		}
+		v.unreachable()
+	case op == opBrIf:
+		if err := v.pop(1); err != nil {
+			return err
+		}
+		return v.branch(int(in.a))
+	case op == opBrTable:
+		if err := v.pop(1); err != nil {
+			return err
+		}
+		n := v.arity(int(in.a))
+		for _, depth := range in.targets {
+			if v.arity(int(depth)) != n {
+				return fmt.Errorf("br_table targets carry different numbers of values")
+			}
+		}
+		if err := v.pop(n); err != nil {
+			return err
+		}
+		v.unreachable()
+	case op == opReturn:
+		if err := v.pop(v.frames[0].results); err != nil {
+			return err
+		}
+		v.unreachable()
+	case op == opCall:
+		t := &m.types[funcTypes[in.a]]
+		return v.popPush(len(t.Params), len(t.Results))
+	case op == opCallIndirect:
+		t := &m.types[in.a]
+		return v.popPush(len(t.Params)+1, len(t.Results))
+
+	case op == opDrop:
+		return v.pop(1)
+	case op == opSelect || op == opSelectTyped:
+		return v.popPush(3, 1)
+
+	case op == opLocalGet || op == opGlobalGet:
+		v.height++
+	case op == opLocalSet || op == opGlobalSet:
+		return v.pop(1)
+	case op == opLocalTee:
+		return v.popPush(1, 1)
+
+	case op >= opI32Load && op <= opI64Store32:
+		if op >= 0x36 {
+			return v.pop(2)
+		}
+		return v.popPush(1, 1)
+	case op == opMemorySize:
+		v.height++
+	case op == opMemoryGrow:
+		return v.popPush(1, 1)
+
+	case op == opI32Const || op == opI64Const || op == opF32Const || op == opF64Const:
+		v.height++
+
+	case op == opPrefixFC:
+		switch {
+		case in.sub <= opTruncSatLast:
+			return v.popPush(1, 1)
+		case in.sub == opDataDrop:
+		default: // memory.init, memory.copy and memory.fill
+			return v.pop(3)
+		}
+	}
+	return nil
+}
+
+// numericParams returns the number of operands of a numeric instruction
+func numericParams(op byte) int {
+	switch {
+	case op == 0x45 || op == 0x50: // eqz
+		return 1
+	case op <= 0x66: // Comparisons
+		return 2
+	case op <= 0x69: // i32 clz, ctz and popcnt
+		return 1
+	case op <= 0x78:
+		return 2
+	case op <= 0x7b: // i64 clz, ctz and popcnt
+		return 1
+	case op <= 0x8a:
+		return 2
+	case op <= 0x91: // f32 unary
+		return 1
+	case op <= 0x98:
+		return 2
+	case op <= 0x9f: // f64 unary
+		return 1
+	case op <= 0xa6:
+		return 2
+	default: // Conversions
+		return 1
+	}
+}
@@ -0,0 +1,264 @@
+// Package federation scrapes the /federate endpoint of Prometheus servers as
+// an input, so aggregation can be trialled on existing metrics
+package federation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	"github.com/marcotuna/adaptive-metrics/pkg/metrics"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// acceptHeader requests the Prometheus text exposition format
+const acceptHeader = "text/plain;version=0.0.4"
+
+// Processor receives the scraped samples
+type Processor interface {
+	ProcessMetric(sample *models.MetricSample) error
+}
+
+// Scraper periodically pulls series from the /federate endpoint of
+// Prometheus servers and feeds them to the processor
+type Scraper struct {
+	cfg        *config.FederationConfig
+	processor  Processor
+	httpClient *http.Client
+	ctx        context.Context // Cancelled on Stop to abort running scrapes
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// NewScraper creates a federation scraper
+func NewScraper(cfg *config.FederationConfig, processor Processor) (*Scraper, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("federation config cannot be nil")
+	}
+	if cfg.IntervalSeconds <= 0 {
+		return nil, fmt.Errorf("federation interval must be greater than 0")
+	}
+	for _, target := range cfg.Targets {
+		if _, err := url.Parse(target.URL); err != nil || target.URL == "" {
+			return nil, fmt.Errorf("invalid federation target URL %q", target.URL)
+		}
+		if len(target.Match) == 0 {
+			return nil, fmt.Errorf("federation target %s needs at least one match selector", targetName(target))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scraper{
+		cfg:       cfg,
+		processor: processor,
+		httpClient: &http.Client{
+			Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// Start starts scraping all targets
+func (s *Scraper) Start() {
+	for _, target := range s.cfg.Targets {
+		s.wg.Add(1)
+		go s.run(target)
+	}
+}
+
+// Stop stops scraping and waits for running scrapes to finish
+func (s *Scraper) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// run scrapes a target every interval until the scraper is stopped
+func (s *Scraper) run(target config.FederationTarget) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(s.cfg.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		s.scrapeTarget(target)
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrapeTarget scrapes a target once, logging and recording the result
+func (s *Scraper) scrapeTarget(target config.FederationTarget) {
+	name := targetName(target)
+	start := time.Now()
+
+	samples, err := s.scrape(s.ctx, target)
+	if err != nil && s.ctx.Err() != nil {
+		return // Stopped during the scrape
+	}
+	if err != nil {
+		metrics.RecordFederationScrape(name, false, samples)
+		logger.LogErrorWithFields("Failed to scrape federation target", logger.Fields{
+			"target": name,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	metrics.RecordFederationScrape(name, true, samples)
+	logger.LogDebugWithFields("Scraped federation target", logger.Fields{
+		"target":      name,
+		"samples":     samples,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
+
+// scrape pulls the series of a target and processes them. It returns the
+// number of samples processed.
+func (s *Scraper) scrape(ctx context.Context, target config.FederationTarget) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, federateURL(target), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+	for k, v := range target.Headers {
+		req.Header.Set(k, v)
+	}
+	if target.Username != "" {
+		req.SetBasicAuth(target.Username, target.Password)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("non-200 status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse federation response: %w", err)
+	}
+
+	now := time.Now()
+	processed := 0
+	for _, family := range families {
+		for _, sample := range familySamples(family, now) {
+			if err := s.processor.ProcessMetric(sample); err != nil {
+				return processed, fmt.Errorf("failed to process sample: %w", err)
+			}
+			processed++
+		}
+	}
+
+	return processed, nil
+}
+
+// federateURL returns the /federate URL of a target with its match[] selectors
+func federateURL(target config.FederationTarget) string {
+	query := url.Values{}
+	for _, selector := range target.Match {
+		query.Add("match[]", selector)
+	}
+	return strings.TrimSuffix(target.URL, "/") + "/federate?" + query.Encode()
+}
+
+// targetName returns the name of a target, defaulting to its URL
+func targetName(target config.FederationTarget) string {
+	if target.Name != "" {
+		return target.Name
+	}
+	return target.URL
+}
+
+// familySamples converts a scraped metric family to samples. Histograms and
+// summaries are expanded into their _bucket, quantile, _sum and _count
+// series. Prometheus counters are cumulative, and so are these series.
+func familySamples(family *dto.MetricFamily, now time.Time) []*models.MetricSample {
+	name := family.GetName()
+	var samples []*models.MetricSample
+
+	for _, metric := range family.GetMetric() {
+		labels := make(map[string]string, len(metric.GetLabel()))
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		timestamp := now
+		if metric.TimestampMs != nil {
+			timestamp = time.UnixMilli(metric.GetTimestampMs())
+		}
+		add := func(name string, labels map[string]string, value float64, temporality string) {
+			samples = append(samples, &models.MetricSample{
+				Name:        name,
+				Value:       value,
+				Timestamp:   timestamp,
+				Labels:      labels,
+				Temporality: temporality,
+			})
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			add(name, labels, metric.GetCounter().GetValue(), models.TemporalityCumulative)
+		case dto.MetricType_GAUGE:
+			add(name, labels, metric.GetGauge().GetValue(), "")
+		case dto.MetricType_HISTOGRAM:
+			histogram := metric.GetHistogram()
+			for _, bucket := range histogram.GetBucket() {
+				add(name+"_bucket", withLabel(labels, "le", formatFloat(bucket.GetUpperBound())), float64(bucket.GetCumulativeCount()), models.TemporalityCumulative)
+			}
+			add(name+"_sum", labels, histogram.GetSampleSum(), models.TemporalityCumulative)
+			add(name+"_count", labels, float64(histogram.GetSampleCount()), models.TemporalityCumulative)
+		case dto.MetricType_SUMMARY:
+			summary := metric.GetSummary()
+			for _, quantile := range summary.GetQuantile() {
+				add(name, withLabel(labels, "quantile", formatFloat(quantile.GetQuantile())), quantile.GetValue(), "")
+			}
+			add(name+"_sum", labels, summary.GetSampleSum(), models.TemporalityCumulative)
+			add(name+"_count", labels, float64(summary.GetSampleCount()), models.TemporalityCumulative)
+		default:
+			// Federation exposes most series untyped; counters are recognized by name
+			add(name, labels, metric.GetUntyped().GetValue(), models.NameTemporality(name))
+		}
+	}
+
+	return samples
+}
+
+// withLabel returns a copy of labels with one more label
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[name] = value
+	return result
+}
+
+// formatFloat formats a bucket bound or quantile like Prometheus does
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
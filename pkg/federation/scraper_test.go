@@ -0,0 +1,93 @@
+package federation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+type recordingProcessor struct {
+	samples []*models.MetricSample
+}
+
+func (p *recordingProcessor) ProcessMetric(sample *models.MetricSample) error {
+	p.samples = append(p.samples, sample)
+	return nil
+}
+
+const federateResponse = `# TYPE http_requests_total untyped
+http_requests_total{instance="a",job="api",path="/"} 10 1700000000000
+# TYPE temperature untyped
+temperature{instance="a",job="api"} 21.5 1700000000000
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{le="0.5"} 3
+request_duration_seconds_bucket{le="+Inf"} 4
+request_duration_seconds_sum 1.5
+request_duration_seconds_count 4
+`
+
+func TestScraper_Scrape(t *testing.T) {
+	var matches []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/federate" {
+			http.NotFound(w, r)
+			return
+		}
+		matches = r.URL.Query()["match[]"]
+		w.Write([]byte(federateResponse))
+	}))
+	defer server.Close()
+
+	processor := &recordingProcessor{}
+	target := config.FederationTarget{
+		URL:   server.URL + "/",
+		Match: []string{`{job="api"}`, `request_duration_seconds_bucket`},
+	}
+	scraper, err := NewScraper(&config.FederationConfig{IntervalSeconds: 60, TimeoutSeconds: 5, Targets: []config.FederationTarget{target}}, processor)
+	if err != nil {
+		t.Fatalf("NewScraper() error = %v", err)
+	}
+
+	processed, err := scraper.scrape(context.Background(), target)
+	if err != nil {
+		t.Fatalf("scrape() error = %v", err)
+	}
+	if len(matches) != 2 || matches[0] != `{job="api"}` {
+		t.Errorf("match[] = %v, want the configured selectors", matches)
+	}
+	if processed != 6 || len(processor.samples) != 6 {
+		t.Fatalf("processed %d samples, want 6", processed)
+	}
+
+	byName := make(map[string]*models.MetricSample)
+	for _, sample := range processor.samples {
+		byName[sample.Name+sample.Labels["le"]] = sample
+	}
+	requests := byName["http_requests_total"]
+	if requests == nil || requests.Value != 10 || requests.Temporality != models.TemporalityCumulative || requests.Labels["path"] != "/" {
+		t.Errorf("http_requests_total = %+v, want a cumulative counter", requests)
+	}
+	if requests != nil && requests.Timestamp.UnixMilli() != 1700000000000 {
+		t.Errorf("timestamp = %v, want the federated timestamp", requests.Timestamp)
+	}
+	if temperature := byName["temperature"]; temperature == nil || temperature.Temporality != "" {
+		t.Errorf("temperature = %+v, want a gauge", temperature)
+	}
+	if bucket := byName["request_duration_seconds_bucket+Inf"]; bucket == nil || bucket.Value != 4 {
+		t.Errorf("+Inf bucket = %+v, want 4", bucket)
+	}
+}
+
+func TestNewScraper_Validation(t *testing.T) {
+	cfg := &config.FederationConfig{
+		IntervalSeconds: 60,
+		Targets:         []config.FederationTarget{{URL: "http://prometheus:9090"}},
+	}
+	if _, err := NewScraper(cfg, &recordingProcessor{}); err == nil {
+		t.Error("NewScraper() accepted a target without match selectors")
+	}
+}
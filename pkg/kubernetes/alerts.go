@@ -0,0 +1,130 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// Defaults of generated health alerts
+const (
+	defaultAlertFor      = "10m"
+	defaultAlertSeverity = "warning"
+)
+
+// buildAlerts creates a PrometheusRule alerting when the aggregated metric of
+// a rule goes absent or when dropped original metrics reappear
+func buildAlerts(rule *models.Rule) *PrometheusRule {
+	config := rule.OutputKubernetes
+	alerts := config.Alerts
+	if alerts == nil {
+		alerts = &models.AlertsConfig{}
+	}
+
+	forDuration := alerts.For
+	if forDuration == "" {
+		forDuration = defaultAlertFor
+	}
+	severity := alerts.Severity
+	if severity == "" {
+		severity = defaultAlertSeverity
+	}
+
+	labels := map[string]string{
+		"severity":              severity,
+		"adaptive_metrics_rule": rule.ID,
+	}
+	for k, v := range alerts.Labels {
+		labels[k] = v
+	}
+
+	metricName := rule.Output.MetricName
+	rules := []Rule{{
+		Alert:  "AdaptiveMetricsAggregationAbsent",
+		Expr:   fmt.Sprintf("absent(%s)", metricName),
+		For:    forDuration,
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":     fmt.Sprintf("Aggregated metric %s is absent", metricName),
+			"description": fmt.Sprintf("The aggregated metric %s produced by adaptive metrics rule %s has not been received for %s.", metricName, rule.ID, forDuration),
+		},
+	}}
+
+	// Original metrics should stay absent once the monitor drops all of them;
+	// during a partial rollout some are expected to remain
+	if patterns := originalMetricPatterns(rule); config.DropOriginalMetrics && rule.DropPercentage() == 100 && len(patterns) > 0 {
+		rules = append(rules, Rule{
+			Alert:  "AdaptiveMetricsOriginalMetricsReappeared",
+			Expr:   fmt.Sprintf("count by (__name__) ({__name__=~%q}) > 0", strings.Join(patterns, "|")),
+			For:    forDuration,
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":     "Original metrics of {{ $labels.__name__ }} reappeared after being dropped",
+				"description": fmt.Sprintf("Metrics dropped in favour of %s by adaptive metrics rule %s are being ingested again; check the metric relabelings of the monitor.", metricName, rule.ID),
+			},
+		})
+	}
+
+	namespace := config.Namespace
+	if targets := config.MonitorTargets(); namespace == "" && len(targets) > 0 {
+		namespace = targets[0].Namespace
+	}
+
+	return &PrometheusRule{
+		APIVersion: APIVersion,
+		Kind:       KindPrometheusRule,
+		Metadata: ObjectMeta{
+			Name:      metricName + "-alerts",
+			Namespace: namespace,
+			Labels:    config.Labels,
+		},
+		Spec: PrometheusRuleSpec{
+			Groups: []RuleGroup{{
+				Name:  "adaptive-metrics-" + rule.ID,
+				Rules: rules,
+			}},
+		},
+	}
+}
+
+// appendAlerts appends the rule's health alerts to the generated documents if
+// enabled. Shadow rules get no alerts since their output is never written.
+func appendAlerts(rule *models.Rule, documents [][]byte) ([][]byte, error) {
+	if rule.OutputKubernetes.Alerts == nil || !rule.OutputKubernetes.Alerts.Enabled || rule.Shadow {
+		return documents, nil
+	}
+
+	document, err := marshalDocument(buildAlerts(rule), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(documents, document), nil
+}
+
+// GenerateAlerts creates the PrometheusRule alerting on the health of a rule's aggregation
+func (g *Generator) GenerateAlerts(rule *models.Rule) (string, error) {
+	if rule == nil || rule.OutputKubernetes == nil || !rule.OutputKubernetes.Enabled {
+		return "", fmt.Errorf("rule does not have Kubernetes output enabled")
+	}
+	if rule.Shadow {
+		return "", fmt.Errorf("rule is in shadow mode and does not write aggregated metrics")
+	}
+
+	document, err := marshalDocument(buildAlerts(rule), "")
+	if err != nil {
+		return "", err
+	}
+
+	return g.output([][]byte{document}, fmt.Sprintf("%s-%s.yaml", KindPrometheusRule, rule.ID))
+}
+
+// RenderAlerts renders the PrometheusRule alerting on the health of a rule's aggregation
+func RenderAlerts(rule *models.Rule) (string, error) {
+	gen, err := NewGenerator("")
+	if err != nil {
+		return "", err
+	}
+	return gen.GenerateAlerts(rule)
+}
@@ -0,0 +1,61 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// FieldManager identifies the service as the owner of the fields it applies
+const FieldManager = "adaptive-metrics"
+
+// MonitorApplier creates or updates monitors in the cluster
+type MonitorApplier interface {
+	ApplyMonitor(kind, namespace, name string, monitor interface{}) error
+}
+
+// AppliedMonitor names a monitor applied to the cluster
+type AppliedMonitor struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// String returns the monitor as kind/namespace/name
+func (m AppliedMonitor) String() string {
+	return fmt.Sprintf("%s/%s/%s", m.Kind, m.Namespace, m.Name)
+}
+
+// ApplyMonitors creates or updates the monitors of a rule in the cluster and
+// returns the ones applied before any error. Only monitors created for the
+// rule can be applied; modified and patched monitors belong to someone else
+// and are written to files for review instead.
+func ApplyMonitors(rule *models.Rule, applier MonitorApplier) ([]AppliedMonitor, error) {
+	if rule == nil || rule.OutputKubernetes == nil || !rule.OutputKubernetes.Enabled {
+		return nil, fmt.Errorf("rule does not have Kubernetes output enabled")
+	}
+	if mode := rule.OutputKubernetes.Mode; mode != "" && mode != "create" {
+		return nil, fmt.Errorf("monitors in %s mode cannot be applied to the cluster", mode)
+	}
+
+	gen, err := NewGenerator("")
+	if err != nil {
+		return nil, err
+	}
+	monitors, err := gen.buildMonitors(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]AppliedMonitor, 0, len(monitors))
+	for _, monitor := range monitors {
+		if monitor.metadata.Namespace == "" {
+			return applied, fmt.Errorf("monitor %s has no namespace", monitor.metadata.Name)
+		}
+		if err := applier.ApplyMonitor(monitor.kind, monitor.metadata.Namespace, monitor.metadata.Name, monitor.object); err != nil {
+			return applied, err
+		}
+		applied = append(applied, AppliedMonitor{Kind: monitor.kind, Namespace: monitor.metadata.Namespace, Name: monitor.metadata.Name})
+	}
+	return applied, nil
+}
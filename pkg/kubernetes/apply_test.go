@@ -0,0 +1,63 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestApplyMonitors(t *testing.T) {
+	type request struct {
+		method, path, query, contentType string
+		kind                             string
+	}
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var monitor struct {
+			Kind string `json:"kind"`
+		}
+		json.Unmarshal(body, &monitor)
+		requests = append(requests, request{r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Content-Type"), monitor.Kind})
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	rule := &models.Rule{
+		ID:     "rule",
+		Output: models.OutputConfig{MetricName: "http_requests_aggregated"},
+		OutputKubernetes: &models.KubernetesOutputConfig{
+			Enabled:      true,
+			ResourceType: KindPodMonitor,
+			Namespace:    "monitoring",
+			Selector:     map[string]string{"app": "api"},
+		},
+	}
+
+	applied, err := ApplyMonitors(rule, NewClient(server.URL, "token", nil))
+	if err != nil {
+		t.Fatalf("ApplyMonitors() error = %v", err)
+	}
+	if len(applied) != 1 || applied[0].String() != "PodMonitor/monitoring/http_requests_aggregated-monitor" {
+		t.Fatalf("ApplyMonitors() = %v, want the PodMonitor of the rule", applied)
+	}
+	want := request{
+		method:      http.MethodPatch,
+		path:        "/apis/monitoring.coreos.com/v1/namespaces/monitoring/podmonitors/http_requests_aggregated-monitor",
+		query:       "fieldManager=adaptive-metrics&force=true",
+		contentType: "application/apply-patch+yaml",
+		kind:        KindPodMonitor,
+	}
+	if len(requests) != 1 || requests[0] != want {
+		t.Errorf("requests = %+v, want %+v", requests, want)
+	}
+
+	rule.OutputKubernetes.Mode = "patch"
+	if _, err := ApplyMonitors(rule, NewClient(server.URL, "", nil)); err == nil {
+		t.Error("ApplyMonitors() of a patched monitor should fail")
+	}
+}
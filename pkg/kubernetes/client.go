@@ -0,0 +1,211 @@
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Locations of the in-cluster service account credentials
+const (
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// ErrNotFound is returned for resources that do not exist
+var ErrNotFound = errors.New("not found")
+
+// MonitorReader reads existing monitor resources
+type MonitorReader interface {
+	// GetMonitor returns the monitor of the given kind as an unstructured object
+	GetMonitor(kind, namespace, name string) (map[string]interface{}, error)
+}
+
+// Client is a minimal Kubernetes API client for Prometheus Operator resources
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the API server at baseURL authenticating with
+// a bearer token. A nil httpClient uses a default client.
+func NewClient(baseURL, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// NewInClusterClient creates a client from the service account of the pod
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+
+	token, err := os.ReadFile(serviceAccountTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+
+	ca, err := os.ReadFile(serviceAccountCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("failed to parse service account CA")
+	}
+
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	baseURL := "https://" + net.JoinHostPort(host, port)
+	return NewClient(baseURL, strings.TrimSpace(string(token)), httpClient), nil
+}
+
+// monitorResource returns the API resource name of a monitor kind
+func monitorResource(kind string) (string, error) {
+	switch kind {
+	case KindServiceMonitor:
+		return "servicemonitors", nil
+	case KindPodMonitor:
+		return "podmonitors", nil
+	default:
+		return "", fmt.Errorf("unsupported resource type: %s", kind)
+	}
+}
+
+// GetMonitor fetches a ServiceMonitor or PodMonitor
+func (c *Client) GetMonitor(kind, namespace, name string) (map[string]interface{}, error) {
+	resource, err := monitorResource(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/apis/%s/namespaces/%s/%s/%s", c.baseURL, APIVersion, namespace, resource, name)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s/%s: %w", kind, namespace, name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %s/%s: %w", kind, namespace, name, ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to get %s %s/%s: status %d: %s", kind, namespace, name, resp.StatusCode, body)
+	}
+
+	var monitor map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&monitor); err != nil {
+		return nil, fmt.Errorf("failed to decode %s %s/%s: %w", kind, namespace, name, err)
+	}
+
+	return monitor, nil
+}
+
+// ApplyMonitor creates or updates a ServiceMonitor or PodMonitor with a
+// server-side apply, taking over fields owned by other managers
+func (c *Client) ApplyMonitor(kind, namespace, name string, monitor interface{}) error {
+	resource, err := monitorResource(kind)
+	if err != nil {
+		return err
+	}
+
+	// JSON is valid YAML, which the apply patch type expects
+	body, err := json.Marshal(monitor)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s %s/%s: %w", kind, namespace, name, err)
+	}
+
+	url := fmt.Sprintf("%s/apis/%s/namespaces/%s/%s/%s?fieldManager=%s&force=true",
+		c.baseURL, APIVersion, namespace, resource, name, FieldManager)
+	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/apply-patch+yaml")
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to apply %s %s/%s: %w", kind, namespace, name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to apply %s %s/%s: status %d: %s", kind, namespace, name, resp.StatusCode, body)
+	}
+	return nil
+}
+
+// GetSecret returns the value of a key of a Secret
+func (c *Client) GetSecret(namespace, name, key string) (string, error) {
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", c.baseURL, namespace, name)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to get secret %s/%s: status %d: %s", namespace, name, resp.StatusCode, body)
+	}
+
+	// Values of data are base64 encoded, which []byte decodes
+	var secret struct {
+		Data map[string][]byte `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to decode secret %s/%s: %w", namespace, name, err)
+	}
+	value, exists := secret.Data[key]
+	if !exists {
+		return "", fmt.Errorf("secret %s/%s has no key %s", namespace, name, key)
+	}
+
+	return string(value), nil
+}
@@ -4,18 +4,22 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
-	"text/template"
 
 	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"sigs.k8s.io/yaml"
 )
 
 // Generator creates Kubernetes monitor resources for metrics
 type Generator struct {
 	outputDir string
+	// existingDir holds the existing monitor files read in patch mode
+	existingDir string
+	reader      MonitorReader // Reads existing monitors in patch mode
 }
 
 // NewGenerator creates a new Kubernetes resource generator
@@ -43,303 +47,416 @@ func (g *Generator) Generate(rule *models.Rule) (string, error) {
 	switch config.Mode {
 	case "create":
 		return g.generateNewMonitor(rule)
-	case "modify", "patch":
+	case "modify":
 		return g.modifyExistingMonitor(rule)
+	case "patch":
+		return g.patchExistingMonitor(rule)
 	default:
 		// Default to create if mode not specified
 		return g.generateNewMonitor(rule)
 	}
 }
 
-// generateNewMonitor creates a new ServiceMonitor or PodMonitor
+// generateNewMonitor creates a new ServiceMonitor or PodMonitor for each target
 func (g *Generator) generateNewMonitor(rule *models.Rule) (string, error) {
-	// Determine which template to use based on resource type
-	var tmpl *template.Template
-	var err error
-
-	switch rule.OutputKubernetes.ResourceType {
-	case "PodMonitor":
-		tmpl, err = template.New("podmonitor").Parse(newPodMonitorTemplate)
-	case "ServiceMonitor":
-		tmpl, err = template.New("servicemonitor").Parse(newServiceMonitorTemplate)
-	default:
-		return "", fmt.Errorf("unsupported resource type: %s", rule.OutputKubernetes.ResourceType)
+	monitors, err := g.buildMonitors(rule)
+	if err != nil {
+		return "", err
+	}
+
+	var documents [][]byte
+	for _, monitor := range monitors {
+		document, err := marshalDocument(monitor.object, "")
+		if err != nil {
+			return "", err
+		}
+		documents = append(documents, document)
 	}
 
+	// Health alerts are generated alongside the monitors
+	documents, err = appendAlerts(rule, documents)
 	if err != nil {
-		return "", fmt.Errorf("failed to create template: %w", err)
+		return "", err
 	}
 
+	return g.output(documents, MonitorFile(rule))
+}
+
+// generatedMonitor is a monitor created for a target of a rule
+type generatedMonitor struct {
+	kind     string
+	metadata ObjectMeta
+	object   interface{}
+}
+
+// buildMonitors builds the ServiceMonitor or PodMonitor of each target of a rule
+func (g *Generator) buildMonitors(rule *models.Rule) ([]generatedMonitor, error) {
+	config := rule.OutputKubernetes
+
 	// Build metric relabelings
 	metricRelabelings := g.buildMetricRelabelings(rule)
 
-	// Prepare data for template
-	data := map[string]interface{}{
-		"Rule":              rule,
-		"K8sConfig":         rule.OutputKubernetes,
-		"MetricRelabelings": metricRelabelings,
-	}
+	var monitors []generatedMonitor
+	names := make(map[string]bool)
+	for _, target := range config.MonitorTargets() {
+		monitorName := MonitorName(rule, target)
+		if names[monitorName] {
+			return nil, fmt.Errorf("duplicate monitor target name: %s", target.Name)
+		}
+		names[monitorName] = true
 
-	// Execute template
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("failed to execute template: %w", err)
+		metadata := ObjectMeta{
+			Name:      monitorName,
+			Namespace: target.Namespace,
+			Labels:    config.Labels,
+		}
+		selector := &LabelSelector{MatchLabels: target.Selector}
+		endpoint := Endpoint{
+			Port:                 target.Port,
+			Path:                 target.Path,
+			Interval:             target.Interval,
+			TLSConfig:            buildTLSConfig(config.TLSConfig),
+			MetricRelabelConfigs: metricRelabelings,
+		}
+
+		var monitor interface{}
+		switch config.ResourceType {
+		case KindPodMonitor:
+			monitor = &PodMonitor{
+				APIVersion: APIVersion,
+				Kind:       KindPodMonitor,
+				Metadata:   metadata,
+				Spec:       PodMonitorSpec{Selector: selector, PodMetricsEndpoints: []Endpoint{endpoint}},
+			}
+		case KindServiceMonitor:
+			monitor = &ServiceMonitor{
+				APIVersion: APIVersion,
+				Kind:       KindServiceMonitor,
+				Metadata:   metadata,
+				Spec:       ServiceMonitorSpec{Selector: selector, Endpoints: []Endpoint{endpoint}},
+			}
+		default:
+			return nil, fmt.Errorf("unsupported resource type: %s", config.ResourceType)
+		}
+		monitors = append(monitors, generatedMonitor{kind: config.ResourceType, metadata: metadata, object: monitor})
 	}
 
-	// Write to file if output directory is specified
-	if g.outputDir != "" {
-		filename := filepath.Join(g.outputDir, fmt.Sprintf("%s-%s.yaml",
-			rule.OutputKubernetes.ResourceType, rule.ID))
+	return monitors, nil
+}
 
-		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
-			return "", fmt.Errorf("failed to write file: %w", err)
-		}
+// MonitorName returns the name of the monitor created for a target of a rule.
+// Monitors of explicit targets are named after the target.
+func MonitorName(rule *models.Rule, target models.MonitorTarget) string {
+	if len(rule.OutputKubernetes.Targets) > 0 {
+		return fmt.Sprintf("%s-%s-monitor", rule.Output.MetricName, target.Name)
+	}
+	return rule.Output.MetricName + "-monitor"
+}
 
-		return filename, nil
+// MonitorFile returns the name of the file the monitors of a rule are written
+// to in the output directory
+func MonitorFile(rule *models.Rule) string {
+	config := rule.OutputKubernetes
+	// Modified and patched monitors of a single target are named after the
+	// existing monitor
+	name := config.ExistingMonitorName
+	if len(config.Targets) > 0 {
+		name = rule.ID
 	}
 
-	return buf.String(), nil
+	switch config.Mode {
+	case "modify":
+		return fmt.Sprintf("modified-%s-%s.yaml", config.ResourceType, name)
+	case "patch":
+		return fmt.Sprintf("patched-%s-%s.yaml", config.ResourceType, name)
+	default:
+		return fmt.Sprintf("%s-%s.yaml", config.ResourceType, rule.ID)
+	}
 }
 
-// modifyExistingMonitor reads an existing monitor and modifies it based on the rule
+// modifyExistingMonitor generates a patch adding the rule's metric relabelings
+// to each existing monitor
 func (g *Generator) modifyExistingMonitor(rule *models.Rule) (string, error) {
 	config := rule.OutputKubernetes
 
-	// Check if the existing monitor name is provided
-	if config.ExistingMonitorName == "" {
-		return "", fmt.Errorf("existing monitor name must be provided for modify/patch mode")
+	// Check if the existing monitor name is provided for every target
+	targets := config.MonitorTargets()
+	for _, target := range targets {
+		if target.ExistingMonitorName == "" {
+			return "", fmt.Errorf("existing monitor name must be provided for modify/patch mode")
+		}
 	}
 
-	// For this example, we'll use a template to show how to modify an existing monitor
-	// In a real implementation, you would read the existing file, unmarshal it, modify it, and write it back
-	var tmpl *template.Template
-	var err error
+	// Build metric relabelings
+	metricRelabelings := g.buildMetricRelabelings(rule)
+	endpoints := []Endpoint{{MetricRelabelConfigs: metricRelabelings}}
 
-	switch config.ResourceType {
-	case "PodMonitor":
-		tmpl, err = template.New("modify-podmonitor").Parse(modifyPodMonitorTemplate)
-	case "ServiceMonitor":
-		tmpl, err = template.New("modify-servicemonitor").Parse(modifyServiceMonitorTemplate)
-	default:
-		return "", fmt.Errorf("unsupported resource type: %s", config.ResourceType)
+	var documents [][]byte
+	for _, target := range targets {
+		metadata := ObjectMeta{
+			Name:      target.ExistingMonitorName,
+			Namespace: target.Namespace,
+		}
+
+		var patch interface{}
+		switch config.ResourceType {
+		case KindPodMonitor:
+			patch = &PodMonitor{
+				APIVersion: APIVersion,
+				Kind:       KindPodMonitor,
+				Metadata:   metadata,
+				Spec:       PodMonitorSpec{PodMetricsEndpoints: endpoints},
+			}
+		case KindServiceMonitor:
+			patch = &ServiceMonitor{
+				APIVersion: APIVersion,
+				Kind:       KindServiceMonitor,
+				Metadata:   metadata,
+				Spec:       ServiceMonitorSpec{Endpoints: endpoints},
+			}
+		default:
+			return "", fmt.Errorf("unsupported resource type: %s", config.ResourceType)
+		}
+
+		comment := fmt.Sprintf("Applied modifications to %s: %s\n"+
+			"This is a patch to be applied to the existing %s. Add the metricRelabelings\n"+
+			"to the appropriate endpoint.", config.ResourceType, target.ExistingMonitorName, config.ResourceType)
+		document, err := marshalDocument(patch, comment)
+		if err != nil {
+			return "", err
+		}
+		documents = append(documents, document)
 	}
 
+	// Health alerts are generated alongside the monitors
+	documents, err := appendAlerts(rule, documents)
 	if err != nil {
-		return "", fmt.Errorf("failed to create template: %w", err)
+		return "", err
 	}
 
-	// Build metric relabelings
-	metricRelabelings := g.buildMetricRelabelings(rule)
+	return g.output(documents, MonitorFile(rule))
+}
 
-	// Prepare data for template
-	data := map[string]interface{}{
-		"Rule":              rule,
-		"K8sConfig":         config,
-		"MetricRelabelings": metricRelabelings,
+// patchExistingMonitor merges the rule's metric relabelings into each existing
+// monitor and returns the merged objects. When writing to the output
+// directory, the JSON patches are written next to them.
+func (g *Generator) patchExistingMonitor(rule *models.Rule) (string, error) {
+	patches, err := g.PatchMonitors(rule)
+	if err != nil {
+		return "", err
 	}
 
-	// Execute template
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("failed to execute template: %w", err)
+	var documents [][]byte
+	for _, patch := range patches {
+		document, err := marshalDocument(patch.Merged, "")
+		if err != nil {
+			return "", err
+		}
+		documents = append(documents, document)
 	}
 
-	// Write to file if output directory is specified
-	if g.outputDir != "" {
-		filename := filepath.Join(g.outputDir, fmt.Sprintf("modified-%s-%s.yaml",
-			config.ResourceType, config.ExistingMonitorName))
+	filename := strings.TrimSuffix(MonitorFile(rule), ".yaml")
 
-		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+	if g.outputDir != "" {
+		encoded, err := json.MarshalIndent(patches, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal patches: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(g.outputDir, filename+".patch.json"), encoded, 0644); err != nil {
 			return "", fmt.Errorf("failed to write file: %w", err)
 		}
+	}
+
+	// Health alerts are generated alongside the monitors
+	documents, err = appendAlerts(rule, documents)
+	if err != nil {
+		return "", err
+	}
+
+	return g.output(documents, filename+".yaml")
+}
+
+// marshalDocument encodes a resource as a YAML document, with an optional
+// head comment. Resources are encoded through their JSON tags, as Kubernetes
+// encodes its objects.
+func marshalDocument(resource interface{}, comment string) ([]byte, error) {
+	data, err := yaml.Marshal(resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal resource: %w", err)
+	}
+	if comment == "" {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	for _, line := range strings.Split(comment, "\n") {
+		buf.WriteString("# " + line + "\n")
+	}
+	buf.Write(data)
+	return buf.Bytes(), nil
+}
 
-		return filename, nil
+// output joins rendered monitors into a multi-document YAML stream, writes it
+// to the output directory if one is set and returns the file name, or returns
+// the content itself
+func (g *Generator) output(documents [][]byte, filename string) (string, error) {
+	content := bytes.Join(documents, []byte("---\n"))
+	if g.outputDir == "" {
+		return string(content), nil
 	}
 
-	return buf.String(), nil
+	path := filepath.Join(g.outputDir, filename)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return path, nil
+}
+
+// buildTLSConfig converts the rule's TLS configuration to the endpoint format
+func buildTLSConfig(config *models.TLSConfig) *TLSConfig {
+	if config == nil {
+		return nil
+	}
+	return &TLSConfig{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		CAFile:             config.CAFile,
+		CertFile:           config.CertFile,
+		KeyFile:            config.KeyFile,
+		ServerName:         config.ServerName,
+	}
 }
 
 // buildMetricRelabelings creates the appropriate metric relabeling configurations
-func (g *Generator) buildMetricRelabelings(rule *models.Rule) string {
-	var relabelings []string
+func (g *Generator) buildMetricRelabelings(rule *models.Rule) []RelabelConfig {
 	config := rule.OutputKubernetes
 
 	// If there are predefined relabelings in the config, use them
 	if len(config.MetricRelabeling) > 0 {
-		relabelingsBytes, _ := json.MarshalIndent(config.MetricRelabeling, "", "  ")
-		return string(relabelingsBytes)
-	}
-
-	// Add a relabeling to keep the aggregated metric
-	keepAggregated := fmt.Sprintf(`
-- sourceLabels: [__name__]
-  regex: %s
-  action: keep`, rule.Output.MetricName)
-	relabelings = append(relabelings, keepAggregated)
-
-	// If drop original metrics is enabled, add relabelings to drop them
-	if config.DropOriginalMetrics && len(config.OriginalMetricNames) > 0 {
-		for _, originalMetric := range config.OriginalMetricNames {
-			dropOriginal := fmt.Sprintf(`
-- sourceLabels: [__name__]
-  regex: %s
-  action: drop`, originalMetric)
-			relabelings = append(relabelings, dropOriginal)
+		relabelings := make([]RelabelConfig, len(config.MetricRelabeling))
+		for i, relabel := range config.MetricRelabeling {
+			relabelings[i] = RelabelConfig{
+				SourceLabels: relabel.SourceLabels,
+				Separator:    relabel.Separator,
+				TargetLabel:  relabel.TargetLabel,
+				Regex:        relabel.Regex,
+				Modulus:      relabel.Modulus,
+				Replacement:  relabel.Replacement,
+				Action:       relabel.Action,
+			}
 		}
+		return relabelings
 	}
 
-	// Default: if no original metrics specified but drop is enabled, try to drop metrics from matcher
-	if config.DropOriginalMetrics && len(config.OriginalMetricNames) == 0 && len(rule.Matcher.MetricNames) > 0 {
-		for _, metricName := range rule.Matcher.MetricNames {
-			if metricName != "*" { // Skip wildcard matches
-				dropOriginal := fmt.Sprintf(`
-- sourceLabels: [__name__]
-  regex: %s
-  action: drop`, metricName)
-				relabelings = append(relabelings, dropOriginal)
-			}
+	// Add a relabeling to keep the aggregated metrics
+	names := rule.OutputMetricNames()
+	for i, name := range names {
+		names[i] = regexp.QuoteMeta(name)
+	}
+	relabelings := []RelabelConfig{{
+		SourceLabels: []string{models.MetricNameLabel},
+		Regex:        strings.Join(names, "|"),
+		Action:       "keep",
+	}}
+
+	percentage := rule.DropPercentage()
+	if !config.DropOriginalMetrics || percentage == 0 {
+		return relabelings
+	}
+
+	if percentage < 100 {
+		return append(relabelings, rolloutRelabelings(rule, percentage)...)
+	}
+
+	// Drop the original metrics
+	for _, pattern := range originalMetricPatterns(rule) {
+		relabelings = append(relabelings, RelabelConfig{
+			SourceLabels: []string{models.MetricNameLabel},
+			Regex:        pattern,
+			Action:       "drop",
+		})
+	}
+
+	return relabelings
+}
+
+// rolloutHashLabel is the temporary label holding the rollout bucket of a series
+const rolloutHashLabel = "__tmp_rollout_bucket"
+
+// rolloutRelabelings drops the original metrics of a partially rolled out
+// rule: each series is hashed into one of 100 buckets and dropped when its
+// bucket is below the rollout percentage
+func rolloutRelabelings(rule *models.Rule, percentage int) []RelabelConfig {
+	relabelings := []RelabelConfig{{
+		SourceLabels: rule.RolloutHashLabels(),
+		TargetLabel:  rolloutHashLabel,
+		Modulus:      100,
+		Action:       "hashmod",
+	}}
+
+	buckets := make([]string, percentage)
+	for i := range buckets {
+		buckets[i] = strconv.Itoa(i)
+	}
+	for _, pattern := range originalMetricPatterns(rule) {
+		relabelings = append(relabelings, RelabelConfig{
+			SourceLabels: []string{models.MetricNameLabel, rolloutHashLabel},
+			Separator:    ";",
+			Regex:        fmt.Sprintf("%s;(%s)", pattern, strings.Join(buckets, "|")),
+			Action:       "drop",
+		})
+	}
+
+	return relabelings
+}
+
+// originalMetricPatterns returns regexes of the original metrics dropped by the
+// monitor. If none are specified, the metrics from the matcher are used.
+func originalMetricPatterns(rule *models.Rule) []string {
+	originalMetrics := rule.OutputKubernetes.OriginalMetricNames
+	if len(originalMetrics) == 0 {
+		originalMetrics = rule.Matcher.Names()
+	}
+
+	var patterns []string
+	for _, metricName := range originalMetrics {
+		if metricName == "*" { // Skip wildcard matches
+			continue
 		}
+		patterns = append(patterns, globToRegex(metricName))
 	}
 
-	return fmt.Sprintf(`%s`, strings.Join(relabelings, "\n"))
+	return patterns
+}
+
+// globToRegex converts a metric name glob pattern, where * matches any
+// characters, to a regular expression
+func globToRegex(pattern string) string {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return strings.Join(parts, ".*")
 }
 
-// New ServiceMonitor template based on Prometheus Operator CRD
-const newServiceMonitorTemplate = `apiVersion: monitoring.coreos.com/v1
-kind: ServiceMonitor
-metadata:
-  name: {{ .Rule.Output.MetricName }}-monitor
-  namespace: {{ .K8sConfig.Namespace }}
-  labels:
-    {{- range $key, $value := .K8sConfig.Labels }}
-    {{ $key }}: {{ $value }}
-    {{- end }}
-spec:
-  selector:
-    matchLabels:
-      {{- range $key, $value := .K8sConfig.Selector }}
-      {{ $key }}: {{ $value }}
-      {{- end }}
-  endpoints:
-  - port: {{ .K8sConfig.Port }}
-    {{- if .K8sConfig.Path }}
-    path: {{ .K8sConfig.Path }}
-    {{- end }}
-    {{- if .K8sConfig.Interval }}
-    interval: {{ .K8sConfig.Interval }}
-    {{- end }}
-    {{- if .K8sConfig.TLSConfig }}
-    tlsConfig:
-      {{- if .K8sConfig.TLSConfig.InsecureSkipVerify }}
-      insecureSkipVerify: {{ .K8sConfig.TLSConfig.InsecureSkipVerify }}
-      {{- end }}
-      {{- if .K8sConfig.TLSConfig.CAFile }}
-      caFile: {{ .K8sConfig.TLSConfig.CAFile }}
-      {{- end }}
-      {{- if .K8sConfig.TLSConfig.CertFile }}
-      certFile: {{ .K8sConfig.TLSConfig.CertFile }}
-      {{- end }}
-      {{- if .K8sConfig.TLSConfig.KeyFile }}
-      keyFile: {{ .K8sConfig.TLSConfig.KeyFile }}
-      {{- end }}
-      {{- if .K8sConfig.TLSConfig.ServerName }}
-      serverName: {{ .K8sConfig.TLSConfig.ServerName }}
-      {{- end }}
-    {{- end }}
-    metricRelabelings:
-    {{ .MetricRelabelings }}
-`
-
-// New PodMonitor template based on Prometheus Operator CRD
-const newPodMonitorTemplate = `apiVersion: monitoring.coreos.com/v1
-kind: PodMonitor
-metadata:
-  name: {{ .Rule.Output.MetricName }}-monitor
-  namespace: {{ .K8sConfig.Namespace }}
-  labels:
-    {{- range $key, $value := .K8sConfig.Labels }}
-    {{ $key }}: {{ $value }}
-    {{- end }}
-spec:
-  selector:
-    matchLabels:
-      {{- range $key, $value := .K8sConfig.Selector }}
-      {{ $key }}: {{ $value }}
-      {{- end }}
-  podMetricsEndpoints:
-  - port: {{ .K8sConfig.Port }}
-    {{- if .K8sConfig.Path }}
-    path: {{ .K8sConfig.Path }}
-    {{- end }}
-    {{- if .K8sConfig.Interval }}
-    interval: {{ .K8sConfig.Interval }}
-    {{- end }}
-    {{- if .K8sConfig.TLSConfig }}
-    tlsConfig:
-      {{- if .K8sConfig.TLSConfig.InsecureSkipVerify }}
-      insecureSkipVerify: {{ .K8sConfig.TLSConfig.InsecureSkipVerify }}
-      {{- end }}
-      {{- if .K8sConfig.TLSConfig.CAFile }}
-      caFile: {{ .K8sConfig.TLSConfig.CAFile }}
-      {{- end }}
-      {{- if .K8sConfig.TLSConfig.CertFile }}
-      certFile: {{ .K8sConfig.TLSConfig.CertFile }}
-      {{- end }}
-      {{- if .K8sConfig.TLSConfig.KeyFile }}
-      keyFile: {{ .K8sConfig.TLSConfig.KeyFile }}
-      {{- end }}
-      {{- if .K8sConfig.TLSConfig.ServerName }}
-      serverName: {{ .K8sConfig.TLSConfig.ServerName }}
-      {{- end }}
-    {{- end }}
-    metricRelabelings:
-    {{ .MetricRelabelings }}
-`
-
-// Modify ServiceMonitor template - shows how to patch an existing monitor
-const modifyServiceMonitorTemplate = `# Applied modifications to ServiceMonitor: {{ .K8sConfig.ExistingMonitorName }}
-# This is a patch to be applied to the existing ServiceMonitor
-apiVersion: monitoring.coreos.com/v1
-kind: ServiceMonitor
-metadata:
-  name: {{ .K8sConfig.ExistingMonitorName }}
-  namespace: {{ .K8sConfig.Namespace }}
-spec:
-  endpoints:
-  # Add these metricRelabelings to the appropriate endpoint in your ServiceMonitor
-  - metricRelabelings:
-    {{ .MetricRelabelings }}
-`
-
-// Modify PodMonitor template - shows how to patch an existing monitor
-const modifyPodMonitorTemplate = `# Applied modifications to PodMonitor: {{ .K8sConfig.ExistingMonitorName }}
-# This is a patch to be applied to the existing PodMonitor
-apiVersion: monitoring.coreos.com/v1
-kind: PodMonitor
-metadata:
-  name: {{ .K8sConfig.ExistingMonitorName }}
-  namespace: {{ .K8sConfig.Namespace }}
-spec:
-  podMetricsEndpoints:
-  # Add these metricRelabelings to the appropriate endpoint in your PodMonitor
-  - metricRelabelings:
-    {{ .MetricRelabelings }}
-`
-
-// RenderMonitor renders a monitor template as a string
-func RenderMonitor(rule *models.Rule) (string, error) {
+// RenderMonitor renders a monitor template as a string, reading existing
+// monitor files from existingDir
+func RenderMonitor(rule *models.Rule, existingDir string) (string, error) {
 	gen, err := NewGenerator("")
 	if err != nil {
 		return "", err
 	}
+	gen.SetExistingMonitorsDir(existingDir)
 	return gen.Generate(rule)
 }
 
-// WriteMonitorFile generates a monitor file for a rule
-func WriteMonitorFile(rule *models.Rule, outputDir string) (string, error) {
+// WriteMonitorFile generates a monitor file for a rule, reading existing
+// monitor files from existingDir
+func WriteMonitorFile(rule *models.Rule, outputDir, existingDir string) (string, error) {
 	gen, err := NewGenerator(outputDir)
 	if err != nil {
 		return "", err
 	}
+	gen.SetExistingMonitorsDir(existingDir)
 	return gen.Generate(rule)
 }
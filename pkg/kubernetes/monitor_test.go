@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"sigs.k8s.io/yaml"
 )
 
 func TestGenerator_GenerateNewMonitor(t *testing.T) {
@@ -288,19 +289,23 @@ func TestGenerator_BuildMetricRelabelings(t *testing.T) {
 			relabelings := generator.buildMetricRelabelings(tt.rule)
 
 			if tt.expectCustomConfig {
-				// For predefined config, check if JSON structure is preserved
-				if !strings.Contains(relabelings, `"action":`) {
-					t.Errorf("Expected predefined relabeling config to be preserved")
+				// For predefined config, check that the rules are preserved
+				if len(relabelings) != len(tt.rule.OutputKubernetes.MetricRelabeling) || relabelings[0].Action != "keep" {
+					t.Errorf("Expected predefined relabeling config to be preserved, got %+v", relabelings)
 				}
 			} else {
 				// For auto-generated config, check for keep and drop actions
-				if tt.expectKeepMetric != "" && !strings.Contains(relabelings, tt.expectKeepMetric) {
-					t.Errorf("Expected keep action for %s but not found", tt.expectKeepMetric)
+				if tt.expectKeepMetric != "" && (relabelings[0].Action != "keep" || relabelings[0].Regex != tt.expectKeepMetric) {
+					t.Errorf("Expected keep action for %s but got %+v", tt.expectKeepMetric, relabelings[0])
 				}
 
-				for _, dropMetric := range tt.expectDropMetrics {
-					if !strings.Contains(relabelings, dropMetric) {
-						t.Errorf("Expected drop action for %s but not found", dropMetric)
+				drops := relabelings[1:]
+				if len(drops) != len(tt.expectDropMetrics) {
+					t.Fatalf("Expected %d drop actions, got %+v", len(tt.expectDropMetrics), drops)
+				}
+				for i, dropMetric := range tt.expectDropMetrics {
+					if drops[i].Action != "drop" || !strings.Contains(drops[i].Regex, dropMetric) {
+						t.Errorf("Expected drop action for %s but got %+v", dropMetric, drops[i])
 					}
 				}
 			}
@@ -308,6 +313,43 @@ func TestGenerator_BuildMetricRelabelings(t *testing.T) {
 	}
 }
 
+func TestGenerator_BuildMetricRelabelings_Rollout(t *testing.T) {
+	generator, err := NewGenerator(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	percentage := 3
+	rule := &models.Rule{
+		Matcher: models.MetricMatcher{MetricNames: []string{"original_metric"}},
+		Output: models.OutputConfig{
+			MetricName:        "aggregated_metric",
+			RolloutPercentage: &percentage,
+			RolloutLabels:     []string{"pod"},
+		},
+		OutputKubernetes: &models.KubernetesOutputConfig{DropOriginalMetrics: true},
+	}
+
+	relabelings := generator.buildMetricRelabelings(rule)
+	if len(relabelings) != 3 {
+		t.Fatalf("Expected keep, hashmod and drop relabelings, got %+v", relabelings)
+	}
+	hash := relabelings[1]
+	if hash.Action != "hashmod" || hash.Modulus != 100 || hash.TargetLabel != rolloutHashLabel || hash.SourceLabels[0] != "pod" {
+		t.Errorf("Unexpected hashmod relabeling %+v", hash)
+	}
+	drop := relabelings[2]
+	if drop.Action != "drop" || drop.Regex != "original_metric;(0|1|2)" || drop.SourceLabels[1] != rolloutHashLabel {
+		t.Errorf("Unexpected drop relabeling %+v", drop)
+	}
+
+	// Nothing is dropped at 0%
+	percentage = 0
+	if relabelings := generator.buildMetricRelabelings(rule); len(relabelings) != 1 {
+		t.Errorf("Expected only the keep relabeling at 0%%, got %+v", relabelings)
+	}
+}
+
 func TestRenderMonitor(t *testing.T) {
 	// Create a simple rule for rendering
 	rule := &models.Rule{
@@ -333,7 +375,7 @@ func TestRenderMonitor(t *testing.T) {
 	}
 
 	// Test RenderMonitor function
-	output, err := RenderMonitor(rule)
+	output, err := RenderMonitor(rule, "")
 	if err != nil {
 		t.Fatalf("RenderMonitor failed: %v", err)
 	}
@@ -394,7 +436,7 @@ func TestWriteMonitorFile(t *testing.T) {
 	}
 
 	// Test WriteMonitorFile function
-	filePath, err := WriteMonitorFile(rule, tempDir)
+	filePath, err := WriteMonitorFile(rule, tempDir, "")
 	if err != nil {
 		t.Fatalf("WriteMonitorFile failed: %v", err)
 	}
@@ -502,4 +544,319 @@ func TestGeneratorErrors(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error for missing existing monitor name in modify mode but got nil")
 	}
-}
\ No newline at end of file
+}
+func TestGenerator_GenerateMultipleTargets(t *testing.T) {
+	generator, err := NewGenerator("")
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	rule := &models.Rule{
+		ID:   "multi-target",
+		Name: "Multi Target",
+		Matcher: models.MetricMatcher{
+			MetricNames: []string{"http_requests_total"},
+		},
+		Output: models.OutputConfig{
+			MetricName: "http_requests_aggregated",
+		},
+		OutputKubernetes: &models.KubernetesOutputConfig{
+			Enabled:      true,
+			ResourceType: "PodMonitor",
+			Mode:         "create",
+			Namespace:    "default",
+			Port:         "metrics",
+			Targets: []models.MonitorTarget{
+				{Namespace: "checkout", Selector: map[string]string{"app": "checkout"}},
+				{Name: "payments-api", Namespace: "payments", Selector: map[string]string{"app": "payments"}, Port: "http"},
+			},
+		},
+	}
+
+	content, err := generator.Generate(rule)
+	if err != nil {
+		t.Fatalf("Failed to generate monitors: %v", err)
+	}
+
+	documents := strings.Split(content, "---\n")
+	if len(documents) != 2 {
+		t.Fatalf("Expected 2 documents, got %d", len(documents))
+	}
+
+	expected := [][]string{
+		{"name: http_requests_aggregated-checkout-monitor", "namespace: checkout", "app: checkout", "port: metrics"},
+		{"name: http_requests_aggregated-payments-api-monitor", "namespace: payments", "app: payments", "port: http"},
+	}
+	for i, elements := range expected {
+		for _, element := range elements {
+			if !strings.Contains(documents[i], element) {
+				t.Errorf("Expected '%s' in document %d but it was not found", element, i)
+			}
+		}
+	}
+
+	// Targets must produce distinct monitors
+	rule.OutputKubernetes.Targets = append(rule.OutputKubernetes.Targets, models.MonitorTarget{Namespace: "checkout"})
+	if _, err := generator.Generate(rule); err == nil {
+		t.Error("Expected error for duplicate target names but got nil")
+	}
+}
+
+func TestRenderMonitor_EscapesSpecialCharacters(t *testing.T) {
+	rule := &models.Rule{
+		ID: "escape-test",
+		Output: models.OutputConfig{
+			MetricName: "escape_aggregated",
+		},
+		OutputKubernetes: &models.KubernetesOutputConfig{
+			Enabled:      true,
+			ResourceType: "ServiceMonitor",
+			Namespace:    "test",
+			Labels: map[string]string{
+				"team": "a: b # c",
+			},
+			Selector: map[string]string{
+				"app.kubernetes.io/name": "*",
+			},
+			Port: "metrics",
+		},
+	}
+
+	output, err := RenderMonitor(rule, "")
+	if err != nil {
+		t.Fatalf("RenderMonitor failed: %v", err)
+	}
+
+	var monitor ServiceMonitor
+	if err := yaml.Unmarshal([]byte(output), &monitor); err != nil {
+		t.Fatalf("RenderMonitor produced invalid YAML: %v\n%s", err, output)
+	}
+	if monitor.Metadata.Labels["team"] != "a: b # c" {
+		t.Errorf("Label value not preserved: %q", monitor.Metadata.Labels["team"])
+	}
+	if monitor.Spec.Selector.MatchLabels["app.kubernetes.io/name"] != "*" {
+		t.Errorf("Selector value not preserved: %v", monitor.Spec.Selector.MatchLabels)
+	}
+	if len(monitor.Spec.Endpoints) != 1 || monitor.Spec.Endpoints[0].MetricRelabelConfigs[0].Regex != "escape_aggregated" {
+		t.Errorf("Unexpected endpoints: %+v", monitor.Spec.Endpoints)
+	}
+}
+
+func TestGenerator_PatchExistingMonitor(t *testing.T) {
+	tempDir := t.TempDir()
+
+	existingFile := filepath.Join(tempDir, "existing.yaml")
+	existing := `apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: my-service-monitor
+  namespace: monitoring
+  resourceVersion: "12345"
+spec:
+  selector:
+    matchLabels:
+      app: my-service
+  endpoints:
+    - port: web
+    - port: metrics
+      honorLabels: true
+      metricRelabelings:
+        - sourceLabels: [__name__]
+          regex: go_.*
+          action: drop
+`
+	if err := os.WriteFile(existingFile, []byte(existing), 0644); err != nil {
+		t.Fatalf("Failed to write existing monitor: %v", err)
+	}
+
+	rule := &models.Rule{
+		ID: "patch-test",
+		Matcher: models.MetricMatcher{
+			MetricNames: []string{"http_requests_total"},
+		},
+		Output: models.OutputConfig{
+			MetricName: "http_requests_aggregated",
+		},
+		OutputKubernetes: &models.KubernetesOutputConfig{
+			Enabled:             true,
+			ResourceType:        "ServiceMonitor",
+			Mode:                "patch",
+			Port:                "metrics",
+			ExistingMonitorFile: "existing.yaml",
+			DropOriginalMetrics: true,
+		},
+	}
+
+	generator, err := NewGenerator("")
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	generator.SetExistingMonitorsDir(tempDir)
+
+	patches, err := generator.PatchMonitors(rule)
+	if err != nil {
+		t.Fatalf("PatchMonitors failed: %v", err)
+	}
+	if len(patches) != 1 {
+		t.Fatalf("Expected 1 patch, got %d", len(patches))
+	}
+
+	// Only the metrics endpoint is patched, appending to its relabelings
+	patch := patches[0]
+	if patch.Name != "my-service-monitor" || len(patch.Patch) != 2 {
+		t.Fatalf("Unexpected patch: %+v", patch)
+	}
+	for _, op := range patch.Patch {
+		if op.Op != "add" || op.Path != "/spec/endpoints/1/metricRelabelings/-" {
+			t.Errorf("Unexpected patch operation: %+v", op)
+		}
+	}
+
+	// The merged object keeps unknown fields and drops server-managed ones
+	output, err := generator.Generate(rule)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, expected := range []string{"honorLabels: true", "regex: go_.*", "regex: http_requests_aggregated", "regex: http_requests_total"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected '%s' in merged monitor but it was not found", expected)
+		}
+	}
+	if strings.Contains(output, "resourceVersion") {
+		t.Error("Merged monitor should not contain resourceVersion")
+	}
+
+	// Patching an up-to-date monitor is a no-op
+	mergedFile := filepath.Join(tempDir, "merged.yaml")
+	if err := os.WriteFile(mergedFile, []byte(output), 0644); err != nil {
+		t.Fatalf("Failed to write merged monitor: %v", err)
+	}
+	rule.OutputKubernetes.ExistingMonitorFile = "merged.yaml"
+	patches, err = generator.PatchMonitors(rule)
+	if err != nil {
+		t.Fatalf("PatchMonitors failed: %v", err)
+	}
+	if len(patches[0].Patch) != 0 {
+		t.Errorf("Expected no patch operations, got %+v", patches[0].Patch)
+	}
+
+	// Files outside of the existing monitors directory cannot be read
+	for _, file := range []string{existingFile, "../existing.yaml", "nested/../../existing.yaml"} {
+		rule.OutputKubernetes.ExistingMonitorFile = file
+		if _, err := generator.PatchMonitors(rule); err == nil {
+			t.Errorf("PatchMonitors read existing monitor file %q", file)
+		}
+	}
+}
+
+type fakeMonitorReader map[string]map[string]interface{}
+
+func (f fakeMonitorReader) GetMonitor(kind, namespace, name string) (map[string]interface{}, error) {
+	return f[namespace+"/"+name], nil
+}
+
+func TestGenerator_PatchMonitorFromReader(t *testing.T) {
+	generator, err := NewGenerator("")
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	generator.SetMonitorReader(fakeMonitorReader{
+		"apps/app-monitor": {
+			"kind":     "PodMonitor",
+			"metadata": map[string]interface{}{"name": "app-monitor", "namespace": "apps"},
+			"spec": map[string]interface{}{
+				"podMetricsEndpoints": []interface{}{map[string]interface{}{"port": "metrics"}},
+			},
+		},
+	})
+
+	rule := &models.Rule{
+		Output: models.OutputConfig{MetricName: "app_aggregated"},
+		OutputKubernetes: &models.KubernetesOutputConfig{
+			Enabled:             true,
+			ResourceType:        "PodMonitor",
+			Mode:                "patch",
+			Namespace:           "apps",
+			ExistingMonitorName: "app-monitor",
+		},
+	}
+
+	patches, err := generator.PatchMonitors(rule)
+	if err != nil {
+		t.Fatalf("PatchMonitors failed: %v", err)
+	}
+	ops := patches[0].Patch
+	if len(ops) != 1 || ops[0].Path != "/spec/podMetricsEndpoints/0/metricRelabelings" {
+		t.Errorf("Unexpected patch operations: %+v", ops)
+	}
+
+	// A mismatched resource type is an error
+	rule.OutputKubernetes.ResourceType = "ServiceMonitor"
+	if _, err := generator.PatchMonitors(rule); err == nil {
+		t.Error("Expected error for mismatched resource type but got nil")
+	}
+}
+
+func TestGenerator_GenerateAlerts(t *testing.T) {
+	rule := &models.Rule{
+		ID: "alerts-test",
+		Matcher: models.MetricMatcher{
+			MetricNames: []string{"http_requests_total", "grpc_*"},
+		},
+		Output: models.OutputConfig{
+			MetricName: "http_requests_aggregated",
+		},
+		OutputKubernetes: &models.KubernetesOutputConfig{
+			Enabled:             true,
+			ResourceType:        "ServiceMonitor",
+			Mode:                "create",
+			Namespace:           "monitoring",
+			Labels:              map[string]string{"release": "prometheus"},
+			Port:                "metrics",
+			DropOriginalMetrics: true,
+			Alerts: &models.AlertsConfig{
+				Enabled:  true,
+				Severity: "critical",
+			},
+		},
+	}
+
+	output, err := RenderMonitor(rule, "")
+	if err != nil {
+		t.Fatalf("RenderMonitor failed: %v", err)
+	}
+
+	documents := strings.Split(output, "---\n")
+	if len(documents) != 2 {
+		t.Fatalf("Expected monitor and alerts documents, got %d", len(documents))
+	}
+
+	var alerts PrometheusRule
+	if err := yaml.Unmarshal([]byte(documents[1]), &alerts); err != nil {
+		t.Fatalf("Failed to parse alerts: %v", err)
+	}
+	if alerts.Kind != KindPrometheusRule || alerts.Metadata.Namespace != "monitoring" || alerts.Metadata.Labels["release"] != "prometheus" {
+		t.Errorf("Unexpected PrometheusRule metadata: %+v", alerts)
+	}
+
+	rules := alerts.Spec.Groups[0].Rules
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 alerts, got %d", len(rules))
+	}
+	if rules[0].Expr != "absent(http_requests_aggregated)" || rules[0].For != "10m" || rules[0].Labels["severity"] != "critical" {
+		t.Errorf("Unexpected absent alert: %+v", rules[0])
+	}
+	if rules[1].Expr != `count by (__name__) ({__name__=~"http_requests_total|grpc_.*"}) > 0` {
+		t.Errorf("Unexpected reappeared alert expression: %s", rules[1].Expr)
+	}
+
+	// Without dropping originals, only the absent alert is generated
+	rule.OutputKubernetes.DropOriginalMetrics = false
+	output, err = RenderAlerts(rule)
+	if err != nil {
+		t.Fatalf("RenderAlerts failed: %v", err)
+	}
+	if strings.Contains(output, "AdaptiveMetricsOriginalMetricsReappeared") {
+		t.Error("Unexpected reappeared alert without dropped original metrics")
+	}
+}
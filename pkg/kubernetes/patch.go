@@ -0,0 +1,240 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"sigs.k8s.io/yaml"
+)
+
+// PatchOperation is a JSON patch (RFC 6902) operation
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// MonitorPatch adds a rule's metric relabelings to an existing monitor
+type MonitorPatch struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Patch is the JSON patch to apply to the existing monitor, for example
+	// with kubectl patch --type=json. It is empty if the monitor is up to date.
+	Patch []PatchOperation `json:"patch"`
+	// Merged is the full monitor with the patch applied, ready for kubectl apply
+	Merged map[string]interface{} `json:"merged"`
+}
+
+// serverFields are metadata fields set by the API server, removed from merged
+// monitors so they can be applied
+var serverFields = []string{"resourceVersion", "uid", "generation", "creationTimestamp", "managedFields", "selfLink"}
+
+// SetMonitorReader sets the reader used to fetch existing monitors that are
+// not read from a file. By default the in-cluster service account is used.
+func (g *Generator) SetMonitorReader(reader MonitorReader) {
+	g.reader = reader
+}
+
+// SetExistingMonitorsDir sets the directory existing monitor files are read
+// from. Files outside of it cannot be read, and none can when it is empty.
+func (g *Generator) SetExistingMonitorsDir(dir string) {
+	g.existingDir = dir
+}
+
+// PatchMonitors computes the patches adding the rule's metric relabelings to
+// the existing monitor of every target
+func (g *Generator) PatchMonitors(rule *models.Rule) ([]*MonitorPatch, error) {
+	if rule == nil || rule.OutputKubernetes == nil || !rule.OutputKubernetes.Enabled {
+		return nil, fmt.Errorf("rule does not have Kubernetes output enabled")
+	}
+
+	config := rule.OutputKubernetes
+	relabelings := g.buildMetricRelabelings(rule)
+
+	var patches []*MonitorPatch
+	for _, target := range config.MonitorTargets() {
+		if target.ExistingMonitorName == "" && target.ExistingMonitorFile == "" {
+			return nil, fmt.Errorf("existing monitor name or file must be provided for patch mode")
+		}
+
+		existing, err := g.existingMonitor(config.ResourceType, target)
+		if err != nil {
+			return nil, err
+		}
+
+		patch, err := PatchMonitor(existing, config.ResourceType, target.Port, relabelings)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, patch)
+	}
+
+	return patches, nil
+}
+
+// existingMonitor reads the existing monitor of a target from its file or the cluster
+func (g *Generator) existingMonitor(kind string, target models.MonitorTarget) (map[string]interface{}, error) {
+	if target.ExistingMonitorFile != "" {
+		path, err := g.existingMonitorPath(target.ExistingMonitorFile)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read existing monitor: %w", err)
+		}
+
+		var monitor map[string]interface{}
+		if err := yaml.Unmarshal(content, &monitor); err != nil {
+			return nil, fmt.Errorf("failed to parse existing monitor: %w", err)
+		}
+		return monitor, nil
+	}
+
+	if g.reader == nil {
+		client, err := NewInClusterClient()
+		if err != nil {
+			return nil, fmt.Errorf("existing monitor file not set and cannot read from cluster: %w", err)
+		}
+		g.reader = client
+	}
+
+	return g.reader.GetMonitor(kind, target.Namespace, target.ExistingMonitorName)
+}
+
+// existingMonitorPath resolves an existing monitor file inside the existing
+// monitors directory
+func (g *Generator) existingMonitorPath(file string) (string, error) {
+	if g.existingDir == "" {
+		return "", fmt.Errorf("existing monitor files are not enabled; set kubernetes.existing_monitors_dir")
+	}
+	cleaned := filepath.Clean(file)
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("existing monitor file %q must be relative to the existing monitors directory", file)
+	}
+	return filepath.Join(g.existingDir, cleaned), nil
+}
+
+// PatchMonitor computes the patch adding relabelings to the metricRelabelings
+// of an existing monitor. Only endpoints with the given port are patched, or
+// all endpoints if port is empty. Relabelings already present are skipped.
+func PatchMonitor(existing map[string]interface{}, kind, port string, relabelings []RelabelConfig) (*MonitorPatch, error) {
+	if existingKind, _ := existing["kind"].(string); existingKind != kind {
+		return nil, fmt.Errorf("existing monitor is a %s, expected %s", existingKind, kind)
+	}
+
+	endpointsField := "endpoints"
+	if kind == KindPodMonitor {
+		endpointsField = "podMetricsEndpoints"
+	}
+
+	// Work on a copy so the existing object is left untouched
+	merged, err := toUnstructured(existing)
+	if err != nil {
+		return nil, err
+	}
+	mergedMap := merged.(map[string]interface{})
+
+	metadata, _ := mergedMap["metadata"].(map[string]interface{})
+	patch := &MonitorPatch{Kind: kind, Merged: mergedMap, Patch: []PatchOperation{}}
+	if metadata != nil {
+		patch.Name, _ = metadata["name"].(string)
+		patch.Namespace, _ = metadata["namespace"].(string)
+		for _, field := range serverFields {
+			delete(metadata, field)
+		}
+	}
+	delete(mergedMap, "status")
+
+	spec, _ := mergedMap["spec"].(map[string]interface{})
+	endpoints, _ := spec[endpointsField].([]interface{})
+
+	patched := 0
+	for i, item := range endpoints {
+		endpoint, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if port != "" && endpoint["port"] != port {
+			continue
+		}
+		patched++
+
+		path := fmt.Sprintf("/spec/%s/%d/metricRelabelings", endpointsField, i)
+		current, hasRelabelings := endpoint["metricRelabelings"].([]interface{})
+
+		var added []interface{}
+		for _, relabeling := range relabelings {
+			value, err := toUnstructured(relabeling)
+			if err != nil {
+				return nil, err
+			}
+			if !containsValue(current, value) && !containsValue(added, value) {
+				added = append(added, value)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+
+		if hasRelabelings {
+			for _, value := range added {
+				patch.Patch = append(patch.Patch, PatchOperation{Op: "add", Path: path + "/-", Value: value})
+			}
+		} else {
+			patch.Patch = append(patch.Patch, PatchOperation{Op: "add", Path: path, Value: added})
+		}
+		endpoint["metricRelabelings"] = append(current, added...)
+	}
+
+	if patched == 0 {
+		if port != "" {
+			return nil, fmt.Errorf("%s %s/%s has no endpoint with port %s", kind, patch.Namespace, patch.Name, port)
+		}
+		return nil, fmt.Errorf("%s %s/%s has no endpoints", kind, patch.Namespace, patch.Name)
+	}
+
+	return patch, nil
+}
+
+// toUnstructured converts a value to its generic JSON representation
+func toUnstructured(value interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(encoded, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode value: %w", err)
+	}
+
+	return result, nil
+}
+
+// containsValue reports whether list holds a value equal to value
+func containsValue(list []interface{}, value interface{}) bool {
+	encoded, _ := json.Marshal(value)
+	for _, item := range list {
+		if existing, _ := json.Marshal(item); string(existing) == string(encoded) {
+			return true
+		}
+	}
+	return false
+}
+
+// PatchMonitors computes the patches adding a rule's metric relabelings to its
+// existing monitors, reading existing monitor files from existingDir
+func PatchMonitors(rule *models.Rule, existingDir string) ([]*MonitorPatch, error) {
+	gen, err := NewGenerator("")
+	if err != nil {
+		return nil, err
+	}
+	gen.SetExistingMonitorsDir(existingDir)
+	return gen.PatchMonitors(rule)
+}
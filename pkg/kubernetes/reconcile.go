@@ -0,0 +1,163 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+// monitorFilePattern matches the files the generator writes monitors to
+var monitorFilePattern = regexp.MustCompile(`^(modified-|patched-)?(ServiceMonitor|PodMonitor)-.+\.(yaml|patch\.json)$`)
+
+// ReconcileReport compares the rules with Kubernetes output against their
+// generated monitor files and, optionally, the monitors in the cluster
+type ReconcileReport struct {
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`
+	// Missing lists rules whose monitor file does not exist
+	Missing []MissingMonitor `json:"missing"`
+	// Orphaned lists monitor files of rules that no longer exist
+	Orphaned []string `json:"orphaned"`
+	// MissingInCluster lists monitors of rules that do not exist in the cluster
+	MissingInCluster []ClusterMonitor `json:"missing_in_cluster,omitempty"`
+	// Fixed lists the files generated or deleted by the auto-fix
+	Fixed  []string `json:"fixed,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// MissingMonitor is a rule whose monitor file does not exist
+type MissingMonitor struct {
+	RuleID string `json:"rule_id"`
+	File   string `json:"file"`
+}
+
+// ClusterMonitor is a monitor of a rule in the cluster
+type ClusterMonitor struct {
+	RuleID    string `json:"rule_id"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Consistent reports whether the rules and their monitors match
+func (r *ReconcileReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Orphaned) == 0 && len(r.MissingInCluster) == 0
+}
+
+// Reconcile compares the rules with Kubernetes output against the monitor
+// files in dir, reading the existing monitors of rules in patch mode from
+// existingDir. If reader is set, it also checks that their monitors exist in
+// the cluster. With fix, missing files are generated and orphaned ones
+// deleted; monitors missing in the cluster are only reported.
+func Reconcile(rules []*models.Rule, dir, existingDir string, reader MonitorReader, fix bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		Time:     time.Now(),
+		Dir:      dir,
+		Missing:  []MissingMonitor{},
+		Orphaned: []string{},
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read monitors directory: %w", err)
+	}
+	existing := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && monitorFilePattern.MatchString(entry.Name()) {
+			existing[entry.Name()] = true
+		}
+	}
+
+	expected := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.OutputKubernetes == nil || !rule.OutputKubernetes.Enabled {
+			continue
+		}
+		file := MonitorFile(rule)
+		expected[file] = true
+		if rule.OutputKubernetes.Mode == "patch" {
+			expected[strings.TrimSuffix(file, ".yaml")+".patch.json"] = true
+		}
+		if !existing[file] {
+			report.Missing = append(report.Missing, MissingMonitor{RuleID: rule.ID, File: file})
+		}
+		if reader != nil {
+			report.checkCluster(rule, reader)
+		}
+	}
+
+	for file := range existing {
+		if !expected[file] {
+			report.Orphaned = append(report.Orphaned, file)
+		}
+	}
+	sort.Strings(report.Orphaned)
+
+	if fix {
+		report.fix(rules, dir, existingDir)
+	}
+
+	return report, nil
+}
+
+// checkCluster reports the monitors of a rule missing in the cluster
+func (r *ReconcileReport) checkCluster(rule *models.Rule, reader MonitorReader) {
+	config := rule.OutputKubernetes
+	for _, target := range config.MonitorTargets() {
+		name := target.ExistingMonitorName
+		if config.Mode != "modify" && config.Mode != "patch" {
+			name = MonitorName(rule, target)
+		}
+
+		_, err := reader.GetMonitor(config.ResourceType, target.Namespace, name)
+		if errors.Is(err, ErrNotFound) {
+			r.MissingInCluster = append(r.MissingInCluster, ClusterMonitor{
+				RuleID:    rule.ID,
+				Kind:      config.ResourceType,
+				Namespace: target.Namespace,
+				Name:      name,
+			})
+		} else if err != nil {
+			r.Errors = append(r.Errors, err.Error())
+		}
+	}
+}
+
+// fix generates the missing monitor files and deletes the orphaned ones
+func (r *ReconcileReport) fix(rules []*models.Rule, dir, existingDir string) {
+	byID := make(map[string]*models.Rule, len(rules))
+	for _, rule := range rules {
+		byID[rule.ID] = rule
+	}
+
+	if len(r.Missing) > 0 {
+		generator, err := NewGenerator(dir)
+		if err != nil {
+			r.Errors = append(r.Errors, err.Error())
+			return
+		}
+		generator.SetExistingMonitorsDir(existingDir)
+		for _, missing := range r.Missing {
+			if _, err := generator.Generate(byID[missing.RuleID]); err != nil {
+				r.Errors = append(r.Errors, fmt.Sprintf("failed to generate %s: %v", missing.File, err))
+				continue
+			}
+			r.Fixed = append(r.Fixed, missing.File)
+		}
+	}
+
+	for _, file := range r.Orphaned {
+		if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+			r.Errors = append(r.Errors, fmt.Sprintf("failed to delete %s: %v", file, err))
+			continue
+		}
+		r.Fixed = append(r.Fixed, file)
+	}
+}
@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func reconcileTestRule(id string) *models.Rule {
+	return &models.Rule{
+		ID:      id,
+		Name:    id,
+		Enabled: true,
+		Matcher: models.MetricMatcher{MetricNames: []string{"http_requests_total"}},
+		Aggregation: models.AggregationConfig{
+			Type:            "sum",
+			IntervalSeconds: 60,
+		},
+		Output: models.OutputConfig{MetricName: id + "_aggregated"},
+		OutputKubernetes: &models.KubernetesOutputConfig{
+			Enabled:      true,
+			ResourceType: "ServiceMonitor",
+			Mode:         "create",
+			Namespace:    "monitoring",
+			Selector:     map[string]string{"app": "my-app"},
+		},
+	}
+}
+
+type missingMonitorReader struct{}
+
+func (missingMonitorReader) GetMonitor(kind, namespace, name string) (map[string]interface{}, error) {
+	return nil, fmt.Errorf("%s %s/%s: %w", kind, namespace, name, ErrNotFound)
+}
+
+func TestReconcile(t *testing.T) {
+	dir := t.TempDir()
+	generated, missing := reconcileTestRule("generated"), reconcileTestRule("missing")
+
+	generator, err := NewGenerator(dir)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	if _, err := generator.Generate(generated); err != nil {
+		t.Fatalf("Failed to generate monitor: %v", err)
+	}
+	// A monitor of a deleted rule, and a file the generator did not write
+	if err := os.WriteFile(filepath.Join(dir, "ServiceMonitor-deleted.yaml"), []byte("kind: ServiceMonitor\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("notes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	disabled := reconcileTestRule("disabled")
+	disabled.OutputKubernetes.Enabled = false
+	rules := []*models.Rule{generated, missing, disabled}
+
+	report, err := Reconcile(rules, dir, "", nil, false)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if report.Consistent() {
+		t.Fatal("Expected an inconsistent report")
+	}
+	if len(report.Missing) != 1 || report.Missing[0].RuleID != "missing" || report.Missing[0].File != "ServiceMonitor-missing.yaml" {
+		t.Errorf("Unexpected missing monitors: %+v", report.Missing)
+	}
+	if len(report.Orphaned) != 1 || report.Orphaned[0] != "ServiceMonitor-deleted.yaml" {
+		t.Errorf("Unexpected orphaned monitors: %v", report.Orphaned)
+	}
+	if len(report.Fixed) != 0 {
+		t.Errorf("Expected nothing fixed without fix, got %v", report.Fixed)
+	}
+
+	report, err = Reconcile(rules, dir, "", nil, true)
+	if err != nil {
+		t.Fatalf("Reconcile with fix failed: %v", err)
+	}
+	if len(report.Fixed) != 2 || len(report.Errors) != 0 {
+		t.Errorf("Expected 2 fixed files and no errors, got %v and %v", report.Fixed, report.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ServiceMonitor-missing.yaml")); err != nil {
+		t.Errorf("Expected missing monitor to be generated: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ServiceMonitor-deleted.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected orphaned monitor to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "README.md")); err != nil {
+		t.Errorf("Expected unrelated file to be kept: %v", err)
+	}
+
+	report, err = Reconcile(rules, dir, "", nil, false)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if !report.Consistent() {
+		t.Errorf("Expected a consistent report after fix, got %+v", report)
+	}
+}
+
+func TestReconcile_Cluster(t *testing.T) {
+	rule := reconcileTestRule("cluster")
+
+	report, err := Reconcile([]*models.Rule{rule}, t.TempDir(), "", missingMonitorReader{}, false)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(report.MissingInCluster) != 1 {
+		t.Fatalf("Expected 1 monitor missing in the cluster, got %+v", report.MissingInCluster)
+	}
+	missing := report.MissingInCluster[0]
+	if missing.Namespace != "monitoring" || missing.Name != MonitorName(rule, rule.OutputKubernetes.MonitorTargets()[0]) {
+		t.Errorf("Unexpected monitor missing in the cluster: %+v", missing)
+	}
+	if len(report.Errors) != 0 {
+		t.Errorf("Expected no errors, got %v", report.Errors)
+	}
+}
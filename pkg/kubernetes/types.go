@@ -0,0 +1,122 @@
+package kubernetes
+
+// Typed subset of the monitoring.coreos.com/v1 API used by the generator.
+// Only the fields Adaptive Metrics sets are modelled; marshalling these types
+// instead of templating YAML keeps the output escaped and schema-valid.
+//
+// The types are declared here rather than imported from the Prometheus
+// Operator's monitoring/v1 package, which depends on k8s.io/api and
+// k8s.io/apimachinery and pins the Go version and Kubernetes libraries of the
+// whole module to theirs, for the dozen fields set here. They keep the JSON
+// names of the upstream types, and are marshalled with sigs.k8s.io/yaml like
+// Kubernetes objects, so that switching to the upstream types only changes
+// the type names.
+
+// APIVersion is the Prometheus Operator API version of generated resources
+const APIVersion = "monitoring.coreos.com/v1"
+
+// Kinds of monitor resources the generator supports
+const (
+	KindServiceMonitor = "ServiceMonitor"
+	KindPodMonitor     = "PodMonitor"
+)
+
+// ObjectMeta is the metadata of a Kubernetes object
+type ObjectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// LabelSelector selects objects by their labels
+type LabelSelector struct {
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+}
+
+// RelabelConfig is a Prometheus relabeling rule in Prometheus Operator format
+type RelabelConfig struct {
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	Separator    string   `json:"separator,omitempty"`
+	TargetLabel  string   `json:"targetLabel,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	Modulus      uint64   `json:"modulus,omitempty"`
+	Replacement  string   `json:"replacement,omitempty"`
+	Action       string   `json:"action,omitempty"`
+}
+
+// TLSConfig is the TLS configuration of a scrape endpoint
+type TLSConfig struct {
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+}
+
+// Endpoint is a scrape endpoint of a ServiceMonitor or PodMonitor
+type Endpoint struct {
+	Port                 string          `json:"port,omitempty"`
+	Path                 string          `json:"path,omitempty"`
+	Interval             string          `json:"interval,omitempty"`
+	TLSConfig            *TLSConfig      `json:"tlsConfig,omitempty"`
+	MetricRelabelConfigs []RelabelConfig `json:"metricRelabelings,omitempty"`
+}
+
+// ServiceMonitorSpec is the specification of a ServiceMonitor
+type ServiceMonitorSpec struct {
+	Selector  *LabelSelector `json:"selector,omitempty"`
+	Endpoints []Endpoint     `json:"endpoints"`
+}
+
+// ServiceMonitor scrapes the endpoints of selected services
+type ServiceMonitor struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Metadata   ObjectMeta         `json:"metadata"`
+	Spec       ServiceMonitorSpec `json:"spec"`
+}
+
+// PodMonitorSpec is the specification of a PodMonitor
+type PodMonitorSpec struct {
+	Selector            *LabelSelector `json:"selector,omitempty"`
+	PodMetricsEndpoints []Endpoint     `json:"podMetricsEndpoints"`
+}
+
+// PodMonitor scrapes selected pods directly
+type PodMonitor struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Metadata   ObjectMeta     `json:"metadata"`
+	Spec       PodMonitorSpec `json:"spec"`
+}
+
+// KindPrometheusRule is the kind of generated alerting rule resources
+const KindPrometheusRule = "PrometheusRule"
+
+// Rule is an alerting rule of a PrometheusRule
+type Rule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// RuleGroup is a group of rules evaluated together
+type RuleGroup struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// PrometheusRuleSpec is the specification of a PrometheusRule
+type PrometheusRuleSpec struct {
+	Groups []RuleGroup `json:"groups"`
+}
+
+// PrometheusRule holds Prometheus recording and alerting rules
+type PrometheusRule struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Metadata   ObjectMeta         `json:"metadata"`
+	Spec       PrometheusRuleSpec `json:"spec"`
+}
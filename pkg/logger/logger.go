@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/pkg/version"
 )
 
 // Level represents logging levels
@@ -47,11 +48,13 @@ func (l Level) String() string {
 
 // Logger provides structured logging capabilities
 type Logger struct {
-	level         Level
-	format        string
-	output        io.Writer
-	includeTime   bool
-	includeCaller bool
+	level          Level
+	format         string
+	output         io.Writer
+	includeTime    bool
+	includeCaller  bool
+	sampleInterval time.Duration
+	staticFields   Fields // Added to every entry, such as the version
 }
 
 // Fields represents a collection of log fields
@@ -82,6 +85,7 @@ func GetLogger() *Logger {
 			output:        os.Stdout,
 			includeTime:   true,
 			includeCaller: false,
+			staticFields:  buildFields(),
 		}
 	}
 	return defaultLogger
@@ -120,14 +124,27 @@ func New(cfg *config.LoggingConfig) (*Logger, error) {
 	}
 
 	return &Logger{
-		level:         level,
-		format:        format,
-		output:        output,
-		includeTime:   cfg.IncludeTimestamp,
-		includeCaller: cfg.IncludeCaller,
+		level:          level,
+		format:         format,
+		output:         output,
+		includeTime:    cfg.IncludeTimestamp,
+		includeCaller:  cfg.IncludeCaller,
+		sampleInterval: time.Duration(cfg.SampleIntervalSeconds) * time.Second,
+		staticFields:   buildFields(),
 	}, nil
 }
 
+// buildFields returns the build information added to every log entry, so
+// entries can be attributed to a release when several versions run
+func buildFields() Fields {
+	info := version.Get()
+	fields := Fields{"version": info.Version}
+	if info.Revision != "" {
+		fields["revision"] = info.Revision
+	}
+	return fields
+}
+
 // log logs a message at the specified level with fields
 func (l *Logger) log(level Level, msg string, fields Fields) {
 	if level < l.level {
@@ -136,6 +153,9 @@ func (l *Logger) log(level Level, msg string, fields Fields) {
 
 	// Merge standard fields
 	logFields := Fields{}
+	for k, v := range l.staticFields {
+		logFields[k] = v
+	}
 	if fields != nil {
 		for k, v := range fields {
 			logFields[k] = v
@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/pkg/version"
+)
+
+func TestLogger_StaticFields(t *testing.T) {
+	l, err := New(&config.LoggingConfig{Format: "json", Level: "info"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var buf bytes.Buffer
+	l.output = &buf
+
+	l.InfoWithFields("Started", Fields{"component": "server"})
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse log entry: %v", err)
+	}
+	if entry["version"] != version.Get().Version {
+		t.Errorf("version = %v, want %s", entry["version"], version.Get().Version)
+	}
+	if entry["component"] != "server" {
+		t.Errorf("component = %v, want server", entry["component"])
+	}
+}
@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultSampleInterval is used when no sample interval is configured
+const defaultSampleInterval = 10 * time.Second
+
+// Sampler rate-limits a repetitive log event on a hot path. The first event
+// is logged immediately; further events within the sample interval are only
+// counted and reported at the end of the interval, e.g. "count": 1523 events
+// over "interval": "10s", so the last burst is not lost when events stop.
+// Fields of the most recent event are kept as an example.
+type Sampler struct {
+	level      Level
+	msg        string
+	mu         sync.Mutex
+	suppressed int64
+	lastLogged time.Time
+	// Fields of the most recent suppressed event
+	fields Fields
+	// Flushes the suppressed events at the end of the interval
+	timer *time.Timer
+}
+
+// NewSampler creates a sampler for the given level and message. The sample
+// interval comes from the global logger configuration.
+func NewSampler(level Level, msg string) *Sampler {
+	return &Sampler{level: level, msg: msg}
+}
+
+// Log records an event and writes it if the sample interval has elapsed
+// since the last entry was written
+func (s *Sampler) Log(fields Fields) {
+	l := GetLogger()
+	if s.level < l.level {
+		return
+	}
+
+	now := time.Now()
+	interval := l.sampleInterval
+	if interval <= 0 {
+		interval = defaultSampleInterval
+	}
+
+	s.mu.Lock()
+	if !s.lastLogged.IsZero() && now.Sub(s.lastLogged) < interval {
+		s.suppressed++
+		s.fields = fields
+		if s.timer == nil {
+			s.timer = time.AfterFunc(s.lastLogged.Add(interval).Sub(now), s.flush)
+		}
+		s.mu.Unlock()
+		return
+	}
+	count := s.suppressed + 1
+	elapsed := now.Sub(s.lastLogged)
+	s.reset(now)
+	s.mu.Unlock()
+
+	s.write(l, fields, count, elapsed)
+}
+
+// flush writes the events suppressed since the last entry, if any
+func (s *Sampler) flush() {
+	now := time.Now()
+	s.mu.Lock()
+	s.timer = nil
+	if s.suppressed == 0 {
+		s.mu.Unlock()
+		return
+	}
+	count, fields := s.suppressed, s.fields
+	elapsed := now.Sub(s.lastLogged)
+	s.reset(now)
+	s.mu.Unlock()
+
+	s.write(GetLogger(), fields, count, elapsed)
+}
+
+// reset starts a new interval after an entry is written. s.mu must be held.
+func (s *Sampler) reset(now time.Time) {
+	s.suppressed = 0
+	s.fields = nil
+	s.lastLogged = now
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// write writes an entry reporting count events over elapsed
+func (s *Sampler) write(l *Logger, fields Fields, count int64, elapsed time.Duration) {
+	entry := make(Fields, len(fields)+2)
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["count"] = count
+	if count > 1 {
+		entry["interval"] = elapsed.Round(time.Second).String()
+	}
+
+	l.log(s.level, s.msg, entry)
+}
@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSampler_Log(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger = &Logger{level: Info, format: "json", output: &buf, sampleInterval: time.Hour}
+	defer func() { defaultLogger = nil }()
+
+	sampler := NewSampler(Warn, "Dropped metrics")
+	for i := 0; i < 100; i++ {
+		sampler.Log(Fields{"metric_name": "up"})
+	}
+
+	// Only the first event is written within the interval
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d log lines, want 1", len(lines))
+	}
+
+	// The next entry after the interval reports the suppressed events
+	sampler.lastLogged = time.Now().Add(-2 * time.Hour)
+	buf.Reset()
+	sampler.Log(Fields{"metric_name": "up"})
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse log entry: %v", err)
+	}
+	if entry["count"] != float64(100) {
+		t.Errorf("count = %v, want 100", entry["count"])
+	}
+	if entry["metric_name"] != "up" {
+		t.Errorf("metric_name = %v, want up", entry["metric_name"])
+	}
+}
+
+func TestSampler_FlushesSuppressedEvents(t *testing.T) {
+	var buf syncBuffer
+	defaultLogger = &Logger{level: Info, format: "json", output: &buf, sampleInterval: 50 * time.Millisecond}
+	defer func() { defaultLogger = nil }()
+
+	sampler := NewSampler(Warn, "Dropped metrics")
+	sampler.Log(Fields{"metric_name": "up"})
+	sampler.Log(Fields{"metric_name": "up"})
+	sampler.Log(Fields{"metric_name": "down"})
+
+	// The events suppressed in the last interval are written without a
+	// further event
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(buf.String(), "\n") < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2", len(lines))
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("failed to parse log entry: %v", err)
+	}
+	if entry["count"] != float64(2) || entry["metric_name"] != "down" {
+		t.Errorf("flushed entry = %v, want a count of 2 with the fields of the last event", entry)
+	}
+}
+
+// syncBuffer is a buffer safe for the writes of timers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -1,9 +1,11 @@
 package metrics
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/pkg/version"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -35,6 +37,192 @@ var (
 		[]string{"metric_name", "reason"},
 	)
 
+	// RateLimitedSamplesCounter counts samples rejected by per-tenant ingestion limits
+	RateLimitedSamplesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_rate_limited_samples_total",
+			Help: "Total number of samples rejected by per-tenant ingestion limits",
+		},
+		[]string{"tenant"},
+	)
+
+	// RateLimitedRequestsCounter counts requests rejected by per-client request limits
+	RateLimitedRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_rate_limited_requests_total",
+			Help: "Total number of requests rejected by per-client request rate limits",
+		},
+		[]string{"class"},
+	)
+
+	// IngestFilteredSamplesCounter counts samples dropped or passed through by ingest filters
+	IngestFilteredSamplesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_ingest_filtered_samples_total",
+			Help: "Total number of samples dropped or passed through untouched by ingest filters",
+		},
+		[]string{"action"},
+	)
+
+	// OutOfBoundsSamplesCounter counts ingested samples whose timestamp was too old or too far in the future
+	OutOfBoundsSamplesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_ingest_out_of_bounds_samples_total",
+			Help: "Total number of ingested samples with a timestamp too old or too far in the future, rejected or clamped",
+		},
+		[]string{"tenant", "reason", "action"},
+	)
+
+	// HADeduplicatedSamplesCounter counts samples of non-elected HA replicas that were dropped
+	HADeduplicatedSamplesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_ha_deduplicated_samples_total",
+			Help: "Total number of samples dropped because they came from a non-elected HA replica",
+		},
+		[]string{"cluster"},
+	)
+
+	// HAReplicaElectionsCounter counts failovers to another HA replica
+	HAReplicaElectionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_ha_replica_elections_total",
+			Help: "Total number of times another replica of an HA cluster was elected after a failover",
+		},
+		[]string{"cluster"},
+	)
+
+	// SubscriptionDropsCounter counts aggregated series dropped for subscribers that fell behind
+	SubscriptionDropsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_subscription_dropped_total",
+			Help: "Total number of aggregated series dropped because a subscriber's channel was full",
+		},
+		[]string{"subscriber"},
+	)
+
+	// RuleExpirationsCounter counts temporary rules disabled because they expired
+	RuleExpirationsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_rule_expirations_total",
+			Help: "Total number of temporary rules disabled because they expired",
+		},
+	)
+
+	// RuleOutputStallsCounter counts rules that received input but stopped producing output
+	RuleOutputStallsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_rule_output_stalls_total",
+			Help: "Total number of times a rule received input but produced no output",
+		},
+		[]string{"rule_id"},
+	)
+
+	// ShadowOutputSeriesGauge tracks the series a shadow rule produced in its last flush
+	ShadowOutputSeriesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "adaptive_metrics_shadow_output_series",
+			Help: "Number of series a shadow rule produced in its last aggregation",
+		},
+		[]string{"rule_id"},
+	)
+
+	// ShadowSamplesCounter counts the input samples aggregated by shadow rules
+	ShadowSamplesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_shadow_samples_total",
+			Help: "Total number of input samples aggregated by shadow rules",
+		},
+		[]string{"rule_id"},
+	)
+
+	// FederationScrapesCounter counts scrapes of Prometheus federation targets
+	FederationScrapesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_federation_scrapes_total",
+			Help: "Total number of scrapes of Prometheus federation targets",
+		},
+		[]string{"target", "result"},
+	)
+
+	// FederationSamplesCounter counts samples ingested from Prometheus federation targets
+	FederationSamplesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_federation_samples_total",
+			Help: "Total number of samples ingested from Prometheus federation targets",
+		},
+		[]string{"target"},
+	)
+
+	// PluginSyncsCounter counts recommendation syncs with the Grafana plugin by result
+	PluginSyncsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_plugin_syncs_total",
+			Help: "Total number of recommendation syncs with the Grafana plugin by result",
+		},
+		[]string{"result"},
+	)
+
+	// RuleSyncsCounter counts rule syncs with the Grafana plugin by result
+	RuleSyncsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_rule_syncs_total",
+			Help: "Total number of rule syncs with the Grafana plugin by result",
+		},
+		[]string{"result"},
+	)
+
+	// RuleSyncConflictsGauge tracks rules changed on both sides awaiting manual resolution
+	RuleSyncConflictsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "adaptive_metrics_rule_sync_conflicts",
+			Help: "Number of rules changed both locally and in the Grafana plugin awaiting resolution",
+		},
+	)
+
+	// GitOpsSyncsCounter counts rule syncs with a Git repository by result
+	GitOpsSyncsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_gitops_syncs_total",
+			Help: "Total number of rule syncs with a Git repository by result",
+		},
+		[]string{"result"},
+	)
+
+	// ControlPlanePollsCounter counts polls of the central bundle by result
+	ControlPlanePollsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_control_plane_polls_total",
+			Help: "Total number of polls of the control plane bundle by result",
+		},
+		[]string{"result"},
+	)
+
+	// CounterResetsCounter counts detected resets of cumulative input counters
+	CounterResetsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_counter_resets_total",
+			Help: "Total number of detected resets of cumulative input counters",
+		},
+		[]string{"metric_name"},
+	)
+
+	// CounterStateEvictionsCounter counts input series whose counter state was evicted
+	CounterStateEvictionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_counter_state_evictions_total",
+			Help: "Total number of input series whose counter state was evicted",
+		},
+		[]string{"reason"},
+	)
+
+	// CounterSeriesGauge tracks the number of input series with counter state
+	CounterSeriesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "adaptive_metrics_counter_state_series",
+			Help: "Number of input counter series whose last value is kept",
+		},
+	)
+
 	// ProcessingDurationHistogram tracks the duration of metric processing
 	ProcessingDurationHistogram = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -55,6 +243,132 @@ var (
 		[]string{"result"},
 	)
 
+	// HTTPRequestsCounter counts API requests by route, method and status code
+	HTTPRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_http_requests_total",
+			Help: "Total number of HTTP requests by route, method and status code",
+		},
+		[]string{"route", "method", "code"},
+	)
+
+	// HTTPRequestDurationHistogram tracks the latency of API requests
+	HTTPRequestDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "adaptive_metrics_http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"route", "method"},
+	)
+
+	// HTTPRequestSizeHistogram tracks the body size of API requests
+	HTTPRequestSizeHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "adaptive_metrics_http_request_size_bytes",
+			Help:    "Size of HTTP request bodies in bytes",
+			Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+		},
+		[]string{"route", "method"},
+	)
+
+	// UsageSamplingRateGauge tracks the 1-in-N rate at which usage tracking analyzes samples
+	UsageSamplingRateGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "adaptive_metrics_usage_sampling_rate",
+			Help: "Usage tracking analyzes 1 in this many samples",
+		},
+	)
+
+	// ProfilePushesCounter counts profiles pushed to a continuous profiling server
+	ProfilePushesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_profile_pushes_total",
+			Help: "Total number of profiles pushed to a continuous profiling server",
+		},
+		[]string{"profile", "result"},
+	)
+
+	// ProcessorWorkersGauge tracks the number of processor workers
+	ProcessorWorkersGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "adaptive_metrics_processor_workers",
+			Help: "Number of workers processing the input queue",
+		},
+	)
+
+	// QueueWaitHistogram tracks how long samples wait in the input queue
+	QueueWaitHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "adaptive_metrics_queue_wait_seconds",
+			Help:    "Time samples wait in the input queue before being processed",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		},
+	)
+
+	// DroppedSamplesCounter counts samples and series dropped anywhere in the pipeline
+	DroppedSamplesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_dropped_total",
+			Help: "Total number of samples and aggregated series dropped by pipeline stage and reason",
+		},
+		[]string{"stage", "reason"},
+	)
+
+	// QueueLengthGauge tracks the number of items waiting in the internal queues
+	QueueLengthGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "adaptive_metrics_queue_length",
+			Help: "Number of items waiting in an internal queue",
+		},
+		[]string{"queue"},
+	)
+
+	// QueueSaturationGauge tracks how full the internal queues are
+	QueueSaturationGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "adaptive_metrics_queue_saturation_ratio",
+			Help: "Fraction of the capacity of an internal queue in use, from 0 to 1",
+		},
+		[]string{"queue"},
+	)
+
+	// QueueFullCounter counts writes that found an internal queue full
+	QueueFullCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_queue_full_total",
+			Help: "Total number of writes that found an internal queue full",
+		},
+		[]string{"queue"},
+	)
+
+	// RemoteWriteRequestsCounter counts remote write requests by endpoint and result
+	RemoteWriteRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_remote_write_requests_total",
+			Help: "Total number of remote write requests, including retries, by endpoint and result",
+		},
+		[]string{"endpoint", "result"},
+	)
+
+	// RemoteWriteSamplesCounter counts aggregated series delivered to remote write endpoints
+	RemoteWriteSamplesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "adaptive_metrics_remote_write_samples_total",
+			Help: "Total number of aggregated series delivered to remote write endpoints",
+		},
+		[]string{"endpoint"},
+	)
+
+	// RemoteWriteCircuitStateGauge tracks the circuit breaker state of remote write endpoints
+	RemoteWriteCircuitStateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "adaptive_metrics_remote_write_circuit_state",
+			Help: "Circuit breaker state of a remote write endpoint: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{"endpoint"},
+	)
+
 	// ActiveRulesGauge tracks the number of active rules
 	ActiveRulesGauge = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -70,6 +384,15 @@ var (
 			Help: "Number of active aggregation buckets",
 		},
 	)
+
+	// BuildInfoGauge is always 1, labeled with the build information of the running binary
+	BuildInfoGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "adaptive_metrics_build_info",
+			Help: "A metric with a constant '1' value labeled by version, revision, branch, build date and Go version",
+		},
+		[]string{"version", "revision", "branch", "build_date", "goversion"},
+	)
 )
 
 func init() {
@@ -77,18 +400,89 @@ func init() {
 	prometheus.MustRegister(InputMetricsCounter)
 	prometheus.MustRegister(AggregatedMetricsCounter)
 	prometheus.MustRegister(DiscardedSamplesCounter)
+	prometheus.MustRegister(RateLimitedSamplesCounter)
+	prometheus.MustRegister(RateLimitedRequestsCounter)
+	prometheus.MustRegister(IngestFilteredSamplesCounter)
+	prometheus.MustRegister(OutOfBoundsSamplesCounter)
+	prometheus.MustRegister(HADeduplicatedSamplesCounter)
+	prometheus.MustRegister(HAReplicaElectionsCounter)
+	prometheus.MustRegister(SubscriptionDropsCounter)
+	prometheus.MustRegister(RuleExpirationsCounter)
+	prometheus.MustRegister(RuleOutputStallsCounter)
+	prometheus.MustRegister(ShadowOutputSeriesGauge)
+	prometheus.MustRegister(ShadowSamplesCounter)
+	prometheus.MustRegister(FederationScrapesCounter)
+	prometheus.MustRegister(FederationSamplesCounter)
+	prometheus.MustRegister(PluginSyncsCounter)
+	prometheus.MustRegister(RuleSyncsCounter)
+	prometheus.MustRegister(RuleSyncConflictsGauge)
+	prometheus.MustRegister(GitOpsSyncsCounter)
+	prometheus.MustRegister(ControlPlanePollsCounter)
+	prometheus.MustRegister(CounterResetsCounter)
+	prometheus.MustRegister(CounterStateEvictionsCounter)
+	prometheus.MustRegister(CounterSeriesGauge)
 	prometheus.MustRegister(ProcessingDurationHistogram)
 	prometheus.MustRegister(RuleMatchingHistogram)
+	prometheus.MustRegister(HTTPRequestsCounter)
+	prometheus.MustRegister(HTTPRequestDurationHistogram)
+	prometheus.MustRegister(HTTPRequestSizeHistogram)
+	prometheus.MustRegister(UsageSamplingRateGauge)
+	prometheus.MustRegister(ProfilePushesCounter)
+	prometheus.MustRegister(ProcessorWorkersGauge)
+	prometheus.MustRegister(QueueWaitHistogram)
+	prometheus.MustRegister(DroppedSamplesCounter)
+	prometheus.MustRegister(QueueLengthGauge)
+	prometheus.MustRegister(QueueSaturationGauge)
+	prometheus.MustRegister(QueueFullCounter)
+	prometheus.MustRegister(RemoteWriteRequestsCounter)
+	prometheus.MustRegister(RemoteWriteSamplesCounter)
+	prometheus.MustRegister(RemoteWriteCircuitStateGauge)
 	prometheus.MustRegister(ActiveRulesGauge)
 	prometheus.MustRegister(AggregationBucketsGauge)
+	prometheus.MustRegister(BuildInfoGauge)
+
+	info := version.Get()
+	BuildInfoGauge.WithLabelValues(info.Version, info.Revision, info.Branch, info.BuildDate, info.GoVersion).Set(1)
 }
 
+// Reasons counter state is evicted
+const (
+	EvictionReasonStale = "stale"
+	EvictionReasonLimit = "limit"
+)
+
+// Pipeline stages in which samples are dropped
+const (
+	StageIngest      = "ingest"
+	StageAggregation = "aggregation"
+	StageRemoteWrite = "remote_write"
+)
+
+// Internal queues
+const (
+	QueueInput       = "input"
+	QueueRemoteWrite = "remote_write"
+)
+
 // TrackDuration is a helper to measure and record the duration of operations
 func TrackDuration(operation string) func() {
 	start := time.Now()
 	return func() {
-		duration := time.Since(start).Seconds()
-		ProcessingDurationHistogram.WithLabelValues(operation).Observe(duration)
+		RecordProcessingDuration(operation, time.Since(start))
+	}
+}
+
+// RecordProcessingDuration records the duration of a processing operation
+func RecordProcessingDuration(operation string, duration time.Duration) {
+	ProcessingDurationHistogram.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// RecordHTTPRequest records a served HTTP request
+func RecordHTTPRequest(route, method string, code int, duration time.Duration, size int64) {
+	HTTPRequestsCounter.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	HTTPRequestDurationHistogram.WithLabelValues(route, method).Observe(duration.Seconds())
+	if size >= 0 {
+		HTTPRequestSizeHistogram.WithLabelValues(route, method).Observe(float64(size))
 	}
 }
 
@@ -107,6 +501,118 @@ func RecordDiscardedSample(metricName, reason string) {
 	DiscardedSamplesCounter.WithLabelValues(metricName, reason).Inc()
 }
 
+// RecordRateLimitedSamples records that samples of a tenant were rejected by ingestion limits
+func RecordRateLimitedSamples(tenant string, count int) {
+	RateLimitedSamplesCounter.WithLabelValues(tenant).Add(float64(count))
+}
+
+// RecordRateLimitedRequest records that a request of a class (ingest or api) was rejected by its client's limit
+func RecordRateLimitedRequest(class string) {
+	RateLimitedRequestsCounter.WithLabelValues(class).Inc()
+}
+
+// RecordIngestFilteredSamples records that samples were dropped or passed through by ingest filters
+func RecordIngestFilteredSamples(action string, count int) {
+	IngestFilteredSamplesCounter.WithLabelValues(action).Add(float64(count))
+}
+
+// RecordOutOfBoundsSamples records that samples of a tenant had a timestamp out
+// of bounds (too_old or too_new) and were rejected or clamped
+func RecordOutOfBoundsSamples(tenant, reason, action string, count int) {
+	OutOfBoundsSamplesCounter.WithLabelValues(tenant, reason, action).Add(float64(count))
+}
+
+// RecordHADeduplicatedSamples records that samples of a non-elected HA replica were dropped
+func RecordHADeduplicatedSamples(cluster string, count int) {
+	HADeduplicatedSamplesCounter.WithLabelValues(cluster).Add(float64(count))
+}
+
+// RecordHAReplicaElected records that another replica of an HA cluster was elected
+func RecordHAReplicaElected(cluster string) {
+	HAReplicaElectionsCounter.WithLabelValues(cluster).Inc()
+}
+
+// RecordSubscriptionDrop records that an aggregated series was dropped for a slow subscriber
+func RecordSubscriptionDrop(subscriber string) {
+	SubscriptionDropsCounter.WithLabelValues(subscriber).Inc()
+}
+
+// RecordRuleExpired records that a temporary rule expired
+func RecordRuleExpired() {
+	RuleExpirationsCounter.Inc()
+}
+
+// RecordRuleOutputStalled records that a rule received input but produced no output
+func RecordRuleOutputStalled(ruleID string) {
+	RuleOutputStallsCounter.WithLabelValues(ruleID).Inc()
+}
+
+// RecordShadowAggregation records the output series and input samples of one
+// flush of a shadow rule
+func RecordShadowAggregation(ruleID string, series, samples int) {
+	ShadowOutputSeriesGauge.WithLabelValues(ruleID).Set(float64(series))
+	ShadowSamplesCounter.WithLabelValues(ruleID).Add(float64(samples))
+}
+
+// RecordFederationScrape records a scrape of a federation target and the samples it ingested
+func RecordFederationScrape(target string, success bool, samples int) {
+	result := "error"
+	if success {
+		result = "success"
+	}
+	FederationScrapesCounter.WithLabelValues(target, result).Inc()
+	FederationSamplesCounter.WithLabelValues(target).Add(float64(samples))
+}
+
+// RecordPluginSync records a recommendation sync with the Grafana plugin
+func RecordPluginSync(success bool) {
+	result := "error"
+	if success {
+		result = "success"
+	}
+	PluginSyncsCounter.WithLabelValues(result).Inc()
+}
+
+// RecordRuleSync records a rule sync with the Grafana plugin and the conflicts left
+func RecordRuleSync(success bool, conflicts int) {
+	result := "error"
+	if success {
+		result = "success"
+	}
+	RuleSyncsCounter.WithLabelValues(result).Inc()
+	RuleSyncConflictsGauge.Set(float64(conflicts))
+}
+
+// RecordGitOpsSync records a rule sync with a Git repository
+func RecordGitOpsSync(success bool) {
+	result := "error"
+	if success {
+		result = "success"
+	}
+	GitOpsSyncsCounter.WithLabelValues(result).Inc()
+}
+
+// RecordControlPlanePoll records a poll of the control plane bundle; result
+// is "changed", "unchanged" or "error"
+func RecordControlPlanePoll(result string) {
+	ControlPlanePollsCounter.WithLabelValues(result).Inc()
+}
+
+// RecordCounterReset records that a cumulative input counter was reset
+func RecordCounterReset(metricName string) {
+	CounterResetsCounter.WithLabelValues(metricName).Inc()
+}
+
+// RecordCounterStateEvictions records that the counter state of input series was evicted
+func RecordCounterStateEvictions(reason string, count int) {
+	CounterStateEvictionsCounter.WithLabelValues(reason).Add(float64(count))
+}
+
+// UpdateCounterSeriesCount updates the count of input series with counter state
+func UpdateCounterSeriesCount(count int) {
+	CounterSeriesGauge.Set(float64(count))
+}
+
 // RecordRuleMatching records the duration of a rule matching operation
 func RecordRuleMatching(duration time.Duration, matched bool) {
 	result := "no_match"
@@ -124,4 +630,72 @@ func UpdateActiveRulesCount(count int) {
 // UpdateAggregationBucketsCount updates the count of active aggregation buckets
 func UpdateAggregationBucketsCount(count int) {
 	AggregationBucketsGauge.Set(float64(count))
-}
\ No newline at end of file
+}
+
+// UpdateUsageSamplingRate updates the rate at which usage tracking samples
+func UpdateUsageSamplingRate(rate int) {
+	UsageSamplingRateGauge.Set(float64(rate))
+}
+
+// RecordProfilePush records the result of pushing a profile
+func RecordProfilePush(profile string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	ProfilePushesCounter.WithLabelValues(profile, result).Inc()
+}
+
+// UpdateProcessorWorkers updates the number of processor workers
+func UpdateProcessorWorkers(count int) {
+	ProcessorWorkersGauge.Set(float64(count))
+}
+
+// RecordQueueWait records how long a sample waited in the input queue
+func RecordQueueWait(wait time.Duration) {
+	QueueWaitHistogram.Observe(wait.Seconds())
+}
+
+// RecordDroppedSamples records that samples or series were dropped in a pipeline stage
+func RecordDroppedSamples(stage, reason string, count int) {
+	DroppedSamplesCounter.WithLabelValues(stage, reason).Add(float64(count))
+}
+
+// UpdateQueue updates the length and saturation of an internal queue
+func UpdateQueue(queue string, length, capacity int) {
+	QueueLengthGauge.WithLabelValues(queue).Set(float64(length))
+	if capacity > 0 {
+		QueueSaturationGauge.WithLabelValues(queue).Set(float64(length) / float64(capacity))
+	}
+}
+
+// RecordQueueFull records that a write found an internal queue full
+func RecordQueueFull(queue string) {
+	QueueFullCounter.WithLabelValues(queue).Inc()
+}
+
+// RecordRemoteWriteRequest records a remote write request to an endpoint
+func RecordRemoteWriteRequest(endpoint string, success bool) {
+	result := "error"
+	if success {
+		result = "success"
+	}
+	RemoteWriteRequestsCounter.WithLabelValues(endpoint, result).Inc()
+}
+
+// RecordRemoteWriteSamples records aggregated series delivered to an endpoint
+func RecordRemoteWriteSamples(endpoint string, count int) {
+	RemoteWriteSamplesCounter.WithLabelValues(endpoint).Add(float64(count))
+}
+
+// UpdateRemoteWriteCircuitState updates the circuit breaker state of a remote write endpoint
+func UpdateRemoteWriteCircuitState(endpoint, state string) {
+	value := 0.0
+	switch state {
+	case "half_open":
+		value = 1
+	case "open":
+		value = 2
+	}
+	RemoteWriteCircuitStateGauge.WithLabelValues(endpoint).Set(value)
+}
@@ -0,0 +1,214 @@
+// Package parquet writes flat tables as Apache Parquet files: required
+// columns, plain encoding and gzip compressed pages, in a single row group.
+// It covers exports meant for offline analysis, not the full format.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Physical types, converted types and other enums of the Parquet format
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	repetitionRequired = 0
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecGzip          = 2
+	pageTypeData       = 0
+)
+
+// magic starts and ends every Parquet file
+const magic = "PAR1"
+
+// createdBy identifies the writer in the file metadata
+const createdBy = "adaptive-metrics"
+
+// Column is a required column of a table
+type Column struct {
+	name      string
+	typ       int32
+	converted int32 // -1 when the column has no converted type
+	rows      int
+	encode    func(*bytes.Buffer)
+}
+
+// Int64Column returns a column of 64-bit integers
+func Int64Column(name string, values []int64) Column {
+	return Column{name: name, typ: typeInt64, converted: -1, rows: len(values), encode: func(buf *bytes.Buffer) {
+		for _, v := range values {
+			binary.Write(buf, binary.LittleEndian, v)
+		}
+	}}
+}
+
+// DoubleColumn returns a column of 64-bit floating point numbers
+func DoubleColumn(name string, values []float64) Column {
+	return Column{name: name, typ: typeDouble, converted: -1, rows: len(values), encode: func(buf *bytes.Buffer) {
+		for _, v := range values {
+			binary.Write(buf, binary.LittleEndian, math.Float64bits(v))
+		}
+	}}
+}
+
+// StringColumn returns a column of UTF-8 strings
+func StringColumn(name string, values []string) Column {
+	return Column{name: name, typ: typeByteArray, converted: convertedUTF8, rows: len(values), encode: func(buf *bytes.Buffer) {
+		for _, v := range values {
+			binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		}
+	}}
+}
+
+// TimestampColumn returns a column of timestamps with millisecond precision
+func TimestampColumn(name string, values []time.Time) Column {
+	millis := make([]int64, len(values))
+	for i, v := range values {
+		millis[i] = v.UnixMilli()
+	}
+	column := Int64Column(name, millis)
+	column.converted = convertedTimestampMillis
+	return column
+}
+
+// columnChunk is the position and size of a written column
+type columnChunk struct {
+	column       Column
+	offset       int64
+	uncompressed int64
+	compressed   int64
+}
+
+// Write writes a table of columns of equal length as a Parquet file
+func Write(w io.Writer, columns ...Column) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("parquet: no columns")
+	}
+	rows := columns[0].rows
+	for _, column := range columns {
+		if column.rows != rows {
+			return fmt.Errorf("parquet: column %s has %d rows, want %d", column.name, column.rows, rows)
+		}
+	}
+
+	var file bytes.Buffer
+	file.WriteString(magic)
+
+	chunks := make([]columnChunk, 0, len(columns))
+	for _, column := range columns {
+		var page bytes.Buffer
+		column.encode(&page)
+
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(page.Bytes())
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("parquet: failed to compress column %s: %w", column.name, err)
+		}
+
+		header := pageHeader(page.Len(), compressed.Len(), rows)
+		chunks = append(chunks, columnChunk{
+			column:       column,
+			offset:       int64(file.Len()),
+			uncompressed: int64(len(header) + page.Len()),
+			compressed:   int64(len(header) + compressed.Len()),
+		})
+		file.Write(header)
+		file.Write(compressed.Bytes())
+	}
+
+	footer := fileMetadata(chunks, rows)
+	file.Write(footer)
+	binary.Write(&file, binary.LittleEndian, uint32(len(footer)))
+	file.WriteString(magic)
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// pageHeader encodes the header of a data page holding rows values without
+// repetition or definition levels, as all columns are required
+func pageHeader(uncompressed, compressed, rows int) []byte {
+	var c compactWriter
+	c.i32Field(1, pageTypeData)
+	c.i32Field(2, int32(uncompressed))
+	c.i32Field(3, int32(compressed))
+	c.structField(5)
+	c.i32Field(1, int32(rows))
+	c.i32Field(2, encodingPlain)
+	c.i32Field(3, encodingRLE)
+	c.i32Field(4, encodingRLE)
+	c.structEnd()
+	c.buf.WriteByte(0)
+	return c.buf.Bytes()
+}
+
+// fileMetadata encodes the footer describing the schema and the single row
+// group of the file
+func fileMetadata(chunks []columnChunk, rows int) []byte {
+	var c compactWriter
+	c.i32Field(1, 1)
+
+	// The schema is a root element followed by the columns
+	c.listField(2, thriftStruct, len(chunks)+1)
+	c.structBegin()
+	c.stringField(4, "schema")
+	c.i32Field(5, int32(len(chunks)))
+	c.structEnd()
+	for _, chunk := range chunks {
+		c.structBegin()
+		c.i32Field(1, chunk.column.typ)
+		c.i32Field(3, repetitionRequired)
+		c.stringField(4, chunk.column.name)
+		if chunk.column.converted >= 0 {
+			c.i32Field(6, chunk.column.converted)
+		}
+		c.structEnd()
+	}
+	c.i64Field(3, int64(rows))
+
+	var total int64
+	for _, chunk := range chunks {
+		total += chunk.uncompressed
+	}
+	c.listField(4, thriftStruct, 1)
+	c.structBegin()
+	c.listField(1, thriftStruct, len(chunks))
+	for _, chunk := range chunks {
+		c.structBegin()
+		c.i64Field(2, chunk.offset)
+		c.structField(3)
+		c.i32Field(1, chunk.column.typ)
+		c.listField(2, thriftI32, 2)
+		c.i32(encodingPlain)
+		c.i32(encodingRLE)
+		c.listField(3, thriftBinary, 1)
+		c.binary(chunk.column.name)
+		c.i32Field(4, codecGzip)
+		c.i64Field(5, int64(rows))
+		c.i64Field(6, chunk.uncompressed)
+		c.i64Field(7, chunk.compressed)
+		c.i64Field(9, chunk.offset)
+		c.structEnd()
+		c.structEnd()
+	}
+	c.i64Field(2, total)
+	c.i64Field(3, int64(rows))
+	c.structEnd()
+
+	c.stringField(6, createdBy)
+	c.buf.WriteByte(0)
+	return c.buf.Bytes()
+}
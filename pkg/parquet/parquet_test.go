@@ -0,0 +1,152 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// compactReader decodes Thrift compact structs into maps of field ids to
+// values, enough to check the files written here
+type compactReader struct {
+	r *bytes.Reader
+	t *testing.T
+}
+
+func (c *compactReader) varint() uint64 {
+	v, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		c.t.Fatalf("invalid varint: %v", err)
+	}
+	return v
+}
+
+func (c *compactReader) zigzag() int64 {
+	v := c.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (c *compactReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return c.zigzag()
+	case thriftBinary:
+		b := make([]byte, c.varint())
+		io.ReadFull(c.r, b)
+		return string(b)
+	case thriftList:
+		header, _ := c.r.ReadByte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(c.varint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = c.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		fields := make(map[int64]interface{})
+		var id int64
+		for {
+			header, err := c.r.ReadByte()
+			if err != nil {
+				c.t.Fatalf("truncated struct: %v", err)
+			}
+			if header == 0 {
+				return fields
+			}
+			if delta := header >> 4; delta != 0 {
+				id += int64(delta)
+			} else {
+				id = c.zigzag()
+			}
+			fields[id] = c.value(header & 0x0f)
+		}
+	}
+	c.t.Fatalf("unexpected type %d", typ)
+	return nil
+}
+
+func TestWrite(t *testing.T) {
+	first := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	err := Write(&buf,
+		StringColumn("metric_name", []string{"up", "http_requests_total"}),
+		Int64Column("cardinality", []int64{3, 1200}),
+		DoubleColumn("sum_value", []float64{1.5, -2}),
+		TimestampColumn("first_seen", []time.Time{first, first.Add(time.Second)}),
+	)
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	data := buf.Bytes()
+	if string(data[:4]) != magic || string(data[len(data)-4:]) != magic {
+		t.Fatalf("file is not framed by %s", magic)
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLength : len(data)-8]
+	reader := &compactReader{r: bytes.NewReader(footer), t: t}
+	metadata := reader.value(thriftStruct).(map[int64]interface{})
+	if reader.r.Len() != 0 {
+		t.Errorf("%d bytes left after the file metadata", reader.r.Len())
+	}
+
+	if metadata[3] != int64(2) {
+		t.Errorf("num_rows = %v, want 2", metadata[3])
+	}
+	schema := metadata[2].([]interface{})
+	if len(schema) != 5 || schema[1].(map[int64]interface{})[4] != "metric_name" {
+		t.Fatalf("schema = %v", schema)
+	}
+	if converted := schema[4].(map[int64]interface{})[6]; converted != int64(convertedTimestampMillis) {
+		t.Errorf("first_seen converted type = %v, want TIMESTAMP_MILLIS", converted)
+	}
+
+	// Read the pages of the columns back
+	rowGroup := metadata[4].([]interface{})[0].(map[int64]interface{})
+	chunks := rowGroup[1].([]interface{})
+	pages := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		meta := chunk.(map[int64]interface{})[3].(map[int64]interface{})
+		offset, size := meta[9].(int64), meta[7].(int64)
+		page := bytes.NewReader(data[offset : offset+size])
+		header := (&compactReader{r: page, t: t}).value(thriftStruct).(map[int64]interface{})
+		if values := header[5].(map[int64]interface{})[1]; values != int64(2) {
+			t.Errorf("column %d page has %v values, want 2", i, values)
+		}
+		gz, err := gzip.NewReader(page)
+		if err != nil {
+			t.Fatalf("column %d page is not gzip compressed: %v", i, err)
+		}
+		pages[i], _ = io.ReadAll(gz)
+		if int64(len(pages[i])) != header[2] {
+			t.Errorf("column %d page is %d bytes, header says %v", i, len(pages[i]), header[2])
+		}
+	}
+
+	if want := "\x02\x00\x00\x00up\x13\x00\x00\x00http_requests_total"; string(pages[0]) != want {
+		t.Errorf("metric_name page = %q, want %q", pages[0], want)
+	}
+	if got := int64(binary.LittleEndian.Uint64(pages[1][8:])); got != 1200 {
+		t.Errorf("second cardinality = %d, want 1200", got)
+	}
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(pages[2])); got != 1.5 {
+		t.Errorf("first sum_value = %v, want 1.5", got)
+	}
+	if got := int64(binary.LittleEndian.Uint64(pages[3])); got != first.UnixMilli() {
+		t.Errorf("first first_seen = %d, want %d", got, first.UnixMilli())
+	}
+}
+
+func TestWrite_MismatchedColumns(t *testing.T) {
+	err := Write(io.Discard, Int64Column("a", []int64{1, 2}), Int64Column("b", []int64{1}))
+	if err == nil {
+		t.Error("Write() with columns of different lengths succeeded")
+	}
+}
@@ -0,0 +1,104 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Types of the Thrift compact protocol used by the Parquet metadata
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// compactWriter encodes Thrift structs with the compact protocol. Field ids
+// are written as deltas from the previous field of the same struct, so the
+// previous ids of enclosing structs are kept on a stack.
+type compactWriter struct {
+	buf    bytes.Buffer
+	lastID int16
+	stack  []int16
+}
+
+// varint writes an unsigned LEB128 integer
+func (c *compactWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	c.buf.Write(b[:n])
+}
+
+// field writes the header of a field
+func (c *compactWriter) field(id int16, typ byte) {
+	if delta := id - c.lastID; delta > 0 && delta <= 15 {
+		c.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		c.buf.WriteByte(typ)
+		c.varint(uint64(uint16((id << 1) ^ (id >> 15))))
+	}
+	c.lastID = id
+}
+
+// i32 writes a zigzag encoded 32-bit integer
+func (c *compactWriter) i32(v int32) {
+	c.varint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+// i64 writes a zigzag encoded 64-bit integer
+func (c *compactWriter) i64(v int64) {
+	c.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+// binary writes a length prefixed string
+func (c *compactWriter) binary(s string) {
+	c.varint(uint64(len(s)))
+	c.buf.WriteString(s)
+}
+
+func (c *compactWriter) i32Field(id int16, v int32) {
+	c.field(id, thriftI32)
+	c.i32(v)
+}
+
+func (c *compactWriter) i64Field(id int16, v int64) {
+	c.field(id, thriftI64)
+	c.i64(v)
+}
+
+func (c *compactWriter) stringField(id int16, s string) {
+	c.field(id, thriftBinary)
+	c.binary(s)
+}
+
+// listField writes the header of a list field of size elements
+func (c *compactWriter) listField(id int16, elemType byte, size int) {
+	c.field(id, thriftList)
+	if size < 15 {
+		c.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	c.buf.WriteByte(0xf0 | elemType)
+	c.varint(uint64(size))
+}
+
+// structField writes the header of a struct field; its fields follow until
+// structEnd
+func (c *compactWriter) structField(id int16) {
+	c.field(id, thriftStruct)
+	c.structBegin()
+}
+
+// structBegin starts a struct written as a list element or top-level value
+func (c *compactWriter) structBegin() {
+	c.stack = append(c.stack, c.lastID)
+	c.lastID = 0
+}
+
+// structEnd writes the stop field of the current struct
+func (c *compactWriter) structEnd() {
+	c.buf.WriteByte(0)
+	c.lastID = c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+}
@@ -0,0 +1,141 @@
+package remote
+
+import (
+	"sync"
+	"time"
+
+	pkgmetrics "github.com/marcotuna/adaptive-metrics/pkg/metrics"
+)
+
+// Circuit breaker states of an endpoint
+const (
+	// CircuitClosed endpoints receive all requests
+	CircuitClosed = "closed"
+	// CircuitOpen endpoints failed repeatedly and receive no requests until
+	// the open period ends
+	CircuitOpen = "open"
+	// CircuitHalfOpen endpoints receive a single probe request, which closes
+	// the circuit on success and opens it again on failure
+	CircuitHalfOpen = "half_open"
+)
+
+// EndpointStatus is the health of a remote write endpoint
+type EndpointStatus struct {
+	Endpoint            string     `json:"endpoint"`
+	State               string     `json:"state"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	// OpenedAt and RetryAt are set while the circuit is open
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"`
+}
+
+// breaker is the circuit breaker of an endpoint. It opens after threshold
+// consecutive failed requests, so batches skip the endpoint instead of
+// waiting on its retries, and lets a probe through once openFor has passed.
+// A threshold of 0 never opens the circuit.
+type breaker struct {
+	endpoint  string
+	threshold int
+	openFor   time.Duration
+
+	mu          sync.Mutex
+	state       string
+	failures    int
+	probing     bool
+	openedAt    time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+func newBreaker(endpoint string, threshold int, openFor time.Duration) *breaker {
+	b := &breaker{endpoint: endpoint, threshold: threshold, openFor: openFor, state: CircuitClosed}
+	pkgmetrics.UpdateRemoteWriteCircuitState(endpoint, CircuitClosed)
+	return b
+}
+
+// allow reports whether a request may be sent to the endpoint
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.openedAt) < b.openFor {
+			return false
+		}
+		b.setState(CircuitHalfOpen)
+		b.probing = true
+		return true
+	case CircuitHalfOpen:
+		// Only one probe at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the circuit with the result of a request
+func (b *breaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.lastSuccess = now
+		b.setState(CircuitClosed)
+		return
+	}
+
+	b.failures++
+	b.lastFailure = now
+	b.lastError = err.Error()
+	if b.threshold > 0 && (b.state == CircuitHalfOpen || b.failures >= b.threshold) {
+		b.openedAt = now
+		b.setState(CircuitOpen)
+	}
+}
+
+// setState changes the state of the circuit; callers hold the lock
+func (b *breaker) setState(state string) {
+	if b.state != state {
+		b.state = state
+		pkgmetrics.UpdateRemoteWriteCircuitState(b.endpoint, state)
+	}
+}
+
+// status returns the health of the endpoint
+func (b *breaker) status() EndpointStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := EndpointStatus{
+		Endpoint:            b.endpoint,
+		State:               b.state,
+		Healthy:             b.state == CircuitClosed && b.failures == 0,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+	if !b.lastSuccess.IsZero() {
+		lastSuccess := b.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	if !b.lastFailure.IsZero() {
+		lastFailure := b.lastFailure
+		status.LastFailure = &lastFailure
+	}
+	if b.state == CircuitOpen {
+		openedAt, retryAt := b.openedAt, b.openedAt.Add(b.openFor)
+		status.OpenedAt, status.RetryAt = &openedAt, &retryAt
+	}
+	return status
+}
@@ -0,0 +1,99 @@
+package remote
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker("http://mimir", 2, time.Minute)
+	failure := errors.New("connection refused")
+
+	b.record(failure, now)
+	if !b.allow(now) || b.status().State != CircuitClosed {
+		t.Fatal("circuit should stay closed below the failure threshold")
+	}
+	b.record(failure, now)
+	if b.allow(now.Add(30*time.Second)) || b.status().State != CircuitOpen {
+		t.Fatal("circuit should open at the failure threshold")
+	}
+	if status := b.status(); status.RetryAt == nil || !status.RetryAt.Equal(now.Add(time.Minute)) || status.Healthy {
+		t.Errorf("status() = %+v, want an unhealthy endpoint retried after a minute", status)
+	}
+
+	// Once the open period ends a single probe is let through
+	later := now.Add(time.Minute)
+	if !b.allow(later) || b.allow(later) {
+		t.Fatal("half-open circuit should allow exactly one probe")
+	}
+	b.record(failure, later)
+	if b.allow(later) || b.status().State != CircuitOpen {
+		t.Fatal("failed probe should open the circuit again")
+	}
+
+	b.allow(later.Add(time.Minute))
+	b.record(nil, later.Add(time.Minute))
+	if status := b.status(); status.State != CircuitClosed || !status.Healthy || status.ConsecutiveFailures != 0 {
+		t.Errorf("status() after a successful probe = %+v, want a healthy closed circuit", status)
+	}
+
+	// A threshold of 0 never opens the circuit
+	disabled := newBreaker("http://mimir", 0, time.Minute)
+	for i := 0; i < 10; i++ {
+		disabled.record(failure, now)
+	}
+	if !disabled.allow(now) {
+		t.Error("disabled circuit breaker should allow all requests")
+	}
+}
+
+func TestClient_SendBatch_SkipsOpenCircuit(t *testing.T) {
+	var healthy, failing atomic.Int32
+	healthyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthy.Add(1)
+	}))
+	defer healthyServer.Close()
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failing.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failingServer.Close()
+
+	client, err := NewClient(&config.RemoteWriteConfig{
+		Enabled:    true,
+		Endpoints:  []string{healthyServer.URL, failingServer.URL},
+		MaxRetries: 2,
+		Timeout:    5,
+		CircuitBreaker: config.RemoteWriteCircuitBreakerConfig{
+			Enabled:          true,
+			FailureThreshold: 2,
+			OpenSeconds:      60,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric := &models.AggregatedMetric{Name: "requests_aggregated", Value: 1}
+	for i := 0; i < 3; i++ {
+		client.sendBatch([]*models.AggregatedMetric{metric})
+	}
+
+	// The failing endpoint is retried until its circuit opens, then skipped
+	if healthy.Load() != 3 || failing.Load() != 2 {
+		t.Errorf("requests = %d to the healthy and %d to the failing endpoint, want 3 and 2", healthy.Load(), failing.Load())
+	}
+
+	status := client.Status()
+	if len(status) != 2 || !status[0].Healthy || status[1].State != CircuitOpen {
+		t.Errorf("Status() = %+v, want a healthy endpoint and an open circuit", status)
+	}
+}
@@ -3,6 +3,7 @@ package remote
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,12 +11,28 @@ import (
 	"time"
 
 	"github.com/gogo/protobuf/proto"
-	"github.com/golang/snappy"
 	"github.com/marcotuna/adaptive-metrics/internal/config"
 	"github.com/marcotuna/adaptive-metrics/internal/models"
+	"github.com/marcotuna/adaptive-metrics/internal/tracing"
+	"github.com/marcotuna/adaptive-metrics/pkg/logger"
+	pkgmetrics "github.com/marcotuna/adaptive-metrics/pkg/metrics"
 	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// errUnsupportedEncoding is returned when an endpoint rejects the compression of a request
+var errUnsupportedEncoding = errors.New("endpoint does not support the compression")
+
+// droppedQueueLog reports metrics dropped because the write queue is full
+var droppedQueueLog = logger.NewSampler(logger.Warn, "Remote write queue is full, dropped metrics")
+
+// circuitOpenLog reports batches skipped by an endpoint whose circuit is open
+var circuitOpenLog = logger.NewSampler(logger.Warn, "Remote write endpoint circuit is open, dropped batch")
+
 // Client is a Prometheus remote write client
 type Client struct {
 	cfg           *config.RemoteWriteConfig
@@ -29,6 +46,13 @@ type Client struct {
 	// Track which metrics came from recommendations
 	recommendationMetrics map[string]bool
 	recommendationMu      sync.RWMutex
+	// compression is the codec of each endpoint; endpoints rejecting zstd
+	// fall back to snappy
+	compression   map[string]string
+	compressionMu sync.RWMutex
+	// breakers holds the circuit breaker of each endpoint, in breakerOrder
+	breakers     map[string]*breaker
+	breakerOrder []string
 }
 
 // BasicAuth contains basic authentication credentials
@@ -59,6 +83,11 @@ func NewClient(cfg *config.RemoteWriteConfig) (*Client, error) {
 		}
 	}
 
+	compression, err := endpointCompression(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	client := &Client{
 		cfg:                  cfg,
 		endpoints:            cfg.Endpoints,
@@ -67,14 +96,53 @@ func NewClient(cfg *config.RemoteWriteConfig) (*Client, error) {
 		queue:                make(chan *models.AggregatedMetric, cfg.BatchSize),
 		done:                 make(chan struct{}),
 		recommendationMetrics: make(map[string]bool),
+		compression:          compression,
 		httpClient: &http.Client{
 			Timeout: time.Duration(cfg.Timeout) * time.Second,
 		},
 	}
+	client.initBreakers()
 
 	return client, nil
 }
 
+// initBreakers creates the circuit breakers of the default and routing-only endpoints
+func (c *Client) initBreakers() {
+	threshold := 0
+	if c.cfg.CircuitBreaker.Enabled {
+		threshold = c.cfg.CircuitBreaker.FailureThreshold
+	}
+	openFor := time.Duration(c.cfg.CircuitBreaker.OpenSeconds) * time.Second
+
+	c.breakers = make(map[string]*breaker)
+	add := func(endpoint string) {
+		if _, exists := c.breakers[endpoint]; !exists {
+			c.breakers[endpoint] = newBreaker(endpoint, threshold, openFor)
+			c.breakerOrder = append(c.breakerOrder, endpoint)
+		}
+	}
+	for _, endpoint := range c.endpoints {
+		add(endpoint)
+	}
+	for _, options := range c.cfg.EndpointOptions {
+		add(options.URL)
+	}
+}
+
+// Status returns the health of each endpoint
+func (c *Client) Status() []EndpointStatus {
+	status := make([]EndpointStatus, 0, len(c.breakerOrder))
+	for _, endpoint := range c.breakerOrder {
+		status = append(status, c.breakers[endpoint].status())
+	}
+	return status
+}
+
+// QueueLength returns the number of series waiting in the write queue
+func (c *Client) QueueLength() int {
+	return len(c.queue)
+}
+
 // Start starts the remote write client
 func (c *Client) Start() {
 	c.wg.Add(1)
@@ -105,7 +173,12 @@ func (c *Client) Write(metric *models.AggregatedMetric) {
 		// Successfully queued
 	default:
 		// Queue is full, log and drop
-		fmt.Printf("Warning: Remote write queue is full, dropping metric: %s\n", metric.Name)
+		pkgmetrics.RecordQueueFull(pkgmetrics.QueueRemoteWrite)
+		pkgmetrics.RecordDroppedSamples(pkgmetrics.StageRemoteWrite, "queue_full", 1)
+		droppedQueueLog.Log(logger.Fields{
+			"metric_name": metric.Name,
+			"rule_id":     metric.SourceRule,
+		})
 	}
 }
 
@@ -116,6 +189,32 @@ func (c *Client) RegisterRecommendationRule(ruleID string) {
 	c.recommendationMetrics[ruleID] = true
 }
 
+// UnregisterRecommendationRule forgets that a rule came from a recommendation
+func (c *Client) UnregisterRecommendationRule(ruleID string) {
+	c.recommendationMu.Lock()
+	defer c.recommendationMu.Unlock()
+	delete(c.recommendationMetrics, ruleID)
+}
+
+// drain sends a partial batch and the metrics left in the queue
+func (c *Client) drain(batch []*models.AggregatedMetric) {
+	for {
+		select {
+		case metric := <-c.queue:
+			batch = append(batch, metric)
+			if len(batch) >= c.cfg.BatchSize {
+				c.sendBatch(batch)
+				batch = make([]*models.AggregatedMetric, 0, c.cfg.BatchSize)
+			}
+		default:
+			if len(batch) > 0 {
+				c.sendBatch(batch)
+			}
+			return
+		}
+	}
+}
+
 // worker processes the queue and sends metrics to remote endpoints
 func (c *Client) worker() {
 	defer c.wg.Done()
@@ -127,10 +226,9 @@ func (c *Client) worker() {
 	for {
 		select {
 		case <-c.done:
-			// Flush any remaining metrics before exiting
-			if len(batch) > 0 {
-				c.sendBatch(batch)
-			}
+			// Flush any remaining metrics, including those still queued,
+			// before exiting
+			c.drain(batch)
 			return
 		case metric := <-c.queue:
 			batch = append(batch, metric)
@@ -140,6 +238,7 @@ func (c *Client) worker() {
 				batch = make([]*models.AggregatedMetric, 0, c.cfg.BatchSize)
 			}
 		case <-ticker.C:
+			pkgmetrics.UpdateQueue(pkgmetrics.QueueRemoteWrite, len(c.queue), cap(c.queue))
 			// Send periodically even if batch is not full
 			if len(batch) > 0 {
 				c.sendBatch(batch)
@@ -149,9 +248,23 @@ func (c *Client) worker() {
 	}
 }
 
-// sendBatch sends a batch of metrics to all configured remote write endpoints
+// sendBatch sends a batch of metrics to their remote write endpoints
 func (c *Client) sendBatch(metrics []*models.AggregatedMetric) {
-	if len(metrics) == 0 {
+	for _, group := range groupByTarget(metrics) {
+		c.sendGroup(group)
+	}
+}
+
+// sendGroup sends metrics sharing a remote write target to its endpoints
+func (c *Client) sendGroup(metrics []*models.AggregatedMetric) {
+	route, err := c.route(metrics[0].Target)
+	if err != nil {
+		logger.LogErrorWithFields("Failed to route remote write batch, dropping it", logger.Fields{
+			"rule_id": metrics[0].SourceRule,
+			"metrics": len(metrics),
+			"error":   err.Error(),
+		})
+		pkgmetrics.RecordDroppedSamples(pkgmetrics.StageRemoteWrite, "unroutable", len(metrics))
 		return
 	}
 
@@ -161,35 +274,193 @@ func (c *Client) sendBatch(metrics []*models.AggregatedMetric) {
 	// Serialize and compress
 	data, err := proto.Marshal(req)
 	if err != nil {
-		fmt.Printf("Error marshaling write request: %v\n", err)
+		logger.LogErrorWithFields("Failed to marshal remote write request", logger.Fields{
+			"metrics": len(metrics),
+			"error":   err.Error(),
+		})
+		pkgmetrics.RecordDroppedSamples(pkgmetrics.StageRemoteWrite, "marshal_error", len(metrics))
 		return
 	}
 
-	compressed := snappy.Encode(nil, data)
+	body := newPayload(data)
 
-	// Send to all endpoints
-	for _, endpoint := range c.endpoints {
-		for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
-			if err := c.sendToEndpoint(endpoint, compressed); err != nil {
-				fmt.Printf("Error sending to endpoint %s (attempt %d/%d): %v\n", 
-					endpoint, attempt+1, c.cfg.MaxRetries+1, err)
-				
-				if attempt < c.cfg.MaxRetries {
-					// Wait before retrying
-					time.Sleep(time.Duration(c.cfg.RetryInterval) * time.Second)
-					continue
-				}
-			} else {
-				// Success
+	// Trace batches containing traced metrics, linked to the flushes that produced them
+	ctx := context.Background()
+	var spanContexts []trace.SpanContext
+	for _, metric := range metrics {
+		if metric.SpanContext.IsSampled() {
+			spanContexts = append(spanContexts, metric.SpanContext)
+		}
+	}
+	if len(spanContexts) > 0 {
+		var span trace.Span
+		ctx, span = tracing.Tracer().Start(trace.ContextWithSpanContext(ctx, spanContexts[0]), "remote_write.send",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithLinks(tracing.Links(spanContexts[1:])...),
+			trace.WithAttributes(
+				attribute.Int("batch.size", len(metrics)),
+				attribute.Int("batch.bytes", len(data)),
+			))
+		defer span.End()
+	}
+
+	// Send to the endpoints of the route concurrently, so that retries of a
+	// failing endpoint do not hold back the others
+	var wg sync.WaitGroup
+	for _, endpoint := range route.endpoints {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			c.sendWithRetries(ctx, endpoint, route.headers, body, len(metrics))
+		}(endpoint)
+	}
+	wg.Wait()
+}
+
+// sendWithRetries sends a batch of series to an endpoint, retrying failed
+// requests while its circuit allows, and drops the batch once retries are
+// exhausted or the circuit is open
+func (c *Client) sendWithRetries(ctx context.Context, endpoint string, headers map[string]string, body *payload, series int) {
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if !c.breaker(endpoint).allow(time.Now()) {
+			circuitOpenLog.Log(logger.Fields{
+				"endpoint": endpoint,
+				"metrics":  series,
+			})
+			pkgmetrics.RecordDroppedSamples(pkgmetrics.StageRemoteWrite, "circuit_open", series)
+			return
+		}
+
+		err := c.sendToEndpoint(ctx, endpoint, headers, body)
+		c.breaker(endpoint).record(err, time.Now())
+		if err == nil {
+			pkgmetrics.RecordRemoteWriteSamples(endpoint, series)
+			return
+		}
+		trace.SpanFromContext(ctx).AddEvent("send failed", trace.WithAttributes(
+			attribute.String("endpoint", endpoint),
+			attribute.Int("attempt", attempt+1),
+			attribute.String("error", err.Error()),
+		))
+
+		if attempt < c.cfg.MaxRetries {
+			logger.LogWarnWithFields("Failed to send to remote write endpoint, retrying", logger.Fields{
+				"endpoint":     endpoint,
+				"attempt":      attempt + 1,
+				"max_attempts": c.cfg.MaxRetries + 1,
+				"error":        err.Error(),
+			})
+			// Wait before retrying
+			time.Sleep(time.Duration(c.cfg.RetryInterval) * time.Second)
+			continue
+		}
+
+		trace.SpanFromContext(ctx).SetStatus(codes.Error, "failed to send batch to "+endpoint)
+		logger.LogErrorWithFields("Failed to send to remote write endpoint, dropping batch", logger.Fields{
+			"endpoint": endpoint,
+			"attempts": c.cfg.MaxRetries + 1,
+			"metrics":  series,
+			"error":    err.Error(),
+		})
+		pkgmetrics.RecordDroppedSamples(pkgmetrics.StageRemoteWrite, "send_failed", series)
+	}
+}
+
+// breaker returns the circuit breaker of an endpoint. Routes only resolve to
+// configured endpoints, which all have one.
+func (c *Client) breaker(endpoint string) *breaker {
+	return c.breakers[endpoint]
+}
+
+// Send writes a batch of metrics to their endpoints synchronously, retrying
+// failed requests, and returns the first error. Unlike Write it does not
+// queue or drop metrics, and applies to metrics of any rule.
+func (c *Client) Send(ctx context.Context, metrics []*models.AggregatedMetric) error {
+	for _, group := range groupByTarget(metrics) {
+		if err := c.sendSync(ctx, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendSync writes metrics sharing a remote write target to its endpoints
+func (c *Client) sendSync(ctx context.Context, metrics []*models.AggregatedMetric) error {
+	route, err := c.route(metrics[0].Target)
+	if err != nil {
+		return err
+	}
+
+	data, err := proto.Marshal(c.buildWriteRequest(metrics))
+	if err != nil {
+		return fmt.Errorf("failed to marshal remote write request: %w", err)
+	}
+	body := newPayload(data)
+
+	for _, endpoint := range route.endpoints {
+		for attempt := 0; ; attempt++ {
+			if !c.breaker(endpoint).allow(time.Now()) {
+				return fmt.Errorf("failed to send to %s: circuit is open", endpoint)
+			}
+			err = c.sendToEndpoint(ctx, endpoint, route.headers, body)
+			c.breaker(endpoint).record(err, time.Now())
+			if err == nil {
+				pkgmetrics.RecordRemoteWriteSamples(endpoint, len(metrics))
 				break
 			}
+			if attempt >= c.cfg.MaxRetries {
+				return fmt.Errorf("failed to send to %s: %w", endpoint, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(c.cfg.RetryInterval) * time.Second):
+			}
 		}
 	}
+
+	return nil
+}
+
+// sendToEndpoint sends a write request to a specific endpoint, compressed
+// with the endpoint's codec. An endpoint rejecting zstd with 415 Unsupported
+// Media Type is switched to snappy and the request sent again.
+func (c *Client) sendToEndpoint(ctx context.Context, endpoint string, headers map[string]string, body *payload) error {
+	codec := c.endpointCodec(endpoint)
+	err := c.post(ctx, endpoint, codec, headers, body)
+	if !errors.Is(err, errUnsupportedEncoding) || codec == CompressionSnappy {
+		return err
+	}
+
+	logger.LogWarnWithFields("Remote write endpoint does not support the compression, falling back to snappy", logger.Fields{
+		"endpoint":    endpoint,
+		"compression": codec,
+	})
+	c.compressionMu.Lock()
+	c.compression[endpoint] = CompressionSnappy
+	c.compressionMu.Unlock()
+	return c.post(ctx, endpoint, CompressionSnappy, headers, body)
 }
 
-// sendToEndpoint sends compressed data to a specific endpoint
-func (c *Client) sendToEndpoint(endpoint string, data []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.cfg.Timeout)*time.Second)
+// endpointCodec returns the compression of an endpoint
+func (c *Client) endpointCodec(endpoint string) string {
+	c.compressionMu.RLock()
+	defer c.compressionMu.RUnlock()
+	if codec, exists := c.compression[endpoint]; exists {
+		return codec
+	}
+	return CompressionSnappy
+}
+
+// post sends a write request compressed with a codec to an endpoint
+func (c *Client) post(ctx context.Context, endpoint, codec string, headers map[string]string, body *payload) error {
+	data, err := body.encode(codec)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.cfg.Timeout)*time.Second)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
@@ -198,11 +469,12 @@ func (c *Client) sendToEndpoint(endpoint string, data []byte) error {
 	}
 
 	req.Header.Set("Content-Type", "application/x-protobuf")
-	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Encoding", codec)
 	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	// Add custom headers
-	for k, v := range c.headers {
+	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
 
@@ -213,10 +485,15 @@ func (c *Client) sendToEndpoint(endpoint string, data []byte) error {
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		pkgmetrics.RecordRemoteWriteRequest(endpoint, false)
 		return err
 	}
 	defer resp.Body.Close()
+	pkgmetrics.RecordRemoteWriteRequest(endpoint, resp.StatusCode/100 == 2)
 
+	if resp.StatusCode == http.StatusUnsupportedMediaType && codec != CompressionSnappy {
+		return errUnsupportedEncoding
+	}
 	if resp.StatusCode/100 != 2 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("non-200 status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
@@ -225,11 +502,97 @@ func (c *Client) sendToEndpoint(endpoint string, data []byte) error {
 	return nil
 }
 
+// endpointCompression returns the configured compression of each endpoint
+func endpointCompression(cfg *config.RemoteWriteConfig) (map[string]string, error) {
+	codec := cfg.Compression
+	if codec == "" {
+		codec = CompressionSnappy
+	}
+	if !ValidCompression(codec) {
+		return nil, fmt.Errorf("unsupported remote write compression %q", codec)
+	}
+
+	compression := make(map[string]string, len(cfg.Endpoints))
+	for _, endpoint := range cfg.Endpoints {
+		compression[endpoint] = codec
+	}
+	for _, options := range cfg.EndpointOptions {
+		if options.URL == "" {
+			return nil, fmt.Errorf("remote write endpoint options %q have no url", options.Name)
+		}
+		if options.Compression == "" {
+			compression[options.URL] = codec
+			continue
+		}
+		if !ValidCompression(options.Compression) {
+			return nil, fmt.Errorf("unsupported remote write compression %q for %s", options.Compression, options.URL)
+		}
+		compression[options.URL] = options.Compression
+	}
+	return compression, nil
+}
+
+// route is where metrics are sent, and the headers of their requests
+type route struct {
+	endpoints []string
+	headers   map[string]string
+}
+
+// route resolves the remote write target of a rule. Metrics without a target
+// go to the default endpoints.
+func (c *Client) route(target *models.RemoteWriteTarget) (route, error) {
+	if target == nil {
+		return route{endpoints: c.endpoints, headers: c.headers}, nil
+	}
+
+	r := route{endpoints: c.endpoints, headers: make(map[string]string, len(c.headers)+len(target.Headers)+1)}
+	if target.Endpoint != "" {
+		url, exists := c.cfg.EndpointURL(target.Endpoint)
+		if !exists {
+			return route{}, fmt.Errorf("unknown remote write endpoint %q", target.Endpoint)
+		}
+		r.endpoints = []string{url}
+	}
+
+	for k, v := range c.headers {
+		r.headers[k] = v
+	}
+	if target.Tenant != "" {
+		header := c.cfg.TenantHeader
+		if header == "" {
+			header = "X-Scope-OrgID"
+		}
+		r.headers[header] = target.Tenant
+	}
+	for k, v := range target.Headers {
+		r.headers[k] = v
+	}
+	return r, nil
+}
+
+// groupByTarget splits a batch into the metrics of each remote write target,
+// in order of first appearance
+func groupByTarget(metrics []*models.AggregatedMetric) [][]*models.AggregatedMetric {
+	var groups [][]*models.AggregatedMetric
+	index := make(map[*models.RemoteWriteTarget]int)
+	for _, metric := range metrics {
+		i, exists := index[metric.Target]
+		if !exists {
+			i = len(groups)
+			index[metric.Target] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], metric)
+	}
+	return groups
+}
+
 // buildWriteRequest converts aggregated metrics to a Prometheus write request
 func (c *Client) buildWriteRequest(metrics []*models.AggregatedMetric) *prompb.WriteRequest {
 	request := &prompb.WriteRequest{
 		Timeseries: make([]prompb.TimeSeries, 0, len(metrics)),
 	}
+	var described map[string]bool
 
 	for _, metric := range metrics {
 		// Create labels including the metric name
@@ -252,7 +615,7 @@ func (c *Client) buildWriteRequest(metrics []*models.AggregatedMetric) *prompb.W
 		// Create a sample
 		sample := prompb.Sample{
 			Value:     metric.Value,
-			Timestamp: metric.EndTime.UnixNano() / int64(time.Millisecond),
+			Timestamp: metric.SampleTime().UnixNano() / int64(time.Millisecond),
 		}
 
 		// Add to timeseries
@@ -262,6 +625,19 @@ func (c *Client) buildWriteRequest(metrics []*models.AggregatedMetric) *prompb.W
 		}
 
 		request.Timeseries = append(request.Timeseries, ts)
+
+		// Describe aggregated gauges so query authors know how to interpret them
+		if metric.Semantics != "" && !described[metric.Name] {
+			if described == nil {
+				described = make(map[string]bool)
+			}
+			described[metric.Name] = true
+			request.Metadata = append(request.Metadata, prompb.MetricMetadata{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: metric.Name,
+				Help:             models.GaugeSemanticsHelp(metric.Semantics, metric.EndTime.Sub(metric.StartTime)),
+			})
+		}
 	}
 
 	return request
@@ -0,0 +1,185 @@
+package remote
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/marcotuna/adaptive-metrics/internal/config"
+	"github.com/marcotuna/adaptive-metrics/internal/models"
+	pkgmetrics "github.com/marcotuna/adaptive-metrics/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestClient_Send_Compression(t *testing.T) {
+	var mu sync.Mutex
+	var encodings []string
+	handler := func(supportsZstd bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			encoding := r.Header.Get("Content-Encoding")
+			mu.Lock()
+			encodings = append(encodings, encoding)
+			mu.Unlock()
+
+			body, _ := io.ReadAll(r.Body)
+			var data []byte
+			var err error
+			switch {
+			case encoding == CompressionSnappy:
+				data, err = snappy.Decode(nil, body)
+			case encoding == CompressionZstd && supportsZstd:
+				var decoder *zstd.Decoder
+				decoder, _ = zstd.NewReader(nil)
+				data, err = decoder.DecodeAll(body, nil)
+			default:
+				http.Error(w, "unsupported encoding", http.StatusUnsupportedMediaType)
+				return
+			}
+			var req prompb.WriteRequest
+			if err != nil || proto.Unmarshal(data, &req) != nil || len(req.Timeseries) != 1 {
+				http.Error(w, "bad request", http.StatusBadRequest)
+			}
+		}
+	}
+	zstdServer := httptest.NewServer(handler(true))
+	defer zstdServer.Close()
+	legacyServer := httptest.NewServer(handler(false))
+	defer legacyServer.Close()
+
+	client, err := NewClient(&config.RemoteWriteConfig{
+		Enabled:     true,
+		Endpoints:   []string{zstdServer.URL, legacyServer.URL},
+		Compression: CompressionZstd,
+		BatchSize:   10,
+		Timeout:     5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric := &models.AggregatedMetric{Name: "requests_aggregated", Value: 1}
+	for i := 0; i < 2; i++ {
+		if err := client.Send(context.Background(), []*models.AggregatedMetric{metric}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	// The legacy endpoint rejects zstd once and gets snappy from then on
+	want := []string{"zstd", "zstd", "snappy", "zstd", "snappy"}
+	if len(encodings) != len(want) {
+		t.Fatalf("encodings = %v, want %v", encodings, want)
+	}
+	for i := range want {
+		if encodings[i] != want[i] {
+			t.Fatalf("encodings = %v, want %v", encodings, want)
+		}
+	}
+}
+
+func TestNewClient_InvalidCompression(t *testing.T) {
+	_, err := NewClient(&config.RemoteWriteConfig{
+		Enabled:         true,
+		Endpoints:       []string{"http://localhost:9090/api/v1/write"},
+		EndpointOptions: []config.RemoteWriteEndpointOptions{{URL: "http://localhost:9090/api/v1/write", Compression: "gzip"}},
+	})
+	if err == nil {
+		t.Error("expected an unsupported compression to be rejected")
+	}
+}
+
+func TestClient_Send_RemoteWriteTarget(t *testing.T) {
+	type request struct {
+		server, tenant, team string
+		series               int
+	}
+	var mu sync.Mutex
+	var requests []request
+	handler := func(server string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			data, _ := snappy.Decode(nil, body)
+			var req prompb.WriteRequest
+			proto.Unmarshal(data, &req)
+			mu.Lock()
+			requests = append(requests, request{server, r.Header.Get("X-Scope-OrgID"), r.Header.Get("X-Team"), len(req.Timeseries)})
+			mu.Unlock()
+		}
+	}
+	defaultServer := httptest.NewServer(handler("default"))
+	defer defaultServer.Close()
+	archiveServer := httptest.NewServer(handler("archive"))
+	defer archiveServer.Close()
+
+	client, err := NewClient(&config.RemoteWriteConfig{
+		Enabled:         true,
+		Endpoints:       []string{defaultServer.URL},
+		EndpointOptions: []config.RemoteWriteEndpointOptions{{Name: "archive", URL: archiveServer.URL}},
+		Headers:         map[string]string{"X-Team": "platform"},
+		TenantHeader:    "X-Scope-OrgID",
+		Timeout:         5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &models.RemoteWriteTarget{Endpoint: "archive", Tenant: "long-retention", Headers: map[string]string{"X-Team": "billing"}}
+	metrics := []*models.AggregatedMetric{
+		{Name: "a", Value: 1},
+		{Name: "b", Value: 1, Target: archive},
+		{Name: "c", Value: 1},
+		{Name: "d", Value: 1, Target: archive},
+	}
+	if err := client.Send(context.Background(), metrics); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	want := []request{
+		{"default", "", "platform", 2},
+		{"archive", "long-retention", "billing", 2},
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("requests = %v, want %v", requests, want)
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Fatalf("requests = %v, want %v", requests, want)
+		}
+	}
+
+	if got := testutil.ToFloat64(pkgmetrics.RemoteWriteSamplesCounter.WithLabelValues(archiveServer.URL)); got != 2 {
+		t.Errorf("series delivered to the archive endpoint = %v, want 2", got)
+	}
+
+	// Metrics routed to an unknown endpoint are not sent anywhere
+	unknown := []*models.AggregatedMetric{{Name: "e", Value: 1, Target: &models.RemoteWriteTarget{Endpoint: "missing"}}}
+	if err := client.Send(context.Background(), unknown); err == nil {
+		t.Error("expected an unknown endpoint to be rejected")
+	}
+}
+
+func TestClient_BuildWriteRequest_GaugeMetadata(t *testing.T) {
+	client := &Client{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	req := client.buildWriteRequest([]*models.AggregatedMetric{
+		{Name: "memory_bytes:max", Value: 1, StartTime: start, EndTime: start.Add(time.Minute), Semantics: models.GaugeSemanticsMax},
+		{Name: "memory_bytes:max", Value: 2, StartTime: start, EndTime: start.Add(time.Minute), Semantics: models.GaugeSemanticsMax},
+		{Name: "requests:sum", Value: 3},
+	})
+
+	if len(req.Metadata) != 1 {
+		t.Fatalf("Metadata = %+v, want one family", req.Metadata)
+	}
+	md := req.Metadata[0]
+	if md.MetricFamilyName != "memory_bytes:max" || md.Type != prompb.MetricMetadata_GAUGE || !strings.Contains(md.Help, "max_over_time") {
+		t.Errorf("Metadata = %+v, want a gauge referring to max_over_time", md)
+	}
+}
@@ -0,0 +1,68 @@
+package remote
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs of remote write requests, sent as the Content-Encoding
+const (
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+// zstdEncoder is shared by all clients; EncodeAll is safe for concurrent use
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+})
+
+// ValidCompression reports whether a codec is supported
+func ValidCompression(codec string) bool {
+	return codec == CompressionSnappy || codec == CompressionZstd
+}
+
+// compress encodes a serialized write request with a codec
+func compress(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case CompressionSnappy, "":
+		return snappy.Encode(nil, data), nil
+	case CompressionZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", codec)
+	}
+}
+
+// payload is a serialized write request compressed on demand with the codecs
+// of the endpoints it is sent to
+type payload struct {
+	data       []byte
+	compressed map[string][]byte
+	mu         sync.Mutex
+}
+
+func newPayload(data []byte) *payload {
+	return &payload{data: data, compressed: make(map[string][]byte)}
+}
+
+// encode returns the request compressed with a codec
+func (p *payload) encode(codec string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if compressed, exists := p.compressed[codec]; exists {
+		return compressed, nil
+	}
+	compressed, err := compress(codec, p.data)
+	if err != nil {
+		return nil, err
+	}
+	p.compressed[codec] = compressed
+	return compressed, nil
+}
@@ -0,0 +1,44 @@
+// Package transform is the interface of transform plugins: Go plugins that
+// change samples before they are aggregated and aggregated series before
+// they are written, such as to enrich series with labels from an inventory.
+//
+// A transform plugin is a main package built with -buildmode=plugin against
+// the same version of this module, exporting any of:
+//
+//	func Init(params map[string]string) error
+//	func TransformSample(sample *transform.Sample) bool
+//	func TransformOutput(output *transform.Output) bool
+//
+// Init is called once when the plugin is loaded, with the params of its
+// configuration. The transform functions may change what they are given, and
+// return false to drop it. They are called concurrently and must be safe for
+// concurrent use.
+package transform
+
+import "time"
+
+// Names of the symbols looked up in transform plugins
+const (
+	InitSymbol   = "Init"
+	SampleSymbol = "TransformSample"
+	OutputSymbol = "TransformOutput"
+)
+
+// Sample is an ingested sample, before it is matched against rules
+type Sample struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp time.Time
+}
+
+// Output is an aggregated series, before it is written to remote write and
+// subscribers
+type Output struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp time.Time
+	// Rule is the ID of the rule that produced the series; read only
+	Rule string
+}
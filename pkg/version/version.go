@@ -0,0 +1,67 @@
+// Package version holds build information, set at link time with
+// -ldflags "-X github.com/marcotuna/adaptive-metrics/pkg/version.Version=..."
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information set at link time
+var (
+	Version   = "dev"
+	Revision  = ""
+	Branch    = ""
+	BuildUser = ""
+	BuildDate = ""
+)
+
+// Info is the build information of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	Branch    string `json:"branch"`
+	BuildUser string `json:"buildUser"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information. Without ldflags, the version falls back
+// to the module version of binaries built with go install, and the revision
+// and build date to the VCS information recorded by the Go toolchain.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Revision:  Revision,
+		Branch:    Branch,
+		BuildUser: BuildUser,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "dev" && buildInfo.Main.Version != "" && buildInfo.Main.Version != "(devel)" {
+			info.Version = buildInfo.Main.Version
+		}
+		modified := false
+		for _, setting := range buildInfo.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Revision == "" {
+					info.Revision = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && Revision == "" && info.Revision != "" {
+			info.Revision += "-dirty"
+		}
+	}
+
+	return info
+}
@@ -11,6 +11,11 @@ interface Rule {
   createdAt: string;
   updatedAt: string;
   active: boolean;
+  dry_statistics?: {
+    samples: number;
+    series: number;
+    series_limited?: boolean;
+  };
 }
 
 interface RulesListProps {
@@ -141,6 +146,11 @@ const RulesList: React.FC<RulesListProps> = ({ onRuleSelect, onRefreshRequest })
                     <span className={`inline-flex px-2 py-1 text-xs font-semibold rounded-full ${rule.active ? 'bg-green-100 text-green-800' : 'bg-gray-100 text-gray-800'}`}>
                       {rule.active ? 'Active' : 'Inactive'}
                     </span>
+                    {rule.dry_statistics && (
+                      <div className="text-xs text-gray-500 mt-1">
+                        Would match {rule.dry_statistics.samples} samples of {rule.dry_statistics.series}{rule.dry_statistics.series_limited ? '+' : ''} series in the last hour
+                      </div>
+                    )}
                   </td>
                   <td className="px-4 py-3 whitespace-nowrap text-right text-sm font-medium">
                     <Button
@@ -0,0 +1,28 @@
+//go:build !noui
+
+// Package web embeds the built web UI so the server can be distributed as a
+// single binary. Build with the noui tag to leave the UI out.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// build holds the output of the UI build. It only contains a placeholder
+// until the UI has been built with make ui.
+//
+//go:embed all:build
+var build embed.FS
+
+// UI returns the embedded web UI, or nil if it was not built before compiling
+func UI() fs.FS {
+	ui, err := fs.Sub(build, "build")
+	if err != nil {
+		return nil
+	}
+	if _, err := fs.Stat(ui, "index.html"); err != nil {
+		return nil
+	}
+	return ui
+}
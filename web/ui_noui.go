@@ -0,0 +1,10 @@
+//go:build noui
+
+package web
+
+import "io/fs"
+
+// UI returns nil: the web UI is not embedded in noui builds
+func UI() fs.FS {
+	return nil
+}